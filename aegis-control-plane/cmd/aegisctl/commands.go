@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/pkg/aegisclient"
+)
+
+// jobPollInterval is how often jobs run --wait checks on the run.
+var jobPollInterval = 2 * time.Second
+
+type cli struct {
+	client *aegisclient.Client
+	json   bool
+	in     io.Reader
+	out    io.Writer
+	errOut io.Writer
+}
+
+func (c *cli) dispatch(ctx context.Context, group, cmd string, args []string) error {
+	switch group + " " + cmd {
+	case "sessions list":
+		return c.sessionsList(ctx, args)
+	case "sessions get":
+		return c.sessionsGet(ctx, args)
+	case "sessions stop":
+		return c.sessionsStop(ctx, args)
+	case "usage show":
+		return c.usageShow(ctx, args)
+	case "manifest get":
+		return c.manifestGet(ctx, args)
+	case "manifest set":
+		return c.manifestSet(ctx, args)
+	case "manifest enable":
+		return c.manifestSwitch(ctx, "manifest enable", args, true)
+	case "manifest disable":
+		return c.manifestSwitch(ctx, "manifest disable", args, false)
+	case "jobs run":
+		return c.jobsRun(ctx, args)
+	case "health latest":
+		return c.healthLatest(ctx, args)
+	}
+	fmt.Fprintf(c.errOut, "aegisctl: unknown command %q\n\n%s", group+" "+cmd, usage)
+	return errUsage
+}
+
+// flags parses a subcommand's flags and checks it got want positional
+// arguments.
+func (c *cli) flags(name string, args []string, want int, define func(*flag.FlagSet)) (*flag.FlagSet, error) {
+	fs := flag.NewFlagSet("aegisctl "+name, flag.ContinueOnError)
+	fs.SetOutput(c.errOut)
+	if define != nil {
+		define(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	if fs.NArg() != want {
+		fmt.Fprintf(c.errOut, "aegisctl %s: expected %d argument(s), got %d\n", name, want, fs.NArg())
+		return nil, errUsage
+	}
+	return fs, nil
+}
+
+func (c *cli) sessionsList(ctx context.Context, args []string) error {
+	var status string
+	var limit int
+	if _, err := c.flags("sessions list", args, 0, func(fs *flag.FlagSet) {
+		fs.StringVar(&status, "status", "", "only sessions in this status")
+		fs.IntVar(&limit, "limit", 50, "maximum sessions to list")
+	}); err != nil {
+		return err
+	}
+	sessions, err := c.client.ListSessions(ctx, status, limit)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(sessions)
+	}
+	tw := c.table("SESSION", "USER", "STATUS", "REGION", "INSTANCE", "PUBLIC IP", "STARTED", "DURATION")
+	for _, s := range sessions {
+		row(tw, s.ID, s.UserID, s.Status, s.Region, s.InstanceID, s.PublicIP, formatTime(&s.StartedAt), (time.Duration(s.DurationSeconds) * time.Second).String())
+	}
+	return tw.Flush()
+}
+
+func (c *cli) sessionsGet(ctx context.Context, args []string) error {
+	fs, err := c.flags("sessions get", args, 1, nil)
+	if err != nil {
+		return err
+	}
+	st, err := c.client.SessionRelay(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(st)
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	row(tw, "session", st.SessionID)
+	row(tw, "user", st.UserID)
+	row(tw, "status", st.SessionStatus)
+	if r := st.Relay; r != nil {
+		row(tw, "relay", r.RelayInstanceID)
+		row(tw, "provider", r.Provider)
+		row(tw, "region", r.Region)
+		row(tw, "instance", r.InstanceID)
+		row(tw, "state", r.State)
+		row(tw, "public ip", r.PublicIP)
+		row(tw, "launched", formatTime(r.LaunchedAt))
+		row(tw, "last health", formatTime(r.LastHealthAt))
+		row(tw, "terminated", formatTime(r.TerminatedAt))
+	}
+	if p := st.Provider; p != nil {
+		row(tw, "provider state", p.State)
+		row(tw, "provider public ip", p.PublicIP)
+	}
+	if st.ProviderError != "" {
+		row(tw, "provider error", st.ProviderError)
+	}
+	return tw.Flush()
+}
+
+func (c *cli) sessionsStop(ctx context.Context, args []string) error {
+	var yes bool
+	fs, err := c.flags("sessions stop", args, 1, func(fs *flag.FlagSet) {
+		fs.BoolVar(&yes, "yes", false, "stop without asking for confirmation")
+	})
+	if err != nil {
+		return err
+	}
+	id := fs.Arg(0)
+	if !yes {
+		ok, err := confirm(c.in, c.errOut, fmt.Sprintf("Stop session %s and terminate its relay?", id))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintln(c.errOut, "aborted")
+			return nil
+		}
+	}
+	res, err := c.client.AdminStopSession(ctx, id)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(res)
+	}
+	fmt.Fprintf(c.out, "session %s %s\n", res.SessionID, res.Status)
+	return nil
+}
+
+// confirm asks a yes/no question on in; anything but y or yes is no.
+func confirm(in io.Reader, prompt io.Writer, question string) (bool, error) {
+	fmt.Fprintf(prompt, "%s [y/N] ", question)
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+func (c *cli) usageShow(ctx context.Context, args []string) error {
+	var userID string
+	if _, err := c.flags("usage show", args, 0, func(fs *flag.FlagSet) {
+		fs.StringVar(&userID, "user", "", "show this user's usage (admin); default is the token's user")
+	}); err != nil {
+		return err
+	}
+	var u aegisclient.Usage
+	var err error
+	if userID == "" {
+		u, err = c.client.Usage(ctx)
+	} else {
+		u, err = c.client.UserUsage(ctx, userID)
+	}
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(u)
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	row(tw, "plan", u.PlanTier)
+	row(tw, "cycle", formatTime(&u.CycleStart)+" - "+formatTime(&u.CycleEnd))
+	row(tw, "included", strconv.Itoa(u.IncludedSeconds)+"s")
+	row(tw, "consumed", strconv.Itoa(u.ConsumedSeconds)+"s")
+	row(tw, "remaining", strconv.Itoa(u.RemainingSeconds)+"s")
+	row(tw, "overage", strconv.Itoa(u.OverageSeconds)+"s")
+	for _, a := range u.Alerts {
+		row(tw, "alert", fmt.Sprintf("%s at %d%%", a.Level, a.ThresholdPercent))
+	}
+	return tw.Flush()
+}
+
+func (c *cli) manifestGet(ctx context.Context, args []string) error {
+	if _, err := c.flags("manifest get", args, 0, nil); err != nil {
+		return err
+	}
+	regions, err := c.client.Manifest(ctx)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(regions)
+	}
+	tw := c.table("REGION", "PROVIDER", "ENABLED", "AVAILABLE", "AMI", "INSTANCE TYPE", "UPDATED")
+	for _, r := range regions {
+		row(tw, r.Region, r.Provider, strconv.FormatBool(r.Enabled), strconv.FormatBool(r.Available), r.AMIID, r.DefaultInstanceType, formatTime(&r.UpdatedAt))
+	}
+	return tw.Flush()
+}
+
+func (c *cli) manifestSet(ctx context.Context, args []string) error {
+	var available string
+	var u aegisclient.ManifestUpdate
+	fs, err := c.flags("manifest set", args, 1, func(fs *flag.FlagSet) {
+		fs.StringVar(&available, "available", "", "true or false")
+		fs.StringVar(&u.AMIID, "ami", "", "AMI ID")
+		fs.StringVar(&u.DefaultInstanceType, "instance-type", "", "default instance type")
+	})
+	if err != nil {
+		return err
+	}
+	if available != "" {
+		v, err := strconv.ParseBool(available)
+		if err != nil {
+			fmt.Fprintf(c.errOut, "aegisctl manifest set: --available must be true or false\n")
+			return errUsage
+		}
+		u.Available = &v
+	}
+	if u.Available == nil && u.AMIID == "" && u.DefaultInstanceType == "" {
+		fmt.Fprintf(c.errOut, "aegisctl manifest set: nothing to change; pass --available, --ami or --instance-type\n")
+		return errUsage
+	}
+	r, err := c.client.SetManifestRegion(ctx, fs.Arg(0), u)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(r)
+	}
+	tw := c.table("REGION", "PROVIDER", "ENABLED", "AVAILABLE", "AMI", "INSTANCE TYPE", "UPDATED")
+	row(tw, r.Region, r.Provider, strconv.FormatBool(r.Enabled), strconv.FormatBool(r.Available), r.AMIID, r.DefaultInstanceType, formatTime(&r.UpdatedAt))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.errOut, "note: the API restores the manifest from its config on restart or config reload")
+	return nil
+}
+
+// manifestSwitch turns new sessions in a region on or off; sessions already
+// there keep running.
+func (c *cli) manifestSwitch(ctx context.Context, name string, args []string, enabled bool) error {
+	fs, err := c.flags(name, args, 1, nil)
+	if err != nil {
+		return err
+	}
+	r, err := c.client.SwitchManifestRegion(ctx, fs.Arg(0), enabled)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(r)
+	}
+	tw := c.table("REGION", "PROVIDER", "ENABLED", "AVAILABLE", "AMI", "INSTANCE TYPE", "UPDATED")
+	row(tw, r.Region, r.Provider, strconv.FormatBool(r.Enabled), strconv.FormatBool(r.Available), r.AMIID, r.DefaultInstanceType, formatTime(&r.UpdatedAt))
+	return tw.Flush()
+}
+
+func (c *cli) jobsRun(ctx context.Context, args []string) error {
+	var wait bool
+	fs, err := c.flags("jobs run", args, 1, func(fs *flag.FlagSet) {
+		fs.BoolVar(&wait, "wait", false, "wait for the run to finish")
+	})
+	if err != nil {
+		return err
+	}
+	run, err := c.client.RunJob(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	for wait && !run.Done() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(jobPollInterval):
+		}
+		if run, err = c.client.JobRun(ctx, run.ID); err != nil {
+			return err
+		}
+	}
+	if c.json {
+		if err := c.printJSON(run); err != nil {
+			return err
+		}
+	} else {
+		tw := c.table("RUN", "JOB", "STATUS", "REQUESTED", "FINISHED", "ERROR")
+		row(tw, strconv.FormatInt(run.ID, 10), run.Job, run.Status, formatTime(&run.RequestedAt), formatTime(run.FinishedAt), run.Error)
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	if run.Status == aegisclient.JobRunFailed {
+		return fmt.Errorf("job %s failed: %s", run.Job, run.Error)
+	}
+	return nil
+}
+
+func (c *cli) healthLatest(ctx context.Context, args []string) error {
+	var limit int
+	fs, err := c.flags("health latest", args, 1, func(fs *flag.FlagSet) {
+		fs.IntVar(&limit, "limit", 10, "maximum heartbeats to show")
+	})
+	if err != nil {
+		return err
+	}
+	events, err := c.client.RelayHealth(ctx, fs.Arg(0), limit)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(events)
+	}
+	tw := c.table("OBSERVED", "RELAY", "INGEST", "EGRESS", "UPTIME")
+	for _, e := range events {
+		row(tw, formatTime(&e.ObservedAt), e.RelayInstanceID, strconv.FormatBool(e.IngestActive), strconv.FormatBool(e.EgressActive), (time.Duration(e.SessionUptimeSeconds) * time.Second).String())
+	}
+	return tw.Flush()
+}
+
+func (c *cli) printJSON(v any) error {
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (c *cli) table(headers ...string) *tabwriter.Writer {
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	row(tw, headers...)
+	return tw
+}
+
+func row(tw *tabwriter.Writer, cols ...string) {
+	for i, col := range cols {
+		if col == "" {
+			cols[i] = "-"
+		}
+	}
+	fmt.Fprintln(tw, strings.Join(cols, "\t"))
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
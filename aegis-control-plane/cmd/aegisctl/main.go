@@ -0,0 +1,133 @@
+// Command aegisctl is the operators' command line for the Aegis control
+// plane. It talks to the API through pkg/aegisclient, so it ships and
+// versions with the API.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/telemyapp/aegis-control-plane/pkg/aegisclient"
+)
+
+const usage = `usage: aegisctl [--config FILE] [--api-url URL] [--json] <command> [args]
+
+commands:
+  sessions list [--status STATUS] [--limit N]
+  sessions get SESSION_ID
+  sessions stop [--yes] SESSION_ID
+  usage show [--user USER_ID]
+  manifest get
+  manifest set [--available true|false] [--ami AMI_ID] [--instance-type TYPE] REGION
+  manifest enable REGION
+  manifest disable REGION
+  jobs run [--wait] JOB
+  health latest [--limit N] SESSION_ID
+
+The API URL and token come from AEGIS_API_URL and AEGIS_TOKEN, or from the
+config file ({"api_url": "...", "token": "..."}); the environment wins.
+`
+
+// errUsage is a malformed command line; the usage text has been printed.
+var errUsage = errors.New("usage")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Getenv, os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes one command and returns the process exit code.
+func run(ctx context.Context, args []string, getenv func(string) string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("aegisctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { fmt.Fprint(stderr, usage) }
+	configPath := fs.String("config", "", "config file (default aegisctl/config.json in the user config directory)")
+	apiURL := fs.String("api-url", "", "API base URL, overriding AEGIS_API_URL and the config file")
+	jsonOut := fs.Bool("json", false, "print JSON instead of tables")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := loadConfig(*configPath, getenv)
+	if err != nil {
+		fmt.Fprintf(stderr, "aegisctl: %v\n", err)
+		return 1
+	}
+	if *apiURL != "" {
+		cfg.APIURL = *apiURL
+	}
+	if cfg.APIURL == "" {
+		fmt.Fprintln(stderr, "aegisctl: no API URL; set AEGIS_API_URL or api_url in the config file")
+		return 1
+	}
+
+	c := &cli{
+		client: aegisclient.New(cfg.APIURL, aegisclient.WithToken(cfg.Token)),
+		json:   *jsonOut,
+		in:     stdin,
+		out:    stdout,
+		errOut: stderr,
+	}
+	err = c.dispatch(ctx, fs.Arg(0), fs.Arg(1), fs.Args()[2:])
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	default:
+		fmt.Fprintf(stderr, "aegisctl: %v\n", err)
+		return 1
+	}
+}
+
+type config struct {
+	APIURL string `json:"api_url"`
+	Token  string `json:"token"`
+}
+
+// loadConfig reads the config file, then applies the environment. The
+// default file may be missing; an explicit one may not.
+func loadConfig(path string, getenv func(string) string) (config, error) {
+	var cfg config
+	explicit := path != ""
+	if !explicit {
+		dir, err := os.UserConfigDir()
+		if err == nil {
+			path = filepath.Join(dir, "aegisctl", "config.json")
+		}
+	}
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(raw, &cfg); err != nil {
+				return config{}, fmt.Errorf("parse config %s: %w", path, err)
+			}
+		case explicit || !errors.Is(err, os.ErrNotExist):
+			return config{}, fmt.Errorf("read config: %w", err)
+		}
+	}
+	if v := getenv("AEGIS_API_URL"); v != "" {
+		cfg.APIURL = v
+	}
+	if v := getenv("AEGIS_TOKEN"); v != "" {
+		cfg.Token = v
+	}
+	cfg.APIURL = strings.TrimSpace(cfg.APIURL)
+	cfg.Token = strings.TrimSpace(cfg.Token)
+	return cfg, nil
+}
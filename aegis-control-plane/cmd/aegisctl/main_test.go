@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(k string) string { return vars[k] }
+}
+
+func TestLoadConfig_EnvironmentOverridesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"api_url": "https://file.example", "token": "file-token"}`), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	cfg, err := loadConfig(path, env(map[string]string{"AEGIS_TOKEN": "env-token"}))
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.APIURL != "https://file.example" || cfg.Token != "env-token" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+
+	if _, err := loadConfig(filepath.Join(t.TempDir(), "missing.json"), env(nil)); err == nil {
+		t.Fatal("expected an error for a missing explicit config file")
+	}
+}
+
+// stopServer answers admin stops and counts them.
+func stopServer(t *testing.T, stops *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/admin/sessions/ses_1/stop" || r.Header.Get("Authorization") != "Bearer tok" {
+			http.Error(w, `{"error":{"code":"not_found","message":"unexpected request"}}`, http.StatusNotFound)
+			return
+		}
+		stops.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"session_id":"ses_1","status":"stopping","stopped_at":"2026-03-01T12:00:00Z"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSessionsStop_PromptsUnlessYes(t *testing.T) {
+	var stops atomic.Int32
+	srv := stopServer(t, &stops)
+	vars := env(map[string]string{"AEGIS_API_URL": srv.URL, "AEGIS_TOKEN": "tok"})
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	var out, errOut bytes.Buffer
+	if code := run(context.Background(), []string{"sessions", "stop", "ses_1"}, vars, strings.NewReader("n\n"), &out, &errOut); code != 0 {
+		t.Fatalf("expected exit 0 on a declined prompt, got %d: %s", code, errOut.String())
+	}
+	if stops.Load() != 0 || !strings.Contains(errOut.String(), "Stop session ses_1") {
+		t.Fatalf("expected a prompt and no stop, stops=%d stderr=%q", stops.Load(), errOut.String())
+	}
+
+	out.Reset()
+	if code := run(context.Background(), []string{"sessions", "stop", "ses_1"}, vars, strings.NewReader("yes\n"), &out, &errOut); code != 0 {
+		t.Fatalf("expected exit 0, got %d: %s", code, errOut.String())
+	}
+	if stops.Load() != 1 || out.String() != "session ses_1 stopping\n" {
+		t.Fatalf("expected a confirmed stop, stops=%d stdout=%q", stops.Load(), out.String())
+	}
+
+	out.Reset()
+	errOut.Reset()
+	if code := run(context.Background(), []string{"--json", "sessions", "stop", "--yes", "ses_1"}, vars, strings.NewReader(""), &out, &errOut); code != 0 {
+		t.Fatalf("expected exit 0, got %d: %s", code, errOut.String())
+	}
+	if stops.Load() != 2 || errOut.Len() != 0 || !strings.Contains(out.String(), `"status": "stopping"`) {
+		t.Fatalf("expected --yes to skip the prompt and print JSON, stops=%d stdout=%q stderr=%q", stops.Load(), out.String(), errOut.String())
+	}
+}
+
+func TestJobsRun_WaitsAndFailsWithTheRun(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	jobPollInterval = time.Millisecond
+	var polls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/admin/jobs/billing_export/runs":
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"run":{"run_id":7,"job":"billing_export","status":"pending","requested_at":"2026-03-01T12:00:00Z"}}`))
+		case r.URL.Path == "/api/v1/admin/jobs/runs/7":
+			status := `"running"`
+			if polls.Add(1) > 1 {
+				status = `"failed","error":"job not enabled on this worker"`
+			}
+			w.Write([]byte(`{"run":{"run_id":7,"job":"billing_export","status":` + status + `,"requested_at":"2026-03-01T12:00:00Z"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	var out, errOut bytes.Buffer
+	code := run(context.Background(), []string{"--api-url", srv.URL, "jobs", "run", "--wait", "billing_export"}, env(nil), strings.NewReader(""), &out, &errOut)
+	if code != 1 || polls.Load() != 2 {
+		t.Fatalf("expected exit 1 after polling to the failure, got %d polls=%d", code, polls.Load())
+	}
+	if !strings.Contains(out.String(), "failed") || !strings.Contains(errOut.String(), "job not enabled on this worker") {
+		t.Fatalf("expected the failed run reported, stdout=%q stderr=%q", out.String(), errOut.String())
+	}
+}
@@ -2,22 +2,26 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/jackc/pgx/v5/pgxpool"
-
 	"github.com/telemyapp/aegis-control-plane/internal/api"
+	"github.com/telemyapp/aegis-control-plane/internal/certs"
 	"github.com/telemyapp/aegis-control-plane/internal/config"
 	"github.com/telemyapp/aegis-control-plane/internal/model"
 	"github.com/telemyapp/aegis-control-plane/internal/relay"
 	"github.com/telemyapp/aegis-control-plane/internal/store"
+	"github.com/telemyapp/aegis-control-plane/internal/version"
 )
 
 func main() {
+	log.Printf("aegis-control-plane starting %s", version.String())
 	cfg, err := config.LoadFromEnv()
 	if err != nil {
 		log.Fatalf("load config: %v", err)
@@ -26,77 +30,206 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	pool, err := pgxpool.New(ctx, cfg.DatabaseURL)
+	var certReloader *certs.Reloader
+	if cfg.TLSEnabled() {
+		certReloader, err = certs.NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			log.Fatalf("init tls: %v", err)
+		}
+		go certReloader.Watch(ctx, 30*time.Second)
+	}
+
+	pool, err := store.Connect(ctx, cfg.DatabaseURL, store.PoolOptions{
+		MaxConns:               cfg.DB.MaxConns,
+		MinConns:               cfg.DB.MinConns,
+		MaxConnLifetime:        cfg.DB.MaxConnLifetime,
+		StatementTimeout:       cfg.DB.StatementTimeout,
+		SlowQuery:              cfg.DB.SlowQuery,
+		StatementCacheCapacity: cfg.DB.StatementCacheCapacity,
+	})
 	if err != nil {
-		log.Fatalf("connect db: %v", err)
+		log.Fatalf("%v", err)
 	}
 	defer pool.Close()
 
-	if err := pool.Ping(ctx); err != nil {
-		log.Fatalf("ping db: %v", err)
+	st := store.New(pool, store.WithTimeouts(store.Timeouts{
+		Read:   cfg.DB.ReadTimeout,
+		Write:  cfg.DB.WriteTimeout,
+		Rollup: cfg.DB.RollupTimeout,
+	}), store.WithIdempotencyMaxPerUser(cfg.IdempotencyMaxPerUser))
+	prov, awsProv, err := cfg.NewRelayRouter(st)
+	if err != nil {
+		log.Fatalf("%v", err)
 	}
 
-	st := store.New(pool)
-	manifestEntries := buildManifestEntries(cfg)
-	if err := st.UpsertRelayManifest(ctx, manifestEntries); err != nil {
+	problems := append(cfg.Validate(), prov.ValidateConfig(ctx, cfg.SupportedRegion)...)
+	for _, p := range problems {
+		log.Printf("startup_validation problem=%q strict=%t", p.Error(), cfg.StrictStartup)
+	}
+	if cfg.StrictStartup && len(problems) > 0 {
+		log.Fatalf("startup validation failed with %d problem(s)", len(problems))
+	}
+	manifestEntries := buildManifestEntries(cfg, unavailableRegions(problems))
+	// Startup keeps regions missing from config: a rolling deploy's older
+	// instances may still serve them. Reloads prune.
+	if err := st.UpsertRelayManifest(ctx, manifestEntries, false); err != nil {
 		log.Fatalf("sync relay manifest: %v", err)
 	}
-	var prov relay.Provisioner
-	switch cfg.RelayProvider {
-	case "aws":
-		awsProv, err := relay.NewAWSProvisioner(relay.AWSProvisionerOptions{
-			AMIByRegion:   cfg.AWSAMIMap,
-			InstanceType:  cfg.AWSInstanceType,
-			SubnetID:      cfg.AWSSubnetID,
-			SecurityGroup: cfg.AWSSecurityIDs,
-			KeyName:       cfg.AWSKeyName,
-		})
-		if err != nil {
-			log.Fatalf("init aws provisioner: %v", err)
+	// The jobs worker prices sessions from the table, so reloads reach it too.
+	if err := st.ReplaceRelayPrices(ctx, cfg.RelayPrices()); err != nil {
+		log.Fatalf("sync relay prices: %v", err)
+	}
+
+	live := config.NewLive(cfg)
+	reloads := &reloader{load: config.LoadFromEnv, strict: cfg.StrictStartup, live: live, prov: prov, store: st}
+	if awsProv != nil {
+		reloads.aws = awsProv
+	}
+	reloadConfig := func() ([]string, error) {
+		return reloads.reload(ctx)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				if _, err := reloadConfig(); err != nil {
+					log.Printf("config_reload failed err=%v", err)
+				}
+				if certReloader != nil {
+					if err := certReloader.Reload(); err != nil {
+						log.Printf("tls_reload failed err=%v", err)
+					}
+				}
+			}
 		}
-		prov = awsProv
-	default:
-		prov = relay.NewFakeProvisioner()
-	}
-	handler := api.NewRouter(cfg, st, prov)
-
-	srv := &http.Server{
-		Addr:        cfg.ListenAddr,
-		Handler:     handler,
-		ReadTimeout: 30 * time.Second,
-		// Relay provisioning in AWS mode may take >15s before the handler writes a response.
-		WriteTimeout: 3 * time.Minute,
-		IdleTimeout:  60 * time.Second,
+	}()
+
+	drain := &api.Drain{}
+	handler, adminHandler := api.NewRouters(cfg, st, prov, api.WithLiveConfig(live), api.WithConfigReloader(reloadConfig), api.WithStreamContext(ctx), api.WithBootProbe(relay.NewDialProbe()), api.WithDrain(drain), api.WithAlerts(cfg.Alerts()))
+	servers := []*http.Server{newHTTPServer(cfg, cfg.ListenAddr, handler)}
+	if adminHandler != nil {
+		servers = append(servers, newHTTPServer(cfg, cfg.AdminListenAddr, adminHandler))
 	}
 
+	// On SIGTERM the API first drains: new starts are refused and /readyz
+	// fails so the load balancer moves traffic away, while starts already
+	// provisioning carry on. Shutdown then waits for them as long as their
+	// own handler timeout allows, on every listener at once.
+	shutdownDone := make(chan struct{})
 	go func() {
+		defer close(shutdownDone)
 		<-ctx.Done()
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		// A second signal stops the process without waiting.
+		stop()
+		drain.Start()
+		drainPeriod := live.Get().DrainPeriod
+		log.Printf("shutdown draining period=%s", drainPeriod)
+		time.Sleep(drainPeriod)
+		timeout := max(cfg.ShutdownGrace, cfg.HTTPStartTimeout)
+		log.Printf("shutdown closing timeout=%s", timeout)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
 		defer cancel()
-		_ = srv.Shutdown(shutdownCtx)
+		var wg sync.WaitGroup
+		for _, srv := range servers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := srv.Shutdown(shutdownCtx); err != nil {
+					log.Printf("shutdown incomplete addr=%s err=%v", srv.Addr, err)
+				}
+			}()
+		}
+		wg.Wait()
 	}()
 
-	log.Printf("aegis-control-plane listening on %s", cfg.ListenAddr)
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("http server: %v", err)
+	serveErrs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func() {
+			serveErrs <- serve(srv, certReloader)
+		}()
+	}
+	for range servers {
+		if err := <-serveErrs; err != nil && err != http.ErrServerClosed {
+			log.Fatalf("http server: %v", err)
+		}
 	}
+	// ListenAndServe returns as soon as Shutdown starts; wait for the
+	// requests it lets finish.
+	<-shutdownDone
 }
 
-func buildManifestEntries(cfg config.Config) []model.RelayManifestEntry {
+func newHTTPServer(cfg config.Config, addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         addr,
+		Handler:      handler,
+		ReadTimeout:  cfg.HTTPReadTimeout,
+		WriteTimeout: cfg.HTTPWriteTimeout,
+		IdleTimeout:  60 * time.Second,
+	}
+}
+
+// serve listens on srv.Addr, with TLS when certReloader is set.
+func serve(srv *http.Server, certReloader *certs.Reloader) error {
+	if certReloader != nil {
+		srv.TLSConfig = certReloader.TLSConfig()
+		log.Printf("aegis-control-plane listening on %s (tls)", srv.Addr)
+		return srv.ListenAndServeTLS("", "")
+	}
+	log.Printf("aegis-control-plane listening on %s", srv.Addr)
+	return srv.ListenAndServe()
+}
+
+func unavailableRegions(problems []error) map[string]bool {
+	out := make(map[string]bool)
+	for _, p := range problems {
+		var regionErr *model.RegionError
+		if errors.As(p, &regionErr) {
+			out[regionErr.Region] = true
+		}
+	}
+	return out
+}
+
+func buildManifestEntries(cfg config.Config, unavailable map[string]bool) []model.RelayManifestEntry {
 	manifestEntries := make([]model.RelayManifestEntry, 0, len(cfg.SupportedRegion))
 	for _, region := range cfg.SupportedRegion {
+		provider := cfg.ProviderFor(region)
 		ami := cfg.AWSAMIMap[region]
-		if ami == "" && cfg.RelayProvider == "fake" {
+		if ami == "" && provider == "fake" {
 			ami = "ami-fake-" + region
 		}
+		instanceType := cfg.AWSInstanceType
+		switch provider {
+		case "docker":
+			ami = cfg.DockerRelayImage
+		case "hetzner":
+			ami = cfg.HetznerImageMap[region]
+			instanceType = cfg.HetznerServerType
+			if t := cfg.HetznerServerTypes[region]; t != "" {
+				instanceType = t
+			}
+		}
 		if ami == "" {
 			continue
 		}
-		manifestEntries = append(manifestEntries, model.RelayManifestEntry{
+		entry := model.RelayManifestEntry{
 			Region:              region,
 			AMIID:               ami,
-			DefaultInstanceType: cfg.AWSInstanceType,
-		})
+			DefaultInstanceType: instanceType,
+			Available:           !unavailable[region],
+			Provider:            provider,
+		}
+		// A malformed entry stays listed but cannot be started into.
+		if err := model.ValidateManifestEntry(entry); err != nil {
+			log.Printf("event=relay_manifest_entry_invalid region=%s provider=%s err=%q", region, provider, err.Error())
+			entry.Available = false
+		}
+		manifestEntries = append(manifestEntries, entry)
 	}
 	return manifestEntries
 }
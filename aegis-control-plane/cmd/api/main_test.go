@@ -1,9 +1,12 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/telemyapp/aegis-control-plane/internal/config"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
 )
 
 func TestBuildManifestEntries_FakeModeUsesPlaceholderAMI(t *testing.T) {
@@ -14,7 +17,7 @@ func TestBuildManifestEntries_FakeModeUsesPlaceholderAMI(t *testing.T) {
 		AWSInstanceType: "t4g.small",
 	}
 
-	got := buildManifestEntries(cfg)
+	got := buildManifestEntries(cfg, nil)
 	if len(got) != 2 {
 		t.Fatalf("expected 2 entries, got %d", len(got))
 	}
@@ -31,16 +34,73 @@ func TestBuildManifestEntries_AWSModeRequiresAMIMapEntries(t *testing.T) {
 		RelayProvider:   "aws",
 		SupportedRegion: []string{"us-east-1", "eu-west-1"},
 		AWSAMIMap: map[string]string{
-			"us-east-1": "ami-real-1",
+			"us-east-1": "ami-0a1b2c3d",
 		},
 		AWSInstanceType: "t4g.small",
 	}
 
-	got := buildManifestEntries(cfg)
+	got := buildManifestEntries(cfg, nil)
 	if len(got) != 1 {
 		t.Fatalf("expected 1 entry, got %d", len(got))
 	}
-	if got[0].Region != "us-east-1" || got[0].AMIID != "ami-real-1" {
+	if got[0].Region != "us-east-1" || got[0].AMIID != "ami-0a1b2c3d" {
 		t.Fatalf("unexpected manifest entry: %+v", got[0])
 	}
 }
+
+func TestBuildManifestEntries_DockerModeUsesRelayImage(t *testing.T) {
+	cfg := config.Config{
+		RelayProvider:    "docker",
+		SupportedRegion:  []string{"us-east-1"},
+		DockerRelayImage: "telemy/relay:dev",
+	}
+
+	got := buildManifestEntries(cfg, nil)
+	if len(got) != 1 || got[0].AMIID != "telemy/relay:dev" {
+		t.Fatalf("unexpected manifest entries: %+v", got)
+	}
+}
+
+func TestBuildManifestEntries_MarksUnavailableRegions(t *testing.T) {
+	cfg := config.Config{
+		RelayProvider:   "aws",
+		SupportedRegion: []string{"us-east-1", "eu-west-1"},
+		AWSAMIMap: map[string]string{
+			"us-east-1": "ami-0a1b2c3d",
+			"eu-west-1": "ami-0e4f5a6b",
+		},
+		AWSInstanceType: "t4g.small",
+	}
+	problems := []error{
+		errors.New("AEGIS_AWS_SUBNET_ID is invalid"),
+		fmt.Errorf("verify: %w", &model.RegionError{Region: "eu-west-1", Err: errors.New("image not found")}),
+	}
+
+	got := buildManifestEntries(cfg, unavailableRegions(problems))
+	if len(got) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(got))
+	}
+	if !got[0].Available {
+		t.Fatalf("expected us-east-1 to be available: %+v", got[0])
+	}
+	if got[1].Available {
+		t.Fatalf("expected eu-west-1 to be unavailable: %+v", got[1])
+	}
+}
+
+func TestBuildManifestEntries_MalformedImageIsUnavailable(t *testing.T) {
+	cfg := config.Config{
+		RelayProvider:   "aws",
+		SupportedRegion: []string{"us-east-1", "eu-west-1"},
+		AWSAMIMap: map[string]string{
+			"us-east-1": "ami-0a1b2c3d",
+			"eu-west-1": "relay-image",
+		},
+		AWSInstanceType: "t4g.small",
+	}
+
+	got := buildManifestEntries(cfg, nil)
+	if len(got) != 2 || !got[0].Available || got[1].Available {
+		t.Fatalf("expected only us-east-1 available, got %+v", got)
+	}
+}
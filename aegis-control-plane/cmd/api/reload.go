@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/telemyapp/aegis-control-plane/internal/config"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+)
+
+// awsReconfigurer is the AWS provisioner as a reload drives it.
+type awsReconfigurer interface {
+	ValidateOptions(ctx context.Context, opts relay.AWSProvisionerOptions, regions []string) []error
+	Reconfigure(opts relay.AWSProvisionerOptions) error
+}
+
+// reloadStore is the store as a reload writes it.
+type reloadStore interface {
+	UpsertRelayManifest(ctx context.Context, entries []model.RelayManifestEntry, prune bool) error
+	ReplaceRelayPrices(ctx context.Context, prices []model.RelayPrice) error
+}
+
+// reloader applies a re-read config to the running API, on SIGHUP and
+// POST /api/v1/admin/config/reload.
+type reloader struct {
+	load   func() (config.Config, error)
+	strict bool
+	live   *config.Live
+	prov   *relay.Router
+	// aws is nil when no region runs on AWS.
+	aws   awsReconfigurer
+	store reloadStore
+}
+
+// reload returns the environment names of changed settings that need a
+// restart. Nothing is swapped in unless the new config validates.
+func (r *reloader) reload(ctx context.Context) ([]string, error) {
+	next, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	problems := r.validate(ctx, next)
+	for _, p := range problems {
+		log.Printf("config_reload problem=%q strict=%t", p.Error(), r.strict)
+	}
+	if r.strict && len(problems) > 0 {
+		return nil, fmt.Errorf("reloaded config has %d problem(s): %w", len(problems), errors.Join(problems...))
+	}
+	if r.aws != nil {
+		if err := r.aws.Reconfigure(next.AWSProvisionerOptions()); err != nil {
+			return nil, err
+		}
+	}
+	rejected := r.live.Reload(next)
+	for _, name := range rejected {
+		log.Printf("config_reload rejected_change field=%s reason=requires_restart", name)
+	}
+	if err := r.store.UpsertRelayManifest(ctx, buildManifestEntries(r.live.Get(), unavailableRegions(problems)), true); err != nil {
+		return rejected, fmt.Errorf("sync relay manifest: %w", err)
+	}
+	if err := r.store.ReplaceRelayPrices(ctx, next.RelayPrices()); err != nil {
+		return rejected, fmt.Errorf("sync relay prices: %w", err)
+	}
+	log.Printf("config_reload ok default_region=%s supported_regions=%s", next.DefaultRegion, strings.Join(next.SupportedRegion, ","))
+	return rejected, nil
+}
+
+// validate checks next before it is applied. The AWS regions are checked
+// against next's AMIs rather than the running ones; the other providers
+// keep their startup settings, so they are checked as they run.
+func (r *reloader) validate(ctx context.Context, next config.Config) []error {
+	problems := next.Validate()
+	if r.aws == nil {
+		return append(problems, r.prov.ValidateConfig(ctx, next.SupportedRegion)...)
+	}
+	var awsRegions, others []string
+	for _, region := range next.SupportedRegion {
+		if name, err := r.prov.ProviderFor(region); err == nil && name == "aws" {
+			awsRegions = append(awsRegions, region)
+		} else {
+			others = append(others, region)
+		}
+	}
+	problems = append(problems, r.prov.ValidateConfig(ctx, others)...)
+	return append(problems, r.aws.ValidateOptions(ctx, next.AWSProvisionerOptions(), awsRegions)...)
+}
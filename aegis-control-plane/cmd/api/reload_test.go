@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/telemyapp/aegis-control-plane/internal/config"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+)
+
+// stubAWS knows the AMIs in images.
+type stubAWS struct {
+	images       map[string]bool
+	reconfigured []relay.AWSProvisionerOptions
+}
+
+func (s *stubAWS) ValidateOptions(_ context.Context, opts relay.AWSProvisionerOptions, regions []string) []error {
+	var problems []error
+	for _, region := range regions {
+		if !s.images[opts.AMIByRegion[region]] {
+			problems = append(problems, &model.RegionError{Region: region, Err: errors.New("image not found")})
+		}
+	}
+	return problems
+}
+
+func (s *stubAWS) Reconfigure(opts relay.AWSProvisionerOptions) error {
+	s.reconfigured = append(s.reconfigured, opts)
+	return nil
+}
+
+type stubReloadStore struct{ manifests int }
+
+func (s *stubReloadStore) UpsertRelayManifest(context.Context, []model.RelayManifestEntry, bool) error {
+	s.manifests++
+	return nil
+}
+
+func (s *stubReloadStore) ReplaceRelayPrices(context.Context, []model.RelayPrice) error {
+	return nil
+}
+
+func TestReload_RejectsUnknownAMIBeforeSwapping(t *testing.T) {
+	base := config.Config{
+		RelayProvider:   "aws",
+		DefaultRegion:   "us-east-1",
+		SupportedRegion: []string{"us-east-1"},
+		AWSAMIMap:       map[string]string{"us-east-1": "ami-old"},
+	}
+	prov, err := relay.NewRouter(map[string]relay.Provisioner{"aws": relay.NewFakeProvisioner()}, nil, "aws")
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	aws := &stubAWS{images: map[string]bool{"ami-old": true, "ami-new": true}}
+	st := &stubReloadStore{}
+	next := base
+	r := &reloader{
+		load:   func() (config.Config, error) { return next, nil },
+		strict: true,
+		live:   config.NewLive(base),
+		prov:   prov,
+		aws:    aws,
+		store:  st,
+	}
+
+	next.AWSAMIMap = map[string]string{"us-east-1": "ami-unknown"}
+	if _, err := r.reload(context.Background()); err == nil {
+		t.Fatal("expected the reload to be refused")
+	}
+	if len(aws.reconfigured) != 0 || st.manifests != 0 {
+		t.Fatalf("expected nothing swapped in, got %d reconfigures and %d manifest syncs", len(aws.reconfigured), st.manifests)
+	}
+	if got := r.live.Get().AWSAMIMap["us-east-1"]; got != "ami-old" {
+		t.Fatalf("expected the running AMI kept, got %s", got)
+	}
+
+	next.AWSAMIMap = map[string]string{"us-east-1": "ami-new"}
+	if _, err := r.reload(context.Background()); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if len(aws.reconfigured) != 1 || r.live.Get().AWSAMIMap["us-east-1"] != "ami-new" {
+		t.Fatalf("expected the known AMI swapped in, got %+v", aws.reconfigured)
+	}
+}
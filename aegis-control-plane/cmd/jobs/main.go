@@ -6,14 +6,19 @@ import (
 	"os/signal"
 	"syscall"
 
-	"github.com/jackc/pgx/v5/pgxpool"
-
+	"github.com/telemyapp/aegis-control-plane/internal/alert"
 	"github.com/telemyapp/aegis-control-plane/internal/config"
 	"github.com/telemyapp/aegis-control-plane/internal/jobs"
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+	"github.com/telemyapp/aegis-control-plane/internal/session"
 	"github.com/telemyapp/aegis-control-plane/internal/store"
+	"github.com/telemyapp/aegis-control-plane/internal/stripe"
+	"github.com/telemyapp/aegis-control-plane/internal/version"
+	"github.com/telemyapp/aegis-control-plane/internal/webhook"
 )
 
 func main() {
+	log.Printf("aegis-jobs starting %s", version.String())
 	cfg, err := config.LoadFromEnv()
 	if err != nil {
 		log.Fatalf("load config: %v", err)
@@ -22,18 +27,63 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	pool, err := pgxpool.New(ctx, cfg.DatabaseURL)
+	pool, err := store.Connect(ctx, cfg.DatabaseURL, store.PoolOptions{
+		MaxConns:               cfg.JobsDB.MaxConns,
+		MinConns:               cfg.JobsDB.MinConns,
+		MaxConnLifetime:        cfg.JobsDB.MaxConnLifetime,
+		StatementTimeout:       cfg.JobsDB.StatementTimeout,
+		SlowQuery:              cfg.JobsDB.SlowQuery,
+		StatementCacheCapacity: cfg.JobsDB.StatementCacheCapacity,
+	})
 	if err != nil {
-		log.Fatalf("connect db: %v", err)
+		log.Fatalf("%v", err)
 	}
 	defer pool.Close()
 
-	if err := pool.Ping(ctx); err != nil {
-		log.Fatalf("ping db: %v", err)
+	st := store.New(pool, store.WithTimeouts(store.Timeouts{
+		Read:   cfg.JobsDB.ReadTimeout,
+		Write:  cfg.JobsDB.WriteTimeout,
+		Rollup: cfg.JobsDB.RollupTimeout,
+	}))
+	// Terminations follow the provider recorded with each relay, so the
+	// worker needs every provider the API may have launched on.
+	prov, _, err := cfg.NewRelayRouter(st)
+	if err != nil {
+		log.Fatalf("%v", err)
 	}
-
-	st := store.New(pool)
-	jobs.NewRunner(st).Start(ctx)
+	terminator := relay.NewTerminator(prov, relay.WithTerminatorWorkers(cfg.TerminatorWorkers))
+	terminator.Start(ctx)
+	alerts := cfg.Alerts()
+	opts := []jobs.Option{
+		jobs.WithAlerts(alerts, cfg.AlertTerminationAttempts),
+		jobs.WithTerminator(terminator),
+		jobs.WithRelayControlPlaneURL(cfg.RelayControlPlaneURL),
+		jobs.WithSRTPorts(cfg.RelaySRTPortRange, cfg.RelaySRTPortCount),
+		jobs.WithUsageAlertThresholds(cfg.UsageAlertThresholds),
+		jobs.WithWebhooks(webhook.NewSender(cfg.WebhookTimeout, cfg.ExternalBaseURL), cfg.WebhookMaxAttempts),
+		jobs.WithHealthEventRetention(cfg.HealthEventRetention),
+		jobs.WithSessionChangeRetention(cfg.SessionChangeRetention),
+	}
+	if cfg.StripeAPIKey != "" {
+		opts = append(opts, jobs.WithBillingExport(stripe.NewClient("", cfg.StripeAPIKey), cfg.StripeMaxAttempts))
+	}
+	if cfg.CanaryInterval > 0 {
+		canary := jobs.CanaryOptions{
+			Interval:     cfg.CanaryInterval,
+			Regions:      cfg.CanaryRegions,
+			InstanceType: cfg.CanaryInstanceType,
+			Webhook:      cfg.CanaryWebhook,
+		}
+		// Canaries start through the same path as the API; their relays are
+		// probed like the API's when the boot probe is on.
+		if cfg.RelayBootProbe {
+			canary.Probe, canary.ProbeTimeout = relay.NewDialProbe(), cfg.RelayBootProbeTimeout
+		}
+		canarySessions := session.NewService(st, prov, config.NewLive(cfg),
+			session.WithAlerts(alerts, alert.NewWindow(cfg.AlertProvisionFailures, cfg.AlertProvisionFailureWindow)))
+		opts = append(opts, jobs.WithCanary(canarySessions, canary))
+	}
+	jobs.NewRunner(st, prov, cfg.RelayProvider, opts...).Start(ctx)
 
 	log.Printf("aegis-jobs worker started")
 	<-ctx.Done()
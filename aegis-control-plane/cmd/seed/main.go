@@ -0,0 +1,63 @@
+// Command seed fills a local database with realistic users, session history
+// and relay heartbeats. See internal/seed.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/seed"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+func main() {
+	databaseURL := flag.String("database-url", os.Getenv("AEGIS_DATABASE_URL"), "database to seed (default $AEGIS_DATABASE_URL)")
+	users := flag.Int("users", 20, "users to create")
+	sessionsPerUser := flag.Int("sessions-per-user", 20, "sessions per user")
+	days := flag.Int("days", 30, "days of session history")
+	seedValue := flag.Uint64("seed", 1, "random seed; the same seed gives the same data")
+	healthInterval := flag.Duration("health-interval", time.Minute, "spacing of relay heartbeats")
+	regions := flag.String("regions", "us-east-1,eu-west-1", "comma-separated regions to spread sessions over")
+	wipe := flag.Bool("wipe", false, "delete all users, sessions and relays first")
+	flag.Parse()
+	if *databaseURL == "" {
+		log.Fatalf("no database; pass --database-url or set AEGIS_DATABASE_URL")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	pool, err := store.Connect(ctx, *databaseURL, store.PoolOptions{})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer pool.Close()
+	st := store.New(pool)
+
+	if *wipe {
+		if err := st.Wipe(ctx); err != nil {
+			log.Fatalf("wipe: %v", err)
+		}
+		log.Printf("event=seed_wiped")
+	}
+	start := time.Now()
+	sum, err := seed.Generate(ctx, st, seed.Options{
+		Users:           *users,
+		SessionsPerUser: *sessionsPerUser,
+		Days:            *days,
+		Seed:            *seedValue,
+		HealthInterval:  *healthInterval,
+		Regions:         strings.Split(*regions, ","),
+	})
+	if err != nil {
+		log.Fatalf("seed: %v", err)
+	}
+	log.Printf("event=seed_done users=%d sessions=%d provisioning=%d outages=%d heartbeats=%d elapsed=%s",
+		sum.Users, sum.Sessions, sum.Provisioning, sum.Outages, sum.Heartbeats, time.Since(start).Round(time.Millisecond))
+}
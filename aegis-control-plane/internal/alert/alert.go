@@ -0,0 +1,187 @@
+// Package alert tells operators about relay failures that need a person,
+// such as launches failing across a region or relays that will not
+// terminate. It is optional: a nil Hook drops every alert.
+package alert
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+)
+
+// Alert kinds.
+const (
+	// KindProvisionFailures: relay launches in a region kept failing.
+	KindProvisionFailures = "provision_failures"
+	// KindTerminationRetries: a relay termination kept failing.
+	KindTerminationRetries = "termination_retries"
+	// KindOrphanedRelay: a relay is still running long after its
+	// termination was requested.
+	KindOrphanedRelay = "orphaned_relay"
+)
+
+const (
+	// maxPerHour caps the alerts a Hook sends in any hour, whatever their
+	// keys, so that a provider outage failing everything at once does not
+	// flood the channel.
+	maxPerHour = 20
+	// deliveryTimeout bounds each notifier call.
+	deliveryTimeout = 10 * time.Second
+)
+
+type Alert struct {
+	Kind string
+	// Key identifies the condition; alerts repeating a key within the
+	// Hook's cooldown are dropped.
+	Key     string
+	Summary string
+	Fields  map[string]string
+}
+
+// Text renders the alert for people: the summary, then the fields sorted
+// by name.
+func (a Alert) Text() string {
+	var b strings.Builder
+	b.WriteString(a.Summary)
+	for _, k := range slices.Sorted(maps.Keys(a.Fields)) {
+		fmt.Fprintf(&b, " %s=%s", k, a.Fields[k])
+	}
+	return b.String()
+}
+
+// Notifier delivers an alert to one channel.
+type Notifier interface {
+	// Name labels the notifier in logs and metrics.
+	Name() string
+	Notify(ctx context.Context, a Alert) error
+}
+
+// Hook sends alerts to its notifiers in the background, dropping repeats
+// of a key within the cooldown and anything over maxPerHour. A nil Hook
+// drops everything.
+type Hook struct {
+	notifiers []Notifier
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu   sync.Mutex
+	last map[string]time.Time
+	sent []time.Time
+
+	wg sync.WaitGroup
+}
+
+// New returns a Hook sending to notifiers, or nil when there are none.
+func New(cooldown time.Duration, notifiers ...Notifier) *Hook {
+	if len(notifiers) == 0 {
+		return nil
+	}
+	return &Hook{notifiers: notifiers, cooldown: cooldown, now: time.Now, last: make(map[string]time.Time)}
+}
+
+// Fire sends a unless it repeats a recent alert or the hourly cap is
+// reached. It does not wait for delivery.
+func (h *Hook) Fire(a Alert) {
+	if h == nil {
+		return
+	}
+	if cause := h.admit(a.Key); cause != "" {
+		metrics.Default().IncCounter("aegis_alerts_suppressed_total", map[string]string{"kind": a.Kind, "cause": cause})
+		return
+	}
+	metrics.Default().IncCounter("aegis_alerts_fired_total", map[string]string{"kind": a.Kind})
+	log.Printf("event=alert_fired kind=%s key=%s text=%q", a.Kind, a.Key, a.Text())
+	for _, n := range h.notifiers {
+		h.wg.Add(1)
+		go func() {
+			defer h.wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+			defer cancel()
+			if err := n.Notify(ctx, a); err != nil {
+				log.Printf("event=alert_delivery_failed kind=%s key=%s notifier=%s err=%q", a.Kind, a.Key, n.Name(), err.Error())
+				metrics.Default().IncCounter("aegis_alert_delivery_failures_total", map[string]string{"kind": a.Kind, "notifier": n.Name()})
+			}
+		}()
+	}
+}
+
+// admit records an alert for key and returns "", or returns why it is
+// dropped: duplicate or rate_limited.
+func (h *Hook) admit(key string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	if last, ok := h.last[key]; ok && now.Sub(last) < h.cooldown {
+		return "duplicate"
+	}
+	h.sent = slices.DeleteFunc(h.sent, func(t time.Time) bool { return now.Sub(t) >= time.Hour })
+	if len(h.sent) >= maxPerHour {
+		return "rate_limited"
+	}
+	h.sent = append(h.sent, now)
+	h.last[key] = now
+	for k, t := range h.last {
+		if now.Sub(t) >= h.cooldown {
+			delete(h.last, k)
+		}
+	}
+	return ""
+}
+
+// Wait returns once the deliveries of every alert fired so far have
+// finished.
+func (h *Hook) Wait() {
+	if h != nil {
+		h.wg.Wait()
+	}
+}
+
+// Window counts events per key over a sliding period, such as provision
+// failures per region. A nil Window counts nothing.
+type Window struct {
+	threshold int
+	period    time.Duration
+	now       func() time.Time
+
+	mu     sync.Mutex
+	events map[string][]time.Time
+}
+
+// NewWindow reports keys seeing threshold events within period. It returns
+// nil when threshold is not positive.
+func NewWindow(threshold int, period time.Duration) *Window {
+	if threshold <= 0 {
+		return nil
+	}
+	return &Window{threshold: threshold, period: period, now: time.Now, events: make(map[string][]time.Time)}
+}
+
+// Add records an event for key and returns the events within the period,
+// and whether they reached the threshold.
+func (w *Window) Add(key string) (int, bool) {
+	if w == nil {
+		return 0, false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.now()
+	events := slices.DeleteFunc(w.events[key], func(t time.Time) bool { return now.Sub(t) >= w.period })
+	events = append(events, now)
+	w.events[key] = events
+	return len(events), len(events) >= w.threshold
+}
+
+// Period is how far back the window counts.
+func (w *Window) Period() time.Duration {
+	if w == nil {
+		return 0
+	}
+	return w.period
+}
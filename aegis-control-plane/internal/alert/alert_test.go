@@ -0,0 +1,152 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+)
+
+type recorder struct {
+	mu     sync.Mutex
+	alerts []Alert
+	err    error
+}
+
+func (r *recorder) Name() string { return "recorder" }
+
+func (r *recorder) Notify(_ context.Context, a Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, a)
+	return r.err
+}
+
+func TestNew_WithoutNotifiersIsNoop(t *testing.T) {
+	h := New(time.Hour)
+	if h != nil {
+		t.Fatal("expected no hook without notifiers")
+	}
+	h.Fire(Alert{Kind: KindOrphanedRelay, Key: "k"})
+	h.Wait()
+}
+
+func TestHook_DropsRepeatsWithinCooldown(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	rec := &recorder{}
+	h := New(30*time.Minute, rec)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	h.now = func() time.Time { return now }
+
+	h.Fire(Alert{Kind: KindProvisionFailures, Key: "provision_failures/us-east-1"})
+	h.Fire(Alert{Kind: KindProvisionFailures, Key: "provision_failures/us-east-1"})
+	h.Fire(Alert{Kind: KindProvisionFailures, Key: "provision_failures/eu-west-1"})
+	now = now.Add(31 * time.Minute)
+	h.Fire(Alert{Kind: KindProvisionFailures, Key: "provision_failures/us-east-1"})
+	h.Wait()
+
+	if len(rec.alerts) != 3 {
+		t.Fatalf("expected the repeat dropped, got %+v", rec.alerts)
+	}
+	out := metrics.Default().Render()
+	for _, want := range []string{
+		`aegis_alerts_fired_total{kind="provision_failures"} 3`,
+		`aegis_alerts_suppressed_total{cause="duplicate",kind="provision_failures"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %s in metrics, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestHook_CapsAlertsPerHour(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	rec := &recorder{err: errors.New("channel down")}
+	h := New(time.Hour, rec)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	h.now = func() time.Time { return now }
+
+	for i := range maxPerHour + 5 {
+		h.Fire(Alert{Kind: KindTerminationRetries, Key: "instance/" + string(rune('a'+i))})
+	}
+	now = now.Add(time.Hour)
+	h.Fire(Alert{Kind: KindTerminationRetries, Key: "instance/late"})
+	h.Wait()
+
+	if len(rec.alerts) != maxPerHour+1 {
+		t.Fatalf("expected %d alerts, got %d", maxPerHour+1, len(rec.alerts))
+	}
+	out := metrics.Default().Render()
+	for _, want := range []string{
+		`aegis_alerts_suppressed_total{cause="rate_limited",kind="termination_retries"} 5`,
+		`aegis_alert_delivery_failures_total{kind="termination_retries",notifier="recorder"} 21`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %s in metrics, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestWindow_CountsWithinPeriod(t *testing.T) {
+	w := NewWindow(3, 10*time.Minute)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+
+	w.Add("us-east-1")
+	now = now.Add(6 * time.Minute)
+	w.Add("us-east-1")
+	w.Add("eu-west-1")
+	now = now.Add(5 * time.Minute)
+	// The first failure is out of the period.
+	if n, reached := w.Add("us-east-1"); n != 2 || reached {
+		t.Fatalf("expected 2 failures in the period, got %d reached=%v", n, reached)
+	}
+	if n, reached := w.Add("us-east-1"); n != 3 || !reached {
+		t.Fatalf("expected the threshold reached, got %d reached=%v", n, reached)
+	}
+	if NewWindow(0, time.Minute) != nil {
+		t.Fatal("expected no window without a threshold")
+	}
+}
+
+func TestNotifiers_PostJSON(t *testing.T) {
+	var bodies []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		var body map[string]any
+		_ = json.Unmarshal(raw, &body)
+		bodies = append(bodies, body)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	a := Alert{Kind: KindOrphanedRelay, Key: "orphaned_relay/us-east-1", Summary: "relay still running", Fields: map[string]string{"region": "us-east-1", "instance_id": "i-1"}}
+
+	if err := NewSlack(srv.URL).Notify(context.Background(), a); err != nil {
+		t.Fatalf("slack: %v", err)
+	}
+	hook := NewWebhook(srv.URL)
+	hook.now = func() time.Time { return time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC) }
+	if err := hook.Notify(context.Background(), a); err != nil {
+		t.Fatalf("webhook: %v", err)
+	}
+	if err := NewWebhook(srv.URL+"/fail").Notify(context.Background(), a); err == nil {
+		t.Fatal("expected a 500 to fail the delivery")
+	}
+
+	if text, _ := bodies[0]["text"].(string); !strings.HasSuffix(text, "relay still running instance_id=i-1 region=us-east-1") {
+		t.Fatalf("unexpected slack text %q", text)
+	}
+	if bodies[1]["kind"] != KindOrphanedRelay || bodies[1]["fired_at"] != "2026-03-01T12:00:00Z" || bodies[1]["fields"].(map[string]any)["instance_id"] != "i-1" {
+		t.Fatalf("unexpected webhook body %v", bodies[1])
+	}
+}
@@ -0,0 +1,74 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Slack posts alerts to a Slack incoming webhook.
+type Slack struct {
+	url    string
+	client *http.Client
+}
+
+func NewSlack(url string) *Slack {
+	return &Slack{url: url, client: &http.Client{}}
+}
+
+func (s *Slack) Name() string { return "slack" }
+
+func (s *Slack) Notify(ctx context.Context, a Alert) error {
+	return post(ctx, s.client, s.url, map[string]string{"text": ":rotating_light: aegis " + a.Text()})
+}
+
+// Webhook posts alerts as JSON to any endpoint:
+// {"kind", "key", "summary", "fields", "fired_at"}.
+type Webhook struct {
+	url    string
+	client *http.Client
+	now    func() time.Time
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{url: url, client: &http.Client{}, now: time.Now}
+}
+
+func (w *Webhook) Name() string { return "webhook" }
+
+func (w *Webhook) Notify(ctx context.Context, a Alert) error {
+	return post(ctx, w.client, w.url, map[string]any{
+		"kind":     a.Kind,
+		"key":      a.Key,
+		"summary":  a.Summary,
+		"fields":   a.Fields,
+		"fired_at": w.now().UTC().Format(time.RFC3339),
+	})
+}
+
+func post(ctx context.Context, client *http.Client, url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "aegis-alerts/1")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("alert endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
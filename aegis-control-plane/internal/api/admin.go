@@ -0,0 +1,272 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/auth"
+	"github.com/telemyapp/aegis-control-plane/internal/jobs"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/session"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+// handleAdminStopSession stops any user's session, as the user would with
+// POST /relay/stop.
+func (s *Server) handleAdminStopSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := chi.URLParam(r, "id")
+	sr, err := s.store.GetSessionRelay(r.Context(), sessionID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeAPIError(w, apierr.NotFound, "session not found")
+			return
+		}
+		writeStoreError(w, err, "failed to load session")
+		return
+	}
+	sess, err := s.sessions.Stop(r.Context(), session.StopCommand{UserID: sr.UserID, SessionID: sessionID, Reason: model.StopReasonAdmin})
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeAPIError(w, apierr.NotFound, "session not found")
+			return
+		}
+		writeStoreError(w, err, "failed to stop session")
+		return
+	}
+	adminID, _ := auth.UserIDFromContext(r.Context())
+	log.Printf("event=admin_session_stop session_id=%s user_id=%s admin_id=%s status=%s", sess.ID, sr.UserID, adminID, sess.Status)
+	writeStopResult(w, sess, "")
+}
+
+// handleAdminStartSession starts a session as the user would with POST
+// /relay/start, for support to reproduce their setup. The session is
+// non-billable and records the admin; it is refused while the user has a
+// live session unless force=true. dry_run works as it does there.
+func (s *Server) handleAdminStartSession(w http.ResponseWriter, r *http.Request) {
+	force := false
+	if raw := r.URL.Query().Get("force"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeAPIError(w, apierr.InvalidRequest, "force must be true or false")
+			return
+		}
+		force = v
+	}
+	cmd, dryRun, ok := s.decodeStart(w, r)
+	if !ok {
+		return
+	}
+	adminID, _ := auth.UserIDFromContext(r.Context())
+	cmd.UserID = chi.URLParam(r, "id")
+	cmd.NonBillable = true
+	cmd.StartedByAdmin = adminID
+	cmd.RefuseIfLive = !force
+	if !s.applyRegionSwitch(w, r, &cmd, dryRun) {
+		return
+	}
+	if dryRun {
+		s.dryRunStart(w, r, cmd)
+		return
+	}
+	if s.drain.Draining() {
+		writeRetryAfter(w, http.StatusServiceUnavailable, apierr.ServerDraining, "", drainRetryAfter)
+		return
+	}
+	log.Printf("event=admin_session_start user_id=%s admin_id=%s region=%s force=%t", cmd.UserID, adminID, cmd.Region, force)
+	s.startSession(w, r, cmd)
+}
+
+func (s *Server) handleAdminUserUsage(w http.ResponseWriter, r *http.Request) {
+	s.writeUsageCurrent(w, r, chi.URLParam(r, "id"))
+}
+
+// handleAdminSessionHealth lists the latest heartbeats of a session's
+// relays, newest first.
+func (s *Server) handleAdminSessionHealth(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 500 {
+			writeAPIError(w, apierr.InvalidRequest, "limit must be between 1 and 500")
+			return
+		}
+		limit = n
+	}
+	events, err := s.store.ListRelayHealth(r.Context(), chi.URLParam(r, "id"), limit)
+	if err != nil {
+		writeStoreError(w, err, "failed to list relay health")
+		return
+	}
+	out := make([]map[string]any, 0, len(events))
+	for _, e := range events {
+		out = append(out, map[string]any{
+			"relay_instance_id":      e.RelayInstanceID,
+			"observed_at":            e.ObservedAt.UTC().Format(time.RFC3339),
+			"ingest_active":          e.IngestActive,
+			"egress_active":          e.EgressActive,
+			"session_uptime_seconds": e.SessionUptimeSeconds,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"session_id": chi.URLParam(r, "id"), "health": out})
+}
+
+type manifestRegionRequest struct {
+	Available           *bool  `json:"available"`
+	AMIID               string `json:"ami_id"`
+	DefaultInstanceType string `json:"default_instance_type"`
+}
+
+// handleAdminSetManifestRegion changes a region's manifest entry. The API
+// rewrites the manifest from its config at startup and on config reload, so
+// the change is an override until then.
+func (s *Server) handleAdminSetManifestRegion(w http.ResponseWriter, r *http.Request) {
+	var req manifestRegionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, apierr.InvalidRequest, "invalid json body")
+		return
+	}
+	if req.Available == nil && req.AMIID == "" && req.DefaultInstanceType == "" {
+		writeAPIError(w, apierr.InvalidRequest, "one of available, ami_id or default_instance_type is required")
+		return
+	}
+	region := chi.URLParam(r, "region")
+	if err := model.ValidateRegion(region); err != nil {
+		writeAPIError(w, apierr.InvalidField, err.Error())
+		return
+	}
+	if req.AMIID != "" {
+		if err := model.ValidateImageID(s.config().ProviderFor(region), req.AMIID); err != nil {
+			writeAPIError(w, apierr.InvalidField, err.Error())
+			return
+		}
+	}
+	entry, err := s.store.UpdateRelayManifestEntry(r.Context(), store.RelayManifestUpdate{
+		Region:              region,
+		Available:           req.Available,
+		AMIID:               req.AMIID,
+		DefaultInstanceType: req.DefaultInstanceType,
+	})
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeAPIError(w, apierr.NotFound, "region not in relay manifest")
+			return
+		}
+		writeStoreError(w, err, "failed to update relay manifest")
+		return
+	}
+	adminID, _ := auth.UserIDFromContext(r.Context())
+	log.Printf("event=admin_manifest_update region=%s admin_id=%s available=%t ami_id=%s instance_type=%s", entry.Region, adminID, entry.Available, entry.AMIID, entry.DefaultInstanceType)
+	writeJSON(w, http.StatusOK, toManifestEntryResponse(entry))
+}
+
+type manifestRegionSwitchRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// handleAdminSwitchManifestRegion switches new sessions in a region on or
+// off. Unlike the other manifest fields the switch survives restarts and
+// config reloads; sessions already in the region are left running.
+func (s *Server) handleAdminSwitchManifestRegion(w http.ResponseWriter, r *http.Request) {
+	var req manifestRegionSwitchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, apierr.InvalidRequest, "invalid json body")
+		return
+	}
+	if req.Enabled == nil {
+		writeAPIError(w, apierr.InvalidRequest, "enabled is required")
+		return
+	}
+	region := chi.URLParam(r, "region")
+	if err := model.ValidateRegion(region); err != nil {
+		writeAPIError(w, apierr.InvalidField, err.Error())
+		return
+	}
+	entry, err := s.store.UpdateRelayManifestEntry(r.Context(), store.RelayManifestUpdate{Region: region, Enabled: req.Enabled})
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeAPIError(w, apierr.NotFound, "region not in relay manifest")
+			return
+		}
+		writeStoreError(w, err, "failed to update relay manifest")
+		return
+	}
+	adminID, _ := auth.UserIDFromContext(r.Context())
+	log.Printf("event=admin_manifest_region_switch region=%s admin_id=%s enabled=%t", entry.Region, adminID, entry.Enabled)
+	writeJSON(w, http.StatusOK, toManifestEntryResponse(entry))
+}
+
+func toManifestEntryResponse(entry *model.RelayManifestEntry) map[string]any {
+	return map[string]any{
+		"region":                entry.Region,
+		"ami_id":                entry.AMIID,
+		"default_instance_type": entry.DefaultInstanceType,
+		"available":             entry.Available && entry.Enabled,
+		"enabled":               entry.Enabled,
+		"updated_at":            entry.UpdatedAt.UTC().Format(time.RFC3339),
+		"provider":              entry.Provider,
+	}
+}
+
+func toJobRunResponse(run *model.JobRun) map[string]any {
+	out := map[string]any{
+		"run_id":       run.ID,
+		"job":          run.Job,
+		"requested_by": run.RequestedBy,
+		"status":       run.Status,
+		"requested_at": run.RequestedAt.UTC().Format(time.RFC3339),
+	}
+	if run.Error != "" {
+		out["error"] = run.Error
+	}
+	for key, ts := range map[string]*time.Time{"started_at": run.StartedAt, "finished_at": run.FinishedAt} {
+		if ts != nil {
+			out[key] = ts.UTC().Format(time.RFC3339)
+		}
+	}
+	return out
+}
+
+// handleAdminRunJob queues a run of a background job; the jobs worker picks
+// it up within seconds. Poll the returned run for its outcome.
+func (s *Server) handleAdminRunJob(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if !slices.Contains(jobs.Names, name) {
+		writeAPIError(w, apierr.NotFound, "unknown job")
+		return
+	}
+	adminID, _ := auth.UserIDFromContext(r.Context())
+	run, err := s.store.RequestJobRun(r.Context(), name, adminID)
+	if err != nil {
+		writeStoreError(w, err, "failed to request job run")
+		return
+	}
+	log.Printf("event=admin_job_run_requested run_id=%d job=%s admin_id=%s", run.ID, name, adminID)
+	w.Header().Set("Location", s.externalURL(r, "/api/v1/admin/jobs/runs/"+strconv.FormatInt(run.ID, 10)))
+	writeJSON(w, http.StatusAccepted, map[string]any{"run": toJobRunResponse(run)})
+}
+
+func (s *Server) handleAdminJobRun(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, apierr.InvalidRequest, "invalid run id")
+		return
+	}
+	run, err := s.store.GetJobRun(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeAPIError(w, apierr.NotFound, "job run not found")
+			return
+		}
+		writeStoreError(w, err, "failed to load job run")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"run": toJobRunResponse(run)})
+}
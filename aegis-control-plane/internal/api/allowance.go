@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+// allowanceCacheTTL is how long a relay's computed allowance is reused.
+// Cached values count down with the clock, as the live session's usage
+// does, so only plan changes show up late.
+const allowanceCacheTTL = 30 * time.Second
+
+// noLimit is the allowance reported when neither the plan nor the session
+// caps how long a relay may run.
+const noLimit = -1
+
+// planRemaining returns the seconds the user may still relay this cycle, or
+// noLimit when the plan does not cap usage: paid tiers are billed for
+// overage, and a tier without included time is not metered.
+func planRemaining(usage *model.UsageCurrent) int {
+	if usage.PlanTier != "free" || usage.IncludedSeconds <= 0 {
+		return noLimit
+	}
+	return usage.RemainingSeconds
+}
+
+// usageExhausted reports whether a free-tier user has used up the cycle's
+// included time. A failed usage read does not block the start.
+func (s *Server) usageExhausted(ctx context.Context, userID string) bool {
+	usage, err := s.store.GetUsageCurrent(ctx, userID, s.config().FreeIncludedSeconds)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("event=relay_start_usage_check_failed user_id=%s err=%v", userID, err)
+		}
+		return false
+	}
+	return planRemaining(usage) == 0
+}
+
+// relayAllowance returns how many more seconds the session's relay may
+// forward: the lower of the plan's remaining time and the session's
+// remaining maximum duration, 0 once the session is stopping, or noLimit.
+func (s *Server) relayAllowance(ctx context.Context, sessionID string) (int, error) {
+	now := time.Now()
+	if seconds, ok := s.allowances.get(sessionID, now); ok {
+		return seconds, nil
+	}
+	sess, err := s.store.GetSessionTimers(ctx, sessionID)
+	if err != nil {
+		return 0, err
+	}
+	seconds := noLimit
+	switch {
+	case sess.Status == model.SessionStopping || sess.Status == model.SessionStopped:
+		seconds = 0
+	default:
+		if sess.MaxSessionSeconds > 0 {
+			seconds = max(sess.MaxSessionSeconds-int(time.Since(sess.StartedAt).Seconds()), 0)
+		}
+		usage, err := s.store.GetUsageCurrent(ctx, sess.UserID, s.config().FreeIncludedSeconds)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return 0, err
+		}
+		if usage != nil {
+			if plan := planRemaining(usage); plan != noLimit && (seconds == noLimit || plan < seconds) {
+				seconds = plan
+			}
+		}
+	}
+	s.allowances.put(sessionID, seconds, now)
+	return seconds, nil
+}
+
+// allowanceCache keeps each session's last computed allowance so that
+// heartbeats do not each read the user's usage.
+type allowanceCache struct {
+	mu      sync.Mutex
+	entries map[string]allowanceEntry
+	swept   time.Time
+}
+
+type allowanceEntry struct {
+	seconds int
+	at      time.Time
+}
+
+func newAllowanceCache() *allowanceCache {
+	return &allowanceCache{entries: make(map[string]allowanceEntry)}
+}
+
+func (c *allowanceCache) get(sessionID string, now time.Time) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[sessionID]
+	if !ok || now.Sub(e.at) >= allowanceCacheTTL {
+		return 0, false
+	}
+	if e.seconds == noLimit {
+		return noLimit, true
+	}
+	return max(e.seconds-int(now.Sub(e.at).Seconds()), 0), true
+}
+
+// put records an allowance, dropping expired entries at most once per TTL.
+func (c *allowanceCache) put(sessionID string, seconds int, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.swept) >= allowanceCacheTTL {
+		for id, e := range c.entries {
+			if now.Sub(e.at) >= allowanceCacheTTL {
+				delete(c.entries, id)
+			}
+		}
+		c.swept = now
+	}
+	c.entries[sessionID] = allowanceEntry{seconds: seconds, at: now}
+}
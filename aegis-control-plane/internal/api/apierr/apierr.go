@@ -0,0 +1,169 @@
+// Package apierr is the catalogue of error codes the API returns in
+// error.code. Each code has one HTTP status and a default message; clients
+// branch on the code, not the message. GET /api/v1/errors serves the
+// catalogue.
+package apierr
+
+import (
+	"net/http"
+	"slices"
+)
+
+type Code string
+
+const (
+	InvalidRequest         Code = "invalid_request"
+	UnsupportedRegion      Code = "unsupported_region"
+	Unauthorized           Code = "unauthorized"
+	Forbidden              Code = "forbidden"
+	UsageExhausted         Code = "usage_exhausted"
+	NotFound               Code = "not_found"
+	IdempotencyMismatch    Code = "idempotency_mismatch"
+	SessionStopping        Code = "session_stopping"
+	SessionNotActive       Code = "session_not_active"
+	SessionLimitReached    Code = "session_limit_reached"
+	ProvisioningInProgress Code = "provisioning_in_progress"
+	IPLockDisabled         Code = "ip_lock_disabled"
+	WebhookLimit           Code = "webhook_limit"
+	UserSessionsLive       Code = "user_sessions_live"
+	AdminSessionLimit      Code = "admin_session_limit"
+	ConfirmationRequired   Code = "confirmation_required"
+	InvalidConfig          Code = "invalid_config"
+	InvalidField           Code = "invalid_field"
+	InvalidStopReason      Code = "invalid_stop_reason"
+	RateLimited            Code = "rate_limited"
+	InternalError          Code = "internal_error"
+	ManifestUnavailable    Code = "manifest_unavailable"
+	ProviderUnavailable    Code = "provider_unavailable"
+	RegionUnavailable      Code = "region_unavailable"
+	ProvisionQueueFull     Code = "provision_queue_full"
+	StaticIPUnavailable    Code = "static_ip_unavailable"
+	ProviderThrottled      Code = "provider_throttled"
+	ProviderQuotaExceeded  Code = "provider_quota_exceeded"
+	RelayImageUnavailable  Code = "relay_image_unavailable"
+	ProviderAuthFailed     Code = "provider_auth_failed"
+	ServerDraining         Code = "server_draining"
+	StoreTimeout           Code = "store_timeout"
+	RequestTimeout         Code = "request_timeout"
+)
+
+// Entry describes a code. Message is what responses carry when the handler
+// has nothing more specific to say.
+type Entry struct {
+	Code        Code   `json:"code"`
+	Status      int    `json:"status"`
+	Message     string `json:"message"`
+	Description string `json:"description"`
+}
+
+var catalogue = []Entry{
+	{InvalidRequest, http.StatusBadRequest, "invalid request",
+		"The request is malformed: bad JSON, a missing or invalid field, header or query parameter."},
+	{UnsupportedRegion, http.StatusBadRequest, "region is not supported",
+		"region_preference names a region relays cannot start in, or one operators have disabled for new sessions; GET /api/v1/relay/manifest lists the supported ones."},
+	{Unauthorized, http.StatusUnauthorized, "unauthorized",
+		"The bearer token (or, for relay endpoints, X-Relay-Auth) is missing or invalid."},
+	{Forbidden, http.StatusForbidden, "forbidden",
+		"The caller is authenticated but the endpoint requires the admin role."},
+	{UsageExhausted, http.StatusForbidden, "included relay time is used up",
+		"A free-tier user has used the cycle's included relay time; starts are refused until the next cycle or an upgrade."},
+	{NotFound, http.StatusNotFound, "not found",
+		"The resource does not exist or belongs to another user."},
+	{IdempotencyMismatch, http.StatusConflict, "same key used with different payload",
+		"The Idempotency-Key was already used for a different request."},
+	{SessionStopping, http.StatusConflict, "previous relay session is still stopping",
+		"The previous session is still terminating its relay; retry once it has stopped."},
+	{SessionNotActive, http.StatusConflict, "session is not active",
+		"The session has stopped, so the operation no longer applies to it."},
+	{SessionLimitReached, http.StatusConflict, "live session limit reached",
+		"The caller already has as many live sessions as their plan allows; stop one first."},
+	{ProvisioningInProgress, http.StatusConflict, "relay is still provisioning",
+		"Another start of the caller's session is still launching its relay; retry shortly or watch GET /api/v1/relay/active."},
+	{IPLockDisabled, http.StatusConflict, "relay is not locked to a client IP",
+		"The relay accepts any client address, so there is no IP lock to move."},
+	{WebhookLimit, http.StatusConflict, "webhook limit reached",
+		"The caller already has the maximum number of webhooks."},
+	{UserSessionsLive, http.StatusConflict, "user still has a live session",
+		"For an erasure, a session of the user started while their data was being erased, so nothing was erased; retry the erasure. For an admin start, pass force=true to start alongside the user's session."},
+	{AdminSessionLimit, http.StatusConflict, "admin session limit reached",
+		"AEGIS_ADMIN_MAX_LIVE_SESSIONS sessions started by admins are already live; stop one first."},
+	{ConfirmationRequired, http.StatusPreconditionRequired, "confirmation token is missing, invalid or expired",
+		"Erasing a user's data needs a fresh X-Confirmation-Token from POST /api/v1/admin/users/{id}/data/erasure-token."},
+	{InvalidConfig, http.StatusUnprocessableEntity, "invalid configuration",
+		"A configuration reload was rejected; the running configuration is unchanged."},
+	{InvalidField, http.StatusUnprocessableEntity, "a field has an invalid value",
+		"The request is well formed but a field holds a value that cannot be stored, e.g. an IP address that does not parse or a region name in the wrong form. The message names the field."},
+	{InvalidStopReason, http.StatusUnprocessableEntity, "stop reason is not recognised",
+		"reason on POST /api/v1/relay/stop must be user_requested, stream_ended, switching_region or other; leave it out for user_requested."},
+	{RateLimited, http.StatusTooManyRequests, "too many requests",
+		"The caller repeated a limited request too soon, e.g. a second data export within the hour. Retry after Retry-After."},
+	{InternalError, http.StatusInternalServerError, "internal error",
+		"The server failed to complete the request; it is safe to retry idempotent requests."},
+	{ManifestUnavailable, http.StatusServiceUnavailable, "relay manifest is not configured",
+		"No relay regions are configured yet. Retry after Retry-After."},
+	{ProviderUnavailable, http.StatusServiceUnavailable, "relay provider is temporarily unavailable",
+		"The cloud provider API is failing in the region and calls are short-circuited; the session was stopped. Retry after Retry-After."},
+	{RegionUnavailable, http.StatusServiceUnavailable, "no relay capacity is available in the region",
+		"No capacity was found in the region or any fallback region; the session was stopped. Retry after Retry-After."},
+	{ProvisionQueueFull, http.StatusServiceUnavailable, "too many relays are starting in the region",
+		"No provision slot freed up in the region in time; the session was stopped. Retry after Retry-After."},
+	{StaticIPUnavailable, http.StatusServiceUnavailable, "no static IP is available for the relay",
+		"static_ip was requested but no address could be obtained; the session was stopped. Retry after Retry-After."},
+	{ProviderThrottled, http.StatusServiceUnavailable, "relay provider is rate limiting requests",
+		"The cloud provider throttled the launch; the session was stopped. Retry after Retry-After."},
+	{ProviderQuotaExceeded, http.StatusServiceUnavailable, "relay provider account limit reached",
+		"The provider account is at an instance or vCPU limit; the session was stopped. Capacity frees up as relays stop; operators are alerted to raise the limit. Retry after Retry-After."},
+	{ServerDraining, http.StatusServiceUnavailable, "server is shutting down",
+		"This API instance is draining before a restart and takes no new starts; nothing was created. Retry after Retry-After, which reaches another instance."},
+	{RelayImageUnavailable, http.StatusBadGateway, "relay image is not available in the region",
+		"The region's relay image is missing or unknown to the provider. Retrying does not help until operators fix the configuration; the session was stopped."},
+	{ProviderAuthFailed, http.StatusBadGateway, "relay provider rejected the control plane's credentials",
+		"The provider refused the control plane's credentials or permissions. Retrying does not help until operators fix them; the session was stopped."},
+	{StoreTimeout, http.StatusGatewayTimeout, "database did not respond in time",
+		"A database operation ran out of time and was rolled back; it is safe to retry idempotent requests."},
+	{RequestTimeout, http.StatusGatewayTimeout, "request timed out",
+		"The request ran past its route's time budget before a response was written; it is safe to retry idempotent requests."},
+}
+
+// Catalogue returns every code, in a stable order.
+func Catalogue() []Entry {
+	return slices.Clone(catalogue)
+}
+
+// Lookup returns the entry for code.
+func Lookup(code Code) (Entry, bool) {
+	i := slices.IndexFunc(catalogue, func(e Entry) bool { return e.Code == code })
+	if i < 0 {
+		return Entry{}, false
+	}
+	return catalogue[i], true
+}
+
+// Error is an error response: a catalogued code with its status.
+type Error struct {
+	Code    Code
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+// New returns the error for code, with its default message when message is
+// empty. An uncatalogued code is reported as a 500.
+func New(code Code, message string) *Error {
+	entry, ok := Lookup(code)
+	if !ok {
+		entry = Entry{Code: code, Status: http.StatusInternalServerError}
+	}
+	if message == "" {
+		message = entry.Message
+	}
+	return &Error{Code: code, Status: entry.Status, Message: message}
+}
+
+// Status returns the HTTP status of code.
+func (c Code) Status() int {
+	return New(c, "").Status
+}
@@ -0,0 +1,65 @@
+package apierr
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestCatalogue_CoversEveryCode(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "apierr.go", nil, 0)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var declared int
+	ast.Inspect(f, func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok {
+			return true
+		}
+		if typ, ok := spec.Type.(*ast.Ident); !ok || typ.Name != "Code" {
+			return true
+		}
+		for _, v := range spec.Values {
+			code, err := strconv.Unquote(v.(*ast.BasicLit).Value)
+			if err != nil {
+				t.Fatalf("unquote %s: %v", v.(*ast.BasicLit).Value, err)
+			}
+			declared++
+			if _, ok := Lookup(Code(code)); !ok {
+				t.Errorf("code %s is not catalogued", code)
+			}
+		}
+		return true
+	})
+
+	seen := map[Code]bool{}
+	for _, e := range Catalogue() {
+		if seen[e.Code] {
+			t.Errorf("code %s is catalogued twice", e.Code)
+		}
+		seen[e.Code] = true
+		if http.StatusText(e.Status) == "" || e.Message == "" || e.Description == "" {
+			t.Errorf("code %s is incomplete: %+v", e.Code, e)
+		}
+	}
+	if declared != len(seen) {
+		t.Errorf("%d codes declared but %d catalogued", declared, len(seen))
+	}
+}
+
+func TestNew_UsesCatalogueDefaults(t *testing.T) {
+	e := New(SessionNotActive, "")
+	if e.Status != http.StatusConflict || e.Message != "session is not active" {
+		t.Fatalf("unexpected defaults: %+v", e)
+	}
+	if e := New(NotFound, "webhook not found"); e.Status != http.StatusNotFound || e.Message != "webhook not found" {
+		t.Fatalf("unexpected error: %+v", e)
+	}
+	if got := Code("made_up").Status(); got != http.StatusInternalServerError {
+		t.Fatalf("expected an uncatalogued code to be a 500, got %d", got)
+	}
+}
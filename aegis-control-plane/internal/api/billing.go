@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+// handleAdminBillingExports shows the Stripe export status of users' cycles,
+// optionally for one user, status or cycle start.
+func (s *Server) handleAdminBillingExports(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := store.BillingExportFilter{UserID: q.Get("user_id"), Status: q.Get("status"), Limit: 50}
+	switch filter.Status {
+	case "", model.BillingExportPending, model.BillingExportReported, model.BillingExportFailed:
+	default:
+		writeAPIError(w, apierr.InvalidRequest, "unknown status filter")
+		return
+	}
+	if raw := q.Get("cycle_start"); raw != "" {
+		ts, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			writeAPIError(w, apierr.InvalidRequest, "cycle_start must be an RFC3339 timestamp")
+			return
+		}
+		filter.CycleStart = ts
+	}
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 500 {
+			writeAPIError(w, apierr.InvalidRequest, "limit must be between 1 and 500")
+			return
+		}
+		filter.Limit = n
+	}
+	exports, err := s.store.ListBillingExports(r.Context(), filter)
+	if err != nil {
+		writeStoreError(w, err, "failed to list billing exports")
+		return
+	}
+	out := make([]map[string]any, 0, len(exports))
+	for _, e := range exports {
+		item := map[string]any{
+			"user_id":                     e.UserID,
+			"cycle_start_at":              e.CycleStart.UTC().Format(time.RFC3339),
+			"cycle_end_at":                e.CycleEnd.UTC().Format(time.RFC3339),
+			"stripe_subscription_item_id": e.SubscriptionItemID,
+			"overage_seconds":             e.OverageSeconds,
+			"status":                      e.Status,
+			"attempts":                    e.Attempts,
+			"last_error":                  e.LastError,
+			"stripe_usage_record_id":      e.StripeUsageRecordID,
+			"updated_at":                  e.UpdatedAt.UTC().Format(time.RFC3339),
+		}
+		if e.Status == model.BillingExportPending {
+			item["next_attempt_at"] = e.NextAttemptAt.UTC().Format(time.RFC3339)
+		}
+		if e.ReportedAt != nil {
+			item["reported_at"] = e.ReportedAt.UTC().Format(time.RFC3339)
+		}
+		out = append(out, item)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"exports": out})
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+const (
+	// changesMaxWait bounds how long a changefeed read waits for new rows.
+	changesMaxWait      = 30 * time.Second
+	changesDefaultLimit = 100
+	changesMaxLimit     = 1000
+)
+
+// changesPollInterval is how often a waiting changefeed read looks for new
+// rows; the jobs worker and other API instances write them too.
+var changesPollInterval = time.Second
+
+var consumerNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+type sessionChange struct {
+	Seq            int64   `json:"seq"`
+	SessionID      string  `json:"session_id"`
+	UserID         string  `json:"user_id"`
+	Region         string  `json:"region"`
+	Status         string  `json:"status"`
+	PreviousStatus *string `json:"previous_status"`
+	StopReason     *string `json:"stop_reason"`
+	ChangedAt      string  `json:"changed_at"`
+}
+
+func toSessionChange(c model.SessionChange) sessionChange {
+	out := sessionChange{
+		Seq:       c.Seq,
+		SessionID: c.SessionID,
+		UserID:    c.UserID,
+		Region:    c.Region,
+		Status:    string(c.Status),
+		ChangedAt: c.ChangedAt.UTC().Format(time.RFC3339Nano),
+	}
+	if c.PreviousStatus != "" {
+		prev := string(c.PreviousStatus)
+		out.PreviousStatus = &prev
+	}
+	if c.StopReason != "" {
+		out.StopReason = &c.StopReason
+	}
+	return out
+}
+
+// mountInternal registers the routes for internal consumers under v1.
+func (s *Server) mountInternal(v1 chi.Router) {
+	v1.With(s.internalAuth).Route("/internal", func(internal chi.Router) {
+		// Reads wait for new rows outside the request timeout, bounded by
+		// changesMaxWait instead.
+		internal.With(extendWriteDeadline(changesMaxWait)).Get("/changes", s.handleInternalChanges)
+	})
+}
+
+// internalAuth admits requests bearing AEGIS_INTERNAL_TOKEN, read on each
+// request so that reloads rotate it.
+func (s *Server) internalAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := s.config().InternalToken
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if want == "" || !ok || subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+			writeAPIError(w, apierr.Unauthorized, "invalid internal token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleInternalChanges returns the session changefeed after the change
+// after_seq, in commit order. With wait it holds the request until rows
+// arrive or wait passes. A named consumer's after_seq acknowledges the rows
+// up to it, so the retention job may delete them.
+func (s *Server) handleInternalChanges(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var afterSeq int64
+	if raw := q.Get("after_seq"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			writeAPIError(w, apierr.InvalidRequest, "after_seq must be a non-negative integer")
+			return
+		}
+		afterSeq = n
+	}
+	limit := changesDefaultLimit
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > changesMaxLimit {
+			writeAPIError(w, apierr.InvalidRequest, "limit must be between 1 and 1000")
+			return
+		}
+		limit = n
+	}
+	var wait time.Duration
+	if raw := q.Get("wait"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 || time.Duration(n)*time.Second > changesMaxWait {
+			writeAPIError(w, apierr.InvalidRequest, "wait must be between 0 and 30 seconds")
+			return
+		}
+		wait = time.Duration(n) * time.Second
+	}
+	consumer := q.Get("consumer")
+	if consumer != "" && !consumerNamePattern.MatchString(consumer) {
+		writeAPIError(w, apierr.InvalidRequest, "consumer must be lower-case letters, digits, '-' or '_'")
+		return
+	}
+
+	if consumer != "" && afterSeq > 0 {
+		if err := s.store.AckSessionChanges(r.Context(), consumer, afterSeq); err != nil {
+			writeStoreError(w, err, "failed to acknowledge changes")
+			return
+		}
+	}
+
+	changes, err := s.store.ListSessionChanges(r.Context(), afterSeq, limit)
+	deadline := time.Now().Add(wait)
+	for err == nil && len(changes) == 0 && time.Until(deadline) > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.streamCtx.Done():
+			// Answer now rather than hold up shutdown.
+			deadline = time.Now()
+			continue
+		case <-time.After(min(changesPollInterval, time.Until(deadline))):
+		}
+		changes, err = s.store.ListSessionChanges(r.Context(), afterSeq, limit)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, apierr.NotFound, "the change after_seq is no longer retained; read again from 0")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err, "failed to read changes")
+		return
+	}
+
+	next := afterSeq
+	out := make([]sessionChange, 0, len(changes))
+	for _, c := range changes {
+		out = append(out, toSessionChange(c))
+		next = c.Seq
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"changes": out, "next_seq": next})
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// debugRoutes mounts pprof, expvar and build info. Profiles and traces run
+// for as long as their seconds parameter asks, so none of these routes use
+// the request timeout or the server-wide write deadline.
+func (s *Server) debugRoutes(r chi.Router) {
+	r.Use(clearWriteDeadline)
+	r.Get("/buildinfo", s.handleBuildInfo)
+	r.Method(http.MethodGet, "/vars", expvar.Handler())
+	r.Get("/pprof/", pprof.Index)
+	r.Get("/pprof/cmdline", pprof.Cmdline)
+	r.Get("/pprof/profile", pprof.Profile)
+	r.Get("/pprof/symbol", pprof.Symbol)
+	r.Post("/pprof/symbol", pprof.Symbol)
+	r.Get("/pprof/trace", pprof.Trace)
+	// Named profiles: goroutine, heap, allocs, block, mutex, threadcreate.
+	r.Get("/pprof/{profile}", pprof.Index)
+}
+
+func clearWriteDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleBuildInfo(w http.ResponseWriter, _ *http.Request) {
+	out := map[string]any{"go_version": runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		out["module"] = info.Main.Path
+		out["version"] = info.Main.Version
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				out["vcs_revision"] = setting.Value
+			case "vcs.time":
+				out["vcs_time"] = setting.Value
+			case "vcs.modified":
+				out["vcs_modified"] = setting.Value == "true"
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
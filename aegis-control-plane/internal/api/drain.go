@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// drainRetryAfter is the Retry-After of starts refused while draining; by
+// then the load balancer sends the retry to another instance.
+const drainRetryAfter = 5 * time.Second
+
+// Drain is flipped before shutdown so the API stops taking new work while
+// requests in flight finish. The zero value is not draining.
+type Drain struct {
+	draining atomic.Bool
+}
+
+// Start makes /relay/start refuse new sessions and /readyz report not
+// ready. It cannot be undone.
+func (d *Drain) Start() {
+	d.draining.Store(true)
+}
+
+func (d *Drain) Draining() bool {
+	return d.draining.Load()
+}
+
+// WithDrain lets the caller put the API into drain mode through d.
+func WithDrain(d *Drain) RouterOption {
+	return func(s *Server) {
+		s.drain = d
+	}
+}
+
+// handleReadyz tells the load balancer whether to send the API traffic.
+func (s *Server) handleReadyz(w http.ResponseWriter, _ *http.Request) {
+	if s.drain.Draining() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "draining"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ready"})
+}
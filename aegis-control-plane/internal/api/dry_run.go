@@ -0,0 +1,137 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/session"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+// Outcomes of a dry-run start.
+const (
+	dryRunCreate   = "create"
+	dryRunExisting = "existing"
+	dryRunReplay   = "replay"
+)
+
+// dryRunResponse is what a start with dry_run would have done. Outcome is
+// empty when Error would have refused it.
+type dryRunResponse struct {
+	DryRun           bool         `json:"dry_run"`
+	Outcome          string       `json:"outcome,omitempty"`
+	SessionID        string       `json:"session_id,omitempty"`
+	Region           string       `json:"region"`
+	InstanceType     string       `json:"instance_type,omitempty"`
+	RemainingSeconds *int         `json:"remaining_seconds"`
+	Error            *dryRunError `json:"error"`
+}
+
+// dryRunError is the error a real start would have returned, with its
+// status.
+type dryRunError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Status  int    `json:"status"`
+}
+
+func dryRunErrorFor(e *apierr.Error) *dryRunError {
+	return &dryRunError{Code: string(e.Code), Message: e.Message, Status: e.Status}
+}
+
+// dryRunStart answers a start with dry_run. It makes the checks a start
+// makes before launching a relay, in the same order, without recording
+// anything, and reports the first that would refuse it. Failures only the
+// provider can report, such as missing capacity, are not foreseen.
+func (s *Server) dryRunStart(w http.ResponseWriter, r *http.Request, cmd session.StartCommand) {
+	resp := dryRunResponse{DryRun: true, Region: cmd.Region}
+	block := func(e *apierr.Error) {
+		if resp.Error == nil {
+			resp.Error = dryRunErrorFor(e)
+		}
+	}
+	if s.drain.Draining() {
+		block(apierr.New(apierr.ServerDraining, ""))
+	}
+
+	usage, err := s.store.PreviewUsageCurrent(r.Context(), cmd.UserID, s.config().FreeIncludedSeconds)
+	switch {
+	case err == nil:
+		resp.RemainingSeconds = &usage.RemainingSeconds
+		// Admin starts are not billed, so used-up time does not stop them.
+		if cmd.StartedByAdmin == "" && planRemaining(usage) == 0 {
+			block(apierr.New(apierr.UsageExhausted, ""))
+		}
+	case !errors.Is(err, store.ErrNotFound):
+		log.Printf("event=relay_start_usage_check_failed user_id=%s err=%v", cmd.UserID, err)
+	}
+
+	preview, err := s.sessions.Preview(r.Context(), cmd)
+	switch {
+	case err != nil:
+		e, _ := startError(err)
+		block(e)
+	case preview.Existing != nil:
+		resp.Outcome, resp.SessionID, resp.Region = dryRunExisting, preview.Existing.ID, preview.Existing.Region
+		if preview.Replayed {
+			resp.Outcome = dryRunReplay
+		}
+		if !preview.Replayed && preview.Existing.Status == model.SessionProvisioning {
+			block(apierr.New(apierr.ProvisioningInProgress, ""))
+		}
+	default:
+		resp.Outcome = dryRunCreate
+	}
+
+	// Only a new session launches a relay, so only it needs the manifest.
+	if resp.Outcome == dryRunCreate || resp.Outcome == "" {
+		manifest, err := s.store.ListRelayManifest(r.Context())
+		if err != nil {
+			writeStoreError(w, err, "failed to read relay manifest")
+			return
+		}
+		if e := manifestBlock(manifest, cmd, &resp); e != nil {
+			block(e)
+		}
+	}
+	if resp.Error != nil {
+		resp.Outcome = ""
+	}
+
+	code := ""
+	if resp.Error != nil {
+		code = resp.Error.Code
+	}
+	log.Printf("event=relay_start_dry_run user_id=%s region=%s outcome=%s error=%s", cmd.UserID, resp.Region, resp.Outcome, code)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// manifestBlock sets the instance type a launch in cmd's region would use
+// and returns the error a start would fail with when the manifest cannot
+// launch one there.
+func manifestBlock(manifest []model.RelayManifestEntry, cmd session.StartCommand, resp *dryRunResponse) *apierr.Error {
+	if len(manifest) == 0 {
+		return apierr.New(apierr.ManifestUnavailable, "relay manifest is not configured")
+	}
+	for _, entry := range manifest {
+		if entry.Region != cmd.Region {
+			continue
+		}
+		resp.InstanceType = entry.DefaultInstanceType
+		if cmd.InstanceType != "" {
+			resp.InstanceType = cmd.InstanceType
+		}
+		switch {
+		case !entry.Available:
+			return apierr.New(apierr.RegionUnavailable, fmt.Sprintf("region %s is not available", cmd.Region))
+		case entry.AMIID == "":
+			return apierr.New(apierr.RelayImageUnavailable, "")
+		}
+		return nil
+	}
+	return apierr.New(apierr.RegionUnavailable, fmt.Sprintf("region %s is not in the relay manifest", cmd.Region))
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/auth"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/session"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+// erasureTokenTTL is how long a confirmation token for erasing a user's data
+// stays valid.
+const erasureTokenTTL = 10 * time.Minute
+
+// erasureToken confirms that adminID erases userID's data, until expires.
+// Tokens are stateless: an HMAC under the JWT secret, prefixed with their
+// expiry in Unix seconds.
+func erasureToken(secret, adminID, userID string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "erase-user-data:%s:%s:%s", adminID, userID, exp)
+	return exp + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func validErasureToken(secret, adminID, userID, token string, now time.Time) bool {
+	exp, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || !now.Before(time.Unix(unix, 0)) {
+		return false
+	}
+	want := erasureToken(secret, adminID, userID, time.Unix(unix, 0))
+	return hmac.Equal([]byte(token), []byte(want))
+}
+
+// handleAdminErasureToken issues the confirmation token that DELETE
+// /admin/users/{id}/data requires, so an erasure takes two deliberate calls.
+func (s *Server) handleAdminErasureToken(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "id")
+	adminID, _ := auth.UserIDFromContext(r.Context())
+	expires := time.Now().Add(erasureTokenTTL)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"user_id":            userID,
+		"confirmation_token": erasureToken(s.config().JWTSecret, adminID, userID, expires),
+		"expires_at":         expires.UTC().Format(time.RFC3339),
+	})
+}
+
+// handleAdminEraseUserData stops the user's live sessions and anonymizes
+// their data, keeping usage totals under a tombstone user. Repeating it
+// returns the first erasure.
+func (s *Server) handleAdminEraseUserData(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "id")
+	adminID, _ := auth.UserIDFromContext(r.Context())
+	if !validErasureToken(s.config().JWTSecret, adminID, userID, r.Header.Get("X-Confirmation-Token"), time.Now()) {
+		writeAPIError(w, apierr.ConfirmationRequired, "")
+		return
+	}
+	live, err := s.store.ListLiveSessions(r.Context(), userID, "")
+	if err != nil {
+		writeStoreError(w, err, "failed to load sessions")
+		return
+	}
+	for _, sess := range live {
+		if sess.Status == model.SessionStopping {
+			continue
+		}
+		_, err := s.sessions.Stop(r.Context(), session.StopCommand{UserID: userID, SessionID: sess.ID, Reason: model.StopReasonErasure})
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			writeStoreError(w, err, "failed to stop session")
+			return
+		}
+	}
+	erasure, erased, err := s.store.EraseUserData(r.Context(), userID, adminID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			writeAPIError(w, apierr.NotFound, "user not found")
+		case errors.Is(err, store.ErrUserSessionsLive):
+			writeAPIError(w, apierr.UserSessionsLive, "")
+		default:
+			writeStoreError(w, err, "failed to erase user data")
+		}
+		return
+	}
+	if erased {
+		log.Printf("event=admin_user_data_erased tombstone_user_id=%s admin_id=%s sessions_stopped=%d rows=%v",
+			erasure.TombstoneUserID, adminID, len(live), erasure.Rows)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"erasure": map[string]any{
+		"tombstone_user_id": erasure.TombstoneUserID,
+		"requested_by":      erasure.RequestedBy,
+		"erased_at":         erasure.ErasedAt.UTC().Format(time.RFC3339),
+		"rows":              erasure.Rows,
+		"replayed":          !erased,
+	}})
+}
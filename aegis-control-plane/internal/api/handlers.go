@@ -2,20 +2,29 @@ package api
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"slices"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
 	"github.com/telemyapp/aegis-control-plane/internal/auth"
 	"github.com/telemyapp/aegis-control-plane/internal/metrics"
 	"github.com/telemyapp/aegis-control-plane/internal/model"
 	"github.com/telemyapp/aegis-control-plane/internal/relay"
+	"github.com/telemyapp/aegis-control-plane/internal/session"
 	"github.com/telemyapp/aegis-control-plane/internal/store"
+	"github.com/telemyapp/aegis-control-plane/internal/version"
 )
 
 type relayStartRequest struct {
@@ -24,12 +33,28 @@ type relayStartRequest struct {
 		OBSConnected bool   `json:"obs_connected"`
 		Mode         string `json:"mode"`
 		RequestedBy  string `json:"requested_by"`
+		// Tags label the session; see model.NormalizeSessionTags.
+		Tags []string `json:"tags,omitempty"`
 	} `json:"client_context"`
+	// StaticIP requests a relay with a stable public IP (Elastic IP).
+	StaticIP bool `json:"static_ip,omitempty"`
+	// DryRun, like ?dry_run=true, only reports what the start would do.
+	// It is not part of the request hash.
+	DryRun bool `json:"dry_run,omitempty"`
 }
 
 type relayStopRequest struct {
 	SessionID string `json:"session_id"`
-	Reason    string `json:"reason"`
+	// Reason is one of model.ClientStopReasons, user_requested when empty.
+	Reason string `json:"reason,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type relayInterruptionRequest struct {
+	SessionID  string `json:"session_id"`
+	InstanceID string `json:"instance_id"`
+	Action     string `json:"action"`
+	NoticeTime string `json:"notice_time"`
 }
 
 type relayHealthRequest struct {
@@ -39,125 +64,152 @@ type relayHealthRequest struct {
 	EgressActive         bool   `json:"egress_active"`
 	SessionUptimeSeconds int    `json:"session_uptime_seconds"`
 	ObservedAt           string `json:"observed_at"`
+	AgentVersion         string `json:"agent_version,omitempty"`
 }
 
 func (s *Server) handleRelayStart(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.UserIDFromContext(r.Context())
 	if !ok {
-		writeAPIError(w, http.StatusUnauthorized, "unauthorized", "missing user identity")
+		writeAPIError(w, apierr.Unauthorized, "missing user identity")
+		return
+	}
+	cmd, dryRun, ok := s.decodeStart(w, r)
+	if !ok {
 		return
 	}
+	cmd.UserID = userID
+	if !s.applyRegionSwitch(w, r, &cmd, dryRun) {
+		return
+	}
+	if dryRun {
+		s.dryRunStart(w, r, cmd)
+		return
+	}
+	if s.drain.Draining() {
+		writeRetryAfter(w, http.StatusServiceUnavailable, apierr.ServerDraining, "", drainRetryAfter)
+		return
+	}
+	if s.usageExhausted(r.Context(), userID) {
+		writeAPIError(w, apierr.UsageExhausted, "")
+		return
+	}
+	s.startSession(w, r, cmd)
+}
 
+// decodeStart reads the Idempotency-Key and body of a start into a command
+// without its user, and whether the caller asked for a dry run. It writes
+// the error response and returns false when they are invalid.
+func (s *Server) decodeStart(w http.ResponseWriter, r *http.Request) (_ session.StartCommand, dryRun bool, _ bool) {
 	idemRaw := r.Header.Get("Idempotency-Key")
 	if idemRaw == "" {
-		writeAPIError(w, http.StatusBadRequest, "invalid_request", "Idempotency-Key is required")
-		return
+		writeAPIError(w, apierr.InvalidRequest, "Idempotency-Key is required")
+		return session.StartCommand{}, false, false
 	}
 	idem, err := parseIdempotencyKey(idemRaw)
 	if err != nil {
-		writeAPIError(w, http.StatusBadRequest, "invalid_request", "Idempotency-Key must be uuid-v4")
-		return
+		writeAPIError(w, apierr.InvalidRequest, "Idempotency-Key must be a version 4 uuid")
+		return session.StartCommand{}, false, false
 	}
 
 	var req relayStartRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeAPIError(w, http.StatusBadRequest, "invalid_request", "invalid JSON payload")
-		return
+		writeAPIError(w, apierr.InvalidRequest, "invalid JSON payload")
+		return session.StartCommand{}, false, false
+	}
+
+	dryRun = req.DryRun
+	if raw := r.URL.Query().Get("dry_run"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeAPIError(w, apierr.InvalidRequest, "dry_run must be true or false")
+			return session.StartCommand{}, false, false
+		}
+		dryRun = dryRun || v
 	}
 
-	region := s.resolveRegion(req.RegionPreference)
+	if pref := req.RegionPreference; pref != "" && pref != "auto" && !slices.Contains(s.config().SupportedRegion, pref) {
+		e := apierr.New(apierr.UnsupportedRegion, fmt.Sprintf("region %q is not supported", pref))
+		if dryRun {
+			writeJSON(w, http.StatusOK, dryRunResponse{DryRun: true, Region: pref, Error: dryRunErrorFor(e)})
+		} else {
+			writeError(w, e)
+		}
+		return session.StartCommand{}, false, false
+	}
 	requestedBy := req.ClientContext.RequestedBy
 	if requestedBy == "" {
 		requestedBy = "dashboard"
 	}
-
-	hash, err := store.HashJSON(req)
+	tags, err := model.NormalizeSessionTags(req.ClientContext.Tags)
 	if err != nil {
-		writeAPIError(w, http.StatusBadRequest, "invalid_request", "failed to hash request")
-		return
+		writeAPIError(w, apierr.InvalidField, err.Error())
+		return session.StartCommand{}, false, false
 	}
 
-	sess, created, err := s.store.StartOrGetSession(r.Context(), store.StartInput{
-		UserID:         userID,
-		Region:         region,
-		RequestedBy:    requestedBy,
-		IdempotencyKey: idem,
-		RequestHash:    hash,
+	// Only fields that change what a start does are hashed, so a retry
+	// across a deploy that adds fields still matches its key.
+	hash, err := store.CanonicalHash(map[string]any{
+		"region_preference": req.RegionPreference,
+		"client_context": map[string]any{
+			"requested_by": req.ClientContext.RequestedBy,
+			"tags":         tags,
+		},
+		"static_ip": req.StaticIP,
 	})
 	if err != nil {
-		switch {
-		case errors.Is(err, store.ErrIdempotencyMismatch):
-			writeAPIError(w, http.StatusConflict, "idempotency_mismatch", "same key used with different payload")
-		default:
-			writeAPIError(w, http.StatusInternalServerError, "internal_error", "failed to start relay session")
-		}
-		return
+		writeAPIError(w, apierr.InvalidRequest, "failed to hash request")
+		return session.StartCommand{}, false, false
 	}
+	legacyHash, err := store.HashJSON(req)
+	if err != nil {
+		writeAPIError(w, apierr.InvalidRequest, "failed to hash request")
+		return session.StartCommand{}, false, false
+	}
+	return session.StartCommand{
+		Region:            s.resolveRegion(req.RegionPreference),
+		RegionPreference:  req.RegionPreference,
+		RequestedBy:       requestedBy,
+		IdempotencyKey:    idem,
+		RequestHash:       hash,
+		LegacyRequestHash: legacyHash,
+		StaticIP:          req.StaticIP,
+		Tags:              tags,
+		ClientIP:          clientIP(r),
+		UserAgent:         r.UserAgent(),
+	}, dryRun, true
+}
 
-	if created {
-		compensateStop := func() {
-			if _, stopErr := s.store.StopSession(r.Context(), userID, sess.ID); stopErr != nil {
-				log.Printf("relay_start_compensation stop_session_failed session_id=%s user_id=%s err=%v", sess.ID, userID, stopErr)
-			}
-		}
-
-		provisionStart := time.Now()
-		prov, err := s.provisioner.Provision(r.Context(), relay.ProvisionRequest{
-			SessionID: sess.ID,
-			UserID:    userID,
-			Region:    sess.Region,
-		})
-		durMS := float64(time.Since(provisionStart).Milliseconds())
-		labels := map[string]string{
-			"provider": s.cfg.RelayProvider,
-			"region":   sess.Region,
-		}
-		if err != nil {
-			log.Printf("metric=relay_provision_latency_ms session_id=%s user_id=%s region=%s value=%d status=error", sess.ID, userID, sess.Region, time.Since(provisionStart).Milliseconds())
-			labels["status"] = "error"
-			metrics.Default().IncCounter("aegis_relay_provision_total", labels)
-			metrics.Default().ObserveHistogram("aegis_relay_provision_latency_ms", durMS, labels)
-			compensateStop()
-			writeAPIError(w, http.StatusInternalServerError, "internal_error", "relay provisioning failed")
-			return
-		}
-		log.Printf("metric=relay_provision_latency_ms session_id=%s user_id=%s region=%s value=%d status=ok", sess.ID, userID, sess.Region, time.Since(provisionStart).Milliseconds())
-		labels["status"] = "ok"
-		metrics.Default().IncCounter("aegis_relay_provision_total", labels)
-		metrics.Default().ObserveHistogram("aegis_relay_provision_latency_ms", durMS, labels)
+// provisioningRetryAfter is the Retry-After of a start that found the
+// user's session still provisioning.
+const provisioningRetryAfter = 5 * time.Second
 
-		pairToken, err := generatePairToken(8)
-		if err != nil {
-			s.compensateRelayStartProvisioned(r.Context(), sess, userID, prov)
-			writeAPIError(w, http.StatusInternalServerError, "internal_error", "token generation failed")
-			return
-		}
-		relayWSToken, err := generateRelayWSToken()
+// startSession runs cmd and writes the started session: 201 when it is new,
+// 202 when a replayed start's session is still provisioning, and 409 when
+// a start with another key finds the user's session provisioning.
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, cmd session.StartCommand) {
+	sess, created, err := s.sessions.Start(r.Context(), cmd)
+	if err != nil {
+		s.writeStartError(w, err)
+		return
+	}
+	if sess.Replayed {
+		// The replayed session is as first recorded; report it as it is now.
+		current, err := s.store.GetSessionByID(r.Context(), cmd.UserID, sess.ID)
 		if err != nil {
-			s.compensateRelayStartProvisioned(r.Context(), sess, userID, prov)
-			writeAPIError(w, http.StatusInternalServerError, "internal_error", "token generation failed")
+			writeStoreError(w, err, "failed to read session")
 			return
 		}
-
-		activatedSess, err := s.store.ActivateProvisionedSession(r.Context(), store.ActivateProvisionedSessionInput{
-			UserID:        userID,
-			SessionID:     sess.ID,
-			Region:        sess.Region,
-			AWSInstanceID: prov.AWSInstanceID,
-			AMIID:         prov.AMIID,
-			InstanceType:  prov.InstanceType,
-			PublicIP:      prov.PublicIP,
-			SRTPort:       prov.SRTPort,
-			WSURL:         prov.WSURL,
-			PairToken:     pairToken,
-			RelayWSToken:  relayWSToken,
-		})
-		if err != nil {
-			s.compensateRelayStartProvisioned(r.Context(), sess, userID, prov)
-			writeAPIError(w, http.StatusInternalServerError, "internal_error", "failed to activate relay session")
+		sess = current
+		if sess.Status == model.SessionProvisioning {
+			writeJSON(w, http.StatusAccepted, map[string]any{"session": toSessionResponse(sess)})
 			return
 		}
-		sess = activatedSess
+	}
+	if !created && sess.Status == model.SessionProvisioning {
+		payload := retryAfterError(w, apierr.ProvisioningInProgress, "", provisioningRetryAfter)
+		payload.Error.SessionID = sess.ID
+		writeJSON(w, http.StatusConflict, payload)
+		return
 	}
 
 	status := http.StatusOK
@@ -167,135 +219,476 @@ func (s *Server) handleRelayStart(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, status, map[string]any{"session": toSessionResponse(sess)})
 }
 
-func (s *Server) compensateRelayStartProvisioned(ctx context.Context, sess *model.Session, userID string, prov relay.ProvisionResult) {
-	if deprovErr := s.provisioner.Deprovision(ctx, relay.DeprovisionRequest{
-		SessionID:     sess.ID,
-		UserID:        userID,
-		Region:        sess.Region,
-		AWSInstanceID: prov.AWSInstanceID,
-	}); deprovErr != nil {
-		log.Printf("relay_start_compensation deprovision_failed session_id=%s user_id=%s instance_id=%s err=%v", sess.ID, userID, prov.AWSInstanceID, deprovErr)
+// writeStartError translates a failed session.Service.Start.
+func (s *Server) writeStartError(w http.ResponseWriter, err error) {
+	e, retryAfter := startError(err)
+	if e.Status == http.StatusServiceUnavailable {
+		if retryAfter <= 0 {
+			retryAfter = s.config().UnavailableRetryAfter
+		}
+		writeRetryAfter(w, e.Status, e.Code, e.Message, retryAfter)
+		return
+	}
+	writeError(w, e)
+}
+
+// startError maps a failed start to its error response and, for a 503, the
+// Retry-After to send; zero means the configured default.
+func startError(err error) (*apierr.Error, time.Duration) {
+	var unavailable *relay.UnavailableError
+	switch {
+	case errors.Is(err, store.ErrIdempotencyMismatch):
+		return apierr.New(apierr.IdempotencyMismatch, "same key used with different payload"), 0
+	case errors.Is(err, store.ErrSessionStopping):
+		return apierr.New(apierr.SessionStopping, "previous relay session is still stopping"), 0
+	case errors.Is(err, store.ErrSessionLimit):
+		return apierr.New(apierr.SessionLimitReached, ""), 0
+	case errors.Is(err, store.ErrAdminSessionLimit):
+		return apierr.New(apierr.AdminSessionLimit, ""), 0
+	case errors.Is(err, store.ErrUserSessionsLive):
+		return apierr.New(apierr.UserSessionsLive, "user already has a live session; pass force=true to start another"), 0
+	case errors.Is(err, store.ErrStoreTimeout):
+		return apierr.New(apierr.StoreTimeout, ""), 0
+	case errors.Is(err, session.ErrProvisionQueueFull):
+		return apierr.New(apierr.ProvisionQueueFull, "too many relays are starting in the region"), 0
+	case errors.Is(err, relay.ErrStaticIPUnavailable):
+		return apierr.New(apierr.StaticIPUnavailable, "no static IP is available for the relay"), 0
+	case errors.As(err, &unavailable):
+		return apierr.New(apierr.ProviderUnavailable, "relay provider is temporarily unavailable"), unavailable.RetryAfter
+	case errors.Is(err, relay.ErrRegionUnavailable), errors.Is(err, relay.ErrNoCapacity):
+		return apierr.New(apierr.RegionUnavailable, "no relay capacity is available in the region"), 0
+	case errors.Is(err, relay.ErrProviderThrottled):
+		return apierr.New(apierr.ProviderThrottled, ""), 0
+	case errors.Is(err, relay.ErrQuotaExceeded):
+		return apierr.New(apierr.ProviderQuotaExceeded, ""), 0
+	case errors.Is(err, relay.ErrImageUnavailable):
+		return apierr.New(apierr.RelayImageUnavailable, ""), 0
+	case errors.Is(err, relay.ErrProviderAuth):
+		return apierr.New(apierr.ProviderAuthFailed, ""), 0
+	case errors.Is(err, session.ErrTokens):
+		return apierr.New(apierr.InternalError, "token generation failed"), 0
+	case errors.Is(err, session.ErrProvision):
+		return apierr.New(apierr.InternalError, "relay provisioning failed"), 0
+	case errors.Is(err, session.ErrActivate):
+		return apierr.New(apierr.InternalError, "failed to activate relay session"), 0
+	case errors.Is(err, store.ErrNotFound):
+		// Only admin starts name a user that may not exist.
+		return apierr.New(apierr.NotFound, "user not found"), 0
+	default:
+		return apierr.New(apierr.InternalError, "failed to start relay session"), 0
+	}
+}
+
+// handleRelayAuthorizeIP moves the IP lock of the caller's relay to the
+// address of this request, for streamers whose IP changed mid-session.
+func (s *Server) handleRelayAuthorizeIP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierr.Unauthorized, "missing user identity")
+		return
+	}
+	ip := clientIP(r)
+	if ip == "" {
+		writeAPIError(w, apierr.InvalidRequest, "client IP could not be determined")
+		return
 	}
-	if _, stopErr := s.store.StopSession(ctx, userID, sess.ID); stopErr != nil {
-		log.Printf("relay_start_compensation stop_session_failed session_id=%s user_id=%s err=%v", sess.ID, userID, stopErr)
+	access, err := s.store.GetActiveRelayAccess(r.Context(), userID)
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, apierr.NotFound, "no active relay")
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err, "failed to query active relay")
+		return
+	}
+	if access.SecurityGroupID == "" {
+		writeAPIError(w, apierr.IPLockDisabled, "relay is not locked to a client IP")
+		return
 	}
+	err = s.provisioner.AuthorizeClientIP(r.Context(), relay.AuthorizeClientIPRequest{
+		Region:          access.Region,
+		SecurityGroupID: access.SecurityGroupID,
+		ClientIP:        ip,
+		SRTPorts:        access.SRTPorts,
+		Provider:        access.Provider,
+	})
+	if err != nil {
+		log.Printf("event=relay_authorize_ip_failed session_id=%s user_id=%s group_id=%s err=%q", access.SessionID, userID, access.SecurityGroupID, err.Error())
+		writeAPIError(w, apierr.InternalError, "failed to authorize client IP")
+		return
+	}
+	// A replacement relay is locked to the recorded IP, so a stale record
+	// fails the request; retrying re-applies the same rules.
+	if err := s.store.UpdateRelayAllowedClientIP(r.Context(), access.RelayInstanceID, ip); err != nil {
+		writeStoreError(w, err, "failed to record client IP")
+		return
+	}
+	log.Printf("event=relay_client_ip_authorized session_id=%s user_id=%s client_ip=%s", access.SessionID, userID, ip)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"session_id": access.SessionID,
+		"client_ip":  ip,
+	})
 }
 
 func (s *Server) handleRelayActive(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.UserIDFromContext(r.Context())
 	if !ok {
-		writeAPIError(w, http.StatusUnauthorized, "unauthorized", "missing user identity")
+		writeAPIError(w, apierr.Unauthorized, "missing user identity")
 		return
 	}
 	sess, err := s.store.GetActiveSession(r.Context(), userID)
+	if errors.Is(err, store.ErrNotFound) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "internal_error", "failed to query active session")
+		writeStoreError(w, err, "failed to query active session")
 		return
 	}
-	if sess == nil {
-		w.WriteHeader(http.StatusNoContent)
+	resp := toSessionResponse(sess)
+	if s.config().MaskSessionCredentials {
+		resp["credentials"] = maskedCredentials(sess)
+	}
+	if warning := s.usageWarning(r.Context(), userID); warning != nil {
+		resp["usage_warning"] = warning
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"session": resp})
+}
+
+// handleListSessions lists the caller's live sessions, newest first, for
+// plans that allow more than one. status narrows them to one state.
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierr.Unauthorized, "missing user identity")
+		return
+	}
+	status := model.SessionStatus(r.URL.Query().Get("status"))
+	switch status {
+	case "", model.SessionProvisioning, model.SessionActive, model.SessionGrace, model.SessionStopping:
+	default:
+		writeAPIError(w, apierr.InvalidRequest, "status must be a live session status")
+		return
+	}
+	tag, ok := tagFilter(w, r)
+	if !ok {
+		return
+	}
+	sessions, err := s.store.ListLiveSessions(r.Context(), userID, tag)
+	if err != nil {
+		writeStoreError(w, err, "failed to list sessions")
+		return
+	}
+	mask := s.config().MaskSessionCredentials
+	out := make([]map[string]any, 0, len(sessions))
+	for i := range sessions {
+		sess := &sessions[i]
+		if status != "" && sess.Status != status {
+			continue
+		}
+		resp := toSessionResponse(sess)
+		if mask {
+			resp["credentials"] = maskedCredentials(sess)
+		}
+		out = append(out, resp)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"sessions": out})
+}
+
+// usageWarning summarizes the highest usage threshold the user has reached,
+// or nil. It is advisory, so a failed usage read leaves it out rather than
+// failing the session read.
+func (s *Server) usageWarning(ctx context.Context, userID string) map[string]any {
+	cfg := s.config()
+	if len(cfg.UsageAlertThresholds) == 0 {
+		return nil
+	}
+	usage, err := s.store.GetUsageCurrent(ctx, userID, cfg.FreeIncludedSeconds)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("event=usage_warning_failed user_id=%s err=%v", userID, err)
+		}
+		return nil
+	}
+	alerts := usageAlerts(usage, cfg.UsageAlertThresholds)
+	if len(alerts) == 0 {
+		return nil
+	}
+	top := alerts[len(alerts)-1]
+	return map[string]any{
+		"threshold_percent": top.ThresholdPercent,
+		"level":             top.Level,
+		"remaining_seconds": usage.RemainingSeconds,
+	}
+}
+
+type usageAlert struct {
+	ThresholdPercent int    `json:"threshold_percent"`
+	Level            string `json:"level"`
+}
+
+// usageAlerts lists the thresholds, in percent of included seconds, that
+// usage has reached: "exhausted" from 100% on, "warning" below.
+func usageAlerts(usage *model.UsageCurrent, thresholds []int) []usageAlert {
+	alerts := []usageAlert{}
+	if usage.IncludedSeconds <= 0 {
+		return alerts
+	}
+	for _, t := range thresholds {
+		if usage.ConsumedSeconds*100 < usage.IncludedSeconds*t {
+			continue
+		}
+		level := "warning"
+		if t >= 100 {
+			level = "exhausted"
+		}
+		alerts = append(alerts, usageAlert{ThresholdPercent: t, Level: level})
+	}
+	return alerts
+}
+
+// handleSessionCredentials returns a session's full credentials for clients
+// that only see them masked elsewhere. Every fetch is recorded as a session
+// event.
+func (s *Server) handleSessionCredentials(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierr.Unauthorized, "missing user identity")
+		return
+	}
+	sess, err := s.store.GetSessionByID(r.Context(), userID, chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeAPIError(w, apierr.NotFound, "session not found")
+			return
+		}
+		writeStoreError(w, err, "failed to load session")
+		return
+	}
+	if sess.Status == model.SessionStopped {
+		writeAPIError(w, apierr.SessionNotActive, "session is stopped")
+		return
+	}
+	requestID := middleware.GetReqID(r.Context())
+	if err := s.store.RecordSessionEvent(r.Context(), sess.ID, userID, "credentials_fetched", map[string]any{
+		"session_id": sess.ID,
+		"request_id": requestID,
+		"client_ip":  clientIP(r),
+	}); err != nil {
+		log.Printf("event=session_credentials_audit_failed session_id=%s user_id=%s request_id=%s err=%q", sess.ID, userID, requestID, err.Error())
+		writeStoreError(w, err, "failed to load credentials")
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"session": toSessionResponse(sess)})
+	log.Printf("event=session_credentials_fetched session_id=%s user_id=%s request_id=%s", sess.ID, userID, requestID)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"session_id": sess.ID,
+		"credentials": map[string]any{
+			"pair_token":     sess.PairToken,
+			"relay_ws_token": sess.RelayWSToken,
+		},
+	})
 }
 
 func (s *Server) handleRelayStop(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.UserIDFromContext(r.Context())
 	if !ok {
-		writeAPIError(w, http.StatusUnauthorized, "unauthorized", "missing user identity")
+		writeAPIError(w, apierr.Unauthorized, "missing user identity")
 		return
 	}
 
 	var req relayStopRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SessionID == "" {
-		writeAPIError(w, http.StatusBadRequest, "invalid_request", "session_id is required")
+		writeAPIError(w, apierr.InvalidRequest, "session_id is required")
 		return
 	}
 
-	curr, err := s.store.GetSessionByID(r.Context(), userID, req.SessionID)
-	if err != nil {
-		if errors.Is(err, store.ErrNotFound) {
-			writeAPIError(w, http.StatusNotFound, "not_found", "session not found")
-			return
-		}
-		writeAPIError(w, http.StatusInternalServerError, "internal_error", "failed to query session")
-		return
-	}
-	if curr.Status != model.SessionStopped && curr.RelayAWSInstanceID != "" {
-		deprovStart := time.Now()
-		if err := s.provisioner.Deprovision(r.Context(), relay.DeprovisionRequest{
-			SessionID:     curr.ID,
-			UserID:        curr.UserID,
-			Region:        curr.Region,
-			AWSInstanceID: curr.RelayAWSInstanceID,
-		}); err != nil {
-			durMS := float64(time.Since(deprovStart).Milliseconds())
-			log.Printf("metric=relay_deprovision_latency_ms session_id=%s user_id=%s region=%s value=%d status=error", curr.ID, curr.UserID, curr.Region, time.Since(deprovStart).Milliseconds())
-			labels := map[string]string{
-				"provider": s.cfg.RelayProvider,
-				"region":   curr.Region,
-				"status":   "error",
-			}
-			metrics.Default().IncCounter("aegis_relay_deprovision_total", labels)
-			metrics.Default().ObserveHistogram("aegis_relay_deprovision_latency_ms", durMS, labels)
-			writeAPIError(w, http.StatusInternalServerError, "internal_error", "failed to terminate relay instance")
-			return
-		}
-		durMS := float64(time.Since(deprovStart).Milliseconds())
-		log.Printf("metric=relay_deprovision_latency_ms session_id=%s user_id=%s region=%s value=%d status=ok", curr.ID, curr.UserID, curr.Region, time.Since(deprovStart).Milliseconds())
-		labels := map[string]string{
-			"provider": s.cfg.RelayProvider,
-			"region":   curr.Region,
-			"status":   "ok",
-		}
-		metrics.Default().IncCounter("aegis_relay_deprovision_total", labels)
-		metrics.Default().ObserveHistogram("aegis_relay_deprovision_latency_ms", durMS, labels)
+	reason := strings.ToLower(strings.TrimSpace(req.Reason))
+	if reason == "" {
+		reason = model.ClientStopUserRequested
+	}
+	if !slices.Contains(model.ClientStopReasons, reason) {
+		writeAPIError(w, apierr.InvalidStopReason, fmt.Sprintf("reason must be one of %s", strings.Join(model.ClientStopReasons, ", ")))
+		return
+	}
+	detail := strings.TrimSpace(req.Detail)
+	if utf8.RuneCountInString(detail) > model.MaxClientStopDetailLength || !utf8.ValidString(detail) {
+		writeAPIError(w, apierr.InvalidField, fmt.Sprintf("detail must be valid UTF-8 of at most %d characters", model.MaxClientStopDetailLength))
+		return
 	}
 
-	sess, err := s.store.StopSession(r.Context(), userID, req.SessionID)
+	sess, err := s.sessions.Stop(r.Context(), session.StopCommand{
+		UserID:       userID,
+		SessionID:    req.SessionID,
+		Reason:       model.StopReasonUser,
+		ClientReason: reason,
+		ClientDetail: detail,
+	})
 	if err != nil {
 		if errors.Is(err, store.ErrNotFound) {
-			writeAPIError(w, http.StatusNotFound, "not_found", "session not found")
+			writeAPIError(w, apierr.NotFound, "session not found")
 			return
 		}
-		writeAPIError(w, http.StatusInternalServerError, "internal_error", "failed to stop session")
+		writeStoreError(w, err, "failed to stop session")
 		return
 	}
+	writeStopResult(w, sess, reason)
+}
+
+// writeStopResult writes the result of a stop; reason, the normalized
+// reason a user's client gave, is echoed when set.
+func writeStopResult(w http.ResponseWriter, sess *model.Session, reason string) {
 	stoppedAt := time.Now().UTC().Format(time.RFC3339)
 	if sess.StoppedAt != nil {
 		stoppedAt = sess.StoppedAt.UTC().Format(time.RFC3339)
 	}
-	writeJSON(w, http.StatusOK, map[string]any{
+	status := http.StatusOK
+	if sess.Status == model.SessionStopping {
+		status = http.StatusAccepted
+	}
+	out := map[string]any{
 		"session_id": sess.ID,
 		"status":     string(sess.Status),
 		"stopped_at": stoppedAt,
-	})
+	}
+	if reason != "" {
+		out["reason"] = reason
+	}
+	writeJSON(w, status, out)
 }
 
-func (s *Server) handleRelayManifest(w http.ResponseWriter, r *http.Request) {
-	type regionDef struct {
-		Region              string `json:"region"`
-		AMIID               string `json:"ami_id"`
-		DefaultInstanceType string `json:"default_instance_type"`
-		UpdatedAt           string `json:"updated_at"`
+// Session event streams poll the store; the API and jobs worker run as
+// separate processes, so events cannot be pushed in-memory.
+var (
+	sessionEventPollInterval = 2 * time.Second
+	sessionEventKeepalive    = 15 * time.Second
+)
+
+// handleRelayEvents streams the caller's session events (e.g. relay_replaced)
+// as server-sent events. Clients resume with Last-Event-ID; without it only
+// events newer than the connection are sent.
+func (s *Server) handleRelayEvents(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierr.Unauthorized, "missing user identity")
+		return
 	}
+	var afterID int64
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id < 0 {
+			writeAPIError(w, apierr.InvalidRequest, "Last-Event-ID must be a non-negative integer")
+			return
+		}
+		afterID = id
+	} else {
+		latest, err := s.store.LatestSessionEventID(r.Context(), userID)
+		if err != nil {
+			writeStoreError(w, err, "failed to open event stream")
+			return
+		}
+		afterID = latest
+	}
+
+	rc := http.NewResponseController(w)
+	// The stream outlives the server-wide WriteTimeout; not every
+	// ResponseWriter supports deadlines.
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	_ = rc.Flush()
+
+	ticker := time.NewTicker(sessionEventPollInterval)
+	defer ticker.Stop()
+	lastWrite := time.Now()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.streamCtx.Done():
+			return
+		case <-ticker.C:
+		}
+		events, err := s.store.ListSessionEvents(r.Context(), userID, afterID, 100)
+		if err != nil {
+			if r.Context().Err() == nil {
+				log.Printf("event=session_event_stream_failed user_id=%s err=%q", userID, err.Error())
+			}
+			return
+		}
+		for _, ev := range events {
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, ev.Payload); err != nil {
+				return
+			}
+			afterID = ev.ID
+		}
+		if len(events) == 0 {
+			if time.Since(lastWrite) < sessionEventKeepalive {
+				continue
+			}
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		lastWrite = time.Now()
+	}
+}
+
+type manifestRegion struct {
+	Region              string `json:"region"`
+	AMIID               string `json:"ami_id"`
+	DefaultInstanceType string `json:"default_instance_type"`
+	Available           bool   `json:"available"`
+	UpdatedAt           string `json:"updated_at"`
+	// Enabled is false while operators have switched the region off for
+	// new sessions, which also makes it unavailable.
+	Enabled bool `json:"enabled"`
+	// AMIParameter is the configured SSM parameter ami_id was resolved from.
+	AMIParameter string `json:"ami_parameter,omitempty"`
+	Provider     string `json:"provider,omitempty"`
+}
+
+func (s *Server) handleRelayManifest(w http.ResponseWriter, r *http.Request) {
 	manifest, err := s.store.ListRelayManifest(r.Context())
 	if err != nil {
-		writeAPIError(w, http.StatusInternalServerError, "internal_error", "failed to read relay manifest")
+		writeStoreError(w, err, "failed to read relay manifest")
 		return
 	}
 	if len(manifest) == 0 {
-		writeAPIError(w, http.StatusServiceUnavailable, "manifest_unavailable", "relay manifest is not configured")
+		s.writeUnavailable(w, apierr.ManifestUnavailable, "relay manifest is not configured", 0)
 		return
 	}
-	regions := make([]regionDef, 0, len(manifest))
+	resolver, _ := s.provisioner.(relay.AMIResolver)
+	regions := make([]manifestRegion, 0, len(manifest))
 	for _, entry := range manifest {
-		regions = append(regions, regionDef{
+		def := manifestRegion{
 			Region:              entry.Region,
 			AMIID:               entry.AMIID,
 			DefaultInstanceType: entry.DefaultInstanceType,
+			Available:           entry.Available && entry.Enabled,
 			UpdatedAt:           entry.UpdatedAt.UTC().Format(time.RFC3339),
-		})
+			Enabled:             entry.Enabled,
+			Provider:            entry.Provider,
+		}
+		// Show what a launch would actually use; resolution is cached.
+		if resolver != nil {
+			amiID, err := resolver.ResolveAMI(r.Context(), entry.Region, entry.AMIID)
+			switch {
+			case err != nil:
+				log.Printf("event=relay_manifest_ami_resolve_failed region=%s ami=%s err=%q", entry.Region, entry.AMIID, err.Error())
+				def.AMIParameter = entry.AMIID
+				def.AMIID = ""
+				def.Available = false
+			case amiID != entry.AMIID:
+				def.AMIParameter = entry.AMIID
+				def.AMIID = amiID
+			}
+		}
+		regions = append(regions, def)
 	}
 	writeJSON(w, http.StatusOK, map[string]any{"regions": regions})
 }
@@ -303,16 +696,21 @@ func (s *Server) handleRelayManifest(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleUsageCurrent(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth.UserIDFromContext(r.Context())
 	if !ok {
-		writeAPIError(w, http.StatusUnauthorized, "unauthorized", "missing user identity")
+		writeAPIError(w, apierr.Unauthorized, "missing user identity")
 		return
 	}
-	usage, err := s.store.GetUsageCurrent(r.Context(), userID)
+	s.writeUsageCurrent(w, r, userID)
+}
+
+func (s *Server) writeUsageCurrent(w http.ResponseWriter, r *http.Request, userID string) {
+	cfg := s.config()
+	usage, err := s.store.GetUsageCurrent(r.Context(), userID, cfg.FreeIncludedSeconds)
 	if err != nil {
 		if errors.Is(err, store.ErrNotFound) {
-			writeAPIError(w, http.StatusNotFound, "not_found", "user usage not found")
+			writeAPIError(w, apierr.NotFound, "user usage not found")
 			return
 		}
-		writeAPIError(w, http.StatusInternalServerError, "internal_error", "failed to query usage")
+		writeStoreError(w, err, "failed to query usage")
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]any{
@@ -323,13 +721,14 @@ func (s *Server) handleUsageCurrent(w http.ResponseWriter, r *http.Request) {
 		"consumed_seconds":  usage.ConsumedSeconds,
 		"remaining_seconds": usage.RemainingSeconds,
 		"overage_seconds":   usage.OverageSeconds,
+		"usage_alerts":      usageAlerts(usage, cfg.UsageAlertThresholds),
 	})
 }
 
 func (s *Server) handleRelayHealth(w http.ResponseWriter, r *http.Request) {
 	var req relayHealthRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SessionID == "" {
-		writeAPIError(w, http.StatusBadRequest, "invalid_request", "invalid relay health payload")
+		writeAPIError(w, apierr.InvalidRequest, "invalid relay health payload")
 		return
 	}
 
@@ -337,51 +736,415 @@ func (s *Server) handleRelayHealth(w http.ResponseWriter, r *http.Request) {
 	if req.ObservedAt != "" {
 		t, err := time.Parse(time.RFC3339, req.ObservedAt)
 		if err != nil {
-			writeAPIError(w, http.StatusBadRequest, "invalid_request", "observed_at must be RFC3339")
+			writeAPIError(w, apierr.InvalidRequest, "observed_at must be RFC3339")
 			return
 		}
 		observedAt = t.UTC()
 	}
 	raw, _ := json.Marshal(req)
+	minVersion := s.config().RelayMinAgentVersion
+	outdated := agentOutdated(req.AgentVersion, minVersion)
+	if outdated {
+		log.Printf("event=relay_agent_outdated session_id=%s instance_id=%s agent_version=%q min_agent_version=%s", req.SessionID, req.InstanceID, req.AgentVersion, minVersion)
+	}
 
-	err := s.store.RecordRelayHealth(r.Context(), store.RelayHealthInput{
+	in := store.RelayHealthInput{
 		SessionID:            req.SessionID,
 		ObservedAt:           observedAt,
 		IngestActive:         req.IngestActive,
 		EgressActive:         req.EgressActive,
 		SessionUptimeSeconds: req.SessionUptimeSeconds,
 		RawPayload:           raw,
-	})
+		AgentVersion:         req.AgentVersion,
+		AgentOutdated:        outdated,
+	}
+	if err := model.ValidateRelayHealth(in.Health()); err != nil {
+		writeAPIError(w, apierr.InvalidField, err.Error())
+		return
+	}
+	err := s.store.RecordRelayHealth(r.Context(), in)
 	if err != nil {
 		if errors.Is(err, store.ErrRelayHealthRejected) {
-			writeAPIError(w, http.StatusBadRequest, "invalid_request", "relay health rejected")
+			writeAPIError(w, apierr.InvalidRequest, "relay health rejected")
+			return
+		}
+		writeStoreError(w, err, "failed to record relay health")
+		return
+	}
+	resp := map[string]any{"ok": true}
+	if outdated {
+		resp["upgrade_required"] = true
+		resp["min_agent_version"] = minVersion
+	}
+	// Without an allowance the relay keeps counting down from the last one.
+	if seconds, err := s.relayAllowance(r.Context(), req.SessionID); err != nil {
+		log.Printf("event=relay_allowance_failed session_id=%s err=%v", req.SessionID, err)
+	} else {
+		resp["remaining_allowed_seconds"] = seconds
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// agentOutdated reports whether a relay agent is older than minVersion. With
+// a minimum configured, an agent that sends no version or one that does not
+// parse is treated as outdated.
+func agentOutdated(agentVersion, minVersion string) bool {
+	if minVersion == "" {
+		return false
+	}
+	want, err := version.ParseSemver(minVersion)
+	if err != nil {
+		return false
+	}
+	got, err := version.ParseSemver(agentVersion)
+	return err != nil || got.Compare(want) < 0
+}
+
+// handleRelayInterruption receives the spot interruption notice a relay reads
+// from instance metadata and moves its session into grace.
+func (s *Server) handleRelayInterruption(w http.ResponseWriter, r *http.Request) {
+	var req relayInterruptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SessionID == "" || req.InstanceID == "" {
+		writeAPIError(w, apierr.InvalidRequest, "session_id and instance_id are required")
+		return
+	}
+	sess, err := s.store.MarkRelayInterrupted(r.Context(), req.SessionID, req.InstanceID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeAPIError(w, apierr.NotFound, "no active session for relay instance")
+			return
+		}
+		writeStoreError(w, err, "failed to record relay interruption")
+		return
+	}
+	log.Printf("event=relay_interruption session_id=%s instance_id=%s region=%s action=%s notice_time=%s", sess.ID, req.InstanceID, sess.Region, req.Action, req.NoticeTime)
+	metrics.Default().IncCounter("aegis_relay_interruptions_total", map[string]string{"region": sess.Region})
+	writeJSON(w, http.StatusOK, map[string]any{
+		"session_id": sess.ID,
+		"status":     string(sess.Status),
+	})
+}
+
+// handleRelaySession lets a relay that restarted re-fetch the settings of
+// the session it serves. The user's pair token is never included.
+func (s *Server) handleRelaySession(w http.ResponseWriter, r *http.Request) {
+	sessionID, instanceID := r.URL.Query().Get("session_id"), r.URL.Query().Get("instance_id")
+	if sessionID == "" || instanceID == "" {
+		writeAPIError(w, apierr.InvalidRequest, "session_id and instance_id are required")
+		return
+	}
+	sess, err := s.store.GetRelaySession(r.Context(), sessionID, instanceID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeAPIError(w, apierr.NotFound, "no session for relay instance")
 			return
 		}
-		writeAPIError(w, http.StatusInternalServerError, "internal_error", "failed to record relay health")
+		writeStoreError(w, err, "failed to load relay session")
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	stopped := sess.Status == model.SessionStopping || sess.Status == model.SessionStopped
+	remaining := 0
+	if !stopped {
+		remaining = max(sess.MaxSessionSeconds-int(time.Since(sess.StartedAt).Seconds()), 0)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"session_id":           sess.ID,
+		"status":               string(sess.Status),
+		"region":               sess.Region,
+		"srt_port":             sess.SRTPort,
+		"srt_ports":            srtPorts(sess),
+		"grace_window_seconds": sess.GraceWindowSeconds,
+		"max_session_seconds":  sess.MaxSessionSeconds,
+		"remaining_seconds":    remaining,
+		"stopped":              stopped,
+	})
+}
+
+func (s *Server) handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch model.SessionStatus(status) {
+	case "", model.SessionProvisioning, model.SessionActive, model.SessionGrace, model.SessionStopping, model.SessionStopped:
+	default:
+		writeAPIError(w, apierr.InvalidRequest, "unknown status filter")
+		return
+	}
+	limit := 50
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 500 {
+			writeAPIError(w, apierr.InvalidRequest, "limit must be between 1 and 500")
+			return
+		}
+		limit = n
+	}
+	tag, ok := tagFilter(w, r)
+	if !ok {
+		return
+	}
+	sessions, err := s.store.ListSessions(r.Context(), status, tag, limit)
+	if err != nil {
+		writeStoreError(w, err, "failed to list sessions")
+		return
+	}
+	out := make([]map[string]any, 0, len(sessions))
+	for i := range sessions {
+		sess := &sessions[i]
+		item := map[string]any{
+			"session_id":        sess.ID,
+			"user_id":           sess.UserID,
+			"status":            string(sess.Status),
+			"region":            sess.Region,
+			"instance_id":       sess.RelayAWSInstanceID,
+			"relay_lifecycle":   sess.RelayLifecycle,
+			"subnet_id":         sess.RelaySubnetID,
+			"availability_zone": sess.RelayAvailabilityZone,
+			"public_ip":         sess.PublicIP,
+			"started_at":        sess.StartedAt.UTC().Format(time.RFC3339),
+			"duration_seconds":  sess.DurationSeconds,
+			"billable":          sess.Billable,
+			"tags":              sessionTags(sess),
+		}
+		if sess.StoppedAt != nil {
+			item["stopped_at"] = sess.StoppedAt.UTC().Format(time.RFC3339)
+		}
+		out = append(out, item)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"sessions": out})
+}
+
+// handleAdminSessionRelay shows the database's view of a session's relay
+// next to the provider's, so operators can spot drift (e.g. a relay the
+// database thinks is running whose instance is gone).
+func (s *Server) handleAdminSessionRelay(w http.ResponseWriter, r *http.Request) {
+	sr, err := s.store.GetSessionRelay(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeAPIError(w, apierr.NotFound, "session not found")
+			return
+		}
+		writeStoreError(w, err, "failed to load session relay")
+		return
+	}
+	attempts, err := s.store.ListProvisionAttempts(r.Context(), sr.SessionID)
+	if err != nil {
+		writeStoreError(w, err, "failed to load provision attempts")
+		return
+	}
+	out := map[string]any{
+		"session_id":         sr.SessionID,
+		"user_id":            sr.UserID,
+		"session_status":     string(sr.SessionStatus),
+		"relay":              nil,
+		"provider":           nil,
+		"provision_attempts": toProvisionAttempts(attempts),
+		"started_from_ip":    nil,
+		"started_user_agent": nil,
+	}
+	// Where the session was started from is shown to admins only.
+	for key, v := range map[string]string{"started_from_ip": sr.StartedFromIP, "started_user_agent": sr.StartedUserAgent} {
+		if v != "" {
+			out[key] = v
+		}
+	}
+	if sr.AWSInstanceID == "" {
+		writeJSON(w, http.StatusOK, out)
+		return
+	}
+	dbView := map[string]any{
+		"relay_instance_id": sr.RelayInstanceID,
+		"region":            sr.Region,
+		"instance_id":       sr.AWSInstanceID,
+		"state":             sr.State,
+		"public_ip":         sr.PublicIP,
+	}
+	if sr.Provider != "" {
+		dbView["provider"] = sr.Provider
+	}
+	for key, ts := range map[string]*time.Time{"launched_at": sr.LaunchedAt, "terminated_at": sr.TerminatedAt, "last_health_at": sr.LastHealthAt} {
+		if ts != nil {
+			dbView[key] = ts.UTC().Format(time.RFC3339)
+		}
+	}
+	out["relay"] = dbView
+
+	status, err := s.provisioner.Status(r.Context(), relay.StatusRequest{Region: sr.Region, AWSInstanceID: sr.AWSInstanceID, Provider: sr.Provider})
+	if err != nil {
+		log.Printf("event=admin_relay_status_failed session_id=%s instance_id=%s err=%q", sr.SessionID, sr.AWSInstanceID, err.Error())
+		out["provider_error"] = err.Error()
+		writeJSON(w, http.StatusOK, out)
+		return
+	}
+	providerView := map[string]any{
+		"state":     status.State,
+		"public_ip": status.PublicIP,
+	}
+	if !status.LaunchedAt.IsZero() {
+		providerView["launched_at"] = status.LaunchedAt.UTC().Format(time.RFC3339)
+	}
+	out["provider"] = providerView
+	writeJSON(w, http.StatusOK, out)
+}
+
+func toProvisionAttempts(attempts []model.ProvisionAttempt) []map[string]any {
+	out := make([]map[string]any, 0, len(attempts))
+	for _, a := range attempts {
+		v := map[string]any{
+			"attempt_id":    a.ID,
+			"provider":      a.Provider,
+			"region":        a.Region,
+			"instance_type": a.InstanceType,
+			"started_at":    a.StartedAt.UTC().Format(time.RFC3339),
+			"finished_at":   a.FinishedAt.UTC().Format(time.RFC3339),
+			"outcome":       a.Outcome,
+		}
+		for key, val := range map[string]string{"aws_error_code": a.AWSErrorCode, "error": a.Error, "instance_id": a.InstanceID, "compensation": a.Compensation} {
+			if val != "" {
+				v[key] = val
+			}
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+func (s *Server) handleConfigReload(w http.ResponseWriter, _ *http.Request) {
+	rejected, err := s.reloadConfig()
+	if err != nil {
+		writeAPIError(w, apierr.InvalidConfig, err.Error())
+		return
+	}
+	cfg := s.config()
+	if rejected == nil {
+		rejected = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"reloaded":          true,
+		"default_region":    cfg.DefaultRegion,
+		"supported_regions": cfg.SupportedRegion,
+		"rejected_fields":   rejected,
+	})
 }
 
 func (s *Server) resolveRegion(pref string) string {
+	cfg := s.config()
 	if pref == "" || pref == "auto" {
-		return s.cfg.DefaultRegion
+		return cfg.DefaultRegion
 	}
-	if slices.Contains(s.cfg.SupportedRegion, pref) {
+	if slices.Contains(cfg.SupportedRegion, pref) {
 		return pref
 	}
-	return s.cfg.DefaultRegion
+	return cfg.DefaultRegion
+}
+
+// applyRegionSwitch keeps new sessions out of regions operators have
+// switched off in the manifest. A start that left the region to the
+// control plane moves to the first enabled supported region; one that named
+// a disabled region is refused as unsupported, unless it would return an
+// existing session instead of creating one. It writes the error response,
+// or for a dry run the refused preview, and returns false when refused.
+func (s *Server) applyRegionSwitch(w http.ResponseWriter, r *http.Request, cmd *session.StartCommand, dryRun bool) bool {
+	manifest, err := s.store.ListRelayManifest(r.Context())
+	if err != nil {
+		writeStoreError(w, err, "failed to read relay manifest")
+		return false
+	}
+	disabled := make(map[string]bool)
+	for _, entry := range manifest {
+		if !entry.Enabled {
+			disabled[entry.Region] = true
+		}
+	}
+	if !disabled[cmd.Region] {
+		return true
+	}
+
+	pref := cmd.RegionPreference
+	if pref == "" || pref == "auto" {
+		for _, region := range s.config().SupportedRegion {
+			if !disabled[region] {
+				log.Printf("event=relay_start_region_fallback user_id=%s disabled_region=%s region=%s", cmd.UserID, cmd.Region, region)
+				cmd.Region = region
+				return true
+			}
+		}
+		e := apierr.New(apierr.RegionUnavailable, "every supported region is disabled")
+		if dryRun {
+			writeJSON(w, http.StatusOK, dryRunResponse{DryRun: true, Region: cmd.Region, Error: dryRunErrorFor(e)})
+		} else {
+			s.writeUnavailable(w, apierr.RegionUnavailable, e.Message, 0)
+		}
+		return false
+	}
+	// Sessions already started there are not affected by the switch. A start
+	// the preview refuses for another reason is refused for the switch.
+	preview, err := s.sessions.Preview(r.Context(), *cmd)
+	if err != nil {
+		if e, _ := startError(err); e.Status >= http.StatusInternalServerError {
+			writeStoreError(w, err, "failed to read sessions")
+			return false
+		}
+	} else if preview.Existing != nil {
+		return true
+	}
+	e := apierr.New(apierr.UnsupportedRegion, fmt.Sprintf("region %q is disabled", pref))
+	if dryRun {
+		writeJSON(w, http.StatusOK, dryRunResponse{DryRun: true, Region: cmd.Region, Error: dryRunErrorFor(e)})
+	} else {
+		writeError(w, e)
+	}
+	return false
+}
+
+// srtPorts lists the session's SRT ports; a session without a relay yet
+// reports the default port alone.
+func srtPorts(sess *model.Session) []int {
+	if len(sess.SRTPorts) == 0 {
+		return []int{sess.SRTPort}
+	}
+	return sess.SRTPorts
+}
+
+// tagFilter reads the optional ?tag= of a session list, normalized. It
+// writes the error response and returns false when the tag is invalid.
+func tagFilter(w http.ResponseWriter, r *http.Request) (string, bool) {
+	raw := r.URL.Query().Get("tag")
+	if raw == "" {
+		return "", true
+	}
+	tag, err := model.NormalizeSessionTag(raw)
+	if err != nil {
+		writeAPIError(w, apierr.InvalidRequest, err.Error())
+		return "", false
+	}
+	return tag, true
+}
+
+// sessionTags is sess.Tags, never null in responses.
+func sessionTags(sess *model.Session) []string {
+	if sess.Tags == nil {
+		return []string{}
+	}
+	return sess.Tags
 }
 
 func toSessionResponse(sess *model.Session) map[string]any {
+	var lastHealthAt, heartbeatAge any
+	if sess.LastHealthAt != nil {
+		lastHealthAt = sess.LastHealthAt.UTC().Format(time.RFC3339)
+		heartbeatAge = max(int(time.Since(*sess.LastHealthAt).Seconds()), 0)
+	}
 	resp := map[string]any{
 		"session_id": sess.ID,
 		"status":     string(sess.Status),
 		"region":     sess.Region,
 		"relay": map[string]any{
-			"public_ip": sess.PublicIP,
-			"srt_port":  sess.SRTPort,
-			"ws_url":    sess.WSURL,
+			"public_ip":             sess.PublicIP,
+			"public_ipv6":           sess.PublicIPv6,
+			"srt_port":              sess.SRTPort,
+			"srt_ports":             srtPorts(sess),
+			"ws_url":                sess.WSURL,
+			"last_health_at":        lastHealthAt,
+			"heartbeat_age_seconds": heartbeatAge,
 		},
 		"credentials": map[string]any{
 			"pair_token":     sess.PairToken,
@@ -391,30 +1154,56 @@ func toSessionResponse(sess *model.Session) map[string]any {
 			"grace_window_seconds": sess.GraceWindowSeconds,
 			"max_session_seconds":  sess.MaxSessionSeconds,
 		},
+		"duration_seconds": sess.DurationSeconds,
+	}
+	if !sess.StartedAt.IsZero() {
+		resp["started_at"] = sess.StartedAt.UTC().Format(time.RFC3339)
+		if sess.StoppedAt == nil && sess.MaxSessionSeconds > 0 {
+			expiresAt := sess.StartedAt.Add(time.Duration(sess.MaxSessionSeconds) * time.Second)
+			resp["expires_at"] = expiresAt.UTC().Format(time.RFC3339)
+		}
+	}
+	if sess.StoppedAt != nil {
+		resp["stopped_at"] = sess.StoppedAt.UTC().Format(time.RFC3339)
+	}
+	resp["tags"] = sessionTags(sess)
+	if sess.ProvisioningPhase != "" {
+		resp["provisioning_phase"] = sess.ProvisioningPhase
+	}
+	if sess.Status == model.SessionGrace && sess.GraceStartedAt != nil {
+		graceDeadline := sess.GraceStartedAt.Add(time.Duration(sess.GraceWindowSeconds) * time.Second)
+		resp["grace_deadline"] = graceDeadline.UTC().Format(time.RFC3339)
 	}
 	return resp
 }
 
-func generatePairToken(length int) (string, error) {
-	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	if length <= 0 {
-		return "", errors.New("invalid token length")
-	}
-	buf := make([]byte, length)
-	if _, err := rand.Read(buf); err != nil {
-		return "", err
+// maskedCredentials keeps the last two characters of each token, enough for
+// a user to tell sessions apart.
+func maskedCredentials(sess *model.Session) map[string]any {
+	return map[string]any{
+		"pair_token":     maskToken(sess.PairToken),
+		"relay_ws_token": maskToken(sess.RelayWSToken),
+		"masked":         true,
 	}
-	out := make([]byte, length)
-	for i := range buf {
-		out[i] = alphabet[int(buf[i])%len(alphabet)]
+}
+
+func maskToken(tok string) string {
+	if len(tok) <= 2 {
+		return strings.Repeat("*", len(tok))
 	}
-	return string(out), nil
+	return "******" + tok[len(tok)-2:]
 }
 
-func generateRelayWSToken() (string, error) {
-	b := make([]byte, 24)
-	if _, err := rand.Read(b); err != nil {
-		return "", err
+// clientIP returns the caller's address as resolved by middleware.RealIP, or
+// "" when it is not a valid IP.
+func clientIP(r *http.Request) string {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
 	}
-	return base64.RawURLEncoding.EncodeToString(b), nil
+	return ip.String()
 }
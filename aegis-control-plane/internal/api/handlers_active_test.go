@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+func getActiveSessionJSON(t *testing.T, sess *model.Session) map[string]any {
+	t.Helper()
+	ms := &mockStore{
+		getActiveSessionFn: func(_ context.Context, _ string) (*model.Session, error) {
+			return sess, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/relay/active", nil)
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Session map[string]any `json:"session"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return body.Session
+}
+
+func TestRelayActive_ReportsExpiry(t *testing.T) {
+	startedAt := time.Date(2026, 2, 21, 20, 0, 0, 0, time.FixedZone("EST", -5*3600))
+	got := getActiveSessionJSON(t, &model.Session{
+		ID:                 "ses_1",
+		UserID:             "usr_1",
+		Status:             model.SessionActive,
+		Region:             "us-east-1",
+		StartedAt:          startedAt,
+		DurationSeconds:    3600,
+		GraceWindowSeconds: 600,
+		MaxSessionSeconds:  57600,
+	})
+
+	if got["started_at"] != "2026-02-22T01:00:00Z" {
+		t.Fatalf("expected UTC started_at, got %v", got["started_at"])
+	}
+	if got["expires_at"] != "2026-02-22T17:00:00Z" {
+		t.Fatalf("expected expires_at started_at+max_session_seconds, got %v", got["expires_at"])
+	}
+	if got["duration_seconds"] != float64(3600) {
+		t.Fatalf("expected duration_seconds 3600, got %v", got["duration_seconds"])
+	}
+	for _, key := range []string{"stopped_at", "grace_deadline", "provisioning_phase"} {
+		if _, ok := got[key]; ok {
+			t.Fatalf("expected %s to be omitted, got %v", key, got[key])
+		}
+	}
+}
+
+func TestRelayActive_ReportsGraceDeadline(t *testing.T) {
+	graceStartedAt := time.Date(2026, 2, 21, 21, 0, 0, 0, time.UTC)
+	got := getActiveSessionJSON(t, &model.Session{
+		ID:                 "ses_1",
+		UserID:             "usr_1",
+		Status:             model.SessionGrace,
+		Region:             "us-east-1",
+		StartedAt:          time.Date(2026, 2, 21, 20, 0, 0, 0, time.UTC),
+		GraceStartedAt:     &graceStartedAt,
+		GraceWindowSeconds: 600,
+		MaxSessionSeconds:  57600,
+	})
+
+	if got["grace_deadline"] != "2026-02-21T21:10:00Z" {
+		t.Fatalf("expected grace_deadline grace start+grace window, got %v", got["grace_deadline"])
+	}
+	if got["expires_at"] != "2026-02-22T12:00:00Z" {
+		t.Fatalf("expected expires_at, got %v", got["expires_at"])
+	}
+}
+
+func TestRelayStart_ExistingSessionReportsExpiry(t *testing.T) {
+	ms := &mockStore{
+		startOrGetSessionFn: func(_ context.Context, _ store.StartInput) (*model.Session, bool, error) {
+			return &model.Session{
+				ID:                "ses_existing",
+				UserID:            "usr_1",
+				Status:            model.SessionActive,
+				Region:            "us-east-1",
+				StartedAt:         time.Date(2026, 2, 21, 20, 0, 0, 0, time.UTC),
+				MaxSessionSeconds: 3600,
+			}, false, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/start", jsonBody(map[string]any{"region_preference": "us-east-1"}))
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	req.Header.Set("Idempotency-Key", "4f1d3a52-8c1e-4b8e-9a55-0d6a1f2c7e90")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Session map[string]any `json:"session"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Session["expires_at"] != "2026-02-21T21:00:00Z" {
+		t.Fatalf("expected expires_at in start response, got %v", body.Session["expires_at"])
+	}
+}
+
+func TestRelayActive_ReportsHeartbeatAge(t *testing.T) {
+	lastHealthAt := time.Now().Add(-12 * time.Second)
+	got := getActiveSessionJSON(t, &model.Session{ID: "ses_1", Status: model.SessionActive, LastHealthAt: &lastHealthAt})
+	relay := got["relay"].(map[string]any)
+	if relay["last_health_at"] != lastHealthAt.UTC().Format(time.RFC3339) {
+		t.Fatalf("expected last_health_at in UTC, got %v", relay["last_health_at"])
+	}
+	if age, _ := relay["heartbeat_age_seconds"].(float64); age < 12 || age > 14 {
+		t.Fatalf("expected a heartbeat age of about 12s, got %v", relay["heartbeat_age_seconds"])
+	}
+
+	// A relay that never sent a heartbeat reports nulls, not a zero time.
+	got = getActiveSessionJSON(t, &model.Session{ID: "ses_1", Status: model.SessionProvisioning})
+	relay = got["relay"].(map[string]any)
+	for _, key := range []string{"last_health_at", "heartbeat_age_seconds"} {
+		if v, ok := relay[key]; !ok || v != nil {
+			t.Fatalf("expected %s to be null, got %v (present=%t)", key, v, ok)
+		}
+	}
+}
+
+func TestRelayActive_NoSessionReturns204(t *testing.T) {
+	router := NewRouter(testConfig(), &mockStore{}, &mockProvisioner{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/relay/active", nil)
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestRelayActive_ReportsProvisioningPhase(t *testing.T) {
+	got := getActiveSessionJSON(t, &model.Session{ID: "ses_1", Status: model.SessionProvisioning, ProvisioningPhase: model.ProvisioningWaitingBoot})
+	if got["provisioning_phase"] != model.ProvisioningWaitingBoot {
+		t.Fatalf("expected provisioning_phase waiting_boot, got %v", got["provisioning_phase"])
+	}
+}
@@ -0,0 +1,900 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/config"
+	"github.com/telemyapp/aegis-control-plane/internal/jobs"
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+func TestResolveRegion_ObservesReloadedDefaultRegion(t *testing.T) {
+	live := config.NewLive(testConfig())
+	s := &Server{cfg: live}
+
+	if got := s.resolveRegion("auto"); got != "us-east-1" {
+		t.Fatalf("expected us-east-1 before reload, got %s", got)
+	}
+
+	next := testConfig()
+	next.DefaultRegion = "eu-west-1"
+	next.SupportedRegion = []string{"eu-west-1", "ap-southeast-2"}
+	live.Reload(next)
+
+	if got := s.resolveRegion("auto"); got != "eu-west-1" {
+		t.Fatalf("expected eu-west-1 after reload, got %s", got)
+	}
+	if got := s.resolveRegion("ap-southeast-2"); got != "ap-southeast-2" {
+		t.Fatalf("expected newly supported region to resolve, got %s", got)
+	}
+}
+
+func TestConfigReload_RequiresAdminRole(t *testing.T) {
+	calls := 0
+	router := NewRouter(testConfig(), &mockStore{}, &mockProvisioner{}, WithConfigReloader(func() ([]string, error) {
+		calls++
+		return nil, nil
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/config/reload", nil)
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for non-admin, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/admin/config/reload", nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminJWT(t, "test-secret", "usr_admin"))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 for admin, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 reload call, got %d", calls)
+	}
+}
+
+func testAdminJWT(t *testing.T, secret, userID string) string {
+	t.Helper()
+	claims := jwt.MapClaims{
+		"uid":  userID,
+		"role": "admin",
+		"exp":  time.Now().Add(1 * time.Hour).Unix(),
+		"iat":  time.Now().Unix(),
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := tok.SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("sign jwt: %v", err)
+	}
+	return signed
+}
+
+func TestAdminSessions_ListsRelayLifecycle(t *testing.T) {
+	var gotStatus string
+	var gotLimit int
+	ms := &mockStore{
+		listSessionsFn: func(_ context.Context, status string, limit int) ([]model.Session, error) {
+			gotStatus, gotLimit = status, limit
+			return []model.Session{{
+				ID:                 "ses_1",
+				UserID:             "usr_1",
+				Status:             model.SessionActive,
+				Region:             "us-east-1",
+				RelayAWSInstanceID: "i-spot",
+				RelayLifecycle:     "spot",
+				StartedAt:          time.Now(),
+				Billable:           true,
+
+				RelaySubnetID:         "subnet-0123456789abcdef0",
+				RelayAvailabilityZone: "us-east-1b",
+			}}, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/sessions?status=active&limit=10", nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminJWT(t, "test-secret", "usr_admin"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if gotStatus != "active" || gotLimit != 10 {
+		t.Fatalf("unexpected filter status=%q limit=%d", gotStatus, gotLimit)
+	}
+	var body struct {
+		Sessions []map[string]any `json:"sessions"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Sessions) != 1 || body.Sessions[0]["relay_lifecycle"] != "spot" || body.Sessions[0]["availability_zone"] != "us-east-1b" ||
+		body.Sessions[0]["billable"] != true {
+		t.Fatalf("unexpected sessions: %v", body.Sessions)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/admin/sessions", nil)
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for non-admin, got %d", rr.Code)
+	}
+}
+
+func TestRelayInterruption_MovesSessionToGrace(t *testing.T) {
+	ms := &mockStore{
+		markInterruptedFn: func(_ context.Context, sessionID, instanceID string) (*model.Session, error) {
+			if sessionID != "ses_1" || instanceID != "i-spot" {
+				t.Fatalf("unexpected interruption target session=%s instance=%s", sessionID, instanceID)
+			}
+			return &model.Session{ID: sessionID, Status: model.SessionGrace, Region: "us-east-1"}, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/interruption", jsonBody(map[string]any{
+		"session_id":  "ses_1",
+		"instance_id": "i-spot",
+		"action":      "terminate",
+	}))
+	req.Header.Set("X-Relay-Auth", "relay-key")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), `"status":"grace"`) {
+		t.Fatalf("expected grace status, got %s", rr.Body.String())
+	}
+}
+
+func TestAdminSessionRelay_ComparesDatabaseAndProvider(t *testing.T) {
+	launched := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	ms := &mockStore{
+		getSessionRelayFn: func(_ context.Context, sessionID string) (*model.SessionRelay, error) {
+			if sessionID != "ses_1" {
+				return nil, store.ErrNotFound
+			}
+			return &model.SessionRelay{
+				SessionID: "ses_1", UserID: "usr_1", SessionStatus: model.SessionActive,
+				RelayInstanceID: "ri_1", Region: "eu-west-1", AWSInstanceID: "i-1", State: "running",
+				PublicIP: "198.51.100.5", LaunchedAt: &launched,
+				StartedFromIP: "203.0.113.77", StartedUserAgent: "OBS/30.1",
+			}, nil
+		},
+	}
+	var got relay.StatusRequest
+	mp := &mockProvisioner{
+		statusFn: func(_ context.Context, req relay.StatusRequest) (relay.StatusResult, error) {
+			got = req
+			return relay.StatusResult{State: relay.InstanceTerminated, LaunchedAt: launched}, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, mp)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/sessions/ses_1/relay", nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminJWT(t, "test-secret", "usr_admin"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got.Region != "eu-west-1" || got.AWSInstanceID != "i-1" {
+		t.Fatalf("unexpected status request: %+v", got)
+	}
+	var body struct {
+		Relay            map[string]any `json:"relay"`
+		Provider         map[string]any `json:"provider"`
+		StartedFromIP    string         `json:"started_from_ip"`
+		StartedUserAgent string         `json:"started_user_agent"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Relay["state"] != "running" || body.Provider["state"] != "terminated" || body.Provider["launched_at"] != "2026-03-01T12:00:00Z" {
+		t.Fatalf("unexpected body: %s", rr.Body.String())
+	}
+	if body.StartedFromIP != "203.0.113.77" || body.StartedUserAgent != "OBS/30.1" {
+		t.Fatalf("expected where the session was started from, got %s", rr.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/admin/sessions/ses_missing/relay", nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminJWT(t, "test-secret", "usr_admin"))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAdminBillingExports_FiltersByUserAndCycle(t *testing.T) {
+	cycleStart := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	reportedAt := time.Date(2026, 4, 1, 0, 20, 0, 0, time.UTC)
+	var got store.BillingExportFilter
+	ms := &mockStore{
+		listBillingExportsFn: func(_ context.Context, f store.BillingExportFilter) ([]model.BillingExport, error) {
+			got = f
+			return []model.BillingExport{{
+				UserID: "usr_1", CycleStart: cycleStart, CycleEnd: cycleStart.AddDate(0, 1, 0), SubscriptionItemID: "si_1",
+				OverageSeconds: 600, Status: model.BillingExportReported, Attempts: 2, StripeUsageRecordID: "mbur_1", ReportedAt: &reportedAt,
+			}}, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/billing/exports?user_id=usr_1&cycle_start=2026-03-01T00:00:00Z", nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminJWT(t, "test-secret", "usr_admin"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got.UserID != "usr_1" || !got.CycleStart.Equal(cycleStart) || got.Status != "" || got.Limit != 50 {
+		t.Fatalf("unexpected filter %+v", got)
+	}
+	var body struct {
+		Exports []map[string]any `json:"exports"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Exports) != 1 || body.Exports[0]["status"] != "reported" || body.Exports[0]["stripe_usage_record_id"] != "mbur_1" ||
+		body.Exports[0]["reported_at"] != "2026-04-01T00:20:00Z" {
+		t.Fatalf("unexpected exports %v", body.Exports)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/admin/billing/exports?status=lost", nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminJWT(t, "test-secret", "usr_admin"))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown status, got %d", rr.Code)
+	}
+}
+
+func TestAdminRelays_PagesWithCursor(t *testing.T) {
+	launched := time.Date(2026, 9, 1, 12, 0, 0, 123456000, time.UTC)
+	var filters []store.RelayInstanceFilter
+	ms := &mockStore{
+		listRelayInstancesFn: func(_ context.Context, f store.RelayInstanceFilter) ([]model.RelayInstance, error) {
+			filters = append(filters, f)
+			if f.BeforeID != "" {
+				return []model.RelayInstance{{ID: "rly_1", Region: "us-east-1", AMIID: "ami-0a1b2c3d", State: model.RelayTerminated, LaunchedAt: launched.Add(-2 * time.Hour)}}, nil
+			}
+			return []model.RelayInstance{
+				{ID: "rly_3", SessionID: "ses_3", Region: "us-east-1", AMIID: "ami-0a1b2c3d", State: model.RelayTerminated, LaunchedAt: launched, LastHealthAt: &launched},
+				{ID: "rly_2", Region: "us-east-1", AMIID: "ami-0a1b2c3d", State: model.RelayTerminated, LaunchedAt: launched.Add(-time.Hour)},
+				{ID: "rly_1b", Region: "us-east-1", AMIID: "ami-0a1b2c3d", State: model.RelayTerminated, LaunchedAt: launched.Add(-time.Hour)},
+			}, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+
+	rr := adminRequest(t, router, http.MethodGet, "/api/v1/admin/relays?region=us-east-1&state=terminated&ami_id=ami-0a1b2c3d&launched_from=2026-09-01T00:00:00Z&limit=2", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	f := filters[0]
+	if f.Region != "us-east-1" || f.State != "terminated" || f.AMIID != "ami-0a1b2c3d" || !f.LaunchedFrom.Equal(time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)) ||
+		!f.LaunchedTo.IsZero() || f.Limit != 3 {
+		t.Fatalf("unexpected filter %+v", f)
+	}
+	var page struct {
+		Relays     []map[string]any `json:"relays"`
+		NextCursor *string          `json:"next_cursor"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(page.Relays) != 2 || page.Relays[0]["session_id"] != "ses_3" || page.Relays[0]["last_health_at"] != "2026-09-01T12:00:00Z" ||
+		page.Relays[1]["id"] != "rly_2" || page.NextCursor == nil {
+		t.Fatalf("unexpected page %s", rr.Body.String())
+	}
+
+	rr = adminRequest(t, router, http.MethodGet, "/api/v1/admin/relays?limit=2&cursor="+*page.NextCursor, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if f := filters[1]; f.BeforeID != "rly_2" || !f.BeforeLaunchedAt.Equal(launched.Add(-time.Hour)) {
+		t.Fatalf("expected the cursor to continue after rly_2, got %+v", f)
+	}
+	page.NextCursor = nil
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(page.Relays) != 1 || page.NextCursor != nil {
+		t.Fatalf("expected a last page without a cursor, got %s", rr.Body.String())
+	}
+
+	for _, query := range []string{"state=lost", "launched_to=yesterday", "limit=0", "cursor=not-a-cursor"} {
+		if rr := adminRequest(t, router, http.MethodGet, "/api/v1/admin/relays?"+query, nil); rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", query, rr.Code)
+		}
+	}
+}
+
+func TestAdminRelayQuality_DefaultsToLastThirtyDays(t *testing.T) {
+	day := time.Date(2026, 9, 30, 0, 0, 0, 0, time.UTC)
+	var got store.RelayQualityFilter
+	ms := &mockStore{
+		listRelayQualityFn: func(_ context.Context, f store.RelayQualityFilter) ([]model.RelayQualityDay, error) {
+			got = f
+			return []model.RelayQualityDay{{
+				Day: day, Region: "us-east-1", AMIID: "ami-0e4f5a6b", InstanceType: "t4g.small", Relays: 12, RelaysWithGaps: 3, HealthGaps: 5, GapSeconds: 840, NeverHealthy: 1,
+			}}, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+
+	rr := adminRequest(t, router, http.MethodGet, "/api/v1/admin/relays/quality?ami_id=ami-0e4f5a6b", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	if got.AMIID != "ami-0e4f5a6b" || !got.From.Equal(today.AddDate(0, 0, -30)) || !got.To.IsZero() || got.Limit != 500 {
+		t.Fatalf("unexpected filter %+v", got)
+	}
+	var body struct {
+		Days []map[string]any `json:"days"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Days) != 1 || body.Days[0]["day"] != "2026-09-30" || body.Days[0]["health_gaps"] != float64(5) || body.Days[0]["never_healthy"] != float64(1) {
+		t.Fatalf("unexpected days %v", body.Days)
+	}
+
+	for _, query := range []string{"from=2026-09-31", "from=2026-09-10&to=2026-09-01", "limit=501"} {
+		if rr := adminRequest(t, router, http.MethodGet, "/api/v1/admin/relays/quality?"+query, nil); rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", query, rr.Code)
+		}
+	}
+}
+
+func adminRequest(t *testing.T, router http.Handler, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var req *http.Request
+	if body != nil {
+		req = httptest.NewRequest(method, path, jsonBody(body))
+	} else {
+		req = httptest.NewRequest(method, path, nil)
+	}
+	req.Header.Set("Authorization", "Bearer "+testAdminJWT(t, "test-secret", "usr_admin"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAdminStopSession_StopsOnBehalfOfOwner(t *testing.T) {
+	var stoppedFor, reason string
+	ms := &mockStore{
+		getSessionRelayFn: func(_ context.Context, sessionID string) (*model.SessionRelay, error) {
+			return &model.SessionRelay{SessionID: sessionID, UserID: "usr_1", SessionStatus: model.SessionActive}, nil
+		},
+		stopSessionFn: func(_ context.Context, userID, sessionID, stopReason string) (*model.Session, error) {
+			stoppedFor, reason = userID, stopReason
+			return &model.Session{ID: sessionID, UserID: userID, Status: model.SessionStopping}, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+
+	rr := adminRequest(t, router, http.MethodPost, "/api/v1/admin/sessions/ses_1/stop", nil)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if stoppedFor != "usr_1" || reason != model.StopReasonAdmin {
+		t.Fatalf("expected an admin stop as the session owner, got %q for %q", reason, stoppedFor)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/sessions/ses_1/stop", nil)
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_2"))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for non-admin, got %d", rr.Code)
+	}
+}
+
+func TestAdminStartSession_StartsNonBillableSessionAsUser(t *testing.T) {
+	var got store.StartInput
+	startErr := error(nil)
+	ms := &mockStore{
+		startOrGetSessionFn: func(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+			got = in
+			if startErr != nil {
+				return nil, false, startErr
+			}
+			return &model.Session{ID: "ses_1", UserID: in.UserID, Status: model.SessionActive, Region: in.Region}, false, nil
+		},
+	}
+	cfg := testConfig()
+	cfg.AdminMaxLiveSessions = 2
+	router := NewRouter(cfg, ms, &mockProvisioner{})
+	start := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/users/usr_1/sessions"+query, jsonBody(map[string]any{"region_preference": "us-east-1"}))
+		req.Header.Set("Authorization", "Bearer "+testAdminJWT(t, "test-secret", "usr_admin"))
+		req.Header.Set("Idempotency-Key", "4f1d3a52-8c1e-4b8e-9a55-0d6a1f2c7e90")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := start("")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"session_id":"ses_1"`) {
+		t.Fatalf("expected the session envelope, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got.UserID != "usr_1" || !got.NonBillable || got.StartedByAdmin != "usr_admin" || got.MaxAdminSessions != 2 || !got.RefuseIfLive {
+		t.Fatalf("expected a non-billable admin start as the user, got %+v", got)
+	}
+	if rr := start("?force=true"); rr.Code != http.StatusOK || got.RefuseIfLive {
+		t.Fatalf("expected force to allow a live session, got %d refuse=%t", rr.Code, got.RefuseIfLive)
+	}
+	assertAPIError(t, start("?force=maybe"), apierr.InvalidRequest)
+
+	for err, code := range map[error]apierr.Code{
+		store.ErrUserSessionsLive:  apierr.UserSessionsLive,
+		store.ErrAdminSessionLimit: apierr.AdminSessionLimit,
+		store.ErrNotFound:          apierr.NotFound,
+	} {
+		startErr = err
+		assertAPIError(t, start(""), code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/users/usr_1/sessions", jsonBody(map[string]any{}))
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_2"))
+	req.Header.Set("Idempotency-Key", "4f1d3a52-8c1e-4b8e-9a55-0d6a1f2c7e90")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for non-admin, got %d", rr.Code)
+	}
+}
+
+func TestAdminSetManifestRegion_PatchesEntry(t *testing.T) {
+	var got store.RelayManifestUpdate
+	ms := &mockStore{
+		updateManifestFn: func(_ context.Context, in store.RelayManifestUpdate) (*model.RelayManifestEntry, error) {
+			got = in
+			if in.Region != "us-east-1" {
+				return nil, store.ErrNotFound
+			}
+			return &model.RelayManifestEntry{Region: in.Region, AMIID: "ami-1", DefaultInstanceType: "t4g.small", Available: *in.Available, Enabled: true, UpdatedAt: time.Now()}, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+
+	rr := adminRequest(t, router, http.MethodPut, "/api/v1/admin/manifest/us-east-1", map[string]any{"available": false})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got.Available == nil || *got.Available || got.AMIID != "" || got.DefaultInstanceType != "" {
+		t.Fatalf("expected only availability patched, got %+v", got)
+	}
+	if !strings.Contains(rr.Body.String(), `"available":false`) {
+		t.Fatalf("expected updated entry, got %s", rr.Body.String())
+	}
+
+	if rr := adminRequest(t, router, http.MethodPut, "/api/v1/admin/manifest/mars-1", map[string]any{"available": true}); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown region, got %d", rr.Code)
+	}
+	if rr := adminRequest(t, router, http.MethodPut, "/api/v1/admin/manifest/us-east-1", map[string]any{}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for empty patch, got %d", rr.Code)
+	}
+}
+
+func TestAdminSetManifestRegion_RejectsInvalidValues(t *testing.T) {
+	ms := &mockStore{
+		updateManifestFn: func(context.Context, store.RelayManifestUpdate) (*model.RelayManifestEntry, error) {
+			t.Fatal("expected the update not to reach the store")
+			return nil, nil
+		},
+	}
+	cfg := testConfig()
+	cfg.RelayProvider = "aws"
+	router := NewRouter(cfg, ms, &mockProvisioner{})
+
+	for path, body := range map[string]map[string]any{
+		"/api/v1/admin/manifest/US_EAST_1": {"available": true},
+		"/api/v1/admin/manifest/us-east-1": {"ami_id": "ami-not-hex"},
+	} {
+		rr := adminRequest(t, router, http.MethodPut, path, body)
+		assertAPIError(t, rr, apierr.InvalidField)
+	}
+}
+
+func TestAdminSwitchManifestRegion_TogglesRegion(t *testing.T) {
+	var got store.RelayManifestUpdate
+	ms := &mockStore{
+		updateManifestFn: func(_ context.Context, in store.RelayManifestUpdate) (*model.RelayManifestEntry, error) {
+			got = in
+			if in.Region != "us-east-1" {
+				return nil, store.ErrNotFound
+			}
+			return &model.RelayManifestEntry{Region: in.Region, AMIID: "ami-1", Available: true, Enabled: *in.Enabled, UpdatedAt: time.Now()}, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+
+	rr := adminRequest(t, router, http.MethodPatch, "/api/v1/admin/relay/manifest/us-east-1", map[string]any{"enabled": false})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got.Enabled == nil || *got.Enabled || got.Available != nil || got.AMIID != "" {
+		t.Fatalf("expected only the switch changed, got %+v", got)
+	}
+	if !strings.Contains(rr.Body.String(), `"enabled":false`) || !strings.Contains(rr.Body.String(), `"available":false`) {
+		t.Fatalf("expected the region switched off, got %s", rr.Body.String())
+	}
+
+	if rr := adminRequest(t, router, http.MethodPatch, "/api/v1/admin/relay/manifest/us-east-1", map[string]any{"enabled": true}); !strings.Contains(rr.Body.String(), `"enabled":true`) {
+		t.Fatalf("expected the region switched back on, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if rr := adminRequest(t, router, http.MethodPatch, "/api/v1/admin/relay/manifest/mars-1", map[string]any{"enabled": true}); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown region, got %d", rr.Code)
+	}
+	if rr := adminRequest(t, router, http.MethodPatch, "/api/v1/admin/relay/manifest/us-east-1", map[string]any{}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without enabled, got %d", rr.Code)
+	}
+	assertAPIError(t, adminRequest(t, router, http.MethodPatch, "/api/v1/admin/relay/manifest/US_EAST_1", map[string]any{"enabled": true}), apierr.InvalidField)
+}
+
+func TestAdminRunJob_QueuesKnownJobs(t *testing.T) {
+	ms := &mockStore{}
+	cfg := testConfig()
+	cfg.ExternalBaseURL = "https://aegis.example.com"
+	router := NewRouter(cfg, ms, &mockProvisioner{})
+
+	rr := adminRequest(t, router, http.MethodPost, "/api/v1/admin/jobs/session_usage_rollup/runs", nil)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Location"); got != "https://aegis.example.com/api/v1/admin/jobs/runs/1" {
+		t.Fatalf("expected the run's absolute URL as Location, got %q", got)
+	}
+	var body struct {
+		Run map[string]any `json:"run"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Run["run_id"] != float64(1) || body.Run["status"] != "pending" || body.Run["requested_by"] != "usr_admin" {
+		t.Fatalf("unexpected run %v", body.Run)
+	}
+
+	if rr := adminRequest(t, router, http.MethodGet, "/api/v1/admin/jobs/runs/1", nil); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"job":"session_usage_rollup"`) {
+		t.Fatalf("expected run lookup, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if rr := adminRequest(t, router, http.MethodPost, "/api/v1/admin/jobs/drop_tables/runs", nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown job, got %d", rr.Code)
+	}
+	if len(ms.jobRuns) != 1 {
+		t.Fatalf("expected one queued run, got %v", ms.jobRuns)
+	}
+}
+
+func TestAdminSessionHealth_ListsHeartbeats(t *testing.T) {
+	observedAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	var gotLimit int
+	ms := &mockStore{
+		listRelayHealthFn: func(_ context.Context, sessionID string, limit int) ([]model.RelayHealthEvent, error) {
+			gotLimit = limit
+			return []model.RelayHealthEvent{{SessionID: sessionID, RelayInstanceID: "rly_1", ObservedAt: observedAt, IngestActive: true, SessionUptimeSeconds: 90}}, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+
+	rr := adminRequest(t, router, http.MethodGet, "/api/v1/admin/sessions/ses_1/health?limit=1", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if gotLimit != 1 || !strings.Contains(rr.Body.String(), `"observed_at":"2026-03-01T12:00:00Z"`) {
+		t.Fatalf("unexpected health response limit=%d body=%s", gotLimit, rr.Body.String())
+	}
+}
+
+func TestAdminSessionRelay_ListsProvisionAttempts(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	ms := &mockStore{
+		startOrGetSessionFn: func(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+			return &model.Session{ID: "ses_1", UserID: in.UserID, Status: model.SessionProvisioning, Region: in.Region}, true, nil
+		},
+		getSessionRelayFn: func(_ context.Context, sessionID string) (*model.SessionRelay, error) {
+			return &model.SessionRelay{SessionID: sessionID, UserID: "usr_1", SessionStatus: model.SessionStopped}, nil
+		},
+		stopSessionFn: func(_ context.Context, _, sessionID, _ string) (*model.Session, error) {
+			return &model.Session{ID: sessionID, Status: model.SessionStopped}, nil
+		},
+	}
+	mp := &mockProvisioner{
+		provisionFn: func(context.Context, relay.ProvisionRequest) (relay.ProvisionResult, error) {
+			return relay.ProvisionResult{}, &smithy.GenericAPIError{Code: "UnauthorizedOperation", Message: "denied"}
+		},
+	}
+	cfg := testConfig()
+	cfg.RelayProvider = "aws"
+	router := NewRouter(cfg, ms, mp)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, relayStartRequestFor(t, "us-east-1"))
+	assertAPIError(t, rr, apierr.InternalError)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/sessions/ses_1/relay", nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminJWT(t, "test-secret", "usr_admin"))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		ProvisionAttempts []map[string]any `json:"provision_attempts"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.ProvisionAttempts) != 1 {
+		t.Fatalf("expected one attempt, got %s", rr.Body.String())
+	}
+	a := body.ProvisionAttempts[0]
+	if a["outcome"] != "failed" || a["aws_error_code"] != "UnauthorizedOperation" || a["region"] != "us-east-1" || a["compensation"] != "session_stopped" {
+		t.Fatalf("unexpected attempt: %s", rr.Body.String())
+	}
+	if !strings.Contains(metrics.Default().Render(), `aegis_relay_provision_attempts_total{error_code="UnauthorizedOperation",outcome="failed",provider="aws",region="us-east-1"} 1`) {
+		t.Fatalf("expected the attempt counted by error code, got:\n%s", metrics.Default().Render())
+	}
+}
+
+func TestAdminEraseUserData_RequiresConfirmationAndStopsLiveSessions(t *testing.T) {
+	var stopped []string
+	var erasedFor, erasedBy string
+	ms := &mockStore{
+		listLiveSessionsFn: func(_ context.Context, userID string) ([]model.Session, error) {
+			return []model.Session{
+				{ID: "ses_1", UserID: userID, Status: model.SessionActive},
+				{ID: "ses_0", UserID: userID, Status: model.SessionStopping},
+			}, nil
+		},
+		stopSessionFn: func(_ context.Context, userID, sessionID, reason string) (*model.Session, error) {
+			stopped = append(stopped, userID+":"+sessionID+":"+reason)
+			return &model.Session{ID: sessionID, Status: model.SessionStopping}, nil
+		},
+		eraseUserDataFn: func(_ context.Context, userID, requestedBy string) (*model.UserDataErasure, bool, error) {
+			erasedFor, erasedBy = userID, requestedBy
+			return &model.UserDataErasure{
+				TombstoneUserID: "usr_erased_1",
+				RequestedBy:     requestedBy,
+				Rows:            map[string]int{"sessions": 2, "usage_records": 2},
+				ErasedAt:        time.Now(),
+			}, true, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+
+	rr := adminRequest(t, router, http.MethodDelete, "/api/v1/admin/users/usr_1/data", nil)
+	assertAPIError(t, rr, apierr.ConfirmationRequired)
+	if erasedFor != "" || len(stopped) != 0 {
+		t.Fatal("expected nothing stopped or erased without confirmation")
+	}
+
+	rr = adminRequest(t, router, http.MethodPost, "/api/v1/admin/users/usr_1/data/erasure-token", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var issued struct {
+		Token string `json:"confirmation_token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &issued); err != nil || issued.Token == "" {
+		t.Fatalf("expected a confirmation token, got %s", rr.Body.String())
+	}
+
+	// A token confirms the erasure of one user only.
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/admin/users/usr_2/data", nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminJWT(t, "test-secret", "usr_admin"))
+	req.Header.Set("X-Confirmation-Token", issued.Token)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assertAPIError(t, rr, apierr.ConfirmationRequired)
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/v1/admin/users/usr_1/data", nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminJWT(t, "test-secret", "usr_admin"))
+	req.Header.Set("X-Confirmation-Token", issued.Token)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if len(stopped) != 1 || stopped[0] != "usr_1:ses_1:"+model.StopReasonErasure {
+		t.Fatalf("expected the active session stopped for erasure, got %v", stopped)
+	}
+	if erasedFor != "usr_1" || erasedBy != "usr_admin" {
+		t.Fatalf("expected usr_1 erased by usr_admin, got %q by %q", erasedFor, erasedBy)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, `"tombstone_user_id":"usr_erased_1"`) || !strings.Contains(body, `"usage_records":2`) || !strings.Contains(body, `"replayed":false`) {
+		t.Fatalf("unexpected erasure response: %s", body)
+	}
+}
+
+func TestErasureToken_Expires(t *testing.T) {
+	now := time.Now()
+	token := erasureToken("secret", "usr_admin", "usr_1", now.Add(time.Minute))
+	if !validErasureToken("secret", "usr_admin", "usr_1", token, now) {
+		t.Fatal("expected the token valid before it expires")
+	}
+	if validErasureToken("secret", "usr_admin", "usr_1", token, now.Add(2*time.Minute)) {
+		t.Fatal("expected the token rejected once expired")
+	}
+	if validErasureToken("secret", "usr_other", "usr_1", token, now) {
+		t.Fatal("expected the token rejected for another admin")
+	}
+}
+
+func TestAdminOverview_ReturnsOtherSectionsWhenOneFails(t *testing.T) {
+	updated := time.Now().Add(-5 * time.Minute)
+	var staleTimeout time.Duration
+	var terminatedSince time.Time
+	var costUsers int
+	ms := &mockStore{
+		countSessionsFn: func(context.Context) (map[model.SessionStatus]map[string]int, error) {
+			return map[model.SessionStatus]map[string]int{
+				model.SessionActive:   {"us-east-1": 3},
+				model.SessionGrace:    {"us-east-1": 1},
+				model.SessionStopping: {"eu-west-1": 2},
+			}, nil
+		},
+		provisionStatsFn: func(context.Context, time.Time) (model.ProvisionStats, error) {
+			return model.ProvisionStats{Attempts: 4, Succeeded: 3, P95Latency: 1500 * time.Millisecond}, nil
+		},
+		countStaleRelaysFn: func(_ context.Context, timeout time.Duration) (int, error) {
+			staleTimeout = timeout
+			return 2, nil
+		},
+		pendingTerminationsFn: func(context.Context) (int, error) {
+			return 0, errors.New("db timeout")
+		},
+		instanceSecondsFn: func(_ context.Context, since time.Time) (map[string]int64, error) {
+			terminatedSince = since
+			return map[string]int64{model.StopReasonUser: 7200, model.TerminateReasonCompensation: 90}, nil
+		},
+		costOverviewFn: func(_ context.Context, topUsers int) (model.CostOverview, error) {
+			costUsers = topUsers
+			return model.CostOverview{LiveSessions: 2, BurnUSDPerHour: 0.0336, LiveEstimatedCostUSD: 0.5, TopUsers: []model.UserCycleCost{
+				{UserID: "usr_1", CycleStart: updated, CycleEnd: updated.AddDate(0, 1, 0), BillableSeconds: 7200, EstimatedCostUSD: 1.25},
+			}}, nil
+		},
+		listRelayManifestFn: func(context.Context) ([]model.RelayManifestEntry, error) {
+			return []model.RelayManifestEntry{{Region: "us-east-1", Available: true, Enabled: true, UpdatedAt: updated}, {Region: "eu-west-1", Enabled: true, UpdatedAt: time.Now()}}, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+
+	rr := adminRequest(t, router, http.MethodGet, "/api/v1/admin/overview", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Sessions     map[string]map[string]int `json:"sessions"`
+		Provisioning struct {
+			SuccessRate  float64 `json:"success_rate"`
+			P95LatencyMS int64   `json:"p95_latency_ms"`
+		} `json:"provisioning"`
+		StaleRelays         *int `json:"stale_relays"`
+		PendingTerminations *int `json:"pending_terminations"`
+		Terminations        struct {
+			WindowSeconds   int              `json:"window_seconds"`
+			InstanceSeconds map[string]int64 `json:"instance_seconds"`
+		} `json:"terminations"`
+		Cost struct {
+			BurnUSDPerHour float64 `json:"burn_usd_per_hour"`
+			TopUsers       []struct {
+				UserID           string  `json:"user_id"`
+				EstimatedCostUSD float64 `json:"estimated_cost_usd"`
+			} `json:"top_users"`
+		} `json:"cost"`
+		Manifest struct {
+			AvailableRegions int `json:"available_regions"`
+			AgeSeconds       int `json:"age_seconds"`
+		} `json:"manifest"`
+		Warnings []overviewWarning `json:"warnings"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Sessions) != 1 || body.Sessions["us-east-1"]["active"] != 3 || body.Sessions["us-east-1"]["grace"] != 1 {
+		t.Fatalf("expected live us-east-1 sessions only, got %v", body.Sessions)
+	}
+	if body.Provisioning.SuccessRate != 0.75 || body.Provisioning.P95LatencyMS != 1500 {
+		t.Fatalf("unexpected provisioning: %+v", body.Provisioning)
+	}
+	if body.StaleRelays == nil || *body.StaleRelays != 2 || staleTimeout != jobs.RelayHeartbeatTimeout {
+		t.Fatalf("expected 2 stale relays by the replacement timeout, got %v (timeout %s)", body.StaleRelays, staleTimeout)
+	}
+	if body.PendingTerminations != nil {
+		t.Fatalf("expected pending_terminations null, got %d", *body.PendingTerminations)
+	}
+	if body.Terminations.WindowSeconds != 86400 || body.Terminations.InstanceSeconds["user"] != 7200 ||
+		body.Terminations.InstanceSeconds["compensation"] != 90 || time.Since(terminatedSince) < 24*time.Hour-time.Minute {
+		t.Fatalf("expected instance seconds per reason over a day, got %+v since %s", body.Terminations, terminatedSince)
+	}
+	if body.Cost.BurnUSDPerHour != 0.0336 || len(body.Cost.TopUsers) != 1 || body.Cost.TopUsers[0].EstimatedCostUSD != 1.25 || costUsers != overviewCostUsers {
+		t.Fatalf("expected the burn rate and top users by cost, got %+v (asked for %d)", body.Cost, costUsers)
+	}
+	if body.Manifest.AvailableRegions != 1 || body.Manifest.AgeSeconds < 299 {
+		t.Fatalf("expected manifest age from the oldest entry, got %+v", body.Manifest)
+	}
+	if len(body.Warnings) != 1 || body.Warnings[0].Section != "pending_terminations" || strings.Contains(body.Warnings[0].Message, "db timeout") {
+		t.Fatalf("expected one pending_terminations warning without the store error, got %+v", body.Warnings)
+	}
+}
+
+func TestAdminOverview_ServesCachedSummary(t *testing.T) {
+	reads := 0
+	ms := &mockStore{
+		pendingTerminationsFn: func(context.Context) (int, error) {
+			reads++
+			return reads, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+
+	for i := 0; i < 3; i++ {
+		rr := adminRequest(t, router, http.MethodGet, "/api/v1/admin/overview", nil)
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"pending_terminations":1`) {
+			t.Fatalf("expected the first summary, got %d body=%s", rr.Code, rr.Body.String())
+		}
+	}
+	if reads != 1 {
+		t.Fatalf("expected the store read once, got %d", reads)
+	}
+}
+
+func TestNewRouters_ServesAdminRoutesOnlyOnAdminListener(t *testing.T) {
+	cfg := testConfig()
+	cfg.AdminListenAddr = "127.0.0.1:9090"
+	ms := &mockStore{
+		listSessionsFn: func(context.Context, string, int) ([]model.Session, error) { return nil, nil },
+	}
+	public, admin := NewRouters(cfg, ms, &mockProvisioner{})
+	if admin == nil {
+		t.Fatal("expected an admin handler")
+	}
+
+	if rr := adminRequest(t, public, http.MethodGet, "/api/v1/admin/sessions", nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected admin routes absent from the public listener, got %d", rr.Code)
+	}
+	if rr := adminRequest(t, admin, http.MethodGet, "/api/v1/admin/sessions", nil); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 on the admin listener, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if rr := adminRequest(t, admin, http.MethodGet, "/api/v1/relay/active", nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected user routes absent from the admin listener, got %d", rr.Code)
+	}
+	rr := httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected healthz on the admin listener, got %d", rr.Code)
+	}
+
+	cfg.AdminListenAddr = ""
+	if _, admin := NewRouters(cfg, ms, &mockProvisioner{}); admin != nil {
+		t.Fatal("expected no admin handler without AEGIS_ADMIN_LISTEN_ADDR")
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+func allowanceStore(usage *model.UsageCurrent, maxSessionSeconds int, usageReads *int) *mockStore {
+	return &mockStore{
+		recordRelayHealthEventFn: func(context.Context, store.RelayHealthInput) error { return nil },
+		getSessionTimersFn: func(_ context.Context, sessionID string) (*model.Session, error) {
+			return &model.Session{ID: sessionID, UserID: "usr_1", Status: model.SessionActive, StartedAt: time.Now().Add(-time.Hour), MaxSessionSeconds: maxSessionSeconds}, nil
+		},
+		getUsageCurrentFn: func(context.Context, string, int) (*model.UsageCurrent, error) {
+			if usageReads != nil {
+				*usageReads++
+			}
+			return usage, nil
+		},
+	}
+}
+
+// postHealth sends a heartbeat and returns remaining_allowed_seconds.
+func postHealth(t *testing.T, router http.Handler) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/health", jsonBody(map[string]any{"session_id": "ses_1", "ingest_active": true}))
+	req.Header.Set("X-Relay-Auth", "relay-key")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		RemainingAllowedSeconds *int `json:"remaining_allowed_seconds"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.RemainingAllowedSeconds == nil {
+		t.Fatalf("expected remaining_allowed_seconds, got %s", rr.Body.String())
+	}
+	return *body.RemainingAllowedSeconds
+}
+
+func TestRelayHealth_ExhaustedUserGetsZero(t *testing.T) {
+	usage := &model.UsageCurrent{PlanTier: "free", IncludedSeconds: 3600, ConsumedSeconds: 3700}
+	router := NewRouter(testConfig(), allowanceStore(usage, 57600, nil), &mockProvisioner{})
+	if got := postHealth(t, router); got != 0 {
+		t.Fatalf("expected 0 for an exhausted user, got %d", got)
+	}
+}
+
+func TestRelayHealth_UnlimitedTier(t *testing.T) {
+	usage := &model.UsageCurrent{PlanTier: "pro", IncludedSeconds: 3600, ConsumedSeconds: 7200}
+	if got := postHealth(t, NewRouter(testConfig(), allowanceStore(usage, 0, nil), &mockProvisioner{})); got != noLimit {
+		t.Fatalf("expected %d for an unlimited tier without a session cap, got %d", noLimit, got)
+	}
+	// The session's maximum duration still applies.
+	got := postHealth(t, NewRouter(testConfig(), allowanceStore(usage, 2*3600, nil), &mockProvisioner{}))
+	if got < 3600-5 || got > 3600 {
+		t.Fatalf("expected about an hour left of the session, got %d", got)
+	}
+}
+
+func TestRelayHealth_AllowanceIsLowerLimitAndCached(t *testing.T) {
+	usage := &model.UsageCurrent{PlanTier: "free", IncludedSeconds: 3600, ConsumedSeconds: 3500, RemainingSeconds: 100}
+	reads := 0
+	router := NewRouter(testConfig(), allowanceStore(usage, 57600, &reads), &mockProvisioner{})
+	if got := postHealth(t, router); got < 99 || got > 100 {
+		t.Fatalf("expected the plan's 100s to bound the allowance, got %d", got)
+	}
+	if got := postHealth(t, router); got < 99 || got > 100 {
+		t.Fatalf("expected the cached allowance, got %d", got)
+	}
+	if reads != 1 {
+		t.Fatalf("expected one usage read for both heartbeats, got %d", reads)
+	}
+}
+
+func TestAllowanceCache_CountsDownAndExpires(t *testing.T) {
+	c := newAllowanceCache()
+	now := time.Now()
+	c.put("ses_1", 100, now)
+	c.put("ses_2", noLimit, now)
+	if got, ok := c.get("ses_1", now.Add(10*time.Second)); !ok || got != 90 {
+		t.Fatalf("expected 90 after 10s, got %d ok=%v", got, ok)
+	}
+	if got, ok := c.get("ses_2", now.Add(10*time.Second)); !ok || got != noLimit {
+		t.Fatalf("expected no limit, got %d ok=%v", got, ok)
+	}
+	if _, ok := c.get("ses_1", now.Add(allowanceCacheTTL)); ok {
+		t.Fatal("expected the entry to expire")
+	}
+	c.put("ses_3", 5, now.Add(allowanceCacheTTL))
+	if len(c.entries) != 1 {
+		t.Fatalf("expected expired entries swept, got %d", len(c.entries))
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+func TestRelayAuthorizeIP_ReauthorizesForwardedIP(t *testing.T) {
+	var recorded string
+	ms := &mockStore{
+		getRelayAccessFn: func(_ context.Context, userID string) (*model.RelayAccess, error) {
+			return &model.RelayAccess{SessionID: "ses_1", RelayInstanceID: "rly_1", Region: "us-east-1", SecurityGroupID: "sg-1", SRTPorts: []int{9000, 9001}}, nil
+		},
+		updateAllowedClientIPFn: func(_ context.Context, relayInstanceID, clientIP string) error {
+			recorded = relayInstanceID + "=" + clientIP
+			return nil
+		},
+	}
+	var got relay.AuthorizeClientIPRequest
+	mp := &mockProvisioner{
+		authorizeFn: func(_ context.Context, req relay.AuthorizeClientIPRequest) error {
+			got = req
+			return nil
+		},
+	}
+
+	router := NewRouter(testConfig(), ms, mp)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/authorize-ip", nil)
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	req.Header.Set("X-Forwarded-For", "198.51.100.23")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got.SecurityGroupID != "sg-1" || got.ClientIP != "198.51.100.23" || !slices.Equal(got.SRTPorts, []int{9000, 9001}) || got.Region != "us-east-1" {
+		t.Fatalf("unexpected authorize request: %+v", got)
+	}
+	if recorded != "rly_1=198.51.100.23" {
+		t.Fatalf("expected new ip recorded, got %q", recorded)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body["client_ip"] != "198.51.100.23" || body["session_id"] != "ses_1" {
+		t.Fatalf("unexpected body: %v", body)
+	}
+}
+
+func TestRelayAuthorizeIP_Errors(t *testing.T) {
+	cases := []struct {
+		name   string
+		access *model.RelayAccess
+		status int
+		code   string
+	}{
+		{name: "no active relay", status: http.StatusNotFound, code: "not_found"},
+		{name: "relay without ip lock", access: &model.RelayAccess{SessionID: "ses_1", RelayInstanceID: "rly_1"}, status: http.StatusConflict, code: "ip_lock_disabled"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := &mockStore{
+				getRelayAccessFn: func(_ context.Context, _ string) (*model.RelayAccess, error) {
+					if tc.access == nil {
+						return nil, store.ErrNotFound
+					}
+					return tc.access, nil
+				},
+			}
+			router := NewRouter(testConfig(), ms, &mockProvisioner{})
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/authorize-ip", nil)
+			req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tc.status {
+				t.Fatalf("expected %d, got %d body=%s", tc.status, rr.Code, rr.Body.String())
+			}
+			var body struct {
+				Error struct {
+					Code string `json:"code"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || body.Error.Code != tc.code {
+				t.Fatalf("expected error code %s, got %s", tc.code, rr.Body.String())
+			}
+		})
+	}
+}
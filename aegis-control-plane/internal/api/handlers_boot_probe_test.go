@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+type probeFunc func(context.Context, relay.ProvisionResult) error
+
+func (f probeFunc) WaitReady(ctx context.Context, res relay.ProvisionResult) error {
+	return f(ctx, res)
+}
+
+func startWithBootProbe(t *testing.T, ms *mockStore, probe relay.BootProbe) *httptest.ResponseRecorder {
+	t.Helper()
+	cfg := testConfig()
+	cfg.RelayBootProbe = true
+	cfg.RelayBootProbeTimeout = 50 * time.Millisecond
+	router := NewRouter(cfg, ms, &mockProvisioner{}, WithBootProbe(probe))
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/start", jsonBody(map[string]any{"region_preference": "us-east-1"}))
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	req.Header.Set("Idempotency-Key", "0b8c7f0e-5d0a-4f43-9b7e-2f4c9f1f6a11")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func provisioningSessionStore(activateCalls, stopCalls *int) *mockStore {
+	return &mockStore{
+		startOrGetSessionFn: func(_ context.Context, _ store.StartInput) (*model.Session, bool, error) {
+			return &model.Session{ID: "ses_probe", UserID: "usr_1", Status: model.SessionProvisioning, Region: "us-east-1"}, true, nil
+		},
+		activateSessionFn: func(_ context.Context, in store.ActivateProvisionedSessionInput) (*model.Session, error) {
+			*activateCalls++
+			return &model.Session{ID: in.SessionID, UserID: in.UserID, Status: model.SessionActive, Region: in.Region}, nil
+		},
+		stopProvisionedFn: func(_ context.Context, userID, sessionID, _, awsInstanceID string) (*model.Session, error) {
+			*stopCalls++
+			return &model.Session{ID: sessionID, UserID: userID, Status: model.SessionStopping}, nil
+		},
+	}
+}
+
+func TestRelayStart_BootProbeGatesActivation(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	var activateCalls, stopCalls int
+	var probed string
+	rr := startWithBootProbe(t, provisioningSessionStore(&activateCalls, &stopCalls), probeFunc(func(_ context.Context, res relay.ProvisionResult) error {
+		probed = res.WSURL
+		return nil
+	}))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if probed != "wss://relay.default/ws" || activateCalls != 1 || stopCalls != 0 {
+		t.Fatalf("expected probe then activation, probed=%q activate=%d stop=%d", probed, activateCalls, stopCalls)
+	}
+	if out := metrics.Default().Render(); !strings.Contains(out, `aegis_relay_boot_ready_ms_count{provider="",region="us-east-1",status="ok"} 1`) {
+		t.Fatalf("expected boot ready histogram, got:\n%s", out)
+	}
+	if out := metrics.Default().Render(); !strings.Contains(out, `aegis_relay_provision_phase_ms_count{instance_type="t4g.small",phase="boot_probe",provider="",region="us-east-1",status="ok"} 1`) {
+		t.Fatalf("expected boot_probe phase, got:\n%s", out)
+	}
+}
+
+func TestRelayStart_BootProbeTimeoutCompensates(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	var activateCalls, stopCalls int
+	rr := startWithBootProbe(t, provisioningSessionStore(&activateCalls, &stopCalls), probeFunc(func(ctx context.Context, _ relay.ProvisionResult) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if activateCalls != 0 || stopCalls != 1 {
+		t.Fatalf("expected the launched relay to be stopped without activation, activate=%d stop=%d", activateCalls, stopCalls)
+	}
+	if out := metrics.Default().Render(); !strings.Contains(out, `aegis_relay_boot_ready_ms_count{provider="",region="us-east-1",status="timeout"} 1`) {
+		t.Fatalf("expected boot ready timeout, got:\n%s", out)
+	}
+}
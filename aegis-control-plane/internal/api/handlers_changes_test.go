@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/config"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+func changesConfig() config.Config {
+	cfg := testConfig()
+	cfg.InternalToken = "internal-token"
+	return cfg
+}
+
+func changesRequest(router http.Handler, query, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/internal/changes?"+query, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+type changesBody struct {
+	Changes []sessionChange `json:"changes"`
+	NextSeq int64           `json:"next_seq"`
+}
+
+func TestInternalChanges_ReturnsChangesAfterSeq(t *testing.T) {
+	changedAt := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	var gotAfter int64
+	var gotLimit int
+	ms := &mockStore{
+		listSessionChangesFn: func(_ context.Context, afterSeq int64, limit int) ([]model.SessionChange, error) {
+			gotAfter, gotLimit = afterSeq, limit
+			return []model.SessionChange{
+				{Seq: 8, SessionID: "ses_1", UserID: "usr_1", Region: "us-east-1", Status: model.SessionActive, PreviousStatus: model.SessionProvisioning, ChangedAt: changedAt},
+				{Seq: 9, SessionID: "ses_2", UserID: "usr_2", Region: "eu-west-1", Status: model.SessionProvisioning, ChangedAt: changedAt},
+			}, nil
+		},
+	}
+	router := NewRouter(changesConfig(), ms, &mockProvisioner{})
+
+	rr := changesRequest(router, "after_seq=7&limit=50&consumer=analytics", "internal-token")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if gotAfter != 7 || gotLimit != 50 {
+		t.Fatalf("expected changes after 7 limited to 50, got after=%d limit=%d", gotAfter, gotLimit)
+	}
+	var body changesBody
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.NextSeq != 9 || len(body.Changes) != 2 {
+		t.Fatalf("unexpected body %s", rr.Body.String())
+	}
+	if c := body.Changes[0]; c.PreviousStatus == nil || *c.PreviousStatus != "provisioning" || c.StopReason != nil || c.ChangedAt != "2026-10-01T12:00:00Z" {
+		t.Fatalf("unexpected change %+v", c)
+	}
+	if body.Changes[1].PreviousStatus != nil {
+		t.Fatalf("expected a new session without a previous status, got %+v", body.Changes[1])
+	}
+	if ms.ackedChanges["analytics"] != 7 {
+		t.Fatalf("expected analytics to acknowledge 7, got %v", ms.ackedChanges)
+	}
+}
+
+func TestInternalChanges_RequiresInternalToken(t *testing.T) {
+	router := NewRouter(changesConfig(), &mockStore{}, &mockProvisioner{})
+	for _, token := range []string{"", "wrong", testJWT(t, "test-secret", "usr_admin")} {
+		assertAPIError(t, changesRequest(router, "", token), apierr.Unauthorized)
+	}
+
+	// Without a configured token nothing gets in.
+	router = NewRouter(testConfig(), &mockStore{}, &mockProvisioner{})
+	assertAPIError(t, changesRequest(router, "", "internal-token"), apierr.Unauthorized)
+}
+
+func TestInternalChanges_ExpiredPositionIsNotFound(t *testing.T) {
+	ms := &mockStore{
+		listSessionChangesFn: func(context.Context, int64, int) ([]model.SessionChange, error) {
+			return nil, store.ErrNotFound
+		},
+	}
+	router := NewRouter(changesConfig(), ms, &mockProvisioner{})
+	assertAPIError(t, changesRequest(router, "after_seq=7&wait=5", "internal-token"), apierr.NotFound)
+}
+
+func TestInternalChanges_RejectsInvalidParameters(t *testing.T) {
+	router := NewRouter(changesConfig(), &mockStore{}, &mockProvisioner{})
+	for _, query := range []string{"after_seq=-1", "after_seq=x", "limit=0", "limit=1001", "wait=31", "wait=-1", "consumer=Analytics!"} {
+		assertAPIError(t, changesRequest(router, query, "internal-token"), apierr.InvalidRequest)
+	}
+}
+
+func TestInternalChanges_WaitsForNewChanges(t *testing.T) {
+	prev := changesPollInterval
+	changesPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { changesPollInterval = prev })
+
+	var calls atomic.Int32
+	ms := &mockStore{
+		listSessionChangesFn: func(_ context.Context, afterSeq int64, _ int) ([]model.SessionChange, error) {
+			if calls.Add(1) < 3 {
+				return nil, nil
+			}
+			return []model.SessionChange{{Seq: afterSeq + 1, SessionID: "ses_1", Status: model.SessionStopped, StopReason: "user"}}, nil
+		},
+	}
+	router := NewRouter(changesConfig(), ms, &mockProvisioner{})
+
+	rr := changesRequest(router, "after_seq=3&wait=5", "internal-token")
+	var body changesBody
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if rr.Code != http.StatusOK || len(body.Changes) != 1 || body.NextSeq != 4 || *body.Changes[0].StopReason != "user" {
+		t.Fatalf("expected the change that arrived while waiting, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("expected three reads, got %d", calls.Load())
+	}
+}
+
+func TestInternalChanges_WaitEndsEmpty(t *testing.T) {
+	prev := changesPollInterval
+	changesPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { changesPollInterval = prev })
+
+	router := NewRouter(changesConfig(), &mockStore{}, &mockProvisioner{})
+	start := time.Now()
+	rr := changesRequest(router, "after_seq=12&wait=1", "internal-token")
+	if took := time.Since(start); took < time.Second {
+		t.Fatalf("expected the read to wait a second, took %s", took)
+	}
+	if rr.Code != http.StatusOK || rr.Body.String() != "{\"changes\":[],\"next_seq\":12}\n" {
+		t.Fatalf("expected no changes and the same seq, got %d body=%q", rr.Code, rr.Body.String())
+	}
+}
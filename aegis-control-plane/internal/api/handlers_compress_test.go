@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+func gunzip(t *testing.T, body []byte) []byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("gunzip: %v", err)
+	}
+	return out
+}
+
+func TestCompressResponses_DecodedBodiesMatch(t *testing.T) {
+	var rows []model.UsageExportRow
+	for range 50 {
+		rows = append(rows, exportRows()...)
+	}
+	ms := &mockStore{
+		exportUsageFn: func(context.Context, time.Time, time.Time) (store.UsageExport, error) {
+			return &sliceUsageExport{rows: rows}, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+testAdminJWT(t, "test-secret", "usr_admin"))
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, path := range []string{
+		"/api/v1/admin/usage/export?cycle_start=2026-03-01",
+		"/api/v1/admin/usage/export?cycle_start=2026-03-01&format=json",
+		"/api/v1/openapi.json",
+	} {
+		plain := get(path, "")
+		zipped := get(path, "br;q=1.0, gzip;q=0.8")
+		if plain.Code != http.StatusOK || zipped.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d and %d", path, plain.Code, zipped.Code)
+		}
+		if plain.Header().Get("Content-Encoding") != "" || zipped.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("%s: unexpected encodings %q and %q", path, plain.Header().Get("Content-Encoding"), zipped.Header().Get("Content-Encoding"))
+		}
+		if zipped.Header().Get("Vary") != "Accept-Encoding" || plain.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("%s: expected Vary: Accept-Encoding", path)
+		}
+		if zipped.Body.Len() >= plain.Body.Len() {
+			t.Fatalf("%s: compressed body is %d bytes, plain %d", path, zipped.Body.Len(), plain.Body.Len())
+		}
+		if !bytes.Equal(gunzip(t, zipped.Body.Bytes()), plain.Body.Bytes()) {
+			t.Fatalf("%s: decoded body differs from the uncompressed one", path)
+		}
+	}
+
+	if rr := get("/api/v1/openapi.json", "gzip;q=0"); rr.Header().Get("Content-Encoding") != "" {
+		t.Fatal("expected gzip;q=0 to refuse compression")
+	}
+}
+
+func TestCompressResponses_SkipsSmallResponsesAndMetrics(t *testing.T) {
+	small := compressResponses(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusCreated, map[string]any{"status": "ok"})
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	small.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated || rr.Header().Get("Content-Encoding") != "" || rr.Body.String() != "{\"status\":\"ok\"}\n" {
+		t.Fatalf("expected a small response as is, got %d %q %q", rr.Code, rr.Header().Get("Content-Encoding"), rr.Body.String())
+	}
+
+	router := NewRouter(testConfig(), &mockStore{}, &mockProvisioner{})
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Body.Len() < compressMinBytes || rr.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("expected /metrics uncompressed, got %d bytes with encoding %q", rr.Body.Len(), rr.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestCompressResponses_StreamsFlushedEvents(t *testing.T) {
+	prev := sessionEventPollInterval
+	sessionEventPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { sessionEventPollInterval = prev })
+
+	ms := &mockStore{
+		listSessionEventsFn: func(_ context.Context, userID string, afterID int64, _ int) ([]model.SessionEvent, error) {
+			if afterID >= 1 {
+				return nil, nil
+			}
+			return []model.SessionEvent{{ID: 1, SessionID: "ses_1", UserID: userID, Type: "relay_ready", Payload: json.RawMessage(`{}`)}}, nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := httptest.NewServer(NewRouter(testConfig(), ms, &mockProvisioner{}, WithStreamContext(ctx)))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/relay/events", nil)
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	req.Header.Set("Last-Event-ID", "0")
+	req.Header.Set("Accept-Encoding", "gzip")
+	// Read the raw gzip stream rather than letting the transport decode it.
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET events: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a gzip stream, got %q", resp.Header.Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	// The stream stays open, so this only returns if each flush reaches
+	// the client.
+	var lines []string
+	sc := bufio.NewScanner(zr)
+	for sc.Scan() && sc.Text() != "" {
+		lines = append(lines, sc.Text())
+	}
+	if got := strings.Join(lines, "\n"); got != "id: 1\nevent: relay_ready\ndata: {}" {
+		t.Fatalf("unexpected event:\n%s", got)
+	}
+}
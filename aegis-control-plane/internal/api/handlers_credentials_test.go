@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+func credentialedSession() *model.Session {
+	return &model.Session{
+		ID:           "ses_1",
+		UserID:       "usr_1",
+		Status:       model.SessionActive,
+		Region:       "us-east-1",
+		PairToken:    "A1B2C3D4",
+		RelayWSToken: "relaytoken",
+	}
+}
+
+func activeCredentials(t *testing.T, mask bool) map[string]any {
+	t.Helper()
+	ms := &mockStore{
+		getActiveSessionFn: func(context.Context, string) (*model.Session, error) {
+			return credentialedSession(), nil
+		},
+	}
+	cfg := testConfig()
+	cfg.MaskSessionCredentials = mask
+	router := NewRouter(cfg, ms, &mockProvisioner{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/relay/active", nil)
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Session struct {
+			Credentials map[string]any `json:"credentials"`
+		} `json:"session"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return body.Session.Credentials
+}
+
+func TestRelayActive_CredentialsMasking(t *testing.T) {
+	if got := activeCredentials(t, false); got["pair_token"] != "A1B2C3D4" || got["relay_ws_token"] != "relaytoken" || got["masked"] != nil {
+		t.Fatalf("expected full credentials with masking off, got %v", got)
+	}
+	if got := activeCredentials(t, true); got["pair_token"] != "******D4" || got["relay_ws_token"] != "******en" || got["masked"] != true {
+		t.Fatalf("expected masked credentials, got %v", got)
+	}
+}
+
+func postCredentials(t *testing.T, ms *mockStore, sessionID string) *httptest.ResponseRecorder {
+	t.Helper()
+	cfg := testConfig()
+	cfg.MaskSessionCredentials = true
+	router := NewRouter(cfg, ms, &mockProvisioner{})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/sessions/"+sessionID+"/credentials", nil)
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	req.Header.Set("X-Request-Id", "req-audit-1")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestSessionCredentials_ReturnsFullValuesAndAudits(t *testing.T) {
+	var eventType string
+	var payload map[string]any
+	ms := &mockStore{
+		getSessionByIDFn: func(_ context.Context, userID, sessionID string) (*model.Session, error) {
+			if userID != "usr_1" || sessionID != "ses_1" {
+				t.Fatalf("unexpected lookup user=%s session=%s", userID, sessionID)
+			}
+			return credentialedSession(), nil
+		},
+		recordSessionEventFn: func(_ context.Context, sessionID, userID, typ string, p any) error {
+			eventType = typ
+			payload = p.(map[string]any)
+			return nil
+		},
+	}
+	rr := postCredentials(t, ms, "ses_1")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Credentials map[string]string `json:"credentials"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Credentials["pair_token"] != "A1B2C3D4" || body.Credentials["relay_ws_token"] != "relaytoken" {
+		t.Fatalf("expected full credentials, got %v", body.Credentials)
+	}
+	if eventType != "credentials_fetched" || payload["request_id"] != "req-audit-1" {
+		t.Fatalf("expected audit event with request id, got type=%q payload=%v", eventType, payload)
+	}
+}
+
+func TestSessionCredentials_AuditFailureWithholdsCredentials(t *testing.T) {
+	ms := &mockStore{
+		getSessionByIDFn: func(context.Context, string, string) (*model.Session, error) {
+			return credentialedSession(), nil
+		},
+		recordSessionEventFn: func(context.Context, string, string, string, any) error {
+			return errors.New("db down")
+		},
+	}
+	if rr := postCredentials(t, ms, "ses_1"); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if rr := postCredentials(t, &mockStore{}, "ses_missing"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown session, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func TestDebugRoutes_AbsentUnlessEnabled(t *testing.T) {
+	router := NewRouter(testConfig(), &mockStore{}, &mockProvisioner{})
+	for _, path := range []string{"/debug/buildinfo", "/debug/vars", "/debug/pprof/", "/debug/pprof/goroutine"} {
+		if rr := adminRequest(t, router, http.MethodGet, path, nil); rr.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected 404 with pprof disabled, got %d", path, rr.Code)
+		}
+	}
+}
+
+func TestDebugRoutes_AdminOnly(t *testing.T) {
+	cfg := testConfig()
+	cfg.EnablePprof = true
+	router := NewRouter(cfg, &mockStore{}, &mockProvisioner{})
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/pprof/goroutine?debug=1", nil)
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a non-admin, got %d", rr.Code)
+	}
+
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/goroutine?debug=1", "/debug/vars"} {
+		if rr := adminRequest(t, router, http.MethodGet, path, nil); rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", path, rr.Code)
+		}
+	}
+
+	rr = adminRequest(t, router, http.MethodGet, "/debug/buildinfo", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var info map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if info["go_version"] != runtime.Version() || info["module"] == nil {
+		t.Fatalf("unexpected build info %v", info)
+	}
+}
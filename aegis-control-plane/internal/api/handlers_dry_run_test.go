@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+func dryRunManifest(context.Context) ([]model.RelayManifestEntry, error) {
+	return []model.RelayManifestEntry{
+		{Region: "us-east-1", AMIID: "ami-1", DefaultInstanceType: "t4g.small", Available: true, Enabled: true},
+		{Region: "eu-west-1", AMIID: "ami-2", DefaultInstanceType: "t4g.medium", Available: false, Enabled: true},
+	}, nil
+}
+
+func postStart(t *testing.T, router http.Handler, target string, body map[string]any) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, target, jsonBody(body))
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	req.Header.Set("Idempotency-Key", "0b8c7f0e-5d0a-4f43-9b7e-2f4c9f1f6a11")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func decodeDryRun(t *testing.T, rr *httptest.ResponseRecorder) dryRunResponse {
+	t.Helper()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var resp dryRunResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !resp.DryRun {
+		t.Fatalf("expected dry_run true, got %s", rr.Body.String())
+	}
+	return resp
+}
+
+func TestRelayStart_DryRunThenStartWithSameKey(t *testing.T) {
+	// Sessions by idempotency key, as the store records them.
+	started := map[uuid.UUID]*model.Session{}
+	starts, provisions, usageReads := 0, 0, 0
+	ms := &mockStore{
+		previewStartFn: func(_ context.Context, in store.StartInput) (store.StartPreview, error) {
+			if sess, ok := started[in.IdempotencyKey]; ok {
+				return store.StartPreview{Existing: sess, Replayed: true}, nil
+			}
+			return store.StartPreview{}, nil
+		},
+		startOrGetSessionFn: func(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+			starts++
+			if sess, ok := started[in.IdempotencyKey]; ok {
+				return sess, false, nil
+			}
+			return &model.Session{ID: "ses_1", UserID: in.UserID, Status: model.SessionProvisioning, Region: in.Region}, true, nil
+		},
+		activateSessionFn: func(_ context.Context, in store.ActivateProvisionedSessionInput) (*model.Session, error) {
+			sess := &model.Session{ID: in.SessionID, UserID: in.UserID, Status: model.SessionActive, Region: in.Region}
+			started[uuid.MustParse("0b8c7f0e-5d0a-4f43-9b7e-2f4c9f1f6a11")] = sess
+			return sess, nil
+		},
+		getUsageCurrentFn: func(context.Context, string, int) (*model.UsageCurrent, error) {
+			usageReads++
+			return &model.UsageCurrent{PlanTier: "free", IncludedSeconds: 3600, RemainingSeconds: 1200}, nil
+		},
+		previewUsageCurrentFn: func(context.Context, string, int) (*model.UsageCurrent, error) {
+			return &model.UsageCurrent{PlanTier: "free", IncludedSeconds: 3600, RemainingSeconds: 1200}, nil
+		},
+		listRelayManifestFn: dryRunManifest,
+	}
+	prov := &mockProvisioner{}
+	prov.provisionFn = func(context.Context, relay.ProvisionRequest) (relay.ProvisionResult, error) {
+		provisions++
+		return relay.ProvisionResult{AWSInstanceID: "i-1", AMIID: "ami-1", InstanceType: "t4g.small", PublicIP: "203.0.113.10", SRTPort: 9000}, nil
+	}
+	router := NewRouter(testConfig(), ms, prov)
+	body := map[string]any{"region_preference": "us-east-1"}
+
+	resp := decodeDryRun(t, postStart(t, router, "/api/v1/relay/start?dry_run=true", body))
+	if resp.Outcome != dryRunCreate || resp.Region != "us-east-1" || resp.InstanceType != "t4g.small" ||
+		resp.RemainingSeconds == nil || *resp.RemainingSeconds != 1200 || resp.Error != nil {
+		t.Fatalf("unexpected dry run: %+v", resp)
+	}
+	if starts != 0 || provisions != 0 || usageReads != 0 {
+		t.Fatalf("expected the dry run to start nothing, got %d starts, %d provisions and %d usage reads that may write", starts, provisions, usageReads)
+	}
+
+	if rr := postStart(t, router, "/api/v1/relay/start", body); rr.Code != http.StatusCreated {
+		t.Fatalf("expected the real start to create the session, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if starts != 1 || provisions != 1 {
+		t.Fatalf("expected one start and one provision, got %d and %d", starts, provisions)
+	}
+
+	// The body flag works too, and now sees the session the key started.
+	resp = decodeDryRun(t, postStart(t, router, "/api/v1/relay/start", map[string]any{"region_preference": "us-east-1", "dry_run": true}))
+	if resp.Outcome != dryRunReplay || resp.SessionID != "ses_1" || resp.Error != nil {
+		t.Fatalf("expected the started session replayed, got %+v", resp)
+	}
+	if rr := postStart(t, router, "/api/v1/relay/start", body); rr.Code != http.StatusOK || starts != 2 || provisions != 1 {
+		t.Fatalf("expected the retry to replay the session, got %d starts=%d provisions=%d", rr.Code, starts, provisions)
+	}
+}
+
+func TestRelayStart_DryRunReportsBlockingError(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		region   string
+		usage    *model.UsageCurrent
+		preview  error
+		wantCode string
+		want     int
+	}{
+		{"unsupported region", "ap-south-1", nil, nil, "unsupported_region", http.StatusBadRequest},
+		{"usage exhausted", "us-east-1", &model.UsageCurrent{PlanTier: "free", IncludedSeconds: 3600}, nil, "usage_exhausted", http.StatusForbidden},
+		{"previous session stopping", "us-east-1", nil, store.ErrSessionStopping, "session_stopping", http.StatusConflict},
+		{"region off in the manifest", "eu-west-1", nil, nil, "region_unavailable", http.StatusServiceUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := &mockStore{
+				previewStartFn: func(context.Context, store.StartInput) (store.StartPreview, error) {
+					return store.StartPreview{}, tc.preview
+				},
+				startOrGetSessionFn: func(context.Context, store.StartInput) (*model.Session, bool, error) {
+					t.Fatal("dry run reached StartOrGetSession")
+					return nil, false, nil
+				},
+				previewUsageCurrentFn: func(context.Context, string, int) (*model.UsageCurrent, error) {
+					if tc.usage == nil {
+						return nil, store.ErrNotFound
+					}
+					return tc.usage, nil
+				},
+				listRelayManifestFn: dryRunManifest,
+			}
+			router := NewRouter(testConfig(), ms, &mockProvisioner{})
+			resp := decodeDryRun(t, postStart(t, router, "/api/v1/relay/start?dry_run=1", map[string]any{"region_preference": tc.region}))
+			if resp.Error == nil || resp.Error.Code != tc.wantCode || resp.Error.Status != tc.want || resp.Outcome != "" {
+				t.Fatalf("expected %s (%d), got %+v", tc.wantCode, tc.want, resp)
+			}
+		})
+	}
+}
+
+func TestRelayStart_RejectsInvalidDryRun(t *testing.T) {
+	router := NewRouter(testConfig(), &mockStore{}, &mockProvisioner{})
+	if rr := postStart(t, router, "/api/v1/relay/start?dry_run=maybe", map[string]any{}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d body=%s", rr.Code, rr.Body.String())
+	}
+}
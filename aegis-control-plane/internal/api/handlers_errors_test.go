@@ -0,0 +1,235 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+// TestErrorCallSitesUseCataloguedCodes keeps handlers from writing codes
+// that GET /api/v1/errors does not list.
+func TestErrorCallSitesUseCataloguedCodes(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi fs.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	calls := 0
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			ast.Inspect(f, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok || len(call.Args) < 2 {
+					return true
+				}
+				switch fn := call.Fun.(type) {
+				case *ast.Ident:
+					if fn.Name != "writeAPIError" {
+						return true
+					}
+				case *ast.SelectorExpr:
+					if fn.Sel.Name != "writeUnavailable" {
+						return true
+					}
+				default:
+					return true
+				}
+				calls++
+				if sel, ok := call.Args[1].(*ast.SelectorExpr); ok {
+					if pkg, ok := sel.X.(*ast.Ident); ok && pkg.Name == "apierr" {
+						return true
+					}
+				}
+				t.Errorf("%s: error code must be an apierr constant", fset.Position(call.Pos()))
+				return true
+			})
+		}
+	}
+	if calls == 0 {
+		t.Fatal("found no error call sites")
+	}
+}
+
+func TestErrorCatalogue_ListsEveryCode(t *testing.T) {
+	router := NewRouter(testConfig(), &mockStore{}, &mockProvisioner{})
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/errors", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Errors []apierr.Entry `json:"errors"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !reflect.DeepEqual(body.Errors, apierr.Catalogue()) {
+		t.Fatalf("expected the catalogue, got %s", rr.Body.String())
+	}
+}
+
+func assertAPIError(t *testing.T, rr *httptest.ResponseRecorder, code apierr.Code) {
+	t.Helper()
+	if rr.Code != code.Status() {
+		t.Fatalf("expected %d, got %d body=%s", code.Status(), rr.Code, rr.Body.String())
+	}
+	var body apiError
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Error.Code != string(code) {
+		t.Fatalf("expected %s, got %s", code, rr.Body.String())
+	}
+}
+
+func relayStartRequestFor(t *testing.T, region string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/start", jsonBody(map[string]any{"region_preference": region}))
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	req.Header.Set("Idempotency-Key", "3c9d2e1f-6a4b-4c8d-9e7f-0a1b2c3d4e5f")
+	return req
+}
+
+func TestRelayStart_UnsupportedRegion(t *testing.T) {
+	ms := &mockStore{
+		startOrGetSessionFn: func(context.Context, store.StartInput) (*model.Session, bool, error) {
+			t.Fatal("no session should be started")
+			return nil, false, nil
+		},
+	}
+	rr := httptest.NewRecorder()
+	NewRouter(testConfig(), ms, &mockProvisioner{}).ServeHTTP(rr, relayStartRequestFor(t, "ap-southeast-2"))
+	assertAPIError(t, rr, apierr.UnsupportedRegion)
+}
+
+func TestRelayStart_ExistingProvisioningSession(t *testing.T) {
+	ms := &mockStore{
+		startOrGetSessionFn: func(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+			return &model.Session{ID: "ses_1", UserID: in.UserID, Status: model.SessionProvisioning, Region: in.Region}, false, nil
+		},
+	}
+	rr := httptest.NewRecorder()
+	NewRouter(testConfig(), ms, &mockProvisioner{}).ServeHTTP(rr, relayStartRequestFor(t, "us-east-1"))
+	assertAPIError(t, rr, apierr.ProvisioningInProgress)
+	var body apiError
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Error.SessionID != "ses_1" || body.Error.RetryAfterSeconds != 5 || rr.Header().Get("Retry-After") != "5" {
+		t.Fatalf("expected the session and a retry hint, got Retry-After=%q body=%s", rr.Header().Get("Retry-After"), rr.Body.String())
+	}
+}
+
+func TestRelayStart_ReplayedProvisioningSession(t *testing.T) {
+	// The replay carries the session as first recorded; the store has moved on.
+	current := &model.Session{ID: "ses_1", UserID: "usr_1", Status: model.SessionProvisioning, Region: "us-east-1"}
+	ms := &mockStore{
+		startOrGetSessionFn: func(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+			return &model.Session{ID: "ses_1", UserID: in.UserID, Status: model.SessionProvisioning, Region: in.Region, Replayed: true}, false, nil
+		},
+		getSessionByIDFn: func(_ context.Context, userID, sessionID string) (*model.Session, error) {
+			if userID != "usr_1" || sessionID != "ses_1" {
+				t.Fatalf("unexpected lookup of %s for %s", sessionID, userID)
+			}
+			return current, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, relayStartRequestFor(t, "us-east-1"))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 while the replayed session provisions, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Session map[string]any `json:"session"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Session["session_id"] != "ses_1" || resp.Session["status"] != string(model.SessionProvisioning) {
+		t.Fatalf("expected the provisioning session, got %s", rr.Body.String())
+	}
+
+	current = &model.Session{ID: "ses_1", UserID: "usr_1", Status: model.SessionActive, Region: "us-east-1", PublicIP: "203.0.113.10", SRTPort: 9000}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, relayStartRequestFor(t, "us-east-1"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 once the replayed session is active, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Session["status"] != string(model.SessionActive) {
+		t.Fatalf("expected the session as it is now, got %s", rr.Body.String())
+	}
+}
+
+func TestRelayStart_FreeTierUsageExhausted(t *testing.T) {
+	usage := &model.UsageCurrent{PlanTier: "free", IncludedSeconds: 3600, ConsumedSeconds: 3600}
+	starts := 0
+	ms := &mockStore{
+		getUsageCurrentFn: func(context.Context, string, int) (*model.UsageCurrent, error) {
+			return usage, nil
+		},
+		startOrGetSessionFn: func(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+			starts++
+			return &model.Session{ID: "ses_1", UserID: in.UserID, Status: model.SessionActive, Region: in.Region}, false, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, relayStartRequestFor(t, "us-east-1"))
+	assertAPIError(t, rr, apierr.UsageExhausted)
+	if starts != 0 {
+		t.Fatalf("expected no session started, got %d", starts)
+	}
+
+	// Paid tiers are billed for overage rather than refused.
+	usage = &model.UsageCurrent{PlanTier: "starter", IncludedSeconds: 3600, ConsumedSeconds: 7200}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, relayStartRequestFor(t, "us-east-1"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 for a paid tier, got %d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestSessionCredentials_StoppedSessionNotActive(t *testing.T) {
+	ms := &mockStore{
+		getSessionByIDFn: func(_ context.Context, userID, sessionID string) (*model.Session, error) {
+			return &model.Session{ID: sessionID, UserID: userID, Status: model.SessionStopped}, nil
+		},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/sessions/ses_1/credentials", nil)
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	rr := httptest.NewRecorder()
+	NewRouter(testConfig(), ms, &mockProvisioner{}).ServeHTTP(rr, req)
+	assertAPIError(t, rr, apierr.SessionNotActive)
+}
+
+func TestStoreTimeout_Returns504(t *testing.T) {
+	ms := &mockStore{
+		getActiveSessionFn: func(context.Context, string) (*model.Session, error) {
+			return nil, fmt.Errorf("%w: %w", store.ErrStoreTimeout, context.DeadlineExceeded)
+		},
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/relay/active", nil)
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	rr := httptest.NewRecorder()
+	NewRouter(testConfig(), ms, &mockProvisioner{}).ServeHTTP(rr, req)
+	assertAPIError(t, rr, apierr.StoreTimeout)
+}
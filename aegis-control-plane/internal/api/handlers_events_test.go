@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+func TestRelayEvents_StreamsEventsAfterLastEventID(t *testing.T) {
+	prev := sessionEventPollInterval
+	sessionEventPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { sessionEventPollInterval = prev })
+
+	var gotUser string
+	var gotAfter int64
+	ms := &mockStore{
+		latestSessionEventID: 99,
+		listSessionEventsFn: func(_ context.Context, userID string, afterID int64, _ int) ([]model.SessionEvent, error) {
+			gotUser, gotAfter = userID, afterID
+			if afterID >= 6 {
+				return nil, nil
+			}
+			return []model.SessionEvent{{
+				ID:        6,
+				SessionID: "ses_1",
+				UserID:    userID,
+				Type:      "relay_replaced",
+				Payload:   json.RawMessage(`{"session_id":"ses_1","public_ip":"198.51.100.9"}`),
+			}}, nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := httptest.NewServer(NewRouter(testConfig(), ms, &mockProvisioner{}, WithStreamContext(ctx)))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/relay/events", nil)
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	req.Header.Set("Last-Event-ID", "5")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET events: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	var lines []string
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		if sc.Text() == "" {
+			break
+		}
+		lines = append(lines, sc.Text())
+	}
+	want := "id: 6\nevent: relay_replaced\ndata: {\"session_id\":\"ses_1\",\"public_ip\":\"198.51.100.9\"}"
+	if got := strings.Join(lines, "\n"); got != want {
+		t.Fatalf("unexpected event:\n%s", got)
+	}
+	if gotUser != "usr_1" || gotAfter != 5 {
+		t.Fatalf("unexpected query user=%s after=%d", gotUser, gotAfter)
+	}
+}
+
+func TestRelayEvents_RejectsInvalidLastEventID(t *testing.T) {
+	router := NewRouter(testConfig(), &mockStore{}, &mockProvisioner{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/relay/events", nil)
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	req.Header.Set("Last-Event-ID", "abc")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
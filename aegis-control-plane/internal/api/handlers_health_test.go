@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+)
+
+func TestHealthz_ReportsBuildInfo(t *testing.T) {
+	router := NewRouter(testConfig(), &mockStore{}, &mockProvisioner{})
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := map[string]any{"status": "ok", "version": "dev", "commit": "dev", "build_date": "dev", "draining": false}
+	for k, v := range want {
+		if body[k] != v {
+			t.Fatalf("expected %s=%s, got %v", k, v, body)
+		}
+	}
+}
+
+func TestMetrics_ExposesBuildInfo(t *testing.T) {
+	router := NewRouter(testConfig(), &mockStore{}, &mockProvisioner{})
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `aegis_build_info{commit="dev",version="dev"} 1`) {
+		t.Fatalf("missing build info: %s", rr.Body.String())
+	}
+}
+
+func TestDrain_RefusesStartsAndFailsReadiness(t *testing.T) {
+	drain := &Drain{}
+	router := NewRouter(testConfig(), &mockStore{}, &mockProvisioner{}, WithDrain(drain))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected ready before draining, got %d", rr.Code)
+	}
+
+	drain.Start()
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), `"draining"`) {
+		t.Fatalf("expected 503 draining, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"draining":true`) {
+		t.Fatalf("expected healthz to stay up and report draining, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/start", jsonBody(map[string]any{"region_preference": "us-east-1"}))
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	req.Header.Set("Idempotency-Key", "7d3f0c2e-1a4b-4c5d-8e9f-0a1b2c3d4e5f")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assertAPIError(t, rr, apierr.ServerDraining)
+	if rr.Header().Get("Retry-After") != "5" {
+		t.Fatalf("expected Retry-After 5, got %q", rr.Header().Get("Retry-After"))
+	}
+}
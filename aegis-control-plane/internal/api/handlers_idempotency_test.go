@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+func startWithKey(t *testing.T, ms *mockStore, key string) *httptest.ResponseRecorder {
+	t.Helper()
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/start", jsonBody(map[string]any{"region_preference": "us-east-1"}))
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	req.Header.Set("Idempotency-Key", key)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRelayStart_RejectsNonV4IdempotencyKeys(t *testing.T) {
+	for _, key := range []string{
+		"6ba7b810-9dad-11d1-80b4-00c04fd430c8",          // version 1
+		"{0b8c7f0e-5d0a-4f43-9b7e-2f4c9f1f6a11}",        // braces
+		"urn:uuid:0b8c7f0e-5d0a-4f43-9b7e-2f4c9f1f6a11", // URN
+		"0b8c7f0e5d0a4f439b7e2f4c9f1f6a11",              // no hyphens
+		"0b8c7f0e-5d0a-4f43-cb7e-2f4c9f1f6a11",          // non-RFC 4122 variant
+	} {
+		ms := &mockStore{
+			startOrGetSessionFn: func(context.Context, store.StartInput) (*model.Session, bool, error) {
+				t.Fatalf("key %q reached the store", key)
+				return nil, false, nil
+			},
+		}
+		if rr := startWithKey(t, ms, key); rr.Code != http.StatusBadRequest {
+			t.Fatalf("key %q: expected 400, got %d body=%s", key, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestRelayStart_UppercaseIdempotencyKeyMatchesLowercase(t *testing.T) {
+	var keys []uuid.UUID
+	ms := &mockStore{
+		startOrGetSessionFn: func(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+			keys = append(keys, in.IdempotencyKey)
+			return &model.Session{ID: "ses_1", UserID: in.UserID, Status: model.SessionActive, Region: in.Region}, false, nil
+		},
+	}
+	for _, key := range []string{"0b8c7f0e-5d0a-4f43-9b7e-2f4c9f1f6a11", "0B8C7F0E-5D0A-4F43-9B7E-2F4C9F1F6A11"} {
+		if rr := startWithKey(t, ms, key); rr.Code != http.StatusOK {
+			t.Fatalf("key %q: expected 200, got %d body=%s", key, rr.Code, rr.Body.String())
+		}
+	}
+	if len(keys) != 2 || keys[0] != keys[1] || keys[1].String() != "0b8c7f0e-5d0a-4f43-9b7e-2f4c9f1f6a11" {
+		t.Fatalf("expected both casings to reach the store as one lowercase key, got %v", keys)
+	}
+}
+
+func TestRelayStart_HashIgnoresFieldsThatDoNotAffectTheStart(t *testing.T) {
+	var hashes []string
+	ms := &mockStore{
+		startOrGetSessionFn: func(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+			hashes = append(hashes, in.RequestHash)
+			return &model.Session{ID: "ses_1", UserID: in.UserID, Status: model.SessionActive, Region: in.Region}, false, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+	for _, body := range []map[string]any{
+		{"region_preference": "us-east-1", "client_context": map[string]any{"mode": "studio", "obs_connected": true}},
+		{"client_context": map[string]any{"obs_connected": false}, "region_preference": "us-east-1", "added_later": "x"},
+		{"region_preference": "us-east-1", "static_ip": true},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/start", jsonBody(body))
+		req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+		req.Header.Set("Idempotency-Key", "0b8c7f0e-5d0a-4f43-9b7e-2f4c9f1f6a11")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+		}
+	}
+	if len(hashes) != 3 || hashes[0] != hashes[1] {
+		t.Fatalf("expected the first two requests to hash alike, got %v", hashes)
+	}
+	if hashes[2] == hashes[0] {
+		t.Fatal("expected static_ip to change the hash")
+	}
+}
+
+func TestRelayStart_HashesNormalizedTags(t *testing.T) {
+	var starts []store.StartInput
+	ms := &mockStore{
+		startOrGetSessionFn: func(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+			starts = append(starts, in)
+			return &model.Session{ID: "ses_1", UserID: in.UserID, Status: model.SessionActive, Region: in.Region, Tags: in.Tags}, false, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+	for _, body := range []map[string]any{
+		{"region_preference": "us-east-1", "client_context": map[string]any{"tags": []string{"Friday-Show", "client-x"}}},
+		{"region_preference": "us-east-1", "client_context": map[string]any{"tags": []string{"client-x", " friday-show ", "CLIENT-X"}}},
+		{"region_preference": "us-east-1", "client_context": map[string]any{"tags": []string{}}},
+		{"region_preference": "us-east-1"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/start", jsonBody(body))
+		req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+		req.Header.Set("Idempotency-Key", "0b8c7f0e-5d0a-4f43-9b7e-2f4c9f1f6a11")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+		}
+	}
+	if len(starts) != 4 {
+		t.Fatalf("expected 4 starts, got %d", len(starts))
+	}
+	if want := []string{"client-x", "friday-show"}; !slices.Equal(starts[0].Tags, want) || !slices.Equal(starts[1].Tags, want) {
+		t.Fatalf("expected normalized tags %v, got %v and %v", want, starts[0].Tags, starts[1].Tags)
+	}
+	if starts[0].RequestHash != starts[1].RequestHash {
+		t.Fatal("expected the same tags in another order and case to hash alike")
+	}
+	if starts[2].RequestHash != starts[3].RequestHash {
+		t.Fatal("expected no tags to hash like a request without the field")
+	}
+	if starts[0].RequestHash == starts[3].RequestHash {
+		t.Fatal("expected tags to change the hash")
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+)
+
+func ping(h http.Handler, remoteAddr, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/relay/ping", nil)
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("X-Relay-Auth", key)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRelayPing_ReturnsServerTimeAndAgentSettings(t *testing.T) {
+	cfg := testConfig()
+	cfg.RelayMinAgentVersion = "1.4.0"
+	before := time.Now().Add(-time.Second)
+	rr := ping(NewRouter(cfg, &mockStore{}, &mockProvisioner{}), "198.51.100.7:4000", "relay-key")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		ServerTime               string  `json:"server_time"`
+		MinAgentVersion          *string `json:"min_agent_version"`
+		HeartbeatIntervalSeconds int     `json:"heartbeat_interval_seconds"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	at, err := time.Parse(serverTimeFormat, body.ServerTime)
+	if err != nil || at.Before(before) || at.After(time.Now()) {
+		t.Fatalf("expected the current time with milliseconds, got %q (%v)", body.ServerTime, err)
+	}
+	if body.MinAgentVersion == nil || *body.MinAgentVersion != "1.4.0" || body.HeartbeatIntervalSeconds != 30 {
+		t.Fatalf("unexpected agent settings: %+v", body)
+	}
+
+	rr = ping(NewRouter(cfg, &mockStore{}, &mockProvisioner{}), "198.51.100.7:4000", "wrong")
+	assertAPIError(t, rr, apierr.Unauthorized)
+}
+
+func TestRelayPing_RateLimitedPerIP(t *testing.T) {
+	cfg := testConfig()
+	cfg.RelayPingRateLimit = 2
+	h := NewRouter(cfg, &mockStore{}, &mockProvisioner{})
+
+	// Failed auth counts against the limit too.
+	for _, key := range []string{"relay-key", "wrong"} {
+		ping(h, "198.51.100.7:4000", key)
+	}
+	rr := ping(h, "198.51.100.7:4001", "relay-key")
+	assertAPIError(t, rr, apierr.RateLimited)
+	if rr.Header().Get("Retry-After") == "" {
+		t.Fatal("expected Retry-After on a limited ping")
+	}
+	if rr := ping(h, "203.0.113.9:4000", "relay-key"); rr.Code != http.StatusOK {
+		t.Fatalf("expected another IP to be admitted, got %d", rr.Code)
+	}
+}
+
+func TestIPRateLimiter_ResetsEachWindow(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	l := newIPRateLimiter(time.Minute)
+	l.now = func() time.Time { return now }
+
+	if ok, _ := l.allow("198.51.100.7", 1); !ok {
+		t.Fatal("expected the first request admitted")
+	}
+	now = now.Add(20 * time.Second)
+	if ok, retryAfter := l.allow("198.51.100.7", 1); ok || retryAfter != 40*time.Second {
+		t.Fatalf("expected a refusal until the window ends, got ok=%t retry_after=%s", ok, retryAfter)
+	}
+	now = now.Add(40 * time.Second)
+	if ok, _ := l.allow("198.51.100.7", 1); !ok {
+		t.Fatal("expected a new window to admit the request")
+	}
+}
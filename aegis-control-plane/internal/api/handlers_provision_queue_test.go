@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+func TestRelayStart_ProvisionQueueFullReturns503(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	var mu sync.Mutex
+	var stopped []string
+	ms := &mockStore{
+		startOrGetSessionFn: func(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+			return &model.Session{ID: "ses_" + in.UserID, UserID: in.UserID, Status: model.SessionProvisioning, Region: in.Region}, true, nil
+		},
+		activateSessionFn: func(_ context.Context, in store.ActivateProvisionedSessionInput) (*model.Session, error) {
+			return &model.Session{ID: in.SessionID, UserID: in.UserID, Status: model.SessionActive, Region: in.Region}, nil
+		},
+		stopSessionFn: func(_ context.Context, _ string, sessionID, reason string) (*model.Session, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			stopped = append(stopped, sessionID+":"+reason)
+			return &model.Session{ID: sessionID, Status: model.SessionStopped}, nil
+		},
+	}
+	entered := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	mp := &mockProvisioner{
+		provisionFn: func(_ context.Context, req relay.ProvisionRequest) (relay.ProvisionResult, error) {
+			if req.UserID == "usr_slow" {
+				entered <- struct{}{}
+				<-unblock
+			}
+			return relay.ProvisionResult{AWSInstanceID: "i-" + req.UserID, PublicIP: "203.0.113.10", SRTPort: 9000}, nil
+		},
+	}
+	cfg := testConfig()
+	cfg.ProvisionConcurrency = 1
+	cfg.ProvisionQueueTimeout = 50 * time.Millisecond
+	router := NewRouter(cfg, ms, mp)
+	start := func(userID, idem string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/start", jsonBody(map[string]any{"region_preference": "us-east-1"}))
+		req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", userID))
+		req.Header.Set("Idempotency-Key", idem)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	slow := make(chan *httptest.ResponseRecorder)
+	go func() { slow <- start("usr_slow", "5f3c2b1a-8d7e-4c6b-9a5f-1e2d3c4b5a69") }()
+	<-entered
+
+	rr := start("usr_queued", "7a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d")
+	assertUnavailable(t, rr, "provision_queue_full", 30)
+	mu.Lock()
+	if len(stopped) != 1 || stopped[0] != "ses_usr_queued:"+model.StopReasonStartFailed {
+		t.Fatalf("expected the queued session to be stopped, got %v", stopped)
+	}
+	mu.Unlock()
+
+	close(unblock)
+	if rr := <-slow; rr.Code != http.StatusCreated {
+		t.Fatalf("expected the first start to finish, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	// The slot is free again once the first Provision returned.
+	if rr := start("usr_next", "2e4d6c8b-0a1f-4e3d-9c5b-7a6f8e9d0c1b"); rr.Code != http.StatusCreated {
+		t.Fatalf("expected a start after the slot was released, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	out := metrics.Default().Render()
+	for _, want := range []string{
+		`aegis_relay_provision_queue_wait_ms_count{region="us-east-1",status="timeout"} 1`,
+		`aegis_relay_provision_queue_depth{region="us-east-1"} 0`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %s, got:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+// switchedOffManifest lists the test config's regions with us-east-1, its
+// default, switched off.
+func switchedOffManifest(context.Context) ([]model.RelayManifestEntry, error) {
+	return []model.RelayManifestEntry{
+		{Region: "us-east-1", AMIID: "ami-1", DefaultInstanceType: "t4g.small", Available: true, UpdatedAt: time.Now()},
+		{Region: "eu-west-1", AMIID: "ami-2", DefaultInstanceType: "t4g.small", Available: true, Enabled: true, UpdatedAt: time.Now()},
+	}, nil
+}
+
+func TestRelayStart_DisabledRegionIsUnsupported(t *testing.T) {
+	ms := &mockStore{
+		listRelayManifestFn: switchedOffManifest,
+		startOrGetSessionFn: func(context.Context, store.StartInput) (*model.Session, bool, error) {
+			t.Fatal("no session should be started")
+			return nil, false, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, relayStartRequestFor(t, "us-east-1"))
+	assertAPIError(t, rr, apierr.UnsupportedRegion)
+
+	req := relayStartRequestFor(t, "us-east-1")
+	req.URL.RawQuery = "dry_run=true"
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var preview dryRunResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &preview); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if rr.Code != http.StatusOK || preview.Error == nil || preview.Error.Code != string(apierr.UnsupportedRegion) {
+		t.Fatalf("expected the dry run to report the disabled region, got %d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestRelayStart_DisabledRegionRefusedWhenSessionsUnreadable(t *testing.T) {
+	ms := &mockStore{
+		listRelayManifestFn: switchedOffManifest,
+		previewStartFn: func(context.Context, store.StartInput) (store.StartPreview, error) {
+			return store.StartPreview{}, errors.New("connection reset")
+		},
+		startOrGetSessionFn: func(context.Context, store.StartInput) (*model.Session, bool, error) {
+			t.Fatal("no session should be started")
+			return nil, false, nil
+		},
+	}
+	rr := httptest.NewRecorder()
+	NewRouter(testConfig(), ms, &mockProvisioner{}).ServeHTTP(rr, relayStartRequestFor(t, "us-east-1"))
+	assertAPIError(t, rr, apierr.InternalError)
+}
+
+func TestRelayStart_AutoFallsBackFromDisabledRegion(t *testing.T) {
+	var got store.StartInput
+	ms := &mockStore{
+		listRelayManifestFn: switchedOffManifest,
+		startOrGetSessionFn: func(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+			got = in
+			return &model.Session{ID: "ses_1", UserID: in.UserID, Status: model.SessionActive, Region: in.Region}, false, nil
+		},
+	}
+	rr := httptest.NewRecorder()
+	NewRouter(testConfig(), ms, &mockProvisioner{}).ServeHTTP(rr, relayStartRequestFor(t, "auto"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got.Region != "eu-west-1" {
+		t.Fatalf("expected the start to fall back to eu-west-1, got %q", got.Region)
+	}
+}
+
+func TestRelayStart_AllRegionsDisabled(t *testing.T) {
+	ms := &mockStore{
+		listRelayManifestFn: func(context.Context) ([]model.RelayManifestEntry, error) {
+			return []model.RelayManifestEntry{{Region: "us-east-1"}, {Region: "eu-west-1"}}, nil
+		},
+	}
+	rr := httptest.NewRecorder()
+	NewRouter(testConfig(), ms, &mockProvisioner{}).ServeHTTP(rr, relayStartRequestFor(t, ""))
+	assertAPIError(t, rr, apierr.RegionUnavailable)
+	if rr.Header().Get("Retry-After") == "" {
+		t.Fatal("expected a Retry-After")
+	}
+}
+
+func TestRelayStart_DisabledRegionKeepsExistingSession(t *testing.T) {
+	existing := &model.Session{ID: "ses_1", UserID: "usr_1", Status: model.SessionActive, Region: "us-east-1"}
+	ms := &mockStore{
+		listRelayManifestFn: switchedOffManifest,
+		previewStartFn: func(context.Context, store.StartInput) (store.StartPreview, error) {
+			return store.StartPreview{Existing: existing}, nil
+		},
+		startOrGetSessionFn: func(context.Context, store.StartInput) (*model.Session, bool, error) {
+			return existing, false, nil
+		},
+	}
+	rr := httptest.NewRecorder()
+	NewRouter(testConfig(), ms, &mockProvisioner{}).ServeHTTP(rr, relayStartRequestFor(t, "us-east-1"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the live session, got %d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestRelayManifest_DisabledRegionIsUnavailable(t *testing.T) {
+	ms := &mockStore{listRelayManifestFn: switchedOffManifest}
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/relay/manifest", nil)
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	rr := httptest.NewRecorder()
+	NewRouter(testConfig(), ms, &mockProvisioner{}).ServeHTTP(rr, req)
+
+	var body struct {
+		Regions []manifestRegion `json:"regions"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Regions) != 2 {
+		t.Fatalf("expected the disabled region to stay listed, got %s", rr.Body.String())
+	}
+	for _, r := range body.Regions {
+		disabled := r.Region == "us-east-1"
+		if r.Available == disabled || r.Enabled == disabled {
+			t.Fatalf("unexpected %s entry %+v", r.Region, r)
+		}
+	}
+}
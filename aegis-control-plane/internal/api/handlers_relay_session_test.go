@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+func relaySessionStore(status model.SessionStatus) *mockStore {
+	return &mockStore{
+		getRelaySessionFn: func(_ context.Context, sessionID, instanceID string) (*model.Session, error) {
+			if sessionID != "ses_1" || instanceID != "i-current" {
+				return nil, store.ErrNotFound
+			}
+			return &model.Session{
+				ID:                 sessionID,
+				UserID:             "usr_1",
+				RelayAWSInstanceID: instanceID,
+				Status:             status,
+				Region:             "us-east-1",
+				SRTPort:            9000,
+				SRTPorts:           []int{9000, 9001},
+				PairToken:          "PAIR1234",
+				StartedAt:          time.Now().Add(-time.Hour),
+				GraceWindowSeconds: 600,
+				MaxSessionSeconds:  4 * 3600,
+			}, nil
+		},
+	}
+}
+
+func getRelaySession(t *testing.T, ms *mockStore, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/relay/session?"+query, nil)
+	req.Header.Set("X-Relay-Auth", "relay-key")
+	rr := httptest.NewRecorder()
+	NewRouter(testConfig(), ms, &mockProvisioner{}).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRelaySession_ReturnsSettingsWithoutPairToken(t *testing.T) {
+	rr := getRelaySession(t, relaySessionStore(model.SessionActive), "session_id=ses_1&instance_id=i-current")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if strings.Contains(rr.Body.String(), "PAIR1234") || strings.Contains(rr.Body.String(), "pair_token") {
+		t.Fatalf("pair token leaked: %s", rr.Body.String())
+	}
+	var body struct {
+		SRTPort            int   `json:"srt_port"`
+		SRTPorts           []int `json:"srt_ports"`
+		GraceWindowSeconds int   `json:"grace_window_seconds"`
+		RemainingSeconds   int   `json:"remaining_seconds"`
+		Stopped            bool  `json:"stopped"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.SRTPort != 9000 || len(body.SRTPorts) != 2 || body.SRTPorts[1] != 9001 || body.GraceWindowSeconds != 600 || body.Stopped {
+		t.Fatalf("unexpected body: %s", rr.Body.String())
+	}
+	if body.RemainingSeconds < 3*3600-5 || body.RemainingSeconds > 3*3600 {
+		t.Fatalf("expected about 3h remaining, got %d", body.RemainingSeconds)
+	}
+}
+
+func TestRelaySession_InstanceMismatchReturns404(t *testing.T) {
+	rr := getRelaySession(t, relaySessionStore(model.SessionActive), "session_id=ses_1&instance_id=i-replaced")
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestRelaySession_StoppedSession(t *testing.T) {
+	rr := getRelaySession(t, relaySessionStore(model.SessionStopped), "session_id=ses_1&instance_id=i-current")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Status           string `json:"status"`
+		RemainingSeconds int    `json:"remaining_seconds"`
+		Stopped          bool   `json:"stopped"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Status != "stopped" || !body.Stopped || body.RemainingSeconds != 0 {
+		t.Fatalf("unexpected body: %s", rr.Body.String())
+	}
+}
+
+func TestRelaySession_RequiresRelayAuthAndParams(t *testing.T) {
+	if rr := getRelaySession(t, relaySessionStore(model.SessionActive), "session_id=ses_1"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without instance_id, got %d", rr.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/relay/session?session_id=ses_1&instance_id=i-current", nil)
+	rr := httptest.NewRecorder()
+	NewRouter(testConfig(), relaySessionStore(model.SessionActive), &mockProvisioner{}).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without X-Relay-Auth, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+func TestListSessions_FiltersByStatusAndMasks(t *testing.T) {
+	ms := &mockStore{
+		listLiveSessionsFn: func(_ context.Context, userID string) ([]model.Session, error) {
+			return []model.Session{
+				{ID: "ses_3", UserID: userID, Status: model.SessionProvisioning, Region: "us-east-1"},
+				{ID: "ses_2", UserID: userID, Status: model.SessionActive, Region: "us-east-1", PairToken: "PAIR1234", RelayWSToken: "ws_token", Tags: []string{"client-x", "friday-show"}},
+				{ID: "ses_1", UserID: userID, Status: model.SessionActive, Region: "eu-west-1", PairToken: "PAIR5678", RelayWSToken: "ws_token"},
+			}, nil
+		},
+	}
+	cfg := testConfig()
+	cfg.MaskSessionCredentials = true
+	router := NewRouter(cfg, ms, &mockProvisioner{})
+	list := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/sessions"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := list("?status=active")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Sessions []struct {
+			SessionID   string `json:"session_id"`
+			Credentials struct {
+				PairToken string `json:"pair_token"`
+			} `json:"credentials"`
+		} `json:"sessions"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Sessions) != 2 || body.Sessions[0].SessionID != "ses_2" || body.Sessions[1].SessionID != "ses_1" {
+		t.Fatalf("expected the two active sessions newest first, got %s", rr.Body.String())
+	}
+	if body.Sessions[0].Credentials.PairToken != "******34" {
+		t.Fatalf("expected masked credentials, got %s", rr.Body.String())
+	}
+
+	if rr := list("?status=stopped"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a stopped filter, got %d", rr.Code)
+	}
+
+	rr = list("?tag=Friday-Show")
+	var tagged struct {
+		Sessions []struct {
+			SessionID string   `json:"session_id"`
+			Tags      []string `json:"tags"`
+		} `json:"sessions"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &tagged); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(tagged.Sessions) != 1 || tagged.Sessions[0].SessionID != "ses_2" || len(tagged.Sessions[0].Tags) != 2 {
+		t.Fatalf("expected only the session tagged friday-show, got %s", rr.Body.String())
+	}
+	if rr := list("?tag=no%20spaces"); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "no spaces") {
+		t.Fatalf("expected 400 naming the tag, got %d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestRelayStart_RejectsInvalidTags(t *testing.T) {
+	for _, tags := range [][]string{
+		{"friday-show", "has space"},
+		{strings.Repeat("x", model.MaxSessionTagLength+1)},
+		{"a", "b", "c", "d", "e", "f"},
+		{""},
+	} {
+		ms := &mockStore{
+			startOrGetSessionFn: func(context.Context, store.StartInput) (*model.Session, bool, error) {
+				t.Fatalf("tags %q reached the store", tags)
+				return nil, false, nil
+			},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/start", jsonBody(map[string]any{
+			"region_preference": "us-east-1",
+			"client_context":    map[string]any{"tags": tags},
+		}))
+		req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+		req.Header.Set("Idempotency-Key", "0b8c7f0e-5d0a-4f43-9b7e-2f4c9f1f6a11")
+		rr := httptest.NewRecorder()
+		NewRouter(testConfig(), ms, &mockProvisioner{}).ServeHTTP(rr, req)
+		assertAPIError(t, rr, apierr.InvalidField)
+		if !strings.Contains(rr.Body.String(), "tags") {
+			t.Fatalf("expected the error to name the field, got %s", rr.Body.String())
+		}
+	}
+}
+
+func TestRelayStart_SessionLimitReached(t *testing.T) {
+	ms := &mockStore{
+		startOrGetSessionFn: func(context.Context, store.StartInput) (*model.Session, bool, error) {
+			return nil, false, store.ErrSessionLimit
+		},
+	}
+	rr := httptest.NewRecorder()
+	NewRouter(testConfig(), ms, &mockProvisioner{}).ServeHTTP(rr, relayStartRequestFor(t, "us-east-1"))
+	assertAPIError(t, rr, apierr.SessionLimitReached)
+}
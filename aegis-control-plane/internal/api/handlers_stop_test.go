@@ -5,13 +5,18 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
 	"github.com/telemyapp/aegis-control-plane/internal/config"
 	"github.com/telemyapp/aegis-control-plane/internal/metrics"
 	"github.com/telemyapp/aegis-control-plane/internal/model"
@@ -21,15 +26,276 @@ import (
 
 type mockStore struct {
 	getSessionByIDFn         func(context.Context, string, string) (*model.Session, error)
-	stopSessionFn            func(context.Context, string, string) (*model.Session, error)
+	stopSessionFn            func(context.Context, string, string, string) (*model.Session, error)
+	stopProvisionedFn        func(context.Context, string, string, string, string) (*model.Session, error)
+	stopMu                   sync.Mutex
+	stopInputs               []store.StopSessionInput
 	startOrGetSessionFn      func(context.Context, store.StartInput) (*model.Session, bool, error)
+	previewStartFn           func(context.Context, store.StartInput) (store.StartPreview, error)
 	activateSessionFn        func(context.Context, store.ActivateProvisionedSessionInput) (*model.Session, error)
+	advancePhaseFn           func(context.Context, string, string, string) error
 	getActiveSessionFn       func(context.Context, string) (*model.Session, error)
-	getUsageCurrentFn        func(context.Context, string) (*model.UsageCurrent, error)
+	listLiveSessionsFn       func(context.Context, string) ([]model.Session, error)
+	getUsageCurrentFn        func(context.Context, string, int) (*model.UsageCurrent, error)
+	previewUsageCurrentFn    func(context.Context, string, int) (*model.UsageCurrent, error)
 	recordRelayHealthEventFn func(context.Context, store.RelayHealthInput) error
 	listRelayManifestFn      func(context.Context) ([]model.RelayManifestEntry, error)
+	listSessionsFn           func(context.Context, string, int) ([]model.Session, error)
+	getSessionRelayFn        func(context.Context, string) (*model.SessionRelay, error)
+	markInterruptedFn        func(context.Context, string, string) (*model.Session, error)
+	getRelaySessionFn        func(context.Context, string, string) (*model.Session, error)
+	getSessionTimersFn       func(context.Context, string) (*model.Session, error)
+	attemptsMu               sync.Mutex
+	provisionAttempts        []model.ProvisionAttempt
+	listSessionEventsFn      func(context.Context, string, int64, int) ([]model.SessionEvent, error)
+	listSessionChangesFn     func(context.Context, int64, int) ([]model.SessionChange, error)
+	ackedChanges             map[string]int64
+	latestSessionEventID     int64
+	recordSessionEventFn     func(context.Context, string, string, string, any) error
+	getRelayAccessFn         func(context.Context, string) (*model.RelayAccess, error)
+	updateAllowedClientIPFn  func(context.Context, string, string) error
+
+	webhooks         map[string]model.Webhook
+	listDeliveriesFn func(context.Context, string, int) ([]model.WebhookDelivery, error)
+	replayDeliveryFn func(context.Context, int64) error
+
+	exportUsageFn func(context.Context, time.Time, time.Time) (store.UsageExport, error)
+
+	listBillingExportsFn func(context.Context, store.BillingExportFilter) ([]model.BillingExport, error)
+
+	listRelayInstancesFn func(context.Context, store.RelayInstanceFilter) ([]model.RelayInstance, error)
+	listRelayQualityFn   func(context.Context, store.RelayQualityFilter) ([]model.RelayQualityDay, error)
+
+	listRelayHealthFn func(context.Context, string, int) ([]model.RelayHealthEvent, error)
+	updateManifestFn  func(context.Context, store.RelayManifestUpdate) (*model.RelayManifestEntry, error)
+	jobRuns           []model.JobRun
+	eraseUserDataFn   func(context.Context, string, string) (*model.UserDataErasure, bool, error)
+	recordExportFn    func(context.Context, string, string, time.Duration) (time.Time, error)
+	userSessions      []model.Session
+	userUsage         []model.UsageExportRow
+	userEvents        []model.SessionEvent
+	userEventsErr     error
+
+	countSessionsFn       func(context.Context) (map[model.SessionStatus]map[string]int, error)
+	provisionStatsFn      func(context.Context, time.Time) (model.ProvisionStats, error)
+	countStaleRelaysFn    func(context.Context, time.Duration) (int, error)
+	pendingTerminationsFn func(context.Context) (int, error)
+	instanceSecondsFn     func(context.Context, time.Time) (map[string]int64, error)
+	costOverviewFn        func(context.Context, int) (model.CostOverview, error)
 }
 
+// The mock keeps webhooks in memory, keyed by ID.
+func (m *mockStore) CreateWebhook(_ context.Context, in model.Webhook) (*model.Webhook, error) {
+	if m.webhooks == nil {
+		m.webhooks = make(map[string]model.Webhook)
+	}
+	in.ID = fmt.Sprintf("whk_%d", len(m.webhooks)+1)
+	in.CreatedAt = time.Now()
+	in.UpdatedAt = in.CreatedAt
+	m.webhooks[in.ID] = in
+	return &in, nil
+}
+
+func (m *mockStore) ListWebhooks(_ context.Context, userID string) ([]model.Webhook, error) {
+	var out []model.Webhook
+	for _, wh := range m.webhooks {
+		if wh.UserID == userID {
+			out = append(out, wh)
+		}
+	}
+	return out, nil
+}
+
+func (m *mockStore) GetWebhook(_ context.Context, userID, id string) (*model.Webhook, error) {
+	wh, ok := m.webhooks[id]
+	if !ok || wh.UserID != userID {
+		return nil, store.ErrNotFound
+	}
+	return &wh, nil
+}
+
+func (m *mockStore) UpdateWebhook(ctx context.Context, in model.Webhook) (*model.Webhook, error) {
+	wh, err := m.GetWebhook(ctx, in.UserID, in.ID)
+	if err != nil {
+		return nil, err
+	}
+	wh.URL, wh.Events = in.URL, in.Events
+	if in.Secret != "" {
+		wh.Secret = in.Secret
+	}
+	m.webhooks[wh.ID] = *wh
+	return wh, nil
+}
+
+func (m *mockStore) DeleteWebhook(ctx context.Context, userID, id string) error {
+	if _, err := m.GetWebhook(ctx, userID, id); err != nil {
+		return err
+	}
+	delete(m.webhooks, id)
+	return nil
+}
+
+func (m *mockStore) ListWebhookDeliveries(ctx context.Context, status string, limit int) ([]model.WebhookDelivery, error) {
+	if m.listDeliveriesFn != nil {
+		return m.listDeliveriesFn(ctx, status, limit)
+	}
+	return nil, nil
+}
+
+func (m *mockStore) ReplayWebhookDelivery(ctx context.Context, id int64) error {
+	if m.replayDeliveryFn != nil {
+		return m.replayDeliveryFn(ctx, id)
+	}
+	return store.ErrNotFound
+}
+
+func (m *mockStore) ExportUsage(ctx context.Context, from, to time.Time) (store.UsageExport, error) {
+	if m.exportUsageFn != nil {
+		return m.exportUsageFn(ctx, from, to)
+	}
+	return &sliceUsageExport{}, nil
+}
+
+func (m *mockStore) ListBillingExports(ctx context.Context, f store.BillingExportFilter) ([]model.BillingExport, error) {
+	if m.listBillingExportsFn != nil {
+		return m.listBillingExportsFn(ctx, f)
+	}
+	return nil, nil
+}
+
+func (m *mockStore) ListRelayInstances(ctx context.Context, f store.RelayInstanceFilter) ([]model.RelayInstance, error) {
+	if m.listRelayInstancesFn != nil {
+		return m.listRelayInstancesFn(ctx, f)
+	}
+	return nil, nil
+}
+
+func (m *mockStore) ListRelayQuality(ctx context.Context, f store.RelayQualityFilter) ([]model.RelayQualityDay, error) {
+	if m.listRelayQualityFn != nil {
+		return m.listRelayQualityFn(ctx, f)
+	}
+	return nil, nil
+}
+
+func (m *mockStore) ListRelayHealth(ctx context.Context, sessionID string, limit int) ([]model.RelayHealthEvent, error) {
+	if m.listRelayHealthFn != nil {
+		return m.listRelayHealthFn(ctx, sessionID, limit)
+	}
+	return nil, nil
+}
+
+func (m *mockStore) UpdateRelayManifestEntry(ctx context.Context, in store.RelayManifestUpdate) (*model.RelayManifestEntry, error) {
+	if m.updateManifestFn != nil {
+		return m.updateManifestFn(ctx, in)
+	}
+	return nil, store.ErrNotFound
+}
+
+// The mock keeps job run requests in memory; run IDs are 1-based indexes.
+func (m *mockStore) RequestJobRun(_ context.Context, job, requestedBy string) (*model.JobRun, error) {
+	run := model.JobRun{ID: int64(len(m.jobRuns) + 1), Job: job, RequestedBy: requestedBy, Status: model.JobRunPending, RequestedAt: time.Now()}
+	m.jobRuns = append(m.jobRuns, run)
+	return &run, nil
+}
+
+func (m *mockStore) GetJobRun(_ context.Context, id int64) (*model.JobRun, error) {
+	if id < 1 || id > int64(len(m.jobRuns)) {
+		return nil, store.ErrNotFound
+	}
+	run := m.jobRuns[id-1]
+	return &run, nil
+}
+
+func (m *mockStore) EraseUserData(ctx context.Context, userID, requestedBy string) (*model.UserDataErasure, bool, error) {
+	if m.eraseUserDataFn != nil {
+		return m.eraseUserDataFn(ctx, userID, requestedBy)
+	}
+	return nil, false, store.ErrNotFound
+}
+
+func (m *mockStore) RecordUserDataExport(ctx context.Context, userID, requestedBy string, every time.Duration) (time.Time, error) {
+	if m.recordExportFn != nil {
+		return m.recordExportFn(ctx, userID, requestedBy, every)
+	}
+	return time.Now(), nil
+}
+
+func (m *mockStore) ExportUserSessions(context.Context, string) (store.RowIterator[model.Session], error) {
+	return &sliceRows[model.Session]{rows: m.userSessions}, nil
+}
+
+func (m *mockStore) ExportUserUsage(context.Context, string) (store.UsageExport, error) {
+	return &sliceRows[model.UsageExportRow]{rows: m.userUsage}, nil
+}
+
+func (m *mockStore) ExportUserSessionEvents(context.Context, string) (store.RowIterator[model.SessionEvent], error) {
+	return &sliceRows[model.SessionEvent]{rows: m.userEvents, err: m.userEventsErr}, nil
+}
+
+func (m *mockStore) CountSessionsByStatusRegion(ctx context.Context) (map[model.SessionStatus]map[string]int, error) {
+	if m.countSessionsFn != nil {
+		return m.countSessionsFn(ctx)
+	}
+	return map[model.SessionStatus]map[string]int{}, nil
+}
+
+func (m *mockStore) ProvisionStats(ctx context.Context, since time.Time) (model.ProvisionStats, error) {
+	if m.provisionStatsFn != nil {
+		return m.provisionStatsFn(ctx, since)
+	}
+	return model.ProvisionStats{}, nil
+}
+
+func (m *mockStore) CountStaleRelays(ctx context.Context, heartbeatTimeout time.Duration) (int, error) {
+	if m.countStaleRelaysFn != nil {
+		return m.countStaleRelaysFn(ctx, heartbeatTimeout)
+	}
+	return 0, nil
+}
+
+func (m *mockStore) CountPendingTerminations(ctx context.Context) (int, error) {
+	if m.pendingTerminationsFn != nil {
+		return m.pendingTerminationsFn(ctx)
+	}
+	return 0, nil
+}
+
+func (m *mockStore) InstanceSecondsByTerminateReason(ctx context.Context, since time.Time) (map[string]int64, error) {
+	if m.instanceSecondsFn != nil {
+		return m.instanceSecondsFn(ctx, since)
+	}
+	return map[string]int64{}, nil
+}
+
+func (m *mockStore) CostOverview(ctx context.Context, topUsers int) (model.CostOverview, error) {
+	if m.costOverviewFn != nil {
+		return m.costOverviewFn(ctx, topUsers)
+	}
+	return model.CostOverview{TopUsers: []model.UserCycleCost{}}, nil
+}
+
+// sliceRows is a store.RowIterator over fixed rows that fails with err once
+// they run out.
+type sliceRows[T any] struct {
+	rows []T
+	err  error
+	pos  int
+}
+
+type sliceUsageExport = sliceRows[model.UsageExportRow]
+
+func (e *sliceRows[T]) Next() bool {
+	if e.pos >= len(e.rows) {
+		return false
+	}
+	e.pos++
+	return true
+}
+
+func (e *sliceRows[T]) Row() T     { return e.rows[e.pos-1] }
+func (e *sliceRows[T]) Err() error { return e.err }
+func (e *sliceRows[T]) Close()     {}
+
 func (m *mockStore) StartOrGetSession(ctx context.Context, in store.StartInput) (*model.Session, bool, error) {
 	if m.startOrGetSessionFn != nil {
 		return m.startOrGetSessionFn(ctx, in)
@@ -37,6 +303,20 @@ func (m *mockStore) StartOrGetSession(ctx context.Context, in store.StartInput)
 	return nil, false, nil
 }
 
+func (m *mockStore) PreviewStart(ctx context.Context, in store.StartInput) (store.StartPreview, error) {
+	if m.previewStartFn != nil {
+		return m.previewStartFn(ctx, in)
+	}
+	return store.StartPreview{}, nil
+}
+
+func (m *mockStore) AdvanceProvisioningPhase(ctx context.Context, userID, sessionID, phase string) error {
+	if m.advancePhaseFn != nil {
+		return m.advancePhaseFn(ctx, userID, sessionID, phase)
+	}
+	return nil
+}
+
 func (m *mockStore) ActivateProvisionedSession(ctx context.Context, in store.ActivateProvisionedSessionInput) (*model.Session, error) {
 	if m.activateSessionFn != nil {
 		return m.activateSessionFn(ctx, in)
@@ -48,9 +328,25 @@ func (m *mockStore) GetActiveSession(ctx context.Context, userID string) (*model
 	if m.getActiveSessionFn != nil {
 		return m.getActiveSessionFn(ctx, userID)
 	}
+	return nil, store.ErrNotFound
+}
+
+func (m *mockStore) ListLiveSessions(ctx context.Context, userID, tag string) ([]model.Session, error) {
+	if m.listLiveSessionsFn != nil {
+		sessions, err := m.listLiveSessionsFn(ctx, userID)
+		return withTag(sessions, tag), err
+	}
 	return nil, nil
 }
 
+// withTag filters sessions to those tagged tag, as the store does.
+func withTag(sessions []model.Session, tag string) []model.Session {
+	if tag == "" {
+		return sessions
+	}
+	return slices.DeleteFunc(sessions, func(sess model.Session) bool { return !slices.Contains(sess.Tags, tag) })
+}
+
 func (m *mockStore) GetSessionByID(ctx context.Context, userID, sessionID string) (*model.Session, error) {
 	if m.getSessionByIDFn != nil {
 		return m.getSessionByIDFn(ctx, userID, sessionID)
@@ -58,16 +354,33 @@ func (m *mockStore) GetSessionByID(ctx context.Context, userID, sessionID string
 	return nil, store.ErrNotFound
 }
 
-func (m *mockStore) StopSession(ctx context.Context, userID, sessionID string) (*model.Session, error) {
+func (m *mockStore) StopSession(ctx context.Context, in store.StopSessionInput) (*model.Session, error) {
+	m.stopMu.Lock()
+	m.stopInputs = append(m.stopInputs, in)
+	m.stopMu.Unlock()
 	if m.stopSessionFn != nil {
-		return m.stopSessionFn(ctx, userID, sessionID)
+		return m.stopSessionFn(ctx, in.UserID, in.SessionID, in.Reason)
+	}
+	return nil, store.ErrNotFound
+}
+
+func (m *mockStore) StopProvisionedSession(ctx context.Context, userID, sessionID, region, awsInstanceID string) (*model.Session, error) {
+	if m.stopProvisionedFn != nil {
+		return m.stopProvisionedFn(ctx, userID, sessionID, region, awsInstanceID)
 	}
 	return nil, store.ErrNotFound
 }
 
-func (m *mockStore) GetUsageCurrent(ctx context.Context, userID string) (*model.UsageCurrent, error) {
+func (m *mockStore) GetUsageCurrent(ctx context.Context, userID string, freeIncludedSeconds int) (*model.UsageCurrent, error) {
 	if m.getUsageCurrentFn != nil {
-		return m.getUsageCurrentFn(ctx, userID)
+		return m.getUsageCurrentFn(ctx, userID, freeIncludedSeconds)
+	}
+	return nil, store.ErrNotFound
+}
+
+func (m *mockStore) PreviewUsageCurrent(ctx context.Context, userID string, freeIncludedSeconds int) (*model.UsageCurrent, error) {
+	if m.previewUsageCurrentFn != nil {
+		return m.previewUsageCurrentFn(ctx, userID, freeIncludedSeconds)
 	}
 	return nil, store.ErrNotFound
 }
@@ -86,9 +399,122 @@ func (m *mockStore) ListRelayManifest(ctx context.Context) ([]model.RelayManifes
 	return nil, nil
 }
 
+func (m *mockStore) ListSessions(ctx context.Context, status, tag string, limit int) ([]model.Session, error) {
+	if m.listSessionsFn != nil {
+		sessions, err := m.listSessionsFn(ctx, status, limit)
+		return withTag(sessions, tag), err
+	}
+	return nil, nil
+}
+
+func (m *mockStore) GetSessionRelay(ctx context.Context, sessionID string) (*model.SessionRelay, error) {
+	if m.getSessionRelayFn != nil {
+		return m.getSessionRelayFn(ctx, sessionID)
+	}
+	return nil, store.ErrNotFound
+}
+
+func (m *mockStore) MarkRelayInterrupted(ctx context.Context, sessionID, awsInstanceID string) (*model.Session, error) {
+	if m.markInterruptedFn != nil {
+		return m.markInterruptedFn(ctx, sessionID, awsInstanceID)
+	}
+	return nil, store.ErrNotFound
+}
+
+func (m *mockStore) GetRelaySession(ctx context.Context, sessionID, awsInstanceID string) (*model.Session, error) {
+	if m.getRelaySessionFn != nil {
+		return m.getRelaySessionFn(ctx, sessionID, awsInstanceID)
+	}
+	return nil, store.ErrNotFound
+}
+
+func (m *mockStore) GetSessionTimers(ctx context.Context, sessionID string) (*model.Session, error) {
+	if m.getSessionTimersFn != nil {
+		return m.getSessionTimersFn(ctx, sessionID)
+	}
+	return nil, store.ErrNotFound
+}
+
+// The mock keeps provision attempts in memory; IDs are 1-based indexes.
+func (m *mockStore) RecordProvisionAttempt(_ context.Context, a model.ProvisionAttempt) (int64, error) {
+	m.attemptsMu.Lock()
+	defer m.attemptsMu.Unlock()
+	a.ID = int64(len(m.provisionAttempts) + 1)
+	m.provisionAttempts = append(m.provisionAttempts, a)
+	return a.ID, nil
+}
+
+func (m *mockStore) SetProvisionAttemptCompensation(_ context.Context, id int64, compensation string) error {
+	m.attemptsMu.Lock()
+	defer m.attemptsMu.Unlock()
+	m.provisionAttempts[id-1].Compensation = compensation
+	return nil
+}
+
+func (m *mockStore) ListProvisionAttempts(_ context.Context, sessionID string) ([]model.ProvisionAttempt, error) {
+	m.attemptsMu.Lock()
+	defer m.attemptsMu.Unlock()
+	var out []model.ProvisionAttempt
+	for _, a := range m.provisionAttempts {
+		if a.SessionID == sessionID {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
+func (m *mockStore) ListSessionEvents(ctx context.Context, userID string, afterID int64, limit int) ([]model.SessionEvent, error) {
+	if m.listSessionEventsFn != nil {
+		return m.listSessionEventsFn(ctx, userID, afterID, limit)
+	}
+	return nil, nil
+}
+
+func (m *mockStore) ListSessionChanges(ctx context.Context, afterSeq int64, limit int) ([]model.SessionChange, error) {
+	if m.listSessionChangesFn != nil {
+		return m.listSessionChangesFn(ctx, afterSeq, limit)
+	}
+	return nil, nil
+}
+
+func (m *mockStore) AckSessionChanges(_ context.Context, consumer string, seq int64) error {
+	if m.ackedChanges == nil {
+		m.ackedChanges = map[string]int64{}
+	}
+	m.ackedChanges[consumer] = max(m.ackedChanges[consumer], seq)
+	return nil
+}
+
+func (m *mockStore) LatestSessionEventID(_ context.Context, _ string) (int64, error) {
+	return m.latestSessionEventID, nil
+}
+
+func (m *mockStore) RecordSessionEvent(ctx context.Context, sessionID, userID, eventType string, payload any) error {
+	if m.recordSessionEventFn != nil {
+		return m.recordSessionEventFn(ctx, sessionID, userID, eventType, payload)
+	}
+	return nil
+}
+
+func (m *mockStore) GetActiveRelayAccess(ctx context.Context, userID string) (*model.RelayAccess, error) {
+	if m.getRelayAccessFn != nil {
+		return m.getRelayAccessFn(ctx, userID)
+	}
+	return nil, store.ErrNotFound
+}
+
+func (m *mockStore) UpdateRelayAllowedClientIP(ctx context.Context, relayInstanceID, clientIP string) error {
+	if m.updateAllowedClientIPFn != nil {
+		return m.updateAllowedClientIPFn(ctx, relayInstanceID, clientIP)
+	}
+	return nil
+}
+
 type mockProvisioner struct {
 	provisionFn   func(context.Context, relay.ProvisionRequest) (relay.ProvisionResult, error)
 	deprovisionFn func(context.Context, relay.DeprovisionRequest) error
+	authorizeFn   func(context.Context, relay.AuthorizeClientIPRequest) error
+	statusFn      func(context.Context, relay.StatusRequest) (relay.StatusResult, error)
 }
 
 func (m *mockProvisioner) Provision(ctx context.Context, req relay.ProvisionRequest) (relay.ProvisionResult, error) {
@@ -112,18 +538,28 @@ func (m *mockProvisioner) Deprovision(ctx context.Context, req relay.Deprovision
 	return nil
 }
 
-func TestRelayStop_IdempotentAlreadyStoppedSkipsDeprovision(t *testing.T) {
+func (m *mockProvisioner) Status(ctx context.Context, req relay.StatusRequest) (relay.StatusResult, error) {
+	if m.statusFn != nil {
+		return m.statusFn(ctx, req)
+	}
+	return relay.StatusResult{State: relay.InstanceRunning}, nil
+}
+
+func (m *mockProvisioner) AuthorizeClientIP(ctx context.Context, req relay.AuthorizeClientIPRequest) error {
+	if m.authorizeFn != nil {
+		return m.authorizeFn(ctx, req)
+	}
+	return nil
+}
+
+func (m *mockProvisioner) ValidateConfig(_ context.Context, _ []string) []error {
+	return nil
+}
+
+func TestRelayStop_IdempotentAlreadyStoppedReturns200(t *testing.T) {
 	stoppedAt := time.Now().UTC()
 	ms := &mockStore{
-		getSessionByIDFn: func(_ context.Context, _, _ string) (*model.Session, error) {
-			return &model.Session{
-				ID:                 "ses_1",
-				UserID:             "usr_1",
-				Status:             model.SessionStopped,
-				RelayAWSInstanceID: "i-abc",
-			}, nil
-		},
-		stopSessionFn: func(_ context.Context, _, _ string) (*model.Session, error) {
+		stopSessionFn: func(_ context.Context, _, _, _ string) (*model.Session, error) {
 			return &model.Session{
 				ID:        "ses_1",
 				UserID:    "usr_1",
@@ -133,15 +569,7 @@ func TestRelayStop_IdempotentAlreadyStoppedSkipsDeprovision(t *testing.T) {
 		},
 	}
 
-	deprovCalls := 0
-	mp := &mockProvisioner{
-		deprovisionFn: func(_ context.Context, _ relay.DeprovisionRequest) error {
-			deprovCalls++
-			return nil
-		},
-	}
-
-	router := NewRouter(testConfig(), ms, mp)
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
 	req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/stop", jsonBody(map[string]any{
 		"session_id": "ses_1",
 		"reason":     "user_requested",
@@ -153,40 +581,29 @@ func TestRelayStop_IdempotentAlreadyStoppedSkipsDeprovision(t *testing.T) {
 	if rr.Code != http.StatusOK {
 		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
 	}
-	if deprovCalls != 0 {
-		t.Fatalf("expected no deprovision call for stopped session, got %d", deprovCalls)
-	}
 }
 
-func TestRelayStop_ActiveSessionCallsDeprovisionThenStops(t *testing.T) {
+func TestRelayStop_ActiveSessionQueuesTerminationWithoutDeprovisioning(t *testing.T) {
 	stoppedAt := time.Now().UTC()
 	ms := &mockStore{
-		getSessionByIDFn: func(_ context.Context, _, _ string) (*model.Session, error) {
+		stopSessionFn: func(_ context.Context, userID, sessionID, _ string) (*model.Session, error) {
+			if userID != "usr_1" || sessionID != "ses_2" {
+				t.Fatalf("unexpected stop target user=%s session=%s", userID, sessionID)
+			}
 			return &model.Session{
 				ID:                 "ses_2",
 				UserID:             "usr_1",
-				Status:             model.SessionActive,
-				Region:             "us-east-1",
+				Status:             model.SessionStopping,
 				RelayAWSInstanceID: "i-xyz",
-			}, nil
-		},
-		stopSessionFn: func(_ context.Context, _, _ string) (*model.Session, error) {
-			return &model.Session{
-				ID:        "ses_2",
-				UserID:    "usr_1",
-				Status:    model.SessionStopped,
-				StoppedAt: &stoppedAt,
+				StoppedAt:          &stoppedAt,
 			}, nil
 		},
 	}
 
 	deprovCalls := 0
 	mp := &mockProvisioner{
-		deprovisionFn: func(_ context.Context, req relay.DeprovisionRequest) error {
+		deprovisionFn: func(_ context.Context, _ relay.DeprovisionRequest) error {
 			deprovCalls++
-			if req.AWSInstanceID != "i-xyz" {
-				t.Fatalf("unexpected instance id: %s", req.AWSInstanceID)
-			}
 			return nil
 		},
 	}
@@ -200,37 +617,106 @@ func TestRelayStop_ActiveSessionCallsDeprovisionThenStops(t *testing.T) {
 	rr := httptest.NewRecorder()
 	router.ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusOK {
-		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d body=%s", rr.Code, rr.Body.String())
 	}
-	if deprovCalls != 1 {
-		t.Fatalf("expected exactly one deprovision call, got %d", deprovCalls)
+	var body map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body["status"] != "stopping" || body["reason"] != model.ClientStopUserRequested {
+		t.Fatalf("expected stopping status and the reason echoed, got %v", body)
+	}
+	if deprovCalls != 0 {
+		t.Fatalf("expected termination to be left to the jobs worker, got %d deprovision calls", deprovCalls)
 	}
 }
 
-func TestRelayStop_DeprovisionFailureReturns500(t *testing.T) {
+func TestRelayStop_ConcurrentStopsShareOneResult(t *testing.T) {
+	// The store mock behaves like StopSession under the row lock: the first
+	// stop moves the session to stopping and queues its termination, later
+	// ones find it stopping and return it unchanged.
+	var mu sync.Mutex
+	var stopped *model.Session
+	queued := 0
+	var arrived sync.WaitGroup
+	arrived.Add(2)
 	ms := &mockStore{
-		getSessionByIDFn: func(_ context.Context, _, _ string) (*model.Session, error) {
-			return &model.Session{
-				ID:                 "ses_3",
-				UserID:             "usr_1",
-				Status:             model.SessionActive,
-				Region:             "us-east-1",
-				RelayAWSInstanceID: "i-fail",
-			}, nil
-		},
-		stopSessionFn: func(_ context.Context, _, _ string) (*model.Session, error) {
-			t.Fatal("stop should not be called when deprovision fails")
-			return nil, nil
+		stopSessionFn: func(_ context.Context, userID, sessionID, _ string) (*model.Session, error) {
+			arrived.Done()
+			arrived.Wait()
+			mu.Lock()
+			defer mu.Unlock()
+			if stopped == nil {
+				stoppedAt := time.Now().UTC().Truncate(time.Second)
+				stopped = &model.Session{ID: sessionID, UserID: userID, Status: model.SessionStopping, RelayAWSInstanceID: "i-xyz", StoppedAt: &stoppedAt}
+				queued++
+			}
+			out := *stopped
+			return &out, nil
 		},
 	}
+	var mpMu sync.Mutex
+	calls := 0
+	count := func() {
+		mpMu.Lock()
+		calls++
+		mpMu.Unlock()
+	}
 	mp := &mockProvisioner{
-		deprovisionFn: func(_ context.Context, _ relay.DeprovisionRequest) error {
-			return context.DeadlineExceeded
+		provisionFn: func(context.Context, relay.ProvisionRequest) (relay.ProvisionResult, error) {
+			count()
+			return relay.ProvisionResult{}, errors.New("unexpected provision")
+		},
+		deprovisionFn: func(context.Context, relay.DeprovisionRequest) error {
+			count()
+			return nil
 		},
 	}
-
 	router := NewRouter(testConfig(), ms, mp)
+
+	responses := make([]*httptest.ResponseRecorder, 2)
+	var wg sync.WaitGroup
+	for i := range responses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/stop", jsonBody(map[string]any{"session_id": "ses_2"}))
+			req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+			responses[i] = httptest.NewRecorder()
+			router.ServeHTTP(responses[i], req)
+		}()
+	}
+	wg.Wait()
+
+	var bodies [2]map[string]any
+	for i, rr := range responses {
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("stop %d: expected 202, got %d body=%s", i, rr.Code, rr.Body.String())
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &bodies[i]); err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+	}
+	if bodies[0]["status"] != "stopping" || bodies[0]["stopped_at"] != bodies[1]["stopped_at"] {
+		t.Fatalf("expected both stops to report the same stop, got %v and %v", bodies[0], bodies[1])
+	}
+	if len(ms.stopInputs) != 2 || queued != 1 {
+		t.Fatalf("expected two stops and one queued termination, got %d stops and %d terminations", len(ms.stopInputs), queued)
+	}
+	if calls != 0 {
+		t.Fatalf("expected termination to be left to the jobs worker, got %d provisioner calls", calls)
+	}
+}
+
+func TestRelayStop_StoreFailureReturns500(t *testing.T) {
+	ms := &mockStore{
+		stopSessionFn: func(_ context.Context, _, _, _ string) (*model.Session, error) {
+			return nil, context.DeadlineExceeded
+		},
+	}
+
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
 	req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/stop", jsonBody(map[string]any{
 		"session_id": "ses_3",
 		"reason":     "user_requested",
@@ -244,6 +730,80 @@ func TestRelayStop_DeprovisionFailureReturns500(t *testing.T) {
 	}
 }
 
+func TestRelayStop_ValidatesAndRecordsClientReason(t *testing.T) {
+	cases := []struct {
+		name       string
+		body       map[string]any
+		wantCode   apierr.Code
+		wantReason string
+		wantDetail string
+	}{
+		{name: "omitted", body: map[string]any{}, wantReason: model.ClientStopUserRequested},
+		{name: "normalized", body: map[string]any{"reason": " Stream_Ended ", "detail": "  OBS closed  "}, wantReason: model.ClientStopStreamEnded, wantDetail: "OBS closed"},
+		{name: "detail at limit", body: map[string]any{"reason": "other", "detail": strings.Repeat("é", model.MaxClientStopDetailLength)}, wantReason: model.ClientStopOther, wantDetail: strings.Repeat("é", model.MaxClientStopDetailLength)},
+		{name: "unknown reason", body: map[string]any{"reason": "shutdown"}, wantCode: apierr.InvalidStopReason},
+		{name: "detail too long", body: map[string]any{"reason": "other", "detail": strings.Repeat("x", model.MaxClientStopDetailLength+1)}, wantCode: apierr.InvalidField},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := &mockStore{
+				stopSessionFn: func(_ context.Context, _, sessionID, _ string) (*model.Session, error) {
+					return &model.Session{ID: sessionID, Status: model.SessionStopped}, nil
+				},
+			}
+			router := NewRouter(testConfig(), ms, &mockProvisioner{})
+			tc.body["session_id"] = "ses_1"
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/stop", jsonBody(tc.body))
+			req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if tc.wantCode != "" {
+				assertAPIError(t, rr, tc.wantCode)
+				if len(ms.stopInputs) != 0 {
+					t.Fatalf("expected no stop, got %+v", ms.stopInputs)
+				}
+				return
+			}
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+			}
+			var body map[string]any
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			if body["reason"] != tc.wantReason {
+				t.Fatalf("expected reason %q echoed, got %v", tc.wantReason, body["reason"])
+			}
+			want := store.StopSessionInput{UserID: "usr_1", SessionID: "ses_1", Reason: model.StopReasonUser, ClientReason: tc.wantReason, ClientDetail: tc.wantDetail}
+			if len(ms.stopInputs) != 1 || ms.stopInputs[0] != want {
+				t.Fatalf("expected stop %+v, got %+v", want, ms.stopInputs)
+			}
+		})
+	}
+}
+
+func TestRelayStart_StoppingSessionReturns409(t *testing.T) {
+	ms := &mockStore{
+		startOrGetSessionFn: func(_ context.Context, _ store.StartInput) (*model.Session, bool, error) {
+			return nil, false, store.ErrSessionStopping
+		},
+	}
+
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/start", jsonBody(map[string]any{
+		"region_preference": "us-east-1",
+	}))
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	req.Header.Set("Idempotency-Key", "0f4f5d1c-5a43-4a5e-9c3b-0b0cf2f7d0a1")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d body=%s", rr.Code, rr.Body.String())
+	}
+}
+
 func TestRelayStart_IdempotencyReplaySkipsProvisioning(t *testing.T) {
 	idem := "8a849d0e-04eb-4a11-bf8a-6b8e5ea1572f"
 	firstSession := &model.Session{
@@ -414,7 +974,7 @@ func TestRelayStart_ProvisionFailureCompensatesByStoppingSession(t *testing.T) {
 		startOrGetSessionFn: func(_ context.Context, _ store.StartInput) (*model.Session, bool, error) {
 			return createdSession, true, nil
 		},
-		stopSessionFn: func(_ context.Context, userID, sessionID string) (*model.Session, error) {
+		stopSessionFn: func(_ context.Context, userID, sessionID, _ string) (*model.Session, error) {
 			stopCalls++
 			if userID != "usr_1" || sessionID != "ses_prov_fail" {
 				t.Fatalf("unexpected stop target user=%s session=%s", userID, sessionID)
@@ -461,7 +1021,7 @@ func TestRelayStart_ProvisionFailureCompensatesByStoppingSession(t *testing.T) {
 	}
 }
 
-func TestRelayStart_ActivationFailureCompensatesByDeprovisionAndStoppingSession(t *testing.T) {
+func TestRelayStart_ActivationFailureQueuesTerminationOfLaunchedRelay(t *testing.T) {
 	createdSession := &model.Session{
 		ID:                 "ses_activate_fail",
 		UserID:             "usr_1",
@@ -485,15 +1045,15 @@ func TestRelayStart_ActivationFailureCompensatesByDeprovisionAndStoppingSession(
 			}
 			return nil, context.Canceled
 		},
-		stopSessionFn: func(_ context.Context, userID, sessionID string) (*model.Session, error) {
+		stopProvisionedFn: func(_ context.Context, userID, sessionID, _, awsInstanceID string) (*model.Session, error) {
 			stopCalls++
-			if userID != "usr_1" || sessionID != "ses_activate_fail" {
-				t.Fatalf("unexpected stop target user=%s session=%s", userID, sessionID)
+			if userID != "usr_1" || sessionID != "ses_activate_fail" || awsInstanceID != "i-orphan-risk" {
+				t.Fatalf("unexpected stop target user=%s session=%s instance=%s", userID, sessionID, awsInstanceID)
 			}
 			return &model.Session{
 				ID:     sessionID,
 				UserID: userID,
-				Status: model.SessionStopped,
+				Status: model.SessionStopping,
 			}, nil
 		},
 	}
@@ -510,11 +1070,8 @@ func TestRelayStart_ActivationFailureCompensatesByDeprovisionAndStoppingSession(
 				WSURL:         "wss://relay.test/ws",
 			}, nil
 		},
-		deprovisionFn: func(_ context.Context, req relay.DeprovisionRequest) error {
+		deprovisionFn: func(_ context.Context, _ relay.DeprovisionRequest) error {
 			deprovCalls++
-			if req.SessionID != "ses_activate_fail" || req.AWSInstanceID != "i-orphan-risk" {
-				t.Fatalf("unexpected deprovision request: %+v", req)
-			}
 			return nil
 		},
 	}
@@ -537,8 +1094,8 @@ func TestRelayStart_ActivationFailureCompensatesByDeprovisionAndStoppingSession(
 	if activateCalls != 1 {
 		t.Fatalf("expected 1 activation call, got %d", activateCalls)
 	}
-	if deprovCalls != 1 {
-		t.Fatalf("expected 1 deprovision compensation call, got %d", deprovCalls)
+	if deprovCalls != 0 {
+		t.Fatalf("expected termination to be queued rather than run inline, got %d deprovision calls", deprovCalls)
 	}
 	if stopCalls != 1 {
 		t.Fatalf("expected 1 stop compensation call, got %d", stopCalls)
@@ -554,12 +1111,14 @@ func TestRelayManifest_ReturnsConfiguredEntries(t *testing.T) {
 					Region:              "eu-west-1",
 					AMIID:               "ami-0456efgh",
 					DefaultInstanceType: "t4g.small",
+					Enabled:             true,
 					UpdatedAt:           ts,
 				},
 				{
 					Region:              "us-east-1",
 					AMIID:               "ami-0123abcd",
 					DefaultInstanceType: "t4g.small",
+					Enabled:             true,
 					UpdatedAt:           ts,
 				},
 			}, nil
@@ -586,6 +1145,56 @@ func TestRelayManifest_ReturnsConfiguredEntries(t *testing.T) {
 	}
 }
 
+type resolvingProvisioner struct {
+	mockProvisioner
+	amis map[string]string
+}
+
+func (p *resolvingProvisioner) ResolveAMI(_ context.Context, _, ami string) (string, error) {
+	if !strings.HasPrefix(ami, "ssm:") {
+		return ami, nil
+	}
+	if resolved, ok := p.amis[ami]; ok {
+		return resolved, nil
+	}
+	return "", errors.New("parameter not found")
+}
+
+func TestRelayManifest_ShowsResolvedSSMAMIs(t *testing.T) {
+	ms := &mockStore{
+		listRelayManifestFn: func(_ context.Context) ([]model.RelayManifestEntry, error) {
+			return []model.RelayManifestEntry{
+				{Region: "eu-west-1", AMIID: "ssm:/aegis/relay/missing", Available: true, Enabled: true},
+				{Region: "us-east-1", AMIID: "ssm:/aegis/relay/ami", Available: true, Enabled: true},
+			}, nil
+		},
+	}
+	prov := &resolvingProvisioner{amis: map[string]string{"ssm:/aegis/relay/ami": "ami-0123abcd"}}
+
+	router := NewRouter(testConfig(), ms, prov)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/relay/manifest", nil)
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Regions []map[string]any `json:"regions"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	failed, resolved := body.Regions[0], body.Regions[1]
+	if resolved["ami_id"] != "ami-0123abcd" || resolved["ami_parameter"] != "ssm:/aegis/relay/ami" || resolved["available"] != true {
+		t.Fatalf("unexpected resolved entry: %v", resolved)
+	}
+	if failed["ami_id"] != "" || failed["available"] != false {
+		t.Fatalf("expected unresolvable entry to be unavailable, got %v", failed)
+	}
+}
+
 func TestRelayManifest_EmptyManifestReturns503(t *testing.T) {
 	ms := &mockStore{
 		listRelayManifestFn: func(_ context.Context) ([]model.RelayManifestEntry, error) {
@@ -628,6 +1237,32 @@ func TestRelayHealth_RejectedPayloadReturns400(t *testing.T) {
 	}
 }
 
+func TestRelayHealth_OutOfRangeUptimeReturns422(t *testing.T) {
+	ms := &mockStore{
+		recordRelayHealthEventFn: func(context.Context, store.RelayHealthInput) error {
+			t.Fatal("expected the event not to reach the store")
+			return nil
+		},
+	}
+
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+	for _, uptime := range []int64{-1, 1 << 31} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/health", jsonBody(map[string]any{
+			"session_id":             "ses_1",
+			"instance_id":            "i-1",
+			"session_uptime_seconds": uptime,
+		}))
+		req.Header.Set("X-Relay-Auth", "relay-key")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assertAPIError(t, rr, apierr.InvalidField)
+		if !strings.Contains(rr.Body.String(), "session_uptime_seconds") {
+			t.Fatalf("expected the field named, got %s", rr.Body.String())
+		}
+	}
+}
+
 func TestRelayHealth_StoreFailureReturns500(t *testing.T) {
 	ms := &mockStore{
 		recordRelayHealthEventFn: func(_ context.Context, _ store.RelayHealthInput) error {
@@ -652,6 +1287,60 @@ func TestRelayHealth_StoreFailureReturns500(t *testing.T) {
 	}
 }
 
+func TestRelayHealth_FlagsAgentsBelowMinimumVersion(t *testing.T) {
+	var got []store.RelayHealthInput
+	ms := &mockStore{
+		recordRelayHealthEventFn: func(_ context.Context, in store.RelayHealthInput) error {
+			got = append(got, in)
+			return nil
+		},
+	}
+	cfg := testConfig()
+	cfg.RelayMinAgentVersion = "1.4.0"
+	router := NewRouter(cfg, ms, &mockProvisioner{})
+
+	for _, tc := range []struct {
+		agentVersion string
+		outdated     bool
+	}{
+		{"v1.4", false},
+		{"1.10.0", false},
+		{"1.4.0-rc.1", true},
+		{"1.3.9", true},
+		{"", true},
+		{"nightly", true},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/health", jsonBody(map[string]any{
+			"session_id": "ses_1", "instance_id": "i-1", "session_uptime_seconds": 12, "agent_version": tc.agentVersion,
+		}))
+		req.Header.Set("X-Relay-Auth", "relay-key")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("agent %q: expected 200, got %d body=%s", tc.agentVersion, rr.Code, rr.Body.String())
+		}
+		var body struct {
+			UpgradeRequired bool   `json:"upgrade_required"`
+			MinAgentVersion string `json:"min_agent_version"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		in := got[len(got)-1]
+		if in.AgentOutdated != tc.outdated || in.AgentVersion != tc.agentVersion || body.UpgradeRequired != tc.outdated {
+			t.Fatalf("agent %q: expected outdated=%t, got input=%+v body=%+v", tc.agentVersion, tc.outdated, in, body)
+		}
+		if tc.outdated && body.MinAgentVersion != "1.4.0" {
+			t.Fatalf("agent %q: expected the minimum version in the response, got %+v", tc.agentVersion, body)
+		}
+	}
+
+	// Without a minimum no agent is flagged.
+	if agentOutdated("", "") {
+		t.Fatal("expected no agent outdated without a minimum")
+	}
+}
+
 func TestMetricsEndpoint_ExposesPrometheusPayload(t *testing.T) {
 	metrics.ResetDefaultForTest()
 
@@ -683,6 +1372,17 @@ func testConfig() config.Config {
 		DefaultRegion:   "us-east-1",
 		SupportedRegion: []string{"us-east-1", "eu-west-1"},
 		AWSInstanceType: "t4g.small",
+
+		HTTPRequestTimeout: 5 * time.Second,
+		HTTPStartTimeout:   30 * time.Second,
+		HTTPFastTimeout:    2 * time.Second,
+		HTTPStopTimeout:    10 * time.Second,
+
+		UnavailableRetryAfter: 30 * time.Second,
+		PairTokenLength:       8,
+
+		RelayHeartbeatInterval: 30 * time.Second,
+		RelayPingRateLimit:     60,
 	}
 }
 
@@ -705,3 +1405,184 @@ func jsonBody(v any) *bytes.Reader {
 	b, _ := json.Marshal(v)
 	return bytes.NewReader(b)
 }
+
+func TestRelayStart_ActivatesInFallbackRegion(t *testing.T) {
+	var activatedRegion string
+	ms := &mockStore{
+		startOrGetSessionFn: func(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+			return &model.Session{ID: "ses_fb", UserID: in.UserID, Status: model.SessionProvisioning, Region: in.Region}, true, nil
+		},
+		activateSessionFn: func(_ context.Context, in store.ActivateProvisionedSessionInput) (*model.Session, error) {
+			activatedRegion = in.Region
+			return &model.Session{ID: in.SessionID, UserID: in.UserID, Status: model.SessionActive, Region: in.Region}, nil
+		},
+	}
+	mp := &mockProvisioner{
+		provisionFn: func(_ context.Context, _ relay.ProvisionRequest) (relay.ProvisionResult, error) {
+			return relay.ProvisionResult{Region: "eu-west-1", AWSInstanceID: "i-fb", PublicIP: "198.51.100.9", SRTPort: 9000}, nil
+		},
+	}
+
+	router := NewRouter(testConfig(), ms, mp)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/start", jsonBody(map[string]any{"region_preference": "us-east-1"}))
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	req.Header.Set("Idempotency-Key", "5b0c3f0e-7f59-4d53-9d0b-2f4c8f4f7e11")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if activatedRegion != "eu-west-1" {
+		t.Fatalf("expected activation in fallback region, got %q", activatedRegion)
+	}
+}
+
+func TestRelayStart_PassesBootstrapSettingsToProvisioner(t *testing.T) {
+	var activatedToken string
+	ms := &mockStore{
+		startOrGetSessionFn: func(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+			return &model.Session{ID: "ses_boot", UserID: in.UserID, Status: model.SessionProvisioning, Region: in.Region}, true, nil
+		},
+		activateSessionFn: func(_ context.Context, in store.ActivateProvisionedSessionInput) (*model.Session, error) {
+			activatedToken = in.RelayWSToken
+			return &model.Session{ID: in.SessionID, UserID: in.UserID, Status: model.SessionActive, Region: in.Region}, nil
+		},
+	}
+	var got relay.ProvisionRequest
+	mp := &mockProvisioner{
+		provisionFn: func(_ context.Context, req relay.ProvisionRequest) (relay.ProvisionResult, error) {
+			got = req
+			return relay.ProvisionResult{AWSInstanceID: "i-boot", PublicIP: "198.51.100.9", SRTPort: relay.DefaultSRTPort}, nil
+		},
+	}
+	cfg := testConfig()
+	cfg.RelayControlPlaneURL = "https://api.telemy.test"
+	cfg.RelaySRTPortRange = relay.PortRange{Min: 10000, Max: 10009}
+	cfg.RelaySRTPortCount = 2
+
+	router := NewRouter(cfg, ms, mp)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/start", jsonBody(map[string]any{"region_preference": "us-east-1"}))
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	req.Header.Set("Idempotency-Key", "0f8e7a52-2f4b-4b0c-9a55-0c7d4f8a1b22")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got.ControlPlaneURL != "https://api.telemy.test" || got.SRTPortRange != cfg.RelaySRTPortRange || got.SRTPortCount != 2 || got.UserID != "usr_1" {
+		t.Fatalf("unexpected provision request: %+v", got)
+	}
+	if got.RelayAuthToken == "" || got.RelayAuthToken != activatedToken {
+		t.Fatalf("expected relay token %q to match activated token %q", got.RelayAuthToken, activatedToken)
+	}
+}
+
+func TestRelayStart_StaticIPUnavailableReturns503(t *testing.T) {
+	var stopped string
+	ms := &mockStore{
+		startOrGetSessionFn: func(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+			return &model.Session{ID: "ses_eip", UserID: in.UserID, Status: model.SessionProvisioning, Region: in.Region}, true, nil
+		},
+		stopSessionFn: func(_ context.Context, _ string, sessionID, _ string) (*model.Session, error) {
+			stopped = sessionID
+			return &model.Session{ID: sessionID, Status: model.SessionStopped}, nil
+		},
+	}
+	mp := &mockProvisioner{
+		provisionFn: func(_ context.Context, req relay.ProvisionRequest) (relay.ProvisionResult, error) {
+			if !req.StaticIP {
+				t.Fatal("expected static ip to be requested")
+			}
+			return relay.ProvisionResult{}, fmt.Errorf("%w: eip pool for us-east-1 exhausted", relay.ErrStaticIPUnavailable)
+		},
+	}
+
+	router := NewRouter(testConfig(), ms, mp)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/start", jsonBody(map[string]any{"region_preference": "us-east-1", "static_ip": true}))
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	req.Header.Set("Idempotency-Key", "4c1e2d7a-8b3f-4e55-9f1a-2d6b7c8e9f01")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "static_ip_unavailable") {
+		t.Fatalf("expected static_ip_unavailable code, got %s", rr.Body.String())
+	}
+	if stopped != "ses_eip" {
+		t.Fatalf("expected session compensation stop, got %q", stopped)
+	}
+}
+
+func TestRelayStart_ProviderUnavailableReturns503WithRetryAfter(t *testing.T) {
+	ms := &mockStore{
+		startOrGetSessionFn: func(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+			return &model.Session{ID: "ses_cb", UserID: in.UserID, Status: model.SessionProvisioning, Region: in.Region}, true, nil
+		},
+		stopSessionFn: func(_ context.Context, _ string, sessionID, _ string) (*model.Session, error) {
+			return &model.Session{ID: sessionID, Status: model.SessionStopped}, nil
+		},
+	}
+	mp := &mockProvisioner{
+		provisionFn: func(context.Context, relay.ProvisionRequest) (relay.ProvisionResult, error) {
+			return relay.ProvisionResult{}, fmt.Errorf("run instances: %w", &relay.UnavailableError{Op: "run_instances", Region: "us-east-1", RetryAfter: 12500 * time.Millisecond})
+		},
+	}
+
+	router := NewRouter(testConfig(), ms, mp)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/start", jsonBody(map[string]any{"region_preference": "us-east-1"}))
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	req.Header.Set("Idempotency-Key", "9d2f1c3e-5a7b-4c8d-9e0f-1a2b3c4d5e6f")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), "provider_unavailable") {
+		t.Fatalf("expected 503 provider_unavailable, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Retry-After"); got != "13" {
+		t.Fatalf("expected Retry-After 13, got %q", got)
+	}
+	assertUnavailable(t, rr, "provider_unavailable", 13)
+}
+
+func TestRelayStart_ReturnsIPv6FromFakeProvisioner(t *testing.T) {
+	ms := &mockStore{
+		startOrGetSessionFn: func(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+			return &model.Session{ID: "ses_v6", UserID: in.UserID, Status: model.SessionProvisioning, Region: in.Region}, true, nil
+		},
+		activateSessionFn: func(_ context.Context, in store.ActivateProvisionedSessionInput) (*model.Session, error) {
+			return &model.Session{
+				ID: in.SessionID, UserID: in.UserID, Status: model.SessionActive, Region: in.Region,
+				PublicIP: in.PublicIP, PublicIPv6: in.PublicIPv6, SRTPorts: in.SRTPorts, WSURL: in.WSURL,
+			}, nil
+		},
+	}
+
+	router := NewRouter(testConfig(), ms, relay.NewFakeProvisioner())
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/start", jsonBody(map[string]any{"region_preference": "us-east-1"}))
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	req.Header.Set("Idempotency-Key", "7d3f0c2e-1a4b-4c5d-8e9f-0a1b2c3d4e5f")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Session struct {
+			Relay struct {
+				PublicIP   string `json:"public_ip"`
+				PublicIPv6 string `json:"public_ipv6"`
+			} `json:"relay"`
+		} `json:"session"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if body.Session.Relay.PublicIP == "" || !strings.HasPrefix(body.Session.Relay.PublicIPv6, "2001:db8::") {
+		t.Fatalf("expected dual-stack relay addresses, got %+v", body.Session.Relay)
+	}
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+func TestRouteTimeouts_BoundHandlersByRoute(t *testing.T) {
+	remaining := map[string]time.Duration{}
+	record := func(ctx context.Context, route string) {
+		if dl, ok := ctx.Deadline(); ok {
+			remaining[route] = time.Until(dl)
+		}
+	}
+	ms := &mockStore{
+		getUsageCurrentFn: func(ctx context.Context, _ string, _ int) (*model.UsageCurrent, error) {
+			record(ctx, "usage")
+			return &model.UsageCurrent{}, nil
+		},
+		stopSessionFn: func(ctx context.Context, _, sessionID, _ string) (*model.Session, error) {
+			record(ctx, "stop")
+			return &model.Session{ID: sessionID, Status: model.SessionStopping}, nil
+		},
+		listLiveSessionsFn: func(ctx context.Context, _ string) ([]model.Session, error) {
+			record(ctx, "sessions")
+			return nil, nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+	for _, tc := range []struct {
+		method, path string
+		body         any
+	}{
+		{http.MethodGet, "/api/v1/usage/current", nil},
+		{http.MethodPost, "/api/v1/relay/stop", map[string]any{"session_id": "ses_1"}},
+		{http.MethodGet, "/api/v1/sessions", nil},
+	} {
+		var req *http.Request
+		if tc.body != nil {
+			req = httptest.NewRequest(tc.method, tc.path, jsonBody(tc.body))
+		} else {
+			req = httptest.NewRequest(tc.method, tc.path, nil)
+		}
+		req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	cfg := testConfig()
+	for route, want := range map[string]time.Duration{
+		"usage":    cfg.HTTPFastTimeout,
+		"stop":     cfg.HTTPStopTimeout,
+		"sessions": cfg.HTTPRequestTimeout,
+	} {
+		got, ok := remaining[route]
+		if !ok || got > want || got < want-time.Second {
+			t.Errorf("%s: expected a deadline about %s away, got %s (set %t)", route, want, got, ok)
+		}
+	}
+}
+
+func TestRouteTimeouts_NameRoutedPaths(t *testing.T) {
+	router := NewRouter(testConfig(), &mockStore{}, &mockProvisioner{})
+	routed := map[string]bool{}
+	err := chi.Walk(router.(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		routed[method+" "+route] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk routes: %v", err)
+	}
+	for route := range routeTimeouts(testConfig()) {
+		if !routed[route] {
+			t.Errorf("timeout for %s, which is not a route", route)
+		}
+	}
+}
+
+func TestWithTimeout_WritesAPIErrorWhenHandlerWritesNothing(t *testing.T) {
+	h := withTimeout("GET /slow", 10*time.Millisecond)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assertAPIError(t, rr, apierr.RequestTimeout)
+
+	// A handler that answered its own timeout keeps its response.
+	h = withTimeout("GET /slow", 10*time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		writeAPIError(w, apierr.StoreTimeout, "")
+	}))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assertAPIError(t, rr, apierr.StoreTimeout)
+}
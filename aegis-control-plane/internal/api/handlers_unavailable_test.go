@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+func assertUnavailable(t *testing.T, rr *httptest.ResponseRecorder, code string, retryAfter int) {
+	t.Helper()
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Retry-After"); got != strconv.Itoa(retryAfter) {
+		t.Fatalf("expected Retry-After %d, got %q", retryAfter, got)
+	}
+	var body apiError
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Error.Code != code || body.Error.RetryAfterSeconds != retryAfter {
+		t.Fatalf("expected %s with retry_after_seconds %d, got %s", code, retryAfter, rr.Body.String())
+	}
+}
+
+func TestRelayManifest_EmptyReturns503WithRetryAfter(t *testing.T) {
+	ms := &mockStore{
+		listRelayManifestFn: func(context.Context) ([]model.RelayManifestEntry, error) {
+			return nil, nil
+		},
+	}
+	cfg := testConfig()
+	cfg.UnavailableRetryAfter = 45 * time.Second
+	router := NewRouter(cfg, ms, &mockProvisioner{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/relay/manifest", nil)
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assertUnavailable(t, rr, "manifest_unavailable", 45)
+}
+
+func TestRelayStart_RegionOutOfCapacityReturns503WithRetryAfter(t *testing.T) {
+	stopped := ""
+	ms := &mockStore{
+		startOrGetSessionFn: func(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+			return &model.Session{ID: "ses_cap", UserID: in.UserID, Status: model.SessionProvisioning, Region: in.Region}, true, nil
+		},
+		stopSessionFn: func(_ context.Context, _ string, sessionID, _ string) (*model.Session, error) {
+			stopped = sessionID
+			return &model.Session{ID: sessionID, Status: model.SessionStopped}, nil
+		},
+	}
+	mp := &mockProvisioner{
+		provisionFn: func(context.Context, relay.ProvisionRequest) (relay.ProvisionResult, error) {
+			return relay.ProvisionResult{}, fmt.Errorf("%w: run instances: InsufficientInstanceCapacity", relay.ErrRegionUnavailable)
+		},
+	}
+	router := NewRouter(testConfig(), ms, mp)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/start", jsonBody(map[string]any{"region_preference": "us-east-1"}))
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	req.Header.Set("Idempotency-Key", "6c0f5b0e-2d7a-4f3b-8e51-3a9d2c4b7f10")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assertUnavailable(t, rr, "region_unavailable", 30)
+	if stopped != "ses_cap" {
+		t.Fatalf("expected session compensation stop, got %q", stopped)
+	}
+}
+
+func TestRelayStart_MapsTypedProvisionFailures(t *testing.T) {
+	tests := []struct {
+		providerCode string
+		code         apierr.Code
+		cause        string
+	}{
+		{"InsufficientInstanceCapacity", apierr.RegionUnavailable, "no_capacity"},
+		{"RequestLimitExceeded", apierr.ProviderThrottled, "throttled"},
+		{"VcpuLimitExceeded", apierr.ProviderQuotaExceeded, "quota_exceeded"},
+		{"InvalidAMIID.NotFound", apierr.RelayImageUnavailable, "image_unavailable"},
+		{"UnauthorizedOperation", apierr.ProviderAuthFailed, "auth"},
+		{"InternalError", apierr.InternalError, "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.providerCode, func(t *testing.T) {
+			metrics.ResetDefaultForTest()
+			ms := &mockStore{
+				startOrGetSessionFn: func(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+					return &model.Session{ID: "ses_typed", UserID: in.UserID, Status: model.SessionProvisioning, Region: in.Region}, true, nil
+				},
+				stopSessionFn: func(_ context.Context, _ string, sessionID, _ string) (*model.Session, error) {
+					return &model.Session{ID: sessionID, Status: model.SessionStopped}, nil
+				},
+			}
+			prov := relay.NewFakeProvisioner(relay.WithFakeFailFirst(1), relay.WithFakeErrorCodes(tt.providerCode))
+			rr := httptest.NewRecorder()
+			NewRouter(testConfig(), ms, prov).ServeHTTP(rr, relayStartRequestFor(t, "us-east-1"))
+
+			if tt.code.Status() == http.StatusServiceUnavailable {
+				assertUnavailable(t, rr, string(tt.code), 30)
+			} else {
+				assertAPIError(t, rr, tt.code)
+			}
+			if out := metrics.Default().Render(); !strings.Contains(out, `aegis_relay_provision_failures_total{cause="`+tt.cause+`"`) {
+				t.Fatalf("expected the failure counted by cause, got:\n%s", out)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+func exportUsage(t *testing.T, ms *mockStore, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/usage/export?"+query, nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminJWT(t, "test-secret", "usr_admin"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func exportRows() []model.UsageExportRow {
+	cycleStart := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	stoppedAt := time.Date(2026, 3, 2, 11, 0, 0, 0, time.UTC)
+	cost := 0.0168
+	return []model.UsageExportRow{
+		{
+			UserID: "usr_1", PlanTier: "starter", SessionID: "ses_1", Region: "us-east-1",
+			CycleStart: cycleStart, CycleEnd: cycleStart.AddDate(0, 1, 0),
+			StartedAt: time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC), StoppedAt: &stoppedAt,
+			BillableSeconds: 3600, UpdatedAt: stoppedAt,
+			EstimatedCostUSD: &cost, CycleEstimatedCostUSD: &cost,
+		},
+		{
+			UserID: `usr_"2",x`, PlanTier: "free", SessionID: "ses_2", Region: "eu-central",
+			CycleStart: cycleStart, CycleEnd: cycleStart.AddDate(0, 1, 0),
+			StartedAt:       time.Date(2026, 3, 3, 9, 0, 0, 0, time.UTC),
+			BillableSeconds: 4000, OverageSeconds: 400, UpdatedAt: time.Date(2026, 3, 3, 10, 0, 0, 0, time.UTC),
+		},
+	}
+}
+
+func TestAdminUsageExport_CSV(t *testing.T) {
+	var gotFrom, gotTo time.Time
+	ms := &mockStore{
+		exportUsageFn: func(_ context.Context, from, to time.Time) (store.UsageExport, error) {
+			gotFrom, gotTo = from, to
+			return &sliceUsageExport{rows: exportRows()}, nil
+		},
+	}
+	rr := exportUsage(t, ms, "cycle_start=2026-03-01")
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if !gotFrom.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) || !gotTo.Equal(time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected the cycles starting on 2026-03-01, got [%s, %s)", gotFrom, gotTo)
+	}
+	if got := rr.Header().Get("Content-Disposition"); got != `attachment; filename="usage-2026-03-01.csv"` {
+		t.Fatalf("unexpected content-disposition %q", got)
+	}
+	want := "user_id,plan_tier,session_id,region,cycle_start_at,cycle_end_at,started_at,stopped_at,billable_seconds,overage_seconds,updated_at,estimated_cost_usd,cycle_estimated_cost_usd\r\n" +
+		"usr_1,starter,ses_1,us-east-1,2026-03-01T00:00:00Z,2026-04-01T00:00:00Z,2026-03-02T10:00:00Z,2026-03-02T11:00:00Z,3600,0,2026-03-02T11:00:00Z,0.0168,0.0168\r\n" +
+		`"usr_""2"",x",free,ses_2,eu-central,2026-03-01T00:00:00Z,2026-04-01T00:00:00Z,2026-03-03T09:00:00Z,,4000,400,2026-03-03T10:00:00Z,,` + "\r\n"
+	if rr.Body.String() != want {
+		t.Fatalf("unexpected csv:\n%q\nwant:\n%q", rr.Body.String(), want)
+	}
+}
+
+func TestAdminUsageExport_JSON(t *testing.T) {
+	ms := &mockStore{
+		exportUsageFn: func(_ context.Context, _, _ time.Time) (store.UsageExport, error) {
+			return &sliceUsageExport{rows: exportRows()}, nil
+		},
+	}
+	rr := exportUsage(t, ms, "cycle_start=2026-03-01T00:00:00Z&format=json")
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Content-Disposition"); got != `attachment; filename="usage-20260301T000000Z.json"` {
+		t.Fatalf("unexpected content-disposition %q", got)
+	}
+	var body struct {
+		Records []map[string]any `json:"records"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v body=%s", err, rr.Body.String())
+	}
+	if len(body.Records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(body.Records))
+	}
+	if got := body.Records[1]; got["overage_seconds"] != float64(400) || got["stopped_at"] != nil || got["plan_tier"] != "free" {
+		t.Fatalf("unexpected record %v", got)
+	}
+	if got := body.Records[0]; got["estimated_cost_usd"] != 0.0168 || got["cycle_estimated_cost_usd"] != 0.0168 {
+		t.Fatalf("expected the session and cycle costs, got %v", got)
+	}
+	if _, ok := body.Records[1]["estimated_cost_usd"]; ok {
+		t.Fatalf("expected no cost on an unpriced session, got %v", body.Records[1])
+	}
+}
+
+func TestAdminUsageExport_RejectsBadParameters(t *testing.T) {
+	for _, query := range []string{"", "cycle_start=March", "cycle_start=2026-03-01&format=xlsx"} {
+		if rr := exportUsage(t, &mockStore{}, query); rr.Code != http.StatusBadRequest {
+			t.Fatalf("query %q: expected 400, got %d", query, rr.Code)
+		}
+	}
+}
+
+func TestAdminUsageExport_AbortsOnReadError(t *testing.T) {
+	ms := &mockStore{
+		exportUsageFn: func(_ context.Context, _, _ time.Time) (store.UsageExport, error) {
+			return &sliceUsageExport{rows: exportRows(), err: errors.New("connection reset")}, nil
+		},
+	}
+	defer func() {
+		if got := recover(); got != http.ErrAbortHandler {
+			t.Fatalf("expected the handler to abort the response, got %v", got)
+		}
+	}()
+	exportUsage(t, ms, "cycle_start=2026-03-01")
+}
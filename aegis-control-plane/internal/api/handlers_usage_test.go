@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+func getUsage(t *testing.T, ms *mockStore) *httptest.ResponseRecorder {
+	t.Helper()
+	cfg := testConfig()
+	cfg.FreeIncludedSeconds = 7200
+	cfg.UsageAlertThresholds = []int{80, 100}
+	router := NewRouter(cfg, ms, &mockProvisioner{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/usage/current", nil)
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestUsageCurrent_UnconfiguredUserGetsFreeTier(t *testing.T) {
+	var gotFree int
+	rr := getUsage(t, &mockStore{
+		getUsageCurrentFn: func(_ context.Context, _ string, freeIncludedSeconds int) (*model.UsageCurrent, error) {
+			gotFree = freeIncludedSeconds
+			return &model.UsageCurrent{
+				PlanTier:         "free",
+				CycleStart:       time.Date(2026, 2, 15, 9, 30, 0, 0, time.UTC),
+				CycleEnd:         time.Date(2026, 3, 15, 9, 30, 0, 0, time.UTC),
+				IncludedSeconds:  freeIncludedSeconds,
+				RemainingSeconds: freeIncludedSeconds,
+			}, nil
+		},
+	})
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if gotFree != 7200 {
+		t.Fatalf("expected configured free allowance passed to the store, got %d", gotFree)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body["plan_tier"] != "free" || body["cycle_start"] != "2026-02-15T09:30:00Z" || body["included_seconds"] != float64(7200) || body["consumed_seconds"] != float64(0) {
+		t.Fatalf("unexpected usage body: %v", body)
+	}
+	if alerts, ok := body["usage_alerts"].([]any); !ok || len(alerts) != 0 {
+		t.Fatalf("expected an empty usage_alerts array, got %v", body["usage_alerts"])
+	}
+}
+
+func usageAt(consumed int) func(context.Context, string, int) (*model.UsageCurrent, error) {
+	return func(context.Context, string, int) (*model.UsageCurrent, error) {
+		return &model.UsageCurrent{
+			PlanTier:         "starter",
+			IncludedSeconds:  3600,
+			ConsumedSeconds:  consumed,
+			RemainingSeconds: max(3600-consumed, 0),
+			OverageSeconds:   max(consumed-3600, 0),
+		}, nil
+	}
+}
+
+func TestUsageCurrent_ReportsCrossedThresholds(t *testing.T) {
+	rr := getUsage(t, &mockStore{getUsageCurrentFn: usageAt(3700)})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		UsageAlerts []usageAlert `json:"usage_alerts"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := []usageAlert{{ThresholdPercent: 80, Level: "warning"}, {ThresholdPercent: 100, Level: "exhausted"}}
+	if !reflect.DeepEqual(body.UsageAlerts, want) {
+		t.Fatalf("expected %v, got %v", want, body.UsageAlerts)
+	}
+}
+
+func TestRelayActive_ReportsUsageWarning(t *testing.T) {
+	ms := &mockStore{
+		getActiveSessionFn: func(context.Context, string) (*model.Session, error) {
+			return &model.Session{ID: "ses_1", UserID: "usr_1", Status: model.SessionActive, Region: "us-east-1"}, nil
+		},
+		getUsageCurrentFn: usageAt(3000),
+	}
+	cfg := testConfig()
+	cfg.UsageAlertThresholds = []int{80, 100}
+	router := NewRouter(cfg, ms, &mockProvisioner{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/relay/active", nil)
+	req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Session map[string]any `json:"session"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := map[string]any{"threshold_percent": float64(80), "level": "warning", "remaining_seconds": float64(600)}
+	if !reflect.DeepEqual(body.Session["usage_warning"], want) {
+		t.Fatalf("expected usage_warning %v, got %v", want, body.Session["usage_warning"])
+	}
+}
+
+func TestUsageCurrent_MissingUserIs404(t *testing.T) {
+	rr := getUsage(t, &mockStore{})
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d body=%s", rr.Code, rr.Body.String())
+	}
+}
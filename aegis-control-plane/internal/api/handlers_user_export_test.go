@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+func exportUserData(t *testing.T, ms *mockStore, path, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestUserExport_StreamsSessionsUsageAndEvents(t *testing.T) {
+	generatedAt := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	var gotUser, gotBy string
+	var gotEvery time.Duration
+	ms := &mockStore{
+		recordExportFn: func(_ context.Context, userID, requestedBy string, every time.Duration) (time.Time, error) {
+			gotUser, gotBy, gotEvery = userID, requestedBy, every
+			return generatedAt, nil
+		},
+		userSessions: []model.Session{{ID: "ses_1", Status: model.SessionStopped, Region: "us-east-1", StartedAt: generatedAt.Add(-time.Hour), DurationSeconds: 3600}},
+		userUsage:    exportRows()[:1],
+		userEvents:   []model.SessionEvent{{ID: 7, SessionID: "ses_1", Type: "credentials_fetched", Payload: json.RawMessage(`{"session_id":"ses_1"}`), CreatedAt: generatedAt}},
+	}
+	rr := exportUserData(t, ms, "/api/v1/me/export", testJWT(t, "test-secret", "usr_1"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if gotUser != "usr_1" || gotBy != "usr_1" || gotEvery != time.Hour {
+		t.Fatalf("expected an hourly-limited export by the user, got %q by %q every %s", gotUser, gotBy, gotEvery)
+	}
+	var doc struct {
+		UserID        string              `json:"user_id"`
+		GeneratedAt   string              `json:"generated_at"`
+		Sessions      []userExportSession `json:"sessions"`
+		UsageRecords  []usageExportJSON   `json:"usage_records"`
+		SessionEvents []userExportEvent   `json:"session_events"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("expected one JSON document, got %v: %s", err, rr.Body.String())
+	}
+	if doc.UserID != "usr_1" || doc.GeneratedAt != "2026-03-04T12:00:00Z" {
+		t.Fatalf("unexpected header fields %+v", doc)
+	}
+	if len(doc.Sessions) != 1 || doc.Sessions[0].DurationSeconds != 3600 || doc.Sessions[0].StoppedAt != nil {
+		t.Fatalf("unexpected sessions %+v", doc.Sessions)
+	}
+	if len(doc.UsageRecords) != 1 || doc.UsageRecords[0].BillableSeconds != 3600 {
+		t.Fatalf("unexpected usage records %+v", doc.UsageRecords)
+	}
+	if len(doc.SessionEvents) != 1 || doc.SessionEvents[0].EventID != 7 || string(doc.SessionEvents[0].Payload) != `{"session_id":"ses_1"}` {
+		t.Fatalf("unexpected session events %+v", doc.SessionEvents)
+	}
+}
+
+func TestUserExport_LimitedToOnePerHour(t *testing.T) {
+	ms := &mockStore{
+		recordExportFn: func(context.Context, string, string, time.Duration) (time.Time, error) {
+			return time.Time{}, &store.ExportLimitedError{RetryAfter: 20*time.Minute + 500*time.Millisecond}
+		},
+	}
+	rr := exportUserData(t, ms, "/api/v1/me/export", testJWT(t, "test-secret", "usr_1"))
+	assertAPIError(t, rr, apierr.RateLimited)
+	if got := rr.Header().Get("Retry-After"); got != "1201" {
+		t.Fatalf("expected Retry-After 1201, got %q", got)
+	}
+}
+
+func TestAdminUserExport_IsNotLimited(t *testing.T) {
+	var gotBy string
+	var gotEvery time.Duration
+	ms := &mockStore{
+		recordExportFn: func(_ context.Context, userID, requestedBy string, every time.Duration) (time.Time, error) {
+			if userID != "usr_1" {
+				return time.Time{}, store.ErrNotFound
+			}
+			gotBy, gotEvery = requestedBy, every
+			return time.Now(), nil
+		},
+	}
+	token := testAdminJWT(t, "test-secret", "usr_admin")
+	if rr := exportUserData(t, ms, "/api/v1/admin/users/usr_1/export", token); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if gotBy != "usr_admin" || gotEvery != 0 {
+		t.Fatalf("expected an unlimited export by the admin, got %q every %s", gotBy, gotEvery)
+	}
+	assertAPIError(t, exportUserData(t, ms, "/api/v1/admin/users/usr_2/export", token), apierr.NotFound)
+}
+
+func TestUserExport_AbortsOnReadError(t *testing.T) {
+	ms := &mockStore{userEventsErr: errors.New("connection reset")}
+	defer func() {
+		if got := recover(); got != http.ErrAbortHandler {
+			t.Fatalf("expected the handler to abort the response, got %v", got)
+		}
+	}()
+	exportUserData(t, ms, "/api/v1/me/export", testJWT(t, "test-secret", "usr_1"))
+}
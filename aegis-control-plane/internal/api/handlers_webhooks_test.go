@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+func doWebhookRequest(t *testing.T, router http.Handler, method, path, token string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var req *http.Request
+	if body != nil {
+		req = httptest.NewRequest(method, path, jsonBody(body))
+	} else {
+		req = httptest.NewRequest(method, path, nil)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestWebhooks_CRUDIsScopedToCaller(t *testing.T) {
+	ms := &mockStore{}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+	owner, other := testJWT(t, "test-secret", "usr_1"), testJWT(t, "test-secret", "usr_2")
+
+	rr := doWebhookRequest(t, router, http.MethodPost, "/api/v1/webhooks", owner, map[string]any{
+		"url":    "https://hooks.example.com/aegis",
+		"events": []string{model.WebhookSessionStarted, model.WebhookSessionStopped},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		Webhook struct {
+			ID     string   `json:"webhook_id"`
+			Secret string   `json:"secret"`
+			Events []string `json:"events"`
+		} `json:"webhook"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !strings.HasPrefix(created.Webhook.Secret, "whsec_") || len(created.Webhook.Events) != 2 {
+		t.Fatalf("expected a generated secret and two events, got %+v", created.Webhook)
+	}
+	path := "/api/v1/webhooks/" + created.Webhook.ID
+	if got := rr.Header().Get("Location"); got != path {
+		t.Fatalf("expected the webhook's path as Location, got %q", got)
+	}
+
+	if rr := doWebhookRequest(t, router, http.MethodGet, path, owner, nil); rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "secret") {
+		t.Fatalf("expected the webhook without its secret, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if rr := doWebhookRequest(t, router, http.MethodGet, path, other, nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected another user's webhook to be 404, got %d", rr.Code)
+	}
+	rr = doWebhookRequest(t, router, http.MethodPut, path, owner, map[string]any{"url": "https://hooks.example.com/v2"})
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "/v2") {
+		t.Fatalf("expected update, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := ms.webhooks[created.Webhook.ID].Secret; got != created.Webhook.Secret {
+		t.Fatalf("expected the secret kept when none is given, got %q", got)
+	}
+	if rr := doWebhookRequest(t, router, http.MethodDelete, path, other, nil); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected another user's delete to be 404, got %d", rr.Code)
+	}
+	if rr := doWebhookRequest(t, router, http.MethodDelete, path, owner, nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if len(ms.webhooks) != 0 {
+		t.Fatalf("expected the webhook deleted, got %v", ms.webhooks)
+	}
+}
+
+func TestWebhooks_Location(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		baseURL   string
+		trusted   bool
+		forwarded string
+		want      string
+	}{
+		{"configured base wins", "https://aegis.example.com/cp", true, "http", "https://aegis.example.com/cp/api/v1/webhooks/"},
+		{"untrusted host and proxy header", "", false, "https", "/api/v1/webhooks/"},
+		{"trusted proxy header", "", true, "https, http", "https://api.example.com/api/v1/webhooks/"},
+		{"trusted without header", "", true, "", "http://api.example.com/api/v1/webhooks/"},
+		{"trusted with a bogus header", "", true, "gopher", "http://api.example.com/api/v1/webhooks/"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.ExternalBaseURL = tc.baseURL
+			cfg.TrustForwardedProto = tc.trusted
+			router := NewRouter(cfg, &mockStore{}, &mockProvisioner{})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/webhooks", jsonBody(map[string]any{"url": "https://hooks.example.com/aegis"}))
+			req.Host = "api.example.com"
+			req.Header.Set("Authorization", "Bearer "+testJWT(t, "test-secret", "usr_1"))
+			if tc.forwarded != "" {
+				req.Header.Set("X-Forwarded-Proto", tc.forwarded)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("expected 201, got %d body=%s", rr.Code, rr.Body.String())
+			}
+			if got := rr.Header().Get("Location"); !strings.HasPrefix(got, tc.want) || len(got) == len(tc.want) {
+				t.Fatalf("expected Location under %s, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestWebhooks_RejectsInvalidRequests(t *testing.T) {
+	router := NewRouter(testConfig(), &mockStore{}, &mockProvisioner{})
+	token := testJWT(t, "test-secret", "usr_1")
+	for name, body := range map[string]map[string]any{
+		"relative url":  {"url": "/hooks"},
+		"ftp url":       {"url": "ftp://hooks.example.com"},
+		"unknown event": {"url": "https://hooks.example.com", "events": []string{"session_exploded"}},
+		"short secret":  {"url": "https://hooks.example.com", "secret": "short"},
+	} {
+		if rr := doWebhookRequest(t, router, http.MethodPost, "/api/v1/webhooks", token, body); rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d body=%s", name, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestAdminWebhooks_ManagesGlobalWebhooksAndReplays(t *testing.T) {
+	var replayed []int64
+	ms := &mockStore{
+		listDeliveriesFn: func(_ context.Context, status string, _ int) ([]model.WebhookDelivery, error) {
+			if status != model.WebhookDeliveryDead {
+				t.Fatalf("expected dead deliveries listed by default, got %q", status)
+			}
+			return []model.WebhookDelivery{{ID: 7, WebhookID: "whk_1", EventType: model.WebhookSessionStopped, Status: status, Attempts: 8, LastStatusCode: 503, Payload: json.RawMessage(`{}`), CreatedAt: time.Now()}}, nil
+		},
+		replayDeliveryFn: func(_ context.Context, id int64) error {
+			replayed = append(replayed, id)
+			return nil
+		},
+	}
+	router := NewRouter(testConfig(), ms, &mockProvisioner{})
+	admin := testAdminJWT(t, "test-secret", "usr_admin")
+
+	if rr := doWebhookRequest(t, router, http.MethodPost, "/api/v1/admin/webhooks", admin, map[string]any{"url": "https://billing.example.com/aegis"}); rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	for _, wh := range ms.webhooks {
+		if wh.UserID != "" {
+			t.Fatalf("expected a global webhook, got user %q", wh.UserID)
+		}
+	}
+	if rr := doWebhookRequest(t, router, http.MethodPost, "/api/v1/admin/webhooks", testJWT(t, "test-secret", "usr_1"), map[string]any{"url": "https://x.example.com"}); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected non-admins to be refused, got %d", rr.Code)
+	}
+
+	rr := doWebhookRequest(t, router, http.MethodGet, "/api/v1/admin/webhooks/deliveries", admin, nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"delivery_id":7`) {
+		t.Fatalf("expected the dead delivery listed, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if rr := doWebhookRequest(t, router, http.MethodPost, "/api/v1/admin/webhooks/deliveries/7/replay", admin, nil); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if len(replayed) != 1 || replayed[0] != 7 {
+		t.Fatalf("expected delivery 7 replayed, got %v", replayed)
+	}
+}
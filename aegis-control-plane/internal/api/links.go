@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// externalURL returns the URL of path on the control plane. It is built on
+// AEGIS_EXTERNAL_BASE_URL when that is set. Otherwise, with
+// AEGIS_TRUST_FORWARDED_PROTO, it is built on the request's Host as the
+// trusted proxy passed it: over https when the request came over TLS or the
+// proxy's X-Forwarded-Proto says so. Without either, a client could point
+// links at any host through its Host header, so path is returned as is.
+func (s *Server) externalURL(r *http.Request, path string) string {
+	cfg := s.config()
+	if cfg.ExternalBaseURL != "" {
+		return cfg.ExternalBaseURL + path
+	}
+	if !cfg.TrustForwardedProto {
+		return path
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	// A chain of proxies lists protocols nearest the client first.
+	proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+	if p := strings.ToLower(strings.TrimSpace(proto)); p == "http" || p == "https" {
+		scheme = p
+	}
+	return scheme + "://" + r.Host + path
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/api/openapi"
+)
+
+// openAPITypes are the structs handlers decode requests into or encode
+// responses from, by OpenAPI component name; their schemas are reflected.
+var openAPITypes = map[string]any{
+	"RelayStartRequest":           relayStartRequest{},
+	"RelayStopRequest":            relayStopRequest{},
+	"RelayHealthRequest":          relayHealthRequest{},
+	"RelayInterruptionRequest":    relayInterruptionRequest{},
+	"ManifestRegionRequest":       manifestRegionRequest{},
+	"ManifestRegionSwitchRequest": manifestRegionSwitchRequest{},
+	"WebhookRequest":              webhookRequest{},
+	"ManifestRegion":              manifestRegion{},
+	"StartDryRun":                 dryRunResponse{},
+	"SessionChange":               sessionChange{},
+	"UsageAlert":                  usageAlert{},
+	"UsageExportRecord":           usageExportJSON{},
+	"Error":                       apiError{},
+	"ErrorCatalogueEntry":         apierr.Entry{},
+}
+
+var openAPIDocument = sync.OnceValue(func() *openapi.Document {
+	return openapi.Build(openAPITypes)
+})
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, openAPIDocument())
+}
+
+// handleErrorCatalogue lists every error code responses can carry.
+func (s *Server) handleErrorCatalogue(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"errors": apierr.Catalogue()})
+}
@@ -0,0 +1,44 @@
+package openapi
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSchemaOf_FollowsJSONEncoding(t *testing.T) {
+	type base struct {
+		ID string `json:"id"`
+	}
+	type sample struct {
+		base
+		Name     string    `json:"name"`
+		Count    int64     `json:"count,omitempty"`
+		Enabled  *bool     `json:"enabled"`
+		Tags     []string  `json:"tags"`
+		Seen     time.Time `json:"seen"`
+		Skipped  string    `json:"-"`
+		internal string
+		Nested   struct {
+			Mode string `json:"mode"`
+		} `json:"nested"`
+	}
+	s := SchemaOf(sample{internal: ""})
+	if s.Type != "object" || len(s.Properties) != 7 {
+		t.Fatalf("unexpected properties %v", s.Properties)
+	}
+	for name, want := range map[string]string{"id": "string", "name": "string", "count": "integer", "enabled": "boolean", "tags": "array", "seen": "string", "nested": "object"} {
+		if got := s.Properties[name]; got == nil || got.Type != want {
+			t.Errorf("%s: expected %s, got %+v", name, want, got)
+		}
+	}
+	if !s.Properties["enabled"].Nullable || s.Properties["seen"].Format != "date-time" || s.Properties["count"].Format != "int64" {
+		t.Fatalf("unexpected field schemas %+v %+v %+v", s.Properties["enabled"], s.Properties["seen"], s.Properties["count"])
+	}
+	if s.Properties["nested"].Properties["mode"] == nil || s.Properties["tags"].Items.Type != "string" {
+		t.Fatal("expected nested and array element schemas")
+	}
+	if slices.Contains(s.Required, "count") || !slices.Contains(s.Required, "name") {
+		t.Fatalf("expected omitempty fields optional, got required %v", s.Required)
+	}
+}
@@ -0,0 +1,844 @@
+package openapi
+
+import (
+	"maps"
+	"net/http"
+	"strings"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/jobs"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+// Version is the API version the document describes.
+const Version = "v1"
+
+// requestRequired lists, per reflected request body, the fields handlers
+// reject requests without. Decoding itself requires none.
+var requestRequired = map[string][]string{
+	"RelayStartRequest":           nil,
+	"RelayStopRequest":            {"session_id"},
+	"RelayHealthRequest":          {"session_id"},
+	"RelayInterruptionRequest":    {"session_id", "instance_id"},
+	"ManifestRegionRequest":       nil,
+	"ManifestRegionSwitchRequest": {"enabled"},
+	"WebhookRequest":              {"url"},
+}
+
+var (
+	bearerAuth   = []map[string][]string{{"bearerAuth": {}}}
+	relayAuth    = []map[string][]string{{"relayAuth": {}}}
+	internalAuth = []map[string][]string{{"internalAuth": {}}}
+	noAuth       = []map[string][]string{}
+)
+
+var tagParam = Parameter{Name: "tag", In: "query", Schema: str(""), Description: "Only sessions carrying this tag, matched case-insensitively"}
+
+var sessionStatuses = []string{
+	string(model.SessionProvisioning), string(model.SessionActive), string(model.SessionGrace),
+	string(model.SessionStopping), string(model.SessionStopped),
+}
+
+// Build returns the document. types maps component names to the Go values
+// whose types the handlers decode and encode; their schemas are derived with
+// SchemaOf. The remaining responses are built as maps by the handlers and
+// are declared here.
+func Build(types map[string]any) *Document {
+	schemas := make(map[string]*Schema, len(types))
+	for name, v := range types {
+		s := SchemaOf(v)
+		if required, ok := requestRequired[name]; ok {
+			s.Required = required
+		}
+		schemas[name] = s
+	}
+	maps.Copy(schemas, responseSchemas())
+	if e := schemas["Error"]; e != nil {
+		var codes []string
+		for _, entry := range apierr.Catalogue() {
+			codes = append(codes, string(entry.Code))
+		}
+		e.Properties["error"].Properties["code"].Enum = codes
+	}
+	if stop := schemas["RelayStopRequest"]; stop != nil {
+		stop.Properties["reason"].Enum = model.ClientStopReasons
+		stop.Properties["reason"].Description = "Why the client stopped the session; defaults to user_requested"
+		stop.Properties["detail"].Description = "Free text about the stop, at most 200 characters"
+	}
+
+	d := &Document{
+		OpenAPI: "3.0.3",
+		Info:    Info{Title: "Aegis Control Plane API", Version: Version},
+		Paths:   map[string]map[string]*Operation{},
+		Components: Components{
+			Schemas: schemas,
+			SecuritySchemes: map[string]SecurityScheme{
+				"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+				"relayAuth":  {Type: "apiKey", In: "header", Name: "X-Relay-Auth"},
+				// AEGIS_INTERNAL_TOKEN, sent as a bearer token.
+				"internalAuth": {Type: "http", Scheme: "bearer"},
+			},
+		},
+	}
+	d.systemOps()
+	d.clientOps()
+	d.relayAgentOps()
+	d.adminOps()
+	d.internalOps()
+	d.webhookOps("/api/v1/webhooks", "webhooks", "")
+	d.webhookOps("/api/v1/admin/webhooks", "admin", "Global")
+	return d
+}
+
+func (d *Document) add(method, path string, op *Operation) {
+	if d.Paths[path] == nil {
+		d.Paths[path] = map[string]*Operation{}
+	}
+	d.Paths[path][strings.ToLower(method)] = op
+}
+
+func (d *Document) systemOps() {
+	d.add(http.MethodGet, "/healthz", &Operation{
+		OperationID: "getHealthz", Summary: "Liveness check", Tags: []string{"system"}, Security: noAuth,
+		Responses: map[string]Response{"200": jsonResponse("The API is up", object(map[string]*Schema{
+			"status":     enum("ok"),
+			"version":    str("Release the binary was built from, or dev"),
+			"commit":     str("Git commit the binary was built from, or dev"),
+			"build_date": str("UTC build time, or dev"),
+			"draining":   {Type: "boolean", Description: "The API is about to shut down and refuses new starts"},
+		}, "status", "version", "commit", "build_date", "draining"))},
+	})
+	d.add(http.MethodGet, "/readyz", &Operation{
+		OperationID: "getReadyz", Summary: "Readiness check for load balancers", Tags: []string{"system"}, Security: noAuth,
+		Responses: map[string]Response{
+			"200": jsonResponse("The API takes traffic", object(map[string]*Schema{"status": enum("ready")}, "status")),
+			"503": jsonResponse("The API is draining before shutdown", object(map[string]*Schema{"status": enum("draining")}, "status")),
+		},
+	})
+	d.add(http.MethodGet, "/metrics", &Operation{
+		OperationID: "getMetrics", Summary: "Prometheus metrics", Tags: []string{"system"}, Security: noAuth,
+		Responses: map[string]Response{"200": {Description: "Metrics in the Prometheus text format", Content: map[string]MediaType{"text/plain": {Schema: str("")}}}},
+	})
+	d.add(http.MethodGet, "/api/v1/openapi.json", &Operation{
+		OperationID: "getOpenAPI", Summary: "This document", Tags: []string{"system"}, Security: noAuth,
+		Responses: map[string]Response{"200": jsonResponse("The OpenAPI document", &Schema{Type: "object"})},
+	})
+	d.add(http.MethodGet, "/api/v1/errors", &Operation{
+		OperationID: "getErrorCatalogue", Summary: "Every error code responses can carry, with its status", Tags: []string{"system"}, Security: noAuth,
+		Responses: map[string]Response{"200": jsonResponse("The error catalogue", object(map[string]*Schema{"errors": arrayOf(ref("ErrorCatalogueEntry"))}, "errors"))},
+	})
+}
+
+func (d *Document) clientOps() {
+	tags := []string{"relay"}
+	d.add(http.MethodPost, "/api/v1/relay/start", &Operation{
+		OperationID: "startRelay", Summary: "Start a relay session, or return the live one", Tags: tags, Security: bearerAuth,
+		Parameters:  []Parameter{startIdempotencyKey(), dryRunParam()},
+		RequestBody: jsonBody(ref("RelayStartRequest")),
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The existing live session, or with dry_run what the start would do", startOrDryRun()),
+			"201": jsonResponse("A new session with its relay", ref("SessionEnvelope")),
+			"202": jsonResponse("A replayed start whose session is still provisioning", ref("SessionEnvelope")),
+		}, "400", "401", "403", "409", "500", "502", "503", "504"),
+	})
+	d.add(http.MethodGet, "/api/v1/relay/active", &Operation{
+		OperationID: "getActiveRelay", Summary: "The caller's live session", Tags: tags, Security: bearerAuth,
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The live session; credentials are masked when AEGIS_MASK_SESSION_CREDENTIALS is on", ref("SessionEnvelope")),
+			"204": {Description: "No live session"},
+		}, "401", "500", "504"),
+	})
+	d.add(http.MethodPost, "/api/v1/relay/stop", &Operation{
+		OperationID: "stopRelay", Summary: "Stop a session", Tags: tags, Security: bearerAuth,
+		RequestBody: jsonBody(ref("RelayStopRequest")),
+		Responses:   withErrors(stopResponses(), "400", "401", "404", "422", "500", "504"),
+	})
+	d.add(http.MethodPost, "/api/v1/relay/authorize-ip", &Operation{
+		OperationID: "authorizeRelayIP", Summary: "Move the relay's IP lock to the caller's address", Tags: tags, Security: bearerAuth,
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The address now allowed", object(map[string]*Schema{"session_id": str(""), "client_ip": str("")}, "session_id", "client_ip")),
+		}, "400", "401", "404", "409", "500", "504"),
+	})
+	d.add(http.MethodGet, "/api/v1/relay/manifest", &Operation{
+		OperationID: "getRelayManifest", Summary: "Regions a relay can start in", Tags: tags, Security: bearerAuth,
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The manifest", object(map[string]*Schema{"regions": arrayOf(ref("ManifestRegion"))}, "regions")),
+		}, "401", "500", "503", "504"),
+	})
+	d.add(http.MethodGet, "/api/v1/relay/events", &Operation{
+		OperationID: "streamRelayEvents", Summary: "Stream the caller's session events", Tags: tags, Security: bearerAuth,
+		Parameters: []Parameter{{
+			Name: "Last-Event-ID", In: "header", Schema: &Schema{Type: "integer", Format: "int64"},
+			Description: "Resume after this event; without it only new events are sent",
+		}},
+		Responses: withErrors(map[string]Response{
+			"200": {Description: "Server-sent events, one per session event, with keepalive comments", Content: map[string]MediaType{"text/event-stream": {Schema: str("")}}},
+		}, "400", "401", "500", "504"),
+	})
+	d.add(http.MethodGet, "/api/v1/sessions", &Operation{
+		OperationID: "listSessions", Summary: "The caller's live sessions, newest first; plans may allow more than one", Tags: []string{"sessions"}, Security: bearerAuth,
+		Parameters: []Parameter{{
+			Name: "status", In: "query", Schema: enum(string(model.SessionProvisioning), string(model.SessionActive), string(model.SessionGrace), string(model.SessionStopping)),
+			Description: "Only sessions in this state; every live session when omitted",
+		}, tagParam},
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The sessions; credentials are masked when AEGIS_MASK_SESSION_CREDENTIALS is on", object(map[string]*Schema{"sessions": arrayOf(ref("Session"))}, "sessions")),
+		}, "400", "401", "500", "504"),
+	})
+	d.add(http.MethodPost, "/api/v1/sessions/{id}/credentials", &Operation{
+		OperationID: "getSessionCredentials", Summary: "A session's unmasked credentials; each fetch is audited", Tags: []string{"sessions"}, Security: bearerAuth,
+		Parameters: []Parameter{pathParam("id", "Session ID")},
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The credentials", object(map[string]*Schema{"session_id": str(""), "credentials": ref("Credentials")}, "session_id", "credentials")),
+		}, "401", "404", "409", "500", "504"),
+	})
+	d.add(http.MethodGet, "/api/v1/usage/current", &Operation{
+		OperationID: "getUsageCurrent", Summary: "The caller's usage in the current cycle", Tags: []string{"usage"}, Security: bearerAuth,
+		Responses: withErrors(map[string]Response{"200": jsonResponse("Current usage", ref("Usage"))}, "401", "404", "500", "504"),
+	})
+	d.add(http.MethodGet, "/api/v1/me/export", &Operation{
+		OperationID: "exportMyData", Summary: "Stream the caller's sessions, usage records and session events; once an hour", Tags: []string{"usage"}, Security: bearerAuth,
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The export, as an attachment", ref("UserDataExport")),
+		}, "401", "404", "429", "500", "504"),
+	})
+}
+
+func (d *Document) relayAgentOps() {
+	tags := []string{"relay-agent"}
+	d.add(http.MethodPost, "/api/v1/relay/health", &Operation{
+		OperationID: "reportRelayHealth", Summary: "Relay heartbeat", Tags: tags, Security: relayAuth,
+		RequestBody: jsonBody(ref("RelayHealthRequest")),
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("Recorded", object(map[string]*Schema{
+				"ok":                        {Type: "boolean"},
+				"remaining_allowed_seconds": {Type: "integer", Description: "Seconds the relay may keep forwarding: the lower of the plan's remaining time and the session's remaining maximum duration, 0 once the session is stopping, -1 when neither limits it. Absent when it could not be computed"},
+				"upgrade_required":          {Type: "boolean", Description: "Present and true when the agent is older than the configured minimum version"},
+				"min_agent_version":         {Type: "string", Description: "The minimum agent version; present with upgrade_required"},
+			}, "ok")),
+		}, "400", "401", "422", "500", "504"),
+	})
+	d.add(http.MethodPost, "/api/v1/relay/interruption", &Operation{
+		OperationID: "reportRelayInterruption", Summary: "Spot interruption notice; moves the session into grace", Tags: tags, Security: relayAuth,
+		RequestBody: jsonBody(ref("RelayInterruptionRequest")),
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The session's new status", object(map[string]*Schema{"session_id": str(""), "status": enum(sessionStatuses...)}, "session_id", "status")),
+		}, "400", "401", "404", "500", "504"),
+	})
+	d.add(http.MethodGet, "/api/v1/relay/session", &Operation{
+		OperationID: "getRelaySession", Summary: "The settings of the session a relay serves, for relays that restarted", Tags: tags, Security: relayAuth,
+		Parameters: []Parameter{
+			{Name: "session_id", In: "query", Required: true, Schema: str("")},
+			{Name: "instance_id", In: "query", Required: true, Schema: str(""), Description: "The relay's instance ID; must be the session's current relay"},
+		},
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The session", ref("RelaySession")),
+		}, "400", "401", "404", "500", "504"),
+	})
+	d.add(http.MethodGet, "/api/v1/relay/ping", &Operation{
+		OperationID: "pingRelay", Summary: "Reachability, server time and agent settings for relay agents; rate limited per IP", Tags: tags, Security: relayAuth,
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("Server time and agent settings", object(map[string]*Schema{
+				"server_time":                {Type: "string", Format: "date-time", Description: "RFC 3339 with milliseconds, for measuring clock skew"},
+				"min_agent_version":          nullable(str("Oldest supported relay agent release; null when none is enforced")),
+				"heartbeat_interval_seconds": {Type: "integer", Description: "How often the agent should send POST /relay/health"},
+			}, "server_time", "min_agent_version", "heartbeat_interval_seconds")),
+		}, "401", "429", "504"),
+	})
+}
+
+func (d *Document) internalOps() {
+	d.add(http.MethodGet, "/api/v1/internal/changes", &Operation{
+		OperationID: "listSessionChanges", Summary: "Session status changefeed for internal consumers, with long polling", Tags: []string{"internal"}, Security: internalAuth,
+		Parameters: []Parameter{
+			{Name: "after_seq", In: "query", Schema: &Schema{Type: "integer"}, Description: "The seq of the last change read (next_seq of the previous read); default 0 reads from the start"},
+			{Name: "limit", In: "query", Schema: &Schema{Type: "integer"}, Description: "1 to 1000, default 100"},
+			{Name: "wait", In: "query", Schema: &Schema{Type: "integer"}, Description: "Seconds, up to 30, to wait for a change when there is none yet; default 0"},
+			{Name: "consumer", In: "query", Schema: str(""), Description: "The reader's name; its after_seq acknowledges the changes up to it for the retention job"},
+		},
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The changes, in commit order", object(map[string]*Schema{
+				"changes":  arrayOf(ref("SessionChange")),
+				"next_seq": {Type: "integer", Description: "The after_seq of the next read: the last change's seq, or after_seq when there were none"},
+			}, "changes", "next_seq")),
+		}, "400", "401", "404", "500", "504"),
+	})
+}
+
+func (d *Document) adminOps() {
+	tags := []string{"admin"}
+	limit := func(def string) Parameter {
+		return Parameter{Name: "limit", In: "query", Description: "1 to 500, default " + def, Schema: &Schema{Type: "integer"}}
+	}
+	integer := &Schema{Type: "integer"}
+	d.add(http.MethodGet, "/api/v1/admin/overview", &Operation{
+		OperationID: "adminGetOverview", Summary: "Dashboard summary of sessions, provisioning, relays, estimated costs and the manifest, cached for 10 seconds", Tags: tags, Security: bearerAuth,
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The summary; sections that could not be read are null and named in warnings", object(map[string]*Schema{
+				"generated_at": dateTime(),
+				"sessions":     nullable(&Schema{Type: "object", Description: "provisioning, active and grace session counts, keyed by region"}),
+				"provisioning": nullable(object(map[string]*Schema{
+					"window_seconds": integer,
+					"attempts":       integer,
+					"succeeded":      integer,
+					"success_rate":   nullable(&Schema{Type: "number", Description: "Null without attempts"}),
+					"p95_latency_ms": {Type: "integer", Format: "int64"},
+				}, "window_seconds", "attempts", "succeeded", "success_rate", "p95_latency_ms")),
+				"stale_relays":         nullable(&Schema{Type: "integer"}),
+				"pending_terminations": nullable(&Schema{Type: "integer"}),
+				"terminations": nullable(object(map[string]*Schema{
+					"window_seconds":   integer,
+					"instance_seconds": {Type: "object", Description: "Run time of the relays terminated in the window, keyed by termination reason"},
+				}, "window_seconds", "instance_seconds")),
+				"cost": nullable(object(map[string]*Schema{
+					"live_sessions":           integer,
+					"burn_usd_per_hour":       {Type: "number", Description: "Hourly price of the relays of live sessions"},
+					"live_estimated_cost_usd": {Type: "number", Description: "Estimated cost of live sessions so far"},
+					"top_users": arrayOf(object(map[string]*Schema{
+						"user_id":            str(""),
+						"cycle_start_at":     dateTime(),
+						"cycle_end_at":       dateTime(),
+						"billable_seconds":   integer,
+						"estimated_cost_usd": {Type: "number"},
+					}, "user_id", "cycle_start_at", "cycle_end_at", "billable_seconds", "estimated_cost_usd")),
+				}, "live_sessions", "burn_usd_per_hour", "live_estimated_cost_usd", "top_users")),
+				"manifest": nullable(object(map[string]*Schema{
+					"regions":           integer,
+					"available_regions": integer,
+					"oldest_updated_at": nullable(dateTime()),
+					"age_seconds":       nullable(&Schema{Type: "integer"}),
+				}, "regions", "available_regions", "oldest_updated_at", "age_seconds")),
+				"warnings": arrayOf(object(map[string]*Schema{"section": str(""), "message": str("")}, "section", "message")),
+			}, "generated_at", "sessions", "provisioning", "stale_relays", "pending_terminations", "terminations", "cost", "manifest", "warnings")),
+		}, "401", "403", "504"),
+	})
+	d.add(http.MethodGet, "/api/v1/admin/sessions", &Operation{
+		OperationID: "adminListSessions", Summary: "Recent sessions of all users", Tags: tags, Security: bearerAuth,
+		Parameters: []Parameter{{Name: "status", In: "query", Schema: enum(sessionStatuses...)}, tagParam, limit("50")},
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("Sessions, newest first", object(map[string]*Schema{"sessions": arrayOf(ref("AdminSession"))}, "sessions")),
+		}, "400", "401", "403", "500", "504"),
+	})
+	d.add(http.MethodGet, "/api/v1/admin/sessions/{id}/relay", &Operation{
+		OperationID: "adminGetSessionRelay", Summary: "A session's relay as recorded and as the provider reports it", Tags: tags, Security: bearerAuth,
+		Parameters: []Parameter{pathParam("id", "Session ID")},
+		Responses:  withErrors(map[string]Response{"200": jsonResponse("The relay views", ref("SessionRelay"))}, "401", "403", "404", "500", "504"),
+	})
+	d.add(http.MethodGet, "/api/v1/admin/sessions/{id}/health", &Operation{
+		OperationID: "adminListSessionHealth", Summary: "Latest heartbeats of a session's relays", Tags: tags, Security: bearerAuth,
+		Parameters: []Parameter{pathParam("id", "Session ID"), limit("20")},
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("Heartbeats, newest first", object(map[string]*Schema{"session_id": str(""), "health": arrayOf(ref("RelayHealthEvent"))}, "session_id", "health")),
+		}, "400", "401", "403", "500", "504"),
+	})
+	d.add(http.MethodPost, "/api/v1/admin/sessions/{id}/stop", &Operation{
+		OperationID: "adminStopSession", Summary: "Stop any user's session", Tags: tags, Security: bearerAuth,
+		Parameters: []Parameter{pathParam("id", "Session ID")},
+		Responses:  withErrors(stopResponses(), "401", "403", "404", "500", "504"),
+	})
+	d.add(http.MethodGet, "/api/v1/admin/users/{id}/usage", &Operation{
+		OperationID: "adminGetUserUsage", Summary: "A user's usage in the current cycle", Tags: tags, Security: bearerAuth,
+		Parameters: []Parameter{pathParam("id", "User ID")},
+		Responses:  withErrors(map[string]Response{"200": jsonResponse("Current usage", ref("Usage"))}, "401", "403", "404", "500", "504"),
+	})
+	d.add(http.MethodPost, "/api/v1/admin/users/{id}/sessions", &Operation{
+		OperationID: "adminStartSession", Summary: "Start a non-billable session as a user, for support reproduction", Tags: tags, Security: bearerAuth,
+		Parameters: []Parameter{
+			pathParam("id", "User ID"),
+			startIdempotencyKey(),
+			{Name: "force", In: "query", Description: "Start even though the user has a live session", Schema: &Schema{Type: "boolean"}},
+			dryRunParam(),
+		},
+		RequestBody: jsonBody(ref("RelayStartRequest")),
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The existing live session, with force at a plan limit of one, or with dry_run what the start would do", startOrDryRun()),
+			"201": jsonResponse("A new session with its relay", ref("SessionEnvelope")),
+			"202": jsonResponse("A replayed start whose session is still provisioning", ref("SessionEnvelope")),
+		}, "400", "401", "403", "404", "409", "500", "502", "503", "504"),
+	})
+	d.add(http.MethodPost, "/api/v1/admin/users/{id}/data/erasure-token", &Operation{
+		OperationID: "adminIssueErasureToken", Summary: "Issue the confirmation token that erasing a user's data requires", Tags: tags, Security: bearerAuth,
+		Parameters: []Parameter{pathParam("id", "User ID")},
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("A token valid for ten minutes, for this admin and user only", object(map[string]*Schema{
+				"user_id":            str(""),
+				"confirmation_token": str(""),
+				"expires_at":         dateTime(),
+			}, "user_id", "confirmation_token", "expires_at")),
+		}, "401", "403"),
+	})
+	d.add(http.MethodDelete, "/api/v1/admin/users/{id}/data", &Operation{
+		OperationID: "adminEraseUserData", Summary: "Stop a user's sessions and anonymize their data, keeping usage totals under a tombstone user", Tags: tags, Security: bearerAuth,
+		Parameters: []Parameter{
+			pathParam("id", "User ID"),
+			{Name: "X-Confirmation-Token", In: "header", Required: true, Description: "From POST /api/v1/admin/users/{id}/data/erasure-token", Schema: str("")},
+		},
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The erasure; repeating the request returns the first one with replayed true", object(map[string]*Schema{"erasure": ref("UserDataErasure")}, "erasure")),
+		}, "401", "403", "404", "409", "428", "500", "504"),
+	})
+	d.add(http.MethodGet, "/api/v1/admin/users/{id}/export", &Operation{
+		OperationID: "adminExportUserData", Summary: "Stream a user's data export, without the hourly limit", Tags: tags, Security: bearerAuth,
+		Parameters: []Parameter{pathParam("id", "User ID")},
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The export, as an attachment", ref("UserDataExport")),
+		}, "401", "403", "404", "500", "504"),
+	})
+	d.add(http.MethodPut, "/api/v1/admin/manifest/{region}", &Operation{
+		OperationID: "adminSetManifestRegion", Summary: "Override a region's manifest entry until the next restart or config reload", Tags: tags, Security: bearerAuth,
+		Parameters:  []Parameter{pathParam("region", "Region")},
+		RequestBody: jsonBody(ref("ManifestRegionRequest")),
+		Responses:   withErrors(map[string]Response{"200": jsonResponse("The updated entry", ref("ManifestRegion"))}, "400", "401", "403", "404", "422", "500", "504"),
+	})
+	d.add(http.MethodPatch, "/api/v1/admin/relay/manifest/{region}", &Operation{
+		OperationID: "adminSwitchManifestRegion", Summary: "Switch new sessions in a region on or off, leaving its manifest entry in place", Tags: tags, Security: bearerAuth,
+		Parameters:  []Parameter{pathParam("region", "Region")},
+		RequestBody: jsonBody(ref("ManifestRegionSwitchRequest")),
+		Responses:   withErrors(map[string]Response{"200": jsonResponse("The updated entry", ref("ManifestRegion"))}, "400", "401", "403", "404", "422", "500", "504"),
+	})
+	d.add(http.MethodPost, "/api/v1/admin/jobs/{name}/runs", &Operation{
+		OperationID: "adminRunJob", Summary: "Queue a run of a background job", Tags: tags, Security: bearerAuth,
+		Parameters: []Parameter{{Name: "name", In: "path", Required: true, Schema: enum(jobs.Names...)}},
+		Responses: withErrors(map[string]Response{
+			"202": jsonResponse("The queued run", object(map[string]*Schema{"run": ref("JobRun")}, "run")),
+		}, "401", "403", "404", "500", "504"),
+	})
+	d.add(http.MethodGet, "/api/v1/admin/jobs/runs/{id}", &Operation{
+		OperationID: "adminGetJobRun", Summary: "A job run's status", Tags: tags, Security: bearerAuth,
+		Parameters: []Parameter{{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "integer", Format: "int64"}}},
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The run", object(map[string]*Schema{"run": ref("JobRun")}, "run")),
+		}, "400", "401", "403", "404", "500", "504"),
+	})
+	d.add(http.MethodGet, "/api/v1/admin/usage/export", &Operation{
+		OperationID: "adminExportUsage", Summary: "Stream the usage records of cycles starting on a day or instant", Tags: tags, Security: bearerAuth,
+		Parameters: []Parameter{
+			{Name: "cycle_start", In: "query", Required: true, Description: "YYYY-MM-DD for every cycle starting that UTC day, or an RFC3339 timestamp", Schema: str("")},
+			{Name: "format", In: "query", Schema: enum("csv", "json")},
+		},
+		Responses: withErrors(map[string]Response{
+			"200": {Description: "The records, as an attachment", Content: map[string]MediaType{
+				"text/csv":         {Schema: str("")},
+				"application/json": {Schema: object(map[string]*Schema{"records": arrayOf(ref("UsageExportRecord"))}, "records")},
+			}},
+		}, "400", "401", "403", "500"),
+	})
+	d.add(http.MethodGet, "/api/v1/admin/webhooks/deliveries", &Operation{
+		OperationID: "adminListWebhookDeliveries", Summary: "Webhook deliveries by status, dead-lettered by default", Tags: tags, Security: bearerAuth,
+		Parameters: []Parameter{{Name: "status", In: "query", Schema: enum(model.WebhookDeliveryPending, model.WebhookDeliveryDelivered, model.WebhookDeliveryDead)}, limit("50")},
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The deliveries", object(map[string]*Schema{"deliveries": arrayOf(ref("WebhookDelivery"))}, "deliveries")),
+		}, "400", "401", "403", "500", "504"),
+	})
+	d.add(http.MethodPost, "/api/v1/admin/webhooks/deliveries/{id}/replay", &Operation{
+		OperationID: "adminReplayWebhookDelivery", Summary: "Queue a dead-lettered delivery again", Tags: tags, Security: bearerAuth,
+		Parameters: []Parameter{{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "integer", Format: "int64"}}},
+		Responses: withErrors(map[string]Response{
+			"202": jsonResponse("Requeued", object(map[string]*Schema{"delivery_id": {Type: "integer", Format: "int64"}, "status": enum(model.WebhookDeliveryPending)}, "delivery_id", "status")),
+		}, "400", "401", "403", "404", "500", "504"),
+	})
+	d.add(http.MethodGet, "/api/v1/admin/billing/exports", &Operation{
+		OperationID: "adminListBillingExports", Summary: "Stripe export status per user per cycle", Tags: tags, Security: bearerAuth,
+		Parameters: []Parameter{
+			{Name: "user_id", In: "query", Schema: str("")},
+			{Name: "status", In: "query", Schema: enum(model.BillingExportPending, model.BillingExportReported, model.BillingExportFailed)},
+			{Name: "cycle_start", In: "query", Schema: dateTime()},
+			limit("50"),
+		},
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The exports", object(map[string]*Schema{"exports": arrayOf(ref("BillingExport"))}, "exports")),
+		}, "400", "401", "403", "500", "504"),
+	})
+	d.add(http.MethodGet, "/api/v1/admin/relays", &Operation{
+		OperationID: "adminListRelays", Summary: "Relays, newest launch first, a page at a time", Tags: tags, Security: bearerAuth,
+		Parameters: []Parameter{
+			{Name: "region", In: "query", Schema: str("")},
+			{Name: "state", In: "query", Schema: enum(model.RelayProvisioning, model.RelayRunning, model.RelayTerminating, model.RelayTerminated, model.RelayError)},
+			{Name: "ami_id", In: "query", Schema: str("")},
+			{Name: "launched_from", In: "query", Description: "Relays launched at or after this instant", Schema: dateTime()},
+			{Name: "launched_to", In: "query", Description: "Relays launched before this instant", Schema: dateTime()},
+			{Name: "cursor", In: "query", Description: "next_cursor of the previous page", Schema: str("")},
+			limit("50"),
+		},
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("A page of relays", object(map[string]*Schema{
+				"relays":      arrayOf(ref("RelayInstance")),
+				"next_cursor": nullable(str("Set when another page follows")),
+			}, "relays", "next_cursor")),
+		}, "400", "401", "403", "500", "504"),
+	})
+	d.add(http.MethodGet, "/api/v1/admin/relays/quality", &Operation{
+		OperationID: "adminListRelayQuality", Summary: "Daily health gap counts per region, AMI and instance type, newest day first", Tags: tags, Security: bearerAuth,
+		Parameters: []Parameter{
+			{Name: "from", In: "query", Description: "First UTC day, YYYY-MM-DD; default 30 days ago", Schema: &Schema{Type: "string", Format: "date"}},
+			{Name: "to", In: "query", Description: "Last UTC day, YYYY-MM-DD", Schema: &Schema{Type: "string", Format: "date"}},
+			{Name: "region", In: "query", Schema: str("")},
+			{Name: "ami_id", In: "query", Schema: str("")},
+			limit("500"),
+		},
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The rolled-up days", object(map[string]*Schema{"days": arrayOf(ref("RelayQualityDay"))}, "days")),
+		}, "400", "401", "403", "500", "504"),
+	})
+	d.add(http.MethodPost, "/api/v1/admin/config/reload", &Operation{
+		OperationID: "adminReloadConfig", Summary: "Reload configuration from the environment; absent when the API runs without a reloader", Tags: tags, Security: bearerAuth,
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The reloaded settings", object(map[string]*Schema{
+				"reloaded":          {Type: "boolean"},
+				"default_region":    str(""),
+				"supported_regions": arrayOf(str("")),
+				"rejected_fields":   arrayOf(str("Settings that cannot change without a restart and were left as they were")),
+			}, "reloaded", "default_region", "supported_regions", "rejected_fields")),
+		}, "401", "403", "422"),
+	})
+}
+
+// webhookOps declares webhook CRUD under prefix, for the caller's webhooks
+// or, on admin routes, the global ones.
+func (d *Document) webhookOps(prefix, tag, idPrefix string) {
+	tags := []string{tag}
+	id := func(verb string) string { return verb + idPrefix }
+	d.add(http.MethodGet, prefix, &Operation{
+		OperationID: id("listWebhooks"), Summary: "List webhooks", Tags: tags, Security: bearerAuth,
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The webhooks", object(map[string]*Schema{"webhooks": arrayOf(ref("Webhook"))}, "webhooks")),
+		}, "401", "500", "504"),
+	})
+	d.add(http.MethodPost, prefix, &Operation{
+		OperationID: id("createWebhook"), Summary: "Create a webhook; the response is the only one carrying its secret", Tags: tags, Security: bearerAuth,
+		RequestBody: jsonBody(ref("WebhookRequest")),
+		Responses: withErrors(map[string]Response{
+			"201": jsonResponse("The webhook with its signing secret", object(map[string]*Schema{"webhook": ref("Webhook")}, "webhook")),
+		}, "400", "401", "409", "500", "504"),
+	})
+	params := []Parameter{pathParam("id", "Webhook ID")}
+	d.add(http.MethodGet, prefix+"/{id}", &Operation{
+		OperationID: id("getWebhook"), Summary: "Get a webhook", Tags: tags, Security: bearerAuth, Parameters: params,
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The webhook", object(map[string]*Schema{"webhook": ref("Webhook")}, "webhook")),
+		}, "401", "404", "500", "504"),
+	})
+	d.add(http.MethodPut, prefix+"/{id}", &Operation{
+		OperationID: id("updateWebhook"), Summary: "Replace a webhook's URL and events; the secret changes only when given", Tags: tags, Security: bearerAuth, Parameters: params,
+		RequestBody: jsonBody(ref("WebhookRequest")),
+		Responses: withErrors(map[string]Response{
+			"200": jsonResponse("The webhook", object(map[string]*Schema{"webhook": ref("Webhook")}, "webhook")),
+		}, "400", "401", "404", "500", "504"),
+	})
+	d.add(http.MethodDelete, prefix+"/{id}", &Operation{
+		OperationID: id("deleteWebhook"), Summary: "Delete a webhook", Tags: tags, Security: bearerAuth, Parameters: params,
+		Responses: withErrors(map[string]Response{"204": {Description: "Deleted"}}, "401", "404", "500", "504"),
+	})
+}
+
+func stopResponses() map[string]Response {
+	return map[string]Response{
+		"200": jsonResponse("Stopped, or already stopped", ref("StopResult")),
+		"202": jsonResponse("Stopping while the relay terminates", ref("StopResult")),
+	}
+}
+
+// responseSchemas declares the responses handlers build as maps.
+func responseSchemas() map[string]*Schema {
+	return map[string]*Schema{
+		"SessionEnvelope": object(map[string]*Schema{"session": ref("Session")}, "session"),
+		"Session": object(map[string]*Schema{
+			"session_id": str(""),
+			"status":     enum(sessionStatuses...),
+			"region":     str(""),
+			"provisioning_phase": &Schema{Type: "string", Enum: model.ProvisioningPhases,
+				Description: "How far the relay's start has got; phases only move forward. Absent on sessions started before phases were tracked"},
+			"tags": &Schema{Type: "array", Items: str(""), Description: "The session's labels from client_context.tags, lower-cased and sorted"},
+			"relay": object(map[string]*Schema{
+				"public_ip":             str(""),
+				"public_ipv6":           str(""),
+				"srt_port":              {Type: "integer", Description: "The first of srt_ports"},
+				"srt_ports":             arrayOf(&Schema{Type: "integer"}),
+				"ws_url":                str(""),
+				"last_health_at":        nullable(dateTime()),
+				"heartbeat_age_seconds": nullable(&Schema{Type: "integer", Description: "Seconds since last_health_at; null before the relay's first heartbeat"}),
+			}, "public_ip", "public_ipv6", "srt_port", "srt_ports", "ws_url", "last_health_at", "heartbeat_age_seconds"),
+			"credentials": ref("Credentials"),
+			"timers": object(map[string]*Schema{
+				"grace_window_seconds": {Type: "integer"},
+				"max_session_seconds":  {Type: "integer"},
+			}, "grace_window_seconds", "max_session_seconds"),
+			"duration_seconds": {Type: "integer"},
+			"started_at":       dateTime(),
+			"expires_at":       dateTime(),
+			"stopped_at":       dateTime(),
+			"grace_deadline":   dateTime(),
+			"usage_warning": object(map[string]*Schema{
+				"threshold_percent": {Type: "integer"},
+				"level":             enum("warning", "exhausted"),
+				"remaining_seconds": {Type: "integer"},
+			}, "threshold_percent", "level", "remaining_seconds"),
+		}, "session_id", "status", "region", "relay", "credentials", "timers", "duration_seconds"),
+		"Credentials": object(map[string]*Schema{
+			"pair_token":     str(""),
+			"relay_ws_token": str(""),
+			"masked":         {Type: "boolean", Description: "Present and true when only the last two characters are shown"},
+		}, "pair_token", "relay_ws_token"),
+		"RelaySession": object(map[string]*Schema{
+			"session_id":           str(""),
+			"status":               enum(sessionStatuses...),
+			"region":               str(""),
+			"srt_port":             {Type: "integer", Description: "The first of srt_ports"},
+			"srt_ports":            arrayOf(&Schema{Type: "integer"}),
+			"grace_window_seconds": {Type: "integer"},
+			"max_session_seconds":  {Type: "integer"},
+			"remaining_seconds":    {Type: "integer", Description: "Seconds until the session reaches max_session_seconds; 0 once stopped"},
+			"stopped":              {Type: "boolean", Description: "True once the session is stopping or stopped; the relay should shut down"},
+		}, "session_id", "status", "region", "srt_port", "srt_ports", "grace_window_seconds", "max_session_seconds", "remaining_seconds", "stopped"),
+		"StopResult": object(map[string]*Schema{
+			"session_id": str(""),
+			"status":     enum(string(model.SessionStopping), string(model.SessionStopped)),
+			"stopped_at": dateTime(),
+			"reason":     &Schema{Type: "string", Enum: model.ClientStopReasons, Description: "The normalized reason of a POST /api/v1/relay/stop; absent on admin stops"},
+		}, "session_id", "status", "stopped_at"),
+		"Usage": object(map[string]*Schema{
+			"plan_tier":         str(""),
+			"cycle_start":       dateTime(),
+			"cycle_end":         dateTime(),
+			"included_seconds":  {Type: "integer"},
+			"consumed_seconds":  {Type: "integer"},
+			"remaining_seconds": {Type: "integer"},
+			"overage_seconds":   {Type: "integer"},
+			"usage_alerts":      arrayOf(ref("UsageAlert")),
+		}, "plan_tier", "cycle_start", "cycle_end", "included_seconds", "consumed_seconds", "remaining_seconds", "overage_seconds", "usage_alerts"),
+		"AdminSession": object(map[string]*Schema{
+			"session_id":        str(""),
+			"user_id":           str(""),
+			"status":            enum(sessionStatuses...),
+			"region":            str(""),
+			"instance_id":       str(""),
+			"relay_lifecycle":   str(""),
+			"subnet_id":         str(""),
+			"availability_zone": str(""),
+			"public_ip":         str(""),
+			"started_at":        dateTime(),
+			"stopped_at":        dateTime(),
+			"duration_seconds":  {Type: "integer"},
+			"billable":          {Type: "boolean", Description: "False for sessions left out of usage, such as canaries"},
+			"tags":              arrayOf(str("")),
+		}, "session_id", "user_id", "status", "region", "started_at", "duration_seconds", "billable", "tags"),
+		"SessionRelay": object(map[string]*Schema{
+			"session_id":     str(""),
+			"user_id":        str(""),
+			"session_status": enum(sessionStatuses...),
+			"relay": nullable(object(map[string]*Schema{
+				"relay_instance_id": str(""),
+				"region":            str(""),
+				"instance_id":       str(""),
+				"state":             str(""),
+				"public_ip":         str(""),
+				"provider":          str(""),
+				"launched_at":       dateTime(),
+				"terminated_at":     dateTime(),
+				"last_health_at":    dateTime(),
+			}, "relay_instance_id", "region", "instance_id", "state", "public_ip")),
+			"provider": nullable(object(map[string]*Schema{
+				"state":       str(""),
+				"public_ip":   str(""),
+				"launched_at": dateTime(),
+			}, "state", "public_ip")),
+			"provider_error":     str("Why the provider could not be asked; provider is then null"),
+			"provision_attempts": arrayOf(ref("ProvisionAttempt")),
+			"started_from_ip":    nullable(str("Client IP that started the session; null when not recorded")),
+			"started_user_agent": nullable(str("User-Agent that started the session; null when not recorded")),
+		}, "session_id", "user_id", "session_status", "relay", "provider", "provision_attempts", "started_from_ip", "started_user_agent"),
+		"ProvisionAttempt": object(map[string]*Schema{
+			"attempt_id":     {Type: "integer", Format: "int64"},
+			"provider":       str(""),
+			"region":         str(""),
+			"instance_type":  str(""),
+			"started_at":     dateTime(),
+			"finished_at":    dateTime(),
+			"outcome":        enum(model.ProvisionAttemptSucceeded, model.ProvisionAttemptFailed),
+			"aws_error_code": str("The provider's error code, for failed attempts that had one"),
+			"error":          str(""),
+			"instance_id":    str("The instance the attempt launched, if any"),
+			"compensation":   enum(model.CompensationSessionStopped, model.CompensationTerminationQueued, model.CompensationFailed),
+		}, "attempt_id", "provider", "region", "started_at", "finished_at", "outcome"),
+		"RelayHealthEvent": object(map[string]*Schema{
+			"relay_instance_id":      str(""),
+			"observed_at":            dateTime(),
+			"ingest_active":          {Type: "boolean"},
+			"egress_active":          {Type: "boolean"},
+			"session_uptime_seconds": {Type: "integer"},
+		}, "relay_instance_id", "observed_at", "ingest_active", "egress_active", "session_uptime_seconds"),
+		"JobRun": object(map[string]*Schema{
+			"run_id":       {Type: "integer", Format: "int64"},
+			"job":          enum(jobs.Names...),
+			"requested_by": str(""),
+			"status":       enum(model.JobRunPending, model.JobRunRunning, model.JobRunSucceeded, model.JobRunFailed),
+			"requested_at": dateTime(),
+			"started_at":   dateTime(),
+			"finished_at":  dateTime(),
+			"error":        str(""),
+		}, "run_id", "job", "requested_by", "status", "requested_at"),
+		"UserDataExport": object(map[string]*Schema{
+			"user_id":      str(""),
+			"generated_at": dateTime(),
+			"sessions": arrayOf(object(map[string]*Schema{
+				"session_id":       str(""),
+				"status":           str(""),
+				"region":           str(""),
+				"started_at":       dateTime(),
+				"stopped_at":       {Type: "string", Format: "date-time", Nullable: true},
+				"duration_seconds": {Type: "integer"},
+			}, "session_id", "status", "region", "started_at", "stopped_at", "duration_seconds")),
+			"usage_records": arrayOf(ref("UsageExportRecord")),
+			"session_events": arrayOf(object(map[string]*Schema{
+				"event_id":   {Type: "integer", Format: "int64"},
+				"session_id": str(""),
+				"type":       str(""),
+				"payload":    {Type: "object"},
+				"created_at": dateTime(),
+			}, "event_id", "session_id", "type", "payload", "created_at")),
+		}, "user_id", "generated_at", "sessions", "usage_records", "session_events"),
+		"UserDataErasure": object(map[string]*Schema{
+			"tombstone_user_id": str("The user the retained sessions and usage now belong to"),
+			"requested_by":      str("The admin who erased the data"),
+			"erased_at":         dateTime(),
+			"rows":              {Type: "object", Description: "Rows touched per table"},
+			"replayed":          {Type: "boolean"},
+		}, "tombstone_user_id", "requested_by", "erased_at", "rows", "replayed"),
+		"Webhook": object(map[string]*Schema{
+			"webhook_id": str(""),
+			"url":        str(""),
+			"events":     arrayOf(enum(model.WebhookEvents...)),
+			"secret":     str("Only in the response to creation"),
+			"created_at": dateTime(),
+			"updated_at": dateTime(),
+		}, "webhook_id", "url", "events", "created_at", "updated_at"),
+		"WebhookDelivery": object(map[string]*Schema{
+			"delivery_id":      {Type: "integer", Format: "int64"},
+			"webhook_id":       str(""),
+			"url":              str(""),
+			"event":            enum(model.WebhookEvents...),
+			"status":           enum(model.WebhookDeliveryPending, model.WebhookDeliveryDelivered, model.WebhookDeliveryDead),
+			"attempts":         {Type: "integer"},
+			"last_status_code": {Type: "integer"},
+			"last_error":       str(""),
+			"payload":          {Type: "object"},
+			"created_at":       dateTime(),
+		}, "delivery_id", "webhook_id", "url", "event", "status", "attempts", "last_status_code", "last_error", "payload", "created_at"),
+		"RelayInstance": object(map[string]*Schema{
+			"id":              str(""),
+			"session_id":      str("Empty for warm pool relays not yet claimed"),
+			"provider":        str(""),
+			"region":          str(""),
+			"aws_instance_id": str(""),
+			"ami_id":          str(""),
+			"instance_type":   str(""),
+			"lifecycle":       str(""),
+			"state":           enum(model.RelayProvisioning, model.RelayRunning, model.RelayTerminating, model.RelayTerminated, model.RelayError),
+			"public_ip":       str(""),
+			"agent_version":   str(""),
+			"launched_at":     dateTime(),
+			"terminated_at":   dateTime(),
+			"last_health_at":  dateTime(),
+		}, "id", "session_id", "provider", "region", "aws_instance_id", "ami_id", "instance_type", "lifecycle", "state", "public_ip", "agent_version", "launched_at"),
+		"RelayQualityDay": object(map[string]*Schema{
+			"day":              {Type: "string", Format: "date"},
+			"region":           str(""),
+			"ami_id":           str(""),
+			"instance_type":    str(""),
+			"relays":           {Type: "integer", Description: "Session relays up at some point that day"},
+			"relays_with_gaps": {Type: "integer"},
+			"health_gaps":      {Type: "integer", Description: "Heartbeat gaps longer than the heartbeat timeout ending that day"},
+			"gap_seconds":      {Type: "integer", Format: "int64"},
+			"never_healthy":    {Type: "integer", Description: "Relays launched that day that never sent a heartbeat"},
+			"updated_at":       dateTime(),
+		}, "day", "region", "ami_id", "instance_type", "relays", "relays_with_gaps", "health_gaps", "gap_seconds", "never_healthy", "updated_at"),
+		"BillingExport": object(map[string]*Schema{
+			"user_id":                     str(""),
+			"cycle_start_at":              dateTime(),
+			"cycle_end_at":                dateTime(),
+			"stripe_subscription_item_id": str(""),
+			"overage_seconds":             {Type: "integer"},
+			"status":                      enum(model.BillingExportPending, model.BillingExportReported, model.BillingExportFailed),
+			"attempts":                    {Type: "integer"},
+			"last_error":                  str(""),
+			"stripe_usage_record_id":      str(""),
+			"updated_at":                  dateTime(),
+			"next_attempt_at":             dateTime(),
+			"reported_at":                 dateTime(),
+		}, "user_id", "cycle_start_at", "cycle_end_at", "stripe_subscription_item_id", "overage_seconds", "status", "attempts", "last_error", "stripe_usage_record_id", "updated_at"),
+	}
+}
+
+var errorDescriptions = map[string]string{
+	"400": "Invalid request",
+	"401": "Missing or invalid credentials",
+	"403": "Not an admin, or not entitled to the operation",
+	"404": "Not found",
+	"409": "Conflicts with the current state",
+	"422": "Rejected configuration, or a field value that cannot be stored or is not recognised",
+	"428": "Confirmation token missing, invalid or expired",
+	"429": "Too many requests; retry after the Retry-After header",
+	"500": "Internal error",
+	"502": "The relay provider refused the launch for a reason operators must fix",
+	"503": "Temporarily unavailable; retry after the Retry-After header",
+	"504": "The database did not respond in time",
+}
+
+func withErrors(responses map[string]Response, codes ...string) map[string]Response {
+	for _, code := range codes {
+		responses[code] = jsonResponse(errorDescriptions[code], ref("Error"))
+	}
+	return responses
+}
+
+func jsonResponse(desc string, s *Schema) Response {
+	return Response{Description: desc, Content: map[string]MediaType{"application/json": {Schema: s}}}
+}
+
+func jsonBody(s *Schema) *RequestBody {
+	return &RequestBody{Required: true, Content: map[string]MediaType{"application/json": {Schema: s}}}
+}
+
+func pathParam(name, desc string) Parameter {
+	return Parameter{Name: name, In: "path", Required: true, Description: desc, Schema: str("")}
+}
+
+// dryRunParam is the dry_run query parameter of session starts.
+func dryRunParam() Parameter {
+	return Parameter{
+		Name: "dry_run", In: "query", Schema: &Schema{Type: "boolean"},
+		Description: "Only report what the start would do, always with 200; nothing is recorded and the key stays unused",
+	}
+}
+
+// startOrDryRun is the 200 body of session starts.
+func startOrDryRun() *Schema {
+	return &Schema{OneOf: []*Schema{ref("SessionEnvelope"), ref("StartDryRun")}}
+}
+
+// startIdempotencyKey is the Idempotency-Key header of session starts.
+func startIdempotencyKey() Parameter {
+	return Parameter{
+		Name: "Idempotency-Key", In: "header", Required: true, Schema: &Schema{Type: "string", Format: "uuid"},
+		Description: "Version 4 UUID; retries with the same key and body return the same session",
+	}
+}
+
+func ref(name string) *Schema { return &Schema{Ref: "#/components/schemas/" + name} }
+
+func str(desc string) *Schema { return &Schema{Type: "string", Description: desc} }
+
+func dateTime() *Schema { return &Schema{Type: "string", Format: "date-time"} }
+
+func enum(values ...string) *Schema { return &Schema{Type: "string", Enum: values} }
+
+func arrayOf(items *Schema) *Schema { return &Schema{Type: "array", Items: items} }
+
+func nullable(s *Schema) *Schema {
+	s.Nullable = true
+	return s
+}
+
+func object(props map[string]*Schema, required ...string) *Schema {
+	return &Schema{Type: "object", Properties: props, Required: required}
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestOpenAPI_DescribesEveryRoute(t *testing.T) {
+	router := NewRouter(testConfig(), &mockStore{}, &mockProvisioner{}, WithConfigReloader(func() ([]string, error) { return nil, nil }))
+	var routed []string
+	err := chi.Walk(router.(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		routed = append(routed, method+" "+route)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk routes: %v", err)
+	}
+	var described []string
+	for path, ops := range openAPIDocument().Paths {
+		for method := range ops {
+			described = append(described, strings.ToUpper(method)+" "+path)
+		}
+	}
+	for _, r := range routed {
+		if !slices.Contains(described, r) {
+			t.Errorf("route %s is missing from the OpenAPI document", r)
+		}
+	}
+	for _, d := range described {
+		if !slices.Contains(routed, d) {
+			t.Errorf("OpenAPI operation %s has no route", d)
+		}
+	}
+}
+
+func TestOpenAPI_ReferencesResolve(t *testing.T) {
+	raw, err := json.Marshal(openAPIDocument())
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var refs []string
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok {
+				refs = append(refs, strings.TrimPrefix(ref, "#/components/schemas/"))
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	var doc any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	walk(doc)
+	schemas := openAPIDocument().Components.Schemas
+	for _, ref := range refs {
+		if schemas[ref] == nil {
+			t.Errorf("unresolved reference to %s", ref)
+		}
+	}
+	ids := map[string]bool{}
+	for path, ops := range openAPIDocument().Paths {
+		for method, op := range ops {
+			if ids[op.OperationID] {
+				t.Errorf("%s %s reuses operation id %s", method, path, op.OperationID)
+			}
+			ids[op.OperationID] = true
+		}
+	}
+}
+
+func TestOpenAPI_ServedWithoutAuth(t *testing.T) {
+	router := NewRouter(testConfig(), &mockStore{}, &mockProvisioner{})
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var doc struct {
+		OpenAPI    string `json:"openapi"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+				Required   []string                   `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	stop := doc.Components.Schemas["RelayStopRequest"]
+	if !strings.HasPrefix(doc.OpenAPI, "3.") || stop.Properties["session_id"] == nil || !slices.Equal(stop.Required, []string{"session_id"}) {
+		t.Fatalf("unexpected document: openapi=%s stop=%+v", doc.OpenAPI, stop)
+	}
+}
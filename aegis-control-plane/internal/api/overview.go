@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/jobs"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+const (
+	// overviewCacheTTL is how long an overview is served to every admin
+	// before the database is read again.
+	overviewCacheTTL = 10 * time.Second
+	// overviewProvisionWindow is how far back provisioning stats reach.
+	overviewProvisionWindow = time.Hour
+	// overviewTerminationWindow is how far back terminated relays are
+	// attributed to their termination reasons.
+	overviewTerminationWindow = 24 * time.Hour
+	// overviewCostUsers is how many of the users costing the most in their
+	// current cycle are listed.
+	overviewCostUsers = 10
+)
+
+// overviewCache keeps the last overview. Admins asking while one is being
+// built wait for it rather than reading the database again.
+type overviewCache struct {
+	mu   sync.Mutex
+	body map[string]any
+	at   time.Time
+}
+
+type overviewWarning struct {
+	Section string `json:"section"`
+	Message string `json:"message"`
+}
+
+// handleAdminOverview summarizes sessions, provisioning, relays, estimated
+// costs and the manifest for a dashboard. A section that cannot be read is
+// null and named in warnings; the rest are still returned.
+func (s *Server) handleAdminOverview(w http.ResponseWriter, r *http.Request) {
+	c := s.overview
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.body != nil && now.Sub(c.at) < overviewCacheTTL {
+		writeJSON(w, http.StatusOK, c.body)
+		return
+	}
+	body := s.buildOverview(r.Context(), now)
+	// An overview cut short by its own request is not served to others.
+	if r.Context().Err() == nil {
+		c.body, c.at = body, now
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (s *Server) buildOverview(ctx context.Context, now time.Time) map[string]any {
+	warnings := make([]overviewWarning, 0)
+	fail := func(section, message string, err error) {
+		log.Printf("event=admin_overview_section_failed section=%s err=%q", section, err.Error())
+		warnings = append(warnings, overviewWarning{Section: section, Message: message})
+	}
+	body := map[string]any{
+		"generated_at":         now.UTC().Format(time.RFC3339),
+		"sessions":             nil,
+		"provisioning":         nil,
+		"stale_relays":         nil,
+		"pending_terminations": nil,
+		"terminations":         nil,
+		"cost":                 nil,
+		"manifest":             nil,
+	}
+
+	if counts, err := s.store.CountSessionsByStatusRegion(ctx); err != nil {
+		fail("sessions", "failed to count sessions", err)
+	} else {
+		body["sessions"] = overviewSessions(counts)
+	}
+
+	if st, err := s.store.ProvisionStats(ctx, now.Add(-overviewProvisionWindow)); err != nil {
+		fail("provisioning", "failed to read provision attempts", err)
+	} else {
+		var rate any
+		if st.Attempts > 0 {
+			rate = float64(st.Succeeded) / float64(st.Attempts)
+		}
+		body["provisioning"] = map[string]any{
+			"window_seconds": int(overviewProvisionWindow.Seconds()),
+			"attempts":       st.Attempts,
+			"succeeded":      st.Succeeded,
+			"success_rate":   rate,
+			"p95_latency_ms": st.P95Latency.Milliseconds(),
+		}
+	}
+
+	if n, err := s.store.CountStaleRelays(ctx, jobs.RelayHeartbeatTimeout); err != nil {
+		fail("stale_relays", "failed to count stale relays", err)
+	} else {
+		body["stale_relays"] = n
+	}
+
+	if n, err := s.store.CountPendingTerminations(ctx); err != nil {
+		fail("pending_terminations", "failed to count pending terminations", err)
+	} else {
+		body["pending_terminations"] = n
+	}
+
+	if seconds, err := s.store.InstanceSecondsByTerminateReason(ctx, now.Add(-overviewTerminationWindow)); err != nil {
+		fail("terminations", "failed to sum terminated relays", err)
+	} else {
+		body["terminations"] = map[string]any{
+			"window_seconds":   int(overviewTerminationWindow.Seconds()),
+			"instance_seconds": seconds,
+		}
+	}
+
+	if cost, err := s.store.CostOverview(ctx, overviewCostUsers); err != nil {
+		fail("cost", "failed to sum estimated costs", err)
+	} else {
+		body["cost"] = overviewCost(cost)
+	}
+
+	if entries, err := s.store.ListRelayManifest(ctx); err != nil {
+		fail("manifest", "failed to read relay manifest", err)
+	} else {
+		body["manifest"] = overviewManifest(entries, now)
+	}
+
+	body["warnings"] = warnings
+	return body
+}
+
+// overviewSessions counts the provisioning, active and grace sessions of
+// each region that has any.
+func overviewSessions(counts map[model.SessionStatus]map[string]int) map[string]map[string]int {
+	out := make(map[string]map[string]int)
+	for _, status := range []model.SessionStatus{model.SessionProvisioning, model.SessionActive, model.SessionGrace} {
+		for region, n := range counts[status] {
+			if out[region] == nil {
+				out[region] = map[string]int{
+					string(model.SessionProvisioning): 0,
+					string(model.SessionActive):       0,
+					string(model.SessionGrace):        0,
+				}
+			}
+			out[region][string(status)] = n
+		}
+	}
+	return out
+}
+
+// overviewCost reports what live sessions cost per hour and so far, and the
+// users costing the most in their current cycle.
+func overviewCost(c model.CostOverview) map[string]any {
+	users := make([]map[string]any, 0, len(c.TopUsers))
+	for _, u := range c.TopUsers {
+		users = append(users, map[string]any{
+			"user_id":            u.UserID,
+			"cycle_start_at":     u.CycleStart.UTC().Format(time.RFC3339),
+			"cycle_end_at":       u.CycleEnd.UTC().Format(time.RFC3339),
+			"billable_seconds":   u.BillableSeconds,
+			"estimated_cost_usd": u.EstimatedCostUSD,
+		})
+	}
+	return map[string]any{
+		"live_sessions":           c.LiveSessions,
+		"burn_usd_per_hour":       c.BurnUSDPerHour,
+		"live_estimated_cost_usd": c.LiveEstimatedCostUSD,
+		"top_users":               users,
+	}
+}
+
+// overviewManifest reports how long ago the least recently written manifest
+// entry was written.
+func overviewManifest(entries []model.RelayManifestEntry, now time.Time) map[string]any {
+	available := 0
+	var oldest time.Time
+	for _, e := range entries {
+		if e.Available && e.Enabled {
+			available++
+		}
+		if oldest.IsZero() || e.UpdatedAt.Before(oldest) {
+			oldest = e.UpdatedAt
+		}
+	}
+	out := map[string]any{
+		"regions":           len(entries),
+		"available_regions": available,
+		"oldest_updated_at": nil,
+		"age_seconds":       nil,
+	}
+	if !oldest.IsZero() {
+		out["oldest_updated_at"] = oldest.UTC().Format(time.RFC3339)
+		out["age_seconds"] = int(now.Sub(oldest).Seconds())
+	}
+	return out
+}
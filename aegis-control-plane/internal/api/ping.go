@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+)
+
+// serverTimeFormat is RFC 3339 with milliseconds, so relays can measure
+// their clock skew more finely than a second.
+const serverTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// ipRateLimiter admits a number of requests per client IP in each fixed
+// window. Limits are per process: each API instance counts its own share.
+type ipRateLimiter struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	windows   map[string]*rateWindow
+	lastSweep time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	n     int
+}
+
+func newIPRateLimiter(window time.Duration) *ipRateLimiter {
+	return &ipRateLimiter{window: window, now: time.Now, windows: make(map[string]*rateWindow)}
+}
+
+// allow counts a request from ip and reports whether it is within limit,
+// and otherwise how long until the window resets.
+func (l *ipRateLimiter) allow(ip string, limit int) (bool, time.Duration) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Windows of addresses that went quiet are dropped once per window.
+	if now.Sub(l.lastSweep) >= l.window {
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= l.window {
+				delete(l.windows, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	w := l.windows[ip]
+	if w == nil || now.Sub(w.start) >= l.window {
+		w = &rateWindow{start: now}
+		l.windows[ip] = w
+	}
+	if w.n >= limit {
+		return false, w.start.Add(l.window).Sub(now)
+	}
+	w.n++
+	return true, 0
+}
+
+// limitRelayPings refuses pings past AEGIS_RELAY_PING_RATE_LIMIT per client
+// IP per minute. It runs before relay auth, so guessed keys count too.
+func (s *Server) limitRelayPings(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, retryAfter := s.pings.allow(clientIP(r), s.config().RelayPingRateLimit); !ok {
+			writeRetryAfter(w, http.StatusTooManyRequests, apierr.RateLimited, "too many pings", retryAfter)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleRelayPing lets relay agents check that the control plane is
+// reachable, measure their clock skew and pick up their settings. It reads
+// nothing from the database.
+func (s *Server) handleRelayPing(w http.ResponseWriter, _ *http.Request) {
+	cfg := s.config()
+	var minVersion any
+	if cfg.RelayMinAgentVersion != "" {
+		minVersion = cfg.RelayMinAgentVersion
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"server_time":                time.Now().UTC().Format(serverTimeFormat),
+		"min_agent_version":          minVersion,
+		"heartbeat_interval_seconds": int(cfg.RelayHeartbeatInterval.Seconds()),
+	})
+}
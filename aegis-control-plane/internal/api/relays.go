@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+// relayQualityDefaultDays is how many days of relay quality are listed when
+// no from date is given.
+const relayQualityDefaultDays = 30
+
+// handleAdminRelays lists relays newest launch first, optionally for one
+// region, state or AMI and a launch window. A full page carries
+// next_cursor, which continues the listing from its last relay.
+func (s *Server) handleAdminRelays(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := store.RelayInstanceFilter{Region: q.Get("region"), State: q.Get("state"), AMIID: q.Get("ami_id"), Limit: 50}
+	switch filter.State {
+	case "", model.RelayProvisioning, model.RelayRunning, model.RelayTerminating, model.RelayTerminated, model.RelayError:
+	default:
+		writeAPIError(w, apierr.InvalidRequest, "unknown state filter")
+		return
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"launched_from", &filter.LaunchedFrom}, {"launched_to", &filter.LaunchedTo}} {
+		raw := q.Get(p.name)
+		if raw == "" {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			writeAPIError(w, apierr.InvalidRequest, p.name+" must be an RFC3339 timestamp")
+			return
+		}
+		*p.dst = ts
+	}
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 500 {
+			writeAPIError(w, apierr.InvalidRequest, "limit must be between 1 and 500")
+			return
+		}
+		filter.Limit = n
+	}
+	if raw := q.Get("cursor"); raw != "" {
+		launchedAt, id, ok := parseRelayCursor(raw)
+		if !ok {
+			writeAPIError(w, apierr.InvalidRequest, "invalid cursor")
+			return
+		}
+		filter.BeforeLaunchedAt, filter.BeforeID = launchedAt, id
+	}
+
+	// One extra row tells whether another page follows.
+	limit := filter.Limit
+	filter.Limit++
+	relays, err := s.store.ListRelayInstances(r.Context(), filter)
+	if err != nil {
+		writeStoreError(w, err, "failed to list relays")
+		return
+	}
+	var next any
+	if len(relays) > limit {
+		relays = relays[:limit]
+		last := relays[limit-1]
+		next = relayCursor(last.LaunchedAt, last.ID)
+	}
+	out := make([]map[string]any, 0, len(relays))
+	for _, ri := range relays {
+		item := map[string]any{
+			"id":              ri.ID,
+			"session_id":      ri.SessionID,
+			"provider":        ri.Provider,
+			"region":          ri.Region,
+			"aws_instance_id": ri.AWSInstanceID,
+			"ami_id":          ri.AMIID,
+			"instance_type":   ri.InstanceType,
+			"lifecycle":       ri.Lifecycle,
+			"state":           ri.State,
+			"public_ip":       ri.PublicIP,
+			"agent_version":   ri.AgentVersion,
+			"launched_at":     ri.LaunchedAt.UTC().Format(time.RFC3339),
+		}
+		if ri.TerminatedAt != nil {
+			item["terminated_at"] = ri.TerminatedAt.UTC().Format(time.RFC3339)
+		}
+		if ri.LastHealthAt != nil {
+			item["last_health_at"] = ri.LastHealthAt.UTC().Format(time.RFC3339)
+		}
+		out = append(out, item)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"relays": out, "next_cursor": next})
+}
+
+// relayCursor encodes the position after a relay. It is opaque to clients.
+func relayCursor(launchedAt time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(launchedAt.UTC().Format(time.RFC3339Nano) + "," + id))
+}
+
+func parseRelayCursor(raw string) (time.Time, string, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	ts, id, ok := strings.Cut(string(b), ",")
+	if !ok || id == "" {
+		return time.Time{}, "", false
+	}
+	launchedAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	return launchedAt, id, true
+}
+
+// handleAdminRelayQuality lists the daily relay quality rollup, newest day
+// first, for the days from and to (YYYY-MM-DD, inclusive; by default the
+// last 30) and optionally one region or AMI.
+func (s *Server) handleAdminRelayQuality(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := store.RelayQualityFilter{Region: q.Get("region"), AMIID: q.Get("ami_id"), Limit: 500}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		raw := q.Get(p.name)
+		if raw == "" {
+			continue
+		}
+		day, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			writeAPIError(w, apierr.InvalidRequest, p.name+" must be a YYYY-MM-DD date")
+			return
+		}
+		*p.dst = day
+	}
+	if filter.From.IsZero() {
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		filter.From = today.AddDate(0, 0, -relayQualityDefaultDays)
+	}
+	if !filter.To.IsZero() && filter.To.Before(filter.From) {
+		writeAPIError(w, apierr.InvalidRequest, "to must not be before from")
+		return
+	}
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 500 {
+			writeAPIError(w, apierr.InvalidRequest, "limit must be between 1 and 500")
+			return
+		}
+		filter.Limit = n
+	}
+	days, err := s.store.ListRelayQuality(r.Context(), filter)
+	if err != nil {
+		writeStoreError(w, err, "failed to list relay quality")
+		return
+	}
+	out := make([]map[string]any, 0, len(days))
+	for _, d := range days {
+		out = append(out, map[string]any{
+			"day":              d.Day.Format("2006-01-02"),
+			"region":           d.Region,
+			"ami_id":           d.AMIID,
+			"instance_type":    d.InstanceType,
+			"relays":           d.Relays,
+			"relays_with_gaps": d.RelaysWithGaps,
+			"health_gaps":      d.HealthGaps,
+			"gap_seconds":      d.GapSeconds,
+			"never_healthy":    d.NeverHealthy,
+			"updated_at":       d.UpdatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"days": out})
+}
@@ -3,90 +3,394 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/google/uuid"
 
+	"github.com/telemyapp/aegis-control-plane/internal/alert"
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
 	"github.com/telemyapp/aegis-control-plane/internal/auth"
 	"github.com/telemyapp/aegis-control-plane/internal/config"
 	"github.com/telemyapp/aegis-control-plane/internal/metrics"
 	"github.com/telemyapp/aegis-control-plane/internal/model"
 	"github.com/telemyapp/aegis-control-plane/internal/relay"
+	"github.com/telemyapp/aegis-control-plane/internal/session"
 	"github.com/telemyapp/aegis-control-plane/internal/store"
+	"github.com/telemyapp/aegis-control-plane/internal/version"
 )
 
+// Store is the persistence the API needs. Getters of a single session or
+// relay return store.ErrNotFound when there is none, never a nil result
+// with a nil error.
 type Store interface {
 	StartOrGetSession(rctx context.Context, in store.StartInput) (*model.Session, bool, error)
+	PreviewStart(rctx context.Context, in store.StartInput) (store.StartPreview, error)
 	ActivateProvisionedSession(rctx context.Context, in store.ActivateProvisionedSessionInput) (*model.Session, error)
+	AdvanceProvisioningPhase(rctx context.Context, userID, sessionID, phase string) error
 	GetActiveSession(rctx context.Context, userID string) (*model.Session, error)
+	ListLiveSessions(rctx context.Context, userID, tag string) ([]model.Session, error)
 	GetSessionByID(rctx context.Context, userID, sessionID string) (*model.Session, error)
-	StopSession(rctx context.Context, userID, sessionID string) (*model.Session, error)
-	GetUsageCurrent(rctx context.Context, userID string) (*model.UsageCurrent, error)
+	StopSession(rctx context.Context, in store.StopSessionInput) (*model.Session, error)
+	StopProvisionedSession(rctx context.Context, userID, sessionID, region, awsInstanceID string) (*model.Session, error)
+	RecordProvisionAttempt(rctx context.Context, a model.ProvisionAttempt) (int64, error)
+	SetProvisionAttemptCompensation(rctx context.Context, id int64, compensation string) error
+	ListProvisionAttempts(rctx context.Context, sessionID string) ([]model.ProvisionAttempt, error)
+	GetUsageCurrent(rctx context.Context, userID string, freeIncludedSeconds int) (*model.UsageCurrent, error)
+	PreviewUsageCurrent(rctx context.Context, userID string, freeIncludedSeconds int) (*model.UsageCurrent, error)
 	RecordRelayHealth(rctx context.Context, in store.RelayHealthInput) error
 	ListRelayManifest(rctx context.Context) ([]model.RelayManifestEntry, error)
+	ListSessions(rctx context.Context, status, tag string, limit int) ([]model.Session, error)
+	GetSessionRelay(rctx context.Context, sessionID string) (*model.SessionRelay, error)
+	MarkRelayInterrupted(rctx context.Context, sessionID, awsInstanceID string) (*model.Session, error)
+	GetRelaySession(rctx context.Context, sessionID, awsInstanceID string) (*model.Session, error)
+	GetSessionTimers(rctx context.Context, sessionID string) (*model.Session, error)
+	ListSessionEvents(rctx context.Context, userID string, afterID int64, limit int) ([]model.SessionEvent, error)
+	ListSessionChanges(rctx context.Context, afterSeq int64, limit int) ([]model.SessionChange, error)
+	AckSessionChanges(rctx context.Context, consumer string, seq int64) error
+	LatestSessionEventID(rctx context.Context, userID string) (int64, error)
+	RecordSessionEvent(rctx context.Context, sessionID, userID, eventType string, payload any) error
+	GetActiveRelayAccess(rctx context.Context, userID string) (*model.RelayAccess, error)
+	UpdateRelayAllowedClientIP(rctx context.Context, relayInstanceID, clientIP string) error
+	CreateWebhook(rctx context.Context, in model.Webhook) (*model.Webhook, error)
+	ListWebhooks(rctx context.Context, userID string) ([]model.Webhook, error)
+	GetWebhook(rctx context.Context, userID, id string) (*model.Webhook, error)
+	UpdateWebhook(rctx context.Context, in model.Webhook) (*model.Webhook, error)
+	DeleteWebhook(rctx context.Context, userID, id string) error
+	ListWebhookDeliveries(rctx context.Context, status string, limit int) ([]model.WebhookDelivery, error)
+	ReplayWebhookDelivery(rctx context.Context, id int64) error
+	ExportUsage(rctx context.Context, from, to time.Time) (store.UsageExport, error)
+	ListBillingExports(rctx context.Context, f store.BillingExportFilter) ([]model.BillingExport, error)
+	ListRelayHealth(rctx context.Context, sessionID string, limit int) ([]model.RelayHealthEvent, error)
+	ListRelayInstances(rctx context.Context, f store.RelayInstanceFilter) ([]model.RelayInstance, error)
+	ListRelayQuality(rctx context.Context, f store.RelayQualityFilter) ([]model.RelayQualityDay, error)
+	UpdateRelayManifestEntry(rctx context.Context, in store.RelayManifestUpdate) (*model.RelayManifestEntry, error)
+	RequestJobRun(rctx context.Context, job, requestedBy string) (*model.JobRun, error)
+	GetJobRun(rctx context.Context, id int64) (*model.JobRun, error)
+	EraseUserData(rctx context.Context, userID, requestedBy string) (*model.UserDataErasure, bool, error)
+	RecordUserDataExport(rctx context.Context, userID, requestedBy string, every time.Duration) (time.Time, error)
+	ExportUserSessions(rctx context.Context, userID string) (store.RowIterator[model.Session], error)
+	ExportUserUsage(rctx context.Context, userID string) (store.UsageExport, error)
+	ExportUserSessionEvents(rctx context.Context, userID string) (store.RowIterator[model.SessionEvent], error)
+	CountSessionsByStatusRegion(rctx context.Context) (map[model.SessionStatus]map[string]int, error)
+	ProvisionStats(rctx context.Context, since time.Time) (model.ProvisionStats, error)
+	CountStaleRelays(rctx context.Context, heartbeatTimeout time.Duration) (int, error)
+	CountPendingTerminations(rctx context.Context) (int, error)
+	InstanceSecondsByTerminateReason(rctx context.Context, since time.Time) (map[string]int64, error)
+	CostOverview(rctx context.Context, topUsers int) (model.CostOverview, error)
 }
 
 type Server struct {
-	cfg         config.Config
-	store       Store
-	provisioner relay.Provisioner
+	cfg          *config.Live
+	store        Store
+	provisioner  relay.Provisioner
+	reloadConfig func() ([]string, error)
+	streamCtx    context.Context
+	bootProbe    relay.BootProbe
+	alerts       *alert.Hook
+	sessions     *session.Service
+	allowances   *allowanceCache
+	overview     *overviewCache
+	drain        *Drain
+	pings        *ipRateLimiter
 }
 
-func NewRouter(cfg config.Config, st Store, prov relay.Provisioner) http.Handler {
-	s := &Server{cfg: cfg, store: st, provisioner: prov}
+type RouterOption func(*Server)
+
+// WithLiveConfig makes handlers read configuration through l so that
+// reloads are observed without rebuilding the router.
+func WithLiveConfig(l *config.Live) RouterOption {
+	return func(s *Server) {
+		s.cfg = l
+	}
+}
+
+// WithConfigReloader enables POST /api/v1/admin/config/reload. fn returns the
+// non-reloadable settings that were left unchanged.
+func WithConfigReloader(fn func() ([]string, error)) RouterOption {
+	return func(s *Server) {
+		s.reloadConfig = fn
+	}
+}
+
+// WithStreamContext ends long-lived event streams when ctx is canceled, which
+// http.Server.Shutdown does not do on its own.
+func WithStreamContext(ctx context.Context) RouterOption {
+	return func(s *Server) {
+		s.streamCtx = ctx
+	}
+}
+
+// WithBootProbe makes relay start wait for new relays to pass p before
+// returning them, when AEGIS_RELAY_BOOT_PROBE is on.
+func WithBootProbe(p relay.BootProbe) RouterOption {
+	return func(s *Server) {
+		s.bootProbe = p
+	}
+}
+
+// WithAlerts sends operator alerts through h, e.g. when relay launches in a
+// region keep failing.
+func WithAlerts(h *alert.Hook) RouterOption {
+	return func(s *Server) {
+		s.alerts = h
+	}
+}
+
+// NewRouter returns the API's handler. When cfg.AdminListenAddr is set it
+// has no admin routes; NewRouters also returns the handler that serves them.
+func NewRouter(cfg config.Config, st Store, prov relay.Provisioner, opts ...RouterOption) http.Handler {
+	public, _ := NewRouters(cfg, st, prov, opts...)
+	return public
+}
+
+// NewRouters returns the public handler and, when cfg.AdminListenAddr is
+// set, the admin handler for that listener: it serves /api/v1/admin and
+// /debug, which the public handler then does not. admin is nil otherwise.
+// Both share one Server, so caches and the drain state are the same.
+func NewRouters(cfg config.Config, st Store, prov relay.Provisioner, opts ...RouterOption) (public, admin http.Handler) {
+	s := &Server{
+		cfg:         config.NewLive(cfg),
+		store:       st,
+		provisioner: prov,
+		streamCtx:   context.Background(),
+		allowances:  newAllowanceCache(),
+		overview:    &overviewCache{},
+		drain:       &Drain{},
+		pings:       newIPRateLimiter(time.Minute),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.sessions = session.NewService(st, prov, s.cfg, session.WithBootProbe(s.bootProbe),
+		session.WithAlerts(s.alerts, alert.NewWindow(cfg.AlertProvisionFailures, cfg.AlertProvisionFailureWindow)))
+	separateAdmin := cfg.AdminListenAddr != ""
+	timeouts := routeTimeouts(cfg)
+	timeout := func(route string) func(http.Handler) http.Handler {
+		return timeoutFor(cfg, timeouts, route)
+	}
+	requestTimeout := timeout("default")
+
+	r := s.baseRouter(requestTimeout)
+	r.With(requestTimeout).Get("/metrics", metrics.Default().Handler().ServeHTTP)
+	if !separateAdmin {
+		s.mountDebug(r, cfg)
+	}
+
+	r.Route("/api/v1", func(v1 chi.Router) {
+		v1.Use(compressResponses)
+		v1.With(requestTimeout).Get("/openapi.json", s.handleOpenAPI)
+		v1.With(requestTimeout).Get("/errors", s.handleErrorCatalogue)
+
+		v1.With(auth.Middleware(cfg.JWTSecret)).Group(func(authed chi.Router) {
+			// AWS relay provisioning can exceed tens of seconds during EC2 launch/wait,
+			// so start gets its own (longer) budget and write deadline.
+			authed.With(extendWriteDeadline(cfg.HTTPStartTimeout), timeout("POST /api/v1/relay/start")).Post("/relay/start", s.handleRelayStart)
+			authed.With(timeout("POST /api/v1/relay/stop")).Post("/relay/stop", s.handleRelayStop)
+			authed.With(timeout("GET /api/v1/relay/active")).Get("/relay/active", s.handleRelayActive)
+			authed.With(timeout("GET /api/v1/relay/manifest")).Get("/relay/manifest", s.handleRelayManifest)
+			authed.With(timeout("GET /api/v1/usage/current")).Get("/usage/current", s.handleUsageCurrent)
+
+			authed.Group(func(fast chi.Router) {
+				fast.Use(requestTimeout)
+				fast.Post("/relay/authorize-ip", s.handleRelayAuthorizeIP)
+				fast.Get("/sessions", s.handleListSessions)
+				fast.Post("/sessions/{id}/credentials", s.handleSessionCredentials)
+				s.webhookRoutes(fast, userWebhooks)
+			})
+
+			// Server-sent events stream indefinitely, outside the request timeout.
+			authed.Get("/relay/events", s.handleRelayEvents)
+			// Data exports stream for as long as they take, like usage exports.
+			authed.Get("/me/export", s.handleUserExport)
+		})
+
+		if !separateAdmin {
+			s.mountAdmin(v1, cfg, requestTimeout)
+			s.mountInternal(v1)
+		}
+
+		v1.With(timeout("POST /api/v1/relay/health"), s.relaySharedAuth).Post("/relay/health", s.handleRelayHealth)
+		v1.With(requestTimeout, s.relaySharedAuth).Post("/relay/interruption", s.handleRelayInterruption)
+		v1.With(requestTimeout, s.relaySharedAuth).Get("/relay/session", s.handleRelaySession)
+		v1.With(timeout("GET /api/v1/relay/ping"), s.limitRelayPings, s.relaySharedAuth).Get("/relay/ping", s.handleRelayPing)
+	})
+	if !separateAdmin {
+		return r, nil
+	}
+
+	a := s.baseRouter(requestTimeout)
+	s.mountDebug(a, cfg)
+	a.Route("/api/v1", func(v1 chi.Router) {
+		v1.Use(compressResponses)
+		s.mountAdmin(v1, cfg, requestTimeout)
+		s.mountInternal(v1)
+	})
+	return r, a
+}
+
+// baseRouter returns a router with the middleware and probes every listener
+// has.
+func (s *Server) baseRouter(requestTimeout func(http.Handler) http.Handler) *chi.Mux {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Recoverer)
-	// AWS relay provisioning can exceed tens of seconds during EC2 launch/wait.
-	r.Use(middleware.Timeout(3 * time.Minute))
+	r.With(requestTimeout).Get("/healthz", s.handleHealthz)
+	r.With(requestTimeout).Get("/readyz", s.handleReadyz)
+	return r
+}
 
-	r.Get("/healthz", func(w http.ResponseWriter, _ *http.Request) {
-		writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+func (s *Server) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":     "ok",
+		"version":    version.Version,
+		"commit":     version.Commit,
+		"build_date": version.BuildDate,
+		"draining":   s.drain.Draining(),
 	})
-	r.Get("/metrics", metrics.Default().Handler().ServeHTTP)
+}
 
-	r.Route("/api/v1", func(v1 chi.Router) {
-		v1.With(auth.Middleware(cfg.JWTSecret)).Group(func(authed chi.Router) {
-			authed.Post("/relay/start", s.handleRelayStart)
-			authed.Get("/relay/active", s.handleRelayActive)
-			authed.Post("/relay/stop", s.handleRelayStop)
-			authed.Get("/relay/manifest", s.handleRelayManifest)
-			authed.Get("/usage/current", s.handleUsageCurrent)
-		})
+func (s *Server) mountDebug(r chi.Router, cfg config.Config) {
+	if cfg.EnablePprof {
+		r.With(auth.Middleware(cfg.JWTSecret), auth.RequireAdmin).Route("/debug", s.debugRoutes)
+	}
+}
 
-		v1.With(s.relaySharedAuth).Post("/relay/health", s.handleRelayHealth)
+// mountAdmin registers the admin routes under v1.
+func (s *Server) mountAdmin(v1 chi.Router, cfg config.Config, requestTimeout func(http.Handler) http.Handler) {
+	v1.With(auth.Middleware(cfg.JWTSecret), auth.RequireAdmin).Route("/admin", func(admin chi.Router) {
+		// Exports stream for as long as they take, outside the request timeout.
+		admin.Get("/usage/export", s.handleAdminUsageExport)
+		admin.Get("/users/{id}/export", s.handleAdminUserExport)
+		// Erasure rewrites all of a user's history in one transaction,
+		// bounded by the store's rollup timeout instead.
+		admin.Delete("/users/{id}/data", s.handleAdminEraseUserData)
+		admin.With(extendWriteDeadline(cfg.HTTPStartTimeout), timeoutFor(cfg, routeTimeouts(cfg), "POST /api/v1/admin/users/{id}/sessions")).
+			Post("/users/{id}/sessions", s.handleAdminStartSession)
+
+		admin.Group(func(fast chi.Router) {
+			fast.Use(requestTimeout)
+			fast.Get("/overview", s.handleAdminOverview)
+			fast.Get("/sessions", s.handleAdminSessions)
+			fast.Get("/sessions/{id}/relay", s.handleAdminSessionRelay)
+			fast.Get("/sessions/{id}/health", s.handleAdminSessionHealth)
+			fast.Post("/sessions/{id}/stop", s.handleAdminStopSession)
+			fast.Get("/users/{id}/usage", s.handleAdminUserUsage)
+			fast.Post("/users/{id}/data/erasure-token", s.handleAdminErasureToken)
+			fast.Put("/manifest/{region}", s.handleAdminSetManifestRegion)
+			fast.Patch("/relay/manifest/{region}", s.handleAdminSwitchManifestRegion)
+			fast.Post("/jobs/{name}/runs", s.handleAdminRunJob)
+			fast.Get("/jobs/runs/{id}", s.handleAdminJobRun)
+			fast.Get("/webhooks/deliveries", s.handleAdminWebhookDeliveries)
+			fast.Post("/webhooks/deliveries/{id}/replay", s.handleAdminReplayWebhookDelivery)
+			fast.Get("/billing/exports", s.handleAdminBillingExports)
+			fast.Get("/relays", s.handleAdminRelays)
+			fast.Get("/relays/quality", s.handleAdminRelayQuality)
+			s.webhookRoutes(fast, globalWebhooks)
+			if s.reloadConfig != nil {
+				fast.Post("/config/reload", s.handleConfigReload)
+			}
+		})
 	})
+}
 
-	return r
+// extendWriteDeadline lets a slow route outlive the server-wide WriteTimeout
+// so that only that route needs the long budget.
+func extendWriteDeadline(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Not every ResponseWriter supports deadlines (e.g. httptest); the
+			// server-wide WriteTimeout then still applies.
+			_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d + 5*time.Second))
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 func (s *Server) relaySharedAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Header.Get("X-Relay-Auth") != s.cfg.RelaySharedKey {
-			writeAPIError(w, http.StatusUnauthorized, "unauthorized", "invalid relay auth")
+		if r.Header.Get("X-Relay-Auth") != s.config().RelaySharedKey {
+			writeAPIError(w, apierr.Unauthorized, "invalid relay auth")
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+func (s *Server) config() config.Config {
+	return s.cfg.Get()
+}
+
 type apiError struct {
 	Error struct {
 		Code      string `json:"code"`
 		Message   string `json:"message"`
 		RequestID string `json:"request_id,omitempty"`
+		// RetryAfterSeconds mirrors the Retry-After header of 429, 503 and
+		// provisioning_in_progress responses.
+		RetryAfterSeconds int `json:"retry_after_seconds,omitempty"`
+		// SessionID is the still provisioning session of a
+		// provisioning_in_progress response.
+		SessionID string `json:"session_id,omitempty"`
 	} `json:"error"`
 }
 
-func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+// writeAPIError writes the error for code, with its catalogued status. An
+// empty message uses the code's default.
+func writeAPIError(w http.ResponseWriter, code apierr.Code, message string) {
+	writeError(w, apierr.New(code, message))
+}
+
+// writeError writes e with its status.
+func writeError(w http.ResponseWriter, e *apierr.Error) {
+	var payload apiError
+	payload.Error.Code = string(e.Code)
+	payload.Error.Message = e.Message
+	writeJSON(w, e.Status, payload)
+}
+
+// writeStoreError writes a failed store call: a 504 when the store ran out
+// of time, otherwise a 500 with message.
+func writeStoreError(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, store.ErrStoreTimeout) {
+		writeAPIError(w, apierr.StoreTimeout, "")
+		return
+	}
+	writeAPIError(w, apierr.InternalError, message)
+}
+
+// writeUnavailable writes a 503 with code as the reason. retryAfter is
+// rounded up to whole seconds; zero uses the configured default.
+func (s *Server) writeUnavailable(w http.ResponseWriter, code apierr.Code, message string, retryAfter time.Duration) {
+	if retryAfter <= 0 {
+		retryAfter = s.config().UnavailableRetryAfter
+	}
+	writeRetryAfter(w, http.StatusServiceUnavailable, code, message, retryAfter)
+}
+
+// writeRetryAfter writes the error for code with status and a Retry-After
+// of retryAfter, rounded up to whole seconds.
+func writeRetryAfter(w http.ResponseWriter, status int, code apierr.Code, message string, retryAfter time.Duration) {
+	writeJSON(w, status, retryAfterError(w, code, message, retryAfter))
+}
+
+// retryAfterError sets the Retry-After header to retryAfter, rounded up to
+// whole seconds, and returns the error body for code carrying it.
+func retryAfterError(w http.ResponseWriter, code apierr.Code, message string, retryAfter time.Duration) apiError {
+	seconds := max(int(math.Ceil(retryAfter.Seconds())), 1)
+	e := apierr.New(code, message)
 	var payload apiError
-	payload.Error.Code = code
-	payload.Error.Message = message
-	writeJSON(w, status, payload)
+	payload.Error.Code = string(e.Code)
+	payload.Error.Message = e.Message
+	payload.Error.RetryAfterSeconds = seconds
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	return payload
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
@@ -95,6 +399,19 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	_ = json.NewEncoder(w).Encode(v)
 }
 
+// parseIdempotencyKey accepts a version 4 RFC 4122 UUID in its canonical
+// 36-character form, in either case. Keys are stored as parsed, so case does
+// not distinguish them.
 func parseIdempotencyKey(h string) (uuid.UUID, error) {
-	return uuid.Parse(h)
+	if len(h) != 36 {
+		return uuid.Nil, errors.New("idempotency key must be a canonical uuid")
+	}
+	key, err := uuid.Parse(h)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if key.Version() != 4 || key.Variant() != uuid.RFC4122 {
+		return uuid.Nil, errors.New("idempotency key must be a version 4 uuid")
+	}
+	return key, nil
 }
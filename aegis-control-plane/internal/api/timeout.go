@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/config"
+)
+
+// routeTimeouts are the handler timeouts of the routes that do not use
+// AEGIS_HTTP_REQUEST_TIMEOUT, keyed by method and route pattern. Reads a
+// client polls get a few seconds, so that a slow query frees the
+// connection instead of holding it for the provisioning budget.
+func routeTimeouts(cfg config.Config) map[string]time.Duration {
+	return map[string]time.Duration{
+		"POST /api/v1/relay/start":   cfg.HTTPStartTimeout,
+		"POST /api/v1/relay/stop":    cfg.HTTPStopTimeout,
+		"GET /api/v1/relay/active":   cfg.HTTPFastTimeout,
+		"GET /api/v1/relay/manifest": cfg.HTTPFastTimeout,
+		"GET /api/v1/usage/current":  cfg.HTTPFastTimeout,
+		"POST /api/v1/relay/health":  cfg.HTTPFastTimeout,
+		"GET /api/v1/relay/ping":     cfg.HTTPFastTimeout,
+
+		"POST /api/v1/admin/users/{id}/sessions": cfg.HTTPStartTimeout,
+	}
+}
+
+// timeoutFor returns the timeout middleware of route: its entry in
+// timeouts, or AEGIS_HTTP_REQUEST_TIMEOUT.
+func timeoutFor(cfg config.Config, timeouts map[string]time.Duration, route string) func(http.Handler) http.Handler {
+	d, ok := timeouts[route]
+	if !ok {
+		d = cfg.HTTPRequestTimeout
+	}
+	return withTimeout(route, d)
+}
+
+// withTimeout bounds the handlers of route by d. A handler that runs out
+// without writing a response gets the standard 504 request_timeout error.
+// Like chi's Timeout, it relies on handlers returning once their context
+// is done.
+func withTimeout(route string, d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) || ww.Status() != 0 {
+				return
+			}
+			log.Printf("event=request_timeout route=%q timeout_ms=%d request_id=%s", route, d.Milliseconds(), middleware.GetReqID(r.Context()))
+			writeAPIError(w, apierr.RequestTimeout, "")
+		})
+	}
+}
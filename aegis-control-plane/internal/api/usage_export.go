@@ -0,0 +1,224 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+var usageExportColumns = []string{
+	"user_id", "plan_tier", "session_id", "region", "cycle_start_at", "cycle_end_at",
+	"started_at", "stopped_at", "billable_seconds", "overage_seconds", "updated_at",
+	"estimated_cost_usd", "cycle_estimated_cost_usd",
+}
+
+// usageExportFlushRows is how many rows are written between flushes.
+const usageExportFlushRows = 500
+
+// handleAdminUsageExport streams the usage records of the cycles starting on
+// cycle_start as CSV or JSON. A date selects every cycle starting that UTC
+// day; a timestamp selects cycles starting at exactly that instant.
+func (s *Server) handleAdminUsageExport(w http.ResponseWriter, r *http.Request) {
+	raw := r.URL.Query().Get("cycle_start")
+	from, to, ok := parseCycleStart(raw)
+	if !ok {
+		writeAPIError(w, apierr.InvalidRequest, "cycle_start must be a YYYY-MM-DD date or RFC3339 timestamp")
+		return
+	}
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = "csv"
+	case "csv", "json":
+	default:
+		writeAPIError(w, apierr.InvalidRequest, "format must be csv or json")
+		return
+	}
+
+	export, err := s.store.ExportUsage(r.Context(), from, to)
+	if err != nil {
+		writeAPIError(w, apierr.InternalError, "failed to export usage")
+		return
+	}
+	defer export.Close()
+
+	filename := "usage-" + from.Format("2006-01-02")
+	if to.Sub(from) < 24*time.Hour {
+		filename = "usage-" + from.Format("20060102T150405Z")
+	}
+	contentType := "application/json"
+	if format == "csv" {
+		contentType = "text/csv; charset=utf-8"
+	}
+	rc := startExport(w, contentType, filename+"."+format)
+
+	var n int
+	if format == "csv" {
+		n, err = writeUsageCSV(w, rc, export)
+	} else {
+		n, err = writeUsageJSON(w, rc, export)
+	}
+	if err != nil {
+		log.Printf("event=usage_export_failed cycle_start=%s rows=%d err=%q", raw, n, err.Error())
+		abortExport()
+	}
+	log.Printf("event=usage_export cycle_start=%s format=%s rows=%d", raw, format, n)
+}
+
+// startExport sends the headers of an export streamed as an attachment
+// named filename, and returns the controller that flushes it.
+func startExport(w http.ResponseWriter, contentType, filename string) *http.ResponseController {
+	rc := http.NewResponseController(w)
+	// Large exports outlive the server-wide WriteTimeout; not every
+	// ResponseWriter supports deadlines.
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	return rc
+}
+
+// abortExport ends an export that failed after startExport. The status is
+// already sent, so it aborts the connection and the client sees a truncated
+// response instead of a short, complete-looking export.
+func abortExport() {
+	panic(http.ErrAbortHandler)
+}
+
+func parseCycleStart(raw string) (time.Time, time.Time, bool) {
+	if day, err := time.Parse(time.DateOnly, raw); err == nil {
+		return day, day.AddDate(0, 0, 1), true
+	}
+	if ts, err := time.Parse(time.RFC3339, raw); err == nil {
+		ts = ts.UTC()
+		return ts, ts.Add(time.Second), true
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+func usageExportRecord(row model.UsageExportRow) []string {
+	stoppedAt := ""
+	if row.StoppedAt != nil {
+		stoppedAt = row.StoppedAt.UTC().Format(time.RFC3339)
+	}
+	return []string{
+		row.UserID, row.PlanTier, row.SessionID, row.Region,
+		row.CycleStart.UTC().Format(time.RFC3339), row.CycleEnd.UTC().Format(time.RFC3339),
+		row.StartedAt.UTC().Format(time.RFC3339), stoppedAt,
+		strconv.Itoa(row.BillableSeconds), strconv.Itoa(row.OverageSeconds),
+		row.UpdatedAt.UTC().Format(time.RFC3339),
+		formatCost(row.EstimatedCostUSD), formatCost(row.CycleEstimatedCostUSD),
+	}
+}
+
+// formatCost renders an estimated cost in USD, "" when there is none.
+func formatCost(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+// writeUsageCSV writes an RFC 4180 CSV with CRLF line endings, as
+// spreadsheet tools expect.
+func writeUsageCSV(w http.ResponseWriter, rc *http.ResponseController, export store.UsageExport) (int, error) {
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+	if err := cw.Write(usageExportColumns); err != nil {
+		return 0, err
+	}
+	n := 0
+	for export.Next() {
+		if err := cw.Write(usageExportRecord(export.Row())); err != nil {
+			return n, err
+		}
+		n++
+		if n%usageExportFlushRows == 0 {
+			cw.Flush()
+			_ = rc.Flush()
+		}
+	}
+	if err := export.Err(); err != nil {
+		return n, err
+	}
+	cw.Flush()
+	return n, cw.Error()
+}
+
+type usageExportJSON struct {
+	UserID          string  `json:"user_id"`
+	PlanTier        string  `json:"plan_tier"`
+	SessionID       string  `json:"session_id"`
+	Region          string  `json:"region"`
+	CycleStartAt    string  `json:"cycle_start_at"`
+	CycleEndAt      string  `json:"cycle_end_at"`
+	StartedAt       string  `json:"started_at"`
+	StoppedAt       *string `json:"stopped_at"`
+	BillableSeconds int     `json:"billable_seconds"`
+	OverageSeconds  int     `json:"overage_seconds"`
+	UpdatedAt       string  `json:"updated_at"`
+	// Estimated relay costs in USD, left out when the session has none.
+	EstimatedCostUSD      *float64 `json:"estimated_cost_usd,omitempty"`
+	CycleEstimatedCostUSD *float64 `json:"cycle_estimated_cost_usd,omitempty"`
+}
+
+func toUsageExportJSON(row model.UsageExportRow) usageExportJSON {
+	rec := usageExportJSON{
+		UserID:          row.UserID,
+		PlanTier:        row.PlanTier,
+		SessionID:       row.SessionID,
+		Region:          row.Region,
+		CycleStartAt:    row.CycleStart.UTC().Format(time.RFC3339),
+		CycleEndAt:      row.CycleEnd.UTC().Format(time.RFC3339),
+		StartedAt:       row.StartedAt.UTC().Format(time.RFC3339),
+		BillableSeconds: row.BillableSeconds,
+		OverageSeconds:  row.OverageSeconds,
+		UpdatedAt:       row.UpdatedAt.UTC().Format(time.RFC3339),
+
+		EstimatedCostUSD:      row.EstimatedCostUSD,
+		CycleEstimatedCostUSD: row.CycleEstimatedCostUSD,
+	}
+	if row.StoppedAt != nil {
+		v := row.StoppedAt.UTC().Format(time.RFC3339)
+		rec.StoppedAt = &v
+	}
+	return rec
+}
+
+// writeUsageJSON writes {"records": [...]}, one record at a time.
+func writeUsageJSON(w http.ResponseWriter, rc *http.ResponseController, export store.UsageExport) (int, error) {
+	if _, err := io.WriteString(w, `{"records":[`); err != nil {
+		return 0, err
+	}
+	n := 0
+	for export.Next() {
+		b, err := json.Marshal(toUsageExportJSON(export.Row()))
+		if err != nil {
+			return n, err
+		}
+		if n > 0 {
+			b = append([]byte{','}, b...)
+		}
+		if _, err := w.Write(b); err != nil {
+			return n, err
+		}
+		n++
+		if n%usageExportFlushRows == 0 {
+			_ = rc.Flush()
+		}
+	}
+	if err := export.Err(); err != nil {
+		return n, err
+	}
+	_, err := io.WriteString(w, "]}\n")
+	return n, err
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/auth"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+// userExportInterval is how often users may export their own data.
+const userExportInterval = time.Hour
+
+// handleUserExport streams the caller's own data.
+func (s *Server) handleUserExport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		writeAPIError(w, apierr.Unauthorized, "missing user identity")
+		return
+	}
+	s.writeUserExport(w, r, userID, userID, userExportInterval)
+}
+
+// handleAdminUserExport streams a user's data for support, without the
+// hourly limit.
+func (s *Server) handleAdminUserExport(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := auth.UserIDFromContext(r.Context())
+	s.writeUserExport(w, r, chi.URLParam(r, "id"), adminID, 0)
+}
+
+type userExportSession struct {
+	SessionID       string  `json:"session_id"`
+	Status          string  `json:"status"`
+	Region          string  `json:"region"`
+	StartedAt       string  `json:"started_at"`
+	StoppedAt       *string `json:"stopped_at"`
+	DurationSeconds int     `json:"duration_seconds"`
+}
+
+type userExportEvent struct {
+	EventID   int64           `json:"event_id"`
+	SessionID string          `json:"session_id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt string          `json:"created_at"`
+}
+
+// writeUserExport streams userID's sessions, usage records and session
+// events as one JSON document, reading each section as it is written.
+func (s *Server) writeUserExport(w http.ResponseWriter, r *http.Request, userID, requestedBy string, every time.Duration) {
+	generatedAt, err := s.store.RecordUserDataExport(r.Context(), userID, requestedBy, every)
+	if err != nil {
+		var limited *store.ExportLimitedError
+		switch {
+		case errors.As(err, &limited):
+			writeRetryAfter(w, http.StatusTooManyRequests, apierr.RateLimited, "data can be exported once an hour", limited.RetryAfter)
+		case errors.Is(err, store.ErrNotFound):
+			writeAPIError(w, apierr.NotFound, "user not found")
+		default:
+			writeStoreError(w, err, "failed to export data")
+		}
+		return
+	}
+	// The first section is opened before the status is sent, so that an
+	// unavailable database still gets an error response.
+	sessions, err := s.store.ExportUserSessions(r.Context(), userID)
+	if err != nil {
+		writeStoreError(w, err, "failed to export data")
+		return
+	}
+	defer sessions.Close()
+
+	rc := startExport(w, "application/json", "aegis-export-"+generatedAt.UTC().Format("20060102T150405Z")+".json")
+
+	counts, err := s.streamUserExport(r.Context(), w, rc, userID, generatedAt, sessions)
+	if err != nil {
+		log.Printf("event=user_data_export_failed user_id=%s requested_by=%s rows=%v err=%q", userID, requestedBy, counts, err.Error())
+		abortExport()
+	}
+	log.Printf("event=user_data_export user_id=%s requested_by=%s sessions=%d usage_records=%d session_events=%d",
+		userID, requestedBy, counts[0], counts[1], counts[2])
+}
+
+// streamUserExport writes the document and returns how many sessions, usage
+// records and session events it wrote.
+func (s *Server) streamUserExport(ctx context.Context, w io.Writer, rc *http.ResponseController, userID string, generatedAt time.Time, sessions store.RowIterator[model.Session]) ([3]int, error) {
+	var counts [3]int
+	head, err := json.Marshal(map[string]string{"user_id": userID, "generated_at": generatedAt.UTC().Format(time.RFC3339)})
+	if err != nil {
+		return counts, err
+	}
+	// Drop the closing brace so the sections follow in the same object.
+	if _, err := w.Write(head[:len(head)-1]); err != nil {
+		return counts, err
+	}
+
+	counts[0], err = writeExportSection(w, rc, "sessions", sessions, func(sess model.Session) any {
+		rec := userExportSession{
+			SessionID:       sess.ID,
+			Status:          string(sess.Status),
+			Region:          sess.Region,
+			StartedAt:       sess.StartedAt.UTC().Format(time.RFC3339),
+			DurationSeconds: sess.DurationSeconds,
+		}
+		if sess.StoppedAt != nil {
+			v := sess.StoppedAt.UTC().Format(time.RFC3339)
+			rec.StoppedAt = &v
+		}
+		return rec
+	})
+	if err != nil {
+		return counts, err
+	}
+	sessions.Close()
+
+	usage, err := s.store.ExportUserUsage(ctx, userID)
+	if err != nil {
+		return counts, err
+	}
+	defer usage.Close()
+	counts[1], err = writeExportSection(w, rc, "usage_records", usage, func(row model.UsageExportRow) any {
+		return toUsageExportJSON(row)
+	})
+	if err != nil {
+		return counts, err
+	}
+	usage.Close()
+
+	events, err := s.store.ExportUserSessionEvents(ctx, userID)
+	if err != nil {
+		return counts, err
+	}
+	defer events.Close()
+	counts[2], err = writeExportSection(w, rc, "session_events", events, func(e model.SessionEvent) any {
+		return userExportEvent{EventID: e.ID, SessionID: e.SessionID, Type: e.Type, Payload: e.Payload, CreatedAt: e.CreatedAt.UTC().Format(time.RFC3339)}
+	})
+	if err != nil {
+		return counts, err
+	}
+	_, err = io.WriteString(w, "}\n")
+	return counts, err
+}
+
+// writeExportSection writes `,"key":[...]` from it, one row at a time.
+func writeExportSection[T any](w io.Writer, rc *http.ResponseController, key string, it store.RowIterator[T], encode func(T) any) (int, error) {
+	if _, err := fmt.Fprintf(w, `,%q:[`, key); err != nil {
+		return 0, err
+	}
+	n := 0
+	for it.Next() {
+		b, err := json.Marshal(encode(it.Row()))
+		if err != nil {
+			return n, err
+		}
+		if n > 0 {
+			b = append([]byte{','}, b...)
+		}
+		if _, err := w.Write(b); err != nil {
+			return n, err
+		}
+		n++
+		if n%usageExportFlushRows == 0 {
+			_ = rc.Flush()
+		}
+	}
+	if err := it.Err(); err != nil {
+		return n, err
+	}
+	_, err := io.WriteString(w, "]")
+	return n, err
+}
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api/apierr"
+	"github.com/telemyapp/aegis-control-plane/internal/auth"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+// maxWebhooksPerOwner caps the webhooks of one user, and the global ones.
+const maxWebhooksPerOwner = 10
+
+// webhookOwner resolves whose webhooks a request manages: the caller's, or
+// "" for the global webhooks on admin routes.
+type webhookOwner func(*http.Request) (string, bool)
+
+func userWebhooks(r *http.Request) (string, bool) {
+	return auth.UserIDFromContext(r.Context())
+}
+
+func globalWebhooks(*http.Request) (string, bool) {
+	return "", true
+}
+
+// webhookRoutes mounts webhook CRUD for owner.
+func (s *Server) webhookRoutes(r chi.Router, owner webhookOwner) {
+	r.Get("/webhooks", s.withWebhookOwner(owner, s.handleListWebhooks))
+	r.Post("/webhooks", s.withWebhookOwner(owner, s.handleCreateWebhook))
+	r.Get("/webhooks/{id}", s.withWebhookOwner(owner, s.handleGetWebhook))
+	r.Put("/webhooks/{id}", s.withWebhookOwner(owner, s.handleUpdateWebhook))
+	r.Delete("/webhooks/{id}", s.withWebhookOwner(owner, s.handleDeleteWebhook))
+}
+
+func (s *Server) withWebhookOwner(owner webhookOwner, h func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ownerID, ok := owner(r)
+		if !ok {
+			writeAPIError(w, apierr.Unauthorized, "missing user identity")
+			return
+		}
+		h(w, r, ownerID)
+	}
+}
+
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// validate checks the request and reports a client-facing message.
+func (req webhookRequest) validate() string {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || len(req.URL) > 2048 {
+		return "url must be an absolute http(s) URL"
+	}
+	for _, ev := range req.Events {
+		if !slices.Contains(model.WebhookEvents, ev) {
+			return "unknown event " + strconv.Quote(ev)
+		}
+	}
+	if req.Secret != "" && len(req.Secret) < 16 {
+		return "secret must be at least 16 characters"
+	}
+	return ""
+}
+
+func decodeWebhookRequest(w http.ResponseWriter, r *http.Request) (webhookRequest, bool) {
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, apierr.InvalidRequest, "invalid json body")
+		return req, false
+	}
+	if msg := req.validate(); msg != "" {
+		writeAPIError(w, apierr.InvalidRequest, msg)
+		return req, false
+	}
+	return req, true
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+func toWebhookResponse(wh *model.Webhook) map[string]any {
+	events := wh.Events
+	if events == nil {
+		events = []string{}
+	}
+	return map[string]any{
+		"webhook_id": wh.ID,
+		"url":        wh.URL,
+		"events":     events,
+		"created_at": wh.CreatedAt.UTC().Format(time.RFC3339),
+		"updated_at": wh.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+func (s *Server) handleListWebhooks(w http.ResponseWriter, r *http.Request, ownerID string) {
+	hooks, err := s.store.ListWebhooks(r.Context(), ownerID)
+	if err != nil {
+		writeStoreError(w, err, "failed to list webhooks")
+		return
+	}
+	out := make([]map[string]any, 0, len(hooks))
+	for i := range hooks {
+		out = append(out, toWebhookResponse(&hooks[i]))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"webhooks": out})
+}
+
+// handleCreateWebhook returns the signing secret, generated unless the
+// caller supplied one; it is not shown again.
+func (s *Server) handleCreateWebhook(w http.ResponseWriter, r *http.Request, ownerID string) {
+	req, ok := decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+	existing, err := s.store.ListWebhooks(r.Context(), ownerID)
+	if err != nil {
+		writeStoreError(w, err, "failed to list webhooks")
+		return
+	}
+	if len(existing) >= maxWebhooksPerOwner {
+		writeAPIError(w, apierr.WebhookLimit, "webhook limit reached")
+		return
+	}
+	if req.Secret == "" {
+		if req.Secret, err = newWebhookSecret(); err != nil {
+			writeAPIError(w, apierr.InternalError, "failed to generate webhook secret")
+			return
+		}
+	}
+	wh, err := s.store.CreateWebhook(r.Context(), model.Webhook{UserID: ownerID, URL: req.URL, Secret: req.Secret, Events: req.Events})
+	if err != nil {
+		writeStoreError(w, err, "failed to create webhook")
+		return
+	}
+	resp := toWebhookResponse(wh)
+	resp["secret"] = wh.Secret
+	w.Header().Set("Location", s.externalURL(r, r.URL.Path+"/"+wh.ID))
+	writeJSON(w, http.StatusCreated, map[string]any{"webhook": resp})
+}
+
+func (s *Server) handleGetWebhook(w http.ResponseWriter, r *http.Request, ownerID string) {
+	wh, err := s.store.GetWebhook(r.Context(), ownerID, chi.URLParam(r, "id"))
+	if err != nil {
+		writeWebhookStoreError(w, err, "failed to load webhook")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"webhook": toWebhookResponse(wh)})
+}
+
+// handleUpdateWebhook replaces the URL and events; the secret changes only
+// when one is given.
+func (s *Server) handleUpdateWebhook(w http.ResponseWriter, r *http.Request, ownerID string) {
+	req, ok := decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+	wh, err := s.store.UpdateWebhook(r.Context(), model.Webhook{ID: chi.URLParam(r, "id"), UserID: ownerID, URL: req.URL, Secret: req.Secret, Events: req.Events})
+	if err != nil {
+		writeWebhookStoreError(w, err, "failed to update webhook")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"webhook": toWebhookResponse(wh)})
+}
+
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request, ownerID string) {
+	if err := s.store.DeleteWebhook(r.Context(), ownerID, chi.URLParam(r, "id")); err != nil {
+		writeWebhookStoreError(w, err, "failed to delete webhook")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeWebhookStoreError(w http.ResponseWriter, err error, msg string) {
+	if errors.Is(err, store.ErrNotFound) {
+		writeAPIError(w, apierr.NotFound, "webhook not found")
+		return
+	}
+	writeStoreError(w, err, msg)
+}
+
+// handleAdminWebhookDeliveries lists deliveries by status, dead-lettered
+// ones by default.
+func (s *Server) handleAdminWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = model.WebhookDeliveryDead
+	case model.WebhookDeliveryPending, model.WebhookDeliveryDelivered, model.WebhookDeliveryDead:
+	default:
+		writeAPIError(w, apierr.InvalidRequest, "unknown status filter")
+		return
+	}
+	limit := 50
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 500 {
+			writeAPIError(w, apierr.InvalidRequest, "limit must be between 1 and 500")
+			return
+		}
+		limit = n
+	}
+	deliveries, err := s.store.ListWebhookDeliveries(r.Context(), status, limit)
+	if err != nil {
+		writeStoreError(w, err, "failed to list webhook deliveries")
+		return
+	}
+	out := make([]map[string]any, 0, len(deliveries))
+	for _, d := range deliveries {
+		out = append(out, map[string]any{
+			"delivery_id":      d.ID,
+			"webhook_id":       d.WebhookID,
+			"url":              d.URL,
+			"event":            d.EventType,
+			"status":           d.Status,
+			"attempts":         d.Attempts,
+			"last_status_code": d.LastStatusCode,
+			"last_error":       d.LastError,
+			"payload":          d.Payload,
+			"created_at":       d.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"deliveries": out})
+}
+
+// handleAdminReplayWebhookDelivery queues a dead-lettered delivery again.
+func (s *Server) handleAdminReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, apierr.InvalidRequest, "invalid delivery id")
+		return
+	}
+	if err := s.store.ReplayWebhookDelivery(r.Context(), id); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeAPIError(w, apierr.NotFound, "no dead-lettered delivery with that id")
+			return
+		}
+		writeStoreError(w, err, "failed to replay webhook delivery")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{"delivery_id": id, "status": model.WebhookDeliveryPending})
+}
@@ -11,10 +11,16 @@ import (
 
 type contextKey string
 
-const userIDKey contextKey = "user_id"
+const (
+	userIDKey contextKey = "user_id"
+	roleKey   contextKey = "role"
+)
+
+const RoleAdmin = "admin"
 
 type Claims struct {
 	UserID string `json:"uid"`
+	Role   string `json:"role,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -41,6 +47,7 @@ func Middleware(secret string) func(http.Handler) http.Handler {
 			}
 
 			ctx := context.WithValue(r.Context(), userIDKey, claims.UserID)
+			ctx = context.WithValue(ctx, roleKey, claims.Role)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -51,3 +58,20 @@ func UserIDFromContext(ctx context.Context) (string, bool) {
 	s, ok := v.(string)
 	return s, ok && s != ""
 }
+
+// RequireAdmin must run after Middleware and rejects tokens without the admin
+// role.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsAdmin(r.Context()) {
+			http.Error(w, `{"error":{"code":"forbidden","message":"admin role required"}}`, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func IsAdmin(ctx context.Context) bool {
+	role, _ := ctx.Value(roleKey).(string)
+	return role == RoleAdmin
+}
@@ -1,41 +1,514 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"maps"
+	"math"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/alert"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+	"github.com/telemyapp/aegis-control-plane/internal/version"
+)
+
+// relayProviders are the accepted AEGIS_RELAY_PROVIDER values.
+var relayProviders = []string{"fake", "aws", "docker", "hetzner"}
+
+var (
+	subnetIDPattern           = regexp.MustCompile(`^subnet-[0-9a-f]{8,17}$`)
+	securityGroupIDPattern    = regexp.MustCompile(`^sg-[0-9a-f]{8,17}$`)
+	instanceProfileARNPattern = regexp.MustCompile(`^arn:aws[a-z-]*:iam::[0-9]{12}:instance-profile/.+$`)
 )
 
 type Config struct {
-	ListenAddr      string
+	ListenAddr string
+	// AdminListenAddr, when set, serves the admin routes on a listener of
+	// their own instead of ListenAddr.
+	AdminListenAddr string
 	DatabaseURL     string
 	JWTSecret       string
 	RelaySharedKey  string
+	// InternalToken authenticates internal consumers, such as analytics,
+	// of /api/v1/internal; unset, those routes refuse every request.
+	InternalToken   string
 	DefaultRegion   string
 	SupportedRegion []string
 	RelayProvider   string
-	AWSAMIMap       map[string]string
-	AWSInstanceType string
-	AWSSubnetID     string
-	AWSSecurityIDs  []string
-	AWSKeyName      string
+	// RelayControlPlaneURL is the base URL relays call back to; it is passed
+	// to each relay in its bootstrap user data.
+	RelayControlPlaneURL string
+	AWSAMIMap            map[string]string
+	AWSInstanceType      string
+	AWSSubnetID          string
+	AWSSecurityIDs       []string
+	AWSKeyName           string
+	AWSVerifyAMIs        bool
+	// AWSInstanceProfileARN is attached to launched relays when set.
+	AWSInstanceProfileARN string
+
+	AWSProvisionWaitTimeout  time.Duration
+	AWSProvisionPollInterval time.Duration
+	AWSFallbackTypes         map[string][]string
+	AWSFallbackRegions       []string
+	AWSUseSpot               bool
+	// AWSEIPPool lists Elastic IP allocation IDs per region for relays
+	// started with a static IP.
+	AWSEIPPool map[string][]string
+	// AWSSubnetIDs spreads relays across subnets (AZs) per region; regions
+	// without an entry use AWSSubnetID.
+	AWSSubnetIDs map[string][]string
+	// AWSSessionSecurityGroups gives each relay its own security group
+	// admitting only the streamer's IP, in place of AWSSecurityIDs.
+	AWSSessionSecurityGroups bool
+	// AWSWarmPoolSize is the number of stopped relays kept ready per region;
+	// pool instances older than AWSWarmPoolMaxAge are replaced.
+	AWSWarmPoolSize   map[string]int
+	AWSWarmPoolMaxAge time.Duration
+	// AWSTerminateVerifyTimeout bounds the wait for a terminated relay to
+	// reach terminated; unset leaves confirmation to the orphan reaper.
+	AWSTerminateVerifyTimeout time.Duration
+	// AWSBreakerFailureThreshold consecutive failures of an EC2 operation in
+	// a region open its circuit for AWSBreakerCooldown.
+	AWSBreakerFailureThreshold int
+	AWSBreakerCooldown         time.Duration
+	// AWSRetryPolicies bounds retries of transient AWS errors per operation,
+	// with a "default" entry for the rest; AWSRetryBudget caps retries per
+	// minute across all operations.
+	AWSRetryPolicies map[string]AWSRetryPolicy
+	AWSRetryBudget   int
+
+	// Fake* make the fake provider slow or unreliable for load and chaos
+	// testing: Provision takes FakeProvisionLatencyMin-Max, fails the first
+	// FakeFailFirst calls and then FakeFailureRate of them, returning
+	// FakeErrorCodes in turn.
+	FakeProvisionLatencyMin time.Duration
+	FakeProvisionLatencyMax time.Duration
+	FakeFailureRate         float64
+	FakeErrorCodes          []string
+	FakeFailFirst           int
+
+	// Docker* configure the docker provider, which runs relays as local
+	// containers published on DockerPortMin-DockerPortMax.
+	DockerHost       string
+	DockerRelayImage string
+	DockerPortMin    int
+	DockerPortMax    int
+
+	// Hetzner* configure the hetzner provider. Images and server types are
+	// per region; HetznerLocations maps regions to Hetzner locations.
+	HetznerToken                string
+	HetznerImageMap             map[string]string
+	HetznerServerType           string
+	HetznerServerTypes          map[string]string
+	HetznerLocations            map[string]string
+	HetznerSSHKeys              []string
+	HetznerProvisionWaitTimeout time.Duration
+
+	// RegionProviders runs the listed regions on another provider than
+	// RelayProvider, which serves the rest.
+	RegionProviders map[string]string
+
+	// RelayBootProbe holds relay start until the new relay accepts
+	// connections, for up to RelayBootProbeTimeout.
+	RelayBootProbe        bool
+	RelayBootProbeTimeout time.Duration
+
+	// RelayWSTemplate renders the telemetry URL handed to clients. With
+	// RelayDNSRecords, AWS relays get its host name published as a record in
+	// the Route53 zone RelayDNSZoneID while they run.
+	RelayWSTemplate relay.WSTemplate
+	RelayDNSRecords bool
+	RelayDNSZoneID  string
+
+	// RelaySRTPortCount SRT listeners are bound by each relay, on the lowest
+	// ports of RelaySRTPortRange.
+	RelaySRTPortRange relay.PortRange
+	RelaySRTPortCount int
+
+	// RelayMinAgentVersion and RelayHeartbeatInterval are served to relay
+	// agents by GET /relay/ping: the oldest agent release still supported
+	// ("" for none) and how often agents should report health.
+	// RelayPingRateLimit caps pings per client IP per minute.
+	RelayMinAgentVersion   string
+	RelayHeartbeatInterval time.Duration
+	RelayPingRateLimit     int
+
+	// RelayHourlyPrices are the hourly USD prices session costs are
+	// estimated with, keyed by instance type or by region/instance type for
+	// a region's own price. Types without a price are estimated at
+	// RelayDefaultHourlyPrice. Without either, costs are not estimated.
+	RelayHourlyPrices       map[string]float64
+	RelayDefaultHourlyPrice float64
+
+	// UnavailableRetryAfter is the Retry-After sent with 503 responses that
+	// have no better estimate.
+	UnavailableRetryAfter time.Duration
+
+	// ProvisionConcurrency caps the relay starts provisioning at once in each
+	// region; 0 lifts the cap. Starts over the cap wait up to
+	// ProvisionQueueTimeout for a slot and are then refused with a 503.
+	ProvisionConcurrency  int
+	ProvisionQueueTimeout time.Duration
+
+	// IdempotencyMaxPerUser keeps each user's most recent idempotency
+	// records per endpoint; 0 keeps them all until they expire.
+	IdempotencyMaxPerUser int
+
+	PairTokenLength int
+	// MaskSessionCredentials masks pair and relay tokens in session reads
+	// other than relay start; clients fetch them from the credentials
+	// endpoint instead. Off by default until clients have migrated.
+	MaskSessionCredentials bool
+	// DisableSessionClientInfo stops recording the client IP and user agent
+	// that started each session, for privacy-sensitive deployments.
+	DisableSessionClientInfo bool
+	// AdminMaxLiveSessions caps the live sessions admins have started on
+	// users' behalf, across all users.
+	AdminMaxLiveSessions int
+
+	// FreeIncludedSeconds is the allowance given to users who have no plan
+	// configured when their first free cycle starts.
+	FreeIncludedSeconds int
+	// UsageAlertThresholds are the percentages of included seconds at which
+	// users are warned, ascending.
+	UsageAlertThresholds []int
+
+	// WebhookMaxAttempts is how many times the jobs worker tries a webhook
+	// delivery before dead-lettering it.
+	WebhookMaxAttempts int
+	WebhookTimeout     time.Duration
+
+	// StripeAPIKey enables reporting cycle overage to Stripe from the jobs
+	// worker; StripeMaxAttempts bounds the reports of each cycle before it
+	// is parked as failed.
+	StripeAPIKey      string
+	StripeMaxAttempts int
+
+	// TerminatorWorkers is how many relay terminations the jobs worker runs
+	// at once.
+	TerminatorWorkers int
+
+	// HealthEventRetention is how long the jobs worker keeps relay health
+	// events before purging them.
+	HealthEventRetention time.Duration
+	// SessionChangeRetention is how long the jobs worker keeps changefeed
+	// rows no consumer has acknowledged.
+	SessionChangeRetention time.Duration
+
+	// CanaryInterval, when set, has the jobs worker start, verify and stop
+	// a synthetic session in each of CanaryRegions that often. Canary relays
+	// launch on CanaryInstanceType where the provider has instance types;
+	// CanaryWebhook reports failed runs to global webhooks.
+	CanaryInterval     time.Duration
+	CanaryRegions      []string
+	CanaryInstanceType string
+	CanaryWebhook      bool
+
+	// AlertSlackWebhookURL and AlertWebhookURL receive operator alerts;
+	// with neither set alerting is off. An alert repeating one sent within
+	// AlertCooldown is dropped. AlertProvisionFailures failed launches in
+	// a region within AlertProvisionFailureWindow alert, and so does a
+	// relay termination failing AlertTerminationAttempts times.
+	AlertSlackWebhookURL        string
+	AlertWebhookURL             string
+	AlertCooldown               time.Duration
+	AlertProvisionFailures      int
+	AlertProvisionFailureWindow time.Duration
+	AlertTerminationAttempts    int
+
+	StrictStartup bool
+	TLSCertFile   string
+	TLSKeyFile    string
+	// ExternalBaseURL is the control plane's public https URL, which the
+	// absolute links in responses and webhook payloads are built on. When
+	// unset and TrustForwardedProto is set, the API derives it from each
+	// request's Host and X-Forwarded-Proto; with neither, links in responses
+	// are relative and webhook payloads carry none.
+	ExternalBaseURL     string
+	TrustForwardedProto bool
+	// EnablePprof mounts pprof, expvar and build info under /debug/ for
+	// admins.
+	EnablePprof bool
+
+	HTTPReadTimeout    time.Duration
+	HTTPWriteTimeout   time.Duration
+	HTTPRequestTimeout time.Duration
+	HTTPStartTimeout   time.Duration
+	// HTTPFastTimeout bounds the reads clients poll (active session, usage,
+	// manifest) and relay heartbeats; HTTPStopTimeout bounds stops.
+	HTTPFastTimeout time.Duration
+	HTTPStopTimeout time.Duration
+	ShutdownGrace   time.Duration
+	// DrainPeriod is how long the API refuses new starts and reports not
+	// ready before it stops accepting connections.
+	DrainPeriod time.Duration
+
+	DB     DBPool
+	JobsDB DBPool
 }
 
+// DBPool sizes a pgx pool. The API and jobs binaries are configured
+// separately: jobs run few, long rollup statements.
+type DBPool struct {
+	MaxConns         int
+	MinConns         int
+	MaxConnLifetime  time.Duration
+	StatementTimeout time.Duration
+	SlowQuery        time.Duration
+	// StatementCacheCapacity is the prepared statements kept per
+	// connection.
+	StatementCacheCapacity int
+	// ReadTimeout, WriteTimeout and RollupTimeout bound each store
+	// operation, transactions included.
+	ReadTimeout   time.Duration
+	WriteTimeout  time.Duration
+	RollupTimeout time.Duration
+}
+
+// LoadFromEnv reads configuration from the process environment. When
+// AEGIS_CONFIG_FILE names a KEY=VALUE file, its entries take precedence over
+// the environment so that a running process can pick up edits on reload.
 func LoadFromEnv() (Config, error) {
+	env, err := newSource(os.Getenv("AEGIS_CONFIG_FILE"))
+	if err != nil {
+		return Config{}, err
+	}
 	cfg := Config{
-		ListenAddr:      envOrDefault("AEGIS_LISTEN_ADDR", ":8080"),
-		DatabaseURL:     os.Getenv("AEGIS_DATABASE_URL"),
-		JWTSecret:       os.Getenv("AEGIS_JWT_SECRET"),
-		RelaySharedKey:  os.Getenv("AEGIS_RELAY_SHARED_KEY"),
-		DefaultRegion:   envOrDefault("AEGIS_DEFAULT_REGION", "us-east-1"),
-		SupportedRegion: splitCSV(envOrDefault("AEGIS_SUPPORTED_REGIONS", "us-east-1,eu-west-1")),
-		RelayProvider:   envOrDefault("AEGIS_RELAY_PROVIDER", "fake"),
-		AWSAMIMap:       parseKVMap(os.Getenv("AEGIS_AWS_AMI_MAP")),
-		AWSInstanceType: envOrDefault("AEGIS_AWS_INSTANCE_TYPE", "t4g.small"),
-		AWSSubnetID:     os.Getenv("AEGIS_AWS_SUBNET_ID"),
-		AWSSecurityIDs:  splitCSV(os.Getenv("AEGIS_AWS_SECURITY_GROUP_IDS")),
-		AWSKeyName:      os.Getenv("AEGIS_AWS_KEY_NAME"),
+		ListenAddr:           env.getOrDefault("AEGIS_LISTEN_ADDR", ":8080"),
+		AdminListenAddr:      env.get("AEGIS_ADMIN_LISTEN_ADDR"),
+		DatabaseURL:          env.get("AEGIS_DATABASE_URL"),
+		JWTSecret:            env.get("AEGIS_JWT_SECRET"),
+		RelaySharedKey:       env.get("AEGIS_RELAY_SHARED_KEY"),
+		InternalToken:        env.get("AEGIS_INTERNAL_TOKEN"),
+		DefaultRegion:        env.getOrDefault("AEGIS_DEFAULT_REGION", "us-east-1"),
+		SupportedRegion:      splitCSV(env.getOrDefault("AEGIS_SUPPORTED_REGIONS", "us-east-1,eu-west-1")),
+		RelayProvider:        env.getOrDefault("AEGIS_RELAY_PROVIDER", "fake"),
+		RelayControlPlaneURL: strings.TrimRight(strings.TrimSpace(env.get("AEGIS_RELAY_CONTROL_PLANE_URL")), "/"),
+		AWSAMIMap:            parseKVMap(env.get("AEGIS_AWS_AMI_MAP")),
+		AWSInstanceType:      env.getOrDefault("AEGIS_AWS_INSTANCE_TYPE", "t4g.small"),
+		AWSSubnetID:          env.get("AEGIS_AWS_SUBNET_ID"),
+		AWSSecurityIDs:       splitCSV(env.get("AEGIS_AWS_SECURITY_GROUP_IDS")),
+		AWSKeyName:           env.get("AEGIS_AWS_KEY_NAME"),
+		AWSVerifyAMIs:        env.boolean("AEGIS_AWS_VERIFY_AMIS"),
+		// Optional; attached to every launched relay.
+		AWSInstanceProfileARN: strings.TrimSpace(env.get("AEGIS_AWS_INSTANCE_PROFILE_ARN")),
+		// AEGIS_AWS_FALLBACK_INSTANCE_TYPES=us-east-1=t4g.medium|c7g.medium,eu-west-1=t4g.medium
+		AWSFallbackTypes:   parseListMap(env.get("AEGIS_AWS_FALLBACK_INSTANCE_TYPES")),
+		AWSFallbackRegions: splitCSV(env.get("AEGIS_AWS_FALLBACK_REGIONS")),
+		AWSUseSpot:         env.boolean("AEGIS_AWS_USE_SPOT"),
+		StrictStartup:      env.boolean("AEGIS_STRICT_STARTUP"),
+		EnablePprof:        env.boolean("AEGIS_ENABLE_PPROF"),
+		TLSCertFile:        strings.TrimSpace(env.get("AEGIS_TLS_CERT_FILE")),
+		TLSKeyFile:         strings.TrimSpace(env.get("AEGIS_TLS_KEY_FILE")),
+		// Links are built on the request's Host when unset.
+		ExternalBaseURL:     strings.TrimRight(strings.TrimSpace(env.get("AEGIS_EXTERNAL_BASE_URL")), "/"),
+		TrustForwardedProto: env.boolean("AEGIS_TRUST_FORWARDED_PROTO"),
+		// AEGIS_AWS_EIP_POOL=us-east-1=eipalloc-0a|eipalloc-0b
+		AWSEIPPool: parseListMap(env.get("AEGIS_AWS_EIP_POOL")),
+		// AEGIS_AWS_SUBNET_IDS=us-east-1=subnet-0a|subnet-0b,eu-west-1=subnet-0c
+		AWSSubnetIDs: parseListMap(env.get("AEGIS_AWS_SUBNET_IDS")),
+		// Replaces AEGIS_AWS_SECURITY_GROUP_IDS for relays started with a
+		// known client IP.
+		AWSSessionSecurityGroups: env.boolean("AEGIS_AWS_SESSION_SECURITY_GROUPS"),
+
+		FakeErrorCodes: splitCSV(env.get("AEGIS_FAKE_ERROR_CODES")),
+
+		DockerHost:       env.getOrDefault("AEGIS_DOCKER_HOST", "unix:///var/run/docker.sock"),
+		DockerRelayImage: strings.TrimSpace(env.get("AEGIS_DOCKER_RELAY_IMAGE")),
+
+		StripeAPIKey: strings.TrimSpace(env.get("AEGIS_STRIPE_API_KEY")),
+
+		HetznerToken: strings.TrimSpace(env.get("AEGIS_HETZNER_TOKEN")),
+		// AEGIS_HETZNER_IMAGE_MAP=eu-central=123456,us-east=relay-snapshot
+		HetznerImageMap:    parseKVMap(env.get("AEGIS_HETZNER_IMAGE_MAP")),
+		HetznerServerType:  env.getOrDefault("AEGIS_HETZNER_SERVER_TYPE", "cx22"),
+		HetznerServerTypes: parseKVMap(env.get("AEGIS_HETZNER_SERVER_TYPE_MAP")),
+		// AEGIS_HETZNER_LOCATION_MAP=eu-central=fsn1,us-east=ash
+		HetznerLocations: parseKVMap(env.get("AEGIS_HETZNER_LOCATION_MAP")),
+		HetznerSSHKeys:   splitCSV(env.get("AEGIS_HETZNER_SSH_KEYS")),
+
+		// AEGIS_REGION_PROVIDER_MAP=us-east-1=aws,eu-central=hetzner
+		RegionProviders: parseKVMap(env.get("AEGIS_REGION_PROVIDER_MAP")),
+
+		RelayBootProbe: env.boolean("AEGIS_RELAY_BOOT_PROBE"),
+
+		RelayDNSRecords: env.boolean("AEGIS_RELAY_DNS_RECORDS"),
+		RelayDNSZoneID:  strings.TrimSpace(env.get("AEGIS_RELAY_DNS_ZONE_ID")),
+
+		RelayMinAgentVersion: strings.TrimSpace(env.get("AEGIS_RELAY_MIN_AGENT_VERSION")),
+
+		MaskSessionCredentials:   env.boolean("AEGIS_MASK_SESSION_CREDENTIALS"),
+		DisableSessionClientInfo: env.boolean("AEGIS_DISABLE_SESSION_CLIENT_INFO"),
+
+		CanaryRegions:      splitCSV(env.get("AEGIS_CANARY_REGIONS")),
+		CanaryInstanceType: strings.TrimSpace(env.get("AEGIS_CANARY_INSTANCE_TYPE")),
+		CanaryWebhook:      env.boolean("AEGIS_CANARY_WEBHOOK"),
+
+		AlertSlackWebhookURL: strings.TrimSpace(env.get("AEGIS_ALERT_SLACK_WEBHOOK_URL")),
+		AlertWebhookURL:      strings.TrimSpace(env.get("AEGIS_ALERT_WEBHOOK_URL")),
+	}
+
+	durations := []struct {
+		key string
+		def time.Duration
+		dst *time.Duration
+	}{
+		{"AEGIS_HTTP_READ_TIMEOUT", 30 * time.Second, &cfg.HTTPReadTimeout},
+		// Relay provisioning in AWS mode may take >15s before the handler writes a response.
+		{"AEGIS_HTTP_WRITE_TIMEOUT", 3 * time.Minute, &cfg.HTTPWriteTimeout},
+		{"AEGIS_HTTP_REQUEST_TIMEOUT", 3 * time.Minute, &cfg.HTTPRequestTimeout},
+		{"AEGIS_HTTP_START_TIMEOUT", 3 * time.Minute, &cfg.HTTPStartTimeout},
+		{"AEGIS_HTTP_FAST_TIMEOUT", 5 * time.Second, &cfg.HTTPFastTimeout},
+		{"AEGIS_HTTP_STOP_TIMEOUT", 30 * time.Second, &cfg.HTTPStopTimeout},
+		{"AEGIS_SHUTDOWN_GRACE", 10 * time.Second, &cfg.ShutdownGrace},
+		{"AEGIS_DRAIN_PERIOD", 15 * time.Second, &cfg.DrainPeriod},
+		{"AEGIS_AWS_PROVISION_WAIT_TIMEOUT", 2 * time.Minute, &cfg.AWSProvisionWaitTimeout},
+		{"AEGIS_AWS_PROVISION_POLL_INTERVAL", 15 * time.Second, &cfg.AWSProvisionPollInterval},
+		{"AEGIS_AWS_WARM_POOL_MAX_AGE", 24 * time.Hour, &cfg.AWSWarmPoolMaxAge},
+		{"AEGIS_AWS_TERMINATE_VERIFY_TIMEOUT", 0, &cfg.AWSTerminateVerifyTimeout},
+		{"AEGIS_AWS_BREAKER_COOLDOWN", 30 * time.Second, &cfg.AWSBreakerCooldown},
+		{"AEGIS_HETZNER_PROVISION_WAIT_TIMEOUT", 2 * time.Minute, &cfg.HetznerProvisionWaitTimeout},
+		{"AEGIS_RELAY_BOOT_PROBE_TIMEOUT", 45 * time.Second, &cfg.RelayBootProbeTimeout},
+		{"AEGIS_RELAY_HEARTBEAT_INTERVAL", 30 * time.Second, &cfg.RelayHeartbeatInterval},
+		{"AEGIS_UNAVAILABLE_RETRY_AFTER", 30 * time.Second, &cfg.UnavailableRetryAfter},
+		{"AEGIS_PROVISION_QUEUE_TIMEOUT", 20 * time.Second, &cfg.ProvisionQueueTimeout},
+		{"AEGIS_WEBHOOK_TIMEOUT", 10 * time.Second, &cfg.WebhookTimeout},
+		{"AEGIS_HEALTH_EVENT_RETENTION", 30 * 24 * time.Hour, &cfg.HealthEventRetention},
+		{"AEGIS_SESSION_CHANGE_RETENTION", 7 * 24 * time.Hour, &cfg.SessionChangeRetention},
+		{"AEGIS_CANARY_INTERVAL", 0, &cfg.CanaryInterval},
+		{"AEGIS_ALERT_COOLDOWN", time.Hour, &cfg.AlertCooldown},
+		{"AEGIS_ALERT_PROVISION_FAILURE_WINDOW", 10 * time.Minute, &cfg.AlertProvisionFailureWindow},
+	}
+	for _, d := range durations {
+		v, err := env.duration(d.key, d.def)
+		if err != nil {
+			return Config{}, err
+		}
+		*d.dst = v
+	}
+	// AEGIS_AWS_WARM_POOL_SIZE=us-east-1=2,eu-west-1=1
+	if cfg.AWSWarmPoolSize, err = parseIntMap("AEGIS_AWS_WARM_POOL_SIZE", env.get("AEGIS_AWS_WARM_POOL_SIZE")); err != nil {
+		return Config{}, err
+	}
+	if cfg.AWSBreakerFailureThreshold, err = env.integer("AEGIS_AWS_BREAKER_FAILURE_THRESHOLD", 5, 1); err != nil {
+		return Config{}, err
+	}
+	// AEGIS_AWS_RETRY_POLICIES=default=4|250ms|2s,run_instances=6|500ms|4s
+	if cfg.AWSRetryPolicies, err = parseRetryPolicies("AEGIS_AWS_RETRY_POLICIES", env.get("AEGIS_AWS_RETRY_POLICIES")); err != nil {
+		return Config{}, err
+	}
+	if cfg.AWSRetryBudget, err = env.integer("AEGIS_AWS_RETRY_BUDGET", 100, 1); err != nil {
+		return Config{}, err
+	}
+	// The fake provider makes no API calls, so it is not capped by default.
+	provisionConcurrency := 0
+	if slices.ContainsFunc(cfg.Providers(), func(p string) bool { return p != "fake" }) {
+		provisionConcurrency = 10
+	}
+	if cfg.ProvisionConcurrency, err = env.integer("AEGIS_PROVISION_CONCURRENCY", provisionConcurrency, 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.IdempotencyMaxPerUser, err = env.integer("AEGIS_IDEMPOTENCY_MAX_PER_USER", 100, 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.RelayPingRateLimit, err = env.integer("AEGIS_RELAY_PING_RATE_LIMIT", 60, 1); err != nil {
+		return Config{}, err
+	}
+	if cfg.PairTokenLength, err = env.integer("AEGIS_PAIR_TOKEN_LENGTH", 8, 6); err != nil {
+		return Config{}, err
+	}
+	if cfg.AdminMaxLiveSessions, err = env.integer("AEGIS_ADMIN_MAX_LIVE_SESSIONS", 3, 1); err != nil {
+		return Config{}, err
+	}
+	if cfg.FreeIncludedSeconds, err = env.integer("AEGIS_FREE_INCLUDED_SECONDS", 3600, 0); err != nil {
+		return Config{}, err
+	}
+	if cfg.WebhookMaxAttempts, err = env.integer("AEGIS_WEBHOOK_MAX_ATTEMPTS", 8, 1); err != nil {
+		return Config{}, err
+	}
+	if cfg.StripeMaxAttempts, err = env.integer("AEGIS_STRIPE_MAX_ATTEMPTS", 10, 1); err != nil {
+		return Config{}, err
+	}
+	if cfg.TerminatorWorkers, err = env.integer("AEGIS_TERMINATOR_WORKERS", 4, 1); err != nil {
+		return Config{}, err
+	}
+	if cfg.AlertProvisionFailures, err = env.integer("AEGIS_ALERT_PROVISION_FAILURES", 5, 1); err != nil {
+		return Config{}, err
+	}
+	if cfg.AlertTerminationAttempts, err = env.integer("AEGIS_ALERT_TERMINATION_ATTEMPTS", 6, 1); err != nil {
+		return Config{}, err
+	}
+	// AEGIS_RELAY_HOURLY_PRICES=t4g.small=0.0168,eu-west-1/t4g.small=0.0184
+	if cfg.RelayHourlyPrices, err = parsePriceMap("AEGIS_RELAY_HOURLY_PRICES", env.get("AEGIS_RELAY_HOURLY_PRICES")); err != nil {
+		return Config{}, err
+	}
+	if raw := strings.TrimSpace(env.get("AEGIS_RELAY_DEFAULT_HOURLY_PRICE")); raw != "" {
+		if cfg.RelayDefaultHourlyPrice, err = strconv.ParseFloat(raw, 64); err != nil || !validPrice(cfg.RelayDefaultHourlyPrice) {
+			return Config{}, fmt.Errorf("AEGIS_RELAY_DEFAULT_HOURLY_PRICE must be a non-negative number")
+		}
+	}
+	// AEGIS_USAGE_ALERT_THRESHOLDS=80,100
+	if cfg.UsageAlertThresholds, err = parsePercentList("AEGIS_USAGE_ALERT_THRESHOLDS", env.getOrDefault("AEGIS_USAGE_ALERT_THRESHOLDS", "80,100")); err != nil {
+		return Config{}, err
+	}
+	// AEGIS_FAKE_PROVISION_LATENCY=500ms (fixed) or 200ms-2s (random)
+	if cfg.FakeProvisionLatencyMin, cfg.FakeProvisionLatencyMax, err = parseLatencyRange("AEGIS_FAKE_PROVISION_LATENCY", env.get("AEGIS_FAKE_PROVISION_LATENCY")); err != nil {
+		return Config{}, err
+	}
+	if raw := strings.TrimSpace(env.get("AEGIS_FAKE_FAILURE_RATE")); raw != "" {
+		if cfg.FakeFailureRate, err = strconv.ParseFloat(raw, 64); err != nil || cfg.FakeFailureRate < 0 || cfg.FakeFailureRate > 1 {
+			return Config{}, fmt.Errorf("AEGIS_FAKE_FAILURE_RATE must be a number between 0 and 1")
+		}
+	}
+	if cfg.FakeFailFirst, err = env.integer("AEGIS_FAKE_FAIL_FIRST", 0, 0); err != nil {
+		return Config{}, err
+	}
+	// AEGIS_RELAY_SRT_PORT_RANGE=10000-10009
+	if cfg.RelaySRTPortRange.Min, cfg.RelaySRTPortRange.Max, err = parsePortRange("AEGIS_RELAY_SRT_PORT_RANGE", env.getOrDefault("AEGIS_RELAY_SRT_PORT_RANGE", "9000-9009")); err != nil {
+		return Config{}, err
+	}
+	if cfg.RelaySRTPortCount, err = env.integer("AEGIS_RELAY_SRT_PORT_COUNT", 1, 1); err != nil {
+		return Config{}, err
+	}
+	if cfg.RelaySRTPortCount > cfg.RelaySRTPortRange.Size() {
+		return Config{}, fmt.Errorf("AEGIS_RELAY_SRT_PORT_COUNT must not exceed the %d ports of AEGIS_RELAY_SRT_PORT_RANGE", cfg.RelaySRTPortRange.Size())
+	}
+	// AEGIS_DOCKER_PORT_RANGE=20000-20099
+	if cfg.DockerPortMin, cfg.DockerPortMax, err = parsePortRange("AEGIS_DOCKER_PORT_RANGE", env.getOrDefault("AEGIS_DOCKER_PORT_RANGE", "20000-20099")); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.DB, err = env.dbPool("AEGIS_DB_", DBPool{
+		MaxConns:               10,
+		MaxConnLifetime:        time.Hour,
+		StatementTimeout:       15 * time.Second,
+		SlowQuery:              250 * time.Millisecond,
+		StatementCacheCapacity: 512,
+		ReadTimeout:            5 * time.Second,
+		WriteTimeout:           15 * time.Second,
+		RollupTimeout:          time.Minute,
+	}); err != nil {
+		return Config{}, err
+	}
+	if cfg.JobsDB, err = env.dbPool("AEGIS_JOBS_DB_", DBPool{
+		MaxConns:               4,
+		MaxConnLifetime:        time.Hour,
+		StatementTimeout:       5 * time.Minute,
+		SlowQuery:              5 * time.Second,
+		StatementCacheCapacity: 512,
+		ReadTimeout:            5 * time.Second,
+		WriteTimeout:           15 * time.Second,
+		RollupTimeout:          time.Minute,
+	}); err != nil {
+		return Config{}, err
 	}
 
 	if cfg.DatabaseURL == "" {
@@ -47,22 +520,331 @@ func LoadFromEnv() (Config, error) {
 	if cfg.RelaySharedKey == "" {
 		return Config{}, fmt.Errorf("AEGIS_RELAY_SHARED_KEY is required")
 	}
-	if cfg.RelayProvider != "fake" && cfg.RelayProvider != "aws" {
-		return Config{}, fmt.Errorf("AEGIS_RELAY_PROVIDER must be one of fake|aws")
+	if !slices.Contains(relayProviders, cfg.RelayProvider) {
+		return Config{}, fmt.Errorf("AEGIS_RELAY_PROVIDER must be one of fake|aws|docker|hetzner")
+	}
+	for region, name := range cfg.RegionProviders {
+		if !slices.Contains(relayProviders, name) {
+			return Config{}, fmt.Errorf("AEGIS_REGION_PROVIDER_MAP entry for %s must be one of fake|aws|docker|hetzner", region)
+		}
 	}
-	if cfg.RelayProvider == "aws" && len(cfg.AWSAMIMap) == 0 {
+	if cfg.UsesProvider("aws") && len(cfg.AWSAMIMap) == 0 {
 		return Config{}, fmt.Errorf("AEGIS_AWS_AMI_MAP is required for aws relay provider")
 	}
+	if cfg.UsesProvider("docker") && cfg.DockerRelayImage == "" {
+		return Config{}, fmt.Errorf("AEGIS_DOCKER_RELAY_IMAGE is required for docker relay provider")
+	}
+	if cfg.UsesProvider("hetzner") && cfg.HetznerToken == "" {
+		return Config{}, fmt.Errorf("AEGIS_HETZNER_TOKEN is required for hetzner relay provider")
+	}
+	if cfg.UsesProvider("hetzner") && len(cfg.HetznerImageMap) == 0 {
+		return Config{}, fmt.Errorf("AEGIS_HETZNER_IMAGE_MAP is required for hetzner relay provider")
+	}
+	if cfg.AWSProvisionPollInterval > cfg.AWSProvisionWaitTimeout {
+		return Config{}, fmt.Errorf("AEGIS_AWS_PROVISION_POLL_INTERVAL must not exceed AEGIS_AWS_PROVISION_WAIT_TIMEOUT")
+	}
+	for _, alertURL := range []struct{ key, raw string }{
+		{"AEGIS_ALERT_SLACK_WEBHOOK_URL", cfg.AlertSlackWebhookURL},
+		{"AEGIS_ALERT_WEBHOOK_URL", cfg.AlertWebhookURL},
+	} {
+		if alertURL.raw == "" {
+			continue
+		}
+		if u, err := url.Parse(alertURL.raw); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return Config{}, fmt.Errorf("%s must be an absolute http(s) URL", alertURL.key)
+		}
+	}
+	if cfg.RelayControlPlaneURL != "" {
+		u, err := url.Parse(cfg.RelayControlPlaneURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return Config{}, fmt.Errorf("AEGIS_RELAY_CONTROL_PLANE_URL must be an absolute http(s) URL")
+		}
+	}
+	if cfg.ExternalBaseURL != "" {
+		u, err := url.Parse(cfg.ExternalBaseURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+			return Config{}, fmt.Errorf("AEGIS_EXTERNAL_BASE_URL must be an absolute https URL without query or fragment")
+		}
+	}
+	// AEGIS_RELAY_WS_TEMPLATE=wss://{instance_id}.relays.example.com:7443/telemetry
+	if cfg.RelayWSTemplate, err = relay.ParseWSTemplate(env.get("AEGIS_RELAY_WS_TEMPLATE")); err != nil {
+		return Config{}, fmt.Errorf("AEGIS_RELAY_WS_TEMPLATE: %w", err)
+	}
+	if cfg.RelayDNSRecords && cfg.RelayDNSZoneID == "" {
+		return Config{}, fmt.Errorf("AEGIS_RELAY_DNS_ZONE_ID is required with AEGIS_RELAY_DNS_RECORDS")
+	}
+	if cfg.RelayDNSRecords && !cfg.RelayWSTemplate.NamesRelays() {
+		return Config{}, fmt.Errorf("AEGIS_RELAY_WS_TEMPLATE must name relays by {instance_id} or {session_id} with AEGIS_RELAY_DNS_RECORDS")
+	}
+	if cfg.RelayMinAgentVersion != "" {
+		if _, err := version.ParseSemver(cfg.RelayMinAgentVersion); err != nil {
+			return Config{}, fmt.Errorf("AEGIS_RELAY_MIN_AGENT_VERSION must be a semantic version: %w", err)
+		}
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return Config{}, fmt.Errorf("AEGIS_TLS_CERT_FILE and AEGIS_TLS_KEY_FILE must be set together")
+	}
 	return cfg, nil
 }
 
-func envOrDefault(k, v string) string {
-	if raw := os.Getenv(k); raw != "" {
+// Alerts returns the operator alert hook the alert settings configure; it
+// is nil, and drops everything, when no alert URL is set.
+func (c Config) Alerts() *alert.Hook {
+	var notifiers []alert.Notifier
+	if c.AlertSlackWebhookURL != "" {
+		notifiers = append(notifiers, alert.NewSlack(c.AlertSlackWebhookURL))
+	}
+	if c.AlertWebhookURL != "" {
+		notifiers = append(notifiers, alert.NewWebhook(c.AlertWebhookURL))
+	}
+	return alert.New(c.AlertCooldown, notifiers...)
+}
+
+// TLSEnabled reports whether the API listener should serve HTTPS directly.
+func (c Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// ProviderFor returns the provider relays in region are launched on.
+func (c Config) ProviderFor(region string) string {
+	if name := c.RegionProviders[region]; name != "" {
+		return name
+	}
+	return c.RelayProvider
+}
+
+// Providers lists every provider in use, RelayProvider first.
+func (c Config) Providers() []string {
+	out := []string{c.RelayProvider}
+	for _, region := range slices.Sorted(maps.Keys(c.RegionProviders)) {
+		if name := c.RegionProviders[region]; !slices.Contains(out, name) {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// RelayPrices lists the configured relay prices for the store. The default
+// price is the entry with neither region nor instance type, and is listed
+// whenever any price is configured. Region-wide prices have no region.
+func (c Config) RelayPrices() []model.RelayPrice {
+	if len(c.RelayHourlyPrices) == 0 && c.RelayDefaultHourlyPrice == 0 {
+		return nil
+	}
+	out := []model.RelayPrice{{HourlyUSD: c.RelayDefaultHourlyPrice}}
+	for _, key := range slices.Sorted(maps.Keys(c.RelayHourlyPrices)) {
+		region, instanceType, ok := strings.Cut(key, "/")
+		if !ok {
+			region, instanceType = "", key
+		}
+		out = append(out, model.RelayPrice{Region: region, InstanceType: instanceType, HourlyUSD: c.RelayHourlyPrices[key]})
+	}
+	return out
+}
+
+// UsesProvider reports whether any region runs on the named provider.
+func (c Config) UsesProvider(name string) bool {
+	return slices.Contains(c.Providers(), name)
+}
+
+// Validate cross-checks settings that LoadFromEnv accepts individually but
+// that only make sense together. Problems tied to one region are returned as
+// *model.RegionError so callers can mark just that region unavailable.
+func (c Config) Validate() []error {
+	var problems []error
+	if !slices.Contains(c.SupportedRegion, c.DefaultRegion) {
+		problems = append(problems, fmt.Errorf("AEGIS_DEFAULT_REGION %s is not in AEGIS_SUPPORTED_REGIONS", c.DefaultRegion))
+	}
+	if c.AdminListenAddr != "" && c.AdminListenAddr == c.ListenAddr {
+		problems = append(problems, fmt.Errorf("AEGIS_ADMIN_LISTEN_ADDR %s is the same as AEGIS_LISTEN_ADDR", c.AdminListenAddr))
+	}
+	if c.RelayBootProbe && c.HTTPStartTimeout > 0 && c.RelayBootProbeTimeout >= c.HTTPStartTimeout {
+		problems = append(problems, fmt.Errorf("AEGIS_RELAY_BOOT_PROBE_TIMEOUT %s is not shorter than AEGIS_HTTP_START_TIMEOUT %s", c.RelayBootProbeTimeout, c.HTTPStartTimeout))
+	}
+	if c.ProvisionConcurrency > 0 && c.HTTPStartTimeout > 0 && c.ProvisionQueueTimeout >= c.HTTPStartTimeout {
+		problems = append(problems, fmt.Errorf("AEGIS_PROVISION_QUEUE_TIMEOUT %s is not shorter than AEGIS_HTTP_START_TIMEOUT %s", c.ProvisionQueueTimeout, c.HTTPStartTimeout))
+	}
+	if c.UsesProvider("aws") {
+		if c.HTTPStartTimeout > 0 && c.AWSProvisionWaitTimeout >= c.HTTPStartTimeout {
+			problems = append(problems, fmt.Errorf("AEGIS_AWS_PROVISION_WAIT_TIMEOUT %s is not shorter than AEGIS_HTTP_START_TIMEOUT %s", c.AWSProvisionWaitTimeout, c.HTTPStartTimeout))
+		}
+		for _, region := range c.SupportedRegion {
+			if c.ProviderFor(region) == "aws" && c.AWSAMIMap[region] == "" {
+				problems = append(problems, &model.RegionError{Region: region, Err: errors.New("no AMI configured in AEGIS_AWS_AMI_MAP")})
+			}
+		}
+		for _, region := range c.AWSFallbackRegions {
+			if c.AWSAMIMap[region] == "" {
+				problems = append(problems, fmt.Errorf("AEGIS_AWS_FALLBACK_REGIONS entry %s has no AMI in AEGIS_AWS_AMI_MAP", region))
+			}
+		}
+	}
+	if c.UsesProvider("hetzner") {
+		for _, region := range c.SupportedRegion {
+			if c.ProviderFor(region) == "hetzner" && c.HetznerImageMap[region] == "" {
+				problems = append(problems, &model.RegionError{Region: region, Err: errors.New("no image configured in AEGIS_HETZNER_IMAGE_MAP")})
+			}
+		}
+	}
+	for region, ami := range c.AWSAMIMap {
+		// "ssm:<name>" values are resolved from SSM Parameter Store at launch.
+		if name, ok := strings.CutPrefix(ami, "ssm:"); ok && strings.TrimSpace(name) == "" {
+			problems = append(problems, fmt.Errorf("AEGIS_AWS_AMI_MAP entry for %s names no SSM parameter", region))
+		}
+	}
+	if c.AWSSubnetID != "" && !subnetIDPattern.MatchString(c.AWSSubnetID) {
+		problems = append(problems, fmt.Errorf("AEGIS_AWS_SUBNET_ID %q is not a valid subnet id", c.AWSSubnetID))
+	}
+	for region, ids := range c.AWSSubnetIDs {
+		for _, id := range ids {
+			if !subnetIDPattern.MatchString(id) {
+				problems = append(problems, fmt.Errorf("AEGIS_AWS_SUBNET_IDS entry %q for %s is not a valid subnet id", id, region))
+			}
+		}
+	}
+	if c.AWSInstanceProfileARN != "" && !instanceProfileARNPattern.MatchString(c.AWSInstanceProfileARN) {
+		problems = append(problems, fmt.Errorf("AEGIS_AWS_INSTANCE_PROFILE_ARN %q is not a valid instance profile ARN", c.AWSInstanceProfileARN))
+	}
+	for region, ids := range c.AWSEIPPool {
+		for _, id := range ids {
+			if !strings.HasPrefix(id, "eipalloc-") {
+				problems = append(problems, fmt.Errorf("AEGIS_AWS_EIP_POOL entry %q for %s is not an Elastic IP allocation id", id, region))
+			}
+		}
+	}
+	for region, n := range c.AWSWarmPoolSize {
+		if n > 0 && c.AWSAMIMap[region] == "" {
+			problems = append(problems, fmt.Errorf("AEGIS_AWS_WARM_POOL_SIZE entry %s has no AMI in AEGIS_AWS_AMI_MAP", region))
+		}
+	}
+	if c.CanaryInterval > 0 && len(c.CanaryRegions) == 0 {
+		problems = append(problems, fmt.Errorf("AEGIS_CANARY_INTERVAL is set but AEGIS_CANARY_REGIONS is empty"))
+	}
+	for _, region := range c.CanaryRegions {
+		if !slices.Contains(c.SupportedRegion, region) {
+			problems = append(problems, fmt.Errorf("AEGIS_CANARY_REGIONS entry %s is not in AEGIS_SUPPORTED_REGIONS", region))
+		}
+	}
+	for _, sg := range c.AWSSecurityIDs {
+		if !securityGroupIDPattern.MatchString(sg) {
+			problems = append(problems, fmt.Errorf("AEGIS_AWS_SECURITY_GROUP_IDS entry %q is not a valid security group id", sg))
+		}
+	}
+	return problems
+}
+
+// source resolves configuration keys, preferring values from the optional
+// config file over the process environment.
+type source map[string]string
+
+func newSource(path string) (source, error) {
+	out := source{}
+	if strings.TrimSpace(path) == "" {
+		return out, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read AEGIS_CONFIG_FILE: %w", err)
+	}
+	for i, line := range strings.Split(string(raw), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("AEGIS_CONFIG_FILE line %d: expected KEY=VALUE", i+1)
+		}
+		out[strings.TrimSpace(k)] = strings.Trim(strings.TrimSpace(v), `"`)
+	}
+	return out, nil
+}
+
+func (s source) get(k string) string {
+	if v, ok := s[k]; ok {
+		return v
+	}
+	return os.Getenv(k)
+}
+
+func (s source) getOrDefault(k, v string) string {
+	if raw := s.get(k); raw != "" {
 		return raw
 	}
 	return v
 }
 
+func (s source) duration(k string, d time.Duration) (time.Duration, error) {
+	raw := strings.TrimSpace(s.get(k))
+	if raw == "" {
+		return d, nil
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration like 30s or 2m: %w", k, err)
+	}
+	if v <= 0 {
+		return 0, fmt.Errorf("%s must be positive", k)
+	}
+	return v, nil
+}
+
+func (s source) integer(k string, d, min int) (int, error) {
+	raw := strings.TrimSpace(s.get(k))
+	if raw == "" {
+		return d, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer: %w", k, err)
+	}
+	if n < min {
+		return 0, fmt.Errorf("%s must be >= %d", k, min)
+	}
+	return n, nil
+}
+
+func (s source) dbPool(prefix string, d DBPool) (DBPool, error) {
+	var out DBPool
+	var err error
+	if out.MaxConns, err = s.integer(prefix+"MAX_CONNS", d.MaxConns, 1); err != nil {
+		return DBPool{}, err
+	}
+	if out.MinConns, err = s.integer(prefix+"MIN_CONNS", d.MinConns, 0); err != nil {
+		return DBPool{}, err
+	}
+	if out.MinConns > out.MaxConns {
+		return DBPool{}, fmt.Errorf("%sMIN_CONNS must not exceed %sMAX_CONNS", prefix, prefix)
+	}
+	if out.MaxConnLifetime, err = s.duration(prefix+"MAX_CONN_LIFETIME", d.MaxConnLifetime); err != nil {
+		return DBPool{}, err
+	}
+	if out.StatementTimeout, err = s.duration(prefix+"STATEMENT_TIMEOUT", d.StatementTimeout); err != nil {
+		return DBPool{}, err
+	}
+	if out.SlowQuery, err = s.duration(prefix+"SLOW_QUERY", d.SlowQuery); err != nil {
+		return DBPool{}, err
+	}
+	if out.StatementCacheCapacity, err = s.integer(prefix+"STATEMENT_CACHE_CAPACITY", d.StatementCacheCapacity, 1); err != nil {
+		return DBPool{}, err
+	}
+	if out.ReadTimeout, err = s.duration(prefix+"READ_TIMEOUT", d.ReadTimeout); err != nil {
+		return DBPool{}, err
+	}
+	if out.WriteTimeout, err = s.duration(prefix+"WRITE_TIMEOUT", d.WriteTimeout); err != nil {
+		return DBPool{}, err
+	}
+	if out.RollupTimeout, err = s.duration(prefix+"ROLLUP_TIMEOUT", d.RollupTimeout); err != nil {
+		return DBPool{}, err
+	}
+	return out, nil
+}
+
+func (s source) boolean(k string) bool {
+	v, err := strconv.ParseBool(strings.TrimSpace(s.get(k)))
+	return err == nil && v
+}
+
 func splitCSV(v string) []string {
 	parts := strings.Split(v, ",")
 	out := make([]string, 0, len(parts))
@@ -87,6 +869,136 @@ func ParsePositiveIntEnv(k string, d int) int {
 	return n
 }
 
+// parseListMap parses key=a|b,key2=c into ordered value lists per key.
+func parseListMap(v string) map[string][]string {
+	out := make(map[string][]string)
+	for k, raw := range parseKVMap(v) {
+		for _, item := range strings.Split(raw, "|") {
+			if item = strings.TrimSpace(item); item != "" {
+				out[k] = append(out[k], item)
+			}
+		}
+	}
+	return out
+}
+
+// parseIntMap parses key=n,key2=m into non-negative counts per key.
+func parseIntMap(name, v string) (map[string]int, error) {
+	out := make(map[string]int)
+	for k, raw := range parseKVMap(v) {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%s entry for %s must be a non-negative integer, got %q", name, k, raw)
+		}
+		out[k] = n
+	}
+	return out, nil
+}
+
+// parsePercentList parses n,m into ascending, distinct positive percentages.
+func parsePercentList(name, v string) ([]int, error) {
+	var out []int
+	for _, raw := range splitCSV(v) {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("%s entries must be positive integers, got %q", name, raw)
+		}
+		if !slices.Contains(out, n) {
+			out = append(out, n)
+		}
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// parsePriceMap parses key=price,key2=price into non-negative prices per
+// instance type or region/instance type.
+func parsePriceMap(name, v string) (map[string]float64, error) {
+	out := make(map[string]float64)
+	for k, raw := range parseKVMap(v) {
+		if region, instanceType, ok := strings.Cut(k, "/"); ok && (region == "" || instanceType == "") {
+			return nil, fmt.Errorf("%s entry %s must be an instance type or region/instance type", name, k)
+		}
+		price, err := strconv.ParseFloat(raw, 64)
+		if err != nil || !validPrice(price) {
+			return nil, fmt.Errorf("%s entry for %s must be a non-negative number, got %q", name, k, raw)
+		}
+		out[k] = price
+	}
+	return out, nil
+}
+
+func validPrice(p float64) bool {
+	return p >= 0 && !math.IsInf(p, 0)
+}
+
+// AWSRetryPolicy is one AEGIS_AWS_RETRY_POLICIES entry.
+type AWSRetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// parseRetryPolicies parses op=attempts|base|max entries.
+func parseRetryPolicies(name, v string) (map[string]AWSRetryPolicy, error) {
+	out := make(map[string]AWSRetryPolicy)
+	for op, parts := range parseListMap(v) {
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%s entry for %s must be attempts|base_delay|max_delay", name, op)
+		}
+		attempts, err := strconv.Atoi(parts[0])
+		if err != nil || attempts < 1 {
+			return nil, fmt.Errorf("%s entry for %s must start with a positive attempt count, got %q", name, op, parts[0])
+		}
+		base, baseErr := time.ParseDuration(parts[1])
+		maxDelay, maxErr := time.ParseDuration(parts[2])
+		if baseErr != nil || maxErr != nil || base <= 0 || maxDelay < base {
+			return nil, fmt.Errorf("%s entry for %s needs positive delays with base_delay <= max_delay", name, op)
+		}
+		out[op] = AWSRetryPolicy{MaxAttempts: attempts, BaseDelay: base, MaxDelay: maxDelay}
+	}
+	return out, nil
+}
+
+// parseLatencyRange reads "500ms" as a fixed latency and "200ms-2s" as a
+// range.
+func parseLatencyRange(name, v string) (time.Duration, time.Duration, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, 0, nil
+	}
+	lo, hi, isRange := strings.Cut(v, "-")
+	if !isRange {
+		hi = lo
+	}
+	minD, err := time.ParseDuration(strings.TrimSpace(lo))
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s must be a duration or a range like 200ms-2s: %w", name, err)
+	}
+	maxD, err := time.ParseDuration(strings.TrimSpace(hi))
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s must be a duration or a range like 200ms-2s: %w", name, err)
+	}
+	if minD < 0 || maxD < minD {
+		return 0, 0, fmt.Errorf("%s range must be non-negative and ascending", name)
+	}
+	return minD, maxD, nil
+}
+
+// parsePortRange parses "lo-hi" into an ascending range of TCP/UDP ports.
+func parsePortRange(name, v string) (int, int, error) {
+	lo, hi, ok := strings.Cut(strings.TrimSpace(v), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("%s must be a port range like 20000-20099", name)
+	}
+	minPort, errLo := strconv.Atoi(strings.TrimSpace(lo))
+	maxPort, errHi := strconv.Atoi(strings.TrimSpace(hi))
+	if errLo != nil || errHi != nil || minPort < 1 || maxPort > 65535 || maxPort < minPort {
+		return 0, 0, fmt.Errorf("%s must be a port range like 20000-20099", name)
+	}
+	return minPort, maxPort, nil
+}
+
 func parseKVMap(v string) map[string]string {
 	out := make(map[string]string)
 	if strings.TrimSpace(v) == "" {
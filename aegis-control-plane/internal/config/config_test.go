@@ -0,0 +1,1033 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+)
+
+func TestValidate_ReportsRegionsWithoutAMI(t *testing.T) {
+	cfg := Config{
+		DefaultRegion:   "us-east-1",
+		SupportedRegion: []string{"us-east-1", "ap-southeast-2"},
+		RelayProvider:   "aws",
+		AWSAMIMap:       map[string]string{"us-east-1": "ami-0123abcd"},
+	}
+
+	problems := cfg.Validate()
+	if len(problems) != 1 {
+		t.Fatalf("expected 1 problem, got %v", problems)
+	}
+	var regionErr *model.RegionError
+	if !errors.As(problems[0], &regionErr) || regionErr.Region != "ap-southeast-2" {
+		t.Fatalf("expected region error for ap-southeast-2, got %v", problems[0])
+	}
+}
+
+func TestValidate_RejectsMalformedNetworkIDs(t *testing.T) {
+	cfg := Config{
+		DefaultRegion:   "us-east-1",
+		SupportedRegion: []string{"us-east-1"},
+		RelayProvider:   "fake",
+		AWSSubnetID:     "subnet_bad",
+		AWSSecurityIDs:  []string{"sg-0123456789abcdef0", "default"},
+	}
+
+	problems := cfg.Validate()
+	if len(problems) != 2 {
+		t.Fatalf("expected 2 problems, got %v", problems)
+	}
+	for _, p := range problems {
+		var regionErr *model.RegionError
+		if errors.As(p, &regionErr) {
+			t.Fatalf("network id problems should not be region scoped: %v", p)
+		}
+	}
+}
+
+func TestValidate_DefaultRegionMustBeSupported(t *testing.T) {
+	cfg := Config{
+		DefaultRegion:   "us-west-2",
+		SupportedRegion: []string{"us-east-1"},
+		RelayProvider:   "fake",
+	}
+
+	if problems := cfg.Validate(); len(problems) != 1 {
+		t.Fatalf("expected 1 problem, got %v", problems)
+	}
+}
+
+func TestValidate_AdminListenAddrMustDiffer(t *testing.T) {
+	cfg := Config{
+		ListenAddr:      ":8080",
+		AdminListenAddr: ":8080",
+		DefaultRegion:   "us-east-1",
+		SupportedRegion: []string{"us-east-1"},
+		RelayProvider:   "fake",
+	}
+	if problems := cfg.Validate(); len(problems) != 1 || !strings.Contains(problems[0].Error(), "AEGIS_ADMIN_LISTEN_ADDR") {
+		t.Fatalf("expected an AEGIS_ADMIN_LISTEN_ADDR problem, got %v", problems)
+	}
+	cfg.AdminListenAddr = "127.0.0.1:9090"
+	if problems := cfg.Validate(); len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+}
+
+func TestLiveReload_KeepsNonReloadableFields(t *testing.T) {
+	live := NewLive(Config{
+		ListenAddr:      ":8080",
+		JWTSecret:       "secret-a",
+		DefaultRegion:   "us-east-1",
+		SupportedRegion: []string{"us-east-1"},
+	})
+
+	rejected := live.Reload(Config{
+		ListenAddr:      ":9090",
+		JWTSecret:       "secret-b",
+		DefaultRegion:   "eu-west-1",
+		SupportedRegion: []string{"eu-west-1"},
+	})
+
+	if len(rejected) != 2 || rejected[0] != "AEGIS_LISTEN_ADDR" || rejected[1] != "AEGIS_JWT_SECRET" {
+		t.Fatalf("unexpected rejected fields: %v", rejected)
+	}
+	got := live.Get()
+	if got.ListenAddr != ":8080" || got.JWTSecret != "secret-a" {
+		t.Fatalf("non-reloadable fields changed: %+v", got)
+	}
+	if got.DefaultRegion != "eu-west-1" {
+		t.Fatalf("expected default region to reload, got %s", got.DefaultRegion)
+	}
+}
+
+func TestLiveReload_RejectsRouteTimeouts(t *testing.T) {
+	live := NewLive(Config{HTTPFastTimeout: 5 * time.Second, HTTPStopTimeout: 30 * time.Second})
+	rejected := live.Reload(Config{HTTPFastTimeout: 2 * time.Second, HTTPStopTimeout: time.Minute})
+	if len(rejected) != 2 || rejected[0] != "AEGIS_HTTP_FAST_TIMEOUT" || rejected[1] != "AEGIS_HTTP_STOP_TIMEOUT" {
+		t.Fatalf("unexpected rejected fields: %v", rejected)
+	}
+	if got := live.Get(); got.HTTPFastTimeout != 5*time.Second || got.HTTPStopTimeout != 30*time.Second {
+		t.Fatalf("expected the route timeouts kept, got %+v", got)
+	}
+}
+
+func TestLiveReload_RejectsServerTimeouts(t *testing.T) {
+	live := NewLive(Config{HTTPReadTimeout: 30 * time.Second, HTTPWriteTimeout: 3 * time.Minute, HTTPRequestTimeout: 3 * time.Minute, HTTPStartTimeout: 3 * time.Minute, ShutdownGrace: 10 * time.Second})
+	rejected := live.Reload(Config{HTTPReadTimeout: time.Minute, HTTPWriteTimeout: time.Minute, HTTPRequestTimeout: time.Minute, HTTPStartTimeout: time.Minute, ShutdownGrace: time.Minute})
+	want := []string{"AEGIS_HTTP_REQUEST_TIMEOUT", "AEGIS_HTTP_START_TIMEOUT", "AEGIS_HTTP_READ_TIMEOUT", "AEGIS_HTTP_WRITE_TIMEOUT", "AEGIS_SHUTDOWN_GRACE"}
+	if !reflect.DeepEqual(rejected, want) {
+		t.Fatalf("unexpected rejected fields: %v", rejected)
+	}
+	if got := live.Get(); got.HTTPStartTimeout != 3*time.Minute || got.ShutdownGrace != 10*time.Second {
+		t.Fatalf("expected the server timeouts kept, got %+v", got)
+	}
+}
+
+func TestLiveReload_RejectsPoolSettings(t *testing.T) {
+	live := NewLive(Config{DB: DBPool{MaxConns: 10, ReadTimeout: 5 * time.Second}, JobsDB: DBPool{MaxConns: 4}})
+	rejected := live.Reload(Config{DB: DBPool{MaxConns: 10, ReadTimeout: time.Second}, JobsDB: DBPool{MaxConns: 8}})
+	if len(rejected) != 2 || rejected[0] != "AEGIS_DB_*" || rejected[1] != "AEGIS_JOBS_DB_*" {
+		t.Fatalf("unexpected rejected fields: %v", rejected)
+	}
+	if got := live.Get(); got.DB.ReadTimeout != 5*time.Second || got.JobsDB.MaxConns != 4 {
+		t.Fatalf("expected the pool settings kept, got %+v", got)
+	}
+}
+
+func TestLiveReload_RejectsTLSFiles(t *testing.T) {
+	live := NewLive(Config{TLSCertFile: "/etc/aegis/tls.crt", TLSKeyFile: "/etc/aegis/tls.key"})
+	rejected := live.Reload(Config{TLSCertFile: "/etc/aegis/new.crt", TLSKeyFile: "/etc/aegis/new.key"})
+	if len(rejected) != 2 || rejected[0] != "AEGIS_TLS_CERT_FILE" || rejected[1] != "AEGIS_TLS_KEY_FILE" {
+		t.Fatalf("unexpected rejected fields: %v", rejected)
+	}
+	if got := live.Get(); got.TLSCertFile != "/etc/aegis/tls.crt" {
+		t.Fatalf("expected the TLS files kept, got %+v", got)
+	}
+}
+
+func TestLiveReload_RejectsIdempotencyCap(t *testing.T) {
+	live := NewLive(Config{IdempotencyMaxPerUser: 1000})
+	if rejected := live.Reload(Config{IdempotencyMaxPerUser: 50}); len(rejected) != 1 || rejected[0] != "AEGIS_IDEMPOTENCY_MAX_PER_USER" {
+		t.Fatalf("unexpected rejected fields: %v", rejected)
+	}
+	if got := live.Get(); got.IdempotencyMaxPerUser != 1000 {
+		t.Fatalf("expected the cap kept, got %d", got.IdempotencyMaxPerUser)
+	}
+}
+
+func TestLiveReload_RejectsFakeSettings(t *testing.T) {
+	live := NewLive(Config{FakeProvisionLatencyMin: 200 * time.Millisecond, FakeProvisionLatencyMax: 2 * time.Second, FakeErrorCodes: []string{"capacity"}})
+	rejected := live.Reload(Config{FakeProvisionLatencyMax: time.Second, FakeFailureRate: 0.5, FakeErrorCodes: []string{"quota"}, FakeFailFirst: 2})
+	want := []string{"AEGIS_FAKE_PROVISION_LATENCY", "AEGIS_FAKE_FAILURE_RATE", "AEGIS_FAKE_ERROR_CODES", "AEGIS_FAKE_FAIL_FIRST"}
+	if !reflect.DeepEqual(rejected, want) {
+		t.Fatalf("unexpected rejected fields: %v", rejected)
+	}
+	if got := live.Get(); got.FakeProvisionLatencyMax != 2*time.Second || got.FakeFailureRate != 0 {
+		t.Fatalf("expected the fake settings kept, got %+v", got)
+	}
+}
+
+func TestLiveReload_RejectsAlertSettings(t *testing.T) {
+	live := NewLive(Config{AlertWebhookURL: "https://alerts.example.com/a", AlertCooldown: time.Hour, AlertProvisionFailures: 5, AlertProvisionFailureWindow: 10 * time.Minute, AlertTerminationAttempts: 6})
+	rejected := live.Reload(Config{AlertSlackWebhookURL: "https://hooks.slack.com/services/x", AlertWebhookURL: "https://alerts.example.com/b", AlertCooldown: time.Minute, AlertProvisionFailures: 2, AlertProvisionFailureWindow: time.Minute, AlertTerminationAttempts: 3})
+	want := []string{"AEGIS_ALERT_SLACK_WEBHOOK_URL", "AEGIS_ALERT_WEBHOOK_URL", "AEGIS_ALERT_COOLDOWN", "AEGIS_ALERT_PROVISION_FAILURES", "AEGIS_ALERT_PROVISION_FAILURE_WINDOW", "AEGIS_ALERT_TERMINATION_ATTEMPTS"}
+	if !reflect.DeepEqual(rejected, want) {
+		t.Fatalf("unexpected rejected fields: %v", rejected)
+	}
+	if got := live.Get(); got.AlertWebhookURL != "https://alerts.example.com/a" || got.AlertProvisionFailures != 5 {
+		t.Fatalf("expected the alert settings kept, got %+v", got)
+	}
+}
+
+func TestLiveReload_RejectsDockerSettings(t *testing.T) {
+	live := NewLive(Config{DockerHost: "unix:///var/run/docker.sock", DockerRelayImage: "relay:1", DockerPortMin: 20000, DockerPortMax: 20099})
+	rejected := live.Reload(Config{DockerHost: "tcp://docker:2375", DockerRelayImage: "relay:2", DockerPortMin: 21000, DockerPortMax: 21099})
+	if len(rejected) != 3 || rejected[0] != "AEGIS_DOCKER_HOST" || rejected[1] != "AEGIS_DOCKER_RELAY_IMAGE" || rejected[2] != "AEGIS_DOCKER_PORT_RANGE" {
+		t.Fatalf("unexpected rejected fields: %v", rejected)
+	}
+	if got := live.Get(); got.DockerRelayImage != "relay:1" || got.DockerPortMin != 20000 {
+		t.Fatalf("expected the docker settings kept, got %+v", got)
+	}
+}
+
+func TestLiveReload_RejectsHetznerSettings(t *testing.T) {
+	live := NewLive(Config{HetznerToken: "token-a", HetznerImageMap: map[string]string{"eu-central": "123"}, HetznerSSHKeys: []string{"ops"}})
+	rejected := live.Reload(Config{HetznerToken: "token-a", HetznerImageMap: map[string]string{"eu-central": "456"}, HetznerSSHKeys: []string{"ops", "oncall"}})
+	if len(rejected) != 2 || rejected[0] != "AEGIS_HETZNER_IMAGE_MAP" || rejected[1] != "AEGIS_HETZNER_SSH_KEYS" {
+		t.Fatalf("unexpected rejected fields: %v", rejected)
+	}
+	if got := live.Get(); got.HetznerImageMap["eu-central"] != "123" || len(got.HetznerSSHKeys) != 1 {
+		t.Fatalf("expected the hetzner settings kept, got %+v", got)
+	}
+}
+
+func TestLiveReload_RejectsRelayNaming(t *testing.T) {
+	first, err := relay.ParseWSTemplate("wss://{instance_id}.relays.example.com:7443/telemetry")
+	if err != nil {
+		t.Fatalf("ParseWSTemplate: %v", err)
+	}
+	live := NewLive(Config{RelayWSTemplate: first, RelayDNSRecords: true, RelayDNSZoneID: "Z1"})
+	rejected := live.Reload(Config{RelayDNSZoneID: "Z2"})
+	if len(rejected) != 3 || rejected[0] != "AEGIS_RELAY_WS_TEMPLATE" || rejected[1] != "AEGIS_RELAY_DNS_RECORDS" || rejected[2] != "AEGIS_RELAY_DNS_ZONE_ID" {
+		t.Fatalf("unexpected rejected fields: %v", rejected)
+	}
+	if got := live.Get(); got.RelayWSTemplate != first || !got.RelayDNSRecords || got.RelayDNSZoneID != "Z1" {
+		t.Fatalf("expected the relay naming kept, got %+v", got)
+	}
+}
+
+func TestLiveReload_AppliesRelayAgentSettings(t *testing.T) {
+	live := NewLive(Config{RelayHeartbeatInterval: 30 * time.Second, RelayPingRateLimit: 60})
+	if rejected := live.Reload(Config{RelayMinAgentVersion: "1.4.0", RelayHeartbeatInterval: 15 * time.Second, RelayPingRateLimit: 10}); len(rejected) != 0 {
+		t.Fatalf("unexpected rejected fields: %v", rejected)
+	}
+	got := live.Get()
+	if got.RelayMinAgentVersion != "1.4.0" || got.RelayHeartbeatInterval != 15*time.Second || got.RelayPingRateLimit != 10 {
+		t.Fatalf("expected the relay agent settings to reload, got %+v", got)
+	}
+}
+
+func TestLiveReload_AppliesDrainPeriod(t *testing.T) {
+	live := NewLive(Config{DrainPeriod: 15 * time.Second})
+	if rejected := live.Reload(Config{DrainPeriod: time.Minute}); len(rejected) != 0 {
+		t.Fatalf("unexpected rejected fields: %v", rejected)
+	}
+	if got := live.Get().DrainPeriod; got != time.Minute {
+		t.Fatalf("expected the drain period to reload, got %s", got)
+	}
+}
+
+func TestLiveReload_AppliesRelayPrices(t *testing.T) {
+	live := NewLive(Config{RelayHourlyPrices: map[string]float64{"t4g.small": 0.0168}})
+	if rejected := live.Reload(Config{RelayHourlyPrices: map[string]float64{"t4g.small": 0.02}, RelayDefaultHourlyPrice: 0.05}); len(rejected) != 0 {
+		t.Fatalf("unexpected rejected fields: %v", rejected)
+	}
+	if got := live.Get(); got.RelayHourlyPrices["t4g.small"] != 0.02 || got.RelayDefaultHourlyPrice != 0.05 {
+		t.Fatalf("expected the prices to reload, got %+v", got)
+	}
+}
+
+func TestLoadFromEnv_ConfigFileOverridesEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aegis.env")
+	if err := os.WriteFile(path, []byte("# reloadable settings\nAEGIS_DEFAULT_REGION=eu-west-1\nAEGIS_SUPPORTED_REGIONS=\"us-east-1,eu-west-1\"\n"), 0o600); err != nil {
+		t.Fatalf("write config file: %v", err)
+	}
+	t.Setenv("AEGIS_CONFIG_FILE", path)
+	setRequiredEnv(t)
+	t.Setenv("AEGIS_DEFAULT_REGION", "us-east-1")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.DefaultRegion != "eu-west-1" {
+		t.Fatalf("expected file value to win, got %s", cfg.DefaultRegion)
+	}
+	if len(cfg.SupportedRegion) != 2 {
+		t.Fatalf("unexpected supported regions: %v", cfg.SupportedRegion)
+	}
+}
+
+func setRequiredEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("AEGIS_DATABASE_URL", "postgres://localhost/aegis")
+	t.Setenv("AEGIS_JWT_SECRET", "secret")
+	t.Setenv("AEGIS_RELAY_SHARED_KEY", "relay")
+}
+
+func TestLoadFromEnv_HTTPTimeoutDefaults(t *testing.T) {
+	setRequiredEnv(t)
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.HTTPReadTimeout != 30*time.Second || cfg.HTTPWriteTimeout != 3*time.Minute ||
+		cfg.HTTPRequestTimeout != 3*time.Minute || cfg.HTTPStartTimeout != 3*time.Minute ||
+		cfg.HTTPFastTimeout != 5*time.Second || cfg.HTTPStopTimeout != 30*time.Second ||
+		cfg.ShutdownGrace != 10*time.Second || cfg.DrainPeriod != 15*time.Second {
+		t.Fatalf("unexpected timeout defaults: %+v", cfg)
+	}
+	if cfg.HealthEventRetention != 30*24*time.Hour {
+		t.Fatalf("unexpected health event retention default: %s", cfg.HealthEventRetention)
+	}
+}
+
+func TestLoadFromEnv_InvalidDurations(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+	}{
+		{"AEGIS_HTTP_READ_TIMEOUT", "thirty"},
+		{"AEGIS_HTTP_WRITE_TIMEOUT", "180"},
+		{"AEGIS_HTTP_REQUEST_TIMEOUT", "-5s"},
+		{"AEGIS_HTTP_START_TIMEOUT", "0s"},
+		{"AEGIS_HTTP_FAST_TIMEOUT", "5"},
+		{"AEGIS_SHUTDOWN_GRACE", "10 seconds"},
+		{"AEGIS_DRAIN_PERIOD", "0s"},
+		{"AEGIS_HEALTH_EVENT_RETENTION", "30d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv(tt.key, tt.value)
+			if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), tt.key) {
+				t.Fatalf("expected error naming %s, got %v", tt.key, err)
+			}
+		})
+	}
+}
+
+func TestLoadFromEnv_DBPoolSettings(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("AEGIS_DB_MAX_CONNS", "25")
+	t.Setenv("AEGIS_JOBS_DB_STATEMENT_TIMEOUT", "10m")
+	t.Setenv("AEGIS_JOBS_DB_SLOW_QUERY", "30s")
+	t.Setenv("AEGIS_JOBS_DB_ROLLUP_TIMEOUT", "4m")
+	t.Setenv("AEGIS_JOBS_DB_STATEMENT_CACHE_CAPACITY", "64")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.DB.MaxConns != 25 || cfg.DB.StatementTimeout != 15*time.Second || cfg.DB.SlowQuery != 250*time.Millisecond {
+		t.Fatalf("unexpected api pool: %+v", cfg.DB)
+	}
+	if cfg.DB.ReadTimeout != 5*time.Second || cfg.DB.WriteTimeout != 15*time.Second || cfg.DB.RollupTimeout != time.Minute {
+		t.Fatalf("unexpected api store timeouts: %+v", cfg.DB)
+	}
+	if cfg.DB.StatementCacheCapacity != 512 || cfg.JobsDB.StatementCacheCapacity != 64 {
+		t.Fatalf("unexpected statement cache capacities: api=%d jobs=%d", cfg.DB.StatementCacheCapacity, cfg.JobsDB.StatementCacheCapacity)
+	}
+	if cfg.JobsDB.MaxConns != 4 || cfg.JobsDB.StatementTimeout != 10*time.Minute || cfg.JobsDB.SlowQuery != 30*time.Second || cfg.JobsDB.RollupTimeout != 4*time.Minute {
+		t.Fatalf("unexpected jobs pool: %+v", cfg.JobsDB)
+	}
+}
+
+func TestLoadFromEnv_InvalidDBPoolSettings(t *testing.T) {
+	tests := map[string]string{
+		"AEGIS_DB_MAX_CONNS":                "lots",
+		"AEGIS_DB_MIN_CONNS":                "50",
+		"AEGIS_JOBS_DB_MAX_CONNS":           "0",
+		"AEGIS_DB_MAX_CONN_LIFETIME":        "forever",
+		"AEGIS_JOBS_DB_STATEMENT_TIMEOUT":   "-1m",
+		"AEGIS_DB_SLOW_QUERY":               "0s",
+		"AEGIS_DB_WRITE_TIMEOUT":            "soon",
+		"AEGIS_DB_STATEMENT_CACHE_CAPACITY": "0",
+	}
+	for key, value := range tests {
+		t.Run(key, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv(key, value)
+			if _, err := LoadFromEnv(); err == nil {
+				t.Fatalf("expected error for %s=%s", key, value)
+			}
+		})
+	}
+}
+
+func TestLoadFromEnv_TLSFilesMustBePaired(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("AEGIS_TLS_CERT_FILE", "/etc/aegis/tls.crt")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected error when only AEGIS_TLS_CERT_FILE is set")
+	}
+
+	t.Setenv("AEGIS_TLS_KEY_FILE", "/etc/aegis/tls.key")
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if !cfg.TLSEnabled() {
+		t.Fatal("expected TLS to be enabled")
+	}
+}
+
+func TestLoadFromEnv_ProvisionWaitSettings(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("AEGIS_AWS_PROVISION_WAIT_TIMEOUT", "90s")
+	t.Setenv("AEGIS_AWS_PROVISION_POLL_INTERVAL", "5s")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.AWSProvisionWaitTimeout != 90*time.Second || cfg.AWSProvisionPollInterval != 5*time.Second {
+		t.Fatalf("unexpected wait settings: timeout=%s poll=%s", cfg.AWSProvisionWaitTimeout, cfg.AWSProvisionPollInterval)
+	}
+
+	t.Setenv("AEGIS_AWS_PROVISION_POLL_INTERVAL", "2m")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected error when poll interval exceeds wait timeout")
+	}
+}
+
+func TestValidate_WaitTimeoutMustFitStartTimeout(t *testing.T) {
+	cfg := Config{
+		DefaultRegion:           "us-east-1",
+		SupportedRegion:         []string{"us-east-1"},
+		RelayProvider:           "aws",
+		AWSAMIMap:               map[string]string{"us-east-1": "ami-0123abcd"},
+		AWSProvisionWaitTimeout: 3 * time.Minute,
+		HTTPStartTimeout:        3 * time.Minute,
+	}
+	if problems := cfg.Validate(); len(problems) != 1 {
+		t.Fatalf("expected 1 problem, got %v", problems)
+	}
+}
+
+func TestLoadFromEnv_CapacityFallbackLists(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("AEGIS_AWS_FALLBACK_INSTANCE_TYPES", "us-east-1=t4g.medium|c7g.medium, eu-west-1=t4g.medium")
+	t.Setenv("AEGIS_AWS_FALLBACK_REGIONS", "us-east-2, us-west-2")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if got := strings.Join(cfg.AWSFallbackTypes["us-east-1"], ","); got != "t4g.medium,c7g.medium" {
+		t.Fatalf("unexpected us-east-1 fallback types: %s", got)
+	}
+	if got := strings.Join(cfg.AWSFallbackRegions, ","); got != "us-east-2,us-west-2" {
+		t.Fatalf("unexpected fallback regions: %s", got)
+	}
+}
+
+func TestLoadFromEnv_RelayControlPlaneURL(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("AEGIS_RELAY_CONTROL_PLANE_URL", "api.telemy.app")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected error for a URL without scheme")
+	}
+
+	t.Setenv("AEGIS_RELAY_CONTROL_PLANE_URL", "https://api.telemy.app/")
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.RelayControlPlaneURL != "https://api.telemy.app" {
+		t.Fatalf("unexpected url %q", cfg.RelayControlPlaneURL)
+	}
+}
+
+func TestLoadFromEnv_ExternalBaseURL(t *testing.T) {
+	setRequiredEnv(t)
+	for _, raw := range []string{"aegis.telemy.app", "http://aegis.telemy.app", "https://aegis.telemy.app/?x=1", "https://user@aegis.telemy.app"} {
+		t.Setenv("AEGIS_EXTERNAL_BASE_URL", raw)
+		if _, err := LoadFromEnv(); err == nil {
+			t.Fatalf("expected error for %q", raw)
+		}
+	}
+
+	t.Setenv("AEGIS_EXTERNAL_BASE_URL", " https://aegis.telemy.app/cp/ ")
+	t.Setenv("AEGIS_TRUST_FORWARDED_PROTO", "true")
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.ExternalBaseURL != "https://aegis.telemy.app/cp" || !cfg.TrustForwardedProto {
+		t.Fatalf("unexpected base URL %q trust=%t", cfg.ExternalBaseURL, cfg.TrustForwardedProto)
+	}
+}
+
+func TestLoadFromEnv_Alerts(t *testing.T) {
+	setRequiredEnv(t)
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.Alerts() != nil || cfg.AlertCooldown != time.Hour || cfg.AlertProvisionFailures != 5 || cfg.AlertTerminationAttempts != 6 {
+		t.Fatalf("expected alerting off with default thresholds, got %+v", cfg)
+	}
+
+	t.Setenv("AEGIS_ALERT_WEBHOOK_URL", "hooks.example.com/aegis")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected error for an alert URL without scheme")
+	}
+	t.Setenv("AEGIS_ALERT_WEBHOOK_URL", "https://hooks.example.com/aegis")
+	if cfg, err = LoadFromEnv(); err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.Alerts() == nil {
+		t.Fatal("expected an alert hook with a webhook URL")
+	}
+}
+
+func TestValidate_InstanceProfileARN(t *testing.T) {
+	cfg := Config{
+		DefaultRegion:         "us-east-1",
+		SupportedRegion:       []string{"us-east-1"},
+		RelayProvider:         "fake",
+		AWSInstanceProfileARN: "aegis-relay",
+	}
+	if problems := cfg.Validate(); len(problems) != 1 {
+		t.Fatalf("expected 1 problem, got %v", problems)
+	}
+
+	cfg.AWSInstanceProfileARN = "arn:aws:iam::123456789012:instance-profile/aegis-relay"
+	if problems := cfg.Validate(); len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+}
+
+func TestValidate_EIPPoolAllocationIDs(t *testing.T) {
+	cfg := Config{
+		DefaultRegion:   "us-east-1",
+		SupportedRegion: []string{"us-east-1"},
+		RelayProvider:   "fake",
+		AWSEIPPool:      map[string][]string{"us-east-1": {"eipalloc-0a", "203.0.113.7"}},
+	}
+	if problems := cfg.Validate(); len(problems) != 1 {
+		t.Fatalf("expected 1 problem, got %v", problems)
+	}
+
+	cfg.AWSEIPPool["us-east-1"] = []string{"eipalloc-0a", "eipalloc-0b"}
+	if problems := cfg.Validate(); len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+}
+
+func TestValidate_SSMAMIParameters(t *testing.T) {
+	cfg := Config{
+		DefaultRegion:   "us-east-1",
+		SupportedRegion: []string{"us-east-1", "eu-west-1"},
+		RelayProvider:   "aws",
+		AWSAMIMap:       map[string]string{"us-east-1": "ssm:/aegis/relay/ami", "eu-west-1": "ssm:"},
+	}
+	problems := cfg.Validate()
+	if len(problems) != 1 || !strings.Contains(problems[0].Error(), "eu-west-1 names no SSM parameter") {
+		t.Fatalf("expected one empty parameter problem, got %v", problems)
+	}
+}
+
+func TestLoadFromEnv_SubnetIDsPerRegion(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("AEGIS_AWS_SUBNET_IDS", "us-east-1=subnet-0123456789abcdef0|subnet-0fedcba9876543210, eu-west-1=subnet_bad")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if got := strings.Join(cfg.AWSSubnetIDs["us-east-1"], ","); got != "subnet-0123456789abcdef0,subnet-0fedcba9876543210" {
+		t.Fatalf("unexpected us-east-1 subnets: %s", got)
+	}
+	problems := cfg.Validate()
+	if len(problems) != 1 || !strings.Contains(problems[0].Error(), "subnet_bad") {
+		t.Fatalf("expected one malformed subnet problem, got %v", problems)
+	}
+}
+
+func TestLoadFromEnv_WarmPoolSize(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("AEGIS_AWS_WARM_POOL_SIZE", "us-east-1=2, eu-west-1=0")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.AWSWarmPoolSize["us-east-1"] != 2 || cfg.AWSWarmPoolMaxAge != 24*time.Hour {
+		t.Fatalf("unexpected warm pool settings: %v max_age=%s", cfg.AWSWarmPoolSize, cfg.AWSWarmPoolMaxAge)
+	}
+
+	t.Setenv("AEGIS_AWS_WARM_POOL_SIZE", "us-east-1=two")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_AWS_WARM_POOL_SIZE") {
+		t.Fatalf("expected invalid pool size error, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_BreakerSettings(t *testing.T) {
+	setRequiredEnv(t)
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.AWSBreakerFailureThreshold != 5 || cfg.AWSBreakerCooldown != 30*time.Second {
+		t.Fatalf("unexpected breaker defaults: threshold=%d cooldown=%s", cfg.AWSBreakerFailureThreshold, cfg.AWSBreakerCooldown)
+	}
+
+	t.Setenv("AEGIS_AWS_BREAKER_FAILURE_THRESHOLD", "0")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_AWS_BREAKER_FAILURE_THRESHOLD") {
+		t.Fatalf("expected invalid threshold error, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_ProvisionConcurrency(t *testing.T) {
+	setRequiredEnv(t)
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.ProvisionConcurrency != 0 || cfg.ProvisionQueueTimeout != 20*time.Second {
+		t.Fatalf("expected no cap for the fake provider, got concurrency=%d timeout=%s", cfg.ProvisionConcurrency, cfg.ProvisionQueueTimeout)
+	}
+
+	t.Setenv("AEGIS_REGION_PROVIDER_MAP", "eu-west-1=docker")
+	t.Setenv("AEGIS_DOCKER_RELAY_IMAGE", "relay:latest")
+	if cfg, err = LoadFromEnv(); err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.ProvisionConcurrency != 10 {
+		t.Fatalf("expected the default cap once a real provider is used, got %d", cfg.ProvisionConcurrency)
+	}
+
+	t.Setenv("AEGIS_PROVISION_CONCURRENCY", "-1")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_PROVISION_CONCURRENCY") {
+		t.Fatalf("expected invalid concurrency error, got %v", err)
+	}
+
+	cfg = Config{
+		DefaultRegion:         "us-east-1",
+		SupportedRegion:       []string{"us-east-1"},
+		ProvisionConcurrency:  4,
+		ProvisionQueueTimeout: time.Minute,
+		HTTPStartTimeout:      time.Minute,
+	}
+	if problems := cfg.Validate(); len(problems) != 1 || !strings.Contains(problems[0].Error(), "AEGIS_PROVISION_QUEUE_TIMEOUT") {
+		t.Fatalf("expected the queue timeout to be checked against the start timeout, got %v", problems)
+	}
+}
+
+func TestLoadFromEnv_RetryPolicies(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("AEGIS_AWS_RETRY_POLICIES", "default=3|100ms|1s, run_instances=6|500ms|4s")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	want := AWSRetryPolicy{MaxAttempts: 6, BaseDelay: 500 * time.Millisecond, MaxDelay: 4 * time.Second}
+	if cfg.AWSRetryPolicies["run_instances"] != want || cfg.AWSRetryPolicies["default"].MaxAttempts != 3 || cfg.AWSRetryBudget != 100 {
+		t.Fatalf("unexpected retry settings: %+v budget=%d", cfg.AWSRetryPolicies, cfg.AWSRetryBudget)
+	}
+
+	t.Setenv("AEGIS_AWS_RETRY_POLICIES", "run_instances=6|5s|1s")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_AWS_RETRY_POLICIES") {
+		t.Fatalf("expected invalid policy error, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_FakeProviderInjection(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("AEGIS_FAKE_PROVISION_LATENCY", "200ms-2s")
+	t.Setenv("AEGIS_FAKE_FAILURE_RATE", "0.25")
+	t.Setenv("AEGIS_FAKE_ERROR_CODES", "RequestLimitExceeded,InternalError")
+	t.Setenv("AEGIS_FAKE_FAIL_FIRST", "2")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.FakeProvisionLatencyMin != 200*time.Millisecond || cfg.FakeProvisionLatencyMax != 2*time.Second {
+		t.Fatalf("unexpected latency %s-%s", cfg.FakeProvisionLatencyMin, cfg.FakeProvisionLatencyMax)
+	}
+	if cfg.FakeFailureRate != 0.25 || cfg.FakeFailFirst != 2 || len(cfg.FakeErrorCodes) != 2 {
+		t.Fatalf("unexpected failure settings: rate=%v first=%d codes=%v", cfg.FakeFailureRate, cfg.FakeFailFirst, cfg.FakeErrorCodes)
+	}
+
+	t.Setenv("AEGIS_FAKE_PROVISION_LATENCY", "500ms")
+	if cfg, err = LoadFromEnv(); err != nil || cfg.FakeProvisionLatencyMin != cfg.FakeProvisionLatencyMax {
+		t.Fatalf("expected a fixed latency, got %s-%s err=%v", cfg.FakeProvisionLatencyMin, cfg.FakeProvisionLatencyMax, err)
+	}
+
+	t.Setenv("AEGIS_FAKE_FAILURE_RATE", "1.5")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_FAKE_FAILURE_RATE") {
+		t.Fatalf("expected invalid rate error, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_DockerProvider(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("AEGIS_RELAY_PROVIDER", "docker")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_DOCKER_RELAY_IMAGE") {
+		t.Fatalf("expected missing image error, got %v", err)
+	}
+
+	t.Setenv("AEGIS_DOCKER_RELAY_IMAGE", "telemy/relay:dev")
+	t.Setenv("AEGIS_DOCKER_PORT_RANGE", "21000-21009")
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.DockerPortMin != 21000 || cfg.DockerPortMax != 21009 || cfg.DockerHost != "unix:///var/run/docker.sock" {
+		t.Fatalf("unexpected docker settings: host=%s ports=%d-%d", cfg.DockerHost, cfg.DockerPortMin, cfg.DockerPortMax)
+	}
+
+	t.Setenv("AEGIS_DOCKER_PORT_RANGE", "21009-21000")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_DOCKER_PORT_RANGE") {
+		t.Fatalf("expected invalid port range error, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_HetznerProvider(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("AEGIS_RELAY_PROVIDER", "hetzner")
+	t.Setenv("AEGIS_SUPPORTED_REGIONS", "eu-central,us-east")
+	t.Setenv("AEGIS_DEFAULT_REGION", "eu-central")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_HETZNER_TOKEN") {
+		t.Fatalf("expected missing token error, got %v", err)
+	}
+
+	t.Setenv("AEGIS_HETZNER_TOKEN", "secret")
+	t.Setenv("AEGIS_HETZNER_IMAGE_MAP", "eu-central=123456")
+	t.Setenv("AEGIS_HETZNER_LOCATION_MAP", "eu-central=fsn1")
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.HetznerServerType != "cx22" || cfg.HetznerLocations["eu-central"] != "fsn1" || cfg.HetznerProvisionWaitTimeout != 2*time.Minute {
+		t.Fatalf("unexpected hetzner settings: %+v", cfg)
+	}
+	problems := cfg.Validate()
+	var regionErr *model.RegionError
+	if len(problems) != 1 || !errors.As(problems[0], &regionErr) || regionErr.Region != "us-east" {
+		t.Fatalf("expected a missing image problem for us-east, got %v", problems)
+	}
+}
+
+func TestLoadFromEnv_RegionProviderMap(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("AEGIS_RELAY_PROVIDER", "aws")
+	t.Setenv("AEGIS_AWS_AMI_MAP", "us-east-1=ami-1")
+	t.Setenv("AEGIS_SUPPORTED_REGIONS", "us-east-1,eu-central")
+	t.Setenv("AEGIS_REGION_PROVIDER_MAP", "eu-central=azure")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_REGION_PROVIDER_MAP") {
+		t.Fatalf("expected unknown provider error, got %v", err)
+	}
+
+	// Mapping a region to hetzner needs the hetzner settings too.
+	t.Setenv("AEGIS_REGION_PROVIDER_MAP", "eu-central=hetzner")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_HETZNER_TOKEN") {
+		t.Fatalf("expected missing token error, got %v", err)
+	}
+
+	t.Setenv("AEGIS_HETZNER_TOKEN", "secret")
+	t.Setenv("AEGIS_HETZNER_IMAGE_MAP", "eu-central=123456")
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.ProviderFor("eu-central") != "hetzner" || cfg.ProviderFor("us-east-1") != "aws" {
+		t.Fatalf("unexpected routing %v", cfg.RegionProviders)
+	}
+	if got := cfg.Providers(); len(got) != 2 || got[0] != "aws" || got[1] != "hetzner" {
+		t.Fatalf("unexpected providers %v", got)
+	}
+	// eu-central has no AMI, but it does not run on aws.
+	if problems := cfg.Validate(); len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+}
+
+func TestLoadFromEnv_PairTokenLength(t *testing.T) {
+	setRequiredEnv(t)
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.PairTokenLength != 8 {
+		t.Fatalf("expected default pair token length 8, got %d", cfg.PairTokenLength)
+	}
+
+	t.Setenv("AEGIS_PAIR_TOKEN_LENGTH", "5")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_PAIR_TOKEN_LENGTH") {
+		t.Fatalf("expected pair token length below 6 to be rejected, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_UsageAlertThresholds(t *testing.T) {
+	setRequiredEnv(t)
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.UsageAlertThresholds, []int{80, 100}) {
+		t.Fatalf("expected default thresholds 80,100, got %v", cfg.UsageAlertThresholds)
+	}
+
+	t.Setenv("AEGIS_USAGE_ALERT_THRESHOLDS", "100, 50,100")
+	if cfg, err = LoadFromEnv(); err != nil || !reflect.DeepEqual(cfg.UsageAlertThresholds, []int{50, 100}) {
+		t.Fatalf("expected sorted distinct thresholds, got %v err=%v", cfg.UsageAlertThresholds, err)
+	}
+
+	t.Setenv("AEGIS_USAGE_ALERT_THRESHOLDS", "80,0")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_USAGE_ALERT_THRESHOLDS") {
+		t.Fatalf("expected a zero threshold to be rejected, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_RelayPrices(t *testing.T) {
+	setRequiredEnv(t)
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.RelayPrices() != nil {
+		t.Fatalf("expected no prices by default, got %v", cfg.RelayPrices())
+	}
+
+	t.Setenv("AEGIS_RELAY_HOURLY_PRICES", "t4g.small=0.0168, eu-west-1/t4g.small=0.0184")
+	t.Setenv("AEGIS_RELAY_DEFAULT_HOURLY_PRICE", "0.05")
+	if cfg, err = LoadFromEnv(); err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	want := []model.RelayPrice{
+		{HourlyUSD: 0.05},
+		{Region: "eu-west-1", InstanceType: "t4g.small", HourlyUSD: 0.0184},
+		{InstanceType: "t4g.small", HourlyUSD: 0.0168},
+	}
+	if got := cfg.RelayPrices(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	for key, value := range map[string]string{
+		"AEGIS_RELAY_HOURLY_PRICES":        "/t4g.small=0.01",
+		"AEGIS_RELAY_DEFAULT_HOURLY_PRICE": "-1",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), key) {
+				t.Fatalf("expected %s=%s to be rejected, got %v", key, value, err)
+			}
+		})
+	}
+	t.Setenv("AEGIS_RELAY_HOURLY_PRICES", "t4g.small=Inf")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Fatal("expected an infinite price to be rejected")
+	}
+}
+
+func TestLoadFromEnv_TerminatorWorkers(t *testing.T) {
+	setRequiredEnv(t)
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.TerminatorWorkers != 4 {
+		t.Fatalf("expected 4 terminator workers by default, got %d", cfg.TerminatorWorkers)
+	}
+
+	t.Setenv("AEGIS_TERMINATOR_WORKERS", "0")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_TERMINATOR_WORKERS") {
+		t.Fatalf("expected zero workers to be rejected, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_CanarySettings(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("AEGIS_CANARY_INTERVAL", "15m")
+	t.Setenv("AEGIS_CANARY_REGIONS", "us-east-1, eu-west-1")
+	t.Setenv("AEGIS_CANARY_INSTANCE_TYPE", "t4g.nano")
+	t.Setenv("AEGIS_CANARY_WEBHOOK", "true")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.CanaryInterval != 15*time.Minute || !reflect.DeepEqual(cfg.CanaryRegions, []string{"us-east-1", "eu-west-1"}) ||
+		cfg.CanaryInstanceType != "t4g.nano" || !cfg.CanaryWebhook {
+		t.Fatalf("unexpected canary settings: %s %v %q %t", cfg.CanaryInterval, cfg.CanaryRegions, cfg.CanaryInstanceType, cfg.CanaryWebhook)
+	}
+	if problems := cfg.Validate(); len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+
+	cfg.CanaryRegions = []string{"ap-south-1"}
+	if problems := cfg.Validate(); len(problems) != 1 || !strings.Contains(problems[0].Error(), "AEGIS_CANARY_REGIONS") {
+		t.Fatalf("expected the unsupported canary region reported, got %v", problems)
+	}
+}
+
+func TestLoadFromEnv_IdempotencyMaxPerUser(t *testing.T) {
+	setRequiredEnv(t)
+	cfg, err := LoadFromEnv()
+	if err != nil || cfg.IdempotencyMaxPerUser != 100 {
+		t.Fatalf("expected default 100, got %d err=%v", cfg.IdempotencyMaxPerUser, err)
+	}
+	t.Setenv("AEGIS_IDEMPOTENCY_MAX_PER_USER", "0")
+	if cfg, err = LoadFromEnv(); err != nil || cfg.IdempotencyMaxPerUser != 0 {
+		t.Fatalf("expected 0 to lift the cap, got %d err=%v", cfg.IdempotencyMaxPerUser, err)
+	}
+	t.Setenv("AEGIS_IDEMPOTENCY_MAX_PER_USER", "-1")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_IDEMPOTENCY_MAX_PER_USER") {
+		t.Fatalf("expected a negative cap rejected, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_RelayPingSettings(t *testing.T) {
+	setRequiredEnv(t)
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	if cfg.RelayMinAgentVersion != "" || cfg.RelayHeartbeatInterval != 30*time.Second || cfg.RelayPingRateLimit != 60 {
+		t.Fatalf("unexpected defaults: version=%q interval=%s limit=%d", cfg.RelayMinAgentVersion, cfg.RelayHeartbeatInterval, cfg.RelayPingRateLimit)
+	}
+	t.Setenv("AEGIS_RELAY_MIN_AGENT_VERSION", " 1.4.0 ")
+	t.Setenv("AEGIS_RELAY_HEARTBEAT_INTERVAL", "15s")
+	t.Setenv("AEGIS_RELAY_PING_RATE_LIMIT", "10")
+	if cfg, err = LoadFromEnv(); err != nil || cfg.RelayMinAgentVersion != "1.4.0" || cfg.RelayHeartbeatInterval != 15*time.Second || cfg.RelayPingRateLimit != 10 {
+		t.Fatalf("unexpected settings: %+v err=%v", cfg, err)
+	}
+	t.Setenv("AEGIS_RELAY_PING_RATE_LIMIT", "0")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_RELAY_PING_RATE_LIMIT") {
+		t.Fatalf("expected a zero limit rejected, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_RelayMinAgentVersionMustParse(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("AEGIS_RELAY_MIN_AGENT_VERSION", "latest")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_RELAY_MIN_AGENT_VERSION") {
+		t.Fatalf("expected an unparsable version rejected, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_RelayWSTemplateAndDNSRecords(t *testing.T) {
+	setRequiredEnv(t)
+	cfg, err := LoadFromEnv()
+	if err != nil || cfg.RelayWSTemplate.String() != "wss://{public_ip}:7443/telemetry" || cfg.RelayDNSRecords {
+		t.Fatalf("unexpected defaults: template=%s records=%t err=%v", cfg.RelayWSTemplate, cfg.RelayDNSRecords, err)
+	}
+	t.Setenv("AEGIS_RELAY_WS_TEMPLATE", "wss://{relay}.example.com/telemetry")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_RELAY_WS_TEMPLATE") {
+		t.Fatalf("expected an unknown placeholder rejected, got %v", err)
+	}
+
+	t.Setenv("AEGIS_RELAY_WS_TEMPLATE", "")
+	t.Setenv("AEGIS_RELAY_DNS_RECORDS", "true")
+	t.Setenv("AEGIS_RELAY_DNS_ZONE_ID", "Z0123")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "{instance_id}") {
+		t.Fatalf("expected an IP-based template rejected with DNS records, got %v", err)
+	}
+	t.Setenv("AEGIS_RELAY_WS_TEMPLATE", "wss://{instance_id}.relays.example.com/telemetry")
+	if cfg, err = LoadFromEnv(); err != nil || !cfg.RelayDNSRecords || cfg.RelayDNSZoneID != "Z0123" {
+		t.Fatalf("unexpected settings: %+v err=%v", cfg, err)
+	}
+	t.Setenv("AEGIS_RELAY_DNS_ZONE_ID", "")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_RELAY_DNS_ZONE_ID") {
+		t.Fatalf("expected a missing zone rejected, got %v", err)
+	}
+}
+
+func TestLoadFromEnv_RelaySRTPorts(t *testing.T) {
+	setRequiredEnv(t)
+	cfg, err := LoadFromEnv()
+	if err != nil || cfg.RelaySRTPortRange != (relay.PortRange{Min: 9000, Max: 9009}) || cfg.RelaySRTPortCount != 1 {
+		t.Fatalf("unexpected defaults: range=%+v count=%d err=%v", cfg.RelaySRTPortRange, cfg.RelaySRTPortCount, err)
+	}
+	t.Setenv("AEGIS_RELAY_SRT_PORT_RANGE", "10000-10001")
+	t.Setenv("AEGIS_RELAY_SRT_PORT_COUNT", "2")
+	if cfg, err = LoadFromEnv(); err != nil || cfg.RelaySRTPortRange.Min != 10000 || cfg.RelaySRTPortCount != 2 {
+		t.Fatalf("unexpected settings: %+v err=%v", cfg, err)
+	}
+	t.Setenv("AEGIS_RELAY_SRT_PORT_COUNT", "3")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_RELAY_SRT_PORT_COUNT") {
+		t.Fatalf("expected a count beyond the range rejected, got %v", err)
+	}
+	t.Setenv("AEGIS_RELAY_SRT_PORT_RANGE", "10001-10000")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "AEGIS_RELAY_SRT_PORT_RANGE") {
+		t.Fatalf("expected an inverted range rejected, got %v", err)
+	}
+}
+
+func TestAWSProvisionerOptions_MapsRetriesAndDNSZone(t *testing.T) {
+	cfg := Config{
+		AWSAMIMap:        map[string]string{"us-east-1": "ami-1"},
+		AWSVerifyAMIs:    true,
+		AWSRetryPolicies: map[string]AWSRetryPolicy{"run_instances": {MaxAttempts: 6, BaseDelay: time.Second, MaxDelay: 4 * time.Second}},
+		RelayDNSZoneID:   "Z0123",
+	}
+	opts := cfg.AWSProvisionerOptions()
+	if !opts.VerifyAMIs || opts.AMIByRegion["us-east-1"] != "ami-1" {
+		t.Fatalf("unexpected options %+v", opts)
+	}
+	if got := opts.RetryPolicies["run_instances"]; got != (relay.RetryPolicy{MaxAttempts: 6, BaseDelay: time.Second, MaxDelay: 4 * time.Second}) {
+		t.Fatalf("unexpected retry policy %+v", got)
+	}
+	if opts.DNSZoneID != "" {
+		t.Fatalf("expected no DNS zone without AEGIS_RELAY_DNS_RECORDS, got %q", opts.DNSZoneID)
+	}
+	cfg.RelayDNSRecords = true
+	if got := cfg.AWSProvisionerOptions().DNSZoneID; got != "Z0123" {
+		t.Fatalf("expected the DNS zone, got %q", got)
+	}
+}
+
+func TestNewRelayRouter_RoutesRegionsToTheirProviders(t *testing.T) {
+	cfg := Config{
+		RelayProvider:   "aws",
+		AWSAMIMap:       map[string]string{"us-east-1": "ami-1"},
+		RegionProviders: map[string]string{"eu-central-1": "fake"},
+	}
+	router, awsProv, err := cfg.NewRelayRouter(nil)
+	if err != nil {
+		t.Fatalf("NewRelayRouter: %v", err)
+	}
+	if awsProv == nil {
+		t.Fatal("expected the AWS provisioner returned")
+	}
+	for region, want := range map[string]string{"us-east-1": "aws", "eu-central-1": "fake"} {
+		if got, err := router.ProviderFor(region); err != nil || got != want {
+			t.Fatalf("ProviderFor(%s) = %q, %v; want %q", region, got, err, want)
+		}
+	}
+
+	cfg.RegionProviders = map[string]string{"eu-central-1": "docker"}
+	if _, _, err := cfg.NewRelayRouter(nil); err == nil || !strings.Contains(err.Error(), "init docker provisioner") {
+		t.Fatalf("expected the docker provider's error, got %v", err)
+	}
+}
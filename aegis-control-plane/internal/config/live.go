@@ -0,0 +1,228 @@
+package config
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Live holds the running Config. The provisioning-related fields can be
+// swapped at runtime; everything else keeps the value it had at startup.
+type Live struct {
+	mu  sync.Mutex
+	cur atomic.Pointer[Config]
+}
+
+func NewLive(cfg Config) *Live {
+	l := &Live{}
+	l.cur.Store(&cfg)
+	return l
+}
+
+func (l *Live) Get() Config {
+	return *l.cur.Load()
+}
+
+// Reload applies the reloadable fields of next and returns the environment
+// names of non-reloadable settings that differ from the running values.
+// Those settings keep their current values.
+func (l *Live) Reload(next Config) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cur := l.Get()
+	var rejected []string
+	if next.ListenAddr != cur.ListenAddr {
+		rejected = append(rejected, "AEGIS_LISTEN_ADDR")
+	}
+	if next.AdminListenAddr != cur.AdminListenAddr {
+		rejected = append(rejected, "AEGIS_ADMIN_LISTEN_ADDR")
+	}
+	if next.DatabaseURL != cur.DatabaseURL {
+		rejected = append(rejected, "AEGIS_DATABASE_URL")
+	}
+	// The pools are opened at startup.
+	if next.DB != cur.DB {
+		rejected = append(rejected, "AEGIS_DB_*")
+	}
+	if next.JobsDB != cur.JobsDB {
+		rejected = append(rejected, "AEGIS_JOBS_DB_*")
+	}
+	// The certificate reloader keeps re-reading the files it started with.
+	if next.TLSCertFile != cur.TLSCertFile {
+		rejected = append(rejected, "AEGIS_TLS_CERT_FILE")
+	}
+	if next.TLSKeyFile != cur.TLSKeyFile {
+		rejected = append(rejected, "AEGIS_TLS_KEY_FILE")
+	}
+	if next.JWTSecret != cur.JWTSecret {
+		rejected = append(rejected, "AEGIS_JWT_SECRET")
+	}
+	if next.RelaySharedKey != cur.RelaySharedKey {
+		rejected = append(rejected, "AEGIS_RELAY_SHARED_KEY")
+	}
+	if next.RelayProvider != cur.RelayProvider {
+		rejected = append(rejected, "AEGIS_RELAY_PROVIDER")
+	}
+	// Debug routes are mounted when the router is built.
+	if next.EnablePprof != cur.EnablePprof {
+		rejected = append(rejected, "AEGIS_ENABLE_PPROF")
+	}
+	// Route timeouts are set when the router is built.
+	if next.HTTPRequestTimeout != cur.HTTPRequestTimeout {
+		rejected = append(rejected, "AEGIS_HTTP_REQUEST_TIMEOUT")
+	}
+	if next.HTTPStartTimeout != cur.HTTPStartTimeout {
+		rejected = append(rejected, "AEGIS_HTTP_START_TIMEOUT")
+	}
+	if next.HTTPFastTimeout != cur.HTTPFastTimeout {
+		rejected = append(rejected, "AEGIS_HTTP_FAST_TIMEOUT")
+	}
+	if next.HTTPStopTimeout != cur.HTTPStopTimeout {
+		rejected = append(rejected, "AEGIS_HTTP_STOP_TIMEOUT")
+	}
+	// So are the servers' timeouts, and the shutdown grace is read once.
+	if next.HTTPReadTimeout != cur.HTTPReadTimeout {
+		rejected = append(rejected, "AEGIS_HTTP_READ_TIMEOUT")
+	}
+	if next.HTTPWriteTimeout != cur.HTTPWriteTimeout {
+		rejected = append(rejected, "AEGIS_HTTP_WRITE_TIMEOUT")
+	}
+	if next.ShutdownGrace != cur.ShutdownGrace {
+		rejected = append(rejected, "AEGIS_SHUTDOWN_GRACE")
+	}
+	// The provision slots are sized when the router is built.
+	if next.ProvisionConcurrency != cur.ProvisionConcurrency {
+		rejected = append(rejected, "AEGIS_PROVISION_CONCURRENCY")
+	}
+	// The store is configured at startup.
+	if next.IdempotencyMaxPerUser != cur.IdempotencyMaxPerUser {
+		rejected = append(rejected, "AEGIS_IDEMPOTENCY_MAX_PER_USER")
+	}
+	// The alerter and its failure window are built at startup.
+	if next.AlertSlackWebhookURL != cur.AlertSlackWebhookURL {
+		rejected = append(rejected, "AEGIS_ALERT_SLACK_WEBHOOK_URL")
+	}
+	if next.AlertWebhookURL != cur.AlertWebhookURL {
+		rejected = append(rejected, "AEGIS_ALERT_WEBHOOK_URL")
+	}
+	if next.AlertCooldown != cur.AlertCooldown {
+		rejected = append(rejected, "AEGIS_ALERT_COOLDOWN")
+	}
+	if next.AlertProvisionFailures != cur.AlertProvisionFailures {
+		rejected = append(rejected, "AEGIS_ALERT_PROVISION_FAILURES")
+	}
+	if next.AlertProvisionFailureWindow != cur.AlertProvisionFailureWindow {
+		rejected = append(rejected, "AEGIS_ALERT_PROVISION_FAILURE_WINDOW")
+	}
+	if next.AlertTerminationAttempts != cur.AlertTerminationAttempts {
+		rejected = append(rejected, "AEGIS_ALERT_TERMINATION_ATTEMPTS")
+	}
+	// The provider set is built at startup.
+	if !maps.Equal(next.RegionProviders, cur.RegionProviders) {
+		rejected = append(rejected, "AEGIS_REGION_PROVIDER_MAP")
+	}
+	// Providers keep their first WS template and DNS zone, so that running
+	// relays' records can still be found.
+	if next.RelayWSTemplate != cur.RelayWSTemplate {
+		rejected = append(rejected, "AEGIS_RELAY_WS_TEMPLATE")
+	}
+	if next.RelayDNSRecords != cur.RelayDNSRecords {
+		rejected = append(rejected, "AEGIS_RELAY_DNS_RECORDS")
+	}
+	if next.RelayDNSZoneID != cur.RelayDNSZoneID {
+		rejected = append(rejected, "AEGIS_RELAY_DNS_ZONE_ID")
+	}
+	if next.FakeProvisionLatencyMin != cur.FakeProvisionLatencyMin || next.FakeProvisionLatencyMax != cur.FakeProvisionLatencyMax {
+		rejected = append(rejected, "AEGIS_FAKE_PROVISION_LATENCY")
+	}
+	if next.FakeFailureRate != cur.FakeFailureRate {
+		rejected = append(rejected, "AEGIS_FAKE_FAILURE_RATE")
+	}
+	if !slices.Equal(next.FakeErrorCodes, cur.FakeErrorCodes) {
+		rejected = append(rejected, "AEGIS_FAKE_ERROR_CODES")
+	}
+	if next.FakeFailFirst != cur.FakeFailFirst {
+		rejected = append(rejected, "AEGIS_FAKE_FAIL_FIRST")
+	}
+	if next.DockerHost != cur.DockerHost {
+		rejected = append(rejected, "AEGIS_DOCKER_HOST")
+	}
+	if next.DockerRelayImage != cur.DockerRelayImage {
+		rejected = append(rejected, "AEGIS_DOCKER_RELAY_IMAGE")
+	}
+	if next.DockerPortMin != cur.DockerPortMin || next.DockerPortMax != cur.DockerPortMax {
+		rejected = append(rejected, "AEGIS_DOCKER_PORT_RANGE")
+	}
+	if next.HetznerToken != cur.HetznerToken {
+		rejected = append(rejected, "AEGIS_HETZNER_TOKEN")
+	}
+	if !maps.Equal(next.HetznerImageMap, cur.HetznerImageMap) {
+		rejected = append(rejected, "AEGIS_HETZNER_IMAGE_MAP")
+	}
+	if next.HetznerServerType != cur.HetznerServerType {
+		rejected = append(rejected, "AEGIS_HETZNER_SERVER_TYPE")
+	}
+	if !maps.Equal(next.HetznerServerTypes, cur.HetznerServerTypes) {
+		rejected = append(rejected, "AEGIS_HETZNER_SERVER_TYPE_MAP")
+	}
+	if !maps.Equal(next.HetznerLocations, cur.HetznerLocations) {
+		rejected = append(rejected, "AEGIS_HETZNER_LOCATION_MAP")
+	}
+	if !slices.Equal(next.HetznerSSHKeys, cur.HetznerSSHKeys) {
+		rejected = append(rejected, "AEGIS_HETZNER_SSH_KEYS")
+	}
+	if next.HetznerProvisionWaitTimeout != cur.HetznerProvisionWaitTimeout {
+		rejected = append(rejected, "AEGIS_HETZNER_PROVISION_WAIT_TIMEOUT")
+	}
+
+	updated := cur
+	updated.DefaultRegion = next.DefaultRegion
+	updated.SupportedRegion = next.SupportedRegion
+	updated.AWSAMIMap = next.AWSAMIMap
+	updated.AWSInstanceType = next.AWSInstanceType
+	updated.AWSSubnetID = next.AWSSubnetID
+	updated.AWSSubnetIDs = next.AWSSubnetIDs
+	updated.AWSSecurityIDs = next.AWSSecurityIDs
+	updated.AWSKeyName = next.AWSKeyName
+	updated.AWSInstanceProfileARN = next.AWSInstanceProfileARN
+	updated.AWSProvisionWaitTimeout = next.AWSProvisionWaitTimeout
+	updated.AWSProvisionPollInterval = next.AWSProvisionPollInterval
+	updated.AWSFallbackTypes = next.AWSFallbackTypes
+	updated.AWSFallbackRegions = next.AWSFallbackRegions
+	updated.AWSUseSpot = next.AWSUseSpot
+	updated.AWSEIPPool = next.AWSEIPPool
+	updated.AWSSessionSecurityGroups = next.AWSSessionSecurityGroups
+	updated.AWSWarmPoolSize = next.AWSWarmPoolSize
+	updated.AWSWarmPoolMaxAge = next.AWSWarmPoolMaxAge
+	updated.AWSTerminateVerifyTimeout = next.AWSTerminateVerifyTimeout
+	updated.AWSBreakerFailureThreshold = next.AWSBreakerFailureThreshold
+	updated.AWSBreakerCooldown = next.AWSBreakerCooldown
+	updated.AWSRetryPolicies = next.AWSRetryPolicies
+	updated.AWSRetryBudget = next.AWSRetryBudget
+	updated.RelayControlPlaneURL = next.RelayControlPlaneURL
+	updated.InternalToken = next.InternalToken
+	updated.ExternalBaseURL = next.ExternalBaseURL
+	updated.TrustForwardedProto = next.TrustForwardedProto
+	updated.RelaySRTPortRange = next.RelaySRTPortRange
+	updated.RelaySRTPortCount = next.RelaySRTPortCount
+	updated.RelayBootProbe = next.RelayBootProbe
+	updated.RelayBootProbeTimeout = next.RelayBootProbeTimeout
+	updated.RelayMinAgentVersion = next.RelayMinAgentVersion
+	updated.RelayHeartbeatInterval = next.RelayHeartbeatInterval
+	updated.RelayPingRateLimit = next.RelayPingRateLimit
+	updated.RelayHourlyPrices = next.RelayHourlyPrices
+	updated.RelayDefaultHourlyPrice = next.RelayDefaultHourlyPrice
+	updated.UnavailableRetryAfter = next.UnavailableRetryAfter
+	updated.ProvisionQueueTimeout = next.ProvisionQueueTimeout
+	updated.PairTokenLength = next.PairTokenLength
+	updated.MaskSessionCredentials = next.MaskSessionCredentials
+	updated.DisableSessionClientInfo = next.DisableSessionClientInfo
+	updated.AdminMaxLiveSessions = next.AdminMaxLiveSessions
+	updated.FreeIncludedSeconds = next.FreeIncludedSeconds
+	updated.UsageAlertThresholds = next.UsageAlertThresholds
+	updated.DrainPeriod = next.DrainPeriod
+	l.cur.Store(&updated)
+	return rejected
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+)
+
+// NewRelayRouter builds every provider the config names and routes regions
+// to them per AEGIS_REGION_PROVIDER_MAP. The API and the jobs worker share
+// it, so the worker resolves a relay's provider the way the API launched it.
+// The AWS provisioner, nil when no region uses it, is also returned for
+// reloads; warmPool backs its warm pool.
+func (c Config) NewRelayRouter(warmPool relay.WarmPool) (*relay.Router, *relay.AWSProvisioner, error) {
+	providers := make(map[string]relay.Provisioner)
+	var awsProv *relay.AWSProvisioner
+	for _, name := range c.Providers() {
+		switch name {
+		case "aws":
+			p, err := relay.NewAWSProvisioner(c.AWSProvisionerOptions())
+			if err != nil {
+				return nil, nil, fmt.Errorf("init aws provisioner: %w", err)
+			}
+			p.SetWarmPool(warmPool)
+			awsProv = p
+			providers[name] = p
+		case "hetzner":
+			p, err := relay.NewHetznerProvisioner(c.HetznerProvisionerOptions())
+			if err != nil {
+				return nil, nil, fmt.Errorf("init hetzner provisioner: %w", err)
+			}
+			providers[name] = p
+		case "docker":
+			p, err := relay.NewDockerProvisioner(c.DockerProvisionerOptions())
+			if err != nil {
+				return nil, nil, fmt.Errorf("init docker provisioner: %w", err)
+			}
+			providers[name] = p
+		default:
+			providers[name] = relay.NewFakeProvisioner(c.FakeProvisionerOptions()...)
+		}
+	}
+	router, err := relay.NewRouter(providers, c.RegionProviders, c.RelayProvider)
+	if err != nil {
+		return nil, nil, fmt.Errorf("init relay router: %w", err)
+	}
+	return router, awsProv, nil
+}
+
+// AWSProvisionerOptions maps the AEGIS_AWS_* settings. The API and the jobs
+// worker both launch relays (the worker replaces relays and fills the warm
+// pool), so they use the same options.
+func (c Config) AWSProvisionerOptions() relay.AWSProvisionerOptions {
+	return relay.AWSProvisionerOptions{
+		AMIByRegion:   c.AWSAMIMap,
+		InstanceType:  c.AWSInstanceType,
+		SubnetID:      c.AWSSubnetID,
+		SecurityGroup: c.AWSSecurityIDs,
+		KeyName:       c.AWSKeyName,
+		VerifyAMIs:    c.AWSVerifyAMIs,
+
+		InstanceProfileARN: c.AWSInstanceProfileARN,
+
+		ProvisionWaitTimeout:  c.AWSProvisionWaitTimeout,
+		ProvisionPollInterval: c.AWSProvisionPollInterval,
+		FallbackInstanceTypes: c.AWSFallbackTypes,
+		FallbackRegions:       c.AWSFallbackRegions,
+		UseSpot:               c.AWSUseSpot,
+		// Deprovision needs the pool to return pooled addresses rather
+		// than release them.
+		EIPPool:               c.AWSEIPPool,
+		SubnetsByRegion:       c.AWSSubnetIDs,
+		SessionSecurityGroups: c.AWSSessionSecurityGroups,
+
+		WarmPoolSize:   c.AWSWarmPoolSize,
+		WarmPoolMaxAge: c.AWSWarmPoolMaxAge,
+
+		TerminateVerifyTimeout:  c.AWSTerminateVerifyTimeout,
+		BreakerFailureThreshold: c.AWSBreakerFailureThreshold,
+		BreakerCooldown:         c.AWSBreakerCooldown,
+		RetryPolicies:           awsRetryPolicies(c.AWSRetryPolicies),
+		RetryBudget:             c.AWSRetryBudget,
+
+		WSTemplate: c.RelayWSTemplate,
+		DNSZoneID:  c.relayDNSZoneID(),
+	}
+}
+
+// relayDNSZoneID is the zone relay records are published in, "" unless
+// AEGIS_RELAY_DNS_RECORDS is on.
+func (c Config) relayDNSZoneID() string {
+	if !c.RelayDNSRecords {
+		return ""
+	}
+	return c.RelayDNSZoneID
+}
+
+func awsRetryPolicies(in map[string]AWSRetryPolicy) map[string]relay.RetryPolicy {
+	out := make(map[string]relay.RetryPolicy, len(in))
+	for op, p := range in {
+		out[op] = relay.RetryPolicy(p)
+	}
+	return out
+}
+
+// HetznerProvisionerOptions maps the AEGIS_HETZNER_* settings.
+func (c Config) HetznerProvisionerOptions() relay.HetznerProvisionerOptions {
+	return relay.HetznerProvisionerOptions{
+		Token:                c.HetznerToken,
+		ImageByRegion:        c.HetznerImageMap,
+		ServerType:           c.HetznerServerType,
+		ServerTypeByRegion:   c.HetznerServerTypes,
+		LocationByRegion:     c.HetznerLocations,
+		SSHKeys:              c.HetznerSSHKeys,
+		ProvisionWaitTimeout: c.HetznerProvisionWaitTimeout,
+		WSTemplate:           c.RelayWSTemplate,
+	}
+}
+
+// DockerProvisionerOptions maps the AEGIS_DOCKER_* settings.
+func (c Config) DockerProvisionerOptions() relay.DockerProvisionerOptions {
+	return relay.DockerProvisionerOptions{
+		Host:    c.DockerHost,
+		Image:   c.DockerRelayImage,
+		PortMin: c.DockerPortMin,
+		PortMax: c.DockerPortMax,
+	}
+}
+
+// FakeProvisionerOptions applies the AEGIS_FAKE_* failure and latency
+// injection settings.
+func (c Config) FakeProvisionerOptions() []relay.FakeOption {
+	return []relay.FakeOption{
+		relay.WithFakeLatency(c.FakeProvisionLatencyMin, c.FakeProvisionLatencyMax),
+		relay.WithFakeFailureRate(c.FakeFailureRate),
+		relay.WithFakeErrorCodes(c.FakeErrorCodes...),
+		relay.WithFakeFailFirst(c.FakeFailFirst),
+		relay.WithFakeWSTemplate(c.RelayWSTemplate),
+	}
+}
@@ -0,0 +1,161 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+	"github.com/telemyapp/aegis-control-plane/internal/session"
+)
+
+const (
+	// canaryRunTimeout bounds a canary's start and verification in one
+	// region; the stop that follows gets canaryStopTimeout of its own.
+	canaryRunTimeout  = 5 * time.Minute
+	canaryStopTimeout = 30 * time.Second
+)
+
+// Canary run outcomes, as counted in aegis_canary_runs_total.
+const (
+	canaryOK           = "ok"
+	canaryStartFailed  = "start_failed"
+	canaryVerifyFailed = "verify_failed"
+	canaryStopFailed   = "stop_failed"
+)
+
+// SessionService starts and stops sessions the way the API does.
+type SessionService interface {
+	Start(ctx context.Context, cmd session.StartCommand) (*model.Session, bool, error)
+	Stop(ctx context.Context, cmd session.StopCommand) (*model.Session, error)
+}
+
+// CanaryOptions configure the synthetic sessions WithCanary runs.
+type CanaryOptions struct {
+	Interval time.Duration
+	Regions  []string
+	// InstanceType launches canary relays on another (cheaper) instance
+	// type, on providers that have them.
+	InstanceType string
+	// Probe, when set, must reach each canary relay within ProbeTimeout.
+	Probe        relay.BootProbe
+	ProbeTimeout time.Duration
+	// Webhook reports failed runs to the global webhooks as canary_failed.
+	Webhook bool
+}
+
+// WithCanary starts, verifies and stops a session in each of opts.Regions
+// every opts.Interval, as model.CanaryUserID and through sessions, so a
+// region that cannot serve starts is noticed before users are.
+func WithCanary(sessions SessionService, opts CanaryOptions) Option {
+	return func(r *Runner) {
+		r.canarySessions = sessions
+		r.canary = opts
+	}
+}
+
+// runCanaries runs the canary in each region in turn. Failed runs are
+// counted and, when enabled, reported to webhooks; only store errors fail
+// the job run.
+func (r *Runner) runCanaries(ctx context.Context) error {
+	var errs []error
+	for _, region := range r.canary.Regions {
+		if err := r.runCanary(ctx, region); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Runner) runCanary(ctx context.Context, region string) error {
+	start := time.Now()
+	sessionID, outcome, runErr := r.canaryCycle(ctx, region)
+	durMS := time.Since(start).Milliseconds()
+	labels := map[string]string{"region": region, "outcome": outcome}
+	metrics.Default().IncCounter("aegis_canary_runs_total", labels)
+	metrics.Default().ObserveHistogram("aegis_canary_duration_ms", float64(durMS), labels)
+	if runErr == nil {
+		log.Printf("canary ok region=%s session_id=%s duration_ms=%d", region, sessionID, durMS)
+		return nil
+	}
+	log.Printf("canary failed region=%s session_id=%s outcome=%s duration_ms=%d err=%v", region, sessionID, outcome, durMS, runErr)
+	if !r.canary.Webhook {
+		return nil
+	}
+	return r.store.RecordCanaryFailure(ctx, model.CanaryFailure{
+		Region:    region,
+		SessionID: sessionID,
+		Outcome:   outcome,
+		Error:     runErr.Error(),
+		FailedAt:  time.Now(),
+	})
+}
+
+// canaryCycle starts a canary session in region, verifies it and stops it,
+// and returns the session's ID and the run's outcome.
+func (r *Runner) canaryCycle(ctx context.Context, region string) (string, string, error) {
+	runCtx, cancel := context.WithTimeout(ctx, canaryRunTimeout)
+	defer cancel()
+	sess, _, err := r.canarySessions.Start(runCtx, session.StartCommand{
+		UserID:         model.CanaryUserID,
+		Region:         region,
+		RequestedBy:    "canary",
+		IdempotencyKey: uuid.New(),
+		Canary:         true,
+		NonBillable:    true,
+		InstanceType:   r.canary.InstanceType,
+	})
+	if err != nil {
+		// A failed start stops its own session.
+		return "", canaryStartFailed, err
+	}
+	verifyErr := r.verifyCanary(runCtx, sess)
+
+	// The stop still runs when verification used up the run's time.
+	stopCtx, stopCancel := context.WithTimeout(context.WithoutCancel(ctx), canaryStopTimeout)
+	defer stopCancel()
+	_, stopErr := r.canarySessions.Stop(stopCtx, session.StopCommand{
+		UserID:    model.CanaryUserID,
+		SessionID: sess.ID,
+		Reason:    model.StopReasonCanary,
+	})
+	switch {
+	case verifyErr != nil:
+		return sess.ID, canaryVerifyFailed, errors.Join(verifyErr, stopErr)
+	case stopErr != nil:
+		return sess.ID, canaryStopFailed, stopErr
+	}
+	return sess.ID, canaryOK, nil
+}
+
+// verifyCanary checks that the session came up active on a relay that
+// accepts connections.
+func (r *Runner) verifyCanary(ctx context.Context, sess *model.Session) error {
+	if sess.Status != model.SessionActive {
+		return fmt.Errorf("session is %s, not active", sess.Status)
+	}
+	if sess.WSURL == "" && sess.PublicIP == "" {
+		return errors.New("session has no relay address")
+	}
+	if r.canary.Probe == nil {
+		return nil
+	}
+	if r.canary.ProbeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.canary.ProbeTimeout)
+		defer cancel()
+	}
+	return r.canary.Probe.WaitReady(ctx, relay.ProvisionResult{
+		AWSInstanceID: sess.RelayAWSInstanceID,
+		PublicIP:      sess.PublicIP,
+		SRTPort:       sess.SRTPort,
+		SRTPorts:      sess.SRTPorts,
+		WSURL:         sess.WSURL,
+	})
+}
@@ -0,0 +1,146 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/config"
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+	"github.com/telemyapp/aegis-control-plane/internal/session"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+// canarySessionStore keeps the sessions a canary run starts, for a real
+// session.Service.
+type canarySessionStore struct {
+	starts    []store.StartInput
+	activated []store.ActivateProvisionedSessionInput
+	stopped   []string
+}
+
+func (f *canarySessionStore) StartOrGetSession(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+	f.starts = append(f.starts, in)
+	return &model.Session{ID: "ses_" + in.Region, UserID: in.UserID, Status: model.SessionProvisioning, Region: in.Region}, true, nil
+}
+
+func (f *canarySessionStore) PreviewStart(context.Context, store.StartInput) (store.StartPreview, error) {
+	return store.StartPreview{}, nil
+}
+
+func (f *canarySessionStore) AdvanceProvisioningPhase(context.Context, string, string, string) error {
+	return nil
+}
+
+func (f *canarySessionStore) ActivateProvisionedSession(_ context.Context, in store.ActivateProvisionedSessionInput) (*model.Session, error) {
+	f.activated = append(f.activated, in)
+	return &model.Session{ID: in.SessionID, UserID: in.UserID, Status: model.SessionActive, Region: in.Region, PublicIP: in.PublicIP, SRTPorts: in.SRTPorts, WSURL: in.WSURL}, nil
+}
+
+func (f *canarySessionStore) StopSession(_ context.Context, in store.StopSessionInput) (*model.Session, error) {
+	f.stopped = append(f.stopped, in.SessionID+":"+in.Reason)
+	return &model.Session{ID: in.SessionID, Status: model.SessionStopping}, nil
+}
+
+func (f *canarySessionStore) StopProvisionedSession(_ context.Context, _, sessionID, _, _ string) (*model.Session, error) {
+	f.stopped = append(f.stopped, sessionID+":provisioned")
+	return &model.Session{ID: sessionID, Status: model.SessionStopping}, nil
+}
+
+func (f *canarySessionStore) RecordProvisionAttempt(context.Context, model.ProvisionAttempt) (int64, error) {
+	return 1, nil
+}
+
+func (f *canarySessionStore) SetProvisionAttemptCompensation(context.Context, int64, string) error {
+	return nil
+}
+
+func canaryService(st session.Store, prov relay.Provisioner) *session.Service {
+	return session.NewService(st, prov, config.NewLive(config.Config{PairTokenLength: 8}))
+}
+
+type probeFunc func(context.Context, relay.ProvisionResult) error
+
+func (f probeFunc) WaitReady(ctx context.Context, res relay.ProvisionResult) error {
+	return f(ctx, res)
+}
+
+func TestRunCanaries_CountsOutcomesAndReportsFailures(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	sessions := &canarySessionStore{}
+	st := &fakeStore{}
+	// The first launch fails, so the first region's start does.
+	prov := relay.NewFakeProvisioner(relay.WithFakeFailFirst(1), relay.WithFakeErrorCodes("InsufficientInstanceCapacity"))
+	r := NewRunner(st, prov, "fake", WithCanary(canaryService(sessions, prov), CanaryOptions{
+		Interval:     time.Minute,
+		Regions:      []string{"us-east-1", "eu-west-1"},
+		InstanceType: "t4g.nano",
+		Webhook:      true,
+	}))
+
+	if err := r.runCanaries(context.Background()); err != nil {
+		t.Fatalf("runCanaries: %v", err)
+	}
+	for _, in := range sessions.starts {
+		if in.UserID != model.CanaryUserID || !in.Canary {
+			t.Fatalf("expected canary starts as the canary user, got %+v", in)
+		}
+	}
+	if len(sessions.activated) != 1 || sessions.activated[0].InstanceType != "t4g.nano" {
+		t.Fatalf("expected eu-west-1 to come up on t4g.nano, got %+v", sessions.activated)
+	}
+	want := []string{"ses_us-east-1:" + model.StopReasonStartFailed, "ses_eu-west-1:" + model.StopReasonCanary}
+	if strings.Join(sessions.stopped, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected %v stopped, got %v", want, sessions.stopped)
+	}
+	if len(st.canaryFailures) != 1 || st.canaryFailures[0].Region != "us-east-1" || st.canaryFailures[0].Outcome != canaryStartFailed {
+		t.Fatalf("expected one us-east-1 start failure reported, got %+v", st.canaryFailures)
+	}
+	out := metrics.Default().Render()
+	for _, series := range []string{
+		`aegis_canary_runs_total{outcome="start_failed",region="us-east-1"} 1`,
+		`aegis_canary_runs_total{outcome="ok",region="eu-west-1"} 1`,
+		`aegis_canary_duration_ms_count{outcome="ok",region="eu-west-1"} 1`,
+	} {
+		if !strings.Contains(out, series) {
+			t.Fatalf("expected %s, got:\n%s", series, out)
+		}
+	}
+}
+
+func TestRunCanaries_StopsSessionThatFailsVerification(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	sessions := &canarySessionStore{}
+	st := &fakeStore{}
+	prov := relay.NewFakeProvisioner()
+	var probed []string
+	r := NewRunner(st, prov, "fake", WithCanary(canaryService(sessions, prov), CanaryOptions{
+		Interval: time.Minute,
+		Regions:  []string{"us-east-1"},
+		Probe: probeFunc(func(_ context.Context, res relay.ProvisionResult) error {
+			probed = append(probed, res.WSURL)
+			return errors.New("connection refused")
+		}),
+		ProbeTimeout: time.Second,
+	}))
+
+	if err := r.runCanaries(context.Background()); err != nil {
+		t.Fatalf("runCanaries: %v", err)
+	}
+	if len(probed) != 1 || probed[0] == "" {
+		t.Fatalf("expected the relay probed at its WS URL, got %v", probed)
+	}
+	if len(sessions.stopped) != 1 || sessions.stopped[0] != "ses_us-east-1:"+model.StopReasonCanary {
+		t.Fatalf("expected the canary session stopped, got %v", sessions.stopped)
+	}
+	if len(st.canaryFailures) != 0 {
+		t.Fatalf("expected no webhook report when disabled, got %+v", st.canaryFailures)
+	}
+	if out := metrics.Default().Render(); !strings.Contains(out, `aegis_canary_runs_total{outcome="verify_failed",region="us-east-1"} 1`) {
+		t.Fatalf("expected a verify failure counted, got:\n%s", out)
+	}
+}
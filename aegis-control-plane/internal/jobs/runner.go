@@ -2,43 +2,1090 @@ package jobs
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/telemyapp/aegis-control-plane/internal/alert"
 	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+// RelayHeartbeatTimeout is how long a relay may go without a heartbeat
+// before relay_replacement treats it as stale.
+const RelayHeartbeatTimeout = 90 * time.Second
+
+const (
+	terminationBatchSize  = 10
+	terminationLease      = 5 * time.Minute
+	terminationBaseDelay  = 15 * time.Second
+	terminationMaxBackoff = 10 * time.Minute
+
+	replacementBatchSize = 10
+	// replacementLease must outlast a Provision call, fallbacks included.
+	replacementLease = 10 * time.Minute
+
+	// warmPoolLaunchBatch caps pool launches per region per maintenance run.
+	warmPoolLaunchBatch = 2
+
+	orphanReaperBatchSize = 50
+	// terminationStuckAfter is how long a relay may stay unconfirmed before
+	// the orphan reaper re-issues its termination; after orphanAlertAfter
+	// it alerts operators as well.
+	terminationStuckAfter = 10 * time.Minute
+	orphanAlertAfter      = time.Hour
+
+	webhookBatchSize  = 20
+	webhookLease      = 2 * time.Minute
+	webhookBaseDelay  = 30 * time.Second
+	webhookMaxBackoff = time.Hour
+
+	billingExportBatchSize  = 20
+	billingExportLease      = 5 * time.Minute
+	billingExportBaseDelay  = time.Minute
+	billingExportMaxBackoff = 6 * time.Hour
+	// billingExportSettle lets the last usage rollups of an ended cycle land
+	// before it is exported; cycles that ended more than
+	// billingExportLookback ago are not queued.
+	billingExportSettle   = 15 * time.Minute
+	billingExportLookback = 7 * 24 * time.Hour
+
+	// healthRetentionBatchSize bounds each delete of expired health events
+	// and changefeed rows; a run stops after healthRetentionMaxBatches and
+	// leaves the rest to the next one.
+	healthRetentionBatchSize  = 5000
+	healthRetentionMaxBatches = 100
+
+	// relayQualityLookbackDays is how far back the first relay_quality_rollup
+	// run starts; later runs pick up after the last day they rolled up.
+	relayQualityLookbackDays = 7
+
+	jobRunPollInterval = 5 * time.Second
+	jobRunBatchSize    = 5
 )
 
 type Store interface {
 	CleanupExpiredIdempotencyRecords(context.Context) error
-	RollupLiveSessionDurations(context.Context) error
-	ReconcileOutageFromHealth(context.Context) error
-	UpsertUsageRollups(context.Context) error
+	RollupLiveSessionDurations(context.Context) (int, error)
+	RollupLiveSessionCosts(context.Context) (int, error)
+	ReconcileOutageFromHealth(context.Context) (int, error)
+	DeleteRelayHealthEventsBefore(ctx context.Context, cutoff time.Time, limit int) (int, error)
+	DeleteSessionChanges(ctx context.Context, cutoff time.Time, limit int) (int, error)
+	RollupRelayQuality(ctx context.Context, gapThreshold time.Duration, lookbackDays int) (int, error)
+	UpsertUsageRollups(context.Context) (int, error)
+	RecordUsageAlerts(ctx context.Context, thresholds []int) ([]model.UsageAlert, error)
+	ClaimRelayTerminations(ctx context.Context, limit int, lease time.Duration) ([]model.RelayTermination, error)
+	CompleteRelayTermination(ctx context.Context, t model.RelayTermination, confirmed bool, took time.Duration) error
+	RetryRelayTermination(ctx context.Context, id int64, lastErr string, nextAttemptAt time.Time) error
+	ListUnconfirmedTerminations(ctx context.Context, limit int) ([]model.TerminatingRelay, error)
+	CountPendingTerminations(ctx context.Context) (int, error)
+	CountSessionsByStatusRegion(ctx context.Context) (map[model.SessionStatus]map[string]int, error)
+	CountRelaysByAgentVersion(ctx context.Context) (map[string]int, error)
+	MarkRelayTerminated(ctx context.Context, relayInstanceID string) error
+	RecordRelayTerminateRetry(ctx context.Context, relayInstanceID string) error
+	ListRelayReplacementCandidates(ctx context.Context, heartbeatTimeout, claimLease time.Duration, limit int) ([]model.RelayCheck, error)
+	ClaimRelayReplacement(ctx context.Context, sessionID, relayInstanceID string, lease time.Duration) (bool, error)
+	ReleaseRelayReplacement(ctx context.Context, sessionID string) error
+	ReplaceSessionRelay(ctx context.Context, in store.ReplaceSessionRelayInput) (*model.Session, error)
+	ListPooledRelays(ctx context.Context) ([]model.PooledRelay, error)
+	AddPooledRelay(ctx context.Context, in model.PooledRelay) error
+	MarkPooledRelayAvailable(ctx context.Context, awsInstanceID string) error
+	RemovePooledRelay(ctx context.Context, awsInstanceID string) (bool, error)
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]model.WebhookDelivery, error)
+	CompleteWebhookDelivery(ctx context.Context, id int64, statusCode int) error
+	RetryWebhookDelivery(ctx context.Context, id int64, statusCode int, lastErr string, nextAttemptAt time.Time) error
+	DeadLetterWebhookDelivery(ctx context.Context, id int64, statusCode int, lastErr string) error
+	EnqueueBillingExports(ctx context.Context, settle, lookback time.Duration) (int, error)
+	ClaimBillingExports(ctx context.Context, limit int, lease time.Duration) ([]model.BillingExport, error)
+	CompleteBillingExport(ctx context.Context, userID string, cycleStart time.Time, usageRecordID string) error
+	RetryBillingExport(ctx context.Context, userID string, cycleStart time.Time, lastErr string, nextAttemptAt time.Time) error
+	FailBillingExport(ctx context.Context, userID string, cycleStart time.Time, lastErr string) error
+	CountFailedBillingExports(ctx context.Context) (int, error)
+	ClaimJobRuns(ctx context.Context, limit int) ([]model.JobRun, error)
+	FinishJobRun(ctx context.Context, id int64, runErr string) error
+	RecordCanaryFailure(ctx context.Context, f model.CanaryFailure) error
+}
+
+// WebhookSender posts one webhook delivery and returns the response status
+// code, or 0 when there was no response.
+type WebhookSender interface {
+	Send(ctx context.Context, d model.WebhookDelivery) (int, error)
+}
+
+// UsageReporter reports a cycle's overage to the billing provider and
+// returns the provider's usage record ID. Reporting the same export twice
+// must not bill twice.
+type UsageReporter interface {
+	ReportUsage(ctx context.Context, e model.BillingExport) (string, error)
 }
 
 type Runner struct {
-	store Store
+	store           Store
+	provisioner     relay.Provisioner
+	terminator      *relay.Terminator
+	provider        string
+	controlPlaneURL string
+	srtPortRange    relay.PortRange
+	srtPortCount    int
+
+	usageAlertThresholds []int
+
+	webhooks           WebhookSender
+	webhookMaxAttempts int
+
+	billing                  UsageReporter
+	billingExportMaxAttempts int
+
+	healthEventRetention   time.Duration
+	sessionChangeRetention time.Duration
+
+	canarySessions SessionService
+	canary         CanaryOptions
+
+	alerts                   *alert.Hook
+	alertTerminationAttempts int
+
+	// sessionSeries holds the aegis_active_sessions series set by the last
+	// sample, so those that drop to zero can be removed.
+	sessionSeriesMu sync.Mutex
+	sessionSeries   map[sessionSeriesKey]bool
+	agentSeries     map[string]bool
+}
+
+type sessionSeriesKey struct {
+	status model.SessionStatus
+	region string
+}
+
+type Option func(*Runner)
+
+// WithRelayControlPlaneURL sets the callback URL given to replacement relays
+// in their bootstrap user data.
+func WithRelayControlPlaneURL(u string) Option {
+	return func(r *Runner) {
+		r.controlPlaneURL = u
+	}
+}
+
+// WithSRTPorts sets the SRT ports replacement relays bind: count ports from
+// the range, as for relays started through the API.
+func WithSRTPorts(rng relay.PortRange, count int) Option {
+	return func(r *Runner) {
+		r.srtPortRange = rng
+		r.srtPortCount = count
+	}
+}
+
+// WithUsageAlertThresholds sets the percentages of included seconds at which
+// the usage rollup alerts users.
+func WithUsageAlertThresholds(thresholds []int) Option {
+	return func(r *Runner) {
+		r.usageAlertThresholds = thresholds
+	}
 }
 
-func NewRunner(store Store) *Runner {
-	return &Runner{store: store}
+// WithWebhooks enables webhook delivery; deliveries failing maxAttempts
+// times are dead-lettered.
+func WithWebhooks(sender WebhookSender, maxAttempts int) Option {
+	return func(r *Runner) {
+		r.webhooks = sender
+		r.webhookMaxAttempts = maxAttempts
+	}
+}
+
+// WithBillingExport enables reporting each ended cycle's overage; exports
+// failing maxAttempts times are parked as failed.
+func WithBillingExport(reporter UsageReporter, maxAttempts int) Option {
+	return func(r *Runner) {
+		r.billing = reporter
+		r.billingExportMaxAttempts = maxAttempts
+	}
+}
+
+// WithTerminator runs the jobs' relay terminations through t, which the
+// caller starts. Without it they run inline in the job asking for them.
+func WithTerminator(t *relay.Terminator) Option {
+	return func(r *Runner) {
+		r.terminator = t
+	}
+}
+
+// WithHealthEventRetention purges relay health events older than d; zero
+// keeps them forever.
+func WithHealthEventRetention(d time.Duration) Option {
+	return func(r *Runner) {
+		r.healthEventRetention = d
+	}
+}
+
+// WithSessionChangeRetention purges changefeed rows every consumer has
+// acknowledged, and those older than d; zero keeps them forever.
+func WithSessionChangeRetention(d time.Duration) Option {
+	return func(r *Runner) {
+		r.sessionChangeRetention = d
+	}
+}
+
+// WithAlerts alerts operators through h when a relay termination has failed
+// terminationAttempts times or a relay is still running an hour after its
+// termination was requested.
+func WithAlerts(h *alert.Hook, terminationAttempts int) Option {
+	return func(r *Runner) {
+		r.alerts = h
+		r.alertTerminationAttempts = terminationAttempts
+	}
+}
+
+func NewRunner(store Store, provisioner relay.Provisioner, provider string, opts ...Option) *Runner {
+	r := &Runner{store: store, provisioner: provisioner, provider: provider}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.terminator == nil {
+		r.terminator = relay.NewTerminator(provisioner, relay.WithInlineTermination())
+	}
+	return r
+}
+
+// Names lists every job the worker knows, enabled or not; operators may
+// request a run of any of them.
+var Names = []string{
+	"idempotency_ttl_cleanup",
+	"session_usage_rollup",
+	"outage_reconciliation",
+	"relay_termination_drain",
+	"relay_replacement",
+	"relay_orphan_reaper",
+	"active_session_sampler",
+	"relay_quality_rollup",
+	"relay_warm_pool",
+	"webhook_delivery",
+	"billing_export",
+	"health_event_retention",
+	"session_change_retention",
+	"canary",
+}
+
+type job struct {
+	name     string
+	interval time.Duration
+	fn       func(context.Context) error
+}
+
+// jobs returns the jobs enabled by the runner's provisioner and options.
+func (r *Runner) jobs() []job {
+	out := []job{
+		{"idempotency_ttl_cleanup", 5 * time.Minute, r.store.CleanupExpiredIdempotencyRecords},
+		{"session_usage_rollup", 1 * time.Minute, r.rollupUsage},
+		{"outage_reconciliation", 2 * time.Minute, r.reconcileOutages},
+		{"relay_termination_drain", 15 * time.Second, r.drainRelayTerminations},
+		{"relay_replacement", 30 * time.Second, r.replaceDeadRelays},
+		{"relay_orphan_reaper", 1 * time.Minute, r.reapUnconfirmedTerminations},
+		{"active_session_sampler", 30 * time.Second, r.sampleSessionGauges},
+		{"relay_quality_rollup", time.Hour, r.rollupRelayQuality},
+	}
+	if _, ok := r.provisioner.(relay.WarmPoolProvider); ok {
+		out = append(out, job{"relay_warm_pool", 30 * time.Second, r.maintainWarmPool})
+	}
+	if r.webhooks != nil {
+		out = append(out, job{"webhook_delivery", 10 * time.Second, r.deliverWebhooks})
+	}
+	if r.billing != nil {
+		out = append(out, job{"billing_export", 5 * time.Minute, r.exportBilling})
+	}
+	if r.healthEventRetention > 0 {
+		out = append(out, job{"health_event_retention", time.Hour, r.purgeHealthEvents})
+	}
+	if r.sessionChangeRetention > 0 {
+		out = append(out, job{"session_change_retention", time.Hour, r.purgeSessionChanges})
+	}
+	if r.canarySessions != nil && r.canary.Interval > 0 && len(r.canary.Regions) > 0 {
+		out = append(out, job{"canary", r.canary.Interval, r.runCanaries})
+	}
+	return out
 }
 
 func (r *Runner) Start(ctx context.Context) {
-	go r.runEvery(ctx, "idempotency_ttl_cleanup", 5*time.Minute, r.store.CleanupExpiredIdempotencyRecords)
-	go r.runEvery(ctx, "session_usage_rollup", 1*time.Minute, func(c context.Context) error {
-		if err := r.store.RollupLiveSessionDurations(c); err != nil {
+	enabled := r.jobs()
+	for _, j := range enabled {
+		go r.runEvery(ctx, j.name, j.interval, j.fn)
+	}
+	go r.pollJobRuns(ctx, enabled)
+}
+
+// pollJobRuns runs the jobs operators request through the admin API, on
+// top of their schedule.
+func (r *Runner) pollJobRuns(ctx context.Context, enabled []job) {
+	ticker := time.NewTicker(jobRunPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.runRequestedJobs(ctx, enabled); err != nil {
+				log.Printf("job_run_requests poll_failed err=%v", err)
+			}
+		}
+	}
+}
+
+func (r *Runner) runRequestedJobs(ctx context.Context, enabled []job) error {
+	runs, err := r.store.ClaimJobRuns(ctx, jobRunBatchSize)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, run := range runs {
+		runErr := "job not enabled on this worker"
+		for _, j := range enabled {
+			if j.name == run.Job {
+				runErr = ""
+				if err := r.runOnce(ctx, j.name, j.fn); err != nil {
+					runErr = err.Error()
+				}
+				break
+			}
+		}
+		log.Printf("job_run_request finished id=%d job=%s requested_by=%s err=%q", run.ID, run.Job, run.RequestedBy, runErr)
+		if err := r.store.FinishJobRun(ctx, run.ID, runErr); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deliverWebhooks works the webhook_deliveries outbox. Failed deliveries are
+// retried with exponential backoff and dead-lettered after
+// webhookMaxAttempts; only store errors fail the job run.
+func (r *Runner) deliverWebhooks(ctx context.Context) error {
+	due, err := r.store.ClaimWebhookDeliveries(ctx, webhookBatchSize, webhookLease)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, d := range due {
+		start := time.Now()
+		code, sendErr := r.webhooks.Send(ctx, d)
+		attempt := d.Attempts + 1
+		status := "ok"
+		switch {
+		case sendErr == nil:
+			err = r.store.CompleteWebhookDelivery(ctx, d.ID, code)
+		case attempt >= r.webhookMaxAttempts:
+			status = "dead"
+			log.Printf("webhook_delivery dead_letter delivery_id=%d webhook_id=%s event=%s attempt=%d status_code=%d err=%v", d.ID, d.WebhookID, d.EventType, attempt, code, sendErr)
+			err = r.store.DeadLetterWebhookDelivery(ctx, d.ID, code, sendErr.Error())
+		default:
+			status = "retry"
+			next := time.Now().Add(webhookBackoff(attempt))
+			log.Printf("webhook_delivery retry delivery_id=%d webhook_id=%s event=%s attempt=%d status_code=%d next_attempt_at=%s err=%v", d.ID, d.WebhookID, d.EventType, attempt, code, next.UTC().Format(time.RFC3339), sendErr)
+			err = r.store.RetryWebhookDelivery(ctx, d.ID, code, sendErr.Error(), next)
+		}
+		metrics.Default().IncCounter("aegis_webhook_deliveries_total", map[string]string{"event": d.EventType, "status": status})
+		metrics.Default().ObserveHistogram("aegis_webhook_delivery_latency_ms", float64(time.Since(start).Milliseconds()), map[string]string{"event": d.EventType, "status": status})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func webhookBackoff(attempt int) time.Duration {
+	d := webhookBaseDelay
+	for i := 1; i < attempt && d < webhookMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, webhookMaxBackoff)
+}
+
+// exportBilling queues the cycles that ended since the last run and reports
+// the due exports. Failed reports are retried with backoff and parked after
+// billingExportMaxAttempts; only store errors fail the job run.
+func (r *Runner) exportBilling(ctx context.Context) error {
+	if _, err := r.store.EnqueueBillingExports(ctx, billingExportSettle, billingExportLookback); err != nil {
+		return err
+	}
+	due, err := r.store.ClaimBillingExports(ctx, billingExportBatchSize, billingExportLease)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, e := range due {
+		recordID, reportErr := r.billing.ReportUsage(ctx, e)
+		attempt := e.Attempts + 1
+		status := "ok"
+		cycleStart := e.CycleStart.UTC().Format(time.RFC3339)
+		switch {
+		case reportErr == nil:
+			log.Printf("billing_export reported user_id=%s cycle_start=%s overage_seconds=%d usage_record_id=%s", e.UserID, cycleStart, e.OverageSeconds, recordID)
+			err = r.store.CompleteBillingExport(ctx, e.UserID, e.CycleStart, recordID)
+		case attempt >= r.billingExportMaxAttempts:
+			status = "failed"
+			log.Printf("billing_export failed user_id=%s cycle_start=%s attempt=%d err=%v", e.UserID, cycleStart, attempt, reportErr)
+			err = r.store.FailBillingExport(ctx, e.UserID, e.CycleStart, reportErr.Error())
+		default:
+			status = "retry"
+			next := time.Now().Add(billingExportBackoff(attempt))
+			log.Printf("billing_export retry user_id=%s cycle_start=%s attempt=%d next_attempt_at=%s err=%v", e.UserID, cycleStart, attempt, next.UTC().Format(time.RFC3339), reportErr)
+			err = r.store.RetryBillingExport(ctx, e.UserID, e.CycleStart, reportErr.Error(), next)
+		}
+		metrics.Default().IncCounter("aegis_billing_exports_total", map[string]string{"status": status})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if failed, err := r.store.CountFailedBillingExports(ctx); err != nil {
+		errs = append(errs, err)
+	} else {
+		metrics.Default().SetGauge("aegis_billing_exports_failed", float64(failed), nil)
+	}
+	return errors.Join(errs...)
+}
+
+// purgeHealthEvents deletes relay health events older than the retention
+// window in bounded batches, so a large backlog never holds one long
+// transaction.
+func (r *Runner) purgeHealthEvents(ctx context.Context) error {
+	cutoff := time.Now().Add(-r.healthEventRetention)
+	purged, err := deleteInBatches(ctx, cutoff, r.store.DeleteRelayHealthEventsBefore)
+	metrics.Default().AddCounter("aegis_relay_health_events_purged_total", uint64(purged), nil)
+	log.Printf("health_event_retention purged=%d cutoff=%s", purged, cutoff.UTC().Format(time.RFC3339))
+	return err
+}
+
+// purgeSessionChanges deletes the changefeed rows every consumer has
+// acknowledged and those older than the retention window, in bounded
+// batches like purgeHealthEvents.
+func (r *Runner) purgeSessionChanges(ctx context.Context) error {
+	cutoff := time.Now().Add(-r.sessionChangeRetention)
+	purged, err := deleteInBatches(ctx, cutoff, r.store.DeleteSessionChanges)
+	metrics.Default().AddCounter("aegis_session_changes_purged_total", uint64(purged), nil)
+	log.Printf("session_change_retention purged=%d cutoff=%s", purged, cutoff.UTC().Format(time.RFC3339))
+	return err
+}
+
+// deleteInBatches calls del until a batch comes back short, at most
+// healthRetentionMaxBatches times, and returns how many rows it deleted.
+func deleteInBatches(ctx context.Context, cutoff time.Time, del func(context.Context, time.Time, int) (int, error)) (int, error) {
+	purged := 0
+	for range healthRetentionMaxBatches {
+		n, err := del(ctx, cutoff, healthRetentionBatchSize)
+		if err != nil {
+			return purged, err
+		}
+		purged += n
+		if n < healthRetentionBatchSize {
+			break
+		}
+	}
+	return purged, nil
+}
+
+func billingExportBackoff(attempt int) time.Duration {
+	d := billingExportBaseDelay
+	for i := 1; i < attempt && d < billingExportMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, billingExportMaxBackoff)
+}
+
+// rollupUsage brings live sessions' usage and estimated cost up to date,
+// then alerts users who crossed a usage threshold, so alerts do not depend
+// on the user polling.
+func (r *Runner) rollupUsage(ctx context.Context) error {
+	if err := countRollupRows("live_durations", r.store.RollupLiveSessionDurations)(ctx); err != nil {
+		return err
+	}
+	if err := countRollupRows("live_costs", r.store.RollupLiveSessionCosts)(ctx); err != nil {
+		return err
+	}
+	if err := countRollupRows("usage_rollups", r.store.UpsertUsageRollups)(ctx); err != nil {
+		return err
+	}
+	if len(r.usageAlertThresholds) == 0 {
+		return nil
+	}
+	alerts, err := r.store.RecordUsageAlerts(ctx, r.usageAlertThresholds)
+	if err != nil {
+		return err
+	}
+	for _, a := range alerts {
+		log.Printf("usage_alert user_id=%s session_id=%s threshold_percent=%d consumed_seconds=%d included_seconds=%d", a.UserID, a.SessionID, a.ThresholdPercent, a.ConsumedSeconds, a.IncludedSeconds)
+	}
+	return nil
+}
+
+func (r *Runner) reconcileOutages(ctx context.Context) error {
+	if err := countRollupRows("outage_reconciliation", r.store.ReconcileOutageFromHealth)(ctx); err != nil {
+		return err
+	}
+	return countRollupRows("usage_rollups", r.store.UpsertUsageRollups)(ctx)
+}
+
+// rollupRelayQuality rolls up each UTC day once it has ended. It runs
+// hourly so a worker restart around midnight delays a day by an hour at
+// most.
+func (r *Runner) rollupRelayQuality(ctx context.Context) error {
+	return countRollupRows("relay_quality", func(ctx context.Context) (int, error) {
+		return r.store.RollupRelayQuality(ctx, RelayHeartbeatTimeout, relayQualityLookbackDays)
+	})(ctx)
+}
+
+// countRollupRows adds the rows a rollup step changed to
+// aegis_rollup_rows_touched_total.
+func countRollupRows(step string, fn func(context.Context) (int, error)) func(context.Context) error {
+	return func(ctx context.Context) error {
+		n, err := fn(ctx)
+		if err != nil {
 			return err
 		}
-		return r.store.UpsertUsageRollups(c)
+		metrics.Default().AddCounter("aegis_rollup_rows_touched_total", uint64(n), map[string]string{"step": step})
+		return nil
+	}
+}
+
+// maintainWarmPool moves pool instances from warming (launched, then stopped)
+// to available, recycles instances that are too old or run a superseded
+// image, and launches replacements up to each region's pool size.
+func (r *Runner) maintainWarmPool(ctx context.Context) error {
+	wp, ok := r.provisioner.(relay.WarmPoolProvider)
+	if !ok {
+		return nil
+	}
+	sizes, maxAge := wp.WarmPoolSettings()
+	pooled, err := r.store.ListPooledRelays(ctx)
+	if err != nil {
+		return err
+	}
+	counts := make(map[string]map[string]int)
+	for region := range sizes {
+		counts[region] = map[string]int{model.PoolWarming: 0, model.PoolAvailable: 0}
+	}
+	var errs []error
+	for _, p := range pooled {
+		state, err := r.checkPooledRelay(ctx, wp, p, sizes, maxAge)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if state == "" {
+			continue
+		}
+		if counts[p.Region] == nil {
+			counts[p.Region] = make(map[string]int)
+		}
+		counts[p.Region][state]++
+	}
+	for region, size := range sizes {
+		have := counts[region][model.PoolWarming] + counts[region][model.PoolAvailable]
+		for i := 0; have < size && i < warmPoolLaunchBatch; i++ {
+			p, err := wp.LaunchPooled(ctx, region)
+			if err != nil {
+				log.Printf("relay_warm_pool launch_failed region=%s err=%v", region, err)
+				break
+			}
+			if err := r.store.AddPooledRelay(ctx, p); err != nil {
+				errs = append(errs, err)
+				r.deprovisionPooled(p)
+				break
+			}
+			have++
+			counts[region][model.PoolWarming]++
+		}
+	}
+	for region, states := range counts {
+		for state, n := range states {
+			metrics.Default().SetGauge("aegis_relay_pool_instances", float64(n), map[string]string{"region": region, "state": state})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkPooledRelay advances one pool instance and returns the pool state it
+// is counted in, or "" once it has left the pool.
+func (r *Runner) checkPooledRelay(ctx context.Context, wp relay.WarmPoolProvider, p model.PooledRelay, sizes map[string]int, maxAge time.Duration) (string, error) {
+	status, err := r.provisioner.Status(ctx, relay.StatusRequest{Region: p.Region, AWSInstanceID: p.AWSInstanceID})
+	if err != nil {
+		log.Printf("relay_warm_pool status_failed instance_id=%s err=%v", p.AWSInstanceID, err)
+		return p.State, nil
+	}
+	if status.State == relay.InstanceTerminated || status.State == relay.InstanceShuttingDown {
+		_, err := r.store.RemovePooledRelay(ctx, p.AWSInstanceID)
+		return "", err
+	}
+	reason := ""
+	switch amiID, instanceType, err := wp.PoolImage(ctx, p.Region); {
+	case sizes[p.Region] <= 0:
+		reason = "pool_disabled"
+	case time.Since(p.LaunchedAt) >= maxAge:
+		reason = "max_age"
+	case err == nil && (amiID != p.AMIID || instanceType != p.InstanceType):
+		reason = "image_changed"
+	}
+	if reason != "" {
+		// Removing the row first keeps a concurrent Provision from claiming
+		// the instance while it is terminated.
+		removed, err := r.store.RemovePooledRelay(ctx, p.AWSInstanceID)
+		if err != nil || !removed {
+			return "", err
+		}
+		log.Printf("relay_warm_pool recycle region=%s instance_id=%s reason=%s", p.Region, p.AWSInstanceID, reason)
+		r.deprovisionPooled(p)
+		return "", nil
+	}
+	if p.State != model.PoolWarming {
+		return p.State, nil
+	}
+	switch status.State {
+	case relay.InstanceRunning:
+		if err := wp.StopPooled(ctx, p.Region, p.AWSInstanceID); err != nil {
+			log.Printf("relay_warm_pool stop_failed region=%s instance_id=%s err=%v", p.Region, p.AWSInstanceID, err)
+		}
+	case relay.InstanceStopped:
+		if err := r.store.MarkPooledRelayAvailable(ctx, p.AWSInstanceID); err != nil {
+			return p.State, err
+		}
+		return model.PoolAvailable, nil
+	}
+	return p.State, nil
+}
+
+// deprovisionPooled terminates an instance that is no longer in the pool.
+// A failure leaves it tagged AegisPool=true for manual cleanup.
+func (r *Runner) deprovisionPooled(p model.PooledRelay) {
+	logFailure := func(err error) {
+		if err != nil && !errors.Is(err, relay.ErrTerminationPending) {
+			log.Printf("relay_warm_pool terminate_failed region=%s instance_id=%s err=%v", p.Region, p.AWSInstanceID, err)
+		}
+	}
+	if err := r.terminator.Enqueue(relay.DeprovisionRequest{
+		Region:        p.Region,
+		AWSInstanceID: p.AWSInstanceID,
+		Reason:        model.TerminateReasonPoolRecycle,
+	}, logFailure); err != nil {
+		logFailure(err)
+	}
+}
+
+// replaceDeadRelays launches a new relay for live sessions whose relay
+// instance is no longer running or has stopped sending heartbeats. The
+// session keeps its pair token; clients learn the new endpoint from the
+// relay_replaced session event.
+func (r *Runner) replaceDeadRelays(ctx context.Context) error {
+	candidates, err := r.store.ListRelayReplacementCandidates(ctx, RelayHeartbeatTimeout, replacementLease, replacementBatchSize)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, c := range candidates {
+		if err := r.replaceIfDead(ctx, c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Runner) replaceIfDead(ctx context.Context, c model.RelayCheck) error {
+	status, err := r.provisioner.Status(ctx, relay.StatusRequest{Region: c.RelayRegion, AWSInstanceID: c.AWSInstanceID, Provider: c.Provider})
+	if err != nil {
+		log.Printf("relay_replacement status_failed session_id=%s instance_id=%s err=%v", c.SessionID, c.AWSInstanceID, err)
+		return nil
+	}
+	var reason string
+	switch {
+	case status.State != relay.InstanceRunning && status.State != relay.InstancePending:
+		reason = "instance_" + status.State
+	case c.HeartbeatStale:
+		reason = "heartbeat_timeout"
+	default:
+		return nil
+	}
+
+	claimed, err := r.store.ClaimRelayReplacement(ctx, c.SessionID, c.RelayInstanceID, replacementLease)
+	if err != nil || !claimed {
+		return err
+	}
+
+	start := time.Now()
+	prov, err := r.provisioner.Provision(ctx, relay.ProvisionRequest{
+		SessionID:       c.SessionID,
+		UserID:          c.UserID,
+		Region:          c.Region,
+		ControlPlaneURL: r.controlPlaneURL,
+		RelayAuthToken:  c.RelayWSToken,
+		SRTPortRange:    r.srtPortRange,
+		SRTPortCount:    r.srtPortCount,
+		StaticIP:        c.StaticIP,
+		ClientIP:        c.ClientIP,
+
+		ReplacesInstanceID: c.AWSInstanceID,
 	})
-	go r.runEvery(ctx, "outage_reconciliation", 2*time.Minute, func(c context.Context) error {
-		if err := r.store.ReconcileOutageFromHealth(c); err != nil {
-			return err
+	if err != nil {
+		r.observeReplacement(c, reason, start, "error")
+		log.Printf("relay_replacement provision_failed session_id=%s instance_id=%s reason=%s err=%v", c.SessionID, c.AWSInstanceID, reason, err)
+		return r.store.ReleaseRelayReplacement(ctx, c.SessionID)
+	}
+	region := prov.Region
+	if region == "" {
+		region = c.Region
+	}
+	in := store.ReplaceSessionRelayInput{
+		SessionID:          c.SessionID,
+		OldRelayInstanceID: c.RelayInstanceID,
+		OldRegion:          c.RelayRegion,
+		OldAWSInstanceID:   c.AWSInstanceID,
+		Reason:             reason,
+		Region:             region,
+		AWSInstanceID:      prov.AWSInstanceID,
+		AMIID:              prov.AMIID,
+		InstanceType:       prov.InstanceType,
+		Lifecycle:          prov.Lifecycle,
+		PublicIP:           prov.PublicIP,
+		SRTPorts:           prov.SRTPorts,
+		WSURL:              prov.WSURL,
+		EIPAllocationID:    prov.EIPAllocationID,
+		PublicIPv6:         prov.PublicIPv6,
+		SubnetID:           prov.SubnetID,
+		AvailabilityZone:   prov.AvailabilityZone,
+		SecurityGroupID:    prov.SecurityGroupID,
+		AllowedClientIP:    relay.AllowedClientIP(prov, c.ClientIP),
+		Provider:           prov.Provider,
+	}
+	// A result the relay's columns cannot hold is a provider bug; it is
+	// cleaned up like a replacement that failed to bind.
+	if err = model.ValidateActivation(in.Relay()); err != nil {
+		log.Printf("relay_replacement provision_invalid session_id=%s instance_id=%s provider=%s err=%v", c.SessionID, prov.AWSInstanceID, prov.Provider, err)
+	} else {
+		_, err = r.store.ReplaceSessionRelay(ctx, in)
+	}
+	if err != nil {
+		r.observeReplacement(c, reason, start, "error")
+		// The replacement was never bound; terminate it so it does not leak.
+		logFailure := func(deprovErr error) {
+			if deprovErr != nil && !errors.Is(deprovErr, relay.ErrTerminationPending) {
+				log.Printf("relay_replacement cleanup_failed session_id=%s instance_id=%s err=%v", c.SessionID, prov.AWSInstanceID, deprovErr)
+			}
+		}
+		if deprovErr := r.terminator.Enqueue(relay.DeprovisionRequest{
+			SessionID:       c.SessionID,
+			UserID:          c.UserID,
+			Region:          region,
+			AWSInstanceID:   prov.AWSInstanceID,
+			EIPAllocationID: prov.EIPAllocationID,
+			SecurityGroupID: prov.SecurityGroupID,
+			Provider:        prov.Provider,
+			Reason:          model.TerminateReasonCompensation,
+		}, logFailure); deprovErr != nil {
+			logFailure(deprovErr)
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			log.Printf("relay_replacement abandoned session_id=%s reason=session_not_live", c.SessionID)
+			return nil
 		}
-		return r.store.UpsertUsageRollups(c)
+		if relErr := r.store.ReleaseRelayReplacement(ctx, c.SessionID); relErr != nil {
+			err = errors.Join(err, relErr)
+		}
+		return err
+	}
+	r.observeReplacement(c, reason, start, "ok")
+	log.Printf("relay_replacement done session_id=%s old_instance_id=%s instance_id=%s region=%s reason=%s", c.SessionID, c.AWSInstanceID, prov.AWSInstanceID, region, reason)
+	return nil
+}
+
+func (r *Runner) observeReplacement(c model.RelayCheck, reason string, start time.Time, status string) {
+	log.Printf("metric=relay_replacement_latency_ms session_id=%s region=%s value=%d status=%s", c.SessionID, c.Region, time.Since(start).Milliseconds(), status)
+	metrics.Default().IncCounter("aegis_relay_replacements_total", map[string]string{
+		"provider": r.providerLabel("", c.Region),
+		"region":   c.Region,
+		"reason":   reason,
+		"status":   status,
 	})
 }
 
+// drainRelayTerminations works the relay_terminations outbox written by
+// StopSession, through the terminator. Failed terminations are rescheduled
+// with exponential backoff; only store errors fail the job run.
+func (r *Runner) drainRelayTerminations(ctx context.Context) error {
+	pending, err := r.store.ClaimRelayTerminations(ctx, terminationBatchSize, terminationLease)
+	if err != nil {
+		return err
+	}
+	var batch terminationBatch
+	for _, t := range pending {
+		start := time.Now()
+		err := batch.enqueue(r.terminator, relay.DeprovisionRequest{
+			SessionID:       t.SessionID,
+			UserID:          t.UserID,
+			Region:          t.Region,
+			AWSInstanceID:   t.AWSInstanceID,
+			EIPAllocationID: t.EIPAllocationID,
+			SecurityGroupID: t.SecurityGroupID,
+			Provider:        t.Provider,
+			Reason:          t.Reason,
+		}, func(err error) error {
+			return r.finishRelayTermination(ctx, t, start, err)
+		})
+		if err != nil {
+			// The entry stays leased and is claimed again once the lease lapses.
+			log.Printf("relay_termination deferred session_id=%s instance_id=%s err=%v", t.SessionID, t.AWSInstanceID, err)
+		}
+	}
+	return batch.wait(ctx)
+}
+
+func (r *Runner) finishRelayTermination(ctx context.Context, t model.RelayTermination, start time.Time, err error) error {
+	confirmed := err == nil
+	if errors.Is(err, relay.ErrTerminationPending) {
+		err = nil
+	}
+	took := time.Since(start)
+	r.observeDeprovision(t, took, err)
+	if err != nil {
+		next := time.Now().Add(terminationBackoff(t.Attempts + 1))
+		log.Printf("relay_termination retry session_id=%s instance_id=%s attempt=%d next_attempt_at=%s err=%v", t.SessionID, t.AWSInstanceID, t.Attempts+1, next.UTC().Format(time.RFC3339), err)
+		if t.Attempts+1 >= r.alertTerminationAttempts {
+			// Retries go on; the alert only says they are not working.
+			r.alerts.Fire(alert.Alert{
+				Kind:    alert.KindTerminationRetries,
+				Key:     alert.KindTerminationRetries + "/" + t.Region,
+				Summary: fmt.Sprintf("relay termination failed %d times", t.Attempts+1),
+				Fields: map[string]string{
+					"region":      t.Region,
+					"instance_id": t.AWSInstanceID,
+					"session_id":  t.SessionID,
+					"provider":    r.providerLabel(t.Provider, t.Region),
+					"last_error":  err.Error(),
+				},
+			})
+		}
+		return r.store.RetryRelayTermination(ctx, t.ID, err.Error(), next)
+	}
+	if err := r.store.CompleteRelayTermination(ctx, t, confirmed, took); err != nil {
+		return err
+	}
+	log.Printf("relay_termination done session_id=%s instance_id=%s confirmed=%t reason=%s", t.SessionID, t.AWSInstanceID, confirmed, t.Reason)
+	return nil
+}
+
+// terminationBatch collects the outcome of the terminations one job run
+// enqueued.
+type terminationBatch struct {
+	wg   sync.WaitGroup
+	mu   sync.Mutex
+	errs []error
+}
+
+// enqueue submits req and runs finish with its result; an error from finish
+// fails the batch.
+func (b *terminationBatch) enqueue(t *relay.Terminator, req relay.DeprovisionRequest, finish func(error) error) error {
+	b.wg.Add(1)
+	err := t.Enqueue(req, func(err error) {
+		defer b.wg.Done()
+		if err := finish(err); err != nil {
+			b.mu.Lock()
+			b.errs = append(b.errs, err)
+			b.mu.Unlock()
+		}
+	})
+	if err != nil {
+		b.wg.Done()
+	}
+	return err
+}
+
+// wait returns once every enqueued termination has finished, or ctx is done.
+func (b *terminationBatch) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return errors.Join(b.errs...)
+}
+
+// sampleSessionGauges sets the gauges sampled by active_session_sampler.
+func (r *Runner) sampleSessionGauges(ctx context.Context) error {
+	return errors.Join(r.sampleActiveSessions(ctx), r.sampleRelayAgentVersions(ctx))
+}
+
+// sampleActiveSessions sets aegis_active_sessions from the sessions table.
+// Series from the previous sample whose status and region now have no
+// sessions are deleted rather than left at their last value.
+func (r *Runner) sampleActiveSessions(ctx context.Context) error {
+	counts, err := r.store.CountSessionsByStatusRegion(ctx)
+	if err != nil {
+		return err
+	}
+	r.sessionSeriesMu.Lock()
+	defer r.sessionSeriesMu.Unlock()
+	current := make(map[sessionSeriesKey]bool)
+	for status, regions := range counts {
+		for region, n := range regions {
+			metrics.Default().SetGauge("aegis_active_sessions", float64(n), map[string]string{"status": string(status), "region": region})
+			current[sessionSeriesKey{status, region}] = true
+		}
+	}
+	for key := range r.sessionSeries {
+		if !current[key] {
+			metrics.Default().DeleteGauge("aegis_active_sessions", map[string]string{"status": string(key.status), "region": key.region})
+		}
+	}
+	r.sessionSeries = current
+	return nil
+}
+
+// sampleRelayAgentVersions sets aegis_relays_by_agent_version, deleting the
+// series of versions no running relay reports any more.
+func (r *Runner) sampleRelayAgentVersions(ctx context.Context) error {
+	counts, err := r.store.CountRelaysByAgentVersion(ctx)
+	if err != nil {
+		return err
+	}
+	r.sessionSeriesMu.Lock()
+	defer r.sessionSeriesMu.Unlock()
+	current := make(map[string]bool)
+	for v, n := range counts {
+		metrics.Default().SetGauge("aegis_relays_by_agent_version", float64(n), map[string]string{"agent_version": v})
+		current[v] = true
+	}
+	for v := range r.agentSeries {
+		if !current[v] {
+			metrics.Default().DeleteGauge("aegis_relays_by_agent_version", map[string]string{"agent_version": v})
+		}
+	}
+	r.agentSeries = current
+	return nil
+}
+
+// reapUnconfirmedTerminations confirms relays left 'terminating' by the
+// drain once the provider reports them gone, and re-issues the termination
+// for any that linger past terminationStuckAfter.
+func (r *Runner) reapUnconfirmedTerminations(ctx context.Context) error {
+	n, err := r.store.CountPendingTerminations(ctx)
+	if err != nil {
+		return err
+	}
+	metrics.Default().SetGauge("aegis_relay_terminations_pending", float64(n), nil)
+	if n == 0 {
+		return nil
+	}
+	pending, err := r.store.ListUnconfirmedTerminations(ctx, orphanReaperBatchSize)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	var batch terminationBatch
+	for _, t := range pending {
+		status, err := r.provisioner.Status(ctx, relay.StatusRequest{Region: t.Region, AWSInstanceID: t.AWSInstanceID, Provider: t.Provider})
+		if err != nil {
+			log.Printf("relay_orphan_reaper status_failed instance_id=%s err=%v", t.AWSInstanceID, err)
+			continue
+		}
+		if status.State == relay.InstanceTerminated {
+			if err := r.store.MarkRelayTerminated(ctx, t.RelayInstanceID); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if time.Since(t.TerminateRequestedAt) < terminationStuckAfter {
+			continue
+		}
+		if time.Since(t.TerminatingSince) >= orphanAlertAfter {
+			r.alerts.Fire(alert.Alert{
+				Kind:    alert.KindOrphanedRelay,
+				Key:     alert.KindOrphanedRelay + "/" + t.Region,
+				Summary: "relay still running over an hour after it started terminating",
+				Fields: map[string]string{
+					"region":        t.Region,
+					"instance_id":   t.AWSInstanceID,
+					"session_id":    t.SessionID,
+					"state":         string(status.State),
+					"pending_since": t.TerminateRequestedAt.UTC().Format(time.RFC3339),
+				},
+			})
+		}
+		log.Printf("relay_orphan_reaper reterminate session_id=%s instance_id=%s state=%s pending_since=%s", t.SessionID, t.AWSInstanceID, status.State, t.TerminateRequestedAt.UTC().Format(time.RFC3339))
+		metrics.Default().IncCounter("aegis_relay_terminations_reissued_total", map[string]string{"region": t.Region})
+		reason := t.Reason
+		if reason == "" {
+			reason = model.TerminateReasonOrphanReaper
+		}
+		err = batch.enqueue(r.terminator, relay.DeprovisionRequest{
+			SessionID:     t.SessionID,
+			Region:        t.Region,
+			AWSInstanceID: t.AWSInstanceID,
+			Provider:      t.Provider,
+			Reason:        reason,
+		}, func(err error) error {
+			switch {
+			case err == nil:
+				return r.store.MarkRelayTerminated(ctx, t.RelayInstanceID)
+			case errors.Is(err, relay.ErrTerminationPending):
+				return r.store.RecordRelayTerminateRetry(ctx, t.RelayInstanceID)
+			default:
+				log.Printf("relay_orphan_reaper reterminate_failed instance_id=%s err=%v", t.AWSInstanceID, err)
+				return r.store.RecordRelayTerminateRetry(ctx, t.RelayInstanceID)
+			}
+		})
+		if err != nil {
+			// Left terminating; the next run tries again.
+			log.Printf("relay_orphan_reaper reterminate_deferred instance_id=%s err=%v", t.AWSInstanceID, err)
+		}
+	}
+	return errors.Join(append(errs, batch.wait(ctx))...)
+}
+
+func (r *Runner) observeDeprovision(t model.RelayTermination, took time.Duration, err error) {
+	durMS := took.Milliseconds()
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	log.Printf("metric=relay_deprovision_latency_ms session_id=%s user_id=%s region=%s value=%d status=%s", t.SessionID, t.UserID, t.Region, durMS, status)
+	labels := map[string]string{
+		"provider": r.providerLabel(t.Provider, t.Region),
+		"region":   t.Region,
+		"status":   status,
+	}
+	metrics.Default().IncCounter("aegis_relay_deprovision_total", labels)
+	metrics.Default().ObserveHistogram("aegis_relay_deprovision_latency_ms", float64(durMS), labels)
+}
+
+// providerLabel names the provider a relay runs on: the one recorded with
+// it, else the one its region routes to, else the configured default.
+func (r *Runner) providerLabel(recorded, region string) string {
+	if recorded != "" {
+		return recorded
+	}
+	if router, ok := r.provisioner.(interface {
+		ProviderFor(region string) (string, error)
+	}); ok {
+		if name, err := router.ProviderFor(region); err == nil {
+			return name
+		}
+	}
+	return r.provider
+}
+
+func terminationBackoff(attempt int) time.Duration {
+	d := terminationBaseDelay
+	for i := 1; i < attempt && d < terminationMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, terminationMaxBackoff)
+}
+
 func (r *Runner) runEvery(ctx context.Context, name string, interval time.Duration, fn func(context.Context) error) {
 	r.runOnce(ctx, name, fn)
 	ticker := time.NewTicker(interval)
@@ -53,7 +1100,7 @@ func (r *Runner) runEvery(ctx context.Context, name string, interval time.Durati
 	}
 }
 
-func (r *Runner) runOnce(ctx context.Context, name string, fn func(context.Context) error) {
+func (r *Runner) runOnce(ctx context.Context, name string, fn func(context.Context) error) error {
 	start := time.Now()
 	err := fn(ctx)
 	durMs := float64(time.Since(start).Milliseconds())
@@ -65,10 +1112,11 @@ func (r *Runner) runOnce(ctx context.Context, name string, fn func(context.Conte
 		labels["status"] = "error"
 		metrics.Default().IncCounter("aegis_job_runs_total", labels)
 		metrics.Default().ObserveHistogram("aegis_job_duration_ms", durMs, map[string]string{"job": name})
-		return
+		return err
 	}
 	log.Printf("metric=job_run name=%s status=ok duration_ms=%d", name, int64(durMs))
 	labels["status"] = "ok"
 	metrics.Default().IncCounter("aegis_job_runs_total", labels)
 	metrics.Default().ObserveHistogram("aegis_job_duration_ms", durMs, map[string]string{"job": name})
+	return nil
 }
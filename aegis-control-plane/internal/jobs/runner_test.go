@@ -0,0 +1,1049 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/alert"
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+	"github.com/telemyapp/aegis-control-plane/internal/stripe"
+)
+
+type fakeStore struct {
+	pending   []model.RelayTermination
+	completed []int64
+	retried   map[int64]time.Time
+
+	candidates []model.RelayCheck
+	claimLost  bool
+	released   []string
+	replaceErr error
+	replaced   []store.ReplaceSessionRelayInput
+
+	pool []model.PooledRelay
+
+	unconfirmed  []int64
+	terminating  []model.TerminatingRelay
+	terminated   []string
+	reterminated []string
+
+	alertThresholds [][]int
+
+	deliveries     []model.WebhookDelivery
+	delivered      []int64
+	webhookRetries map[int64]time.Time
+	deadLettered   []int64
+
+	billingExports  []model.BillingExport
+	billingReported map[string]string
+	billingRetries  map[string]time.Time
+	billingFailed   []string
+
+	jobRuns     []model.JobRun
+	jobRunsDone map[int64]string
+
+	sessionCounts map[model.SessionStatus]map[string]int
+	agentCounts   map[string]int
+
+	// healthEvents is how many expired health events remain to purge.
+	healthEvents  int
+	healthCutoffs []time.Time
+
+	qualityRollups []string
+
+	completedTook []time.Duration
+
+	canaryFailures []model.CanaryFailure
+
+	changes       int
+	changeCutoffs []time.Time
+}
+
+func (f *fakeStore) CleanupExpiredIdempotencyRecords(context.Context) error  { return nil }
+func (f *fakeStore) RollupLiveSessionDurations(context.Context) (int, error) { return 2, nil }
+func (f *fakeStore) RollupLiveSessionCosts(context.Context) (int, error)     { return 3, nil }
+func (f *fakeStore) ReconcileOutageFromHealth(context.Context) (int, error)  { return 0, nil }
+func (f *fakeStore) UpsertUsageRollups(context.Context) (int, error)         { return 3, nil }
+
+func (f *fakeStore) DeleteRelayHealthEventsBefore(_ context.Context, cutoff time.Time, limit int) (int, error) {
+	f.healthCutoffs = append(f.healthCutoffs, cutoff)
+	n := min(limit, f.healthEvents)
+	f.healthEvents -= n
+	return n, nil
+}
+
+func (f *fakeStore) DeleteSessionChanges(_ context.Context, cutoff time.Time, limit int) (int, error) {
+	f.changeCutoffs = append(f.changeCutoffs, cutoff)
+	n := min(limit, f.changes)
+	f.changes -= n
+	return n, nil
+}
+
+func (f *fakeStore) RollupRelayQuality(_ context.Context, gapThreshold time.Duration, lookbackDays int) (int, error) {
+	f.qualityRollups = append(f.qualityRollups, fmt.Sprintf("%s/%d", gapThreshold, lookbackDays))
+	return 4, nil
+}
+
+func (f *fakeStore) RecordUsageAlerts(_ context.Context, thresholds []int) ([]model.UsageAlert, error) {
+	f.alertThresholds = append(f.alertThresholds, thresholds)
+	return []model.UsageAlert{{UserID: "usr_1", SessionID: "ses_1", ThresholdPercent: 80}}, nil
+}
+
+func (f *fakeStore) ClaimRelayTerminations(_ context.Context, limit int, _ time.Duration) ([]model.RelayTermination, error) {
+	return f.pending[:min(limit, len(f.pending))], nil
+}
+
+func (f *fakeStore) CompleteRelayTermination(_ context.Context, t model.RelayTermination, confirmed bool, took time.Duration) error {
+	f.completed = append(f.completed, t.ID)
+	f.completedTook = append(f.completedTook, took)
+	if !confirmed {
+		f.unconfirmed = append(f.unconfirmed, t.ID)
+	}
+	return nil
+}
+
+func (f *fakeStore) RetryRelayTermination(_ context.Context, id int64, _ string, next time.Time) error {
+	if f.retried == nil {
+		f.retried = make(map[int64]time.Time)
+	}
+	f.retried[id] = next
+	return nil
+}
+
+func (f *fakeStore) ListUnconfirmedTerminations(_ context.Context, limit int) ([]model.TerminatingRelay, error) {
+	return f.terminating[:min(limit, len(f.terminating))], nil
+}
+
+func (f *fakeStore) CountPendingTerminations(context.Context) (int, error) {
+	return len(f.terminating), nil
+}
+
+func (f *fakeStore) CountSessionsByStatusRegion(context.Context) (map[model.SessionStatus]map[string]int, error) {
+	return f.sessionCounts, nil
+}
+
+func (f *fakeStore) CountRelaysByAgentVersion(context.Context) (map[string]int, error) {
+	return f.agentCounts, nil
+}
+
+func (f *fakeStore) MarkRelayTerminated(_ context.Context, id string) error {
+	f.terminated = append(f.terminated, id)
+	return nil
+}
+
+func (f *fakeStore) RecordRelayTerminateRetry(_ context.Context, id string) error {
+	f.reterminated = append(f.reterminated, id)
+	return nil
+}
+
+func (f *fakeStore) ListRelayReplacementCandidates(context.Context, time.Duration, time.Duration, int) ([]model.RelayCheck, error) {
+	return f.candidates, nil
+}
+
+func (f *fakeStore) ClaimRelayReplacement(context.Context, string, string, time.Duration) (bool, error) {
+	return !f.claimLost, nil
+}
+
+func (f *fakeStore) ReleaseRelayReplacement(_ context.Context, sessionID string) error {
+	f.released = append(f.released, sessionID)
+	return nil
+}
+
+func (f *fakeStore) ReplaceSessionRelay(_ context.Context, in store.ReplaceSessionRelayInput) (*model.Session, error) {
+	if f.replaceErr != nil {
+		return nil, f.replaceErr
+	}
+	f.replaced = append(f.replaced, in)
+	return &model.Session{ID: in.SessionID, Status: model.SessionActive}, nil
+}
+
+func (f *fakeStore) ListPooledRelays(context.Context) ([]model.PooledRelay, error) {
+	return append([]model.PooledRelay(nil), f.pool...), nil
+}
+
+func (f *fakeStore) AddPooledRelay(_ context.Context, in model.PooledRelay) error {
+	f.pool = append(f.pool, in)
+	return nil
+}
+
+func (f *fakeStore) MarkPooledRelayAvailable(_ context.Context, id string) error {
+	for i := range f.pool {
+		if f.pool[i].AWSInstanceID == id {
+			f.pool[i].State = model.PoolAvailable
+		}
+	}
+	return nil
+}
+
+func (f *fakeStore) RemovePooledRelay(_ context.Context, id string) (bool, error) {
+	for i := range f.pool {
+		if f.pool[i].AWSInstanceID == id {
+			f.pool = append(f.pool[:i], f.pool[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeStore) ClaimWebhookDeliveries(_ context.Context, limit int, _ time.Duration) ([]model.WebhookDelivery, error) {
+	return f.deliveries[:min(limit, len(f.deliveries))], nil
+}
+
+func (f *fakeStore) CompleteWebhookDelivery(_ context.Context, id int64, _ int) error {
+	f.delivered = append(f.delivered, id)
+	return nil
+}
+
+func (f *fakeStore) RetryWebhookDelivery(_ context.Context, id int64, _ int, _ string, next time.Time) error {
+	if f.webhookRetries == nil {
+		f.webhookRetries = make(map[int64]time.Time)
+	}
+	f.webhookRetries[id] = next
+	return nil
+}
+
+func (f *fakeStore) DeadLetterWebhookDelivery(_ context.Context, id int64, _ int, _ string) error {
+	f.deadLettered = append(f.deadLettered, id)
+	return nil
+}
+
+func (f *fakeStore) EnqueueBillingExports(context.Context, time.Duration, time.Duration) (int, error) {
+	return 0, nil
+}
+
+func (f *fakeStore) ClaimBillingExports(_ context.Context, limit int, _ time.Duration) ([]model.BillingExport, error) {
+	return f.billingExports[:min(limit, len(f.billingExports))], nil
+}
+
+func (f *fakeStore) CompleteBillingExport(_ context.Context, userID string, _ time.Time, usageRecordID string) error {
+	if f.billingReported == nil {
+		f.billingReported = make(map[string]string)
+	}
+	f.billingReported[userID] = usageRecordID
+	return nil
+}
+
+func (f *fakeStore) RetryBillingExport(_ context.Context, userID string, _ time.Time, _ string, next time.Time) error {
+	if f.billingRetries == nil {
+		f.billingRetries = make(map[string]time.Time)
+	}
+	f.billingRetries[userID] = next
+	return nil
+}
+
+func (f *fakeStore) FailBillingExport(_ context.Context, userID string, _ time.Time, _ string) error {
+	f.billingFailed = append(f.billingFailed, userID)
+	return nil
+}
+
+func (f *fakeStore) CountFailedBillingExports(context.Context) (int, error) {
+	return len(f.billingFailed), nil
+}
+
+func (f *fakeStore) ClaimJobRuns(_ context.Context, limit int) ([]model.JobRun, error) {
+	n := min(limit, len(f.jobRuns))
+	claimed := f.jobRuns[:n]
+	f.jobRuns = f.jobRuns[n:]
+	return claimed, nil
+}
+
+func (f *fakeStore) FinishJobRun(_ context.Context, id int64, runErr string) error {
+	if f.jobRunsDone == nil {
+		f.jobRunsDone = make(map[int64]string)
+	}
+	f.jobRunsDone[id] = runErr
+	return nil
+}
+
+func (f *fakeStore) RecordCanaryFailure(_ context.Context, cf model.CanaryFailure) error {
+	f.canaryFailures = append(f.canaryFailures, cf)
+	return nil
+}
+
+// fakeStripe records usage like Stripe's action=set reports: one quantity
+// per idempotency key, however often it is reported.
+type fakeStripe struct {
+	failUsers map[string]bool
+	usage     map[string]int
+	calls     int
+}
+
+func (f *fakeStripe) ReportUsage(_ context.Context, e model.BillingExport) (string, error) {
+	f.calls++
+	if f.failUsers[e.UserID] {
+		return "", errors.New("stripe: status 500")
+	}
+	if f.usage == nil {
+		f.usage = make(map[string]int)
+	}
+	key := stripe.IdempotencyKey(e)
+	f.usage[key] = e.OverageSeconds
+	return "mbur_" + key, nil
+}
+
+type fakeWebhookSender map[string]int
+
+func (f fakeWebhookSender) Send(_ context.Context, d model.WebhookDelivery) (int, error) {
+	code := f[d.URL]
+	if code < 200 || code > 299 {
+		return code, fmt.Errorf("status %d", code)
+	}
+	return code, nil
+}
+
+type fakeReplacer struct {
+	relay.Provisioner
+	states       map[string]string
+	provisioned  []string
+	requests     []relay.ProvisionRequest
+	deprovisions []string
+	reasons      []string
+	// publicIP, when set, replaces the launched relay's address.
+	publicIP string
+}
+
+func (f *fakeReplacer) Status(_ context.Context, req relay.StatusRequest) (relay.StatusResult, error) {
+	return relay.StatusResult{State: f.states[req.AWSInstanceID]}, nil
+}
+
+func (f *fakeReplacer) Provision(_ context.Context, req relay.ProvisionRequest) (relay.ProvisionResult, error) {
+	f.provisioned = append(f.provisioned, req.SessionID)
+	f.requests = append(f.requests, req)
+	res := relay.ProvisionResult{Region: req.Region, AWSInstanceID: "i-new-" + req.SessionID, PublicIP: "198.51.100.9", SRTPort: 9000}
+	if f.publicIP != "" {
+		res.PublicIP = f.publicIP
+	}
+	return res, nil
+}
+
+func (f *fakeReplacer) Deprovision(_ context.Context, req relay.DeprovisionRequest) error {
+	f.deprovisions = append(f.deprovisions, req.AWSInstanceID)
+	f.reasons = append(f.reasons, req.Reason)
+	return nil
+}
+
+type fakeDeprovisioner struct {
+	relay.Provisioner
+	failInstance    string
+	pendingInstance string
+	requests        []relay.DeprovisionRequest
+}
+
+func (f *fakeDeprovisioner) Deprovision(_ context.Context, req relay.DeprovisionRequest) error {
+	f.requests = append(f.requests, req)
+	switch req.AWSInstanceID {
+	case f.failInstance:
+		return errors.New("terminate failed")
+	case f.pendingInstance:
+		return relay.ErrTerminationPending
+	}
+	return nil
+}
+
+func TestDrainRelayTerminations_CompletesAndReschedules(t *testing.T) {
+	st := &fakeStore{pending: []model.RelayTermination{
+		{ID: 1, SessionID: "ses_1", AWSInstanceID: "i-ok", Region: "us-east-1"},
+		{ID: 2, SessionID: "ses_2", AWSInstanceID: "i-bad", Region: "us-east-1", Attempts: 2},
+	}}
+	r := NewRunner(st, &fakeDeprovisioner{failInstance: "i-bad"}, "aws")
+
+	before := time.Now()
+	if err := r.drainRelayTerminations(context.Background()); err != nil {
+		t.Fatalf("drainRelayTerminations: %v", err)
+	}
+	if len(st.completed) != 1 || st.completed[0] != 1 {
+		t.Fatalf("expected only termination 1 to complete, got %v", st.completed)
+	}
+	next, ok := st.retried[2]
+	if !ok {
+		t.Fatal("expected termination 2 to be rescheduled")
+	}
+	if got := next.Sub(before); got < 60*time.Second {
+		t.Fatalf("expected third attempt backoff of at least 60s, got %s", got)
+	}
+}
+
+func TestDrainRelayTerminations_WaitsForTerminatorWorkers(t *testing.T) {
+	st := &fakeStore{pending: []model.RelayTermination{
+		{ID: 1, SessionID: "ses_1", AWSInstanceID: "i-ok", Region: "us-east-1"},
+		{ID: 2, SessionID: "ses_2", AWSInstanceID: "i-bad", Region: "us-east-1"},
+	}}
+	prov := &fakeDeprovisioner{failInstance: "i-bad"}
+	// One worker keeps the fakes single-threaded.
+	term := relay.NewTerminator(prov, relay.WithTerminatorWorkers(1))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	term.Start(ctx)
+	r := NewRunner(st, prov, "aws", WithTerminator(term))
+
+	if err := r.drainRelayTerminations(ctx); err != nil {
+		t.Fatalf("drainRelayTerminations: %v", err)
+	}
+	if len(st.completed) != 1 || st.completed[0] != 1 || len(st.retried) != 1 {
+		t.Fatalf("expected the run to finish both terminations, completed=%v retried=%v", st.completed, st.retried)
+	}
+}
+
+func TestDrainRelayTerminations_CompletesUnconfirmedTermination(t *testing.T) {
+	st := &fakeStore{pending: []model.RelayTermination{
+		{ID: 1, SessionID: "ses_1", AWSInstanceID: "i-slow", Region: "us-east-1"},
+	}}
+	r := NewRunner(st, &fakeDeprovisioner{pendingInstance: "i-slow"}, "aws")
+
+	if err := r.drainRelayTerminations(context.Background()); err != nil {
+		t.Fatalf("drainRelayTerminations: %v", err)
+	}
+	if len(st.completed) != 1 || len(st.unconfirmed) != 1 || len(st.retried) != 0 {
+		t.Fatalf("expected the stop to complete unconfirmed, completed=%v unconfirmed=%v retried=%v", st.completed, st.unconfirmed, st.retried)
+	}
+}
+
+func TestReapUnconfirmedTerminations_ConfirmsAndReterminates(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	st := &fakeStore{terminating: []model.TerminatingRelay{
+		{RelayInstanceID: "ri_gone", Region: "us-east-1", AWSInstanceID: "i-gone", TerminateRequestedAt: time.Now().Add(-time.Minute)},
+		{RelayInstanceID: "ri_recent", Region: "us-east-1", AWSInstanceID: "i-recent", TerminateRequestedAt: time.Now().Add(-time.Minute)},
+		{RelayInstanceID: "ri_stuck", Region: "us-east-1", AWSInstanceID: "i-stuck", TerminateRequestedAt: time.Now().Add(-time.Hour)},
+	}}
+	prov := &fakeReplacer{states: map[string]string{
+		"i-gone":   relay.InstanceTerminated,
+		"i-recent": relay.InstanceShuttingDown,
+		"i-stuck":  relay.InstanceRunning,
+	}}
+	r := NewRunner(st, prov, "aws")
+
+	if err := r.reapUnconfirmedTerminations(context.Background()); err != nil {
+		t.Fatalf("reapUnconfirmedTerminations: %v", err)
+	}
+	if len(prov.deprovisions) != 1 || prov.deprovisions[0] != "i-stuck" {
+		t.Fatalf("expected only the stuck instance re-terminated, got %v", prov.deprovisions)
+	}
+	if prov.reasons[0] != model.TerminateReasonOrphanReaper {
+		t.Fatalf("expected a relay without a reason attributed to the reaper, got %q", prov.reasons[0])
+	}
+	if len(st.terminated) != 2 || st.terminated[0] != "ri_gone" || st.terminated[1] != "ri_stuck" {
+		t.Fatalf("expected gone and re-terminated relays confirmed, got %v", st.terminated)
+	}
+	out := metrics.Default().Render()
+	if !strings.Contains(out, "aegis_relay_terminations_pending 3") {
+		t.Fatalf("expected pending gauge, got:\n%s", out)
+	}
+	if !strings.Contains(out, `aegis_relay_terminations_reissued_total{region="us-east-1"} 1`) {
+		t.Fatalf("expected reissued counter, got:\n%s", out)
+	}
+}
+
+type alertRecorder struct {
+	mu     sync.Mutex
+	alerts []alert.Alert
+}
+
+func (r *alertRecorder) Name() string { return "recorder" }
+
+func (r *alertRecorder) Notify(_ context.Context, a alert.Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, a)
+	return nil
+}
+
+func TestDrainRelayTerminations_AlertsAfterRepeatedFailures(t *testing.T) {
+	st := &fakeStore{pending: []model.RelayTermination{
+		{ID: 1, SessionID: "ses_1", AWSInstanceID: "i-bad", Region: "us-east-1", Attempts: 1},
+		{ID: 2, SessionID: "ses_2", AWSInstanceID: "i-bad", Region: "eu-west-1", Attempts: 5},
+	}}
+	rec := &alertRecorder{}
+	hook := alert.New(time.Hour, rec)
+	r := NewRunner(st, &fakeDeprovisioner{failInstance: "i-bad"}, "aws", WithAlerts(hook, 6))
+
+	if err := r.drainRelayTerminations(context.Background()); err != nil {
+		t.Fatalf("drainRelayTerminations: %v", err)
+	}
+	hook.Wait()
+	if len(st.retried) != 2 {
+		t.Fatalf("expected both terminations retried, got %v", st.retried)
+	}
+	if len(rec.alerts) != 1 || rec.alerts[0].Kind != alert.KindTerminationRetries || rec.alerts[0].Fields["session_id"] != "ses_2" {
+		t.Fatalf("expected one alert for the sixth failure, got %+v", rec.alerts)
+	}
+}
+
+func TestReapUnconfirmedTerminations_AlertsOnRelaysRunningAnHour(t *testing.T) {
+	st := &fakeStore{terminating: []model.TerminatingRelay{
+		{RelayInstanceID: "ri_stuck", SessionID: "ses_1", Region: "us-east-1", AWSInstanceID: "i-stuck", TerminateRequestedAt: time.Now().Add(-20 * time.Minute), TerminatingSince: time.Now().Add(-50 * time.Minute)},
+		{RelayInstanceID: "ri_leaked", SessionID: "ses_2", Region: "us-east-1", AWSInstanceID: "i-leaked", TerminateRequestedAt: time.Now().Add(-20 * time.Minute), TerminatingSince: time.Now().Add(-2 * time.Hour)},
+	}}
+	prov := &fakeReplacer{states: map[string]string{"i-stuck": relay.InstanceRunning, "i-leaked": relay.InstanceRunning}}
+	rec := &alertRecorder{}
+	hook := alert.New(time.Hour, rec)
+	r := NewRunner(st, prov, "aws", WithAlerts(hook, 6))
+
+	if err := r.reapUnconfirmedTerminations(context.Background()); err != nil {
+		t.Fatalf("reapUnconfirmedTerminations: %v", err)
+	}
+	hook.Wait()
+	if len(rec.alerts) != 1 || rec.alerts[0].Kind != alert.KindOrphanedRelay || rec.alerts[0].Fields["instance_id"] != "i-leaked" {
+		t.Fatalf("expected one orphaned relay alert, got %+v", rec.alerts)
+	}
+	if len(prov.deprovisions) != 2 {
+		t.Fatalf("expected both relays re-terminated, got %v", prov.deprovisions)
+	}
+}
+
+func TestDrainRelayTerminations_PassesStaticIPAllocation(t *testing.T) {
+	st := &fakeStore{pending: []model.RelayTermination{
+		{ID: 1, SessionID: "ses_1", AWSInstanceID: "i-eip", Region: "us-east-1", EIPAllocationID: "eipalloc-1", SecurityGroupID: "sg-1"},
+	}}
+	prov := &fakeDeprovisioner{}
+	r := NewRunner(st, prov, "aws")
+
+	if err := r.drainRelayTerminations(context.Background()); err != nil {
+		t.Fatalf("drainRelayTerminations: %v", err)
+	}
+	if len(prov.requests) != 1 || prov.requests[0].EIPAllocationID != "eipalloc-1" || prov.requests[0].SecurityGroupID != "sg-1" {
+		t.Fatalf("expected allocation and security group ids passed to deprovision, got %+v", prov.requests)
+	}
+}
+
+func TestDrainRelayTerminations_PassesReasonAndRecordsDuration(t *testing.T) {
+	st := &fakeStore{pending: []model.RelayTermination{
+		{ID: 1, SessionID: "ses_1", AWSInstanceID: "i-1", Region: "us-east-1", Reason: model.TerminateReasonCompensation},
+	}}
+	prov := &fakeDeprovisioner{}
+	r := NewRunner(st, prov, "aws")
+
+	if err := r.drainRelayTerminations(context.Background()); err != nil {
+		t.Fatalf("drainRelayTerminations: %v", err)
+	}
+	if len(prov.requests) != 1 || prov.requests[0].Reason != model.TerminateReasonCompensation {
+		t.Fatalf("expected the queued reason passed to deprovision, got %+v", prov.requests)
+	}
+	if len(st.completedTook) != 1 || st.completedTook[0] < 0 {
+		t.Fatalf("expected the deprovision duration recorded, got %v", st.completedTook)
+	}
+}
+
+func TestDrainRelayTerminations_RoutesToRecordedProvider(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	st := &fakeStore{pending: []model.RelayTermination{
+		{ID: 1, SessionID: "ses_1", AWSInstanceID: "i-aws", Region: "eu-central", Provider: "aws"},
+		{ID: 2, SessionID: "ses_2", AWSInstanceID: "srv-1", Region: "eu-central", Provider: "hetzner"},
+		{ID: 3, SessionID: "ses_3", AWSInstanceID: "srv-2", Region: "eu-central"},
+	}}
+	aws, hetzner := &fakeDeprovisioner{}, &fakeDeprovisioner{}
+	router, err := relay.NewRouter(map[string]relay.Provisioner{"aws": aws, "hetzner": hetzner}, map[string]string{"eu-central": "hetzner"}, "aws")
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	r := NewRunner(st, router, "aws")
+
+	if err := r.drainRelayTerminations(context.Background()); err != nil {
+		t.Fatalf("drainRelayTerminations: %v", err)
+	}
+	if len(aws.requests) != 1 || aws.requests[0].AWSInstanceID != "i-aws" {
+		t.Fatalf("expected only the aws relay terminated on aws, got %+v", aws.requests)
+	}
+	// A relay without a recorded provider follows the region map.
+	if len(hetzner.requests) != 2 || hetzner.requests[0].AWSInstanceID != "srv-1" || hetzner.requests[1].AWSInstanceID != "srv-2" {
+		t.Fatalf("expected both hetzner relays terminated on hetzner, got %+v", hetzner.requests)
+	}
+	out := metrics.Default().Render()
+	if !strings.Contains(out, `aegis_relay_deprovision_total{provider="hetzner",region="eu-central",status="ok"} 2`) {
+		t.Fatalf("expected deprovisions labelled with the recorded provider, got:\n%s", out)
+	}
+}
+
+func TestSampleActiveSessions_SetsAndClearsSeries(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	st := &fakeStore{sessionCounts: map[model.SessionStatus]map[string]int{
+		model.SessionActive:   {"us-east-1": 3, "eu-west-1": 1},
+		model.SessionStopping: {"us-east-1": 1},
+	}}
+	r := NewRunner(st, &fakeReplacer{}, "aws")
+
+	if err := r.sampleActiveSessions(context.Background()); err != nil {
+		t.Fatalf("sampleActiveSessions: %v", err)
+	}
+	out := metrics.Default().Render()
+	for _, want := range []string{
+		`aegis_active_sessions{region="us-east-1",status="active"} 3`,
+		`aegis_active_sessions{region="eu-west-1",status="active"} 1`,
+		`aegis_active_sessions{region="us-east-1",status="stopping"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %s, got:\n%s", want, out)
+		}
+	}
+
+	st.sessionCounts = map[model.SessionStatus]map[string]int{
+		model.SessionActive: {"us-east-1": 2},
+		model.SessionGrace:  {"us-east-1": 1},
+	}
+	if err := r.sampleActiveSessions(context.Background()); err != nil {
+		t.Fatalf("sampleActiveSessions: %v", err)
+	}
+	out = metrics.Default().Render()
+	for _, want := range []string{
+		`aegis_active_sessions{region="us-east-1",status="active"} 2`,
+		`aegis_active_sessions{region="us-east-1",status="grace"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %s, got:\n%s", want, out)
+		}
+	}
+	for _, gone := range []string{
+		`aegis_active_sessions{region="eu-west-1",status="active"}`,
+		`aegis_active_sessions{region="us-east-1",status="stopping"}`,
+	} {
+		if strings.Contains(out, gone) {
+			t.Fatalf("expected %s to be cleared, got:\n%s", gone, out)
+		}
+	}
+
+	st.sessionCounts = nil
+	if err := r.sampleActiveSessions(context.Background()); err != nil {
+		t.Fatalf("sampleActiveSessions: %v", err)
+	}
+	if out := metrics.Default().Render(); strings.Contains(out, "aegis_active_sessions{") {
+		t.Fatalf("expected every series cleared once no sessions are live, got:\n%s", out)
+	}
+}
+
+func TestSampleRelayAgentVersions_SetsAndClearsSeries(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	st := &fakeStore{agentCounts: map[string]int{"1.4.0": 2, "unknown": 1}}
+	r := NewRunner(st, &fakeReplacer{}, "aws")
+
+	if err := r.sampleSessionGauges(context.Background()); err != nil {
+		t.Fatalf("sampleSessionGauges: %v", err)
+	}
+	out := metrics.Default().Render()
+	for _, want := range []string{
+		`aegis_relays_by_agent_version{agent_version="1.4.0"} 2`,
+		`aegis_relays_by_agent_version{agent_version="unknown"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %s, got:\n%s", want, out)
+		}
+	}
+
+	st.agentCounts = map[string]int{"1.5.0": 3}
+	if err := r.sampleRelayAgentVersions(context.Background()); err != nil {
+		t.Fatalf("sampleRelayAgentVersions: %v", err)
+	}
+	out = metrics.Default().Render()
+	if !strings.Contains(out, `aegis_relays_by_agent_version{agent_version="1.5.0"} 3`) ||
+		strings.Contains(out, `agent_version="1.4.0"`) || strings.Contains(out, `agent_version="unknown"`) {
+		t.Fatalf("expected only the 1.5.0 series, got:\n%s", out)
+	}
+}
+
+func TestRollupUsage_RecordsAlertsWhenConfigured(t *testing.T) {
+	st := &fakeStore{}
+	if err := NewRunner(st, &fakeReplacer{}, "aws").rollupUsage(context.Background()); err != nil {
+		t.Fatalf("rollupUsage: %v", err)
+	}
+	if len(st.alertThresholds) != 0 {
+		t.Fatalf("expected no alerts without thresholds, got %v", st.alertThresholds)
+	}
+
+	r := NewRunner(st, &fakeReplacer{}, "aws", WithUsageAlertThresholds([]int{80, 100}))
+	if err := r.rollupUsage(context.Background()); err != nil {
+		t.Fatalf("rollupUsage: %v", err)
+	}
+	if !reflect.DeepEqual(st.alertThresholds, [][]int{{80, 100}}) {
+		t.Fatalf("expected alerts evaluated at 80 and 100, got %v", st.alertThresholds)
+	}
+}
+
+func TestRollupJobs_CountRowsTouched(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	r := NewRunner(&fakeStore{}, &fakeReplacer{}, "aws")
+	if err := r.rollupUsage(context.Background()); err != nil {
+		t.Fatalf("rollupUsage: %v", err)
+	}
+	if err := r.reconcileOutages(context.Background()); err != nil {
+		t.Fatalf("reconcileOutages: %v", err)
+	}
+	out := metrics.Default().Render()
+	for _, want := range []string{
+		`aegis_rollup_rows_touched_total{step="live_durations"} 2`,
+		`aegis_rollup_rows_touched_total{step="live_costs"} 3`,
+		`aegis_rollup_rows_touched_total{step="outage_reconciliation"} 0`,
+		`aegis_rollup_rows_touched_total{step="usage_rollups"} 6`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %s, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestDeliverWebhooks_RetriesAndDeadLetters(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	st := &fakeStore{deliveries: []model.WebhookDelivery{
+		{ID: 1, EventType: model.WebhookSessionStarted, URL: "https://ok.example.com"},
+		{ID: 2, EventType: model.WebhookSessionStopped, URL: "https://down.example.com", Attempts: 1},
+		{ID: 3, EventType: model.WebhookSessionStopped, URL: "https://down.example.com", Attempts: 7},
+	}}
+	sender := fakeWebhookSender{"https://ok.example.com": 204, "https://down.example.com": 503}
+	r := NewRunner(st, &fakeReplacer{}, "aws", WithWebhooks(sender, 8))
+
+	before := time.Now()
+	if err := r.deliverWebhooks(context.Background()); err != nil {
+		t.Fatalf("deliverWebhooks: %v", err)
+	}
+	if !reflect.DeepEqual(st.delivered, []int64{1}) || !reflect.DeepEqual(st.deadLettered, []int64{3}) {
+		t.Fatalf("expected 1 delivered and 3 dead-lettered, got delivered=%v dead=%v", st.delivered, st.deadLettered)
+	}
+	next, ok := st.webhookRetries[2]
+	if !ok || len(st.webhookRetries) != 1 {
+		t.Fatalf("expected only delivery 2 rescheduled, got %v", st.webhookRetries)
+	}
+	if got := next.Sub(before); got < 60*time.Second {
+		t.Fatalf("expected second attempt backoff of at least 60s, got %s", got)
+	}
+	out := metrics.Default().Render()
+	for _, want := range []string{
+		`aegis_webhook_deliveries_total{event="session_started",status="ok"} 1`,
+		`aegis_webhook_deliveries_total{event="session_stopped",status="retry"} 1`,
+		`aegis_webhook_deliveries_total{event="session_stopped",status="dead"} 1`,
+		`aegis_webhook_delivery_latency_ms_count{event="session_started",status="ok"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %s, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestExportBilling_ReportsRetriesAndParks(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	cycleStart := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	st := &fakeStore{billingExports: []model.BillingExport{
+		{UserID: "usr_ok", CycleStart: cycleStart, CycleEnd: cycleStart.AddDate(0, 1, 0), SubscriptionItemID: "si_1", OverageSeconds: 600},
+		{UserID: "usr_down", CycleStart: cycleStart, CycleEnd: cycleStart.AddDate(0, 1, 0), SubscriptionItemID: "si_2", Attempts: 1},
+		{UserID: "usr_gone", CycleStart: cycleStart, CycleEnd: cycleStart.AddDate(0, 1, 0), SubscriptionItemID: "si_3", Attempts: 9},
+	}}
+	reporter := &fakeStripe{failUsers: map[string]bool{"usr_down": true, "usr_gone": true}}
+	r := NewRunner(st, &fakeReplacer{}, "aws", WithBillingExport(reporter, 10))
+
+	before := time.Now()
+	if err := r.exportBilling(context.Background()); err != nil {
+		t.Fatalf("exportBilling: %v", err)
+	}
+	if len(st.billingReported) != 1 || st.billingReported["usr_ok"] == "" {
+		t.Fatalf("expected only usr_ok reported, got %v", st.billingReported)
+	}
+	if next, ok := st.billingRetries["usr_down"]; !ok || len(st.billingRetries) != 1 || next.Sub(before) < 2*time.Minute {
+		t.Fatalf("expected usr_down rescheduled with backoff, got %v", st.billingRetries)
+	}
+	if !reflect.DeepEqual(st.billingFailed, []string{"usr_gone"}) {
+		t.Fatalf("expected usr_gone parked, got %v", st.billingFailed)
+	}
+
+	// A report repeated after a lost acknowledgement sets the same usage.
+	st.billingExports = st.billingExports[:1]
+	if err := r.exportBilling(context.Background()); err != nil {
+		t.Fatalf("exportBilling rerun: %v", err)
+	}
+	if len(reporter.usage) != 1 || reporter.usage["aegis-usage-usr_ok-1772323200"] != 600 {
+		t.Fatalf("expected one usage report per user and cycle, got %v", reporter.usage)
+	}
+
+	out := metrics.Default().Render()
+	for _, want := range []string{
+		`aegis_billing_exports_total{status="ok"} 2`,
+		`aegis_billing_exports_total{status="retry"} 1`,
+		`aegis_billing_exports_total{status="failed"} 1`,
+		`aegis_billing_exports_failed 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %s, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestRunRequestedJobs_RunsEnabledAndFailsOthers(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	st := &fakeStore{
+		jobRuns: []model.JobRun{
+			{ID: 1, Job: "session_usage_rollup", RequestedBy: "usr_admin"},
+			{ID: 2, Job: "billing_export", RequestedBy: "usr_admin"},
+		},
+	}
+	r := NewRunner(st, &fakeReplacer{}, "aws", WithUsageAlertThresholds([]int{80}))
+
+	if err := r.runRequestedJobs(context.Background(), r.jobs()); err != nil {
+		t.Fatalf("runRequestedJobs: %v", err)
+	}
+	want := map[int64]string{1: "", 2: "job not enabled on this worker"}
+	if !reflect.DeepEqual(st.jobRunsDone, want) {
+		t.Fatalf("unexpected run outcomes: %v", st.jobRunsDone)
+	}
+	if len(st.alertThresholds) != 1 {
+		t.Fatalf("expected the requested rollup to run once, got %v", st.alertThresholds)
+	}
+	if out := metrics.Default().Render(); !strings.Contains(out, `aegis_job_runs_total{job="session_usage_rollup",status="ok"} 1`) {
+		t.Fatalf("expected requested run counted as a job run, got:\n%s", out)
+	}
+}
+
+func TestNames_CoversEveryJob(t *testing.T) {
+	r := NewRunner(&fakeStore{}, &fakePoolProvider{}, "aws", WithWebhooks(fakeWebhookSender{}, 3), WithBillingExport(&fakeStripe{}, 3), WithHealthEventRetention(time.Hour), WithSessionChangeRetention(time.Hour),
+		WithCanary(canaryService(&canarySessionStore{}, &fakeReplacer{}), CanaryOptions{Interval: time.Minute, Regions: []string{"us-east-1"}}))
+	var got []string
+	for _, j := range r.jobs() {
+		got = append(got, j.name)
+	}
+	if !reflect.DeepEqual(got, Names) {
+		t.Fatalf("expected Names %v to list every job, got %v", Names, got)
+	}
+}
+
+func TestPurgeHealthEvents_DeletesInBatchesUntilShort(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	st := &fakeStore{healthEvents: 2*healthRetentionBatchSize + 7}
+	r := NewRunner(st, &fakeReplacer{}, "aws", WithHealthEventRetention(24*time.Hour))
+
+	before := time.Now()
+	if err := r.purgeHealthEvents(context.Background()); err != nil {
+		t.Fatalf("purgeHealthEvents: %v", err)
+	}
+	if len(st.healthCutoffs) != 3 || st.healthEvents != 0 {
+		t.Fatalf("expected three batches purging everything, got %d batches with %d left", len(st.healthCutoffs), st.healthEvents)
+	}
+	if cutoff := st.healthCutoffs[0]; cutoff.Before(before.Add(-24*time.Hour)) || cutoff.After(time.Now().Add(-24*time.Hour)) {
+		t.Fatalf("expected a cutoff 24h ago, got %s", cutoff)
+	}
+	want := fmt.Sprintf("aegis_relay_health_events_purged_total %d", 2*healthRetentionBatchSize+7)
+	if out := metrics.Default().Render(); !strings.Contains(out, want) {
+		t.Fatalf("expected %s, got:\n%s", want, out)
+	}
+}
+
+func TestPurgeSessionChanges_DeletesInBatchesUntilShort(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	st := &fakeStore{changes: healthRetentionBatchSize + 3}
+	r := NewRunner(st, &fakeReplacer{}, "aws", WithSessionChangeRetention(7*24*time.Hour))
+
+	before := time.Now()
+	if err := r.purgeSessionChanges(context.Background()); err != nil {
+		t.Fatalf("purgeSessionChanges: %v", err)
+	}
+	if len(st.changeCutoffs) != 2 || st.changes != 0 {
+		t.Fatalf("expected two batches purging everything, got %d batches with %d left", len(st.changeCutoffs), st.changes)
+	}
+	if cutoff := st.changeCutoffs[0]; cutoff.Before(before.Add(-7*24*time.Hour)) || cutoff.After(time.Now().Add(-7*24*time.Hour)) {
+		t.Fatalf("expected a cutoff a week ago, got %s", cutoff)
+	}
+	want := fmt.Sprintf("aegis_session_changes_purged_total %d", healthRetentionBatchSize+3)
+	if out := metrics.Default().Render(); !strings.Contains(out, want) {
+		t.Fatalf("expected %s, got:\n%s", want, out)
+	}
+}
+
+func TestRollupRelayQuality_UsesHeartbeatTimeout(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	st := &fakeStore{}
+	r := NewRunner(st, &fakeReplacer{}, "aws")
+
+	if err := r.rollupRelayQuality(context.Background()); err != nil {
+		t.Fatalf("rollupRelayQuality: %v", err)
+	}
+	if len(st.qualityRollups) != 1 || st.qualityRollups[0] != "1m30s/7" {
+		t.Fatalf("expected gaps over the heartbeat timeout with a week's lookback, got %v", st.qualityRollups)
+	}
+	if out := metrics.Default().Render(); !strings.Contains(out, `aegis_rollup_rows_touched_total{step="relay_quality"} 4`) {
+		t.Fatalf("expected the rows counted, got:\n%s", out)
+	}
+}
+
+func TestPurgeHealthEvents_StopsAtMaxBatches(t *testing.T) {
+	st := &fakeStore{healthEvents: (healthRetentionMaxBatches + 1) * healthRetentionBatchSize}
+	r := NewRunner(st, &fakeReplacer{}, "aws", WithHealthEventRetention(time.Hour))
+
+	if err := r.purgeHealthEvents(context.Background()); err != nil {
+		t.Fatalf("purgeHealthEvents: %v", err)
+	}
+	if len(st.healthCutoffs) != healthRetentionMaxBatches || st.healthEvents != healthRetentionBatchSize {
+		t.Fatalf("expected the run capped at %d batches, got %d with %d left", healthRetentionMaxBatches, len(st.healthCutoffs), st.healthEvents)
+	}
+}
+
+func TestTerminationBackoff_Caps(t *testing.T) {
+	if got := terminationBackoff(1); got != terminationBaseDelay {
+		t.Fatalf("unexpected first backoff: %s", got)
+	}
+	if got := terminationBackoff(50); got != terminationMaxBackoff {
+		t.Fatalf("expected capped backoff, got %s", got)
+	}
+}
+
+func TestReplaceDeadRelays_ReplacesDeadAndSkipsHealthy(t *testing.T) {
+	st := &fakeStore{candidates: []model.RelayCheck{
+		{SessionID: "ses_gone", UserID: "usr_1", Region: "us-east-1", RelayInstanceID: "rly_1", RelayRegion: "us-east-1", AWSInstanceID: "i-gone", RelayWSToken: "tok_1"},
+		{SessionID: "ses_quiet", UserID: "usr_2", Region: "us-east-1", RelayInstanceID: "rly_2", RelayRegion: "us-east-1", AWSInstanceID: "i-quiet", HeartbeatStale: true},
+		{SessionID: "ses_ok", UserID: "usr_3", Region: "us-east-1", RelayInstanceID: "rly_3", RelayRegion: "us-east-1", AWSInstanceID: "i-ok"},
+	}}
+	prov := &fakeReplacer{states: map[string]string{
+		"i-gone":  relay.InstanceTerminated,
+		"i-quiet": relay.InstanceRunning,
+		"i-ok":    relay.InstanceRunning,
+	}}
+	r := NewRunner(st, prov, "aws", WithRelayControlPlaneURL("https://api.telemy.test"))
+
+	if err := r.replaceDeadRelays(context.Background()); err != nil {
+		t.Fatalf("replaceDeadRelays: %v", err)
+	}
+	if req := prov.requests[0]; req.RelayAuthToken != "tok_1" || req.ControlPlaneURL != "https://api.telemy.test" || req.ReplacesInstanceID != "i-gone" {
+		t.Fatalf("expected replacement to reuse session bootstrap settings, got %+v", req)
+	}
+	if len(st.replaced) != 2 {
+		t.Fatalf("expected two replacements, got %+v", st.replaced)
+	}
+	first := st.replaced[0]
+	if first.SessionID != "ses_gone" || first.OldAWSInstanceID != "i-gone" || first.AWSInstanceID != "i-new-ses_gone" || first.Reason != "instance_terminated" {
+		t.Fatalf("unexpected replacement: %+v", first)
+	}
+	if st.replaced[1].Reason != "heartbeat_timeout" {
+		t.Fatalf("expected heartbeat_timeout reason, got %q", st.replaced[1].Reason)
+	}
+}
+
+func TestReplaceDeadRelays_SkipsWhenClaimLost(t *testing.T) {
+	st := &fakeStore{
+		candidates: []model.RelayCheck{{SessionID: "ses_1", RelayInstanceID: "rly_1", AWSInstanceID: "i-gone"}},
+		claimLost:  true,
+	}
+	prov := &fakeReplacer{states: map[string]string{"i-gone": relay.InstanceStopped}}
+	r := NewRunner(st, prov, "aws")
+
+	if err := r.replaceDeadRelays(context.Background()); err != nil {
+		t.Fatalf("replaceDeadRelays: %v", err)
+	}
+	if len(prov.provisioned) != 0 {
+		t.Fatalf("expected no provisioning without a claim, got %v", prov.provisioned)
+	}
+}
+
+func TestReplaceDeadRelays_TerminatesUnboundReplacement(t *testing.T) {
+	st := &fakeStore{
+		candidates: []model.RelayCheck{{SessionID: "ses_1", Region: "us-east-1", RelayInstanceID: "rly_1", AWSInstanceID: "i-gone"}},
+		replaceErr: store.ErrNotFound,
+	}
+	prov := &fakeReplacer{states: map[string]string{"i-gone": relay.InstanceTerminated}}
+	r := NewRunner(st, prov, "aws")
+
+	if err := r.replaceDeadRelays(context.Background()); err != nil {
+		t.Fatalf("replaceDeadRelays: %v", err)
+	}
+	if len(prov.deprovisions) != 1 || prov.deprovisions[0] != "i-new-ses_1" || prov.reasons[0] != model.TerminateReasonCompensation {
+		t.Fatalf("expected replacement to be terminated as compensation, got %v %v", prov.deprovisions, prov.reasons)
+	}
+	if len(st.released) != 0 {
+		t.Fatalf("expected no claim release for a stopped session, got %v", st.released)
+	}
+}
+
+func TestReplaceDeadRelays_TerminatesInvalidReplacement(t *testing.T) {
+	st := &fakeStore{
+		candidates: []model.RelayCheck{{SessionID: "ses_1", Region: "us-east-1", RelayInstanceID: "rly_1", AWSInstanceID: "i-gone"}},
+	}
+	prov := &fakeReplacer{states: map[string]string{"i-gone": relay.InstanceTerminated}, publicIP: "not-an-ip"}
+	r := NewRunner(st, prov, "aws")
+
+	var fieldErr *model.FieldError
+	if err := r.replaceDeadRelays(context.Background()); !errors.As(err, &fieldErr) || fieldErr.Field != "public_ip" {
+		t.Fatalf("expected a public_ip field error, got %v", err)
+	}
+	if len(st.replaced) != 0 {
+		t.Fatalf("expected the replacement not to be bound, got %+v", st.replaced)
+	}
+	if len(prov.deprovisions) != 1 || prov.deprovisions[0] != "i-new-ses_1" {
+		t.Fatalf("expected replacement to be terminated, got %v", prov.deprovisions)
+	}
+	if len(st.released) != 1 {
+		t.Fatalf("expected the claim released, got %v", st.released)
+	}
+}
+
+type fakePoolProvider struct {
+	fakeReplacer
+	sizes    map[string]int
+	launched int
+	stopped  []string
+}
+
+func (f *fakePoolProvider) WarmPoolSettings() (map[string]int, time.Duration) {
+	return f.sizes, 24 * time.Hour
+}
+
+func (f *fakePoolProvider) PoolImage(context.Context, string) (string, string, error) {
+	return "ami-current", "t4g.small", nil
+}
+
+func (f *fakePoolProvider) LaunchPooled(_ context.Context, region string) (model.PooledRelay, error) {
+	f.launched++
+	return model.PooledRelay{AWSInstanceID: fmt.Sprintf("i-pool-%d", f.launched), Region: region, AMIID: "ami-current", InstanceType: "t4g.small", State: model.PoolWarming, LaunchedAt: time.Now()}, nil
+}
+
+func (f *fakePoolProvider) StopPooled(_ context.Context, _ string, id string) error {
+	f.stopped = append(f.stopped, id)
+	return nil
+}
+
+func TestMaintainWarmPool_AdvancesRecyclesAndReplenishes(t *testing.T) {
+	now := time.Now()
+	st := &fakeStore{pool: []model.PooledRelay{
+		{AWSInstanceID: "i-running", Region: "us-east-1", AMIID: "ami-current", InstanceType: "t4g.small", State: model.PoolWarming, LaunchedAt: now},
+		{AWSInstanceID: "i-stopped", Region: "us-east-1", AMIID: "ami-current", InstanceType: "t4g.small", State: model.PoolWarming, LaunchedAt: now},
+		{AWSInstanceID: "i-old-image", Region: "us-east-1", AMIID: "ami-previous", InstanceType: "t4g.small", State: model.PoolAvailable, LaunchedAt: now},
+		{AWSInstanceID: "i-expired", Region: "us-east-1", AMIID: "ami-current", InstanceType: "t4g.small", State: model.PoolAvailable, LaunchedAt: now.Add(-25 * time.Hour)},
+		{AWSInstanceID: "i-gone", Region: "us-east-1", AMIID: "ami-current", InstanceType: "t4g.small", State: model.PoolAvailable, LaunchedAt: now},
+	}}
+	prov := &fakePoolProvider{
+		fakeReplacer: fakeReplacer{states: map[string]string{
+			"i-running":   relay.InstanceRunning,
+			"i-stopped":   relay.InstanceStopped,
+			"i-old-image": relay.InstanceStopped,
+			"i-expired":   relay.InstanceStopped,
+			"i-gone":      relay.InstanceTerminated,
+		}},
+		sizes: map[string]int{"us-east-1": 4},
+	}
+	r := NewRunner(st, prov, "aws")
+
+	if err := r.maintainWarmPool(context.Background()); err != nil {
+		t.Fatalf("maintainWarmPool: %v", err)
+	}
+	if len(prov.stopped) != 1 || prov.stopped[0] != "i-running" {
+		t.Fatalf("expected running warm instance to be stopped, got %v", prov.stopped)
+	}
+	if strings.Join(prov.deprovisions, ",") != "i-old-image,i-expired" {
+		t.Fatalf("expected stale instances to be recycled, got %v", prov.deprovisions)
+	}
+	if strings.Join(prov.reasons, ",") != "pool_recycle,pool_recycle" {
+		t.Fatalf("expected recycled instances tagged pool_recycle, got %v", prov.reasons)
+	}
+	states := make(map[string]string)
+	for _, p := range st.pool {
+		states[p.AWSInstanceID] = p.State
+	}
+	want := map[string]string{
+		"i-running": model.PoolWarming,
+		"i-stopped": model.PoolAvailable,
+		"i-pool-1":  model.PoolWarming,
+		"i-pool-2":  model.PoolWarming,
+	}
+	if !reflect.DeepEqual(states, want) {
+		t.Fatalf("unexpected pool after maintenance: %v", states)
+	}
+}
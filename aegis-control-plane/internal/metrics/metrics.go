@@ -6,6 +6,8 @@ import (
 	"sort"
 	"strings"
 	"sync"
+
+	"github.com/telemyapp/aegis-control-plane/internal/version"
 )
 
 type metricType string
@@ -13,6 +15,7 @@ type metricType string
 const (
 	counterType   metricType = "counter"
 	histogramType metricType = "histogram"
+	gaugeType     metricType = "gauge"
 )
 
 type descriptor struct {
@@ -27,6 +30,11 @@ type counterSeries struct {
 	Value  uint64
 }
 
+type gaugeSeries struct {
+	Labels map[string]string
+	Value  float64
+}
+
 type histogramSeries struct {
 	Labels       map[string]string
 	Count        uint64
@@ -34,34 +42,100 @@ type histogramSeries struct {
 	BucketCounts []uint64
 }
 
+// DefaultSeriesLimit caps the label sets kept per metric. Observations that
+// would add a series past the cap are dropped and counted in
+// aegis_metrics_series_dropped_total, so a label fed from request data
+// cannot grow the registry without bound.
+const DefaultSeriesLimit = 1000
+
+const droppedSeriesMetric = "aegis_metrics_series_dropped_total"
+
 type Registry struct {
 	mu         sync.RWMutex
 	descs      map[string]descriptor
 	counters   map[string]map[string]*counterSeries
 	histograms map[string]map[string]*histogramSeries
+	gauges     map[string]map[string]*gaugeSeries
+
+	seriesLimit int
 }
 
 func NewRegistry() *Registry {
 	r := &Registry{
-		descs:      make(map[string]descriptor),
-		counters:   make(map[string]map[string]*counterSeries),
-		histograms: make(map[string]map[string]*histogramSeries),
+		descs:       make(map[string]descriptor),
+		counters:    make(map[string]map[string]*counterSeries),
+		histograms:  make(map[string]map[string]*histogramSeries),
+		gauges:      make(map[string]map[string]*gaugeSeries),
+		seriesLimit: DefaultSeriesLimit,
 	}
 	r.registerDefaults()
 	return r
 }
 
 func (r *Registry) registerDefaults() {
+	r.RegisterCounter(droppedSeriesMetric, "Total observations dropped because their metric reached the series limit, by metric.")
+	r.RegisterGauge("aegis_build_info", "Always 1; labelled with the version and commit of the running binary.")
+	r.SetGauge("aegis_build_info", 1, map[string]string{"version": version.Version, "commit": version.Commit})
+	r.RegisterCounter("aegis_sessions_started_total", "Total sessions created, by region and plan tier.")
+	r.RegisterCounter("aegis_sessions_stopped_total", "Total sessions stopped, by region and reason (user, admin, start_failed).")
+	r.RegisterGauge("aegis_active_sessions", "Sessions not yet stopped, by status and region, as of the last active session sample.")
+	r.RegisterGauge("aegis_relays_by_agent_version", "Running relays of live sessions by the agent version they last reported, as of the last active session sample.")
+	r.RegisterHistogram("aegis_session_duration_seconds", "Session length from start to stop request in seconds, by region.", []float64{60, 300, 900, 1800, 3600, 7200, 14400, 28800, 43200, 57600})
 	r.RegisterCounter("aegis_job_runs_total", "Total background job runs by job and status.")
 	r.RegisterHistogram("aegis_job_duration_ms", "Background job duration in milliseconds by job.", []float64{10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000})
 	r.RegisterCounter("aegis_relay_provision_total", "Total relay provision attempts by provider, region, and status.")
+	r.RegisterCounter("aegis_relay_provision_failures_total", "Failed relay provision calls by provider, region, and cause (no_capacity, throttled, quota_exceeded, image_unavailable, auth, provider_unavailable, static_ip_unavailable, other).")
+	r.RegisterCounter("aegis_relay_provision_attempts_total", "Relay launch attempts within provision calls, capacity fallbacks included, by provider, region, outcome, and provider error code.")
 	r.RegisterHistogram("aegis_relay_provision_latency_ms", "Relay provision latency in milliseconds by provider, region, and status.", []float64{25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000, 120000})
+	r.RegisterGauge("aegis_relay_provision_queue_depth", "Relay starts waiting for a provision slot, by region.")
+	r.RegisterHistogram("aegis_relay_provision_queue_wait_ms", "Time relay starts waited for a provision slot in milliseconds, by region and status (ok, timeout, canceled).", []float64{10, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 20000, 30000, 60000})
+	r.RegisterHistogram("aegis_relay_provision_phase_ms", "Relay provision phase latency in milliseconds by phase (launch, wait_running, describe, boot_probe), provider, region, instance type, and status.", []float64{25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000, 120000})
+	r.RegisterHistogram("aegis_relay_boot_ready_ms", "Time from a relay launch returning to the relay passing the boot probe, in milliseconds by provider, region, and status.", []float64{100, 250, 500, 1000, 2500, 5000, 10000, 15000, 20000, 30000, 45000, 60000})
+	r.RegisterCounter("aegis_relay_capacity_fallback_total", "Total relay launches moved to an alternate region/instance type after capacity errors, by from and to target.")
+	r.RegisterCounter("aegis_relay_spot_fallback_total", "Total spot launch requests that fell back to on-demand, by region.")
+	r.RegisterCounter("aegis_relay_subnet_capacity_retry_total", "Total relay launches retried in another subnet of the same region after capacity errors, by region.")
+	r.RegisterCounter("aegis_relay_interruptions_total", "Total spot interruption notices reported by relays, by region.")
+	r.RegisterCounter("aegis_relay_replacements_total", "Total relay replacement attempts by provider, region, reason, and status.")
 	r.RegisterCounter("aegis_relay_deprovision_total", "Total relay deprovision attempts by provider, region, and status.")
 	r.RegisterHistogram("aegis_relay_deprovision_latency_ms", "Relay deprovision latency in milliseconds by provider, region, and status.", []float64{25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000})
 	r.RegisterCounter("aegis_aws_retries_total", "Total AWS retries by operation, region, and error code.")
 	r.RegisterCounter("aegis_aws_retry_exhausted_total", "Total AWS operations that exhausted retry attempts by operation and region.")
+	r.RegisterCounter("aegis_aws_retry_budget_exhausted_total", "Total transient AWS errors returned without retry because the shared retry budget was exhausted, by op and region.")
+	r.RegisterGauge("aegis_aws_circuit_state", "AWS circuit breaker state by op and region: 0 closed, 1 half-open, 2 open.")
+	r.RegisterCounter("aegis_aws_circuit_transitions_total", "Total AWS circuit breaker state changes by op, region, and target state.")
 	r.RegisterCounter("aegis_aws_operations_total", "Total AWS operation attempts by operation, region, and status.")
 	r.RegisterHistogram("aegis_aws_operation_latency_ms", "AWS operation latency in milliseconds by operation, region, and status.", []float64{25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000, 120000})
+	r.RegisterCounter("aegis_relay_pool_claims_total", "Total warm pool claim attempts by region and result (hit, miss, error).")
+	r.RegisterHistogram("aegis_relay_pool_claim_latency_ms", "Time from claiming a warm pool instance to running, in milliseconds by region and status.", []float64{1000, 5000, 10000, 20000, 30000, 45000, 60000, 90000, 120000})
+	r.RegisterGauge("aegis_relay_pool_instances", "Warm pool instances by region and state, as of the last pool maintenance run.")
+	r.RegisterCounter("aegis_relay_pool_returns_total", "Total relays returned to the warm pool instead of being terminated, by region.")
+	r.RegisterGauge("aegis_relay_terminator_queue_depth", "Relay terminations queued or running in the worker's terminator.")
+	r.RegisterHistogram("aegis_relay_terminator_completion_ms", "Time from queueing a relay termination to the provider call returning, in milliseconds by region and status (ok, pending, error).", []float64{100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000, 120000, 300000})
+	r.RegisterGauge("aegis_relay_terminations_pending", "Relays whose termination was issued but not yet confirmed by the provider, as of the last orphan reaper run.")
+	r.RegisterCounter("aegis_relay_terminations_reissued_total", "Total terminations re-issued by the orphan reaper for relays stuck terminating, by region.")
+	r.RegisterHistogram("aegis_aws_instance_running_wait_ms", "Time spent waiting for a launched instance to reach running, in milliseconds by region and status.", []float64{1000, 5000, 10000, 20000, 30000, 45000, 60000, 90000, 120000, 180000, 300000})
+	r.RegisterCounter("aegis_provider_operations_total", "Total non-AWS provider operation attempts by provider, operation, region, and status.")
+	r.RegisterHistogram("aegis_provider_operation_latency_ms", "Non-AWS provider operation latency in milliseconds by provider, operation, region, and status.", []float64{25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000, 120000})
+	r.RegisterCounter("aegis_provider_retries_total", "Total non-AWS provider retries by provider, operation, region, and error code.")
+	r.RegisterCounter("aegis_provider_retry_exhausted_total", "Total non-AWS provider operations that exhausted retry attempts by provider, operation, and region.")
+	r.RegisterCounter("aegis_provider_retry_budget_exhausted_total", "Total transient non-AWS provider errors returned without retry because the retry budget was exhausted, by provider, op, and region.")
+	r.RegisterCounter("aegis_webhook_deliveries_total", "Total webhook delivery attempts by event and status (ok, retry, dead).")
+	r.RegisterHistogram("aegis_webhook_delivery_latency_ms", "Webhook delivery attempt latency in milliseconds by event and status (ok, retry, dead).", []float64{25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000})
+	r.RegisterCounter("aegis_billing_exports_total", "Stripe usage report attempts by status: ok, retry (rescheduled), failed (parked after the last attempt).")
+	r.RegisterGauge("aegis_billing_exports_failed", "Billing exports parked as failed, as of the last billing export run.")
+	r.RegisterCounter("aegis_alerts_fired_total", "Total operator alerts sent, by kind (provision_failures, termination_retries, orphaned_relay).")
+	r.RegisterCounter("aegis_alerts_suppressed_total", "Total operator alerts dropped, by kind and cause (duplicate within AEGIS_ALERT_COOLDOWN, rate_limited by the hourly cap).")
+	r.RegisterCounter("aegis_alert_delivery_failures_total", "Total operator alert deliveries that failed, by kind and notifier (slack, webhook).")
+	r.RegisterCounter("aegis_relay_health_events_purged_total", "Total relay health events deleted by the retention job.")
+	r.RegisterCounter("aegis_session_changes_purged_total", "Total changefeed rows deleted by the session change retention job.")
+	r.RegisterCounter("aegis_canary_runs_total", "Synthetic canary session runs by region and outcome (ok, start_failed, verify_failed, stop_failed).")
+	r.RegisterHistogram("aegis_canary_duration_ms", "Canary run duration from start to stop in milliseconds by region and outcome.", []float64{250, 500, 1000, 2500, 5000, 10000, 30000, 60000, 120000, 300000})
+	r.RegisterCounter("aegis_rollup_rows_touched_total", "Total rows changed by the usage rollups, by step (live_durations, live_costs, outage_reconciliation, usage_rollups, relay_quality).")
+	r.RegisterCounter("aegis_session_cost_default_price_total", "Total session cost estimates made at AEGIS_RELAY_DEFAULT_HOURLY_PRICE because the relay's instance type has no price, by region and instance type.")
+	r.RegisterHistogram("aegis_db_query_duration_ms", "Database statement latency in milliseconds by query (the store function issuing it).", []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 15000})
+	r.RegisterCounter("aegis_db_query_errors_total", "Total database statements that failed, by query.")
+	r.RegisterCounter("aegis_db_tx_retries_total", "Total transactions rerun after a serialization failure or deadlock, by op and sqlstate.")
+	r.RegisterHistogram("aegis_provider_instance_running_wait_ms", "Time spent waiting for a non-AWS server to be running with a public IP, in milliseconds by provider, region, and status.", []float64{1000, 5000, 10000, 20000, 30000, 45000, 60000, 90000, 120000, 180000, 300000})
 }
 
 func (r *Registry) RegisterCounter(name, help string) {
@@ -78,7 +152,48 @@ func (r *Registry) RegisterHistogram(name, help string, buckets []float64) {
 	r.descs[name] = descriptor{Name: name, Help: help, Type: histogramType, Buckets: cp}
 }
 
+func (r *Registry) RegisterGauge(name, help string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.descs[name] = descriptor{Name: name, Help: help, Type: gaugeType}
+}
+
+// SetSeriesLimit changes the per-metric series cap; n <= 0 removes it.
+// Existing series are kept.
+func (r *Registry) SetSeriesLimit(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seriesLimit = n
+}
+
+// admitSeries reports whether a metric holding n series may add another,
+// counting the drop when it may not. Callers hold r.mu.
+func (r *Registry) admitSeries(name string, n int) bool {
+	if r.seriesLimit <= 0 || n < r.seriesLimit || name == droppedSeriesMetric {
+		return true
+	}
+	dropped := r.counters[droppedSeriesMetric]
+	if dropped == nil {
+		dropped = make(map[string]*counterSeries)
+		r.counters[droppedSeriesMetric] = dropped
+	}
+	labels := map[string]string{"metric": name}
+	key := labelsKey(labels)
+	series := dropped[key]
+	if series == nil {
+		series = &counterSeries{Labels: labels}
+		dropped[key] = series
+	}
+	series.Value++
+	return false
+}
+
 func (r *Registry) IncCounter(name string, labels map[string]string) {
+	r.AddCounter(name, 1, labels)
+}
+
+// AddCounter adds n to a counter, for jobs that count rows in bulk.
+func (r *Registry) AddCounter(name string, n uint64, labels map[string]string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	desc, ok := r.descs[name]
@@ -93,10 +208,13 @@ func (r *Registry) IncCounter(name string, labels map[string]string) {
 	key := labelsKey(labels)
 	series := seriesMap[key]
 	if series == nil {
+		if !r.admitSeries(name, len(seriesMap)) {
+			return
+		}
 		series = &counterSeries{Labels: cloneLabels(labels)}
 		seriesMap[key] = series
 	}
-	series.Value++
+	series.Value += n
 }
 
 func (r *Registry) ObserveHistogram(name string, value float64, labels map[string]string) {
@@ -114,6 +232,9 @@ func (r *Registry) ObserveHistogram(name string, value float64, labels map[strin
 	key := labelsKey(labels)
 	series := seriesMap[key]
 	if series == nil {
+		if !r.admitSeries(name, len(seriesMap)) {
+			return
+		}
 		series = &histogramSeries{
 			Labels:       cloneLabels(labels),
 			BucketCounts: make([]uint64, len(desc.Buckets)+1),
@@ -132,6 +253,39 @@ func (r *Registry) ObserveHistogram(name string, value float64, labels map[strin
 	series.Sum += value
 }
 
+// SetGauge records the current value of a gauge series.
+func (r *Registry) SetGauge(name string, value float64, labels map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	desc, ok := r.descs[name]
+	if !ok || desc.Type != gaugeType {
+		return
+	}
+	seriesMap := r.gauges[name]
+	if seriesMap == nil {
+		seriesMap = make(map[string]*gaugeSeries)
+		r.gauges[name] = seriesMap
+	}
+	key := labelsKey(labels)
+	series := seriesMap[key]
+	if series == nil {
+		if !r.admitSeries(name, len(seriesMap)) {
+			return
+		}
+		series = &gaugeSeries{Labels: cloneLabels(labels)}
+		seriesMap[key] = series
+	}
+	series.Value = value
+}
+
+// DeleteGauge removes a gauge series, so a label set that no longer exists
+// stops being exported instead of keeping its last value.
+func (r *Registry) DeleteGauge(name string, labels map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.gauges[name], labelsKey(labels))
+}
+
 func (r *Registry) Handler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
@@ -174,6 +328,16 @@ func (r *Registry) Render() string {
 				s := series[key]
 				writeMetricLine(&b, name, s.Labels, fmt.Sprintf("%d", s.Value))
 			}
+		case gaugeType:
+			series := r.gauges[name]
+			if len(series) == 0 {
+				continue
+			}
+			keys := sortedSeriesKeys(series)
+			for _, key := range keys {
+				s := series[key]
+				writeMetricLine(&b, name, s.Labels, trimFloat(s.Value))
+			}
 		case histogramType:
 			series := r.histograms[name]
 			if len(series) == 0 {
@@ -18,3 +18,71 @@ func TestRenderIncludesCounterAndHistogramSeries(t *testing.T) {
 		t.Fatalf("missing histogram count sample: %s", out)
 	}
 }
+
+func TestAddCounterAccumulates(t *testing.T) {
+	r := NewRegistry()
+	r.AddCounter("aegis_relay_health_events_purged_total", 5000, nil)
+	r.AddCounter("aegis_relay_health_events_purged_total", 7, nil)
+	r.IncCounter("aegis_relay_health_events_purged_total", nil)
+
+	if out := r.Render(); !strings.Contains(out, "aegis_relay_health_events_purged_total 5008") {
+		t.Fatalf("missing accumulated counter sample: %s", out)
+	}
+}
+
+func TestRenderGaugeKeepsLatestValue(t *testing.T) {
+	r := NewRegistry()
+	r.SetGauge("aegis_relay_pool_instances", 3, map[string]string{"region": "us-east-1", "state": "available"})
+	r.SetGauge("aegis_relay_pool_instances", 1, map[string]string{"region": "us-east-1", "state": "available"})
+
+	out := r.Render()
+	if !strings.Contains(out, "# TYPE aegis_relay_pool_instances gauge") {
+		t.Fatalf("missing gauge type: %s", out)
+	}
+	if !strings.Contains(out, `aegis_relay_pool_instances{region="us-east-1",state="available"} 1`) {
+		t.Fatalf("missing gauge sample: %s", out)
+	}
+}
+
+func TestDeleteGaugeRemovesSeries(t *testing.T) {
+	r := NewRegistry()
+	r.SetSeriesLimit(1)
+	r.SetGauge("aegis_active_sessions", 2, map[string]string{"region": "us-east-1", "status": "active"})
+	r.DeleteGauge("aegis_active_sessions", map[string]string{"region": "us-east-1", "status": "active"})
+	r.SetGauge("aegis_active_sessions", 1, map[string]string{"region": "eu-west-1", "status": "active"})
+
+	out := r.Render()
+	if strings.Contains(out, `region="us-east-1"`) {
+		t.Fatalf("expected deleted series to be gone: %s", out)
+	}
+	if !strings.Contains(out, `aegis_active_sessions{region="eu-west-1",status="active"} 1`) {
+		t.Fatalf("expected the freed slot to take a new series: %s", out)
+	}
+}
+
+func TestSeriesLimitDropsNewLabelSets(t *testing.T) {
+	r := NewRegistry()
+	r.SetSeriesLimit(2)
+	for _, region := range []string{"us-east-1", "us-west-2", "eu-west-1"} {
+		r.ObserveHistogram("aegis_relay_provision_latency_ms", 10, map[string]string{"region": region})
+	}
+	r.ObserveHistogram("aegis_relay_provision_latency_ms", 10, map[string]string{"region": "us-east-1"})
+
+	out := r.Render()
+	if !strings.Contains(out, `aegis_relay_provision_latency_ms_count{region="us-east-1"} 2`) {
+		t.Fatalf("expected existing series to keep recording: %s", out)
+	}
+	if strings.Contains(out, `region="eu-west-1"`) {
+		t.Fatalf("expected series past the limit to be dropped: %s", out)
+	}
+	if !strings.Contains(out, `aegis_metrics_series_dropped_total{metric="aegis_relay_provision_latency_ms"} 1`) {
+		t.Fatalf("expected dropped series counter: %s", out)
+	}
+}
+
+func TestRenderIncludesBuildInfo(t *testing.T) {
+	out := NewRegistry().Render()
+	if !strings.Contains(out, `aegis_build_info{commit="dev",version="dev"} 1`) {
+		t.Fatalf("missing build info: %s", out)
+	}
+}
@@ -1,6 +1,9 @@
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type SessionStatus string
 
@@ -8,26 +11,269 @@ const (
 	SessionProvisioning SessionStatus = "provisioning"
 	SessionActive       SessionStatus = "active"
 	SessionGrace        SessionStatus = "grace"
+	SessionStopping     SessionStatus = "stopping"
 	SessionStopped      SessionStatus = "stopped"
 )
 
+// Provisioning phases of a start, in order, recorded in
+// sessions.provisioning_phase. A session's phase only moves forward.
+const (
+	ProvisioningRequested   = "requested"
+	ProvisioningLaunching   = "launching"
+	ProvisioningWaitingBoot = "waiting_boot"
+	ProvisioningReady       = "ready"
+)
+
+// ProvisioningPhases lists the phases in order.
+var ProvisioningPhases = []string{ProvisioningRequested, ProvisioningLaunching, ProvisioningWaitingBoot, ProvisioningReady}
+
+// Stop reasons, recorded in sessions.stop_reason.
+const (
+	StopReasonUser        = "user"
+	StopReasonAdmin       = "admin"
+	StopReasonStartFailed = "start_failed"
+	StopReasonErasure     = "erasure"
+	StopReasonCanary      = "canary"
+)
+
+// Client stop reasons: why a user's client says it stopped the session,
+// recorded in sessions.client_stop_reason. Its stop_reason is user.
+const (
+	ClientStopUserRequested   = "user_requested"
+	ClientStopStreamEnded     = "stream_ended"
+	ClientStopSwitchingRegion = "switching_region"
+	ClientStopOther           = "other"
+)
+
+// ClientStopReasons lists the reasons clients may give.
+var ClientStopReasons = []string{ClientStopUserRequested, ClientStopStreamEnded, ClientStopSwitchingRegion, ClientStopOther}
+
+// MaxClientStopDetailLength bounds sessions.client_stop_detail, in
+// characters.
+const MaxClientStopDetailLength = 200
+
+// Relay termination reasons, recorded in relay_instances.terminated_reason.
+// A relay stopped with its session carries the session's stop reason,
+// except after a failed start, which is compensation.
+const (
+	TerminateReasonCompensation = "compensation"
+	TerminateReasonReplaced     = "replaced"
+	TerminateReasonOrphanReaper = "orphan_reaper"
+	TerminateReasonPoolRecycle  = "pool_recycle"
+)
+
+// Relay instance purchase options, stored in relay_instances.lifecycle.
+const (
+	RelayLifecycleOnDemand = "on-demand"
+	RelayLifecycleSpot     = "spot"
+)
+
+// TerminateReasonForStop is the termination reason of a relay stopped with
+// its session.
+func TerminateReasonForStop(stopReason string) string {
+	if stopReason == StopReasonStartFailed {
+		return TerminateReasonCompensation
+	}
+	return stopReason
+}
+
+// Session tag limits; see NormalizeSessionTags.
+const (
+	MaxSessionTags      = 5
+	MaxSessionTagLength = 32
+)
+
+// CanaryUserID is the reserved user the jobs worker's canary runs its
+// synthetic sessions as. Its sessions are flagged canary and left out of
+// usage.
+const CanaryUserID = "usr_canary"
+
 type Session struct {
 	ID                 string
 	UserID             string
 	RelayInstanceID    *string
 	RelayAWSInstanceID string
+	RelayLifecycle     string
 	Status             SessionStatus
 	Region             string
 	PairToken          string
 	RelayWSToken       string
 	PublicIP           string
+	PublicIPv6         string
+	// SRTPorts are the relay's SRT listeners; SRTPort is the first.
 	SRTPort            int
+	SRTPorts           []int
 	WSURL              string
 	StartedAt          time.Time
 	StoppedAt          *time.Time
 	DurationSeconds    int
 	GraceWindowSeconds int
 	MaxSessionSeconds  int
+	// GraceStartedAt is set while the session is in grace.
+	GraceStartedAt *time.Time
+	// LastHealthAt is the relay's latest heartbeat, nil before its first.
+	LastHealthAt *time.Time
+	// ProvisioningPhase is how far the session's start got, "" for
+	// sessions started before phases were recorded.
+	ProvisioningPhase string
+	// Tags are the user's labels for the session, normalized by
+	// NormalizeSessionTags.
+	Tags []string
+	// Billable is false for sessions left out of usage, such as canaries.
+	// Only loaded on creation and for admin listings.
+	Billable bool
+	// RelaySubnetID and RelayAvailabilityZone are only loaded for admin
+	// listings.
+	RelaySubnetID         string
+	RelayAvailabilityZone string
+	// Replayed is set on the session StartOrGetSession returns for a start
+	// repeating an earlier one's idempotency key. It is not stored.
+	Replayed bool `json:"-"`
+}
+
+// RelayTermination is a pending entry in the relay_terminations outbox.
+type RelayTermination struct {
+	ID            int64
+	SessionID     string
+	UserID        string
+	Region        string
+	AWSInstanceID string
+	Attempts      int
+	// EIPAllocationID is the relay's static IP, if it had one.
+	EIPAllocationID string
+	// SecurityGroupID is the relay's per-session security group, if any.
+	SecurityGroupID string
+	// Provider is the backend that launched the relay; empty routes by
+	// region.
+	Provider string
+	// Reason is why the relay is terminated; empty for entries queued
+	// before reasons were recorded.
+	Reason string
+}
+
+// TerminatingRelay is a relay whose termination was issued but not yet
+// confirmed by the provider.
+type TerminatingRelay struct {
+	RelayInstanceID      string
+	SessionID            string
+	Region               string
+	AWSInstanceID        string
+	TerminateRequestedAt time.Time
+	// TerminatingSince is when the relay first started terminating;
+	// TerminateRequestedAt moves with each re-issued termination.
+	TerminatingSince time.Time
+
+	Provider string
+	Reason   string
+}
+
+// SessionRelay is the database view of a session's current relay, for
+// comparison with the provider's. Relay fields are empty when the session
+// has no relay.
+type SessionRelay struct {
+	SessionID       string
+	UserID          string
+	SessionStatus   SessionStatus
+	RelayInstanceID string
+	Region          string
+	AWSInstanceID   string
+	State           string
+	PublicIP        string
+	LaunchedAt      *time.Time
+	TerminatedAt    *time.Time
+	LastHealthAt    *time.Time
+
+	Provider string
+
+	// StartedFromIP and StartedUserAgent are the client that started the
+	// session, "" when not recorded.
+	StartedFromIP    string
+	StartedUserAgent string
+}
+
+// RelayHealthEvent is one heartbeat a session's relay sent.
+type RelayHealthEvent struct {
+	ID                   int64
+	SessionID            string
+	RelayInstanceID      string
+	ObservedAt           time.Time
+	IngestActive         bool
+	EgressActive         bool
+	SessionUptimeSeconds int
+	CreatedAt            time.Time
+}
+
+// RelayCheck is a live session whose relay the replacement watchdog should
+// inspect.
+type RelayCheck struct {
+	SessionID       string
+	UserID          string
+	Region          string
+	RelayInstanceID string
+	RelayRegion     string
+	AWSInstanceID   string
+	RelayWSToken    string
+	HeartbeatStale  bool
+	// StaticIP is set when the relay holds an Elastic IP; its replacement
+	// gets one too.
+	StaticIP bool
+	// ClientIP is the streamer IP the relay's security group admits; its
+	// replacement is locked to the same address.
+	ClientIP string
+	// Provider is the backend that launched the relay.
+	Provider string
+}
+
+// RelayAccess identifies the security group that locks a live relay to the
+// streamer's IP. SecurityGroupID is empty for relays without an IP lock.
+type RelayAccess struct {
+	SessionID       string
+	RelayInstanceID string
+	Region          string
+	SecurityGroupID string
+	SRTPorts        []int
+
+	Provider string
+}
+
+// PooledRelay is a pre-launched relay instance in the warm pool. Warming
+// instances are still being stopped; available ones can be claimed.
+type PooledRelay struct {
+	AWSInstanceID string
+	Region        string
+	AMIID         string
+	InstanceType  string
+	State         string
+	LaunchedAt    time.Time
+}
+
+// Warm pool states, stored in relay_pool.state.
+const (
+	PoolWarming   = "warming"
+	PoolAvailable = "available"
+)
+
+type SessionEvent struct {
+	ID        int64
+	SessionID string
+	UserID    string
+	Type      string
+	Payload   json.RawMessage
+	CreatedAt time.Time
+}
+
+// SessionChange is one status change of a session in the internal
+// changefeed. PreviousStatus is empty for a new session, StopReason for a
+// session that has not stopped.
+type SessionChange struct {
+	Seq            int64
+	SessionID      string
+	UserID         string
+	Region         string
+	Status         SessionStatus
+	PreviousStatus SessionStatus
+	StopReason     string
+	ChangedAt      time.Time
 }
 
 type UsageCurrent struct {
@@ -40,9 +286,295 @@ type UsageCurrent struct {
 	OverageSeconds   int
 }
 
+// UsageExportRow is one usage record as exported for finance. PlanTier is the
+// user's current tier.
+type UsageExportRow struct {
+	UserID          string
+	PlanTier        string
+	SessionID       string
+	Region          string
+	CycleStart      time.Time
+	CycleEnd        time.Time
+	StartedAt       time.Time
+	StoppedAt       *time.Time
+	BillableSeconds int
+	OverageSeconds  int
+	UpdatedAt       time.Time
+	// EstimatedCostUSD is the session's estimated relay cost and
+	// CycleEstimatedCostUSD that of all the user's records in the cycle;
+	// nil when the session has no estimate.
+	EstimatedCostUSD      *float64
+	CycleEstimatedCostUSD *float64
+}
+
+// UsageAlert records a user crossing a usage threshold in a cycle. SessionID
+// is the live session notified, if any.
+type UsageAlert struct {
+	UserID           string
+	SessionID        string
+	CycleStart       time.Time
+	ThresholdPercent int
+	ConsumedSeconds  int
+	IncludedSeconds  int
+}
+
+// Webhook event types.
+const (
+	WebhookSessionStarted   = "session_started"
+	WebhookSessionActivated = "session_activated"
+	WebhookSessionStopped   = "session_stopped"
+	WebhookUsageThreshold   = "usage_threshold_crossed"
+	// WebhookCanaryFailed reaches global webhooks only.
+	WebhookCanaryFailed = "canary_failed"
+)
+
+// WebhookEvents lists the event types webhooks can subscribe to.
+var WebhookEvents = []string{WebhookSessionStarted, WebhookSessionActivated, WebhookSessionStopped, WebhookUsageThreshold, WebhookCanaryFailed}
+
+// CanaryFailure is a failed canary run. SessionID is empty when the start
+// failed before the session was recorded.
+type CanaryFailure struct {
+	Region    string
+	SessionID string
+	// Outcome is the step that failed: start_failed, verify_failed or
+	// stop_failed.
+	Outcome  string
+	Error    string
+	FailedAt time.Time
+}
+
+// Webhook is an endpoint events are pushed to. UserID is empty for a global
+// webhook, which receives every user's events. Empty Events subscribes to
+// all of them.
+type Webhook struct {
+	ID        string
+	UserID    string
+	URL       string
+	Secret    string
+	Events    []string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Webhook delivery statuses.
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryDead      = "dead"
+)
+
+type WebhookDelivery struct {
+	ID        int64
+	WebhookID string
+	// Global is set for deliveries to a global webhook.
+	Global         bool
+	URL            string
+	Secret         string
+	EventType      string
+	Payload        json.RawMessage
+	Status         string
+	Attempts       int
+	LastStatusCode int
+	LastError      string
+	NextAttemptAt  time.Time
+	CreatedAt      time.Time
+}
+
+// Job run request statuses.
+const (
+	JobRunPending   = "pending"
+	JobRunRunning   = "running"
+	JobRunSucceeded = "succeeded"
+	JobRunFailed    = "failed"
+)
+
+// JobRun is an operator's request for the jobs worker to run a job now.
+type JobRun struct {
+	ID          int64
+	Job         string
+	RequestedBy string
+	Status      string
+	Error       string
+	RequestedAt time.Time
+	StartedAt   *time.Time
+	FinishedAt  *time.Time
+}
+
+// UserDataErasure is the audit record of one erased user. Their retained
+// history belongs to TombstoneUserID; Rows counts the rows touched per
+// table.
+type UserDataErasure struct {
+	TombstoneUserID string
+	RequestedBy     string
+	Rows            map[string]int
+	ErasedAt        time.Time
+}
+
+// Billing export statuses. Failed exports exhausted their attempts and stay
+// parked until an operator requeues them.
+const (
+	BillingExportPending  = "pending"
+	BillingExportReported = "reported"
+	BillingExportFailed   = "failed"
+)
+
+// BillingExport is one user's cycle overage as reported to Stripe.
+type BillingExport struct {
+	UserID              string
+	CycleStart          time.Time
+	CycleEnd            time.Time
+	SubscriptionItemID  string
+	OverageSeconds      int
+	Status              string
+	Attempts            int
+	LastError           string
+	StripeUsageRecordID string
+	ReportedAt          *time.Time
+	NextAttemptAt       time.Time
+	UpdatedAt           time.Time
+}
+
 type RelayManifestEntry struct {
 	Region              string
 	AMIID               string
 	DefaultInstanceType string
+	Available           bool
 	UpdatedAt           time.Time
+
+	// Provider is the backend relays in the region run on; AMIID is that
+	// provider's image identifier.
+	Provider string
+	// Enabled is false while operators have switched off new sessions in
+	// the region (relay_manifests.enabled). Manifest rewrites from config
+	// leave it as it is.
+	Enabled bool
+}
+
+// Provision attempt outcomes and compensations, recorded in
+// provision_attempts.
+const (
+	ProvisionAttemptSucceeded = "succeeded"
+	ProvisionAttemptFailed    = "failed"
+
+	// CompensationSessionStopped means no relay was left to clean up and the
+	// session was stopped; CompensationTerminationQueued means the launched
+	// relay was queued for termination. CompensationFailed means neither
+	// could be recorded and the instance may have leaked.
+	CompensationSessionStopped    = "session_stopped"
+	CompensationTerminationQueued = "termination_queued"
+	CompensationFailed            = "failed"
+)
+
+// ProvisionAttempt is one launch target tried while starting a session's
+// relay.
+type ProvisionAttempt struct {
+	ID           int64
+	SessionID    string
+	Provider     string
+	Region       string
+	InstanceType string
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	Outcome      string
+	AWSErrorCode string
+	Error        string
+	InstanceID   string
+	// Compensation is set on the session's last attempt when the start
+	// failed after it.
+	Compensation string
+}
+
+// ProvisionStats summarize the launch attempts started in a window.
+// P95Latency is zero when there were none.
+type ProvisionStats struct {
+	Attempts   int
+	Succeeded  int
+	P95Latency time.Duration
+}
+
+// RelayInstance is one relay_instances row, as admins list them.
+// SessionID is empty for warm pool instances not yet claimed.
+type RelayInstance struct {
+	ID            string
+	SessionID     string
+	Provider      string
+	Region        string
+	AWSInstanceID string
+	AMIID         string
+	InstanceType  string
+	Lifecycle     string
+	State         string
+	PublicIP      string
+	AgentVersion  string
+	LaunchedAt    time.Time
+	TerminatedAt  *time.Time
+	LastHealthAt  *time.Time
+}
+
+// Relay instance states, stored in relay_instances.state.
+const (
+	RelayProvisioning = "provisioning"
+	RelayRunning      = "running"
+	RelayTerminating  = "terminating"
+	RelayTerminated   = "terminated"
+	RelayError        = "error"
+)
+
+// RelayQualityDay is how the session relays of one region, AMI and
+// instance type fared on a UTC day. A health gap is a stretch without
+// heartbeats longer than the heartbeat timeout; NeverHealthy counts relays
+// launched that day that sent none at all.
+type RelayQualityDay struct {
+	Day            time.Time
+	Region         string
+	AMIID          string
+	InstanceType   string
+	Relays         int
+	RelaysWithGaps int
+	HealthGaps     int
+	GapSeconds     int64
+	NeverHealthy   int
+	UpdatedAt      time.Time
+}
+
+// RelayPrice is the hourly USD price of relays of InstanceType in Region.
+// An empty Region prices the type in every region without a price of its
+// own; the entry with neither is the default for unpriced types.
+type RelayPrice struct {
+	Region       string
+	InstanceType string
+	HourlyUSD    float64
+}
+
+// CostOverview is the estimated relay cost of live sessions and of the
+// users that cost the most in their current cycle. BurnUSDPerHour is what
+// the live sessions cost per hour at their relays' prices.
+type CostOverview struct {
+	LiveSessions         int
+	BurnUSDPerHour       float64
+	LiveEstimatedCostUSD float64
+	TopUsers             []UserCycleCost
+}
+
+// UserCycleCost is a user's estimated relay cost and billable seconds in
+// their current cycle.
+type UserCycleCost struct {
+	UserID           string
+	CycleStart       time.Time
+	CycleEnd         time.Time
+	BillableSeconds  int
+	EstimatedCostUSD float64
+}
+
+type RegionError struct {
+	Region string
+	Err    error
+}
+
+func (e *RegionError) Error() string {
+	return "region " + e.Region + ": " + e.Err.Error()
+}
+
+func (e *RegionError) Unwrap() error {
+	return e.Err
 }
@@ -0,0 +1,194 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The validators below check values bound for typed Postgres columns, so
+// that a bad value is reported against its field instead of surfacing as a
+// cast or overflow error from the database. The store assumes its callers
+// have run them.
+
+var (
+	regionPattern = regexp.MustCompile(`^[a-z]{2,}(-[a-z0-9]+)+$`)
+	amiIDPattern  = regexp.MustCompile(`^ami-[0-9a-f]+$`)
+	tagPattern    = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+)
+
+// FieldError reports the field of an input that holds an invalid value.
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return "invalid " + e.Field + ": " + e.Reason
+}
+
+// RelayActivation is what binding a launched relay to a session writes to
+// relay_instances.
+type RelayActivation struct {
+	Region          string
+	Provider        string
+	AMIID           string
+	PublicIP        string
+	PublicIPv6      string
+	AllowedClientIP string
+	SRTPorts        []int
+}
+
+// ValidateActivation checks a relay a provider reports launched. Empty
+// addresses and SRT ports are allowed; the store records them as absent.
+func ValidateActivation(in RelayActivation) error {
+	if err := ValidateRegion(in.Region); err != nil {
+		return err
+	}
+	if in.Provider == "aws" && !amiIDPattern.MatchString(in.AMIID) {
+		return &FieldError{Field: "ami_id", Reason: "not an AMI ID: " + strconv.Quote(in.AMIID)}
+	}
+	if err := validateIP("public_ip", in.PublicIP, false); err != nil {
+		return err
+	}
+	if err := validateIP("public_ipv6", in.PublicIPv6, true); err != nil {
+		return err
+	}
+	if err := validateIP("allowed_client_ip", in.AllowedClientIP, false); err != nil {
+		return err
+	}
+	return ValidateSRTPorts(in.SRTPorts)
+}
+
+// RelayHealth is the part of a relay health event stored in typed
+// relay_health_events columns.
+type RelayHealth struct {
+	SessionID            string
+	ObservedAt           time.Time
+	SessionUptimeSeconds int
+}
+
+// ValidateRelayHealth checks a health event before it is stored.
+func ValidateRelayHealth(in RelayHealth) error {
+	if in.SessionID == "" {
+		return &FieldError{Field: "session_id", Reason: "required"}
+	}
+	if in.ObservedAt.IsZero() {
+		return &FieldError{Field: "observed_at", Reason: "required"}
+	}
+	if in.SessionUptimeSeconds < 0 || in.SessionUptimeSeconds > math.MaxInt32 {
+		return &FieldError{Field: "session_uptime_seconds", Reason: "out of range"}
+	}
+	return nil
+}
+
+// ValidateManifestEntry checks a region's manifest entry.
+func ValidateManifestEntry(e RelayManifestEntry) error {
+	if err := ValidateRegion(e.Region); err != nil {
+		return err
+	}
+	return ValidateImageID(e.Provider, e.AMIID)
+}
+
+// ValidateRegion checks that region is a provider region name such as
+// us-east-1 or eu-central.
+func ValidateRegion(region string) error {
+	if !regionPattern.MatchString(region) {
+		return &FieldError{Field: "region", Reason: "not a region name: " + strconv.Quote(region)}
+	}
+	return nil
+}
+
+// ValidateImageID checks a manifest image for provider. AWS images are AMI
+// IDs or "ssm:<name>" parameters resolved at launch; other providers name
+// images their own way, so only presence is checked.
+func ValidateImageID(provider, id string) error {
+	if strings.TrimSpace(id) == "" {
+		return &FieldError{Field: "ami_id", Reason: "required"}
+	}
+	if provider != "aws" {
+		return nil
+	}
+	if name, ok := strings.CutPrefix(id, "ssm:"); ok && strings.TrimSpace(name) != "" {
+		return nil
+	}
+	if !amiIDPattern.MatchString(id) {
+		return &FieldError{Field: "ami_id", Reason: "not an AMI ID or ssm: parameter: " + strconv.Quote(id)}
+	}
+	return nil
+}
+
+// ValidateSRTPorts checks a relay's SRT ports: each 1-65535, with no
+// duplicates.
+func ValidateSRTPorts(ports []int) error {
+	seen := make(map[int]bool, len(ports))
+	for _, port := range ports {
+		if port < 1 || port > 65535 {
+			return &FieldError{Field: "srt_ports", Reason: "port out of range"}
+		}
+		if seen[port] {
+			return &FieldError{Field: "srt_ports", Reason: "duplicate port"}
+		}
+		seen[port] = true
+	}
+	return nil
+}
+
+// NormalizeSessionTags lower-cases and trims tags, drops duplicates and
+// sorts them, so that the same labels in any order or case are one set.
+// Each tag must then be 1-MaxSessionTagLength characters of a-z, 0-9, '.',
+// '_' and '-', starting with a letter or digit, and there may be at most
+// MaxSessionTags of them. Errors name the offending tag.
+func NormalizeSessionTags(tags []string) ([]string, error) {
+	out := make([]string, 0, len(tags))
+	for _, raw := range tags {
+		tag, err := NormalizeSessionTag(raw)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(out, tag) {
+			out = append(out, tag)
+		}
+	}
+	if len(out) > MaxSessionTags {
+		return nil, &FieldError{Field: "tags", Reason: fmt.Sprintf("at most %d tags are allowed, got %d", MaxSessionTags, len(out))}
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// NormalizeSessionTag is NormalizeSessionTags for one tag.
+func NormalizeSessionTag(raw string) (string, error) {
+	tag := strings.ToLower(strings.TrimSpace(raw))
+	switch {
+	case tag == "":
+		return "", &FieldError{Field: "tags", Reason: "tag " + strconv.Quote(raw) + " is empty"}
+	case len(tag) > MaxSessionTagLength:
+		return "", &FieldError{Field: "tags", Reason: fmt.Sprintf("tag %q is longer than %d characters", raw, MaxSessionTagLength)}
+	case !tagPattern.MatchString(tag):
+		return "", &FieldError{Field: "tags", Reason: "tag " + strconv.Quote(raw) + " may only hold a-z, 0-9, '.', '_' and '-', and must start with a letter or digit"}
+	}
+	return tag, nil
+}
+
+// validateIP checks an address for an inet column; "" is allowed.
+func validateIP(field, s string, v6 bool) error {
+	if s == "" {
+		return nil
+	}
+	addr, err := netip.ParseAddr(s)
+	switch {
+	case err != nil:
+		return &FieldError{Field: field, Reason: "not an IP address: " + strconv.Quote(s)}
+	case addr.Zone() != "":
+		return &FieldError{Field: field, Reason: "has a zone"}
+	case v6 && (!addr.Is6() || addr.Is4In6()):
+		return &FieldError{Field: field, Reason: "not an IPv6 address"}
+	}
+	return nil
+}
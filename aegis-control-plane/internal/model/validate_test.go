@@ -0,0 +1,138 @@
+package model
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func assertFieldError(t *testing.T, err error, field string) {
+	t.Helper()
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Field != field {
+		t.Fatalf("expected a %s field error, got %v", field, err)
+	}
+}
+
+func TestValidateActivation(t *testing.T) {
+	valid := RelayActivation{
+		Region: "us-east-1", Provider: "aws", AMIID: "ami-0123abcd", PublicIP: "203.0.113.10", PublicIPv6: "2001:db8::10",
+		AllowedClientIP: "198.51.100.23", SRTPorts: []int{9000, 9001},
+	}
+	if err := ValidateActivation(valid); err != nil {
+		t.Fatalf("expected a valid activation, got %v", err)
+	}
+	// Providers other than AWS name images their own way, and absent
+	// addresses and ports are stored as such.
+	if err := ValidateActivation(RelayActivation{Region: "eu-central", Provider: "hetzner", AMIID: "debian-12"}); err != nil {
+		t.Fatalf("expected a bare hetzner activation to be valid, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		field  string
+		mutate func(*RelayActivation)
+	}{
+		{"region", func(a *RelayActivation) { a.Region = "" }},
+		{"region", func(a *RelayActivation) { a.Region = "US-EAST-1" }},
+		{"region", func(a *RelayActivation) { a.Region = "us-east-1'; drop table sessions" }},
+		{"ami_id", func(a *RelayActivation) { a.AMIID = "" }},
+		{"ami_id", func(a *RelayActivation) { a.AMIID = "ami-XYZ" }},
+		{"ami_id", func(a *RelayActivation) { a.AMIID = "ssm:/aegis/relay/ami" }},
+		{"public_ip", func(a *RelayActivation) { a.PublicIP = "203.0.113" }},
+		{"public_ip", func(a *RelayActivation) { a.PublicIP = "203.0.113.10:9000" }},
+		{"public_ip", func(a *RelayActivation) { a.PublicIP = "fe80::1%eth0" }},
+		{"public_ipv6", func(a *RelayActivation) { a.PublicIPv6 = "203.0.113.10" }},
+		{"public_ipv6", func(a *RelayActivation) { a.PublicIPv6 = "::ffff:203.0.113.10" }},
+		{"public_ipv6", func(a *RelayActivation) { a.PublicIPv6 = "2001:db8::zz" }},
+		{"allowed_client_ip", func(a *RelayActivation) { a.AllowedClientIP = "198.51.100.0/24" }},
+		{"srt_ports", func(a *RelayActivation) { a.SRTPorts = []int{0} }},
+		{"srt_ports", func(a *RelayActivation) { a.SRTPorts = []int{9000, 70000} }},
+		{"srt_ports", func(a *RelayActivation) { a.SRTPorts = []int{9000, 9000} }},
+	} {
+		in := valid
+		tc.mutate(&in)
+		assertFieldError(t, ValidateActivation(in), tc.field)
+	}
+}
+
+func TestValidateRelayHealth(t *testing.T) {
+	valid := RelayHealth{SessionID: "ses_1", ObservedAt: time.Now(), SessionUptimeSeconds: 120}
+	if err := ValidateRelayHealth(valid); err != nil {
+		t.Fatalf("expected a valid event, got %v", err)
+	}
+	for _, tc := range []struct {
+		field  string
+		mutate func(*RelayHealth)
+	}{
+		{"session_id", func(h *RelayHealth) { h.SessionID = "" }},
+		{"observed_at", func(h *RelayHealth) { h.ObservedAt = time.Time{} }},
+		{"session_uptime_seconds", func(h *RelayHealth) { h.SessionUptimeSeconds = -1 }},
+		{"session_uptime_seconds", func(h *RelayHealth) { h.SessionUptimeSeconds = math.MaxInt32 + 1 }},
+	} {
+		in := valid
+		tc.mutate(&in)
+		assertFieldError(t, ValidateRelayHealth(in), tc.field)
+	}
+}
+
+func TestValidateManifestEntry(t *testing.T) {
+	for _, e := range []RelayManifestEntry{
+		{Region: "us-east-1", Provider: "aws", AMIID: "ami-0123abcd"},
+		{Region: "us-east-1", Provider: "aws", AMIID: "ssm:/aegis/relay/ami"},
+		{Region: "eu-central", Provider: "hetzner", AMIID: "debian-12"},
+		{Region: "us-east-1", Provider: "docker", AMIID: "telemy/relay:dev"},
+	} {
+		if err := ValidateManifestEntry(e); err != nil {
+			t.Fatalf("expected %+v to be valid, got %v", e, err)
+		}
+	}
+	for _, tc := range []struct {
+		field string
+		entry RelayManifestEntry
+	}{
+		{"region", RelayManifestEntry{Region: "useast1", Provider: "aws", AMIID: "ami-0123abcd"}},
+		{"region", RelayManifestEntry{Region: "us-east-1 ", Provider: "aws", AMIID: "ami-0123abcd"}},
+		{"ami_id", RelayManifestEntry{Region: "us-east-1", Provider: "aws", AMIID: "ami-real-1"}},
+		{"ami_id", RelayManifestEntry{Region: "us-east-1", Provider: "aws", AMIID: "ssm:"}},
+		{"ami_id", RelayManifestEntry{Region: "eu-central", Provider: "hetzner", AMIID: " "}},
+	} {
+		assertFieldError(t, ValidateManifestEntry(tc.entry), tc.field)
+	}
+}
+
+func TestNormalizeSessionTags(t *testing.T) {
+	got, err := NormalizeSessionTags([]string{" Friday-Show", "client_x.2", "FRIDAY-SHOW"})
+	if err != nil {
+		t.Fatalf("NormalizeSessionTags: %v", err)
+	}
+	if want := []string{"client_x.2", "friday-show"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if got, err := NormalizeSessionTags(nil); err != nil || len(got) != 0 {
+		t.Fatalf("expected no tags, got %v %v", got, err)
+	}
+
+	for _, tc := range []struct {
+		tags []string
+		want string
+	}{
+		{[]string{"ok", "  "}, `tag "  " is empty`},
+		{[]string{"Bad Tag"}, `tag "Bad Tag" may only hold`},
+		{[]string{"-leading"}, `tag "-leading" may only hold`},
+		{[]string{strings.Repeat("a", MaxSessionTagLength+1)}, "is longer than 32 characters"},
+		{[]string{"a", "b", "c", "d", "e", "f"}, "at most 5 tags"},
+	} {
+		_, err := NormalizeSessionTags(tc.tags)
+		assertFieldError(t, err, "tags")
+		if !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("tags %q: expected %q in %v", tc.tags, tc.want, err)
+		}
+	}
+	// Duplicates count once towards the limit.
+	if _, err := NormalizeSessionTags([]string{"a", "b", "c", "d", "e", "E"}); err != nil {
+		t.Fatalf("expected duplicates to count once, got %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+// Package obs records session lifecycle metrics. The store calls it once a
+// transition has committed, so the API and the jobs worker, which both
+// stop sessions, count them the same way.
+package obs
+
+import (
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+)
+
+// SessionStarted counts a newly created session.
+func SessionStarted(region, planTier string) {
+	metrics.Default().IncCounter("aegis_sessions_started_total", map[string]string{"region": region, "plan_tier": planTier})
+}
+
+// SessionStopped counts a session reaching stopped and records how long it
+// ran, from start to the stop request.
+func SessionStopped(region, reason string, duration time.Duration) {
+	metrics.Default().IncCounter("aegis_sessions_stopped_total", map[string]string{"region": region, "reason": reason})
+	metrics.Default().ObserveHistogram("aegis_session_duration_seconds", max(duration.Seconds(), 0), map[string]string{"region": region})
+}
+
+// SessionsPricedAtDefault counts session cost estimates made at the default
+// price because the relay's instance type has none configured.
+func SessionsPricedAtDefault(region, instanceType string, n int) {
+	metrics.Default().AddCounter("aegis_session_cost_default_price_total", uint64(n), map[string]string{"region": region, "instance_type": instanceType})
+}
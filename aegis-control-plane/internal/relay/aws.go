@@ -1,13 +1,12 @@
 package relay
 
 import (
-	"crypto/rand"
 	"context"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -15,15 +14,81 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/smithy-go"
+
 	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
 )
 
+// ec2API is the subset of the EC2 client used by AWSProvisioner, kept narrow
+// so tests can substitute a fake.
+type ec2API interface {
+	RunInstances(ctx context.Context, in *ec2.RunInstancesInput, optFns ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error)
+	DescribeInstances(ctx context.Context, in *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+	TerminateInstances(ctx context.Context, in *ec2.TerminateInstancesInput, optFns ...func(*ec2.Options)) (*ec2.TerminateInstancesOutput, error)
+	DescribeImages(ctx context.Context, in *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error)
+	AllocateAddress(ctx context.Context, in *ec2.AllocateAddressInput, optFns ...func(*ec2.Options)) (*ec2.AllocateAddressOutput, error)
+	AssociateAddress(ctx context.Context, in *ec2.AssociateAddressInput, optFns ...func(*ec2.Options)) (*ec2.AssociateAddressOutput, error)
+	DescribeAddresses(ctx context.Context, in *ec2.DescribeAddressesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeAddressesOutput, error)
+	DisassociateAddress(ctx context.Context, in *ec2.DisassociateAddressInput, optFns ...func(*ec2.Options)) (*ec2.DisassociateAddressOutput, error)
+	ReleaseAddress(ctx context.Context, in *ec2.ReleaseAddressInput, optFns ...func(*ec2.Options)) (*ec2.ReleaseAddressOutput, error)
+	DescribeSubnets(ctx context.Context, in *ec2.DescribeSubnetsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error)
+	CreateSecurityGroup(ctx context.Context, in *ec2.CreateSecurityGroupInput, optFns ...func(*ec2.Options)) (*ec2.CreateSecurityGroupOutput, error)
+	DescribeSecurityGroups(ctx context.Context, in *ec2.DescribeSecurityGroupsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupsOutput, error)
+	AuthorizeSecurityGroupIngress(ctx context.Context, in *ec2.AuthorizeSecurityGroupIngressInput, optFns ...func(*ec2.Options)) (*ec2.AuthorizeSecurityGroupIngressOutput, error)
+	RevokeSecurityGroupIngress(ctx context.Context, in *ec2.RevokeSecurityGroupIngressInput, optFns ...func(*ec2.Options)) (*ec2.RevokeSecurityGroupIngressOutput, error)
+	DeleteSecurityGroup(ctx context.Context, in *ec2.DeleteSecurityGroupInput, optFns ...func(*ec2.Options)) (*ec2.DeleteSecurityGroupOutput, error)
+	StartInstances(ctx context.Context, in *ec2.StartInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StartInstancesOutput, error)
+	StopInstances(ctx context.Context, in *ec2.StopInstancesInput, optFns ...func(*ec2.Options)) (*ec2.StopInstancesOutput, error)
+	ModifyInstanceAttribute(ctx context.Context, in *ec2.ModifyInstanceAttributeInput, optFns ...func(*ec2.Options)) (*ec2.ModifyInstanceAttributeOutput, error)
+	CreateTags(ctx context.Context, in *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error)
+	DeleteTags(ctx context.Context, in *ec2.DeleteTagsInput, optFns ...func(*ec2.Options)) (*ec2.DeleteTagsOutput, error)
+}
+
 type AWSProvisioner struct {
+	settings   atomic.Pointer[awsSettings]
+	verifyAMIs bool
+	newClient  func(ctx context.Context, region string) (ec2API, error)
+	// wsTemplate renders relay URLs; with dnsZoneID set, their host names
+	// are published in that Route53 zone.
+	wsTemplate   WSTemplate
+	dnsZoneID    string
+	newDNSClient func(ctx context.Context) (dnsAPI, error)
+	// newSSMClient and amis resolve "ssm:" AMI map values.
+	newSSMClient func(ctx context.Context, region string) (ssmAPI, error)
+	amis         amiCache
+	// subnetCursor rotates launches across a region's subnets.
+	subnetCursor atomic.Uint64
+	// pool is the warm pool store; nil disables the pool.
+	pool WarmPool
+	// breakers and retry guard this provisioner's EC2 calls; the options'
+	// thresholds, policies and budget apply to them alone.
+	breakers *breakerSet
+	retry    *retryer
+}
+
+// awsSettings holds the launch parameters that can be swapped at runtime via
+// Reconfigure.
+type awsSettings struct {
 	amiByRegion   map[string]string
 	instanceType  string
 	subnetID      string
 	securityGroup []string
 	keyName       string
+	profileARN    string
+	waitTimeout   time.Duration
+	pollInterval  time.Duration
+
+	fallbackTypes   map[string][]string
+	fallbackRegions []string
+	useSpot         bool
+	eipPool         map[string][]string
+	subnets         map[string][]string
+	sessionGroups   bool
+
+	warmPoolSize   map[string]int
+	warmPoolMaxAge time.Duration
+
+	terminateVerifyTimeout time.Duration
 }
 
 type AWSProvisionerOptions struct {
@@ -32,42 +97,491 @@ type AWSProvisionerOptions struct {
 	SubnetID      string
 	SecurityGroup []string
 	KeyName       string
+	VerifyAMIs    bool
+
+	// InstanceProfileARN attaches an IAM instance profile to launched relays.
+	InstanceProfileARN string
+
+	// ProvisionWaitTimeout bounds how long Provision waits for a launched
+	// instance to reach running; ProvisionPollInterval is the delay between
+	// DescribeInstances polls while waiting.
+	ProvisionWaitTimeout  time.Duration
+	ProvisionPollInterval time.Duration
+
+	// FallbackInstanceTypes lists, per region, instance types to try in order
+	// when the primary type has no capacity. FallbackRegions are tried after
+	// the requested region's types are exhausted.
+	FallbackInstanceTypes map[string][]string
+	FallbackRegions       []string
+
+	// UseSpot launches one-time spot instances, falling back to on-demand
+	// when spot capacity or pricing rejects the request.
+	UseSpot bool
+
+	// SubnetsByRegion spreads launches round-robin across several subnets
+	// (typically one per AZ). Regions without an entry use SubnetID.
+	SubnetsByRegion map[string][]string
+
+	// SessionSecurityGroups creates a security group per relay that only
+	// admits the SRT/WS ports from the requesting client's IP. It replaces
+	// SecurityGroup on those relays.
+	SessionSecurityGroups bool
+
+	// EIPPool lists, per region, operator-owned Elastic IP allocation IDs
+	// handed to relays that request a static IP. Regions without a pool
+	// allocate (and later release) a fresh address per relay.
+	EIPPool map[string][]string
+
+	// WarmPoolSize is the number of stopped, pre-launched instances kept per
+	// region (see SetWarmPool). Pool instances older than WarmPoolMaxAge are
+	// recycled.
+	WarmPoolSize   map[string]int
+	WarmPoolMaxAge time.Duration
+
+	// TerminateVerifyTimeout makes Deprovision wait up to this long for a
+	// terminated instance to reach the terminated state. Zero skips the wait
+	// and leaves confirmation to the caller (see ErrTerminationPending).
+	TerminateVerifyTimeout time.Duration
+
+	// BreakerFailureThreshold consecutive failed calls of one EC2 operation
+	// in a region open its circuit for BreakerCooldown, during which calls
+	// fail fast with ErrProviderUnavailable. Zero values use 5 and 30s.
+	BreakerFailureThreshold int
+	BreakerCooldown         time.Duration
+
+	// RetryPolicies bounds retries of transient EC2 errors per operation
+	// (e.g. "run_instances"); the "default" entry applies to the others.
+	// Unset fields use DefaultRetryPolicy.
+	RetryPolicies map[string]RetryPolicy
+	// RetryBudget caps retries across all operations per minute; zero uses
+	// 100 and a negative value disables the cap.
+	RetryBudget int
+
+	// WSTemplate renders the relays' telemetry URLs. When DNSZoneID is set,
+	// each relay's host name is published there as an A/AAAA record while
+	// it runs; the template must then name relays by instance or session.
+	// Both are fixed at construction: Reconfigure keeps the first values so
+	// running relays' records can still be found.
+	WSTemplate WSTemplate
+	DNSZoneID  string
 }
 
 func NewAWSProvisioner(opts AWSProvisionerOptions) (*AWSProvisioner, error) {
+	if opts.DNSZoneID != "" && !opts.WSTemplate.NamesRelays() {
+		return nil, fmt.Errorf("relay ws template %s must name relays by instance or session ID to publish DNS records", opts.WSTemplate)
+	}
+	p := &AWSProvisioner{
+		verifyAMIs:   opts.VerifyAMIs,
+		newClient:    newEC2Client,
+		newSSMClient: newSSMClient,
+		wsTemplate:   opts.WSTemplate,
+		dnsZoneID:    strings.TrimSpace(opts.DNSZoneID),
+		newDNSClient: newRoute53Client,
+		breakers:     newBreakerSet(opts.BreakerFailureThreshold, opts.BreakerCooldown),
+		retry:        newRetryer(opts.RetryPolicies, opts.RetryBudget),
+	}
+	if err := p.Reconfigure(opts); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reconfigure replaces the launch settings used by subsequent Provision
+// calls. In-flight calls keep the settings they started with.
+func (p *AWSProvisioner) Reconfigure(opts AWSProvisionerOptions) error {
 	if len(opts.AMIByRegion) == 0 {
-		return nil, fmt.Errorf("AMIByRegion is required")
+		return fmt.Errorf("AMIByRegion is required")
 	}
 	instanceType := strings.TrimSpace(opts.InstanceType)
 	if instanceType == "" {
 		instanceType = "t4g.small"
 	}
-	return &AWSProvisioner{
+	waitTimeout := opts.ProvisionWaitTimeout
+	if waitTimeout <= 0 {
+		waitTimeout = 2 * time.Minute
+	}
+	pollInterval := opts.ProvisionPollInterval
+	if pollInterval <= 0 {
+		pollInterval = 15 * time.Second
+	}
+	if pollInterval > waitTimeout {
+		return fmt.Errorf("ProvisionPollInterval %s exceeds ProvisionWaitTimeout %s", pollInterval, waitTimeout)
+	}
+	warmPoolMaxAge := opts.WarmPoolMaxAge
+	if warmPoolMaxAge <= 0 {
+		warmPoolMaxAge = 24 * time.Hour
+	}
+	p.settings.Store(&awsSettings{
 		amiByRegion:   opts.AMIByRegion,
 		instanceType:  instanceType,
 		subnetID:      strings.TrimSpace(opts.SubnetID),
 		securityGroup: opts.SecurityGroup,
 		keyName:       strings.TrimSpace(opts.KeyName),
-	}, nil
+		profileARN:    strings.TrimSpace(opts.InstanceProfileARN),
+		waitTimeout:   waitTimeout,
+		pollInterval:  pollInterval,
+
+		fallbackTypes:   opts.FallbackInstanceTypes,
+		fallbackRegions: opts.FallbackRegions,
+		useSpot:         opts.UseSpot,
+		eipPool:         opts.EIPPool,
+		subnets:         opts.SubnetsByRegion,
+		sessionGroups:   opts.SessionSecurityGroups,
+
+		warmPoolSize:   opts.WarmPoolSize,
+		warmPoolMaxAge: warmPoolMaxAge,
+
+		terminateVerifyTimeout: opts.TerminateVerifyTimeout,
+	})
+	p.breakers.configure(opts.BreakerFailureThreshold, opts.BreakerCooldown)
+	p.retry.configure(opts.RetryPolicies, opts.RetryBudget)
+	return nil
+}
+
+func (p *AWSProvisioner) current() *awsSettings {
+	return p.settings.Load()
+}
+
+func newEC2Client(ctx context.Context, region string) (ec2API, error) {
+	cfg, err := awscfg.LoadDefaultConfig(ctx, awscfg.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("aws config: %w", err)
+	}
+	return ec2.NewFromConfig(cfg), nil
+}
+
+// ValidateConfig confirms that the configured AMI for each region exists and
+// is available. Regions without an AMI are skipped here; config.Validate
+// already reports them.
+func (p *AWSProvisioner) ValidateConfig(ctx context.Context, regions []string) []error {
+	return p.validateAMIs(ctx, p.current().amiByRegion, regions)
+}
+
+// ValidateOptions checks the AMIs of opts like ValidateConfig, so a reload
+// can be refused before Reconfigure swaps them in.
+func (p *AWSProvisioner) ValidateOptions(ctx context.Context, opts AWSProvisionerOptions, regions []string) []error {
+	return p.validateAMIs(ctx, opts.AMIByRegion, regions)
+}
+
+func (p *AWSProvisioner) validateAMIs(ctx context.Context, amiByRegion map[string]string, regions []string) []error {
+	if !p.verifyAMIs {
+		return nil
+	}
+	var problems []error
+	for _, region := range regions {
+		amiID := strings.TrimSpace(amiByRegion[region])
+		if amiID == "" {
+			continue
+		}
+		amiID, err := p.ResolveAMI(ctx, region, amiID)
+		if err != nil {
+			problems = append(problems, &model.RegionError{Region: region, Err: err})
+			continue
+		}
+		if err := p.verifyAMI(ctx, region, amiID); err != nil {
+			problems = append(problems, &model.RegionError{Region: region, Err: err})
+		}
+	}
+	return problems
+}
+
+func (p *AWSProvisioner) verifyAMI(ctx context.Context, region, amiID string) error {
+	client, err := p.newClient(ctx, region)
+	if err != nil {
+		return err
+	}
+	var out *ec2.DescribeImagesOutput
+	err = p.retryAWS(ctx, "describe_images", region, func(callCtx context.Context) error {
+		var descErr error
+		out, descErr = client.DescribeImages(callCtx, &ec2.DescribeImagesInput{ImageIds: []string{amiID}})
+		return descErr
+	})
+	if err != nil {
+		return fmt.Errorf("describe image %s: %w", amiID, err)
+	}
+	if len(out.Images) == 0 {
+		return fmt.Errorf("image %s not found", amiID)
+	}
+	if state := out.Images[0].State; state != ec2types.ImageStateAvailable {
+		return fmt.Errorf("image %s is %s, not available", amiID, state)
+	}
+	return nil
+}
+
+// launchTarget is one region/instance-type combination Provision may try.
+type launchTarget struct {
+	region       string
+	amiID        string
+	instanceType string
+	// subnetID and ipv6 are chosen per launch attempt in provisionTarget.
+	subnetID string
+	ipv6     bool
+	// securityGroups overrides the configured groups (session group).
+	securityGroups []string
+}
+
+func (t launchTarget) String() string {
+	return t.region + "/" + t.instanceType
+}
+
+// launchTargets orders the combinations to try: the requested region with the
+// primary (or requested) then alternate instance types, followed by each
+// fallback region that has an AMI configured.
+func (s *awsSettings) launchTargets(region, instanceType string) []launchTarget {
+	primary := s.instanceType
+	if it := strings.TrimSpace(instanceType); it != "" {
+		primary = it
+	}
+	var out []launchTarget
+	seenRegion := make(map[string]bool)
+	for _, r := range append([]string{region}, s.fallbackRegions...) {
+		if seenRegion[r] {
+			continue
+		}
+		seenRegion[r] = true
+		amiID := strings.TrimSpace(s.amiByRegion[r])
+		if amiID == "" {
+			continue
+		}
+		seenType := make(map[string]bool)
+		for _, it := range append([]string{primary}, s.fallbackTypes[r]...) {
+			if it == "" || seenType[it] {
+				continue
+			}
+			seenType[it] = true
+			out = append(out, launchTarget{region: r, amiID: amiID, instanceType: it})
+		}
+	}
+	return out
 }
 
 func (p *AWSProvisioner) Provision(ctx context.Context, req ProvisionRequest) (ProvisionResult, error) {
-	amiID, ok := p.amiByRegion[req.Region]
-	if !ok || strings.TrimSpace(amiID) == "" {
-		return ProvisionResult{}, fmt.Errorf("no AMI configured for region %s", req.Region)
+	settings := p.current()
+	if strings.TrimSpace(settings.amiByRegion[req.Region]) == "" {
+		return ProvisionResult{}, fmt.Errorf("%w: no AMI configured for region %s", ErrImageUnavailable, req.Region)
+	}
+
+	targets := settings.launchTargets(req.Region, req.InstanceType)
+	var lastErr error
+	for i, target := range targets {
+		attemptStart := time.Now()
+		res, err := p.provisionTarget(ctx, settings, req, target)
+		attempt := ProvisionAttempt{Region: target.region, InstanceType: target.instanceType, StartedAt: attemptStart, FinishedAt: time.Now(), InstanceID: res.AWSInstanceID, Err: err}
+		if res.InstanceType != "" {
+			attempt.InstanceType = res.InstanceType
+		}
+		req.reportAttempt(attempt)
+		if err == nil {
+			return res, nil
+		}
+		if !isCapacityError(err) && !errors.Is(err, ErrProviderUnavailable) {
+			return ProvisionResult{}, classifyProvisionError(err)
+		}
+		lastErr = err
+		if i+1 < len(targets) && errors.Is(err, ErrProviderUnavailable) {
+			log.Printf("event=aws_unavailable_fallback session_id=%s from=%s to=%s err=%q", req.SessionID, target, targets[i+1], err.Error())
+			continue
+		}
+		if i+1 < len(targets) {
+			next := targets[i+1]
+			log.Printf("event=aws_capacity_fallback session_id=%s from=%s to=%s err=%q", req.SessionID, target, next, err.Error())
+			metrics.Default().IncCounter("aegis_relay_capacity_fallback_total", map[string]string{
+				"from": target.String(),
+				"to":   next.String(),
+			})
+		}
+	}
+	if isCapacityError(lastErr) {
+		return ProvisionResult{}, fmt.Errorf("%w: %w", ErrRegionUnavailable, classifyProvisionError(lastErr))
+	}
+	return ProvisionResult{}, lastErr
+}
+
+func (p *AWSProvisioner) provisionTarget(ctx context.Context, settings *awsSettings, req ProvisionRequest, target launchTarget) (ProvisionResult, error) {
+	client, err := p.newClient(ctx, target.region)
+	if err != nil {
+		return ProvisionResult{}, err
+	}
+	// Metrics, logs, and cleanup below refer to the region actually used.
+	req.Region = target.region
+	target.amiID, err = p.ResolveAMI(ctx, target.region, target.amiID)
+	if err != nil {
+		return ProvisionResult{}, err
+	}
+	if res, ok := p.adoptSessionInstance(ctx, client, settings, req, target); ok {
+		return res, nil
+	}
+	if res, ok := p.provisionFromPool(ctx, client, settings, req, target); ok {
+		return res, nil
+	}
+	userData, err := renderUserData(req)
+	if err != nil {
+		return ProvisionResult{}, fmt.Errorf("render user data: %w", err)
+	}
+
+	// A capacity error in one subnet (AZ) is retried in the region's next
+	// subnet before Provision moves on to other instance types or regions.
+	var instanceID, lifecycle, groupID string
+	ok := false
+	defer func() {
+		if !ok && groupID != "" {
+			p.cleanupSessionGroup(ctx, client, req, groupID)
+		}
+	}()
+	subnets := p.subnetOrder(settings, target.region)
+	for i, subnetID := range subnets {
+		var subnet *ec2types.Subnet
+		if subnetID != "" {
+			subnet = p.describeSubnet(ctx, client, req, subnetID)
+		}
+		target.subnetID = subnetID
+		target.ipv6 = subnetHasIPv6(subnet)
+		if settings.sessionGroups && req.ClientIP != "" && groupID == "" {
+			if subnetID != "" && subnet == nil {
+				return ProvisionResult{}, fmt.Errorf("describe subnet %s: lookup failed", subnetID)
+			}
+			vpcID := ""
+			if subnet != nil {
+				vpcID = aws.ToString(subnet.VpcId)
+			}
+			groupID, err = p.createSessionGroup(ctx, client, req, vpcID)
+			if err != nil {
+				return ProvisionResult{}, err
+			}
+			target.securityGroups = []string{groupID}
+		}
+		instanceID, lifecycle, err = p.launch(ctx, client, settings, req, target, userData)
+		if err == nil {
+			break
+		}
+		if !isCapacityError(err) || i+1 == len(subnets) {
+			return ProvisionResult{}, err
+		}
+		log.Printf("event=aws_subnet_capacity_retry region=%s session_id=%s from=%s to=%s err=%q", req.Region, req.SessionID, subnetID, subnets[i+1], err.Error())
+		metrics.Default().IncCounter("aegis_relay_subnet_capacity_retry_total", map[string]string{"region": req.Region})
+	}
+
+	res, err := p.finishLaunch(ctx, client, settings, req, target, instanceID, lifecycle, groupID)
+	if err != nil {
+		return ProvisionResult{}, err
+	}
+	ok = true
+	return res, nil
+}
+
+// finishLaunch waits for a launched (or started) instance and builds the
+// result. From here on the instance exists; any failure terminates it so the
+// caller never has to clean up after a failed Provision.
+func (p *AWSProvisioner) finishLaunch(ctx context.Context, client ec2API, settings *awsSettings, req ProvisionRequest, target launchTarget, instanceID, lifecycle, groupID string) (ProvisionResult, error) {
+	if err := p.waitRunning(ctx, client, settings, req, target.instanceType, instanceID); err != nil {
+		p.terminateLaunched(ctx, client, req, instanceID)
+		return ProvisionResult{}, fmt.Errorf("wait running: %w", err)
+	}
+
+	descStart := time.Now()
+	descOut, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{instanceID}})
+	observeProvisionPhase("describe", req, target.instanceType, descStart, err)
+	if err != nil {
+		p.terminateLaunched(ctx, client, req, instanceID)
+		return ProvisionResult{}, fmt.Errorf("describe instances: %w", err)
+	}
+
+	publicIP := extractPublicIP(descOut)
+	publicIPv6 := extractPublicIPv6(descOut)
+	if publicIP == "" && publicIPv6 == "" {
+		p.terminateLaunched(ctx, client, req, instanceID)
+		return ProvisionResult{}, fmt.Errorf("instance %s has no public ip", instanceID)
+	}
+
+	var allocationID string
+	if req.StaticIP {
+		publicIP, allocationID, err = p.attachStaticIP(ctx, client, settings, req, instanceID)
+		if err != nil {
+			p.terminateLaunched(ctx, client, req, instanceID)
+			return ProvisionResult{}, err
+		}
+	}
+
+	wsHost := publicIP
+	if wsHost == "" {
+		wsHost = publicIPv6
+	}
+	srtPorts := req.srtPorts()
+	res := ProvisionResult{
+		Region:           target.region,
+		AWSInstanceID:    instanceID,
+		AMIID:            target.amiID,
+		InstanceType:     target.instanceType,
+		Lifecycle:        lifecycle,
+		PublicIP:         publicIP,
+		PublicIPv6:       publicIPv6,
+		EIPAllocationID:  allocationID,
+		SubnetID:         target.subnetID,
+		AvailabilityZone: extractAvailabilityZone(descOut),
+		SecurityGroupID:  groupID,
+		SRTPort:          srtPorts[0],
+		SRTPorts:         srtPorts,
+		WSURL:            p.wsTemplate.Render(WSVars{PublicIP: wsHost, InstanceID: instanceID, SessionID: req.SessionID}),
 	}
+	if err := p.publishRelayDNS(ctx, req, res); err != nil {
+		p.terminateLaunched(ctx, client, req, instanceID)
+		return ProvisionResult{}, err
+	}
+	return res, nil
+}
 
-	cfg, err := awscfg.LoadDefaultConfig(ctx, awscfg.WithRegion(req.Region))
+// launch runs the instance, trying spot first when enabled and falling back
+// to on-demand when spot capacity or pricing rejects the request.
+func (p *AWSProvisioner) launch(ctx context.Context, client ec2API, settings *awsSettings, req ProvisionRequest, target launchTarget, userData string) (instanceID, lifecycle string, err error) {
+	start := time.Now()
+	defer func() { observeProvisionPhase("launch", req, target.instanceType, start, err) }()
+	if settings.useSpot {
+		instanceID, err := p.runInstance(ctx, client, settings.runInput(target, req, LifecycleSpot, userData), req)
+		switch {
+		case err == nil:
+			return instanceID, LifecycleSpot, nil
+		case isSpotCapacityError(err):
+			log.Printf("event=aws_spot_fallback region=%s session_id=%s instance_type=%s err=%q", req.Region, req.SessionID, target.instanceType, err.Error())
+			metrics.Default().IncCounter("aegis_relay_spot_fallback_total", map[string]string{"region": req.Region})
+		default:
+			return "", "", err
+		}
+	}
+	instanceID, err = p.runInstance(ctx, client, settings.runInput(target, req, LifecycleOnDemand, userData), req)
 	if err != nil {
-		return ProvisionResult{}, fmt.Errorf("aws config: %w", err)
+		return "", "", err
 	}
-	client := ec2.NewFromConfig(cfg)
+	return instanceID, LifecycleOnDemand, nil
+}
 
-	runInput := &ec2.RunInstancesInput{
-		ImageId:      aws.String(amiID),
-		InstanceType: ec2types.InstanceType(p.instanceType),
+// subnetOrder returns the region's subnets rotated so consecutive launches
+// start in different subnets. It returns [""] (the default VPC placement)
+// when no subnet is configured.
+func (p *AWSProvisioner) subnetOrder(settings *awsSettings, region string) []string {
+	subnets := settings.subnets[region]
+	if len(subnets) == 0 {
+		return []string{settings.subnetID}
+	}
+	start := int(p.subnetCursor.Add(1)-1) % len(subnets)
+	return append(append([]string{}, subnets[start:]...), subnets[:start]...)
+}
+
+func (s *awsSettings) runInput(target launchTarget, req ProvisionRequest, lifecycle, userData string) *ec2.RunInstancesInput {
+	in := &ec2.RunInstancesInput{
+		ImageId:      aws.String(target.amiID),
+		InstanceType: ec2types.InstanceType(target.instanceType),
 		MinCount:     aws.Int32(1),
 		MaxCount:     aws.Int32(1),
+		UserData:     aws.String(userData),
+		// IMDSv2 only, with a hop limit that keeps metadata (and instance
+		// credentials) out of reach of containers and SSRF on the relay.
+		MetadataOptions: &ec2types.InstanceMetadataOptionsRequest{
+			HttpEndpoint:            ec2types.InstanceMetadataEndpointStateEnabled,
+			HttpTokens:              ec2types.HttpTokensStateRequired,
+			HttpPutResponseHopLimit: aws.Int32(1),
+		},
+		// A relay that powers itself off must not linger as a stopped instance.
+		InstanceInitiatedShutdownBehavior: ec2types.ShutdownBehaviorTerminate,
 		TagSpecifications: []ec2types.TagSpecification{
 			{
 				ResourceType: ec2types.ResourceTypeInstance,
@@ -76,31 +590,62 @@ func (p *AWSProvisioner) Provision(ctx context.Context, req ProvisionRequest) (P
 					{Key: aws.String("ManagedBy"), Value: aws.String("aegis-control-plane")},
 					{Key: aws.String("AegisSessionID"), Value: aws.String(req.SessionID)},
 					{Key: aws.String("AegisUserID"), Value: aws.String(req.UserID)},
+					{Key: aws.String("AegisLifecycle"), Value: aws.String(lifecycle)},
 				},
 			},
 		},
 	}
-	if p.keyName != "" {
-		runInput.KeyName = aws.String(p.keyName)
+	if lifecycle == LifecycleSpot {
+		in.InstanceMarketOptions = &ec2types.InstanceMarketOptionsRequest{
+			MarketType: ec2types.MarketTypeSpot,
+			SpotOptions: &ec2types.SpotMarketOptions{
+				SpotInstanceType:             ec2types.SpotInstanceTypeOneTime,
+				InstanceInterruptionBehavior: ec2types.InstanceInterruptionBehaviorTerminate,
+			},
+		}
+	}
+	if s.keyName != "" {
+		in.KeyName = aws.String(s.keyName)
+	}
+	if s.profileARN != "" {
+		in.IamInstanceProfile = &ec2types.IamInstanceProfileSpecification{Arn: aws.String(s.profileARN)}
 	}
 
-	if p.subnetID != "" {
+	groups := s.securityGroup
+	if len(target.securityGroups) > 0 {
+		groups = target.securityGroups
+	}
+	if target.subnetID != "" {
 		eni := ec2types.InstanceNetworkInterfaceSpecification{
 			DeviceIndex:              aws.Int32(0),
 			AssociatePublicIpAddress: aws.Bool(true),
-			SubnetId:                 aws.String(p.subnetID),
+			SubnetId:                 aws.String(target.subnetID),
+		}
+		if len(groups) > 0 {
+			eni.Groups = groups
 		}
-		if len(p.securityGroup) > 0 {
-			eni.Groups = p.securityGroup
+		if target.ipv6 {
+			eni.Ipv6AddressCount = aws.Int32(1)
 		}
-		runInput.NetworkInterfaces = []ec2types.InstanceNetworkInterfaceSpecification{eni}
-	} else if len(p.securityGroup) > 0 {
-		runInput.SecurityGroupIds = p.securityGroup
+		in.NetworkInterfaces = []ec2types.InstanceNetworkInterfaceSpecification{eni}
+	} else if len(groups) > 0 {
+		in.SecurityGroupIds = groups
 	}
+	return in
+}
 
+func (p *AWSProvisioner) runInstance(ctx context.Context, client ec2API, runInput *ec2.RunInstancesInput, req ProvisionRequest) (string, error) {
+	// One token per launch, shared by every retry below: if a timed-out
+	// attempt was in fact accepted, AWS returns that instance instead of
+	// launching another.
+	token, err := newClientToken()
+	if err != nil {
+		return "", err
+	}
+	runInput.ClientToken = aws.String(token)
 	var runOut *ec2.RunInstancesOutput
 	runStart := time.Now()
-	err = retryAWS(ctx, "run_instances", req.Region, func(callCtx context.Context) error {
+	err = p.retryAWS(ctx, "run_instances", req.Region, func(callCtx context.Context) error {
 		var runErr error
 		runOut, runErr = client.RunInstances(callCtx, runInput)
 		return runErr
@@ -111,55 +656,130 @@ func (p *AWSProvisioner) Provision(ctx context.Context, req ProvisionRequest) (P
 		labels := map[string]string{"op": "run_instances", "region": req.Region, "status": "error"}
 		metrics.Default().IncCounter("aegis_aws_operations_total", labels)
 		metrics.Default().ObserveHistogram("aegis_aws_operation_latency_ms", runDurMS, labels)
-		return ProvisionResult{}, fmt.Errorf("run instances: %w", err)
+		return "", fmt.Errorf("run instances: %w", err)
 	}
 	labels := map[string]string{"op": "run_instances", "region": req.Region, "status": "ok"}
 	metrics.Default().IncCounter("aegis_aws_operations_total", labels)
 	metrics.Default().ObserveHistogram("aegis_aws_operation_latency_ms", runDurMS, labels)
 	if len(runOut.Instances) == 0 || runOut.Instances[0].InstanceId == nil {
-		return ProvisionResult{}, fmt.Errorf("run instances: no instance returned")
+		return "", fmt.Errorf("run instances: no instance returned")
 	}
-	instanceID := aws.ToString(runOut.Instances[0].InstanceId)
+	return aws.ToString(runOut.Instances[0].InstanceId), nil
+}
 
-	waitCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
-	defer cancel()
-	waiter := ec2.NewInstanceRunningWaiter(client)
-	if err := waiter.Wait(waitCtx, &ec2.DescribeInstancesInput{InstanceIds: []string{instanceID}}, 2*time.Minute); err != nil {
-		return ProvisionResult{}, fmt.Errorf("wait running: %w", err)
+func (p *AWSProvisioner) waitRunning(ctx context.Context, client ec2API, settings *awsSettings, req ProvisionRequest, instanceType, instanceID string) error {
+	waitStart := time.Now()
+	waiter := ec2.NewInstanceRunningWaiter(client, func(o *ec2.InstanceRunningWaiterOptions) {
+		o.MinDelay = settings.pollInterval
+		o.MaxDelay = settings.pollInterval
+	})
+	err := waiter.Wait(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{instanceID}}, settings.waitTimeout)
+	waitMS := time.Since(waitStart).Milliseconds()
+	status := "ok"
+	if err != nil {
+		status = "error"
 	}
+	log.Printf("metric=aws_instance_running_wait_ms region=%s session_id=%s instance_id=%s value=%d status=%s", req.Region, req.SessionID, instanceID, waitMS, status)
+	metrics.Default().ObserveHistogram("aegis_aws_instance_running_wait_ms", float64(waitMS), map[string]string{"region": req.Region, "status": status})
+	observeProvisionPhase("wait_running", req, instanceType, waitStart, err)
+	return err
+}
 
-	descOut, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{instanceID}})
+// observeProvisionPhase records one phase of an AWS session launch. The
+// handler records boot_probe and the end-to-end latency.
+func observeProvisionPhase(phase string, req ProvisionRequest, instanceType string, start time.Time, err error) {
+	status := "ok"
 	if err != nil {
-		return ProvisionResult{}, fmt.Errorf("describe instances: %w", err)
+		status = "error"
 	}
+	metrics.Default().ObserveHistogram("aegis_relay_provision_phase_ms", float64(time.Since(start).Milliseconds()), map[string]string{
+		"phase":         phase,
+		"provider":      "aws",
+		"region":        req.Region,
+		"instance_type": instanceType,
+		"status":        status,
+	})
+}
 
-	publicIP := extractPublicIP(descOut)
-	if publicIP == "" {
-		return ProvisionResult{}, fmt.Errorf("instance %s has no public ip", instanceID)
+// terminateLaunched cleans up an instance from a failed Provision. It uses a
+// detached context because the request context may be what just expired.
+func (p *AWSProvisioner) terminateLaunched(ctx context.Context, client ec2API, req ProvisionRequest, instanceID string) {
+	cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	err := p.retryAWS(cleanupCtx, "terminate_instances", req.Region, func(callCtx context.Context) error {
+		_, termErr := client.TerminateInstances(callCtx, &ec2.TerminateInstancesInput{InstanceIds: []string{instanceID}})
+		return termErr
+	})
+	if err != nil && !shouldIgnoreTerminateError(err) {
+		log.Printf("event=aws_provision_cleanup_failed region=%s session_id=%s instance_id=%s err=%q", req.Region, req.SessionID, instanceID, err.Error())
+		return
 	}
-
-	return ProvisionResult{
-		AWSInstanceID: instanceID,
-		AMIID:         amiID,
-		InstanceType:  p.instanceType,
-		PublicIP:      publicIP,
-		SRTPort:       9000,
-		WSURL:         fmt.Sprintf("wss://%s:7443/telemetry", publicIP),
-	}, nil
+	log.Printf("event=aws_provision_cleanup region=%s session_id=%s instance_id=%s", req.Region, req.SessionID, instanceID)
 }
 
 func (p *AWSProvisioner) Deprovision(ctx context.Context, req DeprovisionRequest) error {
 	if strings.TrimSpace(req.AWSInstanceID) == "" {
 		return nil
 	}
-	cfg, err := awscfg.LoadDefaultConfig(ctx, awscfg.WithRegion(req.Region))
+	client, err := p.newClient(ctx, req.Region)
+	if err != nil {
+		return err
+	}
+	// Static IPs are detached first, while the association still identifies
+	// them, and independently of termination so a failed terminate does not
+	// leave an address billed until the retry.
+	eipErr := p.detachStaticIP(ctx, client, req)
+	// A DNS record left behind is an error, so the termination outbox
+	// retries it like a failed terminate.
+	dnsErr := p.deleteRelayDNS(ctx, req)
+	if eipErr == nil && dnsErr == nil && p.returnToPool(ctx, client, req) {
+		return nil
+	}
+	p.tagTerminateReason(ctx, client, req)
+	confirmed, termErr := p.terminateInstance(ctx, client, req)
+	if termErr == nil && !confirmed {
+		confirmed = p.verifyTerminated(ctx, client, req)
+	}
+	var sgErr error
+	if termErr == nil && req.SecurityGroupID != "" {
+		sgErr = p.deleteSessionGroup(ctx, client, req.Region, req.SecurityGroupID)
+	}
+	if err := errors.Join(eipErr, dnsErr, termErr, sgErr); err != nil {
+		return err
+	}
+	if !confirmed {
+		return ErrTerminationPending
+	}
+	return nil
+}
+
+// tagTerminateReason tags the instance with why it is terminated, to
+// cross-check instance-hours in Cost Explorer against relay_instances. A
+// failure is only logged; it must not hold up the termination.
+func (p *AWSProvisioner) tagTerminateReason(ctx context.Context, client ec2API, req DeprovisionRequest) {
+	if req.Reason == "" {
+		return
+	}
+	err := p.observeAWS(ctx, "create_tags", req.Region, func(callCtx context.Context) error {
+		_, tagErr := client.CreateTags(callCtx, &ec2.CreateTagsInput{
+			Resources: []string{req.AWSInstanceID},
+			Tags:      []ec2types.Tag{{Key: aws.String("AegisTerminateReason"), Value: aws.String(req.Reason)}},
+		})
+		return tagErr
+	})
 	if err != nil {
-		return fmt.Errorf("aws config: %w", err)
+		log.Printf("event=aws_terminate_tag_failed region=%s session_id=%s instance_id=%s reason=%s err=%q", req.Region, req.SessionID, req.AWSInstanceID, req.Reason, err.Error())
 	}
-	client := ec2.NewFromConfig(cfg)
+}
+
+// terminateInstance reports whether the instance is confirmed gone: EC2
+// usually answers with shutting-down, which is not yet a confirmation.
+func (p *AWSProvisioner) terminateInstance(ctx context.Context, client ec2API, req DeprovisionRequest) (bool, error) {
 	termStart := time.Now()
-	err = retryAWS(ctx, "terminate_instances", req.Region, func(callCtx context.Context) error {
-		_, termErr := client.TerminateInstances(callCtx, &ec2.TerminateInstancesInput{
+	var out *ec2.TerminateInstancesOutput
+	err := p.retryAWS(ctx, "terminate_instances", req.Region, func(callCtx context.Context) error {
+		var termErr error
+		out, termErr = client.TerminateInstances(callCtx, &ec2.TerminateInstancesInput{
 			InstanceIds: []string{req.AWSInstanceID},
 		})
 		return termErr
@@ -171,94 +791,109 @@ func (p *AWSProvisioner) Deprovision(ctx context.Context, req DeprovisionRequest
 			labels := map[string]string{"op": "terminate_instances", "region": req.Region, "status": "ignored"}
 			metrics.Default().IncCounter("aegis_aws_operations_total", labels)
 			metrics.Default().ObserveHistogram("aegis_aws_operation_latency_ms", termDurMS, labels)
-			return nil
+			return awsErrorCode(err) == "InvalidInstanceID.NotFound", nil
 		}
 		labels := map[string]string{"op": "terminate_instances", "region": req.Region, "status": "error"}
 		metrics.Default().IncCounter("aegis_aws_operations_total", labels)
 		metrics.Default().ObserveHistogram("aegis_aws_operation_latency_ms", termDurMS, labels)
-		return fmt.Errorf("terminate instance: %w", err)
+		return false, fmt.Errorf("terminate instance: %w", err)
 	}
 	labels := map[string]string{"op": "terminate_instances", "region": req.Region, "status": "ok"}
 	metrics.Default().IncCounter("aegis_aws_operations_total", labels)
 	metrics.Default().ObserveHistogram("aegis_aws_operation_latency_ms", termDurMS, labels)
-	return nil
+	for _, change := range out.TerminatingInstances {
+		if aws.ToString(change.InstanceId) == req.AWSInstanceID && change.CurrentState != nil {
+			return change.CurrentState.Name == ec2types.InstanceStateNameTerminated, nil
+		}
+	}
+	return false, nil
 }
 
-func shouldIgnoreTerminateError(err error) bool {
-	var apiErr smithy.APIError
-	if !errors.As(err, &apiErr) {
+// verifyTerminated waits up to the configured TerminateVerifyTimeout for the
+// instance to reach terminated. A timeout is not an error; the termination is
+// reported as pending instead.
+func (p *AWSProvisioner) verifyTerminated(ctx context.Context, client ec2API, req DeprovisionRequest) bool {
+	timeout := p.current().terminateVerifyTimeout
+	if timeout <= 0 {
 		return false
 	}
-	code := apiErr.ErrorCode()
-	return code == "InvalidInstanceID.NotFound" || code == "IncorrectInstanceState"
+	waiter := ec2.NewInstanceTerminatedWaiter(client, func(o *ec2.InstanceTerminatedWaiterOptions) {
+		o.MinDelay = min(2*time.Second, timeout)
+		o.MaxDelay = min(5*time.Second, timeout)
+	})
+	err := waiter.Wait(ctx, &ec2.DescribeInstancesInput{InstanceIds: []string{req.AWSInstanceID}}, timeout)
+	if err != nil {
+		log.Printf("event=aws_terminate_unconfirmed region=%s session_id=%s instance_id=%s err=%q", req.Region, req.SessionID, req.AWSInstanceID, err.Error())
+		return false
+	}
+	return true
 }
 
-func retryAWS(ctx context.Context, opName, region string, fn func(context.Context) error) error {
-	const (
-		maxAttempts = 4
-		baseDelay   = 250 * time.Millisecond
-		maxDelay    = 2 * time.Second
-	)
-	var lastErr error
-	for attempt := 1; attempt <= maxAttempts; attempt++ {
-		err := fn(ctx)
-		if err == nil {
-			return nil
-		}
-		lastErr = err
-		if !isTransientAWSError(err) {
-			return err
-		}
-		if attempt == maxAttempts {
-			metrics.Default().IncCounter("aegis_aws_retry_exhausted_total", map[string]string{
-				"op":     opName,
-				"region": region,
-			})
-			return err
-		}
-		reason := awsErrorCode(err)
-		metrics.Default().IncCounter("aegis_aws_retries_total", map[string]string{
-			"op":     opName,
-			"region": region,
-			"reason": reason,
-		})
-		delay := baseDelay * time.Duration(1<<(attempt-1))
-		if delay > maxDelay {
-			delay = maxDelay
+func (p *AWSProvisioner) Status(ctx context.Context, req StatusRequest) (StatusResult, error) {
+	client, err := p.newClient(ctx, req.Region)
+	if err != nil {
+		return StatusResult{}, err
+	}
+	var out *ec2.DescribeInstancesOutput
+	err = p.retryAWS(ctx, "describe_instances", req.Region, func(callCtx context.Context) error {
+		var descErr error
+		out, descErr = client.DescribeInstances(callCtx, &ec2.DescribeInstancesInput{InstanceIds: []string{req.AWSInstanceID}})
+		return descErr
+	})
+	if err != nil {
+		if awsErrorCode(err) == "InvalidInstanceID.NotFound" {
+			return StatusResult{State: InstanceTerminated}, nil
 		}
-		delay = withJitter(delay)
-		log.Printf("event=aws_retry op=%s region=%s attempt=%d delay_ms=%d err=%q", opName, region, attempt, delay.Milliseconds(), err.Error())
-		timer := time.NewTimer(delay)
-		select {
-		case <-ctx.Done():
-			timer.Stop()
-			return ctx.Err()
-		case <-timer.C:
+		return StatusResult{}, fmt.Errorf("describe instance: %w", err)
+	}
+	for _, res := range out.Reservations {
+		for _, inst := range res.Instances {
+			if inst.State == nil {
+				continue
+			}
+			return StatusResult{
+				State:      instanceState(inst.State.Name),
+				PublicIP:   aws.ToString(inst.PublicIpAddress),
+				LaunchedAt: aws.ToTime(inst.LaunchTime),
+			}, nil
 		}
 	}
-	return lastErr
+	return StatusResult{State: InstanceTerminated}, nil
 }
 
-func withJitter(delay time.Duration) time.Duration {
-	if delay <= 0 {
-		return 0
-	}
-	floor := delay / 10
-	span := delay - floor
-	if span <= 0 {
-		return floor
+func instanceState(name ec2types.InstanceStateName) string {
+	switch name {
+	case ec2types.InstanceStateNamePending:
+		return InstancePending
+	case ec2types.InstanceStateNameRunning:
+		return InstanceRunning
+	case ec2types.InstanceStateNameStopping, ec2types.InstanceStateNameStopped:
+		return InstanceStopped
+	case ec2types.InstanceStateNameShuttingDown:
+		return InstanceShuttingDown
+	default:
+		return InstanceTerminated
 	}
-	var raw [8]byte
-	if _, err := rand.Read(raw[:]); err != nil {
-		return floor + (span / 2)
+}
+
+func shouldIgnoreTerminateError(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
 	}
-	max := uint64(span)
-	if max == 0 {
-		return floor + (span / 2)
+	code := apiErr.ErrorCode()
+	return code == "InvalidInstanceID.NotFound" || code == "IncorrectInstanceState"
+}
+
+// retryAWS runs fn behind the (op, region) circuit breaker; a call made
+// while the circuit is open fails fast with an *UnavailableError.
+func (p *AWSProvisioner) retryAWS(ctx context.Context, opName, region string, fn func(context.Context) error) error {
+	if err := p.breakers.allow(opName, region); err != nil {
+		return err
 	}
-	n := binary.LittleEndian.Uint64(raw[:]) % max
-	// Jittered delay in [10% of base, 100% of base).
-	return floor + time.Duration(n)
+	err := p.retry.do(ctx, opName, region, fn)
+	p.breakers.record(opName, region, err)
+	return err
 }
 
 func isTransientAWSError(err error) bool {
@@ -275,6 +910,7 @@ func isTransientAWSError(err error) bool {
 		"InternalError",
 		"RequestTimeout",
 		"EC2ThrottledException",
+		"PriorRequestNotComplete",
 		"InsufficientInstanceCapacity":
 		return true
 	default:
@@ -282,6 +918,19 @@ func isTransientAWSError(err error) bool {
 	}
 }
 
+func isCapacityError(err error) bool {
+	return awsErrorCode(err) == "InsufficientInstanceCapacity"
+}
+
+func isSpotCapacityError(err error) bool {
+	switch awsErrorCode(err) {
+	case "InsufficientInstanceCapacity", "SpotMaxPriceTooLow", "MaxSpotInstanceCountExceeded":
+		return true
+	default:
+		return false
+	}
+}
+
 func awsErrorCode(err error) string {
 	var apiErr smithy.APIError
 	if !errors.As(err, &apiErr) {
@@ -294,6 +943,38 @@ func awsErrorCode(err error) string {
 	return code
 }
 
+// describeSubnet returns nil when the lookup fails; the launch then proceeds
+// IPv4-only.
+func (p *AWSProvisioner) describeSubnet(ctx context.Context, client ec2API, req ProvisionRequest, subnetID string) *ec2types.Subnet {
+	var out *ec2.DescribeSubnetsOutput
+	err := p.observeAWS(ctx, "describe_subnets", req.Region, func(callCtx context.Context) error {
+		var descErr error
+		out, descErr = client.DescribeSubnets(callCtx, &ec2.DescribeSubnetsInput{SubnetIds: []string{subnetID}})
+		return descErr
+	})
+	if err != nil {
+		log.Printf("event=aws_describe_subnet_failed region=%s session_id=%s subnet_id=%s err=%q", req.Region, req.SessionID, subnetID, err.Error())
+		return nil
+	}
+	if len(out.Subnets) == 0 {
+		return nil
+	}
+	return &out.Subnets[0]
+}
+
+// subnetHasIPv6 reports whether the subnet has an associated IPv6 CIDR.
+func subnetHasIPv6(subnet *ec2types.Subnet) bool {
+	if subnet == nil {
+		return false
+	}
+	for _, assoc := range subnet.Ipv6CidrBlockAssociationSet {
+		if assoc.Ipv6CidrBlockState != nil && assoc.Ipv6CidrBlockState.State == ec2types.SubnetCidrBlockStateCodeAssociated {
+			return true
+		}
+	}
+	return false
+}
+
 func extractPublicIP(out *ec2.DescribeInstancesOutput) string {
 	for _, res := range out.Reservations {
 		for _, inst := range res.Instances {
@@ -304,3 +985,32 @@ func extractPublicIP(out *ec2.DescribeInstancesOutput) string {
 	}
 	return ""
 }
+
+func extractAvailabilityZone(out *ec2.DescribeInstancesOutput) string {
+	for _, res := range out.Reservations {
+		for _, inst := range res.Instances {
+			if inst.Placement != nil {
+				return aws.ToString(inst.Placement.AvailabilityZone)
+			}
+		}
+	}
+	return ""
+}
+
+func extractPublicIPv6(out *ec2.DescribeInstancesOutput) string {
+	for _, res := range out.Reservations {
+		for _, inst := range res.Instances {
+			if ip := strings.TrimSpace(aws.ToString(inst.Ipv6Address)); ip != "" {
+				return ip
+			}
+			for _, eni := range inst.NetworkInterfaces {
+				for _, addr := range eni.Ipv6Addresses {
+					if ip := strings.TrimSpace(aws.ToString(addr.Ipv6Address)); ip != "" {
+						return ip
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
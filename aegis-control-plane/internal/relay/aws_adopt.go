@@ -0,0 +1,91 @@
+package relay
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// adoptSessionInstance reuses a pending or running instance already tagged
+// with the session, e.g. one whose RunInstances response was lost to a
+// timeout in an earlier Provision call. Without it that instance would run
+// untracked while a second one is launched.
+func (p *AWSProvisioner) adoptSessionInstance(ctx context.Context, client ec2API, settings *awsSettings, req ProvisionRequest, target launchTarget) (ProvisionResult, bool) {
+	if req.SessionID == "" {
+		return ProvisionResult{}, false
+	}
+	inst, err := p.findSessionInstance(ctx, client, req)
+	if err != nil {
+		// The lookup is a safety net; a failure must not block the launch.
+		log.Printf("event=aws_session_instance_lookup_failed region=%s session_id=%s err=%q", req.Region, req.SessionID, err.Error())
+		return ProvisionResult{}, false
+	}
+	if inst == nil {
+		return ProvisionResult{}, false
+	}
+	instanceID := aws.ToString(inst.InstanceId)
+	target.amiID = aws.ToString(inst.ImageId)
+	target.instanceType = string(inst.InstanceType)
+	target.subnetID = aws.ToString(inst.SubnetId)
+	lifecycle := LifecycleOnDemand
+	if inst.InstanceLifecycle == ec2types.InstanceLifecycleTypeSpot {
+		lifecycle = LifecycleSpot
+	}
+	var groupID string
+	for _, g := range inst.SecurityGroups {
+		if strings.HasPrefix(aws.ToString(g.GroupName), "aegis-relay-"+req.SessionID+"-") {
+			groupID = aws.ToString(g.GroupId)
+		}
+	}
+	log.Printf("event=aws_session_instance_adopted region=%s session_id=%s instance_id=%s", req.Region, req.SessionID, instanceID)
+	res, err := p.finishLaunch(ctx, client, settings, req, target, instanceID, lifecycle, groupID)
+	if err != nil {
+		if groupID != "" {
+			p.cleanupSessionGroup(ctx, client, req, groupID)
+		}
+		log.Printf("event=aws_session_instance_adopt_failed region=%s session_id=%s instance_id=%s err=%q", req.Region, req.SessionID, instanceID, err.Error())
+		return ProvisionResult{}, false
+	}
+	return res, true
+}
+
+// findSessionInstance returns a pending or running instance tagged with the
+// session, other than the relay being replaced, or nil.
+func (p *AWSProvisioner) findSessionInstance(ctx context.Context, client ec2API, req ProvisionRequest) (*ec2types.Instance, error) {
+	var out *ec2.DescribeInstancesOutput
+	err := p.observeAWS(ctx, "describe_instances", req.Region, func(callCtx context.Context) error {
+		var descErr error
+		out, descErr = client.DescribeInstances(callCtx, &ec2.DescribeInstancesInput{
+			Filters: []ec2types.Filter{
+				{Name: aws.String("tag:AegisSessionID"), Values: []string{req.SessionID}},
+				{Name: aws.String("instance-state-name"), Values: []string{"pending", "running"}},
+			},
+		})
+		return descErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, res := range out.Reservations {
+		for i, inst := range res.Instances {
+			if id := aws.ToString(inst.InstanceId); id != "" && id != req.ReplacesInstanceID {
+				return &res.Instances[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func newClientToken() (string, error) {
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return "", err
+	}
+	return "aegis-" + hex.EncodeToString(raw[:]), nil
+}
@@ -0,0 +1,110 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// fakeCloud is an EC2 whose RunInstances honours client tokens and whose
+// responses can be lost after the launch was accepted.
+type fakeCloud struct {
+	byToken   map[string]string
+	instances []ec2types.Instance
+	// lose is the number of RunInstances responses replaced by loseErr.
+	lose    int
+	loseErr error
+}
+
+func (c *fakeCloud) client() *fakeEC2 {
+	c.byToken = make(map[string]string)
+	return &fakeEC2{
+		runInstancesFn: func(_ context.Context, in *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			id, ok := c.byToken[aws.ToString(in.ClientToken)]
+			if !ok {
+				id = fmt.Sprintf("i-%d", len(c.instances)+1)
+				c.byToken[aws.ToString(in.ClientToken)] = id
+				c.instances = append(c.instances, ec2types.Instance{
+					InstanceId:      aws.String(id),
+					PublicIpAddress: aws.String("198.51.100.80"),
+					State:           &ec2types.InstanceState{Name: ec2types.InstanceStateNameRunning},
+					Tags:            in.TagSpecifications[0].Tags,
+				})
+			}
+			if c.lose > 0 {
+				c.lose--
+				return nil, c.loseErr
+			}
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String(id)}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, in *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			for _, inst := range c.instances {
+				if aws.ToString(inst.InstanceId) == in.InstanceIds[0] {
+					return &ec2.DescribeInstancesOutput{Reservations: []ec2types.Reservation{{Instances: []ec2types.Instance{inst}}}}, nil
+				}
+			}
+			return &ec2.DescribeInstancesOutput{}, nil
+		},
+		findInstancesFn: func(_ context.Context, in *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			var sessionID string
+			for _, f := range in.Filters {
+				if aws.ToString(f.Name) == "tag:AegisSessionID" {
+					sessionID = f.Values[0]
+				}
+			}
+			var found []ec2types.Instance
+			for _, inst := range c.instances {
+				for _, tag := range inst.Tags {
+					if aws.ToString(tag.Key) == "AegisSessionID" && aws.ToString(tag.Value) == sessionID {
+						found = append(found, inst)
+					}
+				}
+			}
+			return &ec2.DescribeInstancesOutput{Reservations: []ec2types.Reservation{{Instances: found}}}, nil
+		},
+	}
+}
+
+func TestProvision_TimedOutLaunchRetriesWithSameClientToken(t *testing.T) {
+	cloud := &fakeCloud{lose: 1, loseErr: &smithy.GenericAPIError{Code: "RequestTimeout", Message: "timeout"}}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{AMIByRegion: map[string]string{"us-east-1": "ami-east"}}, cloud.client())
+
+	res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if len(cloud.instances) != 1 || res.AWSInstanceID != "i-1" {
+		t.Fatalf("expected the retried launch to return the first instance, got %s with %d instances", res.AWSInstanceID, len(cloud.instances))
+	}
+}
+
+func TestProvision_AdoptsInstanceFromLostLaunch(t *testing.T) {
+	cloud := &fakeCloud{lose: 1, loseErr: errors.New("context deadline exceeded")}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{AMIByRegion: map[string]string{"us-east-1": "ami-east"}}, cloud.client())
+
+	if _, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"}); err == nil {
+		t.Fatal("expected the first Provision to fail")
+	}
+	res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if len(cloud.instances) != 1 || res.AWSInstanceID != "i-1" || res.PublicIP != "198.51.100.80" {
+		t.Fatalf("expected the untracked instance to be adopted, got %+v with %d instances", res, len(cloud.instances))
+	}
+
+	// A replacement never adopts the relay it replaces.
+	res, err = p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1", ReplacesInstanceID: "i-1"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if res.AWSInstanceID != "i-2" {
+		t.Fatalf("expected a fresh replacement instance, got %s", res.AWSInstanceID)
+	}
+}
@@ -0,0 +1,282 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awscfg "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/smithy-go"
+)
+
+// relayDNSTTL is the TTL of relay records. Names are per instance, so a
+// short TTL only matters for negative caching before the record exists.
+const relayDNSTTL = 60
+
+// dnsRegion labels Route53 calls, which are global, in metrics and
+// breakers.
+const dnsRegion = "global"
+
+// dnsRecord is an A or AAAA record set.
+type dnsRecord struct {
+	Name   string
+	Type   string
+	TTL    int64
+	Values []string
+}
+
+// dnsAPI is the subset of Route53 used to publish relay names.
+type dnsAPI interface {
+	// ChangeRecords applies action (UPSERT or DELETE) to every record.
+	ChangeRecords(ctx context.Context, zoneID, action string, records []dnsRecord) error
+	// ListRecords returns the A and AAAA record sets named name.
+	ListRecords(ctx context.Context, zoneID, name string) ([]dnsRecord, error)
+}
+
+// relayDNSName is the record a relay's telemetry URL points at.
+func (p *AWSProvisioner) relayDNSName(instanceID, sessionID string) string {
+	return p.wsTemplate.Host(WSVars{InstanceID: instanceID, SessionID: sessionID})
+}
+
+// publishRelayDNS points the relay's DNS name at its public addresses when
+// AEGIS_RELAY_DNS_RECORDS is on.
+func (p *AWSProvisioner) publishRelayDNS(ctx context.Context, req ProvisionRequest, res ProvisionResult) error {
+	if p.dnsZoneID == "" {
+		return nil
+	}
+	name := p.relayDNSName(res.AWSInstanceID, req.SessionID)
+	var records []dnsRecord
+	if res.PublicIP != "" {
+		records = append(records, dnsRecord{Name: name, Type: "A", TTL: relayDNSTTL, Values: []string{res.PublicIP}})
+	}
+	if res.PublicIPv6 != "" {
+		records = append(records, dnsRecord{Name: name, Type: "AAAA", TTL: relayDNSTTL, Values: []string{res.PublicIPv6}})
+	}
+	client, err := p.newDNSClient(ctx)
+	if err != nil {
+		return fmt.Errorf("publish relay dns %s: %w", name, err)
+	}
+	err = p.observeAWS(ctx, "route53_change_records", dnsRegion, func(callCtx context.Context) error {
+		return client.ChangeRecords(callCtx, p.dnsZoneID, "UPSERT", records)
+	})
+	if err != nil {
+		return fmt.Errorf("publish relay dns %s: %w", name, err)
+	}
+	log.Printf("event=relay_dns_published session_id=%s instance_id=%s name=%s", req.SessionID, res.AWSInstanceID, name)
+	return nil
+}
+
+// deleteRelayDNS removes the relay's DNS records. Records already gone are
+// not an error, so a failed Deprovision can be retried.
+func (p *AWSProvisioner) deleteRelayDNS(ctx context.Context, req DeprovisionRequest) error {
+	// Relays without a session (warm pool instances) never had a name that
+	// depends on it.
+	if p.dnsZoneID == "" || (p.wsTemplate.usesSessionID() && req.SessionID == "") {
+		return nil
+	}
+	name := p.relayDNSName(req.AWSInstanceID, req.SessionID)
+	client, err := p.newDNSClient(ctx)
+	if err != nil {
+		return fmt.Errorf("delete relay dns %s: %w", name, err)
+	}
+	var records []dnsRecord
+	err = p.observeAWS(ctx, "route53_list_records", dnsRegion, func(callCtx context.Context) error {
+		var listErr error
+		records, listErr = client.ListRecords(callCtx, p.dnsZoneID, name)
+		return listErr
+	})
+	if err == nil && len(records) > 0 {
+		err = p.observeAWS(ctx, "route53_change_records", dnsRegion, func(callCtx context.Context) error {
+			return client.ChangeRecords(callCtx, p.dnsZoneID, "DELETE", records)
+		})
+		if isDNSRecordMissing(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		log.Printf("event=relay_dns_delete_failed session_id=%s instance_id=%s name=%s err=%q", req.SessionID, req.AWSInstanceID, name, err.Error())
+		return fmt.Errorf("delete relay dns %s: %w", name, err)
+	}
+	return nil
+}
+
+// isDNSRecordMissing reports whether a DELETE failed because a concurrent
+// call already removed the record.
+func isDNSRecordMissing(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidChangeBatch" && strings.Contains(apiErr.ErrorMessage(), "not found")
+}
+
+// route53Namespace is the XML namespace of the Route53 API.
+const route53Namespace = "https://route53.amazonaws.com/doc/2013-04-01/"
+
+// route53Client calls the Route53 REST API directly with SigV4-signed
+// requests.
+type route53Client struct {
+	httpClient  aws.HTTPClient
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+	endpoint    string
+}
+
+func newRoute53Client(ctx context.Context) (dnsAPI, error) {
+	cfg, err := awscfg.LoadDefaultConfig(ctx, awscfg.WithRegion("us-east-1"))
+	if err != nil {
+		return nil, fmt.Errorf("aws config: %w", err)
+	}
+	endpoint := "https://route53.amazonaws.com"
+	if cfg.BaseEndpoint != nil {
+		endpoint = strings.TrimSuffix(*cfg.BaseEndpoint, "/")
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &route53Client{
+		httpClient:  httpClient,
+		credentials: cfg.Credentials,
+		signer:      v4.NewSigner(),
+		endpoint:    endpoint,
+	}, nil
+}
+
+type route53RecordSet struct {
+	Name            string   `xml:"Name"`
+	Type            string   `xml:"Type"`
+	TTL             int64    `xml:"TTL"`
+	ResourceRecords []string `xml:"ResourceRecords>ResourceRecord>Value"`
+}
+
+type route53Change struct {
+	Action            string           `xml:"Action"`
+	ResourceRecordSet route53RecordSet `xml:"ResourceRecordSet"`
+}
+
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"ChangeResourceRecordSetsRequest"`
+	Xmlns   string          `xml:"xmlns,attr"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+func (c *route53Client) ChangeRecords(ctx context.Context, zoneID, action string, records []dnsRecord) error {
+	in := route53ChangeRequest{Xmlns: route53Namespace}
+	for _, r := range records {
+		in.Changes = append(in.Changes, route53Change{
+			Action:            action,
+			ResourceRecordSet: route53RecordSet{Name: r.Name, Type: r.Type, TTL: r.TTL, ResourceRecords: r.Values},
+		})
+	}
+	body, err := xml.Marshal(in)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, http.MethodPost, "/2013-04-01/hostedzone/"+route53ZoneID(zoneID)+"/rrset/", nil, append([]byte(xml.Header), body...))
+	return err
+}
+
+func (c *route53Client) ListRecords(ctx context.Context, zoneID, name string) ([]dnsRecord, error) {
+	query := url.Values{"name": {name}, "maxitems": {"10"}}
+	raw, err := c.do(ctx, http.MethodGet, "/2013-04-01/hostedzone/"+route53ZoneID(zoneID)+"/rrset", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		RecordSets []route53RecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+	}
+	if err := xml.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("decode ListResourceRecordSets response: %w", err)
+	}
+	// The listing starts at name and continues in order, so only the
+	// leading sets can match.
+	var records []dnsRecord
+	for _, rs := range out.RecordSets {
+		if !strings.EqualFold(strings.TrimSuffix(rs.Name, "."), strings.TrimSuffix(name, ".")) {
+			continue
+		}
+		if rs.Type == "A" || rs.Type == "AAAA" {
+			records = append(records, dnsRecord{Name: rs.Name, Type: rs.Type, TTL: rs.TTL, Values: rs.ResourceRecords})
+		}
+	}
+	return records, nil
+}
+
+func (c *route53Client) do(ctx context.Context, method, path string, query url.Values, body []byte) ([]byte, error) {
+	u := c.endpoint + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	if c.credentials == nil {
+		return nil, fmt.Errorf("no aws credentials configured")
+	}
+	creds, err := c.credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("aws credentials: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	if err := c.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), "route53", "us-east-1", time.Now()); err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, route53Error(resp.StatusCode, raw)
+	}
+	return raw, nil
+}
+
+// route53ZoneID accepts a bare hosted zone ID or the "/hostedzone/" form
+// the console and API responses use.
+func route53ZoneID(id string) string {
+	return strings.TrimPrefix(strings.TrimSpace(id), "/hostedzone/")
+}
+
+// route53Error maps a Route53 error body onto a smithy API error so
+// retryAWS classifies throttling like any other AWS call. Rejected change
+// batches have their own body, listing every problem.
+func route53Error(status int, raw []byte) error {
+	var body struct {
+		XMLName xml.Name
+		Error   struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		} `xml:"Error"`
+		Messages []string `xml:"Messages>Message"`
+	}
+	_ = xml.Unmarshal(raw, &body)
+	code, message := body.Error.Code, body.Error.Message
+	if body.XMLName.Local == "InvalidChangeBatch" {
+		code, message = "InvalidChangeBatch", strings.Join(body.Messages, "; ")
+	}
+	if code == "" && status >= 500 {
+		code = "ServiceUnavailable"
+	} else if code == "" {
+		code = http.StatusText(status)
+	}
+	return &smithy.GenericAPIError{Code: code, Message: message}
+}
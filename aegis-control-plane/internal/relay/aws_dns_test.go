@@ -0,0 +1,221 @@
+package relay
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+type fakeDNS struct {
+	records   map[string][]dnsRecord
+	changeErr error
+	changes   []string
+}
+
+func (f *fakeDNS) ChangeRecords(_ context.Context, _, action string, records []dnsRecord) error {
+	if f.changeErr != nil {
+		return f.changeErr
+	}
+	for _, r := range records {
+		f.changes = append(f.changes, action+" "+r.Type+" "+r.Name)
+		if action == "DELETE" {
+			delete(f.records, r.Name)
+		} else {
+			f.records[r.Name] = append(f.records[r.Name], r)
+		}
+	}
+	return nil
+}
+
+func (f *fakeDNS) ListRecords(_ context.Context, _, name string) ([]dnsRecord, error) {
+	return f.records[name], nil
+}
+
+func newDNSTestProvisioner(t *testing.T, client ec2API, dns *fakeDNS) *AWSProvisioner {
+	t.Helper()
+	tmpl, err := ParseWSTemplate("wss://{instance_id}.relays.example.com:7443/telemetry")
+	if err != nil {
+		t.Fatalf("ParseWSTemplate: %v", err)
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion: map[string]string{"us-east-1": "ami-east"},
+		WSTemplate:  tmpl,
+		DNSZoneID:   "Z0123",
+	}, client)
+	p.newDNSClient = func(context.Context) (dnsAPI, error) { return dns, nil }
+	return p
+}
+
+func TestProvision_PublishesRelayDNSName(t *testing.T) {
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, _ *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-dns")}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return runningInstance("i-dns", "198.51.100.30"), nil
+		},
+	}
+	dns := &fakeDNS{records: map[string][]dnsRecord{}}
+	p := newDNSTestProvisioner(t, client, dns)
+
+	res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if res.WSURL != "wss://i-dns.relays.example.com:7443/telemetry" {
+		t.Fatalf("unexpected ws url %q", res.WSURL)
+	}
+	got := dns.records["i-dns.relays.example.com"]
+	if len(got) != 1 || got[0].Type != "A" || strings.Join(got[0].Values, ",") != "198.51.100.30" {
+		t.Fatalf("expected an A record for the relay, got %+v", dns.records)
+	}
+
+	if err := p.Deprovision(context.Background(), DeprovisionRequest{SessionID: "ses_1", Region: "us-east-1", AWSInstanceID: "i-dns"}); err != nil {
+		t.Fatalf("Deprovision: %v", err)
+	}
+	if len(dns.records) != 0 || dns.changes[len(dns.changes)-1] != "DELETE A i-dns.relays.example.com" {
+		t.Fatalf("expected the record deleted, got %v", dns.changes)
+	}
+}
+
+func TestProvision_DNSFailureTerminatesInstance(t *testing.T) {
+	var terminated []string
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, _ *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-dns")}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return runningInstance("i-dns", "198.51.100.30"), nil
+		},
+		terminateInstancesFn: func(_ context.Context, in *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+			terminated = append(terminated, in.InstanceIds...)
+			return terminatedOutput(in), nil
+		},
+	}
+	dns := &fakeDNS{changeErr: &smithy.GenericAPIError{Code: "AccessDenied", Message: "denied"}}
+	p := newDNSTestProvisioner(t, client, dns)
+
+	_, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"})
+	if err == nil || !strings.Contains(err.Error(), "publish relay dns") {
+		t.Fatalf("expected a dns error, got %v", err)
+	}
+	if strings.Join(terminated, ",") != "i-dns" {
+		t.Fatalf("expected launched instance to be terminated, got %v", terminated)
+	}
+}
+
+func TestDeprovision_DNSFailureIsRetried(t *testing.T) {
+	var terminated []string
+	client := &fakeEC2{
+		terminateInstancesFn: func(_ context.Context, in *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+			terminated = append(terminated, in.InstanceIds...)
+			return terminatedOutput(in), nil
+		},
+	}
+	dns := &fakeDNS{records: map[string][]dnsRecord{
+		"i-dns.relays.example.com": {{Name: "i-dns.relays.example.com", Type: "A", TTL: relayDNSTTL, Values: []string{"198.51.100.30"}}},
+	}}
+	dns.changeErr = &smithy.GenericAPIError{Code: "AccessDenied", Message: "denied"}
+	p := newDNSTestProvisioner(t, client, dns)
+
+	req := DeprovisionRequest{SessionID: "ses_1", Region: "us-east-1", AWSInstanceID: "i-dns"}
+	err := p.Deprovision(context.Background(), req)
+	if err == nil || !strings.Contains(err.Error(), "delete relay dns i-dns.relays.example.com") {
+		t.Fatalf("expected a dns error so the termination is retried, got %v", err)
+	}
+	if strings.Join(terminated, ",") != "i-dns" {
+		t.Fatalf("expected the instance terminated anyway, got %v", terminated)
+	}
+
+	// The retry finds the record; a record already deleted is not an error.
+	dns.changeErr = nil
+	if err := p.Deprovision(context.Background(), req); err != nil || len(dns.records) != 0 {
+		t.Fatalf("expected the retry to delete the record, got %v %+v", err, dns.records)
+	}
+	if err := p.Deprovision(context.Background(), req); err != nil {
+		t.Fatalf("expected a repeat Deprovision to succeed, got %v", err)
+	}
+}
+
+func TestNewAWSProvisioner_DNSRecordsNeedPerRelayNames(t *testing.T) {
+	_, err := NewAWSProvisioner(AWSProvisionerOptions{
+		AMIByRegion: map[string]string{"us-east-1": "ami-east"},
+		DNSZoneID:   "Z0123",
+	})
+	if err == nil {
+		t.Fatal("expected the default template rejected with DNS records")
+	}
+}
+
+func TestRoute53Client_ChangeAndListRecords(t *testing.T) {
+	var changeBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), "/us-east-1/route53/aws4_request") {
+			t.Errorf("unexpected authorization %q", r.Header.Get("Authorization"))
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/2013-04-01/hostedzone/Z0123/rrset/":
+			raw, _ := io.ReadAll(r.Body)
+			changeBody = string(raw)
+			if strings.Contains(changeBody, "<Action>DELETE</Action>") {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`<InvalidChangeBatch><Messages><Message>Tried to delete resource record set [name='i-dns.relays.example.com.', type='A'] but it was not found</Message></Messages></InvalidChangeBatch>`))
+				return
+			}
+			_, _ = w.Write([]byte(`<ChangeResourceRecordSetsResponse><ChangeInfo><Id>/change/C1</Id></ChangeInfo></ChangeResourceRecordSetsResponse>`))
+		case r.Method == http.MethodGet && r.URL.Path == "/2013-04-01/hostedzone/Z0123/rrset":
+			if r.URL.Query().Get("name") != "i-dns.relays.example.com" {
+				t.Errorf("unexpected query %q", r.URL.RawQuery)
+			}
+			_, _ = w.Write([]byte(`<ListResourceRecordSetsResponse><ResourceRecordSets>
+<ResourceRecordSet><Name>i-dns.relays.example.com.</Name><Type>A</Type><TTL>60</TTL><ResourceRecords><ResourceRecord><Value>198.51.100.30</Value></ResourceRecord></ResourceRecords></ResourceRecordSet>
+<ResourceRecordSet><Name>i-dns.relays.example.com.</Name><Type>TXT</Type><TTL>60</TTL><ResourceRecords><ResourceRecord><Value>"x"</Value></ResourceRecord></ResourceRecords></ResourceRecordSet>
+<ResourceRecordSet><Name>i-other.relays.example.com.</Name><Type>A</Type><TTL>60</TTL><ResourceRecords><ResourceRecord><Value>198.51.100.31</Value></ResourceRecord></ResourceRecords></ResourceRecordSet>
+</ResourceRecordSets></ListResourceRecordSetsResponse>`))
+		default:
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`<ErrorResponse><Error><Type>Sender</Type><Code>Throttling</Code><Message>Rate exceeded</Message></Error></ErrorResponse>`))
+		}
+	}))
+	defer srv.Close()
+
+	c := &route53Client{
+		httpClient: srv.Client(),
+		credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+		}),
+		signer:   v4.NewSigner(),
+		endpoint: srv.URL,
+	}
+	records := []dnsRecord{{Name: "i-dns.relays.example.com", Type: "A", TTL: 60, Values: []string{"198.51.100.30"}}}
+	if err := c.ChangeRecords(context.Background(), "/hostedzone/Z0123", "UPSERT", records); err != nil {
+		t.Fatalf("ChangeRecords: %v", err)
+	}
+	var sent route53ChangeRequest
+	if err := xml.Unmarshal([]byte(changeBody), &sent); err != nil || len(sent.Changes) != 1 || sent.Changes[0].ResourceRecordSet.ResourceRecords[0] != "198.51.100.30" {
+		t.Fatalf("unexpected change request %s (%v)", changeBody, err)
+	}
+
+	listed, err := c.ListRecords(context.Background(), "Z0123", "i-dns.relays.example.com")
+	if err != nil || len(listed) != 1 || listed[0].Type != "A" {
+		t.Fatalf("expected the relay's A record only, got %+v err=%v", listed, err)
+	}
+	if err := c.ChangeRecords(context.Background(), "Z0123", "DELETE", listed); !isDNSRecordMissing(err) {
+		t.Fatalf("expected a missing record error, got %v", err)
+	}
+	var apiErr smithy.APIError
+	if _, err := c.ListRecords(context.Background(), "Zother", "x"); !errors.As(err, &apiErr) || !isTransientAWSError(err) {
+		t.Fatalf("expected a transient throttling error, got %v", err)
+	}
+}
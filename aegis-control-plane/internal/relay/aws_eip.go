@@ -0,0 +1,181 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+)
+
+// attachStaticIP associates an Elastic IP with a running relay and returns
+// its address and allocation ID. Regions with a configured pool take the
+// first free pool address; other regions allocate a new one.
+func (p *AWSProvisioner) attachStaticIP(ctx context.Context, client ec2API, settings *awsSettings, req ProvisionRequest, instanceID string) (string, string, error) {
+	if pool := settings.eipPool[req.Region]; len(pool) > 0 {
+		return p.attachPooledIP(ctx, client, req, instanceID, pool)
+	}
+
+	var allocOut *ec2.AllocateAddressOutput
+	err := p.observeAWS(ctx, "allocate_address", req.Region, func(callCtx context.Context) error {
+		var allocErr error
+		allocOut, allocErr = client.AllocateAddress(callCtx, &ec2.AllocateAddressInput{
+			Domain: ec2types.DomainTypeVpc,
+			TagSpecifications: []ec2types.TagSpecification{
+				{
+					ResourceType: ec2types.ResourceTypeElasticIp,
+					Tags: []ec2types.Tag{
+						{Key: aws.String("Name"), Value: aws.String("aegis-relay-" + req.SessionID)},
+						{Key: aws.String("ManagedBy"), Value: aws.String("aegis-control-plane")},
+						{Key: aws.String("AegisSessionID"), Value: aws.String(req.SessionID)},
+					},
+				},
+			},
+		})
+		return allocErr
+	})
+	if err != nil {
+		if awsErrorCode(err) == "AddressLimitExceeded" {
+			return "", "", fmt.Errorf("%w: allocate address in %s: %v", ErrStaticIPUnavailable, req.Region, err)
+		}
+		return "", "", fmt.Errorf("allocate address: %w", err)
+	}
+	allocationID := aws.ToString(allocOut.AllocationId)
+	if err := p.associateAddress(ctx, client, req.Region, allocationID, instanceID); err != nil {
+		releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		if relErr := p.releaseAddress(releaseCtx, client, req.Region, allocationID); relErr != nil {
+			log.Printf("event=aws_eip_release_failed region=%s session_id=%s allocation_id=%s err=%q", req.Region, req.SessionID, allocationID, relErr.Error())
+		}
+		return "", "", fmt.Errorf("associate address: %w", err)
+	}
+	log.Printf("event=aws_eip_attached region=%s session_id=%s instance_id=%s allocation_id=%s pooled=false", req.Region, req.SessionID, instanceID, allocationID)
+	return aws.ToString(allocOut.PublicIp), allocationID, nil
+}
+
+func (p *AWSProvisioner) attachPooledIP(ctx context.Context, client ec2API, req ProvisionRequest, instanceID string, pool []string) (string, string, error) {
+	var descOut *ec2.DescribeAddressesOutput
+	err := p.observeAWS(ctx, "describe_addresses", req.Region, func(callCtx context.Context) error {
+		var descErr error
+		descOut, descErr = client.DescribeAddresses(callCtx, &ec2.DescribeAddressesInput{AllocationIds: pool})
+		return descErr
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("describe eip pool: %w", err)
+	}
+	for _, addr := range descOut.Addresses {
+		if addr.AssociationId != nil {
+			continue
+		}
+		allocationID := aws.ToString(addr.AllocationId)
+		err := p.associateAddress(ctx, client, req.Region, allocationID, instanceID)
+		if awsErrorCode(err) == "Resource.AlreadyAssociated" {
+			// Another start claimed this address since the describe.
+			continue
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("associate address: %w", err)
+		}
+		log.Printf("event=aws_eip_attached region=%s session_id=%s instance_id=%s allocation_id=%s pooled=true", req.Region, req.SessionID, instanceID, allocationID)
+		return aws.ToString(addr.PublicIp), allocationID, nil
+	}
+	return "", "", fmt.Errorf("%w: eip pool for %s exhausted", ErrStaticIPUnavailable, req.Region)
+}
+
+// detachStaticIP disassociates the relay's Elastic IP and releases it
+// unless it belongs to the configured pool. Relays recorded without an
+// allocation ID are looked up by instance association.
+func (p *AWSProvisioner) detachStaticIP(ctx context.Context, client ec2API, req DeprovisionRequest) error {
+	in := &ec2.DescribeAddressesInput{
+		Filters: []ec2types.Filter{{Name: aws.String("instance-id"), Values: []string{req.AWSInstanceID}}},
+	}
+	if req.EIPAllocationID != "" {
+		in = &ec2.DescribeAddressesInput{AllocationIds: []string{req.EIPAllocationID}}
+	}
+	var descOut *ec2.DescribeAddressesOutput
+	err := p.observeAWS(ctx, "describe_addresses", req.Region, func(callCtx context.Context) error {
+		var descErr error
+		descOut, descErr = client.DescribeAddresses(callCtx, in)
+		return descErr
+	})
+	if err != nil {
+		if awsErrorCode(err) == "InvalidAllocationID.NotFound" {
+			return nil
+		}
+		return fmt.Errorf("describe address: %w", err)
+	}
+	pool := p.current().eipPool[req.Region]
+	for _, addr := range descOut.Addresses {
+		allocationID := aws.ToString(addr.AllocationId)
+		// A pooled address may already serve another relay; only undo our
+		// own association.
+		if addr.AssociationId != nil && aws.ToString(addr.InstanceId) == req.AWSInstanceID {
+			err := p.observeAWS(ctx, "disassociate_address", req.Region, func(callCtx context.Context) error {
+				_, disErr := client.DisassociateAddress(callCtx, &ec2.DisassociateAddressInput{AssociationId: addr.AssociationId})
+				return disErr
+			})
+			if err != nil && awsErrorCode(err) != "InvalidAssociationID.NotFound" {
+				return fmt.Errorf("disassociate address: %w", err)
+			}
+		}
+		if slices.Contains(pool, allocationID) || !managedAddress(addr) {
+			continue
+		}
+		if err := p.releaseAddress(ctx, client, req.Region, allocationID); err != nil {
+			return err
+		}
+		log.Printf("event=aws_eip_released region=%s session_id=%s instance_id=%s allocation_id=%s", req.Region, req.SessionID, req.AWSInstanceID, allocationID)
+	}
+	return nil
+}
+
+func managedAddress(addr ec2types.Address) bool {
+	for _, tag := range addr.Tags {
+		if aws.ToString(tag.Key) == "ManagedBy" && aws.ToString(tag.Value) == "aegis-control-plane" {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *AWSProvisioner) associateAddress(ctx context.Context, client ec2API, region, allocationID, instanceID string) error {
+	return p.observeAWS(ctx, "associate_address", region, func(callCtx context.Context) error {
+		_, assocErr := client.AssociateAddress(callCtx, &ec2.AssociateAddressInput{
+			AllocationId:       aws.String(allocationID),
+			InstanceId:         aws.String(instanceID),
+			AllowReassociation: aws.Bool(false),
+		})
+		return assocErr
+	})
+}
+
+func (p *AWSProvisioner) releaseAddress(ctx context.Context, client ec2API, region, allocationID string) error {
+	err := p.observeAWS(ctx, "release_address", region, func(callCtx context.Context) error {
+		_, relErr := client.ReleaseAddress(callCtx, &ec2.ReleaseAddressInput{AllocationId: aws.String(allocationID)})
+		return relErr
+	})
+	if err != nil && awsErrorCode(err) != "InvalidAllocationID.NotFound" {
+		return fmt.Errorf("release address: %w", err)
+	}
+	return nil
+}
+
+// observeAWS runs fn through retryAWS and records the operation metrics.
+func (p *AWSProvisioner) observeAWS(ctx context.Context, op, region string, fn func(context.Context) error) error {
+	start := time.Now()
+	err := p.retryAWS(ctx, op, region, fn)
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	labels := map[string]string{"op": op, "region": region, "status": status}
+	metrics.Default().IncCounter("aegis_aws_operations_total", labels)
+	metrics.Default().ObserveHistogram("aegis_aws_operation_latency_ms", float64(time.Since(start).Milliseconds()), labels)
+	return err
+}
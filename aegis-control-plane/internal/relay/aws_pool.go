@@ -0,0 +1,353 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+// WarmPool is the store backing the warm pool of stopped relay instances.
+type WarmPool interface {
+	// ClaimPooledRelay removes and returns an available instance matching
+	// the launch image, or nil when the pool has none.
+	ClaimPooledRelay(ctx context.Context, region, amiID, instanceType string) (*model.PooledRelay, error)
+	AddPooledRelay(ctx context.Context, in model.PooledRelay) error
+	CountPooledRelays(ctx context.Context, region string) (int, error)
+}
+
+// SetWarmPool enables the warm pool for regions with a WarmPoolSize. It must
+// be called before the provisioner is used.
+func (p *AWSProvisioner) SetWarmPool(pool WarmPool) {
+	p.pool = pool
+}
+
+func (p *AWSProvisioner) WarmPoolSettings() (map[string]int, time.Duration) {
+	settings := p.current()
+	if p.pool == nil {
+		return nil, settings.warmPoolMaxAge
+	}
+	return settings.warmPoolSize, settings.warmPoolMaxAge
+}
+
+func (p *AWSProvisioner) PoolImage(ctx context.Context, region string) (string, string, error) {
+	settings := p.current()
+	ami := strings.TrimSpace(settings.amiByRegion[region])
+	if ami == "" {
+		return "", "", fmt.Errorf("no AMI configured for region %s", region)
+	}
+	amiID, err := p.ResolveAMI(ctx, region, ami)
+	if err != nil {
+		return "", "", err
+	}
+	return amiID, settings.instanceType, nil
+}
+
+// LaunchPooled launches an on-demand instance for the region's warm pool. It
+// boots without bootstrap config; a claim supplies one before starting it.
+func (p *AWSProvisioner) LaunchPooled(ctx context.Context, region string) (model.PooledRelay, error) {
+	settings := p.current()
+	amiID, instanceType, err := p.PoolImage(ctx, region)
+	if err != nil {
+		return model.PooledRelay{}, err
+	}
+	client, err := p.newClient(ctx, region)
+	if err != nil {
+		return model.PooledRelay{}, err
+	}
+	req := ProvisionRequest{Region: region}
+	target := launchTarget{region: region, amiID: amiID, instanceType: instanceType}
+	target.subnetID = p.subnetOrder(settings, region)[0]
+	if target.subnetID != "" {
+		target.ipv6 = subnetHasIPv6(p.describeSubnet(ctx, client, req, target.subnetID))
+	}
+	in := settings.runInput(target, req, LifecycleOnDemand, "")
+	in.UserData = nil
+	in.TagSpecifications = []ec2types.TagSpecification{{ResourceType: ec2types.ResourceTypeInstance, Tags: poolTags()}}
+	instanceID, err := p.runInstance(ctx, client, in, req)
+	if err != nil {
+		return model.PooledRelay{}, err
+	}
+	log.Printf("event=aws_pool_instance_launched region=%s instance_id=%s", region, instanceID)
+	return model.PooledRelay{
+		AWSInstanceID: instanceID,
+		Region:        region,
+		AMIID:         amiID,
+		InstanceType:  instanceType,
+		State:         model.PoolWarming,
+		LaunchedAt:    time.Now().UTC(),
+	}, nil
+}
+
+func (p *AWSProvisioner) StopPooled(ctx context.Context, region, awsInstanceID string) error {
+	client, err := p.newClient(ctx, region)
+	if err != nil {
+		return err
+	}
+	return p.stopInstance(ctx, client, region, awsInstanceID)
+}
+
+// provisionFromPool starts a claimed pool instance for req. It reports false
+// on a miss or when the claimed instance could not be started (it is then
+// terminated), and the caller launches a fresh instance instead.
+func (p *AWSProvisioner) provisionFromPool(ctx context.Context, client ec2API, settings *awsSettings, req ProvisionRequest, target launchTarget) (ProvisionResult, bool) {
+	// The pool only holds the primary instance type.
+	if p.pool == nil || settings.warmPoolSize[target.region] <= 0 || target.instanceType != settings.instanceType {
+		return ProvisionResult{}, false
+	}
+	pooled, err := p.pool.ClaimPooledRelay(ctx, target.region, target.amiID, target.instanceType)
+	if err != nil {
+		log.Printf("event=aws_pool_claim_failed region=%s session_id=%s err=%q", req.Region, req.SessionID, err.Error())
+		observePoolClaim(req.Region, "error")
+		return ProvisionResult{}, false
+	}
+	if pooled == nil {
+		observePoolClaim(req.Region, "miss")
+		return ProvisionResult{}, false
+	}
+
+	start := time.Now()
+	res, err := p.startPooled(ctx, client, settings, req, target, pooled.AWSInstanceID)
+	latencyLabels := map[string]string{"region": req.Region, "status": "ok"}
+	if err != nil {
+		latencyLabels["status"] = "error"
+	}
+	metrics.Default().ObserveHistogram("aegis_relay_pool_claim_latency_ms", float64(time.Since(start).Milliseconds()), latencyLabels)
+	if err != nil {
+		p.terminateLaunched(ctx, client, req, pooled.AWSInstanceID)
+		log.Printf("event=aws_pool_start_failed region=%s session_id=%s instance_id=%s err=%q", req.Region, req.SessionID, pooled.AWSInstanceID, err.Error())
+		observePoolClaim(req.Region, "error")
+		return ProvisionResult{}, false
+	}
+	log.Printf("event=aws_pool_claimed region=%s session_id=%s instance_id=%s", req.Region, req.SessionID, pooled.AWSInstanceID)
+	observePoolClaim(req.Region, "hit")
+	return res, true
+}
+
+func observePoolClaim(region, result string) {
+	metrics.Default().IncCounter("aegis_relay_pool_claims_total", map[string]string{"region": region, "result": result})
+}
+
+// startPooled hands a stopped pool instance to req: it swaps in the session
+// security group, bootstrap user data and tags, then starts it.
+func (p *AWSProvisioner) startPooled(ctx context.Context, client ec2API, settings *awsSettings, req ProvisionRequest, target launchTarget, instanceID string) (ProvisionResult, error) {
+	inst, err := p.describeInstance(ctx, client, req.Region, instanceID)
+	if err != nil {
+		return ProvisionResult{}, err
+	}
+	if inst == nil || inst.State == nil || inst.State.Name != ec2types.InstanceStateNameStopped {
+		return ProvisionResult{}, fmt.Errorf("pooled instance %s is not stopped", instanceID)
+	}
+	target.subnetID = aws.ToString(inst.SubnetId)
+
+	var groupID string
+	ok := false
+	defer func() {
+		if !ok && groupID != "" {
+			p.cleanupSessionGroup(ctx, client, req, groupID)
+		}
+	}()
+	if settings.sessionGroups && req.ClientIP != "" {
+		groupID, err = p.createSessionGroup(ctx, client, req, aws.ToString(inst.VpcId))
+		if err != nil {
+			return ProvisionResult{}, err
+		}
+		if err := p.modifyInstance(ctx, client, req.Region, &ec2.ModifyInstanceAttributeInput{
+			InstanceId: aws.String(instanceID),
+			Groups:     []string{groupID},
+		}); err != nil {
+			return ProvisionResult{}, fmt.Errorf("set security groups: %w", err)
+		}
+	}
+	userData, err := renderBootHook(req)
+	if err != nil {
+		return ProvisionResult{}, fmt.Errorf("render user data: %w", err)
+	}
+	if err := p.modifyInstance(ctx, client, req.Region, &ec2.ModifyInstanceAttributeInput{
+		InstanceId: aws.String(instanceID),
+		UserData:   &ec2types.BlobAttributeValue{Value: userData},
+	}); err != nil {
+		return ProvisionResult{}, fmt.Errorf("set user data: %w", err)
+	}
+	err = p.observeAWS(ctx, "create_tags", req.Region, func(callCtx context.Context) error {
+		_, tagErr := client.CreateTags(callCtx, &ec2.CreateTagsInput{
+			Resources: []string{instanceID},
+			Tags:      sessionTags(req),
+		})
+		return tagErr
+	})
+	if err != nil {
+		return ProvisionResult{}, fmt.Errorf("tag instance: %w", err)
+	}
+	err = p.observeAWS(ctx, "start_instances", req.Region, func(callCtx context.Context) error {
+		_, startErr := client.StartInstances(callCtx, &ec2.StartInstancesInput{InstanceIds: []string{instanceID}})
+		return startErr
+	})
+	if err != nil {
+		return ProvisionResult{}, fmt.Errorf("start instance: %w", err)
+	}
+	res, err := p.finishLaunch(ctx, client, settings, req, target, instanceID, LifecycleOnDemand, groupID)
+	if err != nil {
+		return ProvisionResult{}, err
+	}
+	ok = true
+	return res, nil
+}
+
+// returnToPool stops a healthy relay and adds it to the region's warm pool
+// when the pool is below its target size. It reports false when the instance
+// should be terminated instead; a partially returned instance is then simply
+// terminated by the caller.
+func (p *AWSProvisioner) returnToPool(ctx context.Context, client ec2API, req DeprovisionRequest) bool {
+	settings := p.current()
+	size := settings.warmPoolSize[req.Region]
+	if p.pool == nil || size <= 0 {
+		return false
+	}
+	// Without configured groups there is nothing to put back in place of the
+	// session group.
+	if req.SecurityGroupID != "" && len(settings.securityGroup) == 0 {
+		return false
+	}
+	inst, err := p.describeInstance(ctx, client, req.Region, req.AWSInstanceID)
+	if err != nil || inst == nil {
+		return false
+	}
+	amiID, instanceType, err := p.PoolImage(ctx, req.Region)
+	if err != nil || !poolable(inst, amiID, instanceType, settings.warmPoolMaxAge) {
+		return false
+	}
+	n, err := p.pool.CountPooledRelays(ctx, req.Region)
+	if err != nil || n >= size {
+		return false
+	}
+
+	if req.SecurityGroupID != "" {
+		if err := p.modifyInstance(ctx, client, req.Region, &ec2.ModifyInstanceAttributeInput{
+			InstanceId: aws.String(req.AWSInstanceID),
+			Groups:     settings.securityGroup,
+		}); err != nil {
+			log.Printf("event=aws_pool_return_failed region=%s session_id=%s instance_id=%s err=%q", req.Region, req.SessionID, req.AWSInstanceID, err.Error())
+			return false
+		}
+		// A group that is still attached is left tagged for later cleanup.
+		if err := p.deleteSessionGroup(ctx, client, req.Region, req.SecurityGroupID); err != nil {
+			log.Printf("event=aws_session_sg_cleanup_failed region=%s session_id=%s group_id=%s err=%q", req.Region, req.SessionID, req.SecurityGroupID, err.Error())
+		}
+	}
+	if err := p.stopInstance(ctx, client, req.Region, req.AWSInstanceID); err != nil {
+		log.Printf("event=aws_pool_return_failed region=%s session_id=%s instance_id=%s err=%q", req.Region, req.SessionID, req.AWSInstanceID, err.Error())
+		return false
+	}
+	if err := p.retagPooled(ctx, client, req.Region, req.AWSInstanceID); err != nil {
+		log.Printf("event=aws_pool_retag_failed region=%s instance_id=%s err=%q", req.Region, req.AWSInstanceID, err.Error())
+	}
+	err = p.pool.AddPooledRelay(ctx, model.PooledRelay{
+		AWSInstanceID: req.AWSInstanceID,
+		Region:        req.Region,
+		AMIID:         amiID,
+		InstanceType:  instanceType,
+		State:         model.PoolWarming,
+		LaunchedAt:    aws.ToTime(inst.LaunchTime),
+	})
+	if err != nil {
+		log.Printf("event=aws_pool_return_failed region=%s session_id=%s instance_id=%s err=%q", req.Region, req.SessionID, req.AWSInstanceID, err.Error())
+		return false
+	}
+	log.Printf("event=aws_pool_returned region=%s session_id=%s instance_id=%s", req.Region, req.SessionID, req.AWSInstanceID)
+	metrics.Default().IncCounter("aegis_relay_pool_returns_total", map[string]string{"region": req.Region})
+	return true
+}
+
+// poolable reports whether a running relay can serve another session: an
+// on-demand instance of the current image that is not due for recycling.
+func poolable(inst *ec2types.Instance, amiID, instanceType string, maxAge time.Duration) bool {
+	return inst.State != nil && inst.State.Name == ec2types.InstanceStateNameRunning &&
+		inst.InstanceLifecycle == "" &&
+		aws.ToString(inst.ImageId) == amiID &&
+		string(inst.InstanceType) == instanceType &&
+		time.Since(aws.ToTime(inst.LaunchTime)) < maxAge
+}
+
+// retagPooled drops the previous session's tags from a returned instance.
+func (p *AWSProvisioner) retagPooled(ctx context.Context, client ec2API, region, instanceID string) error {
+	err := p.observeAWS(ctx, "delete_tags", region, func(callCtx context.Context) error {
+		_, tagErr := client.DeleteTags(callCtx, &ec2.DeleteTagsInput{
+			Resources: []string{instanceID},
+			Tags:      []ec2types.Tag{{Key: aws.String("AegisSessionID")}, {Key: aws.String("AegisUserID")}},
+		})
+		return tagErr
+	})
+	if err != nil {
+		return err
+	}
+	return p.observeAWS(ctx, "create_tags", region, func(callCtx context.Context) error {
+		_, tagErr := client.CreateTags(callCtx, &ec2.CreateTagsInput{
+			Resources: []string{instanceID},
+			Tags:      poolTags(),
+		})
+		return tagErr
+	})
+}
+
+func poolTags() []ec2types.Tag {
+	return []ec2types.Tag{
+		{Key: aws.String("Name"), Value: aws.String("aegis-relay-pool")},
+		{Key: aws.String("ManagedBy"), Value: aws.String("aegis-control-plane")},
+		{Key: aws.String("AegisPool"), Value: aws.String("true")},
+		{Key: aws.String("AegisLifecycle"), Value: aws.String(LifecycleOnDemand)},
+	}
+}
+
+func sessionTags(req ProvisionRequest) []ec2types.Tag {
+	return []ec2types.Tag{
+		{Key: aws.String("Name"), Value: aws.String("aegis-relay-" + req.SessionID)},
+		{Key: aws.String("AegisSessionID"), Value: aws.String(req.SessionID)},
+		{Key: aws.String("AegisUserID"), Value: aws.String(req.UserID)},
+		{Key: aws.String("AegisPool"), Value: aws.String("false")},
+	}
+}
+
+func (p *AWSProvisioner) describeInstance(ctx context.Context, client ec2API, region, instanceID string) (*ec2types.Instance, error) {
+	var out *ec2.DescribeInstancesOutput
+	err := p.observeAWS(ctx, "describe_instances", region, func(callCtx context.Context) error {
+		var descErr error
+		out, descErr = client.DescribeInstances(callCtx, &ec2.DescribeInstancesInput{InstanceIds: []string{instanceID}})
+		return descErr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describe instance %s: %w", instanceID, err)
+	}
+	for _, res := range out.Reservations {
+		for i := range res.Instances {
+			return &res.Instances[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (p *AWSProvisioner) modifyInstance(ctx context.Context, client ec2API, region string, in *ec2.ModifyInstanceAttributeInput) error {
+	return p.observeAWS(ctx, "modify_instance_attribute", region, func(callCtx context.Context) error {
+		_, modErr := client.ModifyInstanceAttribute(callCtx, in)
+		return modErr
+	})
+}
+
+func (p *AWSProvisioner) stopInstance(ctx context.Context, client ec2API, region, instanceID string) error {
+	err := p.observeAWS(ctx, "stop_instances", region, func(callCtx context.Context) error {
+		_, stopErr := client.StopInstances(callCtx, &ec2.StopInstancesInput{InstanceIds: []string{instanceID}})
+		return stopErr
+	})
+	if err != nil {
+		return fmt.Errorf("stop instance: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package relay
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+type fakeWarmPool struct {
+	available []model.PooledRelay
+	added     []model.PooledRelay
+	count     int
+}
+
+func (f *fakeWarmPool) ClaimPooledRelay(_ context.Context, region, amiID, instanceType string) (*model.PooledRelay, error) {
+	for i, p := range f.available {
+		if p.Region == region && p.AMIID == amiID && p.InstanceType == instanceType {
+			f.available = append(f.available[:i], f.available[i+1:]...)
+			return &p, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeWarmPool) AddPooledRelay(_ context.Context, in model.PooledRelay) error {
+	f.added = append(f.added, in)
+	return nil
+}
+
+func (f *fakeWarmPool) CountPooledRelays(context.Context, string) (int, error) {
+	return f.count, nil
+}
+
+func TestProvision_ClaimsWarmPoolInstance(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	state := ec2types.InstanceStateNameStopped
+	var userData string
+	var tagged []string
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, _ *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			t.Fatal("RunInstances must not be called on a pool hit")
+			return nil, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			out := runningInstance("i-pooled", "198.51.100.70")
+			out.Reservations[0].Instances[0].State.Name = state
+			out.Reservations[0].Instances[0].SubnetId = aws.String("subnet-0a")
+			return out, nil
+		},
+		modifyAttributeFn: func(_ context.Context, in *ec2.ModifyInstanceAttributeInput) (*ec2.ModifyInstanceAttributeOutput, error) {
+			if in.UserData != nil {
+				userData = string(in.UserData.Value)
+			}
+			return &ec2.ModifyInstanceAttributeOutput{}, nil
+		},
+		createTagsFn: func(_ context.Context, in *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+			for _, tag := range in.Tags {
+				tagged = append(tagged, aws.ToString(tag.Key)+"="+aws.ToString(tag.Value))
+			}
+			return &ec2.CreateTagsOutput{}, nil
+		},
+		startInstancesFn: func(_ context.Context, in *ec2.StartInstancesInput) (*ec2.StartInstancesOutput, error) {
+			state = ec2types.InstanceStateNameRunning
+			return &ec2.StartInstancesOutput{}, nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion:  map[string]string{"us-east-1": "ami-east"},
+		WarmPoolSize: map[string]int{"us-east-1": 1},
+	}, client)
+	p.SetWarmPool(&fakeWarmPool{available: []model.PooledRelay{
+		{AWSInstanceID: "i-pooled", Region: "us-east-1", AMIID: "ami-east", InstanceType: "t4g.small", State: model.PoolAvailable},
+	}})
+
+	res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", UserID: "usr_1", Region: "us-east-1", RelayAuthToken: "tok_1"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if res.AWSInstanceID != "i-pooled" || res.PublicIP != "198.51.100.70" || res.SubnetID != "subnet-0a" || res.Lifecycle != LifecycleOnDemand {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if !strings.HasPrefix(userData, "#cloud-boothook\n") || !strings.Contains(userData, bootstrapPath) {
+		t.Fatalf("expected boothook user data, got %q", userData)
+	}
+	if !strings.Contains(strings.Join(tagged, ","), "AegisSessionID=ses_1") {
+		t.Fatalf("expected session tags, got %v", tagged)
+	}
+	out := metrics.Default().Render()
+	if !strings.Contains(out, `aegis_relay_pool_claims_total{region="us-east-1",result="hit"} 1`) {
+		t.Fatalf("expected pool hit metric, got:\n%s", out)
+	}
+}
+
+func TestProvision_WarmPoolMissLaunchesInstance(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, _ *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-cold")}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return runningInstance("i-cold", "198.51.100.71"), nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion:  map[string]string{"us-east-1": "ami-east"},
+		WarmPoolSize: map[string]int{"us-east-1": 1},
+	}, client)
+	// A pool instance of a superseded image is never claimed.
+	p.SetWarmPool(&fakeWarmPool{available: []model.PooledRelay{
+		{AWSInstanceID: "i-stale", Region: "us-east-1", AMIID: "ami-old", InstanceType: "t4g.small"},
+	}})
+
+	res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if res.AWSInstanceID != "i-cold" {
+		t.Fatalf("expected a fresh launch, got %+v", res)
+	}
+	if out := metrics.Default().Render(); !strings.Contains(out, `aegis_relay_pool_claims_total{region="us-east-1",result="miss"} 1`) {
+		t.Fatalf("expected pool miss metric, got:\n%s", out)
+	}
+}
+
+func TestDeprovision_ReturnsHealthyInstanceToPool(t *testing.T) {
+	var stopped, terminated []string
+	client := &fakeEC2{
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			out := runningInstance("i-1", "198.51.100.72")
+			inst := &out.Reservations[0].Instances[0]
+			inst.ImageId = aws.String("ami-east")
+			inst.InstanceType = ec2types.InstanceTypeT4gSmall
+			inst.LaunchTime = aws.Time(time.Now().Add(-time.Hour))
+			return out, nil
+		},
+		stopInstancesFn: func(_ context.Context, in *ec2.StopInstancesInput) (*ec2.StopInstancesOutput, error) {
+			stopped = append(stopped, in.InstanceIds...)
+			return &ec2.StopInstancesOutput{}, nil
+		},
+		terminateInstancesFn: func(_ context.Context, in *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+			terminated = append(terminated, in.InstanceIds...)
+			return terminatedOutput(in), nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion:  map[string]string{"us-east-1": "ami-east"},
+		WarmPoolSize: map[string]int{"us-east-1": 1},
+	}, client)
+	pool := &fakeWarmPool{}
+	p.SetWarmPool(pool)
+
+	if err := p.Deprovision(context.Background(), DeprovisionRequest{SessionID: "ses_1", Region: "us-east-1", AWSInstanceID: "i-1"}); err != nil {
+		t.Fatalf("Deprovision: %v", err)
+	}
+	if len(stopped) != 1 || len(terminated) != 0 {
+		t.Fatalf("expected instance stopped not terminated, stopped=%v terminated=%v", stopped, terminated)
+	}
+	if len(pool.added) != 1 || pool.added[0].AWSInstanceID != "i-1" || pool.added[0].State != model.PoolWarming {
+		t.Fatalf("expected instance added to the pool, got %+v", pool.added)
+	}
+
+	// A full pool means the next relay is terminated as usual.
+	pool.count = 1
+	if err := p.Deprovision(context.Background(), DeprovisionRequest{SessionID: "ses_2", Region: "us-east-1", AWSInstanceID: "i-1"}); err != nil {
+		t.Fatalf("Deprovision: %v", err)
+	}
+	if len(terminated) != 1 {
+		t.Fatalf("expected termination once the pool is full, got %v", terminated)
+	}
+}
@@ -0,0 +1,168 @@
+package relay
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// createSessionGroup creates a security group admitting the relay's SRT and
+// WS ports from req.ClientIP only. An empty vpcID uses the default VPC.
+func (p *AWSProvisioner) createSessionGroup(ctx context.Context, client ec2API, req ProvisionRequest, vpcID string) (string, error) {
+	perms, err := clientIPPermissions(req.ClientIP, req.srtPorts())
+	if err != nil {
+		return "", err
+	}
+	// Replacement relays share the session ID, so names carry a suffix.
+	var suffix [4]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return "", err
+	}
+	in := &ec2.CreateSecurityGroupInput{
+		GroupName:   aws.String(fmt.Sprintf("aegis-relay-%s-%s", req.SessionID, hex.EncodeToString(suffix[:]))),
+		Description: aws.String("Aegis relay client access for session " + req.SessionID),
+		TagSpecifications: []ec2types.TagSpecification{
+			{
+				ResourceType: ec2types.ResourceTypeSecurityGroup,
+				Tags: []ec2types.Tag{
+					{Key: aws.String("ManagedBy"), Value: aws.String("aegis-control-plane")},
+					{Key: aws.String("AegisSessionID"), Value: aws.String(req.SessionID)},
+				},
+			},
+		},
+	}
+	if vpcID != "" {
+		in.VpcId = aws.String(vpcID)
+	}
+	var out *ec2.CreateSecurityGroupOutput
+	err = p.observeAWS(ctx, "create_security_group", req.Region, func(callCtx context.Context) error {
+		var createErr error
+		out, createErr = client.CreateSecurityGroup(callCtx, in)
+		return createErr
+	})
+	if err != nil {
+		return "", fmt.Errorf("create security group: %w", err)
+	}
+	groupID := aws.ToString(out.GroupId)
+	err = p.observeAWS(ctx, "authorize_security_group_ingress", req.Region, func(callCtx context.Context) error {
+		_, authErr := client.AuthorizeSecurityGroupIngress(callCtx, &ec2.AuthorizeSecurityGroupIngressInput{
+			GroupId:       aws.String(groupID),
+			IpPermissions: perms,
+		})
+		return authErr
+	})
+	if err != nil {
+		p.cleanupSessionGroup(ctx, client, req, groupID)
+		return "", fmt.Errorf("authorize security group ingress: %w", err)
+	}
+	log.Printf("event=aws_session_sg_created region=%s session_id=%s group_id=%s", req.Region, req.SessionID, groupID)
+	return groupID, nil
+}
+
+// cleanupSessionGroup deletes the group of a failed launch. It cannot be
+// deleted while a terminating instance still holds its interface; such
+// groups stay tagged ManagedBy=aegis-control-plane for later cleanup.
+func (p *AWSProvisioner) cleanupSessionGroup(ctx context.Context, client ec2API, req ProvisionRequest, groupID string) {
+	cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	if err := p.deleteSessionGroup(cleanupCtx, client, req.Region, groupID); err != nil {
+		log.Printf("event=aws_session_sg_cleanup_failed region=%s session_id=%s group_id=%s err=%q", req.Region, req.SessionID, groupID, err.Error())
+	}
+}
+
+// deleteSessionGroup fails with DependencyViolation until the instance's
+// network interface is gone; Deprovision callers retry with backoff.
+func (p *AWSProvisioner) deleteSessionGroup(ctx context.Context, client ec2API, region, groupID string) error {
+	err := p.observeAWS(ctx, "delete_security_group", region, func(callCtx context.Context) error {
+		_, delErr := client.DeleteSecurityGroup(callCtx, &ec2.DeleteSecurityGroupInput{GroupId: aws.String(groupID)})
+		return delErr
+	})
+	if err != nil && awsErrorCode(err) != "InvalidGroup.NotFound" {
+		return fmt.Errorf("delete security group %s: %w", groupID, err)
+	}
+	return nil
+}
+
+// AuthorizeClientIP replaces every ingress rule of the relay's session
+// group with rules for req.ClientIP.
+func (p *AWSProvisioner) AuthorizeClientIP(ctx context.Context, req AuthorizeClientIPRequest) error {
+	perms, err := clientIPPermissions(req.ClientIP, req.SRTPorts)
+	if err != nil {
+		return err
+	}
+	client, err := p.newClient(ctx, req.Region)
+	if err != nil {
+		return err
+	}
+	var descOut *ec2.DescribeSecurityGroupsOutput
+	err = p.observeAWS(ctx, "describe_security_groups", req.Region, func(callCtx context.Context) error {
+		var descErr error
+		descOut, descErr = client.DescribeSecurityGroups(callCtx, &ec2.DescribeSecurityGroupsInput{GroupIds: []string{req.SecurityGroupID}})
+		return descErr
+	})
+	if err != nil {
+		return fmt.Errorf("describe security group: %w", err)
+	}
+	if len(descOut.SecurityGroups) == 0 {
+		return fmt.Errorf("security group %s not found", req.SecurityGroupID)
+	}
+	if existing := descOut.SecurityGroups[0].IpPermissions; len(existing) > 0 {
+		err = p.observeAWS(ctx, "revoke_security_group_ingress", req.Region, func(callCtx context.Context) error {
+			_, revokeErr := client.RevokeSecurityGroupIngress(callCtx, &ec2.RevokeSecurityGroupIngressInput{
+				GroupId:       aws.String(req.SecurityGroupID),
+				IpPermissions: existing,
+			})
+			return revokeErr
+		})
+		if err != nil {
+			return fmt.Errorf("revoke security group ingress: %w", err)
+		}
+	}
+	err = p.observeAWS(ctx, "authorize_security_group_ingress", req.Region, func(callCtx context.Context) error {
+		_, authErr := client.AuthorizeSecurityGroupIngress(callCtx, &ec2.AuthorizeSecurityGroupIngressInput{
+			GroupId:       aws.String(req.SecurityGroupID),
+			IpPermissions: perms,
+		})
+		return authErr
+	})
+	if err != nil {
+		return fmt.Errorf("authorize security group ingress: %w", err)
+	}
+	log.Printf("event=aws_session_sg_reauthorized region=%s group_id=%s", req.Region, req.SecurityGroupID)
+	return nil
+}
+
+// clientIPPermissions admits the SRT ports (UDP) and the WS port (TCP) from
+// one host.
+func clientIPPermissions(clientIP string, srtPorts []int) ([]ec2types.IpPermission, error) {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid client ip %q", clientIP)
+	}
+	perm := func(proto string, port int) ec2types.IpPermission {
+		out := ec2types.IpPermission{
+			IpProtocol: aws.String(proto),
+			FromPort:   aws.Int32(int32(port)),
+			ToPort:     aws.Int32(int32(port)),
+		}
+		if ip.To4() != nil {
+			out.IpRanges = []ec2types.IpRange{{CidrIp: aws.String(ip.String() + "/32")}}
+		} else {
+			out.Ipv6Ranges = []ec2types.Ipv6Range{{CidrIpv6: aws.String(ip.String() + "/128")}}
+		}
+		return out
+	}
+	var perms []ec2types.IpPermission
+	for _, port := range srtPorts {
+		perms = append(perms, perm("udp", port))
+	}
+	return append(perms, perm("tcp", relayWSPort)), nil
+}
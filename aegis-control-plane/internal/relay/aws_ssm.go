@@ -0,0 +1,192 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awscfg "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/smithy-go"
+)
+
+// ssmAMIPrefix marks AMI map values that name an SSM parameter holding the
+// image ID, e.g. "ssm:/aegis/relay/ami".
+const ssmAMIPrefix = "ssm:"
+
+// ssmAMICacheTTL bounds how long a resolved parameter is reused, so a new
+// relay image is picked up within minutes of the parameter changing.
+const ssmAMICacheTTL = 5 * time.Minute
+
+// ssmAPI is the subset of SSM Parameter Store used to resolve AMIs.
+type ssmAPI interface {
+	GetParameter(ctx context.Context, name string) (string, error)
+}
+
+type cachedAMI struct {
+	amiID   string
+	expires time.Time
+}
+
+// amiCache holds resolved SSM parameters keyed by region and name.
+type amiCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedAMI
+}
+
+func (c *amiCache) get(key string, now time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || now.After(e.expires) {
+		return "", false
+	}
+	return e.amiID, true
+}
+
+func (c *amiCache) put(key, amiID string, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]cachedAMI)
+	}
+	c.entries[key] = cachedAMI{amiID: amiID, expires: expires}
+}
+
+// ResolveAMI returns the image ID a launch in region uses for the AMI map
+// value ami. Literal IDs are returned unchanged.
+func (p *AWSProvisioner) ResolveAMI(ctx context.Context, region, ami string) (string, error) {
+	ami = strings.TrimSpace(ami)
+	name, ok := strings.CutPrefix(ami, ssmAMIPrefix)
+	if !ok {
+		return ami, nil
+	}
+	key := region + "|" + name
+	if amiID, ok := p.amis.get(key, time.Now()); ok {
+		return amiID, nil
+	}
+	client, err := p.newSSMClient(ctx, region)
+	if err != nil {
+		return "", fmt.Errorf("resolve AMI parameter %s in %s: %w", name, region, err)
+	}
+	var amiID string
+	err = p.observeAWS(ctx, "ssm_get_parameter", region, func(callCtx context.Context) error {
+		var getErr error
+		amiID, getErr = client.GetParameter(callCtx, name)
+		return getErr
+	})
+	if err != nil {
+		return "", fmt.Errorf("resolve AMI parameter %s in %s: %w", name, region, err)
+	}
+	amiID = strings.TrimSpace(amiID)
+	if !strings.HasPrefix(amiID, "ami-") {
+		return "", fmt.Errorf("resolve AMI parameter %s in %s: value %q is not an AMI ID", name, region, amiID)
+	}
+	p.amis.put(key, amiID, time.Now().Add(ssmAMICacheTTL))
+	return amiID, nil
+}
+
+// ssmClient calls the SSM JSON API directly with SigV4-signed requests.
+type ssmClient struct {
+	httpClient  aws.HTTPClient
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+	region      string
+	endpoint    string
+}
+
+func newSSMClient(ctx context.Context, region string) (ssmAPI, error) {
+	cfg, err := awscfg.LoadDefaultConfig(ctx, awscfg.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("aws config: %w", err)
+	}
+	endpoint := "https://ssm." + region + ".amazonaws.com/"
+	if cfg.BaseEndpoint != nil {
+		endpoint = *cfg.BaseEndpoint
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &ssmClient{
+		httpClient:  httpClient,
+		credentials: cfg.Credentials,
+		signer:      v4.NewSigner(),
+		region:      region,
+		endpoint:    endpoint,
+	}, nil
+}
+
+func (c *ssmClient) GetParameter(ctx context.Context, name string) (string, error) {
+	body, err := json.Marshal(map[string]string{"Name": name})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AmazonSSM.GetParameter")
+	if c.credentials == nil {
+		return "", fmt.Errorf("no aws credentials configured")
+	}
+	creds, err := c.credentials.Retrieve(ctx)
+	if err != nil {
+		return "", fmt.Errorf("aws credentials: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	if err := c.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), "ssm", c.region, time.Now()); err != nil {
+		return "", err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", ssmError(resp.StatusCode, raw)
+	}
+	var out struct {
+		Parameter struct {
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return "", fmt.Errorf("decode GetParameter response: %w", err)
+	}
+	return out.Parameter.Value, nil
+}
+
+// ssmError maps an SSM error body onto a smithy API error so retryAWS
+// classifies throttling like any other AWS call.
+func ssmError(status int, raw []byte) error {
+	var body struct {
+		Type    string `json:"__type"`
+		Message string `json:"message"`
+	}
+	_ = json.Unmarshal(raw, &body)
+	code := body.Type
+	if i := strings.LastIndex(code, "#"); i >= 0 {
+		code = code[i+1:]
+	}
+	if code == "" && status >= 500 {
+		code = "ServiceUnavailable"
+	} else if code == "" {
+		code = http.StatusText(status)
+	}
+	return &smithy.GenericAPIError{Code: code, Message: body.Message}
+}
@@ -0,0 +1,133 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+type fakeSSM struct {
+	values map[string]string
+	calls  int
+}
+
+func (f *fakeSSM) GetParameter(_ context.Context, name string) (string, error) {
+	f.calls++
+	v, ok := f.values[name]
+	if !ok {
+		return "", &smithy.GenericAPIError{Code: "ParameterNotFound", Message: "not found"}
+	}
+	return v, nil
+}
+
+func withFakeSSM(p *AWSProvisioner, ssm *fakeSSM) {
+	p.newSSMClient = func(context.Context, string) (ssmAPI, error) {
+		return ssm, nil
+	}
+}
+
+func TestProvision_ResolvesAMIFromSSMParameter(t *testing.T) {
+	var launched []string
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, in *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			launched = append(launched, aws.ToString(in.ImageId))
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-ssm")}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return runningInstance("i-ssm", "198.51.100.60"), nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion: map[string]string{"us-east-1": "ssm:/aegis/relay/ami"},
+	}, client)
+	ssm := &fakeSSM{values: map[string]string{"/aegis/relay/ami": "ami-from-ssm"}}
+	withFakeSSM(p, ssm)
+
+	for i := 0; i < 2; i++ {
+		res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"})
+		if err != nil {
+			t.Fatalf("Provision: %v", err)
+		}
+		if res.AMIID != "ami-from-ssm" {
+			t.Fatalf("expected resolved AMI in result, got %q", res.AMIID)
+		}
+	}
+	if strings.Join(launched, ",") != "ami-from-ssm,ami-from-ssm" {
+		t.Fatalf("unexpected launched images: %v", launched)
+	}
+	if ssm.calls != 1 {
+		t.Fatalf("expected cached parameter to be fetched once, got %d calls", ssm.calls)
+	}
+}
+
+func TestProvision_SSMResolutionFailureNamesParameter(t *testing.T) {
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, _ *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			t.Fatal("RunInstances must not be called without an AMI")
+			return nil, nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion: map[string]string{"us-east-1": "ssm:/aegis/relay/missing"},
+	}, client)
+	withFakeSSM(p, &fakeSSM{})
+
+	_, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"})
+	if err == nil || !strings.Contains(err.Error(), "/aegis/relay/missing") || awsErrorCode(err) != "ParameterNotFound" {
+		t.Fatalf("expected error naming the parameter, got %v", err)
+	}
+}
+
+func TestResolveAMI_LiteralAndInvalidValues(t *testing.T) {
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{AMIByRegion: map[string]string{"us-east-1": "ami-east"}}, &fakeEC2{})
+	withFakeSSM(p, &fakeSSM{values: map[string]string{"/aegis/relay/ami": "latest"}})
+
+	if got, err := p.ResolveAMI(context.Background(), "us-east-1", "ami-east"); err != nil || got != "ami-east" {
+		t.Fatalf("expected literal AMI unchanged, got %q err=%v", got, err)
+	}
+	if _, err := p.ResolveAMI(context.Background(), "us-east-1", "ssm:/aegis/relay/ami"); err == nil || !strings.Contains(err.Error(), "not an AMI ID") {
+		t.Fatalf("expected invalid parameter value error, got %v", err)
+	}
+}
+
+func TestSSMClient_GetParameter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "AmazonSSM.GetParameter" || !strings.Contains(r.Header.Get("Authorization"), "/us-east-1/ssm/aws4_request") {
+			t.Errorf("unexpected request headers: %v", r.Header)
+		}
+		var in struct{ Name string }
+		_ = json.NewDecoder(r.Body).Decode(&in)
+		if in.Name == "/aegis/relay/ami" {
+			_, _ = w.Write([]byte(`{"Parameter":{"Name":"/aegis/relay/ami","Value":"ami-0123"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"__type":"com.amazonaws.ssm#ParameterNotFound","message":"missing"}`))
+	}))
+	defer srv.Close()
+
+	c := &ssmClient{
+		httpClient: srv.Client(),
+		credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+		}),
+		signer:   v4.NewSigner(),
+		region:   "us-east-1",
+		endpoint: srv.URL,
+	}
+	if got, err := c.GetParameter(context.Background(), "/aegis/relay/ami"); err != nil || got != "ami-0123" {
+		t.Fatalf("expected ami-0123, got %q err=%v", got, err)
+	}
+	if _, err := c.GetParameter(context.Background(), "/nope"); awsErrorCode(err) != "ParameterNotFound" {
+		t.Fatalf("expected ParameterNotFound, got %v", err)
+	}
+}
@@ -2,10 +2,22 @@ package relay
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/smithy-go"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
 )
 
 func TestShouldIgnoreTerminateError(t *testing.T) {
@@ -85,8 +97,9 @@ func TestIsTransientAWSError(t *testing.T) {
 }
 
 func TestRetryAWS_NonTransientDoesNotRetry(t *testing.T) {
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{AMIByRegion: map[string]string{"us-east-1": "ami-1"}}, &fakeEC2{})
 	attempts := 0
-	err := retryAWS(context.Background(), "run_instances", "us-east-1", func(context.Context) error {
+	err := p.retryAWS(context.Background(), "run_instances", "us-east-1", func(context.Context) error {
 		attempts++
 		return &smithy.GenericAPIError{Code: "InvalidParameterValue", Message: "bad request"}
 	})
@@ -97,3 +110,1309 @@ func TestRetryAWS_NonTransientDoesNotRetry(t *testing.T) {
 		t.Fatalf("expected 1 attempt, got %d", attempts)
 	}
 }
+
+type fakeEC2 struct {
+	runInstancesFn       func(context.Context, *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error)
+	describeInstancesFn  func(context.Context, *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
+	findInstancesFn      func(context.Context, *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
+	terminateInstancesFn func(context.Context, *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error)
+	describeImagesFn     func(context.Context, *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error)
+
+	allocateAddressFn     func(context.Context, *ec2.AllocateAddressInput) (*ec2.AllocateAddressOutput, error)
+	associateAddressFn    func(context.Context, *ec2.AssociateAddressInput) (*ec2.AssociateAddressOutput, error)
+	describeAddressesFn   func(context.Context, *ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error)
+	disassociateAddressFn func(context.Context, *ec2.DisassociateAddressInput) (*ec2.DisassociateAddressOutput, error)
+	releaseAddressFn      func(context.Context, *ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error)
+	describeSubnetsFn     func(context.Context, *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error)
+
+	createSecurityGroupFn    func(context.Context, *ec2.CreateSecurityGroupInput) (*ec2.CreateSecurityGroupOutput, error)
+	describeSecurityGroupsFn func(context.Context, *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error)
+	authorizeIngressFn       func(context.Context, *ec2.AuthorizeSecurityGroupIngressInput) (*ec2.AuthorizeSecurityGroupIngressOutput, error)
+	revokeIngressFn          func(context.Context, *ec2.RevokeSecurityGroupIngressInput) (*ec2.RevokeSecurityGroupIngressOutput, error)
+	deleteSecurityGroupFn    func(context.Context, *ec2.DeleteSecurityGroupInput) (*ec2.DeleteSecurityGroupOutput, error)
+
+	startInstancesFn  func(context.Context, *ec2.StartInstancesInput) (*ec2.StartInstancesOutput, error)
+	stopInstancesFn   func(context.Context, *ec2.StopInstancesInput) (*ec2.StopInstancesOutput, error)
+	modifyAttributeFn func(context.Context, *ec2.ModifyInstanceAttributeInput) (*ec2.ModifyInstanceAttributeOutput, error)
+	createTagsFn      func(context.Context, *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error)
+	deleteTagsFn      func(context.Context, *ec2.DeleteTagsInput) (*ec2.DeleteTagsOutput, error)
+}
+
+func (f *fakeEC2) RunInstances(ctx context.Context, in *ec2.RunInstancesInput, _ ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error) {
+	if f.runInstancesFn != nil {
+		return f.runInstancesFn(ctx, in)
+	}
+	return nil, errors.New("RunInstances not stubbed")
+}
+
+func (f *fakeEC2) DescribeInstances(ctx context.Context, in *ec2.DescribeInstancesInput, _ ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	// Tag-filtered lookups (session instance adoption) find nothing unless
+	// a test stubs them.
+	if len(in.Filters) > 0 {
+		if f.findInstancesFn != nil {
+			return f.findInstancesFn(ctx, in)
+		}
+		return &ec2.DescribeInstancesOutput{}, nil
+	}
+	if f.describeInstancesFn != nil {
+		return f.describeInstancesFn(ctx, in)
+	}
+	return nil, errors.New("DescribeInstances not stubbed")
+}
+
+func (f *fakeEC2) TerminateInstances(ctx context.Context, in *ec2.TerminateInstancesInput, _ ...func(*ec2.Options)) (*ec2.TerminateInstancesOutput, error) {
+	if f.terminateInstancesFn != nil {
+		return f.terminateInstancesFn(ctx, in)
+	}
+	return terminatedOutput(in), nil
+}
+
+// terminatedOutput answers TerminateInstances as if every instance was
+// already gone, so Deprovision confirms the termination.
+func terminatedOutput(in *ec2.TerminateInstancesInput) *ec2.TerminateInstancesOutput {
+	out := &ec2.TerminateInstancesOutput{}
+	for _, id := range in.InstanceIds {
+		out.TerminatingInstances = append(out.TerminatingInstances, ec2types.InstanceStateChange{
+			InstanceId:   aws.String(id),
+			CurrentState: &ec2types.InstanceState{Name: ec2types.InstanceStateNameTerminated},
+		})
+	}
+	return out
+}
+
+func (f *fakeEC2) DescribeImages(ctx context.Context, in *ec2.DescribeImagesInput, _ ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	if f.describeImagesFn != nil {
+		return f.describeImagesFn(ctx, in)
+	}
+	return nil, errors.New("DescribeImages not stubbed")
+}
+
+func (f *fakeEC2) AllocateAddress(ctx context.Context, in *ec2.AllocateAddressInput, _ ...func(*ec2.Options)) (*ec2.AllocateAddressOutput, error) {
+	if f.allocateAddressFn != nil {
+		return f.allocateAddressFn(ctx, in)
+	}
+	return nil, errors.New("AllocateAddress not stubbed")
+}
+
+func (f *fakeEC2) AssociateAddress(ctx context.Context, in *ec2.AssociateAddressInput, _ ...func(*ec2.Options)) (*ec2.AssociateAddressOutput, error) {
+	if f.associateAddressFn != nil {
+		return f.associateAddressFn(ctx, in)
+	}
+	return &ec2.AssociateAddressOutput{}, nil
+}
+
+func (f *fakeEC2) DescribeAddresses(ctx context.Context, in *ec2.DescribeAddressesInput, _ ...func(*ec2.Options)) (*ec2.DescribeAddressesOutput, error) {
+	if f.describeAddressesFn != nil {
+		return f.describeAddressesFn(ctx, in)
+	}
+	return &ec2.DescribeAddressesOutput{}, nil
+}
+
+func (f *fakeEC2) DisassociateAddress(ctx context.Context, in *ec2.DisassociateAddressInput, _ ...func(*ec2.Options)) (*ec2.DisassociateAddressOutput, error) {
+	if f.disassociateAddressFn != nil {
+		return f.disassociateAddressFn(ctx, in)
+	}
+	return &ec2.DisassociateAddressOutput{}, nil
+}
+
+func (f *fakeEC2) ReleaseAddress(ctx context.Context, in *ec2.ReleaseAddressInput, _ ...func(*ec2.Options)) (*ec2.ReleaseAddressOutput, error) {
+	if f.releaseAddressFn != nil {
+		return f.releaseAddressFn(ctx, in)
+	}
+	return &ec2.ReleaseAddressOutput{}, nil
+}
+
+func (f *fakeEC2) DescribeSubnets(ctx context.Context, in *ec2.DescribeSubnetsInput, _ ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error) {
+	if f.describeSubnetsFn != nil {
+		return f.describeSubnetsFn(ctx, in)
+	}
+	return &ec2.DescribeSubnetsOutput{}, nil
+}
+
+func (f *fakeEC2) CreateSecurityGroup(ctx context.Context, in *ec2.CreateSecurityGroupInput, _ ...func(*ec2.Options)) (*ec2.CreateSecurityGroupOutput, error) {
+	if f.createSecurityGroupFn != nil {
+		return f.createSecurityGroupFn(ctx, in)
+	}
+	return nil, errors.New("CreateSecurityGroup not stubbed")
+}
+
+func (f *fakeEC2) DescribeSecurityGroups(ctx context.Context, in *ec2.DescribeSecurityGroupsInput, _ ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupsOutput, error) {
+	if f.describeSecurityGroupsFn != nil {
+		return f.describeSecurityGroupsFn(ctx, in)
+	}
+	return nil, errors.New("DescribeSecurityGroups not stubbed")
+}
+
+func (f *fakeEC2) AuthorizeSecurityGroupIngress(ctx context.Context, in *ec2.AuthorizeSecurityGroupIngressInput, _ ...func(*ec2.Options)) (*ec2.AuthorizeSecurityGroupIngressOutput, error) {
+	if f.authorizeIngressFn != nil {
+		return f.authorizeIngressFn(ctx, in)
+	}
+	return &ec2.AuthorizeSecurityGroupIngressOutput{}, nil
+}
+
+func (f *fakeEC2) RevokeSecurityGroupIngress(ctx context.Context, in *ec2.RevokeSecurityGroupIngressInput, _ ...func(*ec2.Options)) (*ec2.RevokeSecurityGroupIngressOutput, error) {
+	if f.revokeIngressFn != nil {
+		return f.revokeIngressFn(ctx, in)
+	}
+	return &ec2.RevokeSecurityGroupIngressOutput{}, nil
+}
+
+func (f *fakeEC2) DeleteSecurityGroup(ctx context.Context, in *ec2.DeleteSecurityGroupInput, _ ...func(*ec2.Options)) (*ec2.DeleteSecurityGroupOutput, error) {
+	if f.deleteSecurityGroupFn != nil {
+		return f.deleteSecurityGroupFn(ctx, in)
+	}
+	return &ec2.DeleteSecurityGroupOutput{}, nil
+}
+
+func (f *fakeEC2) StartInstances(ctx context.Context, in *ec2.StartInstancesInput, _ ...func(*ec2.Options)) (*ec2.StartInstancesOutput, error) {
+	if f.startInstancesFn != nil {
+		return f.startInstancesFn(ctx, in)
+	}
+	return nil, errors.New("StartInstances not stubbed")
+}
+
+func (f *fakeEC2) StopInstances(ctx context.Context, in *ec2.StopInstancesInput, _ ...func(*ec2.Options)) (*ec2.StopInstancesOutput, error) {
+	if f.stopInstancesFn != nil {
+		return f.stopInstancesFn(ctx, in)
+	}
+	return nil, errors.New("StopInstances not stubbed")
+}
+
+func (f *fakeEC2) ModifyInstanceAttribute(ctx context.Context, in *ec2.ModifyInstanceAttributeInput, _ ...func(*ec2.Options)) (*ec2.ModifyInstanceAttributeOutput, error) {
+	if f.modifyAttributeFn != nil {
+		return f.modifyAttributeFn(ctx, in)
+	}
+	return &ec2.ModifyInstanceAttributeOutput{}, nil
+}
+
+func (f *fakeEC2) CreateTags(ctx context.Context, in *ec2.CreateTagsInput, _ ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	if f.createTagsFn != nil {
+		return f.createTagsFn(ctx, in)
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (f *fakeEC2) DeleteTags(ctx context.Context, in *ec2.DeleteTagsInput, _ ...func(*ec2.Options)) (*ec2.DeleteTagsOutput, error) {
+	if f.deleteTagsFn != nil {
+		return f.deleteTagsFn(ctx, in)
+	}
+	return &ec2.DeleteTagsOutput{}, nil
+}
+
+func newTestAWSProvisioner(t *testing.T, opts AWSProvisionerOptions, client ec2API) *AWSProvisioner {
+	t.Helper()
+	p, err := NewAWSProvisioner(opts)
+	if err != nil {
+		t.Fatalf("NewAWSProvisioner: %v", err)
+	}
+	// Back off 1ms between retries so tests stay fast.
+	p.retry.jitter = func(time.Duration) time.Duration { return time.Millisecond }
+	p.newClient = func(context.Context, string) (ec2API, error) {
+		return client, nil
+	}
+	return p
+}
+
+func TestValidateConfig_ReportsMissingAndUnavailableImages(t *testing.T) {
+	client := &fakeEC2{
+		describeImagesFn: func(_ context.Context, in *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+			switch in.ImageIds[0] {
+			case "ami-ok":
+				return &ec2.DescribeImagesOutput{Images: []ec2types.Image{{ImageId: aws.String("ami-ok"), State: ec2types.ImageStateAvailable}}}, nil
+			case "ami-pending":
+				return &ec2.DescribeImagesOutput{Images: []ec2types.Image{{ImageId: aws.String("ami-pending"), State: ec2types.ImageStatePending}}}, nil
+			default:
+				return &ec2.DescribeImagesOutput{}, nil
+			}
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion: map[string]string{
+			"us-east-1":      "ami-ok",
+			"eu-west-1":      "ami-pending",
+			"ap-southeast-2": "ami-gone",
+		},
+		VerifyAMIs: true,
+	}, client)
+
+	problems := p.ValidateConfig(context.Background(), []string{"us-east-1", "eu-west-1", "ap-southeast-2", "sa-east-1"})
+	got := map[string]bool{}
+	for _, prob := range problems {
+		var regionErr *model.RegionError
+		if !errors.As(prob, &regionErr) {
+			t.Fatalf("expected region error, got %v", prob)
+		}
+		got[regionErr.Region] = true
+	}
+	if len(got) != 2 || !got["eu-west-1"] || !got["ap-southeast-2"] {
+		t.Fatalf("unexpected problem regions: %v", got)
+	}
+}
+
+func TestValidateOptions_ChecksTheNewAMIsNotTheRunningOnes(t *testing.T) {
+	client := &fakeEC2{
+		describeImagesFn: func(_ context.Context, in *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+			if in.ImageIds[0] == "ami-ok" {
+				return &ec2.DescribeImagesOutput{Images: []ec2types.Image{{ImageId: aws.String("ami-ok"), State: ec2types.ImageStateAvailable}}}, nil
+			}
+			return &ec2.DescribeImagesOutput{}, nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion: map[string]string{"us-east-1": "ami-ok"},
+		VerifyAMIs:  true,
+	}, client)
+
+	problems := p.ValidateOptions(context.Background(), AWSProvisionerOptions{
+		AMIByRegion: map[string]string{"us-east-1": "ami-unknown"},
+	}, []string{"us-east-1"})
+	var regionErr *model.RegionError
+	if len(problems) != 1 || !errors.As(problems[0], &regionErr) || regionErr.Region != "us-east-1" {
+		t.Fatalf("expected the new AMI to be reported, got %v", problems)
+	}
+	if problems := p.ValidateConfig(context.Background(), []string{"us-east-1"}); len(problems) != 0 {
+		t.Fatalf("expected the running AMI to stay valid, got %v", problems)
+	}
+}
+
+func TestValidateConfig_SkipsImageLookupWhenDisabled(t *testing.T) {
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion: map[string]string{"us-east-1": "ami-ok"},
+	}, &fakeEC2{})
+
+	if problems := p.ValidateConfig(context.Background(), []string{"us-east-1"}); len(problems) != 0 {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+}
+
+func TestProvision_WaitTimeoutTerminatesLaunchedInstance(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	var terminated []string
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, _ *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-slow")}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return pendingInstance("i-slow"), nil
+		},
+		terminateInstancesFn: func(_ context.Context, in *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+			terminated = append(terminated, in.InstanceIds...)
+			return &ec2.TerminateInstancesOutput{}, nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion:           map[string]string{"us-east-1": "ami-1"},
+		ProvisionWaitTimeout:  50 * time.Millisecond,
+		ProvisionPollInterval: 10 * time.Millisecond,
+	}, client)
+
+	_, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", UserID: "usr_1", Region: "us-east-1"})
+	if err == nil {
+		t.Fatal("expected wait timeout error")
+	}
+	if len(terminated) != 1 || terminated[0] != "i-slow" {
+		t.Fatalf("expected launched instance to be terminated, got %v", terminated)
+	}
+	if !strings.Contains(metrics.Default().Render(), `aegis_aws_instance_running_wait_ms_count{region="us-east-1",status="error"} 1`) {
+		t.Fatal("expected instance running wait histogram sample")
+	}
+}
+
+func TestReconfigure_RejectsPollIntervalAboveWaitTimeout(t *testing.T) {
+	_, err := NewAWSProvisioner(AWSProvisionerOptions{
+		AMIByRegion:           map[string]string{"us-east-1": "ami-1"},
+		ProvisionWaitTimeout:  time.Second,
+		ProvisionPollInterval: time.Minute,
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func pendingInstance(id string) *ec2.DescribeInstancesOutput {
+	return &ec2.DescribeInstancesOutput{Reservations: []ec2types.Reservation{{
+		Instances: []ec2types.Instance{{
+			InstanceId: aws.String(id),
+			State:      &ec2types.InstanceState{Name: ec2types.InstanceStateNamePending},
+		}},
+	}}}
+}
+
+func TestProvision_FallsBackOnInsufficientCapacity(t *testing.T) {
+	metrics.ResetDefaultForTest()
+
+	var attempts []string
+	newClient := func(_ context.Context, region string) (ec2API, error) {
+		return &fakeEC2{
+			runInstancesFn: func(_ context.Context, in *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+				target := region + "/" + string(in.InstanceType)
+				attempts = append(attempts, target)
+				if target != "us-west-2/t4g.small" {
+					return nil, &smithy.GenericAPIError{Code: "InsufficientInstanceCapacity", Message: "no capacity"}
+				}
+				return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-fallback")}}}, nil
+			},
+			describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+				return runningInstance("i-fallback", "198.51.100.7"), nil
+			},
+		}, nil
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion:           map[string]string{"us-east-1": "ami-east", "us-west-2": "ami-west"},
+		InstanceType:          "t4g.small",
+		FallbackInstanceTypes: map[string][]string{"us-east-1": {"t4g.medium"}},
+		FallbackRegions:       []string{"eu-central-1", "us-west-2"},
+	}, nil)
+	p.newClient = newClient
+
+	var reported []string
+	res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", UserID: "usr_1", Region: "us-east-1", OnAttempt: func(a ProvisionAttempt) {
+		reported = append(reported, a.Region+"/"+a.InstanceType+":"+ErrorCode(a.Err)+":"+a.InstanceID)
+	}})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if res.Region != "us-west-2" || res.InstanceType != "t4g.small" || res.AMIID != "ami-west" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	want := []string{"us-east-1/t4g.small", "us-east-1/t4g.medium", "us-west-2/t4g.small"}
+	if strings.Join(uniqueInOrder(attempts), ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected launch order: %v", attempts)
+	}
+	wantReported := []string{
+		"us-east-1/t4g.small:InsufficientInstanceCapacity:",
+		"us-east-1/t4g.medium:InsufficientInstanceCapacity:",
+		"us-west-2/t4g.small::i-fallback",
+	}
+	if strings.Join(reported, ",") != strings.Join(wantReported, ",") {
+		t.Fatalf("unexpected reported attempts: %v", reported)
+	}
+	out := metrics.Default().Render()
+	if !strings.Contains(out, `aegis_relay_capacity_fallback_total{from="us-east-1/t4g.medium",to="us-west-2/t4g.small"} 1`) {
+		t.Fatalf("expected fallback metric, got:\n%s", out)
+	}
+}
+
+func TestProvision_NonCapacityErrorDoesNotFallBack(t *testing.T) {
+	calls := 0
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, _ *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			calls++
+			return nil, &smithy.GenericAPIError{Code: "InvalidAMIID.NotFound", Message: "missing"}
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion:     map[string]string{"us-east-1": "ami-east", "us-west-2": "ami-west"},
+		FallbackRegions: []string{"us-west-2"},
+	}, client)
+
+	if _, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"}); err == nil {
+		t.Fatal("expected error")
+	}
+	if calls != 1 {
+		t.Fatalf("expected a single launch attempt, got %d", calls)
+	}
+}
+
+func TestProvision_RequestedInstanceTypeReplacesPrimary(t *testing.T) {
+	var launched []string
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, in *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			launched = append(launched, string(in.InstanceType))
+			if in.InstanceType == "t4g.nano" {
+				return nil, &smithy.GenericAPIError{Code: "InsufficientInstanceCapacity", Message: "no capacity"}
+			}
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-canary")}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return runningInstance("i-canary", "198.51.100.9"), nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion:           map[string]string{"us-east-1": "ami-east"},
+		InstanceType:          "t4g.small",
+		FallbackInstanceTypes: map[string][]string{"us-east-1": {"t4g.micro"}},
+	}, client)
+
+	res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1", InstanceType: "t4g.nano"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if got := strings.Join(uniqueInOrder(launched), ","); got != "t4g.nano,t4g.micro" {
+		t.Fatalf("expected the requested type then the fallbacks, got %s", got)
+	}
+	if res.InstanceType != "t4g.micro" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
+
+func TestProvision_CapacityExhaustedIsRegionUnavailable(t *testing.T) {
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, _ *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			return nil, &smithy.GenericAPIError{Code: "InsufficientInstanceCapacity", Message: "no capacity"}
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion:     map[string]string{"us-east-1": "ami-east", "us-west-2": "ami-west"},
+		FallbackRegions: []string{"us-west-2"},
+	}, client)
+
+	_, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"})
+	if !errors.Is(err, ErrRegionUnavailable) || !isCapacityError(err) {
+		t.Fatalf("expected ErrRegionUnavailable wrapping the capacity error, got %v", err)
+	}
+}
+
+func TestProvision_TypesProviderErrors(t *testing.T) {
+	tests := []struct {
+		code  string
+		want  error
+		cause string
+	}{
+		{"InsufficientInstanceCapacity", ErrNoCapacity, "no_capacity"},
+		{"RequestLimitExceeded", ErrProviderThrottled, "throttled"},
+		{"VcpuLimitExceeded", ErrQuotaExceeded, "quota_exceeded"},
+		{"InvalidAMIID.NotFound", ErrImageUnavailable, "image_unavailable"},
+		{"UnauthorizedOperation", ErrProviderAuth, "auth"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			client := &fakeEC2{
+				runInstancesFn: func(_ context.Context, _ *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+					return nil, &smithy.GenericAPIError{Code: tt.code, Message: "failed"}
+				},
+			}
+			p := newTestAWSProvisioner(t, AWSProvisionerOptions{AMIByRegion: map[string]string{"us-east-1": "ami-east"}}, client)
+
+			_, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"})
+			if !errors.Is(err, tt.want) || ErrorCode(err) != tt.code {
+				t.Fatalf("expected %v wrapping %s, got %v", tt.want, tt.code, err)
+			}
+			if got := FailureCause(err); got != tt.cause {
+				t.Fatalf("expected cause %s, got %s", tt.cause, got)
+			}
+		})
+	}
+}
+
+func TestProvision_MissingAMIIsImageUnavailable(t *testing.T) {
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{AMIByRegion: map[string]string{"us-east-1": "ami-east"}}, &fakeEC2{})
+	if _, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "eu-west-1"}); !errors.Is(err, ErrImageUnavailable) {
+		t.Fatalf("expected ErrImageUnavailable, got %v", err)
+	}
+}
+
+func uniqueInOrder(in []string) []string {
+	var out []string
+	for _, v := range in {
+		if len(out) == 0 || out[len(out)-1] != v {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func runningInstance(id, publicIP string) *ec2.DescribeInstancesOutput {
+	return &ec2.DescribeInstancesOutput{Reservations: []ec2types.Reservation{{
+		Instances: []ec2types.Instance{{
+			InstanceId:      aws.String(id),
+			PublicIpAddress: aws.String(publicIP),
+			State:           &ec2types.InstanceState{Name: ec2types.InstanceStateNameRunning},
+		}},
+	}}}
+}
+
+func TestProvision_SpotFallsBackToOnDemand(t *testing.T) {
+	metrics.ResetDefaultForTest()
+
+	var markets []string
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, in *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			if in.InstanceMarketOptions != nil {
+				markets = append(markets, LifecycleSpot)
+				return nil, &smithy.GenericAPIError{Code: "SpotMaxPriceTooLow", Message: "price"}
+			}
+			markets = append(markets, LifecycleOnDemand)
+			if tagValue(in, "AegisLifecycle") != LifecycleOnDemand {
+				t.Fatalf("expected on-demand lifecycle tag, got %q", tagValue(in, "AegisLifecycle"))
+			}
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-od")}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return runningInstance("i-od", "198.51.100.8"), nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion: map[string]string{"us-east-1": "ami-east"},
+		UseSpot:     true,
+	}, client)
+
+	res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if res.Lifecycle != LifecycleOnDemand {
+		t.Fatalf("expected on-demand lifecycle, got %s", res.Lifecycle)
+	}
+	if strings.Join(markets, ",") != "spot,on-demand" {
+		t.Fatalf("unexpected launch sequence: %v", markets)
+	}
+	if !strings.Contains(metrics.Default().Render(), `aegis_relay_spot_fallback_total{region="us-east-1"} 1`) {
+		t.Fatal("expected spot fallback metric")
+	}
+}
+
+func TestProvision_SpotLaunchRecordsLifecycle(t *testing.T) {
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, in *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			if in.InstanceMarketOptions == nil || in.InstanceMarketOptions.SpotOptions.SpotInstanceType != ec2types.SpotInstanceTypeOneTime {
+				t.Fatalf("expected one-time spot request, got %+v", in.InstanceMarketOptions)
+			}
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-spot")}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return runningInstance("i-spot", "198.51.100.9"), nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion: map[string]string{"us-east-1": "ami-east"},
+		UseSpot:     true,
+	}, client)
+
+	res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if res.Lifecycle != LifecycleSpot {
+		t.Fatalf("expected spot lifecycle, got %s", res.Lifecycle)
+	}
+}
+
+func TestProvision_RecordsPhaseLatencies(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, _ *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-phase")}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return runningInstance("i-phase", "198.51.100.12"), nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion:  map[string]string{"us-east-1": "ami-east"},
+		InstanceType: "t4g.small",
+	}, client)
+
+	if _, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"}); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	out := metrics.Default().Render()
+	for _, phase := range []string{"launch", "wait_running", "describe"} {
+		want := `aegis_relay_provision_phase_ms_count{instance_type="t4g.small",phase="` + phase + `",provider="aws",region="us-east-1",status="ok"} 1`
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %s phase, got:\n%s", phase, out)
+		}
+	}
+}
+
+func tagValue(in *ec2.RunInstancesInput, key string) string {
+	for _, spec := range in.TagSpecifications {
+		for _, tag := range spec.Tags {
+			if aws.ToString(tag.Key) == key {
+				return aws.ToString(tag.Value)
+			}
+		}
+	}
+	return ""
+}
+
+func TestStatus_MapsInstanceStates(t *testing.T) {
+	tests := []struct {
+		name string
+		out  *ec2.DescribeInstancesOutput
+		err  error
+		want string
+	}{
+		{name: "running", out: runningInstance("i-1", "198.51.100.7"), want: InstanceRunning},
+		{name: "pending", out: pendingInstance("i-1"), want: InstancePending},
+		{name: "stopped", out: &ec2.DescribeInstancesOutput{Reservations: []ec2types.Reservation{{
+			Instances: []ec2types.Instance{{State: &ec2types.InstanceState{Name: ec2types.InstanceStateNameStopped}}},
+		}}}, want: InstanceStopped},
+		{name: "shutting down", out: &ec2.DescribeInstancesOutput{Reservations: []ec2types.Reservation{{
+			Instances: []ec2types.Instance{{State: &ec2types.InstanceState{Name: ec2types.InstanceStateNameShuttingDown}}},
+		}}}, want: InstanceShuttingDown},
+		{name: "not found", err: &smithy.GenericAPIError{Code: "InvalidInstanceID.NotFound", Message: "missing"}, want: InstanceTerminated},
+		{name: "empty", out: &ec2.DescribeInstancesOutput{}, want: InstanceTerminated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeEC2{
+				describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+					return tt.out, tt.err
+				},
+			}
+			p := newTestAWSProvisioner(t, AWSProvisionerOptions{AMIByRegion: map[string]string{"us-east-1": "ami-east"}}, client)
+			got, err := p.Status(context.Background(), StatusRequest{Region: "us-east-1", AWSInstanceID: "i-1"})
+			if err != nil {
+				t.Fatalf("Status: %v", err)
+			}
+			if got.State != tt.want {
+				t.Fatalf("got state %q, want %q", got.State, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatus_PropagatesAPIErrors(t *testing.T) {
+	client := &fakeEC2{
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return nil, &smithy.GenericAPIError{Code: "UnauthorizedOperation", Message: "denied"}
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{AMIByRegion: map[string]string{"us-east-1": "ami-east"}}, client)
+	if _, err := p.Status(context.Background(), StatusRequest{Region: "us-east-1", AWSInstanceID: "i-1"}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestRenderUserData_WritesBootstrapJSON(t *testing.T) {
+	encoded, err := renderUserData(ProvisionRequest{
+		SessionID:       "ses_1",
+		UserID:          "usr_1",
+		Region:          "eu-west-1",
+		ControlPlaneURL: "https://api.telemy.app",
+		RelayAuthToken:  "tok_1",
+	})
+	if err != nil {
+		t.Fatalf("renderUserData: %v", err)
+	}
+	got := decodeBootstrap(t, encoded)
+	want := relayBootstrap{
+		SessionID:       "ses_1",
+		UserID:          "usr_1",
+		Region:          "eu-west-1",
+		ControlPlaneURL: "https://api.telemy.app",
+		RelayAuthToken:  "tok_1",
+		SRTPort:         DefaultSRTPort,
+		SRTPorts:        []int{DefaultSRTPort},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected bootstrap:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestProvision_SetsUserDataForLaunchRegion(t *testing.T) {
+	var userData string
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, in *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			userData = aws.ToString(in.UserData)
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-1")}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return runningInstance("i-1", "198.51.100.7"), nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{AMIByRegion: map[string]string{"us-east-1": "ami-east"}}, client)
+
+	res, err := p.Provision(context.Background(), ProvisionRequest{
+		SessionID:      "ses_1",
+		UserID:         "usr_1",
+		Region:         "us-east-1",
+		RelayAuthToken: "tok_1",
+		SRTPortRange:   PortRange{Min: 10000, Max: 10009},
+		SRTPortCount:   2,
+	})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if res.SRTPort != 10000 || !slices.Equal(res.SRTPorts, []int{10000, 10001}) {
+		t.Fatalf("expected the lowest ports of the range, got %d %v", res.SRTPort, res.SRTPorts)
+	}
+	got := decodeBootstrap(t, userData)
+	if got.SessionID != "ses_1" || got.Region != "us-east-1" || got.RelayAuthToken != "tok_1" || got.SRTPort != 10000 || !slices.Equal(got.SRTPorts, res.SRTPorts) {
+		t.Fatalf("unexpected bootstrap: %+v", got)
+	}
+}
+
+// decodeBootstrap unwraps base64 user data and the b64 write_files content.
+func decodeBootstrap(t *testing.T, encoded string) relayBootstrap {
+	t.Helper()
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("decode user data: %v", err)
+	}
+	doc := string(raw)
+	if !strings.HasPrefix(doc, "#cloud-config\n") || !strings.Contains(doc, "path: "+bootstrapPath) || !strings.Contains(doc, `permissions: "0600"`) {
+		t.Fatalf("unexpected cloud-config:\n%s", doc)
+	}
+	_, content, ok := strings.Cut(doc, "content: ")
+	if !ok {
+		t.Fatalf("missing content:\n%s", doc)
+	}
+	payload, err := base64.StdEncoding.DecodeString(strings.TrimSpace(content))
+	if err != nil {
+		t.Fatalf("decode content: %v", err)
+	}
+	var out relayBootstrap
+	if err := json.Unmarshal(payload, &out); err != nil {
+		t.Fatalf("unmarshal bootstrap: %v", err)
+	}
+	return out
+}
+
+func TestProvision_EnforcesIMDSv2AndAttachesInstanceProfile(t *testing.T) {
+	var runIn *ec2.RunInstancesInput
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, in *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			runIn = in
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-1")}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return runningInstance("i-1", "198.51.100.7"), nil
+		},
+	}
+	const profile = "arn:aws:iam::123456789012:instance-profile/aegis-relay"
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion:        map[string]string{"us-east-1": "ami-east"},
+		InstanceProfileARN: profile,
+	}, client)
+
+	if _, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"}); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	md := runIn.MetadataOptions
+	if md == nil || md.HttpTokens != ec2types.HttpTokensStateRequired || aws.ToInt32(md.HttpPutResponseHopLimit) != 1 {
+		t.Fatalf("expected IMDSv2 with hop limit 1, got %+v", md)
+	}
+	if runIn.InstanceInitiatedShutdownBehavior != ec2types.ShutdownBehaviorTerminate {
+		t.Fatalf("expected terminate shutdown behavior, got %q", runIn.InstanceInitiatedShutdownBehavior)
+	}
+	if runIn.IamInstanceProfile == nil || aws.ToString(runIn.IamInstanceProfile.Arn) != profile {
+		t.Fatalf("expected instance profile %s, got %+v", profile, runIn.IamInstanceProfile)
+	}
+
+	p = newTestAWSProvisioner(t, AWSProvisionerOptions{AMIByRegion: map[string]string{"us-east-1": "ami-east"}}, client)
+	if _, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_2", Region: "us-east-1"}); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if runIn.IamInstanceProfile != nil {
+		t.Fatalf("expected no instance profile when unset, got %+v", runIn.IamInstanceProfile)
+	}
+}
+
+func TestProvision_StaticIPTakesFreePoolAddress(t *testing.T) {
+	var associated []string
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, _ *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-eip")}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return runningInstance("i-eip", "198.51.100.20"), nil
+		},
+		describeAddressesFn: func(_ context.Context, in *ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+			if strings.Join(in.AllocationIds, ",") != "eipalloc-a,eipalloc-b,eipalloc-c" {
+				t.Fatalf("unexpected pool lookup: %v", in.AllocationIds)
+			}
+			return &ec2.DescribeAddressesOutput{Addresses: []ec2types.Address{
+				{AllocationId: aws.String("eipalloc-a"), PublicIp: aws.String("192.0.2.1"), AssociationId: aws.String("eipassoc-1")},
+				{AllocationId: aws.String("eipalloc-b"), PublicIp: aws.String("192.0.2.2")},
+				{AllocationId: aws.String("eipalloc-c"), PublicIp: aws.String("192.0.2.3")},
+			}}, nil
+		},
+		associateAddressFn: func(_ context.Context, in *ec2.AssociateAddressInput) (*ec2.AssociateAddressOutput, error) {
+			associated = append(associated, aws.ToString(in.AllocationId))
+			if aws.ToBool(in.AllowReassociation) {
+				t.Fatal("pool association must not steal an associated address")
+			}
+			if aws.ToString(in.AllocationId) == "eipalloc-b" {
+				return nil, &smithy.GenericAPIError{Code: "Resource.AlreadyAssociated", Message: "taken"}
+			}
+			return &ec2.AssociateAddressOutput{}, nil
+		},
+		allocateAddressFn: func(_ context.Context, _ *ec2.AllocateAddressInput) (*ec2.AllocateAddressOutput, error) {
+			t.Fatal("pooled region must not allocate addresses")
+			return nil, nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion: map[string]string{"us-east-1": "ami-east"},
+		EIPPool:     map[string][]string{"us-east-1": {"eipalloc-a", "eipalloc-b", "eipalloc-c"}},
+	}, client)
+
+	res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1", StaticIP: true})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if res.PublicIP != "192.0.2.3" || res.EIPAllocationID != "eipalloc-c" {
+		t.Fatalf("expected pool address eipalloc-c, got %s %s", res.PublicIP, res.EIPAllocationID)
+	}
+	if res.WSURL != "wss://192.0.2.3:7443/telemetry" {
+		t.Fatalf("expected ws url on static ip, got %s", res.WSURL)
+	}
+	if strings.Join(associated, ",") != "eipalloc-b,eipalloc-c" {
+		t.Fatalf("unexpected association attempts: %v", associated)
+	}
+}
+
+func TestProvision_StaticIPPoolExhaustedTerminatesInstance(t *testing.T) {
+	var terminated []string
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, _ *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-eip")}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return runningInstance("i-eip", "198.51.100.20"), nil
+		},
+		describeAddressesFn: func(_ context.Context, _ *ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+			return &ec2.DescribeAddressesOutput{Addresses: []ec2types.Address{
+				{AllocationId: aws.String("eipalloc-a"), PublicIp: aws.String("192.0.2.1"), AssociationId: aws.String("eipassoc-1")},
+			}}, nil
+		},
+		terminateInstancesFn: func(_ context.Context, in *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+			terminated = append(terminated, in.InstanceIds...)
+			return &ec2.TerminateInstancesOutput{}, nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion: map[string]string{"us-east-1": "ami-east"},
+		EIPPool:     map[string][]string{"us-east-1": {"eipalloc-a"}},
+	}, client)
+
+	_, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1", StaticIP: true})
+	if !errors.Is(err, ErrStaticIPUnavailable) {
+		t.Fatalf("expected ErrStaticIPUnavailable, got %v", err)
+	}
+	if strings.Join(terminated, ",") != "i-eip" {
+		t.Fatalf("expected launched instance to be terminated, got %v", terminated)
+	}
+}
+
+func TestProvision_StaticIPAllocatesWithoutPool(t *testing.T) {
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, _ *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-eip")}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return runningInstance("i-eip", "198.51.100.20"), nil
+		},
+		allocateAddressFn: func(_ context.Context, in *ec2.AllocateAddressInput) (*ec2.AllocateAddressOutput, error) {
+			if in.Domain != ec2types.DomainTypeVpc || len(in.TagSpecifications) != 1 {
+				t.Fatalf("unexpected allocate input: %+v", in)
+			}
+			return &ec2.AllocateAddressOutput{AllocationId: aws.String("eipalloc-new"), PublicIp: aws.String("192.0.2.9")}, nil
+		},
+		associateAddressFn: func(_ context.Context, in *ec2.AssociateAddressInput) (*ec2.AssociateAddressOutput, error) {
+			if aws.ToString(in.InstanceId) != "i-eip" || aws.ToString(in.AllocationId) != "eipalloc-new" {
+				t.Fatalf("unexpected associate input: %+v", in)
+			}
+			return &ec2.AssociateAddressOutput{}, nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{AMIByRegion: map[string]string{"us-east-1": "ami-east"}}, client)
+
+	res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1", StaticIP: true})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if res.PublicIP != "192.0.2.9" || res.EIPAllocationID != "eipalloc-new" {
+		t.Fatalf("expected allocated address, got %s %s", res.PublicIP, res.EIPAllocationID)
+	}
+}
+
+func TestDeprovision_ReleasesStaticIPWhenTerminateFails(t *testing.T) {
+	var disassociated, released []string
+	client := &fakeEC2{
+		describeAddressesFn: func(_ context.Context, in *ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+			if strings.Join(in.AllocationIds, ",") != "eipalloc-new" {
+				t.Fatalf("expected lookup by allocation id, got %+v", in)
+			}
+			return &ec2.DescribeAddressesOutput{Addresses: []ec2types.Address{{
+				AllocationId:  aws.String("eipalloc-new"),
+				AssociationId: aws.String("eipassoc-9"),
+				InstanceId:    aws.String("i-eip"),
+				Tags:          []ec2types.Tag{{Key: aws.String("ManagedBy"), Value: aws.String("aegis-control-plane")}},
+			}}}, nil
+		},
+		disassociateAddressFn: func(_ context.Context, in *ec2.DisassociateAddressInput) (*ec2.DisassociateAddressOutput, error) {
+			disassociated = append(disassociated, aws.ToString(in.AssociationId))
+			return &ec2.DisassociateAddressOutput{}, nil
+		},
+		releaseAddressFn: func(_ context.Context, in *ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error) {
+			released = append(released, aws.ToString(in.AllocationId))
+			return &ec2.ReleaseAddressOutput{}, nil
+		},
+		terminateInstancesFn: func(_ context.Context, _ *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+			return nil, &smithy.GenericAPIError{Code: "UnauthorizedOperation", Message: "denied"}
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{AMIByRegion: map[string]string{"us-east-1": "ami-east"}}, client)
+
+	err := p.Deprovision(context.Background(), DeprovisionRequest{SessionID: "ses_1", Region: "us-east-1", AWSInstanceID: "i-eip", EIPAllocationID: "eipalloc-new"})
+	if err == nil || !strings.Contains(err.Error(), "terminate instance") {
+		t.Fatalf("expected terminate error, got %v", err)
+	}
+	if strings.Join(disassociated, ",") != "eipassoc-9" || strings.Join(released, ",") != "eipalloc-new" {
+		t.Fatalf("expected address disassociated and released, got %v %v", disassociated, released)
+	}
+}
+
+func TestDeprovision_ReturnsPooledStaticIPWithoutRelease(t *testing.T) {
+	var disassociated []string
+	client := &fakeEC2{
+		describeAddressesFn: func(_ context.Context, _ *ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+			return &ec2.DescribeAddressesOutput{Addresses: []ec2types.Address{{
+				AllocationId:  aws.String("eipalloc-a"),
+				AssociationId: aws.String("eipassoc-1"),
+				InstanceId:    aws.String("i-eip"),
+				Tags:          []ec2types.Tag{{Key: aws.String("ManagedBy"), Value: aws.String("aegis-control-plane")}},
+			}}}, nil
+		},
+		disassociateAddressFn: func(_ context.Context, in *ec2.DisassociateAddressInput) (*ec2.DisassociateAddressOutput, error) {
+			disassociated = append(disassociated, aws.ToString(in.AssociationId))
+			return &ec2.DisassociateAddressOutput{}, nil
+		},
+		releaseAddressFn: func(_ context.Context, _ *ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error) {
+			t.Fatal("pooled address must not be released")
+			return nil, nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion: map[string]string{"us-east-1": "ami-east"},
+		EIPPool:     map[string][]string{"us-east-1": {"eipalloc-a"}},
+	}, client)
+
+	if err := p.Deprovision(context.Background(), DeprovisionRequest{Region: "us-east-1", AWSInstanceID: "i-eip", EIPAllocationID: "eipalloc-a"}); err != nil {
+		t.Fatalf("Deprovision: %v", err)
+	}
+	if strings.Join(disassociated, ",") != "eipassoc-1" {
+		t.Fatalf("expected pooled address disassociated, got %v", disassociated)
+	}
+}
+
+func TestProvision_RequestsIPv6OnDualStackSubnet(t *testing.T) {
+	var eni ec2types.InstanceNetworkInterfaceSpecification
+	client := &fakeEC2{
+		describeSubnetsFn: func(_ context.Context, in *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+			return &ec2.DescribeSubnetsOutput{Subnets: []ec2types.Subnet{{
+				SubnetId: aws.String(in.SubnetIds[0]),
+				Ipv6CidrBlockAssociationSet: []ec2types.SubnetIpv6CidrBlockAssociation{{
+					Ipv6CidrBlock:      aws.String("2600:1f18::/64"),
+					Ipv6CidrBlockState: &ec2types.SubnetCidrBlockState{State: ec2types.SubnetCidrBlockStateCodeAssociated},
+				}},
+			}}}, nil
+		},
+		runInstancesFn: func(_ context.Context, in *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			eni = in.NetworkInterfaces[0]
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-v6")}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			out := runningInstance("i-v6", "198.51.100.30")
+			out.Reservations[0].Instances[0].Ipv6Address = aws.String("2600:1f18::30")
+			return out, nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion: map[string]string{"us-east-1": "ami-east"},
+		SubnetID:    "subnet-0123456789abcdef0",
+	}, client)
+
+	res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if aws.ToInt32(eni.Ipv6AddressCount) != 1 {
+		t.Fatalf("expected one IPv6 address requested, got %+v", eni)
+	}
+	if res.PublicIP != "198.51.100.30" || res.PublicIPv6 != "2600:1f18::30" {
+		t.Fatalf("unexpected addresses: %s %s", res.PublicIP, res.PublicIPv6)
+	}
+	if res.WSURL != "wss://198.51.100.30:7443/telemetry" {
+		t.Fatalf("unexpected ws url %s", res.WSURL)
+	}
+}
+
+func TestProvision_IPv6OnlyInstanceBracketsWSURL(t *testing.T) {
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, in *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			if in.NetworkInterfaces[0].Ipv6AddressCount != nil {
+				t.Fatal("expected no IPv6 request for an IPv4-only subnet")
+			}
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-v6")}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return &ec2.DescribeInstancesOutput{Reservations: []ec2types.Reservation{{
+				Instances: []ec2types.Instance{{
+					InstanceId: aws.String("i-v6"),
+					State:      &ec2types.InstanceState{Name: ec2types.InstanceStateNameRunning},
+					NetworkInterfaces: []ec2types.InstanceNetworkInterface{{
+						Ipv6Addresses: []ec2types.InstanceIpv6Address{{Ipv6Address: aws.String("2600:1f18::31")}},
+					}},
+				}},
+			}}}, nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion: map[string]string{"us-east-1": "ami-east"},
+		SubnetID:    "subnet-0123456789abcdef0",
+	}, client)
+
+	res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if res.PublicIP != "" || res.WSURL != "wss://[2600:1f18::31]:7443/telemetry" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
+
+func TestProvision_RotatesAcrossRegionSubnets(t *testing.T) {
+	var subnets []string
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, in *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			subnets = append(subnets, aws.ToString(in.NetworkInterfaces[0].SubnetId))
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-az")}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return runningInstance("i-az", "198.51.100.40"), nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion:     map[string]string{"us-east-1": "ami-east"},
+		SubnetID:        "subnet-legacy",
+		SubnetsByRegion: map[string][]string{"us-east-1": {"subnet-a", "subnet-b", "subnet-c"}},
+	}, client)
+
+	for i := 0; i < 4; i++ {
+		res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"})
+		if err != nil {
+			t.Fatalf("Provision: %v", err)
+		}
+		if res.SubnetID != subnets[i] {
+			t.Fatalf("expected result subnet %s, got %s", subnets[i], res.SubnetID)
+		}
+	}
+	if got := strings.Join(subnets, ","); got != "subnet-a,subnet-b,subnet-c,subnet-a" {
+		t.Fatalf("unexpected subnet rotation: %s", got)
+	}
+}
+
+func TestProvision_RetriesNextSubnetOnInsufficientCapacity(t *testing.T) {
+	metrics.ResetDefaultForTest()
+
+	var attempts []string
+	client := &fakeEC2{
+		runInstancesFn: func(_ context.Context, in *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			subnet := aws.ToString(in.NetworkInterfaces[0].SubnetId)
+			attempts = append(attempts, subnet+"/"+string(in.InstanceType))
+			if subnet == "subnet-a" {
+				return nil, &smithy.GenericAPIError{Code: "InsufficientInstanceCapacity", Message: "no capacity"}
+			}
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-az")}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			out := runningInstance("i-az", "198.51.100.41")
+			out.Reservations[0].Instances[0].Placement = &ec2types.Placement{AvailabilityZone: aws.String("us-east-1b")}
+			return out, nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion:           map[string]string{"us-east-1": "ami-east"},
+		InstanceType:          "t4g.small",
+		FallbackInstanceTypes: map[string][]string{"us-east-1": {"t4g.medium"}},
+		SubnetsByRegion:       map[string][]string{"us-east-1": {"subnet-a", "subnet-b"}},
+	}, client)
+
+	res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if res.SubnetID != "subnet-b" || res.AvailabilityZone != "us-east-1b" || res.InstanceType != "t4g.small" {
+		t.Fatalf("unexpected placement: %+v", res)
+	}
+	if got := strings.Join(uniqueInOrder(attempts), ","); got != "subnet-a/t4g.small,subnet-b/t4g.small" {
+		t.Fatalf("expected subnet retry before instance type fallback, got %s", got)
+	}
+	if !strings.Contains(metrics.Default().Render(), `aegis_relay_subnet_capacity_retry_total{region="us-east-1"} 1`) {
+		t.Fatal("expected subnet capacity retry metric")
+	}
+}
+
+func TestProvision_SessionSecurityGroupLockedToClientIP(t *testing.T) {
+	var perms []ec2types.IpPermission
+	var groups []string
+	client := &fakeEC2{
+		describeSubnetsFn: func(_ context.Context, _ *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+			return &ec2.DescribeSubnetsOutput{Subnets: []ec2types.Subnet{{SubnetId: aws.String("subnet-a"), VpcId: aws.String("vpc-1")}}}, nil
+		},
+		createSecurityGroupFn: func(_ context.Context, in *ec2.CreateSecurityGroupInput) (*ec2.CreateSecurityGroupOutput, error) {
+			if aws.ToString(in.VpcId) != "vpc-1" || !strings.HasPrefix(aws.ToString(in.GroupName), "aegis-relay-ses_1-") {
+				t.Fatalf("unexpected create input: %+v", in)
+			}
+			return &ec2.CreateSecurityGroupOutput{GroupId: aws.String("sg-session")}, nil
+		},
+		authorizeIngressFn: func(_ context.Context, in *ec2.AuthorizeSecurityGroupIngressInput) (*ec2.AuthorizeSecurityGroupIngressOutput, error) {
+			perms = in.IpPermissions
+			return &ec2.AuthorizeSecurityGroupIngressOutput{}, nil
+		},
+		runInstancesFn: func(_ context.Context, in *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			groups = in.NetworkInterfaces[0].Groups
+			return &ec2.RunInstancesOutput{Instances: []ec2types.Instance{{InstanceId: aws.String("i-sg")}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return runningInstance("i-sg", "198.51.100.50"), nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion:           map[string]string{"us-east-1": "ami-east"},
+		SubnetID:              "subnet-a",
+		SecurityGroup:         []string{"sg-open"},
+		SessionSecurityGroups: true,
+	}, client)
+
+	res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1", ClientIP: "192.0.2.77", SRTPortRange: PortRange{Min: 9000, Max: 9009}, SRTPortCount: 2})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if res.SecurityGroupID != "sg-session" || strings.Join(groups, ",") != "sg-session" {
+		t.Fatalf("expected only the session group attached, got result=%s groups=%v", res.SecurityGroupID, groups)
+	}
+	if len(perms) != 3 {
+		t.Fatalf("expected two SRT rules and a WS rule, got %+v", perms)
+	}
+	for _, perm := range perms {
+		if aws.ToString(perm.IpRanges[0].CidrIp) != "192.0.2.77/32" {
+			t.Fatalf("expected rule locked to client ip, got %+v", perm)
+		}
+	}
+	if aws.ToString(perms[0].IpProtocol) != "udp" || aws.ToInt32(perms[0].FromPort) != 9000 || aws.ToInt32(perms[1].FromPort) != 9001 || aws.ToInt32(perms[2].FromPort) != 7443 {
+		t.Fatalf("unexpected port rules: %+v", perms)
+	}
+}
+
+func TestProvision_SessionSecurityGroupDeletedWhenLaunchFails(t *testing.T) {
+	var deleted []string
+	client := &fakeEC2{
+		createSecurityGroupFn: func(_ context.Context, _ *ec2.CreateSecurityGroupInput) (*ec2.CreateSecurityGroupOutput, error) {
+			return &ec2.CreateSecurityGroupOutput{GroupId: aws.String("sg-session")}, nil
+		},
+		runInstancesFn: func(_ context.Context, _ *ec2.RunInstancesInput) (*ec2.RunInstancesOutput, error) {
+			return nil, &smithy.GenericAPIError{Code: "UnauthorizedOperation", Message: "denied"}
+		},
+		deleteSecurityGroupFn: func(_ context.Context, in *ec2.DeleteSecurityGroupInput) (*ec2.DeleteSecurityGroupOutput, error) {
+			deleted = append(deleted, aws.ToString(in.GroupId))
+			return &ec2.DeleteSecurityGroupOutput{}, nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion:           map[string]string{"us-east-1": "ami-east"},
+		SessionSecurityGroups: true,
+	}, client)
+
+	if _, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1", ClientIP: "2001:db8::7"}); err == nil {
+		t.Fatal("expected launch error")
+	}
+	if strings.Join(deleted, ",") != "sg-session" {
+		t.Fatalf("expected session group cleanup, got %v", deleted)
+	}
+}
+
+func TestDeprovision_SessionSecurityGroupInUseIsRetried(t *testing.T) {
+	client := &fakeEC2{
+		deleteSecurityGroupFn: func(_ context.Context, _ *ec2.DeleteSecurityGroupInput) (*ec2.DeleteSecurityGroupOutput, error) {
+			return nil, &smithy.GenericAPIError{Code: "DependencyViolation", Message: "in use"}
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{AMIByRegion: map[string]string{"us-east-1": "ami-east"}}, client)
+
+	err := p.Deprovision(context.Background(), DeprovisionRequest{Region: "us-east-1", AWSInstanceID: "i-sg", SecurityGroupID: "sg-session"})
+	if err == nil || !strings.Contains(err.Error(), "sg-session") {
+		t.Fatalf("expected security group error so the termination is retried, got %v", err)
+	}
+}
+
+func TestDeprovision_UnconfirmedTerminationIsPending(t *testing.T) {
+	state := ec2types.InstanceStateNameShuttingDown
+	client := &fakeEC2{
+		terminateInstancesFn: func(_ context.Context, in *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+			return &ec2.TerminateInstancesOutput{TerminatingInstances: []ec2types.InstanceStateChange{{
+				InstanceId:   aws.String(in.InstanceIds[0]),
+				CurrentState: &ec2types.InstanceState{Name: ec2types.InstanceStateNameShuttingDown},
+			}}}, nil
+		},
+		describeInstancesFn: func(_ context.Context, _ *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return &ec2.DescribeInstancesOutput{Reservations: []ec2types.Reservation{{
+				Instances: []ec2types.Instance{{InstanceId: aws.String("i-1"), State: &ec2types.InstanceState{Name: state}}},
+			}}}, nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{AMIByRegion: map[string]string{"us-east-1": "ami-east"}}, client)
+	req := DeprovisionRequest{Region: "us-east-1", AWSInstanceID: "i-1"}
+
+	if err := p.Deprovision(context.Background(), req); !errors.Is(err, ErrTerminationPending) {
+		t.Fatalf("expected ErrTerminationPending without verification, got %v", err)
+	}
+
+	// With verification enabled the waiter confirms the termination.
+	if err := p.Reconfigure(AWSProvisionerOptions{AMIByRegion: map[string]string{"us-east-1": "ami-east"}, TerminateVerifyTimeout: time.Second}); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	state = ec2types.InstanceStateNameTerminated
+	if err := p.Deprovision(context.Background(), req); err != nil {
+		t.Fatalf("expected a confirmed termination, got %v", err)
+	}
+}
+
+func TestDeprovision_TagsReasonBestEffort(t *testing.T) {
+	var tagged []ec2types.Tag
+	terminated := false
+	client := &fakeEC2{
+		createTagsFn: func(_ context.Context, in *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+			tagged = append(tagged, in.Tags...)
+			return nil, &smithy.GenericAPIError{Code: "UnauthorizedOperation", Message: "denied"}
+		},
+		terminateInstancesFn: func(_ context.Context, in *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+			terminated = true
+			return &ec2.TerminateInstancesOutput{TerminatingInstances: []ec2types.InstanceStateChange{{
+				InstanceId:   aws.String(in.InstanceIds[0]),
+				CurrentState: &ec2types.InstanceState{Name: ec2types.InstanceStateNameTerminated},
+			}}}, nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{AMIByRegion: map[string]string{"us-east-1": "ami-east"}}, client)
+
+	err := p.Deprovision(context.Background(), DeprovisionRequest{Region: "us-east-1", AWSInstanceID: "i-1", Reason: "compensation"})
+	if err != nil || !terminated {
+		t.Fatalf("expected the instance terminated despite the tag failure, got terminated=%t err=%v", terminated, err)
+	}
+	if len(tagged) != 1 || aws.ToString(tagged[0].Key) != "AegisTerminateReason" || aws.ToString(tagged[0].Value) != "compensation" {
+		t.Fatalf("expected the reason tagged, got %+v", tagged)
+	}
+}
+
+func TestAuthorizeClientIP_ReplacesIngressRules(t *testing.T) {
+	var revoked, authorized []ec2types.IpPermission
+	old := []ec2types.IpPermission{{IpProtocol: aws.String("udp"), FromPort: aws.Int32(9000), ToPort: aws.Int32(9000),
+		IpRanges: []ec2types.IpRange{{CidrIp: aws.String("192.0.2.77/32")}}}}
+	client := &fakeEC2{
+		describeSecurityGroupsFn: func(_ context.Context, _ *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+			return &ec2.DescribeSecurityGroupsOutput{SecurityGroups: []ec2types.SecurityGroup{{GroupId: aws.String("sg-session"), IpPermissions: old}}}, nil
+		},
+		revokeIngressFn: func(_ context.Context, in *ec2.RevokeSecurityGroupIngressInput) (*ec2.RevokeSecurityGroupIngressOutput, error) {
+			revoked = in.IpPermissions
+			return &ec2.RevokeSecurityGroupIngressOutput{}, nil
+		},
+		authorizeIngressFn: func(_ context.Context, in *ec2.AuthorizeSecurityGroupIngressInput) (*ec2.AuthorizeSecurityGroupIngressOutput, error) {
+			authorized = in.IpPermissions
+			return &ec2.AuthorizeSecurityGroupIngressOutput{}, nil
+		},
+	}
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{AMIByRegion: map[string]string{"us-east-1": "ami-east"}}, client)
+
+	err := p.AuthorizeClientIP(context.Background(), AuthorizeClientIPRequest{Region: "us-east-1", SecurityGroupID: "sg-session", ClientIP: "198.51.100.88", SRTPorts: []int{9000}})
+	if err != nil {
+		t.Fatalf("AuthorizeClientIP: %v", err)
+	}
+	if len(revoked) != 1 || len(authorized) != 2 || aws.ToString(authorized[0].IpRanges[0].CidrIp) != "198.51.100.88/32" {
+		t.Fatalf("unexpected rule swap: revoked=%+v authorized=%+v", revoked, authorized)
+	}
+}
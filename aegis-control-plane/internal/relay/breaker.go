@@ -0,0 +1,182 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+)
+
+// ErrProviderUnavailable means calls to the provider kept failing and are
+// being short-circuited until a cooldown passes.
+var ErrProviderUnavailable = errors.New("provider unavailable")
+
+// UnavailableError is returned without calling the provider while a circuit
+// is open. It matches ErrProviderUnavailable.
+type UnavailableError struct {
+	Op         string
+	Region     string
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%s in %s: %v, retry after %s", e.Op, e.Region, ErrProviderUnavailable, e.RetryAfter)
+}
+
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrProviderUnavailable
+}
+
+// Circuit states, exported as aegis_aws_circuit_state values 0, 1 and 2.
+const (
+	circuitClosed   = "closed"
+	circuitHalfOpen = "half_open"
+	circuitOpen     = "open"
+)
+
+const (
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerCooldown         = 30 * time.Second
+)
+
+type breakerKey struct {
+	op     string
+	region string
+}
+
+type circuit struct {
+	state    string
+	failures int
+	openedAt time.Time
+	// probing is set while the single half-open trial call is in flight.
+	probing bool
+}
+
+// breakerSet keeps one circuit per (op, region). A circuit opens after
+// failureThreshold consecutive failed calls, fails fast for cooldown, then
+// lets one trial call through; its outcome closes or reopens the circuit.
+type breakerSet struct {
+	mu               sync.Mutex
+	now              func() time.Time
+	failureThreshold int
+	cooldown         time.Duration
+	circuits         map[breakerKey]*circuit
+}
+
+func newBreakerSet(failureThreshold int, cooldown time.Duration) *breakerSet {
+	b := &breakerSet{now: time.Now, circuits: make(map[breakerKey]*circuit)}
+	b.configure(failureThreshold, cooldown)
+	return b
+}
+
+func (b *breakerSet) configure(failureThreshold int, cooldown time.Duration) {
+	if failureThreshold <= 0 {
+		failureThreshold = defaultBreakerFailureThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failureThreshold = failureThreshold
+	b.cooldown = cooldown
+}
+
+// allow returns an *UnavailableError when the call must not be made. Every
+// allowed call must be followed by record.
+func (b *breakerSet) allow(op, region string) error {
+	key := breakerKey{op: op, region: region}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuits[key]
+	if c == nil {
+		return nil
+	}
+	switch c.state {
+	case circuitOpen:
+		if wait := c.openedAt.Add(b.cooldown).Sub(b.now()); wait > 0 {
+			return &UnavailableError{Op: op, Region: region, RetryAfter: wait}
+		}
+		b.transition(key, c, circuitHalfOpen)
+		c.probing = true
+	case circuitHalfOpen:
+		if c.probing {
+			return &UnavailableError{Op: op, Region: region, RetryAfter: b.cooldown}
+		}
+		c.probing = true
+	}
+	return nil
+}
+
+// record feeds the outcome of an allowed call back into its circuit.
+func (b *breakerSet) record(op, region string, err error) {
+	key := breakerKey{op: op, region: region}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuits[key]
+	if c == nil {
+		if !isBreakerFailure(err) {
+			return
+		}
+		c = &circuit{state: circuitClosed}
+		b.circuits[key] = c
+	}
+	c.probing = false
+	switch {
+	case errors.Is(err, context.Canceled):
+		// The caller gave up; the call says nothing about the provider.
+	case !isBreakerFailure(err):
+		c.failures = 0
+		if c.state != circuitClosed {
+			b.transition(key, c, circuitClosed)
+		}
+	default:
+		c.failures++
+		if c.state == circuitHalfOpen || c.failures >= b.failureThreshold {
+			c.openedAt = b.now()
+			if c.state != circuitOpen {
+				b.transition(key, c, circuitOpen)
+			}
+		}
+	}
+}
+
+func (b *breakerSet) transition(key breakerKey, c *circuit, to string) {
+	log.Printf("event=aws_circuit_transition op=%s region=%s from=%s to=%s failures=%d", key.op, key.region, c.state, to, c.failures)
+	c.state = to
+	labels := map[string]string{"op": key.op, "region": key.region}
+	metrics.Default().SetGauge("aegis_aws_circuit_state", circuitStateValue(to), labels)
+	metrics.Default().IncCounter("aegis_aws_circuit_transitions_total", map[string]string{"op": key.op, "region": key.region, "to": to})
+}
+
+func circuitStateValue(state string) float64 {
+	switch state {
+	case circuitHalfOpen:
+		return 1
+	case circuitOpen:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// isBreakerFailure reports whether err suggests the provider itself is
+// unhealthy: throttling, server errors, or no answer at all. Capacity
+// errors are excluded; they are specific to an instance type and handled by
+// fallback.
+func isBreakerFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return true
+	}
+	return isTransientAWSError(err) && !isCapacityError(err)
+}
@@ -0,0 +1,128 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+)
+
+func TestBreaker_ClosedOpenHalfOpenClosed(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	now := time.Unix(1_700_000_000, 0)
+	b := newBreakerSet(2, 30*time.Second)
+	b.now = func() time.Time { return now }
+	throttled := &smithy.GenericAPIError{Code: "RequestLimitExceeded", Message: "slow down"}
+
+	for i := 0; i < 2; i++ {
+		if err := b.allow("run_instances", "us-east-1"); err != nil {
+			t.Fatalf("closed circuit rejected call %d: %v", i, err)
+		}
+		b.record("run_instances", "us-east-1", throttled)
+	}
+	err := b.allow("run_instances", "us-east-1")
+	var unavailable *UnavailableError
+	if !errors.As(err, &unavailable) || !errors.Is(err, ErrProviderUnavailable) || unavailable.RetryAfter != 30*time.Second {
+		t.Fatalf("expected open circuit to fail fast, got %v", err)
+	}
+	if err := b.allow("run_instances", "eu-west-1"); err != nil {
+		t.Fatalf("other regions must be unaffected, got %v", err)
+	}
+
+	// After the cooldown one trial call goes through; others still fail.
+	now = now.Add(30 * time.Second)
+	if err := b.allow("run_instances", "us-east-1"); err != nil {
+		t.Fatalf("expected half-open trial call, got %v", err)
+	}
+	if err := b.allow("run_instances", "us-east-1"); !errors.Is(err, ErrProviderUnavailable) {
+		t.Fatalf("expected concurrent calls rejected while half-open, got %v", err)
+	}
+	b.record("run_instances", "us-east-1", throttled)
+	if err := b.allow("run_instances", "us-east-1"); !errors.Is(err, ErrProviderUnavailable) {
+		t.Fatalf("expected failed trial to reopen the circuit, got %v", err)
+	}
+
+	now = now.Add(30 * time.Second)
+	if err := b.allow("run_instances", "us-east-1"); err != nil {
+		t.Fatalf("expected half-open trial call, got %v", err)
+	}
+	b.record("run_instances", "us-east-1", nil)
+	if err := b.allow("run_instances", "us-east-1"); err != nil {
+		t.Fatalf("expected successful trial to close the circuit, got %v", err)
+	}
+
+	out := metrics.Default().Render()
+	for _, want := range []string{
+		`aegis_aws_circuit_state{op="run_instances",region="us-east-1"} 0`,
+		`aegis_aws_circuit_transitions_total{op="run_instances",region="us-east-1",to="open"} 2`,
+		`aegis_aws_circuit_transitions_total{op="run_instances",region="us-east-1",to="half_open"} 2`,
+		`aegis_aws_circuit_transitions_total{op="run_instances",region="us-east-1",to="closed"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %s in:\n%s", want, out)
+		}
+	}
+}
+
+func TestBreaker_IgnoresClientAndCapacityErrors(t *testing.T) {
+	b := newBreakerSet(1, time.Minute)
+	for _, err := range []error{
+		&smithy.GenericAPIError{Code: "InvalidParameterValue", Message: "bad"},
+		&smithy.GenericAPIError{Code: "InsufficientInstanceCapacity", Message: "none"},
+		context.Canceled,
+	} {
+		b.record("run_instances", "us-east-1", err)
+	}
+	if err := b.allow("run_instances", "us-east-1"); err != nil {
+		t.Fatalf("expected circuit to stay closed, got %v", err)
+	}
+}
+
+func TestRetryAWS_FailsFastWhileCircuitOpen(t *testing.T) {
+	p := newTestAWSProvisioner(t, AWSProvisionerOptions{
+		AMIByRegion:             map[string]string{"us-east-1": "ami-1"},
+		BreakerFailureThreshold: 1,
+		BreakerCooldown:         time.Minute,
+	}, &fakeEC2{})
+	calls := 0
+	fn := func(context.Context) error {
+		calls++
+		return &smithy.GenericAPIError{Code: "ServiceUnavailable", Message: "down"}
+	}
+	if err := p.retryAWS(context.Background(), "describe_instances", "us-east-1", fn); errors.Is(err, ErrProviderUnavailable) {
+		t.Fatalf("first call should reach AWS, got %v", err)
+	}
+	attempts := calls
+	if err := p.retryAWS(context.Background(), "describe_instances", "us-east-1", fn); !errors.Is(err, ErrProviderUnavailable) {
+		t.Fatalf("expected ErrProviderUnavailable, got %v", err)
+	}
+	if calls != attempts {
+		t.Fatalf("expected no AWS call while open, got %d extra", calls-attempts)
+	}
+}
+
+func TestRetryAWS_CircuitsArePerProvisioner(t *testing.T) {
+	opts := AWSProvisionerOptions{AMIByRegion: map[string]string{"us-east-1": "ami-1"}, BreakerFailureThreshold: 1, BreakerCooldown: time.Minute}
+	tripped := newTestAWSProvisioner(t, opts, &fakeEC2{})
+	opts.BreakerFailureThreshold = 100
+	other := newTestAWSProvisioner(t, opts, &fakeEC2{})
+
+	down := func(context.Context) error {
+		return &smithy.GenericAPIError{Code: "ServiceUnavailable", Message: "down"}
+	}
+	_ = tripped.retryAWS(context.Background(), "describe_instances", "us-east-1", down)
+	if err := tripped.retryAWS(context.Background(), "describe_instances", "us-east-1", down); !errors.Is(err, ErrProviderUnavailable) {
+		t.Fatalf("expected the tripped circuit open, got %v", err)
+	}
+	if err := other.retryAWS(context.Background(), "describe_instances", "us-east-1", func(context.Context) error { return nil }); err != nil {
+		t.Fatalf("expected the other provisioner's circuit closed, got %v", err)
+	}
+	if tripped.breakers.failureThreshold != 1 {
+		t.Fatalf("expected the first threshold kept, got %d", tripped.breakers.failureThreshold)
+	}
+}
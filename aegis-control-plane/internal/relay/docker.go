@@ -0,0 +1,316 @@
+package relay
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Container labels identifying relays launched by DockerProvisioner.
+const (
+	dockerLabelManaged   = "aegis.managed"
+	dockerLabelSessionID = "aegis.session_id"
+	dockerLabelUserID    = "aegis.user_id"
+	dockerLabelRegion    = "aegis.region"
+	dockerLabelHostPort  = "aegis.host_port"
+)
+
+// dockerRelayHost is the address mapped relay ports are published on.
+const dockerRelayHost = "127.0.0.1"
+
+// dockerStopTimeout is how long a relay container gets to exit before it is
+// killed.
+const dockerStopTimeout = 10 * time.Second
+
+var errDockerNotFound = errors.New("docker: no such object")
+
+// dockerAPI is the subset of the Docker Engine API the provisioner uses.
+type dockerAPI interface {
+	CreateContainer(ctx context.Context, name string, spec dockerContainerSpec) (string, error)
+	CopyToContainer(ctx context.Context, id, dir string, archive []byte) error
+	StartContainer(ctx context.Context, id string) error
+	InspectContainer(ctx context.Context, id string) (dockerContainer, error)
+	StopContainer(ctx context.Context, id string, timeout time.Duration) error
+	RemoveContainer(ctx context.Context, id string) error
+	// ListContainers returns containers, stopped ones included, carrying
+	// the label.
+	ListContainers(ctx context.Context, label string) ([]dockerContainer, error)
+	InspectImage(ctx context.Context, image string) error
+}
+
+type dockerContainerSpec struct {
+	Image  string
+	Labels map[string]string
+	// Ports maps container ports ("9000/udp") to host ports.
+	Ports map[string]int
+}
+
+type dockerContainer struct {
+	ID      string
+	State   string
+	Labels  map[string]string
+	Created time.Time
+}
+
+type DockerProvisionerOptions struct {
+	// Host is the Docker Engine endpoint, unix:///path or tcp://host:port.
+	Host string
+	// Image is the relay container image.
+	Image string
+	// PortMin and PortMax bound the host ports relays are published on. Each
+	// relay takes one port, for SRT over UDP and telemetry over TCP.
+	PortMin int
+	PortMax int
+}
+
+// DockerProvisioner runs relays as local containers for end-to-end
+// development. Relays are reachable on 127.0.0.1 at their mapped port.
+type DockerProvisioner struct {
+	image   string
+	portMin int
+	portMax int
+	client  dockerAPI
+
+	// mu serializes port allocation with container creation.
+	mu sync.Mutex
+}
+
+func NewDockerProvisioner(opts DockerProvisionerOptions) (*DockerProvisioner, error) {
+	if opts.Image == "" {
+		return nil, errors.New("docker relay image is required")
+	}
+	if opts.PortMin <= 0 || opts.PortMax < opts.PortMin || opts.PortMax > 65535 {
+		return nil, fmt.Errorf("invalid docker relay port range %d-%d", opts.PortMin, opts.PortMax)
+	}
+	client, err := newDockerEngine(opts.Host)
+	if err != nil {
+		return nil, err
+	}
+	return &DockerProvisioner{image: opts.Image, portMin: opts.PortMin, portMax: opts.PortMax, client: client}, nil
+}
+
+func (p *DockerProvisioner) Provision(ctx context.Context, req ProvisionRequest) (ProvisionResult, error) {
+	doc, err := bootstrapDoc(req)
+	if err != nil {
+		return ProvisionResult{}, err
+	}
+	archive, err := bootstrapArchive(doc)
+	if err != nil {
+		return ProvisionResult{}, err
+	}
+	suffix, err := randomUint8()
+	if err != nil {
+		return ProvisionResult{}, err
+	}
+
+	// The relay binds its SRT ports inside the container; each is published
+	// on a host port of its own, the first also carrying telemetry.
+	srtPorts := req.srtPorts()
+	p.mu.Lock()
+	hostPorts, err := p.freePorts(ctx, len(srtPorts))
+	if err != nil {
+		p.mu.Unlock()
+		return ProvisionResult{}, err
+	}
+	port := hostPorts[0]
+	published := map[string]int{strconv.Itoa(relayWSPort) + "/tcp": port}
+	labelPorts := make([]string, len(hostPorts))
+	for i, hostPort := range hostPorts {
+		published[strconv.Itoa(srtPorts[i])+"/udp"] = hostPort
+		labelPorts[i] = strconv.Itoa(hostPort)
+	}
+	// Replacement relays share the session ID, so the name carries a
+	// random suffix to stay unique.
+	name := fmt.Sprintf("aegis-relay-%s-%02x", req.SessionID, suffix)
+	id, err := p.client.CreateContainer(ctx, name, dockerContainerSpec{
+		Image: p.image,
+		Labels: map[string]string{
+			dockerLabelManaged:   "true",
+			dockerLabelSessionID: req.SessionID,
+			dockerLabelUserID:    req.UserID,
+			dockerLabelRegion:    req.Region,
+			dockerLabelHostPort:  strings.Join(labelPorts, ","),
+		},
+		Ports: published,
+	})
+	p.mu.Unlock()
+	if err != nil {
+		return ProvisionResult{}, fmt.Errorf("create relay container: %w", err)
+	}
+
+	if err := p.client.CopyToContainer(ctx, id, "/", archive); err != nil {
+		p.cleanup(ctx, req, id)
+		return ProvisionResult{}, fmt.Errorf("write relay bootstrap: %w", err)
+	}
+	if err := p.client.StartContainer(ctx, id); err != nil {
+		p.cleanup(ctx, req, id)
+		return ProvisionResult{}, fmt.Errorf("start relay container: %w", err)
+	}
+	log.Printf("event=docker_relay_started session_id=%s container_id=%s port=%d", req.SessionID, id, port)
+	return ProvisionResult{
+		Region:        req.Region,
+		AWSInstanceID: id,
+		AMIID:         p.image,
+		InstanceType:  "docker",
+		Lifecycle:     LifecycleOnDemand,
+		PublicIP:      dockerRelayHost,
+		SRTPort:       port,
+		SRTPorts:      hostPorts,
+		WSURL:         relayWSURLAt(dockerRelayHost, port),
+	}, nil
+}
+
+// freePorts returns the lowest n ports in range not held by a relay
+// container.
+func (p *DockerProvisioner) freePorts(ctx context.Context, n int) ([]int, error) {
+	containers, err := p.client.ListContainers(ctx, dockerLabelManaged+"=true")
+	if err != nil {
+		return nil, fmt.Errorf("list relay containers: %w", err)
+	}
+	used := make(map[int]bool, len(containers))
+	for _, c := range containers {
+		for _, v := range strings.Split(c.Labels[dockerLabelHostPort], ",") {
+			if port, err := strconv.Atoi(v); err == nil {
+				used[port] = true
+			}
+		}
+	}
+	var ports []int
+	for port := p.portMin; port <= p.portMax && len(ports) < n; port++ {
+		if !used[port] {
+			ports = append(ports, port)
+		}
+	}
+	if len(ports) < n {
+		return nil, fmt.Errorf("no %d free relay ports in %d-%d", n, p.portMin, p.portMax)
+	}
+	return ports, nil
+}
+
+func (p *DockerProvisioner) cleanup(ctx context.Context, req ProvisionRequest, id string) {
+	if err := p.client.RemoveContainer(ctx, id); err != nil && !errors.Is(err, errDockerNotFound) {
+		log.Printf("event=docker_relay_cleanup_failed session_id=%s container_id=%s err=%q", req.SessionID, id, err.Error())
+	}
+}
+
+func (p *DockerProvisioner) Deprovision(ctx context.Context, req DeprovisionRequest) error {
+	if req.AWSInstanceID == "" {
+		return nil
+	}
+	if err := p.client.StopContainer(ctx, req.AWSInstanceID, dockerStopTimeout); err != nil && !errors.Is(err, errDockerNotFound) {
+		return fmt.Errorf("stop relay container: %w", err)
+	}
+	if err := p.client.RemoveContainer(ctx, req.AWSInstanceID); err != nil && !errors.Is(err, errDockerNotFound) {
+		return fmt.Errorf("remove relay container: %w", err)
+	}
+	return nil
+}
+
+func (p *DockerProvisioner) Status(ctx context.Context, req StatusRequest) (StatusResult, error) {
+	c, err := p.client.InspectContainer(ctx, req.AWSInstanceID)
+	if errors.Is(err, errDockerNotFound) {
+		return StatusResult{State: InstanceTerminated}, nil
+	}
+	if err != nil {
+		return StatusResult{}, err
+	}
+	res := StatusResult{State: dockerState(c.State), LaunchedAt: c.Created}
+	if res.State == InstanceRunning {
+		res.PublicIP = dockerRelayHost
+	}
+	return res, nil
+}
+
+// dockerState folds Docker container states into instance states. A relay
+// container that exited is as good as terminated; nothing restarts it.
+func dockerState(state string) string {
+	switch state {
+	case "created", "restarting":
+		return InstancePending
+	case "running":
+		return InstanceRunning
+	case "paused":
+		return InstanceStopped
+	case "removing":
+		return InstanceShuttingDown
+	default:
+		return InstanceTerminated
+	}
+}
+
+// DockerRelay is a relay container found by its labels.
+type DockerRelay struct {
+	ContainerID string
+	SessionID   string
+	UserID      string
+	Region      string
+	State       string
+	CreatedAt   time.Time
+}
+
+// List returns every relay container, stopped ones included, so leftovers
+// from crashed sessions can be found and removed.
+func (p *DockerProvisioner) List(ctx context.Context) ([]DockerRelay, error) {
+	containers, err := p.client.ListContainers(ctx, dockerLabelManaged+"=true")
+	if err != nil {
+		return nil, err
+	}
+	out := make([]DockerRelay, 0, len(containers))
+	for _, c := range containers {
+		out = append(out, DockerRelay{
+			ContainerID: c.ID,
+			SessionID:   c.Labels[dockerLabelSessionID],
+			UserID:      c.Labels[dockerLabelUserID],
+			Region:      c.Labels[dockerLabelRegion],
+			State:       dockerState(c.State),
+			CreatedAt:   c.Created,
+		})
+	}
+	return out, nil
+}
+
+// AuthorizeClientIP is a no-op: local relays only listen on loopback.
+func (p *DockerProvisioner) AuthorizeClientIP(_ context.Context, _ AuthorizeClientIPRequest) error {
+	return nil
+}
+
+// ValidateConfig checks that the Docker daemon is reachable and has the
+// relay image. Regions are labels only, so no problem is region-scoped.
+func (p *DockerProvisioner) ValidateConfig(ctx context.Context, _ []string) []error {
+	if err := p.client.InspectImage(ctx, p.image); err != nil {
+		if errors.Is(err, errDockerNotFound) {
+			return []error{fmt.Errorf("docker relay image %s not found; pull or build it first", p.image)}
+		}
+		return []error{fmt.Errorf("inspect docker relay image %s: %w", p.image, err)}
+	}
+	return nil
+}
+
+// bootstrapArchive packs the relay bootstrap document as a tar archive
+// rooted at /, the format the Docker archive endpoint expects.
+func bootstrapArchive(doc []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	dir := strings.TrimPrefix(path.Dir(bootstrapPath), "/")
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: 0o700}); err != nil {
+		return nil, err
+	}
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: strings.TrimPrefix(bootstrapPath, "/"), Mode: 0o600, Size: int64(len(doc))}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(doc); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,183 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dockerAPIVersion is the Engine API version requested; 1.41 ships with
+// Docker 20.10 and later.
+const dockerAPIVersion = "v1.41"
+
+// DefaultDockerHost is the local Docker daemon socket.
+const DefaultDockerHost = "unix:///var/run/docker.sock"
+
+// dockerEngine talks to the Docker Engine API over HTTP.
+type dockerEngine struct {
+	client *http.Client
+	base   string
+}
+
+func newDockerEngine(host string) (*dockerEngine, error) {
+	if host == "" {
+		host = DefaultDockerHost
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host %q: %w", host, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &dockerEngine{client: &http.Client{Transport: transport}, base: "http://docker/" + dockerAPIVersion}, nil
+	case "tcp", "http":
+		return &dockerEngine{client: &http.Client{}, base: "http://" + u.Host + "/" + dockerAPIVersion}, nil
+	default:
+		return nil, fmt.Errorf("unsupported docker host scheme %q", u.Scheme)
+	}
+}
+
+// do sends a request and decodes a JSON response into out, if given. A 404
+// is reported as errDockerNotFound.
+func (e *dockerEngine) do(ctx context.Context, method, path string, query url.Values, contentType string, body io.Reader, out any) error {
+	target := e.base + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errDockerNotFound
+	}
+	// 304 means the container was already started or stopped.
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotModified {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("docker %s %s: status %d: %s", method, path, resp.StatusCode, apiErr.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (e *dockerEngine) CreateContainer(ctx context.Context, name string, spec dockerContainerSpec) (string, error) {
+	type portBinding struct {
+		HostIP   string `json:"HostIp"`
+		HostPort string `json:"HostPort"`
+	}
+	exposed := make(map[string]struct{}, len(spec.Ports))
+	bindings := make(map[string][]portBinding, len(spec.Ports))
+	for containerPort, hostPort := range spec.Ports {
+		exposed[containerPort] = struct{}{}
+		bindings[containerPort] = []portBinding{{HostIP: dockerRelayHost, HostPort: strconv.Itoa(hostPort)}}
+	}
+	body, err := json.Marshal(map[string]any{
+		"Image":        spec.Image,
+		"Labels":       spec.Labels,
+		"ExposedPorts": exposed,
+		"HostConfig": map[string]any{
+			"PortBindings": bindings,
+			// Lets the relay reach a control plane running on the host.
+			"ExtraHosts": []string{"host.docker.internal:host-gateway"},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	var out struct {
+		ID string `json:"Id"`
+	}
+	if err := e.do(ctx, http.MethodPost, "/containers/create", url.Values{"name": {name}}, "application/json", bytes.NewReader(body), &out); err != nil {
+		return "", err
+	}
+	return out.ID, nil
+}
+
+func (e *dockerEngine) CopyToContainer(ctx context.Context, id, dir string, archive []byte) error {
+	return e.do(ctx, http.MethodPut, "/containers/"+url.PathEscape(id)+"/archive", url.Values{"path": {dir}}, "application/x-tar", bytes.NewReader(archive), nil)
+}
+
+func (e *dockerEngine) StartContainer(ctx context.Context, id string) error {
+	return e.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/start", nil, "", nil, nil)
+}
+
+func (e *dockerEngine) InspectContainer(ctx context.Context, id string) (dockerContainer, error) {
+	var out struct {
+		ID      string `json:"Id"`
+		Created time.Time
+		State   struct {
+			Status string
+		}
+		Config struct {
+			Labels map[string]string
+		}
+	}
+	if err := e.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(id)+"/json", nil, "", nil, &out); err != nil {
+		return dockerContainer{}, err
+	}
+	return dockerContainer{ID: out.ID, State: out.State.Status, Labels: out.Config.Labels, Created: out.Created}, nil
+}
+
+func (e *dockerEngine) StopContainer(ctx context.Context, id string, timeout time.Duration) error {
+	query := url.Values{"t": {strconv.Itoa(int(timeout.Seconds()))}}
+	return e.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/stop", query, "", nil, nil)
+}
+
+func (e *dockerEngine) RemoveContainer(ctx context.Context, id string) error {
+	return e.do(ctx, http.MethodDelete, "/containers/"+url.PathEscape(id), url.Values{"force": {"true"}}, "", nil, nil)
+}
+
+func (e *dockerEngine) ListContainers(ctx context.Context, label string) ([]dockerContainer, error) {
+	filters, err := json.Marshal(map[string][]string{"label": {label}})
+	if err != nil {
+		return nil, err
+	}
+	var out []struct {
+		ID      string `json:"Id"`
+		Created int64
+		State   string
+		Labels  map[string]string
+	}
+	if err := e.do(ctx, http.MethodGet, "/containers/json", url.Values{"all": {"true"}, "filters": {string(filters)}}, "", nil, &out); err != nil {
+		return nil, err
+	}
+	containers := make([]dockerContainer, 0, len(out))
+	for _, c := range out {
+		containers = append(containers, dockerContainer{ID: c.ID, State: c.State, Labels: c.Labels, Created: time.Unix(c.Created, 0)})
+	}
+	return containers, nil
+}
+
+func (e *dockerEngine) InspectImage(ctx context.Context, image string) error {
+	// Image references may contain slashes, which the endpoint accepts
+	// unescaped.
+	return e.do(ctx, http.MethodGet, "/images/"+strings.TrimPrefix(image, "/")+"/json", nil, "", nil, nil)
+}
@@ -0,0 +1,196 @@
+package relay
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeDocker keeps containers in memory.
+type fakeDocker struct {
+	containers map[string]*dockerContainer
+	archives   map[string][]byte
+	ports      map[string]map[string]int
+	startErr   error
+	nextID     int
+}
+
+func newFakeDocker() *fakeDocker {
+	return &fakeDocker{containers: make(map[string]*dockerContainer), archives: make(map[string][]byte), ports: make(map[string]map[string]int)}
+}
+
+func (d *fakeDocker) CreateContainer(_ context.Context, _ string, spec dockerContainerSpec) (string, error) {
+	d.nextID++
+	id := fmt.Sprintf("c%d", d.nextID)
+	d.containers[id] = &dockerContainer{ID: id, State: "created", Labels: spec.Labels, Created: time.Unix(1_700_000_000, 0)}
+	d.ports[id] = spec.Ports
+	return id, nil
+}
+
+func (d *fakeDocker) CopyToContainer(_ context.Context, id, _ string, archive []byte) error {
+	d.archives[id] = archive
+	return nil
+}
+
+func (d *fakeDocker) StartContainer(_ context.Context, id string) error {
+	if d.startErr != nil {
+		return d.startErr
+	}
+	d.containers[id].State = "running"
+	return nil
+}
+
+func (d *fakeDocker) InspectContainer(_ context.Context, id string) (dockerContainer, error) {
+	c, ok := d.containers[id]
+	if !ok {
+		return dockerContainer{}, errDockerNotFound
+	}
+	return *c, nil
+}
+
+func (d *fakeDocker) StopContainer(_ context.Context, id string, _ time.Duration) error {
+	c, ok := d.containers[id]
+	if !ok {
+		return errDockerNotFound
+	}
+	c.State = "exited"
+	return nil
+}
+
+func (d *fakeDocker) RemoveContainer(_ context.Context, id string) error {
+	if _, ok := d.containers[id]; !ok {
+		return errDockerNotFound
+	}
+	delete(d.containers, id)
+	return nil
+}
+
+func (d *fakeDocker) ListContainers(_ context.Context, _ string) ([]dockerContainer, error) {
+	var out []dockerContainer
+	for _, c := range d.containers {
+		out = append(out, *c)
+	}
+	return out, nil
+}
+
+func (d *fakeDocker) InspectImage(_ context.Context, _ string) error {
+	return nil
+}
+
+func newTestDockerProvisioner(client dockerAPI) *DockerProvisioner {
+	return &DockerProvisioner{image: "telemy/relay:dev", portMin: 20000, portMax: 20001, client: client}
+}
+
+func TestDockerProvision_PublishesRelayOnLoopback(t *testing.T) {
+	docker := newFakeDocker()
+	p := newTestDockerProvisioner(docker)
+
+	res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", UserID: "usr_1", Region: "us-east-1", RelayAuthToken: "tok"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if res.PublicIP != "127.0.0.1" || res.SRTPort != 20000 || res.WSURL != "wss://127.0.0.1:20000/telemetry" {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	labels := docker.containers[res.AWSInstanceID].Labels
+	if labels[dockerLabelSessionID] != "ses_1" || labels[dockerLabelUserID] != "usr_1" || labels[dockerLabelHostPort] != "20000" {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+
+	tr := tar.NewReader(bytes.NewReader(docker.archives[res.AWSInstanceID]))
+	var doc relayBootstrap
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("bootstrap archive: %v", err)
+		}
+		if hdr.Name == "etc/aegis/relay.json" {
+			raw, _ := io.ReadAll(tr)
+			if err := json.Unmarshal(raw, &doc); err != nil {
+				t.Fatalf("decode bootstrap: %v", err)
+			}
+			break
+		}
+	}
+	if doc.SessionID != "ses_1" || doc.RelayAuthToken != "tok" || doc.SRTPort != DefaultSRTPort {
+		t.Fatalf("unexpected bootstrap %+v", doc)
+	}
+
+	// The next relay takes the next port; a full range fails.
+	res2, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_2", Region: "us-east-1"})
+	if err != nil || res2.SRTPort != 20001 {
+		t.Fatalf("expected the second port, got %+v err=%v", res2, err)
+	}
+	if _, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_3", Region: "us-east-1"}); err == nil {
+		t.Fatal("expected an exhausted port range to fail")
+	}
+}
+
+func TestDockerProvision_PublishesEachSRTPort(t *testing.T) {
+	docker := newFakeDocker()
+	p := &DockerProvisioner{image: "telemy/relay:dev", portMin: 20000, portMax: 20002, client: docker}
+
+	req := ProvisionRequest{SessionID: "ses_1", Region: "us-east-1", SRTPortRange: PortRange{Min: 9000, Max: 9009}, SRTPortCount: 2}
+	res, err := p.Provision(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if res.SRTPort != 20000 || !slices.Equal(res.SRTPorts, []int{20000, 20001}) {
+		t.Fatalf("expected two host ports, got %d %v", res.SRTPort, res.SRTPorts)
+	}
+	ports, labels := docker.ports[res.AWSInstanceID], docker.containers[res.AWSInstanceID].Labels
+	if ports["9000/udp"] != 20000 || ports["9001/udp"] != 20001 || ports["7443/tcp"] != 20000 || labels[dockerLabelHostPort] != "20000,20001" {
+		t.Fatalf("unexpected container ports %v labels %v", ports, labels)
+	}
+	// One host port is left, too few for another relay.
+	if _, err := p.Provision(context.Background(), req); err == nil {
+		t.Fatal("expected a relay that needs two ports to fail")
+	}
+}
+
+func TestDockerProvision_RemovesContainerThatFailsToStart(t *testing.T) {
+	docker := newFakeDocker()
+	docker.startErr = errors.New("port is already allocated")
+	p := newTestDockerProvisioner(docker)
+
+	if _, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"}); err == nil {
+		t.Fatal("expected Provision to fail")
+	}
+	if len(docker.containers) != 0 {
+		t.Fatalf("expected the container to be removed, got %d", len(docker.containers))
+	}
+}
+
+func TestDockerDeprovision_RemovesContainerAndReportsTerminated(t *testing.T) {
+	docker := newFakeDocker()
+	p := newTestDockerProvisioner(docker)
+	res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if status, err := p.Status(context.Background(), StatusRequest{AWSInstanceID: res.AWSInstanceID}); err != nil || status.State != InstanceRunning {
+		t.Fatalf("expected a running relay, got %+v err=%v", status, err)
+	}
+	relays, err := p.List(context.Background())
+	if err != nil || len(relays) != 1 || relays[0].SessionID != "ses_1" {
+		t.Fatalf("unexpected relays %+v err=%v", relays, err)
+	}
+
+	if err := p.Deprovision(context.Background(), DeprovisionRequest{AWSInstanceID: res.AWSInstanceID}); err != nil {
+		t.Fatalf("Deprovision: %v", err)
+	}
+	// A second deprovision of a removed container is a no-op.
+	if err := p.Deprovision(context.Background(), DeprovisionRequest{AWSInstanceID: res.AWSInstanceID}); err != nil {
+		t.Fatalf("repeat Deprovision: %v", err)
+	}
+	if status, err := p.Status(context.Background(), StatusRequest{AWSInstanceID: res.AWSInstanceID}); err != nil || status.State != InstanceTerminated {
+		t.Fatalf("expected a terminated relay, got %+v err=%v", status, err)
+	}
+}
@@ -4,34 +4,232 @@ import (
 	"context"
 	"crypto/rand"
 	"fmt"
+	"log"
+	mathrand "math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go"
 )
 
-type FakeProvisioner struct{}
+// FakeProvisioner hands out made-up relays for local development. Options
+// make it slow or unreliable on demand for load and chaos testing.
+type FakeProvisioner struct {
+	minLatency  time.Duration
+	maxLatency  time.Duration
+	failureRate float64
+	errorCodes  []string
+	failFirst   int
+	wsTemplate  WSTemplate
+
+	mu        sync.Mutex
+	calls     int
+	failures  int
+	instances map[string]*FakeInstance
+	random    func() float64
+}
+
+// FakeInstance is a relay launched by a FakeProvisioner.
+type FakeInstance struct {
+	SessionID     string
+	UserID        string
+	Region        string
+	AWSInstanceID string
+	PublicIP      string
+	State         string
+	LaunchedAt    time.Time
+}
+
+type FakeOption func(*FakeProvisioner)
+
+// WithFakeLatency delays each Provision call by a random duration between
+// lo and hi; equal bounds give a fixed delay.
+func WithFakeLatency(lo, hi time.Duration) FakeOption {
+	return func(f *FakeProvisioner) {
+		f.minLatency = lo
+		f.maxLatency = hi
+	}
+}
+
+// WithFakeFailureRate fails the given fraction (0-1) of Provision calls.
+func WithFakeFailureRate(rate float64) FakeOption {
+	return func(f *FakeProvisioner) {
+		f.failureRate = rate
+	}
+}
+
+// WithFakeErrorCodes sets the API error codes injected failures cycle
+// through, e.g. RequestLimitExceeded or InsufficientInstanceCapacity.
+func WithFakeErrorCodes(codes ...string) FakeOption {
+	return func(f *FakeProvisioner) {
+		f.errorCodes = codes
+	}
+}
+
+// WithFakeFailFirst fails the first n Provision calls, then lets the rest
+// through (subject to the failure rate).
+func WithFakeFailFirst(n int) FakeOption {
+	return func(f *FakeProvisioner) {
+		f.failFirst = n
+	}
+}
+
+// WithFakeWSTemplate renders relay URLs like the real providers do, so
+// local development sees the URLs production would.
+func WithFakeWSTemplate(t WSTemplate) FakeOption {
+	return func(f *FakeProvisioner) {
+		f.wsTemplate = t
+	}
+}
 
-func NewFakeProvisioner() *FakeProvisioner {
-	return &FakeProvisioner{}
+func NewFakeProvisioner(opts ...FakeOption) *FakeProvisioner {
+	f := &FakeProvisioner{
+		instances: make(map[string]*FakeInstance),
+		random:    mathrand.Float64,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
 }
 
-func (f *FakeProvisioner) Provision(_ context.Context, req ProvisionRequest) (ProvisionResult, error) {
+func (f *FakeProvisioner) Provision(ctx context.Context, req ProvisionRequest) (ProvisionResult, error) {
+	if err := f.delay(ctx); err != nil {
+		return ProvisionResult{}, err
+	}
+	if err := f.injectFailure(req); err != nil {
+		return ProvisionResult{}, classifyProvisionError(err)
+	}
 	ipTail, err := randomUint8()
 	if err != nil {
 		return ProvisionResult{}, err
 	}
 	ip := fmt.Sprintf("203.0.113.%d", 10+int(ipTail)%200)
-	return ProvisionResult{
-		AWSInstanceID: "i-fake-" + req.SessionID,
+	// Replacement relays share the session ID, so the instance ID carries a
+	// random suffix to stay unique.
+	suffix, err := randomUint8()
+	if err != nil {
+		return ProvisionResult{}, err
+	}
+	instanceType := "t4g.small"
+	if req.InstanceType != "" {
+		instanceType = req.InstanceType
+	}
+	srtPorts := req.srtPorts()
+	res := ProvisionResult{
+		Region:        req.Region,
+		AWSInstanceID: fmt.Sprintf("i-fake-%s-%02x%02x", req.SessionID, ipTail, suffix),
 		AMIID:         "ami-placeholder-" + req.Region,
-		InstanceType:  "t4g.small",
+		InstanceType:  instanceType,
+		Lifecycle:     LifecycleOnDemand,
 		PublicIP:      ip,
-		SRTPort:       9000,
-		WSURL:         fmt.Sprintf("wss://%s:7443/telemetry", ip),
-	}, nil
+		SRTPort:       srtPorts[0],
+		SRTPorts:      srtPorts,
+		PublicIPv6:    fmt.Sprintf("2001:db8::%x:%x", ipTail, suffix),
+	}
+	res.WSURL = f.wsTemplate.Render(WSVars{PublicIP: ip, InstanceID: res.AWSInstanceID, SessionID: req.SessionID})
+	if req.StaticIP {
+		res.EIPAllocationID = fmt.Sprintf("eipalloc-fake-%02x%02x", ipTail, suffix)
+	}
+	f.mu.Lock()
+	f.instances[res.AWSInstanceID] = &FakeInstance{
+		SessionID:     req.SessionID,
+		UserID:        req.UserID,
+		Region:        res.Region,
+		AWSInstanceID: res.AWSInstanceID,
+		PublicIP:      res.PublicIP,
+		State:         InstanceRunning,
+		LaunchedAt:    time.Now(),
+	}
+	f.mu.Unlock()
+	return res, nil
 }
 
-func (f *FakeProvisioner) Deprovision(_ context.Context, _ DeprovisionRequest) error {
+func (f *FakeProvisioner) Deprovision(_ context.Context, req DeprovisionRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if inst, ok := f.instances[req.AWSInstanceID]; ok {
+		inst.State = InstanceTerminated
+	}
 	return nil
 }
 
+// Status reports the state of instances this fake launched. Others (e.g.
+// launched by another process) are reported as running.
+func (f *FakeProvisioner) Status(_ context.Context, req StatusRequest) (StatusResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if inst, ok := f.instances[req.AWSInstanceID]; ok {
+		return StatusResult{State: inst.State, PublicIP: inst.PublicIP, LaunchedAt: inst.LaunchedAt}, nil
+	}
+	return StatusResult{State: InstanceRunning}, nil
+}
+
+// List returns the instances this fake launched, terminated ones included,
+// oldest first.
+func (f *FakeProvisioner) List() []FakeInstance {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]FakeInstance, 0, len(f.instances))
+	for _, inst := range f.instances {
+		out = append(out, *inst)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].LaunchedAt.Equal(out[j].LaunchedAt) {
+			return out[i].LaunchedAt.Before(out[j].LaunchedAt)
+		}
+		return out[i].AWSInstanceID < out[j].AWSInstanceID
+	})
+	return out
+}
+
+func (f *FakeProvisioner) AuthorizeClientIP(_ context.Context, _ AuthorizeClientIPRequest) error {
+	return nil
+}
+
+func (f *FakeProvisioner) ValidateConfig(_ context.Context, _ []string) []error {
+	return nil
+}
+
+func (f *FakeProvisioner) delay(ctx context.Context) error {
+	if f.maxLatency <= 0 {
+		return nil
+	}
+	d := f.minLatency
+	if span := f.maxLatency - f.minLatency; span > 0 {
+		f.mu.Lock()
+		d += time.Duration(f.random() * float64(span))
+		f.mu.Unlock()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// injectFailure returns an API error shaped like the ones AWS returns, so
+// callers exercise their real error handling.
+func (f *FakeProvisioner) injectFailure(req ProvisionRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.calls > f.failFirst && (f.failureRate <= 0 || f.random() >= f.failureRate) {
+		return nil
+	}
+	code := "InternalError"
+	if len(f.errorCodes) > 0 {
+		code = f.errorCodes[f.failures%len(f.errorCodes)]
+	}
+	f.failures++
+	log.Printf("event=fake_provision_failure_injected session_id=%s region=%s call=%d code=%s", req.SessionID, req.Region, f.calls, code)
+	return &smithy.GenericAPIError{Code: code, Message: "failure injected by fake provisioner"}
+}
+
 func randomUint8() (byte, error) {
 	var b [1]byte
 	if _, err := rand.Read(b[:]); err != nil {
@@ -0,0 +1,98 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestFakeProvisioner_FailFirstThenSucceed(t *testing.T) {
+	f := NewFakeProvisioner(WithFakeFailFirst(3), WithFakeErrorCodes("RequestLimitExceeded", "InsufficientInstanceCapacity"))
+	var codes []string
+	for i := 0; i < 3; i++ {
+		_, err := f.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"})
+		var apiErr smithy.APIError
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("call %d: expected an API error, got %v", i+1, err)
+		}
+		codes = append(codes, apiErr.ErrorCode())
+	}
+	if codes[0] != "RequestLimitExceeded" || codes[1] != "InsufficientInstanceCapacity" || codes[2] != "RequestLimitExceeded" {
+		t.Fatalf("unexpected injected codes %v", codes)
+	}
+	if !isTransientAWSError(&smithy.GenericAPIError{Code: codes[0]}) {
+		t.Fatal("expected injected throttling to be treated as transient")
+	}
+	if _, err := f.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"}); err != nil {
+		t.Fatalf("expected the fourth call to succeed, got %v", err)
+	}
+}
+
+func TestFakeProvisioner_FailureRate(t *testing.T) {
+	f := NewFakeProvisioner(WithFakeFailureRate(0.5))
+	rolls := []float64{0.9, 0.1}
+	f.random = func() float64 {
+		r := rolls[0]
+		rolls = rolls[1:]
+		return r
+	}
+	if _, err := f.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"}); err != nil {
+		t.Fatalf("expected a roll above the rate to succeed, got %v", err)
+	}
+	if _, err := f.Provision(context.Background(), ProvisionRequest{SessionID: "ses_2", Region: "us-east-1"}); err == nil {
+		t.Fatal("expected a roll below the rate to fail")
+	}
+}
+
+func TestFakeProvisioner_LatencyHonorsContext(t *testing.T) {
+	f := NewFakeProvisioner(WithFakeLatency(time.Hour, time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := f.Provision(ctx, ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the delay to end with the context, got %v", err)
+	}
+	if len(f.List()) != 0 {
+		t.Fatal("expected no instance from a cancelled launch")
+	}
+}
+
+func TestFakeProvisioner_TracksInstances(t *testing.T) {
+	f := NewFakeProvisioner()
+	res, err := f.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", UserID: "usr_1", Region: "eu-west-1"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	listed := f.List()
+	if len(listed) != 1 || listed[0].AWSInstanceID != res.AWSInstanceID || listed[0].UserID != "usr_1" || listed[0].State != InstanceRunning {
+		t.Fatalf("unexpected instances %+v", listed)
+	}
+
+	if err := f.Deprovision(context.Background(), DeprovisionRequest{Region: "eu-west-1", AWSInstanceID: res.AWSInstanceID}); err != nil {
+		t.Fatalf("Deprovision: %v", err)
+	}
+	status, err := f.Status(context.Background(), StatusRequest{Region: "eu-west-1", AWSInstanceID: res.AWSInstanceID})
+	if err != nil || status.State != InstanceTerminated || status.LaunchedAt.IsZero() {
+		t.Fatalf("expected a terminated instance, got %+v err=%v", status, err)
+	}
+	// Instances launched elsewhere are assumed to be running.
+	if status, _ := f.Status(context.Background(), StatusRequest{Region: "eu-west-1", AWSInstanceID: "i-other"}); status.State != InstanceRunning {
+		t.Fatalf("expected unknown instances to report running, got %+v", status)
+	}
+}
+
+func TestFakeProvisioner_RendersWSTemplate(t *testing.T) {
+	tmpl, err := ParseWSTemplate("wss://{session_id}.relays.example.com/telemetry")
+	if err != nil {
+		t.Fatalf("ParseWSTemplate: %v", err)
+	}
+	res, err := NewFakeProvisioner(WithFakeWSTemplate(tmpl)).Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "eu-west-1"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if res.WSURL != "wss://ses_1.relays.example.com/telemetry" {
+		t.Fatalf("unexpected ws url %q", res.WSURL)
+	}
+}
@@ -0,0 +1,288 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+// hetznerRetry backs observeHetzner. It shares the default policies but has
+// its own budget so AWS throttling does not starve Hetzner calls.
+var hetznerRetry = newRetryer(nil, defaultRetryBudget).forProvider("hetzner", isTransientHetznerError, hetznerErrorCode)
+
+type HetznerProvisionerOptions struct {
+	Token string
+	// Endpoint overrides the API base URL; empty uses DefaultHetznerEndpoint.
+	Endpoint string
+	// ImageByRegion is the relay image ID or name per region.
+	ImageByRegion map[string]string
+	// ServerType is the default server type; ServerTypeByRegion overrides it.
+	ServerType         string
+	ServerTypeByRegion map[string]string
+	// LocationByRegion maps regions to Hetzner locations (e.g. fsn1); a
+	// region without an entry is used as the location name.
+	LocationByRegion map[string]string
+	SSHKeys          []string
+
+	// ProvisionWaitTimeout bounds the wait for a server to be running with a
+	// public IPv4; ProvisionPollInterval is the delay between checks.
+	ProvisionWaitTimeout  time.Duration
+	ProvisionPollInterval time.Duration
+
+	// WSTemplate renders the relays' telemetry URLs.
+	WSTemplate WSTemplate
+}
+
+// HetznerProvisioner runs relays as Hetzner Cloud servers. Server IDs are
+// stored where AWS instance IDs would be.
+type HetznerProvisioner struct {
+	opts   HetznerProvisionerOptions
+	client hetznerAPI
+}
+
+func NewHetznerProvisioner(opts HetznerProvisionerOptions) (*HetznerProvisioner, error) {
+	if opts.Token == "" {
+		return nil, errors.New("hetzner api token is required")
+	}
+	if len(opts.ImageByRegion) == 0 {
+		return nil, errors.New("hetzner image map is required")
+	}
+	if opts.ServerType == "" {
+		opts.ServerType = "cx22"
+	}
+	if opts.ProvisionWaitTimeout <= 0 {
+		opts.ProvisionWaitTimeout = 2 * time.Minute
+	}
+	if opts.ProvisionPollInterval <= 0 {
+		opts.ProvisionPollInterval = 3 * time.Second
+	}
+	return &HetznerProvisioner{opts: opts, client: newHetznerClient(opts.Endpoint, opts.Token)}, nil
+}
+
+func (p *HetznerProvisioner) location(region string) string {
+	if loc := p.opts.LocationByRegion[region]; loc != "" {
+		return loc
+	}
+	return region
+}
+
+func (p *HetznerProvisioner) serverType(region string) string {
+	if t := p.opts.ServerTypeByRegion[region]; t != "" {
+		return t
+	}
+	return p.opts.ServerType
+}
+
+func (p *HetznerProvisioner) Provision(ctx context.Context, req ProvisionRequest) (ProvisionResult, error) {
+	if req.StaticIP {
+		return ProvisionResult{}, fmt.Errorf("%w: hetzner relays do not support static ips", ErrStaticIPUnavailable)
+	}
+	image := p.opts.ImageByRegion[req.Region]
+	if image == "" {
+		return ProvisionResult{}, fmt.Errorf("%w: no hetzner image configured for region %s", ErrImageUnavailable, req.Region)
+	}
+	userData, err := renderCloudConfig(req)
+	if err != nil {
+		return ProvisionResult{}, err
+	}
+	suffix, err := randomUint8()
+	if err != nil {
+		return ProvisionResult{}, err
+	}
+	in := hetznerCreateServer{
+		// Replacement relays share the session ID, so the name carries a
+		// random suffix to stay unique.
+		Name:       fmt.Sprintf("aegis-relay-%s-%02x", req.SessionID, suffix),
+		ServerType: p.serverType(req.Region),
+		Image:      image,
+		Location:   p.location(req.Region),
+		UserData:   userData,
+		Labels: map[string]string{
+			"aegis-managed":    "true",
+			"aegis-session-id": req.SessionID,
+			"aegis-user-id":    req.UserID,
+		},
+		SSHKeys: p.opts.SSHKeys,
+	}
+	var server hetznerServer
+	err = observeHetzner(ctx, "create_server", req.Region, func(callCtx context.Context) error {
+		var createErr error
+		server, createErr = p.client.CreateServer(callCtx, in)
+		return createErr
+	})
+	if err != nil {
+		return ProvisionResult{}, fmt.Errorf("create hetzner server: %w", classifyProvisionError(err))
+	}
+
+	server, err = p.waitForRunning(ctx, req.Region, server)
+	if err != nil {
+		p.deleteQuietly(ctx, req.Region, server.ID)
+		return ProvisionResult{}, err
+	}
+	ip := server.PublicNet.IPv4.IP
+	serverID := strconv.FormatInt(server.ID, 10)
+	srtPorts := req.srtPorts()
+	log.Printf("event=hetzner_relay_running session_id=%s region=%s server_id=%d ip=%s", req.SessionID, req.Region, server.ID, ip)
+	return ProvisionResult{
+		Region:           req.Region,
+		AWSInstanceID:    serverID,
+		AMIID:            image,
+		InstanceType:     in.ServerType,
+		Lifecycle:        LifecycleOnDemand,
+		PublicIP:         ip,
+		SRTPort:          srtPorts[0],
+		SRTPorts:         srtPorts,
+		WSURL:            p.opts.WSTemplate.Render(WSVars{PublicIP: ip, InstanceID: serverID, SessionID: req.SessionID}),
+		AvailabilityZone: server.Datacenter.Name,
+	}, nil
+}
+
+// waitForRunning polls until the server is running with a public IPv4.
+func (p *HetznerProvisioner) waitForRunning(ctx context.Context, region string, server hetznerServer) (hetznerServer, error) {
+	start := time.Now()
+	waitCtx, cancel := context.WithTimeout(ctx, p.opts.ProvisionWaitTimeout)
+	defer cancel()
+	status := "ok"
+	defer func() {
+		metrics.Default().ObserveHistogram("aegis_provider_instance_running_wait_ms", float64(time.Since(start).Milliseconds()), map[string]string{
+			"provider": "hetzner",
+			"region":   region,
+			"status":   status,
+		})
+	}()
+	for server.Status != "running" || server.PublicNet.IPv4.IP == "" {
+		select {
+		case <-waitCtx.Done():
+			status = "timeout"
+			return server, fmt.Errorf("hetzner server %d not running after %s: %w", server.ID, p.opts.ProvisionWaitTimeout, waitCtx.Err())
+		case <-time.After(p.opts.ProvisionPollInterval):
+		}
+		err := observeHetzner(waitCtx, "get_server", region, func(callCtx context.Context) error {
+			var getErr error
+			server, getErr = p.client.GetServer(callCtx, server.ID)
+			return getErr
+		})
+		if err != nil {
+			status = "error"
+			return server, fmt.Errorf("poll hetzner server: %w", err)
+		}
+	}
+	return server, nil
+}
+
+func (p *HetznerProvisioner) deleteQuietly(ctx context.Context, region string, id int64) {
+	if id == 0 {
+		return
+	}
+	if err := p.deleteServer(ctx, region, id); err != nil {
+		log.Printf("event=hetzner_relay_cleanup_failed region=%s server_id=%d err=%q", region, id, err.Error())
+	}
+}
+
+func (p *HetznerProvisioner) deleteServer(ctx context.Context, region string, id int64) error {
+	err := observeHetzner(ctx, "delete_server", region, func(callCtx context.Context) error {
+		return p.client.DeleteServer(callCtx, id)
+	})
+	if isHetznerNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (p *HetznerProvisioner) Deprovision(ctx context.Context, req DeprovisionRequest) error {
+	if req.AWSInstanceID == "" {
+		return nil
+	}
+	id, err := strconv.ParseInt(req.AWSInstanceID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid hetzner server id %q", req.AWSInstanceID)
+	}
+	if err := p.deleteServer(ctx, req.Region, id); err != nil {
+		return fmt.Errorf("delete hetzner server: %w", err)
+	}
+	return nil
+}
+
+func (p *HetznerProvisioner) Status(ctx context.Context, req StatusRequest) (StatusResult, error) {
+	id, err := strconv.ParseInt(req.AWSInstanceID, 10, 64)
+	if err != nil {
+		return StatusResult{}, fmt.Errorf("invalid hetzner server id %q", req.AWSInstanceID)
+	}
+	var server hetznerServer
+	err = observeHetzner(ctx, "get_server", req.Region, func(callCtx context.Context) error {
+		var getErr error
+		server, getErr = p.client.GetServer(callCtx, id)
+		return getErr
+	})
+	if isHetznerNotFound(err) {
+		return StatusResult{State: InstanceTerminated}, nil
+	}
+	if err != nil {
+		return StatusResult{}, err
+	}
+	return StatusResult{State: hetznerState(server.Status), PublicIP: server.PublicNet.IPv4.IP, LaunchedAt: server.Created}, nil
+}
+
+// hetznerState folds Hetzner server states into instance states.
+func hetznerState(status string) string {
+	switch status {
+	case "initializing", "starting":
+		return InstancePending
+	case "running":
+		return InstanceRunning
+	case "deleting":
+		return InstanceShuttingDown
+	case "stopping", "off":
+		return InstanceStopped
+	default:
+		return InstancePending
+	}
+}
+
+// AuthorizeClientIP is a no-op: Hetzner relays are not locked to a client
+// IP yet.
+func (p *HetznerProvisioner) AuthorizeClientIP(_ context.Context, _ AuthorizeClientIPRequest) error {
+	return nil
+}
+
+// ValidateConfig checks that every region has an image and that the image
+// exists.
+func (p *HetznerProvisioner) ValidateConfig(ctx context.Context, regions []string) []error {
+	var problems []error
+	for _, region := range regions {
+		image := p.opts.ImageByRegion[region]
+		if image == "" {
+			problems = append(problems, &model.RegionError{Region: region, Err: errors.New("no image configured in AEGIS_HETZNER_IMAGE_MAP")})
+			continue
+		}
+		ok, err := p.client.ImageExists(ctx, image)
+		switch {
+		case err != nil:
+			problems = append(problems, &model.RegionError{Region: region, Err: fmt.Errorf("look up hetzner image %s: %w", image, err)})
+		case !ok:
+			problems = append(problems, &model.RegionError{Region: region, Err: fmt.Errorf("hetzner image %s not found", image)})
+		}
+	}
+	return problems
+}
+
+// observeHetzner runs fn with retries and records the operation metrics,
+// mirroring observeAWS.
+func observeHetzner(ctx context.Context, op, region string, fn func(context.Context) error) error {
+	start := time.Now()
+	err := hetznerRetry.do(ctx, op, region, fn)
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	labels := map[string]string{"provider": "hetzner", "op": op, "region": region, "status": status}
+	metrics.Default().IncCounter("aegis_provider_operations_total", labels)
+	metrics.Default().ObserveHistogram("aegis_provider_operation_latency_ms", float64(time.Since(start).Milliseconds()), labels)
+	return err
+}
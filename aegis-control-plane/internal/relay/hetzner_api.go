@@ -0,0 +1,192 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DefaultHetznerEndpoint is the Hetzner Cloud API base URL.
+const DefaultHetznerEndpoint = "https://api.hetzner.cloud/v1"
+
+// hetznerAPI is the subset of the Hetzner Cloud API used by
+// HetznerProvisioner, kept narrow so tests can substitute a fake.
+type hetznerAPI interface {
+	CreateServer(ctx context.Context, in hetznerCreateServer) (hetznerServer, error)
+	GetServer(ctx context.Context, id int64) (hetznerServer, error)
+	DeleteServer(ctx context.Context, id int64) error
+	// ImageExists reports whether an image ID or name is usable.
+	ImageExists(ctx context.Context, image string) (bool, error)
+}
+
+type hetznerCreateServer struct {
+	Name       string            `json:"name"`
+	ServerType string            `json:"server_type"`
+	Image      string            `json:"image"`
+	Location   string            `json:"location"`
+	UserData   string            `json:"user_data,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	SSHKeys    []string          `json:"ssh_keys,omitempty"`
+}
+
+type hetznerServer struct {
+	ID      int64     `json:"id"`
+	Name    string    `json:"name"`
+	Status  string    `json:"status"`
+	Created time.Time `json:"created"`
+
+	PublicNet struct {
+		IPv4 struct {
+			IP string `json:"ip"`
+		} `json:"ipv4"`
+	} `json:"public_net"`
+	Datacenter struct {
+		Name string `json:"name"`
+	} `json:"datacenter"`
+}
+
+// HetznerError is an error response from the Hetzner Cloud API.
+type HetznerError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	header     http.Header
+}
+
+func (e *HetznerError) Error() string {
+	return fmt.Sprintf("hetzner api error %s (status %d): %s", e.Code, e.StatusCode, e.Message)
+}
+
+// ResponseHeader lets the retryer honour Retry-After hints.
+func (e *HetznerError) ResponseHeader() http.Header {
+	return e.header
+}
+
+func isHetznerNotFound(err error) bool {
+	var apiErr *HetznerError
+	return errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.Code == "not_found")
+}
+
+// isTransientHetznerError reports rate limiting, server-side failures and
+// locked resources, which are worth retrying.
+func isTransientHetznerError(err error) bool {
+	var apiErr *HetznerError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.Code {
+	case "rate_limit_exceeded", "server_error", "timeout", "locked", "conflict", "resource_unavailable":
+		return true
+	}
+	return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+}
+
+func hetznerErrorCode(err error) string {
+	var apiErr *HetznerError
+	if errors.As(err, &apiErr) && apiErr.Code != "" {
+		return apiErr.Code
+	}
+	return "unknown"
+}
+
+// hetznerClient talks to the Hetzner Cloud API over HTTPS.
+type hetznerClient struct {
+	client   *http.Client
+	endpoint string
+	token    string
+}
+
+func newHetznerClient(endpoint, token string) *hetznerClient {
+	if endpoint == "" {
+		endpoint = DefaultHetznerEndpoint
+	}
+	return &hetznerClient{client: &http.Client{Timeout: 30 * time.Second}, endpoint: endpoint, token: token}
+}
+
+func (c *hetznerClient) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		raw, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var env struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&env)
+		return &HetznerError{StatusCode: resp.StatusCode, Code: env.Error.Code, Message: env.Error.Message, header: resp.Header}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *hetznerClient) CreateServer(ctx context.Context, in hetznerCreateServer) (hetznerServer, error) {
+	var out struct {
+		Server hetznerServer `json:"server"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/servers", in, &out); err != nil {
+		return hetznerServer{}, err
+	}
+	return out.Server, nil
+}
+
+func (c *hetznerClient) GetServer(ctx context.Context, id int64) (hetznerServer, error) {
+	var out struct {
+		Server hetznerServer `json:"server"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/servers/"+strconv.FormatInt(id, 10), nil, &out); err != nil {
+		return hetznerServer{}, err
+	}
+	return out.Server, nil
+}
+
+func (c *hetznerClient) DeleteServer(ctx context.Context, id int64) error {
+	return c.do(ctx, http.MethodDelete, "/servers/"+strconv.FormatInt(id, 10), nil, nil)
+}
+
+func (c *hetznerClient) ImageExists(ctx context.Context, image string) (bool, error) {
+	if _, err := strconv.ParseInt(image, 10, 64); err == nil {
+		err := c.do(ctx, http.MethodGet, "/images/"+image, nil, nil)
+		if isHetznerNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	var out struct {
+		Images []struct {
+			ID int64 `json:"id"`
+		} `json:"images"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/images?name="+url.QueryEscape(image), nil, &out); err != nil {
+		return false, err
+	}
+	return len(out.Images) > 0, nil
+}
@@ -0,0 +1,157 @@
+package relay
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+)
+
+// fakeHetzner serves servers from memory. Created servers report running
+// with an IP after startPolls GetServer calls.
+type fakeHetzner struct {
+	created    []hetznerCreateServer
+	servers    map[int64]*hetznerServer
+	polls      map[int64]int
+	startPolls int
+	createErrs []error
+	deleted    []int64
+}
+
+func newFakeHetzner() *fakeHetzner {
+	return &fakeHetzner{servers: make(map[int64]*hetznerServer), polls: make(map[int64]int)}
+}
+
+func (h *fakeHetzner) CreateServer(_ context.Context, in hetznerCreateServer) (hetznerServer, error) {
+	if len(h.createErrs) > 0 {
+		err := h.createErrs[0]
+		h.createErrs = h.createErrs[1:]
+		return hetznerServer{}, err
+	}
+	h.created = append(h.created, in)
+	s := &hetznerServer{ID: int64(1000 + len(h.created)), Name: in.Name, Status: "initializing", Created: time.Unix(1_700_000_000, 0)}
+	s.Datacenter.Name = in.Location + "-dc14"
+	h.servers[s.ID] = s
+	return *s, nil
+}
+
+func (h *fakeHetzner) GetServer(_ context.Context, id int64) (hetznerServer, error) {
+	s, ok := h.servers[id]
+	if !ok {
+		return hetznerServer{}, &HetznerError{StatusCode: http.StatusNotFound, Code: "not_found"}
+	}
+	h.polls[id]++
+	if h.polls[id] >= h.startPolls && s.Status == "initializing" {
+		s.Status = "running"
+		s.PublicNet.IPv4.IP = "198.51.100.40"
+	}
+	return *s, nil
+}
+
+func (h *fakeHetzner) DeleteServer(_ context.Context, id int64) error {
+	if _, ok := h.servers[id]; !ok {
+		return &HetznerError{StatusCode: http.StatusNotFound, Code: "not_found"}
+	}
+	h.deleted = append(h.deleted, id)
+	delete(h.servers, id)
+	return nil
+}
+
+func (h *fakeHetzner) ImageExists(_ context.Context, image string) (bool, error) {
+	return image == "relay-snapshot", nil
+}
+
+func newTestHetznerProvisioner(t *testing.T, client hetznerAPI) *HetznerProvisioner {
+	t.Helper()
+	prev := hetznerRetry
+	hetznerRetry = newRetryer(nil, defaultRetryBudget).forProvider("hetzner", isTransientHetznerError, hetznerErrorCode)
+	hetznerRetry.jitter = func(time.Duration) time.Duration { return time.Millisecond }
+	t.Cleanup(func() { hetznerRetry = prev })
+	return &HetznerProvisioner{
+		opts: HetznerProvisionerOptions{
+			ImageByRegion:         map[string]string{"eu-central": "relay-snapshot"},
+			ServerType:            "cx22",
+			ServerTypeByRegion:    map[string]string{"eu-central": "cax11"},
+			LocationByRegion:      map[string]string{"eu-central": "fsn1"},
+			ProvisionWaitTimeout:  time.Second,
+			ProvisionPollInterval: time.Millisecond,
+		},
+		client: client,
+	}
+}
+
+func TestHetznerProvision_WaitsForRunningServer(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	h := newFakeHetzner()
+	h.startPolls = 2
+	h.createErrs = []error{&HetznerError{StatusCode: http.StatusTooManyRequests, Code: "rate_limit_exceeded"}}
+	p := newTestHetznerProvisioner(t, h)
+
+	res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", UserID: "usr_1", Region: "eu-central", RelayAuthToken: "tok"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if res.AWSInstanceID != "1001" || res.PublicIP != "198.51.100.40" || res.InstanceType != "cax11" || res.AvailabilityZone != "fsn1-dc14" {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	in := h.created[0]
+	if in.Location != "fsn1" || in.Image != "relay-snapshot" || in.Labels["aegis-session-id"] != "ses_1" || !strings.HasPrefix(in.UserData, "#cloud-config") {
+		t.Fatalf("unexpected create request %+v", in)
+	}
+	out := metrics.Default().Render()
+	for _, want := range []string{
+		`aegis_provider_retries_total{op="create_server",provider="hetzner",reason="rate_limit_exceeded",region="eu-central"} 1`,
+		`aegis_provider_operations_total{op="create_server",provider="hetzner",region="eu-central",status="ok"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %s in metrics:\n%s", want, out)
+		}
+	}
+}
+
+func TestHetznerProvision_DeletesServerThatNeverRuns(t *testing.T) {
+	h := newFakeHetzner()
+	h.startPolls = 1 << 30
+	p := newTestHetznerProvisioner(t, h)
+	p.opts.ProvisionWaitTimeout = 20 * time.Millisecond
+
+	if _, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "eu-central"}); err == nil {
+		t.Fatal("expected Provision to time out")
+	}
+	if len(h.deleted) != 1 || len(h.servers) != 0 {
+		t.Fatalf("expected the stuck server to be deleted, got deleted=%v", h.deleted)
+	}
+}
+
+func TestHetznerDeprovision_NotFoundIsSuccess(t *testing.T) {
+	h := newFakeHetzner()
+	p := newTestHetznerProvisioner(t, h)
+	res, err := p.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "eu-central"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	req := DeprovisionRequest{Region: "eu-central", AWSInstanceID: res.AWSInstanceID}
+	if err := p.Deprovision(context.Background(), req); err != nil {
+		t.Fatalf("Deprovision: %v", err)
+	}
+	if err := p.Deprovision(context.Background(), req); err != nil {
+		t.Fatalf("expected a deleted server to deprovision cleanly, got %v", err)
+	}
+	status, err := p.Status(context.Background(), StatusRequest{Region: "eu-central", AWSInstanceID: res.AWSInstanceID})
+	if err != nil || status.State != InstanceTerminated {
+		t.Fatalf("expected terminated, got %+v err=%v", status, err)
+	}
+}
+
+func TestHetznerValidateConfig_ReportsRegionProblems(t *testing.T) {
+	p := newTestHetznerProvisioner(t, newFakeHetzner())
+	p.opts.ImageByRegion["us-east"] = "missing-image"
+
+	problems := p.ValidateConfig(context.Background(), []string{"eu-central", "us-east", "ap-south"})
+	if len(problems) != 2 {
+		t.Fatalf("expected 2 problems, got %v", problems)
+	}
+}
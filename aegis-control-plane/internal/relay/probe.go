@@ -0,0 +1,73 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// BootProbe checks that a freshly provisioned relay accepts connections. A
+// running instance may still be booting the relay software.
+type BootProbe interface {
+	// WaitReady blocks until the relay is ready or ctx is done.
+	WaitReady(ctx context.Context, res ProvisionResult) error
+}
+
+// DialProbe reports a relay ready once its telemetry listener accepts a TCP
+// connection. The relay opens it after its SRT listener.
+type DialProbe struct {
+	// Interval is the delay between attempts.
+	Interval time.Duration
+	// AttemptTimeout bounds each connection attempt.
+	AttemptTimeout time.Duration
+
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+func NewDialProbe() *DialProbe {
+	var d net.Dialer
+	return &DialProbe{Interval: time.Second, AttemptTimeout: 3 * time.Second, dial: d.DialContext}
+}
+
+func (p *DialProbe) WaitReady(ctx context.Context, res ProvisionResult) error {
+	addr, err := probeAddr(res)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, p.AttemptTimeout)
+		conn, err := p.dial(attemptCtx, "tcp", addr)
+		cancel()
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("relay %s not ready after %d attempts: %w (last error: %v)", addr, attempt, ctx.Err(), err)
+		case <-time.After(p.Interval):
+		}
+	}
+}
+
+// probeAddr is the relay's telemetry host:port, from its WS URL when set.
+func probeAddr(res ProvisionResult) (string, error) {
+	if res.WSURL == "" {
+		if res.PublicIP == "" {
+			return "", fmt.Errorf("relay %s has no address to probe", res.AWSInstanceID)
+		}
+		return net.JoinHostPort(res.PublicIP, strconv.Itoa(relayWSPort)), nil
+	}
+	u, err := url.Parse(res.WSURL)
+	if err != nil || u.Hostname() == "" {
+		return "", fmt.Errorf("invalid relay ws url %q", res.WSURL)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
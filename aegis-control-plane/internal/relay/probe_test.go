@@ -0,0 +1,39 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDialProbe_RetriesUntilListenerUp(t *testing.T) {
+	var addrs []string
+	p := &DialProbe{Interval: time.Millisecond, AttemptTimeout: time.Second}
+	p.dial = func(_ context.Context, _, addr string) (net.Conn, error) {
+		addrs = append(addrs, addr)
+		if len(addrs) < 3 {
+			return nil, errors.New("connection refused")
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+	if err := p.WaitReady(context.Background(), ProvisionResult{WSURL: "wss://127.0.0.1:20001/telemetry"}); err != nil {
+		t.Fatalf("WaitReady: %v", err)
+	}
+	if len(addrs) != 3 || addrs[0] != "127.0.0.1:20001" {
+		t.Fatalf("expected three attempts at the ws port, got %v", addrs)
+	}
+
+	// A relay that never comes up fails once the budget runs out.
+	p.dial = func(context.Context, string, string) (net.Conn, error) {
+		return nil, errors.New("connection refused")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.WaitReady(ctx, ProvisionResult{PublicIP: "198.51.100.7"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline error, got %v", err)
+	}
+}
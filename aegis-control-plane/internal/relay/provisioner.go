@@ -1,30 +1,346 @@
 package relay
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/smithy-go"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+// Relay instance purchase options, stored in relay_instances.lifecycle.
+const (
+	LifecycleOnDemand = model.RelayLifecycleOnDemand
+	LifecycleSpot     = model.RelayLifecycleSpot
+)
+
+// ErrStaticIPUnavailable means a relay that asked for a static IP could not
+// get one, e.g. because the address pool is exhausted.
+var ErrStaticIPUnavailable = errors.New("static ip unavailable")
+
+// ErrRegionUnavailable means no capacity was found in the region or any of
+// its fallbacks.
+var ErrRegionUnavailable = errors.New("region unavailable")
+
+// Typed provisioning failures. Provisioners wrap the provider's error with
+// one of them, by its error code, so callers can tell a shortage that clears
+// on its own from a problem an operator must fix.
+var (
+	// ErrNoCapacity means the provider had no capacity for the instance.
+	ErrNoCapacity = errors.New("no provider capacity")
+	// ErrProviderThrottled means the provider rate limited the account.
+	ErrProviderThrottled = errors.New("provider throttled")
+	// ErrQuotaExceeded means an account limit, such as vCPUs or instances,
+	// is reached.
+	ErrQuotaExceeded = errors.New("provider quota exceeded")
+	// ErrImageUnavailable means the relay image is not configured for the
+	// region or the provider does not know it.
+	ErrImageUnavailable = errors.New("relay image unavailable")
+	// ErrProviderAuth means the provider rejected the credentials or they
+	// lack a permission.
+	ErrProviderAuth = errors.New("provider rejected credentials")
+)
+
+var provisionErrorCodes = map[string]error{
+	"InsufficientInstanceCapacity":         ErrNoCapacity,
+	"InsufficientHostCapacity":             ErrNoCapacity,
+	"InsufficientCapacity":                 ErrNoCapacity,
+	"InsufficientReservedInstanceCapacity": ErrNoCapacity,
+	"resource_unavailable":                 ErrNoCapacity,
+	"placement_error":                      ErrNoCapacity,
+
+	"RequestLimitExceeded":  ErrProviderThrottled,
+	"Throttling":            ErrProviderThrottled,
+	"ThrottlingException":   ErrProviderThrottled,
+	"RequestThrottled":      ErrProviderThrottled,
+	"EC2ThrottledException": ErrProviderThrottled,
+	"rate_limit_exceeded":   ErrProviderThrottled,
+
+	"InstanceLimitExceeded":        ErrQuotaExceeded,
+	"VcpuLimitExceeded":            ErrQuotaExceeded,
+	"MaxSpotInstanceCountExceeded": ErrQuotaExceeded,
+	"SecurityGroupLimitExceeded":   ErrQuotaExceeded,
+	"resource_limit_exceeded":      ErrQuotaExceeded,
+
+	"InvalidAMIID.NotFound":    ErrImageUnavailable,
+	"InvalidAMIID.Malformed":   ErrImageUnavailable,
+	"InvalidAMIID.Unavailable": ErrImageUnavailable,
+	"ParameterNotFound":        ErrImageUnavailable,
+
+	"AuthFailure":           ErrProviderAuth,
+	"UnauthorizedOperation": ErrProviderAuth,
+	"InvalidClientTokenId":  ErrProviderAuth,
+	"SignatureDoesNotMatch": ErrProviderAuth,
+	"OptInRequired":         ErrProviderAuth,
+	"unauthorized":          ErrProviderAuth,
+	"forbidden":             ErrProviderAuth,
+}
+
+// classifyProvisionError wraps err with the typed failure its provider
+// error code maps to. Errors that already carry one, or have no known code,
+// are returned unchanged.
+func classifyProvisionError(err error) error {
+	if err == nil || FailureCause(err) != "other" {
+		return err
+	}
+	if typed, ok := provisionErrorCodes[ErrorCode(err)]; ok {
+		return fmt.Errorf("%w: %w", typed, err)
+	}
+	return err
+}
+
+// FailureCause names the typed failure err carries, for metrics: one of
+// no_capacity, throttled, quota_exceeded, image_unavailable, auth,
+// provider_unavailable, static_ip_unavailable or other.
+func FailureCause(err error) string {
+	switch {
+	case errors.Is(err, ErrNoCapacity), errors.Is(err, ErrRegionUnavailable):
+		return "no_capacity"
+	case errors.Is(err, ErrProviderThrottled):
+		return "throttled"
+	case errors.Is(err, ErrQuotaExceeded):
+		return "quota_exceeded"
+	case errors.Is(err, ErrImageUnavailable):
+		return "image_unavailable"
+	case errors.Is(err, ErrProviderAuth):
+		return "auth"
+	case errors.Is(err, ErrProviderUnavailable):
+		return "provider_unavailable"
+	case errors.Is(err, ErrStaticIPUnavailable):
+		return "static_ip_unavailable"
+	default:
+		return "other"
+	}
+}
+
+// ErrTerminationPending means Deprovision issued the termination but could
+// not confirm the instance is gone. Callers treat it as success and confirm
+// later through Status.
+var ErrTerminationPending = errors.New("termination not yet confirmed")
+
+// Instance states reported by Provisioner.Status. Provider-specific states
+// are folded into these.
+const (
+	InstancePending    = "pending"
+	InstanceRunning    = "running"
+	InstanceStopped    = "stopped"
+	InstanceTerminated = "terminated"
+
+	// InstanceShuttingDown is a terminated instance the provider has not
+	// finished tearing down.
+	InstanceShuttingDown = "shutting-down"
+)
+
+// DefaultSRTPort is the relay SRT listener port when a request leaves the
+// port range unset.
+const DefaultSRTPort = 9000
+
+// PortRange is an inclusive range of ports.
+type PortRange struct {
+	Min, Max int
+}
+
+// Size is the number of ports in r.
+func (r PortRange) Size() int {
+	return r.Max - r.Min + 1
+}
 
 type ProvisionRequest struct {
 	SessionID string
 	UserID    string
 	Region    string
+
+	// Bootstrap settings passed to the relay at boot.
+	ControlPlaneURL string
+	RelayAuthToken  string
+	// SRTPortCount SRT listeners are allocated from SRTPortRange; unset,
+	// the relay binds DefaultSRTPort alone.
+	SRTPortRange PortRange
+	SRTPortCount int
+
+	// StaticIP asks for a stable public address (an Elastic IP on AWS).
+	StaticIP bool
+	// ClientIP is the streamer's address; providers that lock relay ports
+	// to the client admit only this IP.
+	ClientIP string
+	// ReplacesInstanceID is the dead relay a replacement launch stands in
+	// for. It still carries the session's tags and must not be reused.
+	ReplacesInstanceID string
+	// InstanceType, when set, launches this instance type instead of the
+	// provider's primary one; fallback types still follow it. Providers
+	// without instance types ignore it.
+	InstanceType string
+	// OnAttempt, when set, is called after each launch target a provider
+	// tries, capacity fallbacks included. Providers that launch in a single
+	// step do not call it.
+	OnAttempt func(ProvisionAttempt)
+}
+
+// ProvisionAttempt is one launch target tried within a Provision call.
+type ProvisionAttempt struct {
+	Region       string
+	InstanceType string
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	// InstanceID is set when the attempt launched (or reused) an instance.
+	InstanceID string
+	Err        error
+}
+
+func (r ProvisionRequest) reportAttempt(a ProvisionAttempt) {
+	if r.OnAttempt != nil {
+		r.OnAttempt(a)
+	}
+}
+
+// srtPorts allocates the relay's SRT ports: the lowest SRTPortCount ports of
+// the range. Each relay has the range to itself, so a replacement binds the
+// same ports as the relay it replaces.
+func (r ProvisionRequest) srtPorts() []int {
+	rng := r.SRTPortRange
+	if rng.Min <= 0 || rng.Max < rng.Min {
+		rng = PortRange{Min: DefaultSRTPort, Max: DefaultSRTPort}
+	}
+	ports := make([]int, min(max(r.SRTPortCount, 1), rng.Size()))
+	for i := range ports {
+		ports[i] = rng.Min + i
+	}
+	return ports
+}
+
+// ErrorCode returns the provider error code carried by err, an AWS API
+// error code or a Hetzner error code, or "" when there is none.
+func ErrorCode(err error) string {
+	var hetznerErr *HetznerError
+	if errors.As(err, &hetznerErr) {
+		return hetznerErr.Code
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return strings.TrimSpace(apiErr.ErrorCode())
+	}
+	return ""
 }
 
 type ProvisionResult struct {
+	// Region is where the relay was launched; it differs from the requested
+	// region when the provider fell back to another region.
+	Region        string
 	AWSInstanceID string
 	AMIID         string
 	InstanceType  string
+	Lifecycle     string
 	PublicIP      string
-	SRTPort       int
-	WSURL         string
+	// SRTPorts are the relay's SRT listeners; SRTPort is the first.
+	SRTPort  int
+	SRTPorts []int
+	WSURL    string
+
+	// EIPAllocationID is set when PublicIP is a static address.
+	EIPAllocationID string
+	// PublicIPv6 is set when the relay's subnet is dual-stack.
+	PublicIPv6 string
+	// SubnetID and AvailabilityZone record placement, when known.
+	SubnetID         string
+	AvailabilityZone string
+	// SecurityGroupID is the per-relay group locked to the client IP.
+	SecurityGroupID string
+
+	// Provider names the backend that launched the relay when a Router
+	// chose it; later calls for the relay pass it back.
+	Provider string
+}
+
+// AllowedClientIP is the client IP recorded for a relay; it is only set when
+// the relay's security group is locked to it.
+func AllowedClientIP(prov ProvisionResult, clientIP string) string {
+	if prov.SecurityGroupID == "" {
+		return ""
+	}
+	return clientIP
 }
 
 type DeprovisionRequest struct {
-	SessionID     string
-	UserID        string
+	SessionID       string
+	UserID          string
+	Region          string
+	AWSInstanceID   string
+	EIPAllocationID string
+	SecurityGroupID string
+
+	// Provider is the backend recorded for the relay; empty routes by
+	// region.
+	Provider string
+
+	// Reason is why the relay is terminated (see model.TerminateReason*),
+	// tagged on AWS instances to cross-check costs.
+	Reason string
+}
+
+// AuthorizeClientIPRequest replaces the client IP admitted by a relay's
+// per-session security group.
+type AuthorizeClientIPRequest struct {
+	Region          string
+	SecurityGroupID string
+	ClientIP        string
+	SRTPorts        []int
+
+	Provider string
+}
+
+type StatusRequest struct {
 	Region        string
 	AWSInstanceID string
+
+	Provider string
+}
+
+// StatusResult is the provider's view of an instance. LaunchedAt is zero
+// when the provider does not report it.
+type StatusResult struct {
+	State      string
+	PublicIP   string
+	LaunchedAt time.Time
+}
+
+// AMIResolver is implemented by providers whose AMI map values may be
+// indirect (e.g. SSM parameters). ResolveAMI returns the image a launch in
+// region would use.
+type AMIResolver interface {
+	ResolveAMI(ctx context.Context, region, ami string) (string, error)
+}
+
+// WarmPoolProvider is implemented by providers that keep a warm pool of
+// stopped instances. The jobs worker uses it to replenish and recycle the
+// pool; Provision and Deprovision claim and return instances themselves.
+type WarmPoolProvider interface {
+	// WarmPoolSettings returns the per-region pool size and the age after
+	// which pool instances are recycled.
+	WarmPoolSettings() (map[string]int, time.Duration)
+	// PoolImage returns the AMI and instance type a pool launch in region
+	// uses; older pool instances are recycled.
+	PoolImage(ctx context.Context, region string) (amiID, instanceType string, err error)
+	// LaunchPooled launches a pool instance. It is returned warming and is
+	// stopped by StopPooled once running.
+	LaunchPooled(ctx context.Context, region string) (model.PooledRelay, error)
+	StopPooled(ctx context.Context, region, awsInstanceID string) error
 }
 
 type Provisioner interface {
 	Provision(ctx context.Context, req ProvisionRequest) (ProvisionResult, error)
+	// Deprovision returns ErrTerminationPending when the instance was told
+	// to terminate but is not yet confirmed gone.
 	Deprovision(ctx context.Context, req DeprovisionRequest) error
+	// Status reports the provider-side state of an instance. An instance the
+	// provider no longer knows about is reported as InstanceTerminated.
+	Status(ctx context.Context, req StatusRequest) (StatusResult, error)
+	// ValidateConfig reports provider configuration problems for the given
+	// regions. Region-scoped problems are returned as *model.RegionError.
+	ValidateConfig(ctx context.Context, regions []string) []error
+	// AuthorizeClientIP re-targets a relay's client IP lock at a new address.
+	AuthorizeClientIP(ctx context.Context, req AuthorizeClientIPRequest) error
 }
@@ -0,0 +1,269 @@
+package relay
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+)
+
+// RetryPolicy bounds the retries of one AWS operation. Delays double from
+// BaseDelay up to MaxDelay and are jittered.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy applies to operations without their own policy.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 4, BaseDelay: 250 * time.Millisecond, MaxDelay: 2 * time.Second}
+
+// maxRetryAfterHint is the longest server-requested delay retryAWS waits
+// for; a longer hint ends the retries instead.
+const maxRetryAfterHint = 10 * time.Second
+
+// backoff returns the jittered delay before retry number attempt (1-based).
+func (p RetryPolicy) backoff(attempt int, jitter func(time.Duration) time.Duration) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return jitter(min(delay, p.MaxDelay))
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay < p.BaseDelay {
+		p.MaxDelay = max(DefaultRetryPolicy.MaxDelay, p.BaseDelay)
+	}
+	return p
+}
+
+// clock is time as seen by the retryer; tests substitute a fake.
+type clock interface {
+	Now() time.Time
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryBudget is a token bucket shared by every operation: each retry
+// spends a token, and tokens refill at capacity per minute. It keeps a
+// throttling event from making the whole fleet retry harder.
+type retryBudget struct {
+	mu       sync.Mutex
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+// take spends a token, reporting false when the budget is exhausted. A zero
+// capacity disables the budget.
+func (b *retryBudget) take(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.capacity <= 0 {
+		return true
+	}
+	if !b.last.IsZero() {
+		b.tokens = min(b.capacity, b.tokens+now.Sub(b.last).Minutes()*b.capacity)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *retryBudget) resize(capacity int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.last.IsZero() || float64(capacity) < b.tokens {
+		b.tokens = float64(capacity)
+	}
+	b.capacity = float64(capacity)
+}
+
+// retryer retries transient provider errors with per-operation policies,
+// honoring retry-after hints from AWS responses.
+type retryer struct {
+	mu       sync.RWMutex
+	defaults RetryPolicy
+	perOp    map[string]RetryPolicy
+
+	budget retryBudget
+	clock  clock
+	jitter func(time.Duration) time.Duration
+
+	// provider names the backend in logs and metrics; transient and reason
+	// classify its errors.
+	provider  string
+	transient func(error) bool
+	reason    func(error) string
+}
+
+// defaultRetryBudget is the number of retries allowed per minute across all
+// operations when AWSProvisionerOptions.RetryBudget is unset.
+const defaultRetryBudget = 100
+
+func newRetryer(policies map[string]RetryPolicy, budget int) *retryer {
+	r := &retryer{clock: realClock{}, jitter: withJitter, provider: "aws", transient: isTransientAWSError, reason: awsErrorCode}
+	r.configure(policies, budget)
+	return r
+}
+
+// forProvider adapts the retryer to a non-AWS backend. Its series are
+// aegis_provider_* with a provider label instead of aegis_aws_*.
+func (r *retryer) forProvider(provider string, transient func(error) bool, reason func(error) string) *retryer {
+	r.provider = provider
+	r.transient = transient
+	r.reason = reason
+	return r
+}
+
+func (r *retryer) count(name, opName, region string, extra map[string]string) {
+	labels := map[string]string{"op": opName, "region": region}
+	for k, v := range extra {
+		labels[k] = v
+	}
+	if r.provider == "aws" {
+		metrics.Default().IncCounter("aegis_aws_"+name, labels)
+		return
+	}
+	labels["provider"] = r.provider
+	metrics.Default().IncCounter("aegis_provider_"+name, labels)
+}
+
+// configure applies per-operation policies, with the "default" entry
+// covering every other operation.
+func (r *retryer) configure(policies map[string]RetryPolicy, budget int) {
+	if budget == 0 {
+		budget = defaultRetryBudget
+	}
+	r.budget.resize(max(budget, 0))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.defaults = policies["default"].withDefaults()
+	r.perOp = make(map[string]RetryPolicy, len(policies))
+	for op, p := range policies {
+		if op != "default" {
+			r.perOp[op] = p.withDefaults()
+		}
+	}
+}
+
+func (r *retryer) policy(op string) RetryPolicy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if p, ok := r.perOp[op]; ok {
+		return p
+	}
+	return r.defaults
+}
+
+func (r *retryer) do(ctx context.Context, opName, region string, fn func(context.Context) error) error {
+	policy := r.policy(opName)
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		if !r.transient(err) {
+			return err
+		}
+		hint, hinted := retryAfterHint(err, r.clock.Now())
+		if attempt >= policy.MaxAttempts || (hinted && hint > maxRetryAfterHint) {
+			r.count("retry_exhausted_total", opName, region, nil)
+			return err
+		}
+		if !r.budget.take(r.clock.Now()) {
+			r.count("retry_budget_exhausted_total", opName, region, nil)
+			log.Printf("event=%s_retry_budget_exhausted op=%s region=%s attempt=%d err=%q", r.provider, opName, region, attempt, err.Error())
+			return err
+		}
+		r.count("retries_total", opName, region, map[string]string{"reason": r.reason(err)})
+		delay := max(policy.backoff(attempt, r.jitter), hint)
+		log.Printf("event=%s_retry op=%s region=%s attempt=%d delay_ms=%d retry_after_hint=%t err=%q", r.provider, opName, region, attempt, delay.Milliseconds(), hinted, err.Error())
+		if err := r.clock.Sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// retryAfterHint reads the Retry-After header (seconds or an HTTP date) from
+// the HTTP response behind err, if any.
+func retryAfterHint(err error, now time.Time) (time.Duration, bool) {
+	var header http.Header
+	var respErr interface{ HTTPResponse() *smithyhttp.Response }
+	var headerErr interface{ ResponseHeader() http.Header }
+	switch {
+	case errors.As(err, &respErr):
+		if resp := respErr.HTTPResponse(); resp != nil && resp.Response != nil {
+			header = resp.Header
+		}
+	case errors.As(err, &headerErr):
+		header = headerErr.ResponseHeader()
+	}
+	raw := strings.TrimSpace(header.Get("Retry-After"))
+	if raw == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(raw); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(raw); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+func withJitter(delay time.Duration) time.Duration {
+	if delay <= 0 {
+		return 0
+	}
+	floor := delay / 10
+	span := delay - floor
+	if span <= 0 {
+		return floor
+	}
+	var raw [8]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return floor + (span / 2)
+	}
+	max := uint64(span)
+	if max == 0 {
+		return floor + (span / 2)
+	}
+	n := binary.LittleEndian.Uint64(raw[:]) % max
+	// Jittered delay in [10% of base, 100% of base).
+	return floor + time.Duration(n)
+}
@@ -0,0 +1,150 @@
+package relay
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+)
+
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(_ context.Context, d time.Duration) error {
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+	return nil
+}
+
+func newFakeRetryer(policies map[string]RetryPolicy, budget int) (*retryer, *fakeClock) {
+	clk := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	r := newRetryer(policies, budget)
+	r.clock = clk
+	r.jitter = func(d time.Duration) time.Duration { return d }
+	return r, clk
+}
+
+func throttledResponse(retryAfter string) error {
+	resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+	if retryAfter != "" {
+		resp.Header.Set("Retry-After", retryAfter)
+	}
+	return &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: resp},
+		Err:      &smithy.GenericAPIError{Code: "RequestLimitExceeded", Message: "slow down"},
+	}
+}
+
+func TestRetryer_BacksOffPerOperationPolicy(t *testing.T) {
+	r, clk := newFakeRetryer(map[string]RetryPolicy{
+		"run_instances": {MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 3 * time.Second},
+	}, -1)
+	calls := 0
+	err := r.do(context.Background(), "run_instances", "us-east-1", func(context.Context) error {
+		calls++
+		return throttledResponse("")
+	})
+	if err == nil || calls != 5 {
+		t.Fatalf("expected 5 attempts and an error, got %d calls err=%v", calls, err)
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}
+	if len(clk.sleeps) != len(want) {
+		t.Fatalf("unexpected sleeps %v", clk.sleeps)
+	}
+	for i := range want {
+		if clk.sleeps[i] != want[i] {
+			t.Fatalf("unexpected sleeps %v, want %v", clk.sleeps, want)
+		}
+	}
+
+	// Other operations keep the default policy.
+	calls = 0
+	_ = r.do(context.Background(), "describe_instances", "us-east-1", func(context.Context) error {
+		calls++
+		return throttledResponse("")
+	})
+	if calls != DefaultRetryPolicy.MaxAttempts {
+		t.Fatalf("expected default attempts, got %d", calls)
+	}
+}
+
+func TestRetryer_HonorsRetryAfterHint(t *testing.T) {
+	r, clk := newFakeRetryer(nil, -1)
+	calls := 0
+	err := r.do(context.Background(), "describe_instances", "us-east-1", func(context.Context) error {
+		calls++
+		if calls == 1 {
+			return throttledResponse("3")
+		}
+		return nil
+	})
+	if err != nil || len(clk.sleeps) != 1 || clk.sleeps[0] != 3*time.Second {
+		t.Fatalf("expected one 3s sleep, got sleeps=%v err=%v", clk.sleeps, err)
+	}
+
+	// A hint longer than maxRetryAfterHint ends the retries.
+	calls = 0
+	err = r.do(context.Background(), "describe_instances", "us-east-1", func(context.Context) error {
+		calls++
+		return throttledResponse("120")
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("expected no retry after a long hint, got %d calls err=%v", calls, err)
+	}
+}
+
+func TestRetryer_BudgetExhaustionFailsFast(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	r, clk := newFakeRetryer(map[string]RetryPolicy{"default": {MaxAttempts: 10}}, 3)
+	calls := 0
+	fail := func(context.Context) error {
+		calls++
+		return throttledResponse("")
+	}
+	_ = r.do(context.Background(), "run_instances", "us-east-1", fail)
+	if calls != 4 {
+		t.Fatalf("expected 3 budgeted retries, got %d calls", calls)
+	}
+	if out := metrics.Default().Render(); !strings.Contains(out, `aegis_aws_retry_budget_exhausted_total{op="run_instances",region="us-east-1"} 1`) {
+		t.Fatalf("expected budget exhaustion metric, got:\n%s", out)
+	}
+
+	// The budget refills with time.
+	clk.now = clk.now.Add(time.Minute)
+	calls = 0
+	_ = r.do(context.Background(), "run_instances", "us-east-1", fail)
+	if calls != 4 {
+		t.Fatalf("expected a refilled budget, got %d calls", calls)
+	}
+}
+
+func TestRetryAWS_BudgetIsPerProvisioner(t *testing.T) {
+	opts := AWSProvisionerOptions{AMIByRegion: map[string]string{"us-east-1": "ami-1"}, RetryBudget: 1}
+	first := newTestAWSProvisioner(t, opts, &fakeEC2{})
+	second := newTestAWSProvisioner(t, opts, &fakeEC2{})
+
+	calls := 0
+	throttled := func(context.Context) error {
+		calls++
+		return &smithy.GenericAPIError{Code: "RequestLimitExceeded", Message: "slow down"}
+	}
+	_ = first.retryAWS(context.Background(), "describe_instances", "us-east-1", throttled)
+	if calls != 2 {
+		t.Fatalf("expected one retry within the budget, got %d calls", calls)
+	}
+	calls = 0
+	_ = second.retryAWS(context.Background(), "describe_instances", "us-east-1", throttled)
+	if calls != 2 {
+		t.Fatalf("expected the second provisioner to have its own budget, got %d calls", calls)
+	}
+}
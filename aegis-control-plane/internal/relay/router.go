@@ -0,0 +1,196 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+// ErrUnknownRegion means no provider is configured for a region.
+var ErrUnknownRegion = errors.New("no relay provider for region")
+
+// Router is a Provisioner that runs each region on its own provider. New
+// relays go to the provider the region maps to; calls for an existing relay
+// go to the provider recorded with it, so changing the map does not strand
+// relays on the old backend.
+type Router struct {
+	providers       map[string]Provisioner
+	regions         map[string]string
+	defaultProvider string
+}
+
+// NewRouter builds a router over the named providers. regions maps a region
+// to a provider name; regions without an entry use defaultProvider, which
+// may be empty to reject them.
+func NewRouter(providers map[string]Provisioner, regions map[string]string, defaultProvider string) (*Router, error) {
+	if defaultProvider != "" && providers[defaultProvider] == nil {
+		return nil, fmt.Errorf("default relay provider %q is not configured", defaultProvider)
+	}
+	for region, name := range regions {
+		if providers[name] == nil {
+			return nil, fmt.Errorf("relay provider %q for region %s is not configured", name, region)
+		}
+	}
+	return &Router{providers: providers, regions: regions, defaultProvider: defaultProvider}, nil
+}
+
+// ProviderFor returns the name of the provider new relays in region use.
+func (r *Router) ProviderFor(region string) (string, error) {
+	if name := r.regions[region]; name != "" {
+		return name, nil
+	}
+	if r.defaultProvider != "" {
+		return r.defaultProvider, nil
+	}
+	return "", fmt.Errorf("%w %s", ErrUnknownRegion, region)
+}
+
+// route picks the recorded provider when there is one, else the region's.
+func (r *Router) route(provider, region string) (string, Provisioner, error) {
+	if provider == "" {
+		var err error
+		if provider, err = r.ProviderFor(region); err != nil {
+			return "", nil, err
+		}
+	}
+	p := r.providers[provider]
+	if p == nil {
+		return "", nil, fmt.Errorf("relay provider %q is not configured", provider)
+	}
+	return provider, p, nil
+}
+
+func (r *Router) Provision(ctx context.Context, req ProvisionRequest) (ProvisionResult, error) {
+	name, p, err := r.route("", req.Region)
+	if err != nil {
+		return ProvisionResult{}, err
+	}
+	res, err := p.Provision(ctx, req)
+	if err != nil {
+		return res, err
+	}
+	res.Provider = name
+	return res, nil
+}
+
+func (r *Router) Deprovision(ctx context.Context, req DeprovisionRequest) error {
+	_, p, err := r.route(req.Provider, req.Region)
+	if err != nil {
+		return err
+	}
+	return p.Deprovision(ctx, req)
+}
+
+func (r *Router) Status(ctx context.Context, req StatusRequest) (StatusResult, error) {
+	_, p, err := r.route(req.Provider, req.Region)
+	if err != nil {
+		return StatusResult{}, err
+	}
+	return p.Status(ctx, req)
+}
+
+func (r *Router) AuthorizeClientIP(ctx context.Context, req AuthorizeClientIPRequest) error {
+	_, p, err := r.route(req.Provider, req.Region)
+	if err != nil {
+		return err
+	}
+	return p.AuthorizeClientIP(ctx, req)
+}
+
+// ValidateConfig validates each provider against the regions it serves.
+// Regions without a provider are reported as such.
+func (r *Router) ValidateConfig(ctx context.Context, regions []string) []error {
+	byProvider := make(map[string][]string)
+	var problems []error
+	for _, region := range regions {
+		name, err := r.ProviderFor(region)
+		if err != nil {
+			problems = append(problems, &model.RegionError{Region: region, Err: err})
+			continue
+		}
+		byProvider[name] = append(byProvider[name], region)
+	}
+	for _, name := range r.names() {
+		if served := byProvider[name]; len(served) > 0 {
+			problems = append(problems, r.providers[name].ValidateConfig(ctx, served)...)
+		}
+	}
+	return problems
+}
+
+// ResolveAMI resolves through the region's provider when it supports
+// indirect images, and returns ami unchanged otherwise.
+func (r *Router) ResolveAMI(ctx context.Context, region, ami string) (string, error) {
+	_, p, err := r.route("", region)
+	if err != nil {
+		return "", err
+	}
+	if resolver, ok := p.(AMIResolver); ok {
+		return resolver.ResolveAMI(ctx, region, ami)
+	}
+	return ami, nil
+}
+
+func (r *Router) names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// warmPool returns the first provider, by name, that keeps a warm pool.
+func (r *Router) warmPool() (string, WarmPoolProvider) {
+	for _, name := range r.names() {
+		if wp, ok := r.providers[name].(WarmPoolProvider); ok {
+			return name, wp
+		}
+	}
+	return "", nil
+}
+
+// WarmPoolSettings returns the pool provider's sizes for the regions routed
+// to it; pools elsewhere would never be claimed.
+func (r *Router) WarmPoolSettings() (map[string]int, time.Duration) {
+	name, wp := r.warmPool()
+	if wp == nil {
+		return nil, 0
+	}
+	sizes, maxAge := wp.WarmPoolSettings()
+	out := make(map[string]int, len(sizes))
+	for region, size := range sizes {
+		if routed, err := r.ProviderFor(region); err == nil && routed == name {
+			out[region] = size
+		}
+	}
+	return out, maxAge
+}
+
+func (r *Router) PoolImage(ctx context.Context, region string) (string, string, error) {
+	_, wp := r.warmPool()
+	if wp == nil {
+		return "", "", errors.New("no relay provider keeps a warm pool")
+	}
+	return wp.PoolImage(ctx, region)
+}
+
+func (r *Router) LaunchPooled(ctx context.Context, region string) (model.PooledRelay, error) {
+	_, wp := r.warmPool()
+	if wp == nil {
+		return model.PooledRelay{}, errors.New("no relay provider keeps a warm pool")
+	}
+	return wp.LaunchPooled(ctx, region)
+}
+
+func (r *Router) StopPooled(ctx context.Context, region, awsInstanceID string) error {
+	_, wp := r.warmPool()
+	if wp == nil {
+		return errors.New("no relay provider keeps a warm pool")
+	}
+	return wp.StopPooled(ctx, region, awsInstanceID)
+}
@@ -0,0 +1,79 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+func fakeInstanceState(t *testing.T, f *FakeProvisioner, id string) string {
+	t.Helper()
+	for _, inst := range f.List() {
+		if inst.AWSInstanceID == id {
+			return inst.State
+		}
+	}
+	t.Fatalf("instance %s not launched by this provider", id)
+	return ""
+}
+
+func TestRouter_MixedProvidersStopOnRecordedBackend(t *testing.T) {
+	aws, hetzner := NewFakeProvisioner(), NewFakeProvisioner()
+	providers := map[string]Provisioner{"aws": aws, "hetzner": hetzner}
+	r, err := NewRouter(providers, map[string]string{"eu-central": "hetzner"}, "aws")
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+
+	us, err := r.Provision(context.Background(), ProvisionRequest{SessionID: "ses_us", Region: "us-east-1"})
+	if err != nil || us.Provider != "aws" {
+		t.Fatalf("expected us-east-1 on aws, got %+v err=%v", us, err)
+	}
+	eu, err := r.Provision(context.Background(), ProvisionRequest{SessionID: "ses_eu", Region: "eu-central"})
+	if err != nil || eu.Provider != "hetzner" {
+		t.Fatalf("expected eu-central on hetzner, got %+v err=%v", eu, err)
+	}
+
+	// Moving eu-central to aws must not strand the relay already running
+	// on hetzner.
+	r, err = NewRouter(providers, nil, "aws")
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	for _, res := range []ProvisionResult{us, eu} {
+		if err := r.Deprovision(context.Background(), DeprovisionRequest{Region: res.Region, AWSInstanceID: res.AWSInstanceID, Provider: res.Provider}); err != nil {
+			t.Fatalf("Deprovision %s: %v", res.AWSInstanceID, err)
+		}
+	}
+	if got := fakeInstanceState(t, aws, us.AWSInstanceID); got != InstanceTerminated {
+		t.Fatalf("expected aws relay terminated, got %s", got)
+	}
+	if got := fakeInstanceState(t, hetzner, eu.AWSInstanceID); got != InstanceTerminated {
+		t.Fatalf("expected hetzner relay terminated, got %s", got)
+	}
+}
+
+func TestRouter_UnknownRegion(t *testing.T) {
+	r, err := NewRouter(map[string]Provisioner{"hetzner": NewFakeProvisioner()}, map[string]string{"eu-central": "hetzner"}, "")
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	if _, err := r.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "ap-south-1"}); !errors.Is(err, ErrUnknownRegion) {
+		t.Fatalf("expected ErrUnknownRegion, got %v", err)
+	}
+	if _, err := r.Status(context.Background(), StatusRequest{Region: "ap-south-1", AWSInstanceID: "i-1"}); !errors.Is(err, ErrUnknownRegion) {
+		t.Fatalf("expected ErrUnknownRegion from Status, got %v", err)
+	}
+
+	problems := r.ValidateConfig(context.Background(), []string{"eu-central", "ap-south-1"})
+	var regionErr *model.RegionError
+	if len(problems) != 1 || !errors.As(problems[0], &regionErr) || regionErr.Region != "ap-south-1" || !errors.Is(problems[0], ErrUnknownRegion) {
+		t.Fatalf("expected one region problem for ap-south-1, got %v", problems)
+	}
+
+	if _, err := NewRouter(map[string]Provisioner{"aws": NewFakeProvisioner()}, map[string]string{"eu-central": "hetzner"}, "aws"); err == nil {
+		t.Fatal("expected a map naming an unconfigured provider to be rejected")
+	}
+}
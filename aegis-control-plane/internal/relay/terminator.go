@@ -0,0 +1,170 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+)
+
+// ErrTerminatorFull means the terminator's queue had no room. The caller
+// keeps the termination (e.g. leased in the relay_terminations outbox) and
+// submits it again later.
+var ErrTerminatorFull = errors.New("terminator queue full")
+
+const (
+	defaultTerminatorWorkers = 4
+	terminatorQueueSize      = 100
+)
+
+// Terminator runs Deprovision calls on a bounded pool of workers shared by
+// every caller. Terminations of the same instance are de-duplicated: while
+// one is queued or running, further requests for that instance wait for its
+// result instead of calling the provider again. Persistence is the caller's
+// job; a failed or dropped termination is retried from the outbox.
+type Terminator struct {
+	prov    Provisioner
+	workers int
+	inline  bool
+	queue   chan *termination
+
+	mu      sync.Mutex
+	pending map[string]*termination
+}
+
+type termination struct {
+	req      DeprovisionRequest
+	queuedAt time.Time
+	done     []func(error)
+}
+
+type TerminatorOption func(*Terminator)
+
+// WithTerminatorWorkers sets how many Deprovision calls run at once.
+func WithTerminatorWorkers(n int) TerminatorOption {
+	return func(t *Terminator) {
+		if n > 0 {
+			t.workers = n
+		}
+	}
+}
+
+// WithInlineTermination makes Enqueue run the call before returning, with
+// no workers to start; tests use it to stay deterministic.
+func WithInlineTermination() TerminatorOption {
+	return func(t *Terminator) {
+		t.inline = true
+	}
+}
+
+func NewTerminator(prov Provisioner, opts ...TerminatorOption) *Terminator {
+	t := &Terminator{
+		prov:    prov,
+		workers: defaultTerminatorWorkers,
+		queue:   make(chan *termination, terminatorQueueSize),
+		pending: make(map[string]*termination),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Start runs the workers until ctx is done. Terminations still queued then
+// are dropped without calling done; their outbox entries are claimed again
+// once the lease lapses.
+func (t *Terminator) Start(ctx context.Context) {
+	if t.inline {
+		return
+	}
+	for range t.workers {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case term := <-t.queue:
+					t.run(ctx, term)
+				}
+			}
+		}()
+	}
+}
+
+// Enqueue schedules the termination and calls done with its result, which
+// is nil, ErrTerminationPending or the provider's error. A termination of
+// the same instance already queued or running is joined rather than
+// repeated. ErrTerminatorFull is returned, and done is not called, when the
+// queue has no room.
+func (t *Terminator) Enqueue(req DeprovisionRequest, done func(error)) error {
+	t.mu.Lock()
+	if term := t.pending[req.AWSInstanceID]; term != nil && req.AWSInstanceID != "" {
+		term.done = append(term.done, done)
+		t.mu.Unlock()
+		return nil
+	}
+	term := &termination{req: req, queuedAt: time.Now(), done: []func(error){done}}
+	if t.inline {
+		t.track(term)
+		t.mu.Unlock()
+		t.run(context.Background(), term)
+		return nil
+	}
+	select {
+	case t.queue <- term:
+		t.track(term)
+		t.mu.Unlock()
+		return nil
+	default:
+		t.mu.Unlock()
+		return ErrTerminatorFull
+	}
+}
+
+// Terminate enqueues the termination and waits for its result.
+func (t *Terminator) Terminate(ctx context.Context, req DeprovisionRequest) error {
+	result := make(chan error, 1)
+	if err := t.Enqueue(req, func(err error) { result <- err }); err != nil {
+		return err
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// track records a termination as pending. Callers hold t.mu.
+func (t *Terminator) track(term *termination) {
+	if term.req.AWSInstanceID != "" {
+		t.pending[term.req.AWSInstanceID] = term
+	}
+	metrics.Default().SetGauge("aegis_relay_terminator_queue_depth", float64(len(t.pending)), nil)
+}
+
+func (t *Terminator) run(ctx context.Context, term *termination) {
+	err := t.prov.Deprovision(ctx, term.req)
+
+	t.mu.Lock()
+	if t.pending[term.req.AWSInstanceID] == term {
+		delete(t.pending, term.req.AWSInstanceID)
+	}
+	metrics.Default().SetGauge("aegis_relay_terminator_queue_depth", float64(len(t.pending)), nil)
+	done := term.done
+	t.mu.Unlock()
+
+	status := "ok"
+	switch {
+	case errors.Is(err, ErrTerminationPending):
+		status = "pending"
+	case err != nil:
+		status = "error"
+	}
+	metrics.Default().ObserveHistogram("aegis_relay_terminator_completion_ms", float64(time.Since(term.queuedAt).Milliseconds()), map[string]string{"region": term.req.Region, "status": status})
+	for _, fn := range done {
+		fn(err)
+	}
+}
@@ -0,0 +1,114 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+)
+
+// gatedDeprovisioner blocks Deprovision until release is closed.
+type gatedDeprovisioner struct {
+	*FakeProvisioner
+	calls   atomic.Int32
+	started chan string
+	release chan struct{}
+}
+
+func (g *gatedDeprovisioner) Deprovision(_ context.Context, req DeprovisionRequest) error {
+	g.calls.Add(1)
+	g.started <- req.AWSInstanceID
+	<-g.release
+	if req.AWSInstanceID == "i-pending" {
+		return ErrTerminationPending
+	}
+	return nil
+}
+
+func TestTerminator_JoinsTerminationOfSameInstance(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	prov := &gatedDeprovisioner{FakeProvisioner: NewFakeProvisioner(), started: make(chan string, 4), release: make(chan struct{})}
+	term := NewTerminator(prov, WithTerminatorWorkers(2))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	term.Start(ctx)
+
+	results := make(chan error, 3)
+	done := func(err error) { results <- err }
+	for _, id := range []string{"i-pending", "i-pending", "i-other"} {
+		if err := term.Enqueue(DeprovisionRequest{Region: "us-east-1", AWSInstanceID: id}, done); err != nil {
+			t.Fatalf("Enqueue %s: %v", id, err)
+		}
+	}
+	<-prov.started
+	<-prov.started
+	if out := metrics.Default().Render(); !strings.Contains(out, "aegis_relay_terminator_queue_depth 2") {
+		t.Fatalf("expected two terminations pending, got:\n%s", out)
+	}
+	close(prov.release)
+
+	var pending int
+	for range 3 {
+		select {
+		case err := <-results:
+			if errors.Is(err, ErrTerminationPending) {
+				pending++
+			} else if err != nil {
+				t.Fatalf("unexpected result: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for terminations")
+		}
+	}
+	if pending != 2 || prov.calls.Load() != 2 {
+		t.Fatalf("expected the duplicate to share one call, pending=%d calls=%d", pending, prov.calls.Load())
+	}
+	out := metrics.Default().Render()
+	for _, want := range []string{
+		"aegis_relay_terminator_queue_depth 0",
+		`aegis_relay_terminator_completion_ms_count{region="us-east-1",status="pending"} 1`,
+		`aegis_relay_terminator_completion_ms_count{region="us-east-1",status="ok"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %s, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestTerminator_FullQueueRejects(t *testing.T) {
+	prov := &gatedDeprovisioner{FakeProvisioner: NewFakeProvisioner(), started: make(chan string, 1), release: make(chan struct{})}
+	// Not started, so nothing drains the queue.
+	term := NewTerminator(prov)
+	for i := range terminatorQueueSize {
+		if err := term.Enqueue(DeprovisionRequest{AWSInstanceID: fmt.Sprintf("i-%d", i)}, func(error) {}); err != nil {
+			t.Fatalf("Enqueue %d: %v", i, err)
+		}
+	}
+	if err := term.Enqueue(DeprovisionRequest{AWSInstanceID: "i-overflow"}, func(error) {}); !errors.Is(err, ErrTerminatorFull) {
+		t.Fatalf("expected ErrTerminatorFull, got %v", err)
+	}
+	// A termination already queued is still joined.
+	if err := term.Enqueue(DeprovisionRequest{AWSInstanceID: "i-0"}, func(error) {}); err != nil {
+		t.Fatalf("expected a queued instance to be joined, got %v", err)
+	}
+}
+
+func TestTerminator_InlineRunsBeforeReturning(t *testing.T) {
+	prov := NewFakeProvisioner()
+	res, err := prov.Provision(context.Background(), ProvisionRequest{SessionID: "ses_1", Region: "us-east-1"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	term := NewTerminator(prov, WithInlineTermination())
+	if err := term.Terminate(context.Background(), DeprovisionRequest{Region: res.Region, AWSInstanceID: res.AWSInstanceID}); err != nil {
+		t.Fatalf("Terminate: %v", err)
+	}
+	if got := fakeInstanceState(t, prov, res.AWSInstanceID); got != InstanceTerminated {
+		t.Fatalf("expected the relay terminated, got %s", got)
+	}
+}
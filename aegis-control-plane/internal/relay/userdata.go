@@ -0,0 +1,85 @@
+package relay
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+)
+
+// bootstrapPath is where cloud-init writes the relay's bootstrap config.
+const bootstrapPath = "/etc/aegis/relay.json"
+
+// relayBootstrap is the per-session configuration a relay reads at boot.
+//
+// User data stays readable through instance metadata for the lifetime of the
+// instance, so it only carries credentials scoped to this session: the relay
+// token dies with the session, and the control-plane shared key is never
+// included.
+type relayBootstrap struct {
+	SessionID       string `json:"session_id"`
+	UserID          string `json:"user_id"`
+	Region          string `json:"region"`
+	ControlPlaneURL string `json:"control_plane_url"`
+	RelayAuthToken  string `json:"relay_auth_token"`
+	// SRTPorts are the ports the relay binds SRT listeners on; SRTPort, the
+	// first, is kept for agents that bind only one.
+	SRTPort  int   `json:"srt_port"`
+	SRTPorts []int `json:"srt_ports"`
+}
+
+func bootstrapDoc(req ProvisionRequest) ([]byte, error) {
+	ports := req.srtPorts()
+	return json.Marshal(relayBootstrap{
+		SessionID:       req.SessionID,
+		UserID:          req.UserID,
+		Region:          req.Region,
+		ControlPlaneURL: req.ControlPlaneURL,
+		RelayAuthToken:  req.RelayAuthToken,
+		SRTPort:         ports[0],
+		SRTPorts:        ports,
+	})
+}
+
+// renderUserData returns base64-encoded cloud-config that writes the relay
+// bootstrap JSON to bootstrapPath, readable by root only.
+func renderUserData(req ProvisionRequest) (string, error) {
+	cloudConfig, err := renderCloudConfig(req)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString([]byte(cloudConfig)), nil
+}
+
+// renderCloudConfig is renderUserData before encoding, for providers that
+// take user data as plain text.
+func renderCloudConfig(req ProvisionRequest) (string, error) {
+	doc, err := bootstrapDoc(req)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`#cloud-config
+write_files:
+  - path: %s
+    owner: root:root
+    permissions: "0600"
+    encoding: b64
+    content: %s
+`, bootstrapPath, base64.StdEncoding.EncodeToString(doc)), nil
+}
+
+// renderBootHook returns raw user data for a warm pool instance claimed by
+// req. write_files only runs on an instance's first boot, so pooled relays
+// get a boothook, which cloud-init runs on every boot, instead.
+func renderBootHook(req ProvisionRequest) ([]byte, error) {
+	doc, err := bootstrapDoc(req)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf(`#cloud-boothook
+#!/bin/sh
+umask 077
+mkdir -p %s
+echo '%s' | base64 -d > %s
+`, path.Dir(bootstrapPath), base64.StdEncoding.EncodeToString(doc), bootstrapPath)), nil
+}
@@ -0,0 +1,110 @@
+package relay
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// relayWSPort is the relay's telemetry websocket port.
+const relayWSPort = 7443
+
+// DefaultWSTemplate is the relay telemetry URL when AEGIS_RELAY_WS_TEMPLATE
+// is unset: the relay's public IP on the telemetry port.
+const DefaultWSTemplate = "wss://{public_ip}:7443/telemetry"
+
+// WSVars are the values a WSTemplate's placeholders are replaced with.
+type WSVars struct {
+	PublicIP   string
+	InstanceID string
+	SessionID  string
+}
+
+var wsPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// WSTemplate renders relay telemetry URLs from a template with {public_ip},
+// {instance_id} and {session_id} placeholders. The zero value renders
+// DefaultWSTemplate.
+type WSTemplate struct {
+	raw string
+}
+
+// ParseWSTemplate validates s, which must render an absolute ws or wss URL
+// and use no other placeholders. An empty s is DefaultWSTemplate.
+func ParseWSTemplate(s string) (WSTemplate, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return WSTemplate{}, nil
+	}
+	for _, p := range wsPlaceholder.FindAllString(s, -1) {
+		switch p {
+		case "{public_ip}", "{instance_id}", "{session_id}":
+		default:
+			return WSTemplate{}, fmt.Errorf("unknown placeholder %s in relay ws template", p)
+		}
+	}
+	t := WSTemplate{raw: s}
+	u, err := url.Parse(t.Render(WSVars{PublicIP: "192.0.2.1", InstanceID: "i-0abc", SessionID: "ses_1"}))
+	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Hostname() == "" {
+		return WSTemplate{}, fmt.Errorf("relay ws template %q must render an absolute ws or wss URL", s)
+	}
+	if port := u.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return WSTemplate{}, fmt.Errorf("relay ws template %q has an invalid port", s)
+		}
+	}
+	return t, nil
+}
+
+func (t WSTemplate) String() string {
+	if t.raw == "" {
+		return DefaultWSTemplate
+	}
+	return t.raw
+}
+
+// Render returns the URL for v. An IPv6 public IP is bracketed, as a URL
+// host needs.
+func (t WSTemplate) Render(v WSVars) string {
+	ip := v.PublicIP
+	if strings.Contains(ip, ":") {
+		ip = "[" + ip + "]"
+	}
+	return strings.NewReplacer(
+		"{public_ip}", ip,
+		"{instance_id}", v.InstanceID,
+		"{session_id}", v.SessionID,
+	).Replace(t.String())
+}
+
+// Host returns the host name the URL for v points at, without its port.
+func (t WSTemplate) Host(v WSVars) string {
+	u, err := url.Parse(t.Render(v))
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// NamesRelays reports whether the rendered host is a per-relay DNS name:
+// it comes from the instance or session ID rather than the public IP.
+func (t WSTemplate) NamesRelays() bool {
+	a := t.Host(WSVars{PublicIP: "192.0.2.1", InstanceID: "i-0a", SessionID: "ses_a"})
+	b := t.Host(WSVars{PublicIP: "192.0.2.1", InstanceID: "i-0b", SessionID: "ses_b"})
+	otherIP := t.Host(WSVars{PublicIP: "192.0.2.2", InstanceID: "i-0a", SessionID: "ses_a"})
+	return net.ParseIP(a) == nil && a != b && a == otherIP
+}
+
+// usesSessionID reports whether the rendered host depends on the session.
+func (t WSTemplate) usesSessionID() bool {
+	return t.Host(WSVars{InstanceID: "i-0a", SessionID: "ses_a"}) != t.Host(WSVars{InstanceID: "i-0a", SessionID: "ses_b"})
+}
+
+// relayWSURLAt builds the telemetry URL of a relay reached at host on a
+// remapped port; IPv6 literals are bracketed.
+func relayWSURLAt(host string, port int) string {
+	return "wss://" + net.JoinHostPort(host, strconv.Itoa(port)) + "/telemetry"
+}
@@ -0,0 +1,48 @@
+package relay
+
+import "testing"
+
+func TestParseWSTemplate_RendersPlaceholders(t *testing.T) {
+	vars := WSVars{PublicIP: "198.51.100.7", InstanceID: "i-0abc", SessionID: "ses_1"}
+	for in, want := range map[string]string{
+		"": "wss://198.51.100.7:7443/telemetry",
+		"wss://{instance_id}.relays.example.com/telemetry": "wss://i-0abc.relays.example.com/telemetry",
+		"ws://{public_ip}:9000/t?s={session_id}":           "ws://198.51.100.7:9000/t?s=ses_1",
+	} {
+		tmpl, err := ParseWSTemplate(in)
+		if err != nil {
+			t.Fatalf("ParseWSTemplate(%q): %v", in, err)
+		}
+		if got := tmpl.Render(vars); got != want {
+			t.Fatalf("Render(%q) = %q, want %q", in, got, want)
+		}
+	}
+	var zero WSTemplate
+	if got := zero.Render(WSVars{PublicIP: "2600:1f18::31"}); got != "wss://[2600:1f18::31]:7443/telemetry" {
+		t.Fatalf("expected a bracketed IPv6 host, got %q", got)
+	}
+	for _, in := range []string{"wss://{ip}:7443/telemetry", "https://{public_ip}/telemetry", "{public_ip}:7443", "wss://{public_ip}:99999/telemetry"} {
+		if _, err := ParseWSTemplate(in); err == nil {
+			t.Fatalf("expected ParseWSTemplate(%q) to fail", in)
+		}
+	}
+}
+
+func TestWSTemplate_NamesRelays(t *testing.T) {
+	for in, want := range map[string]bool{
+		"":                                     false,
+		"wss://relay.example.com/telemetry":    false,
+		"wss://{instance_id}.example.com/t":    true,
+		"wss://{session_id}.example.com/t":     true,
+		"wss://{public_ip}.nip.io/telemetry":   false,
+		"wss://relay.example.com/{session_id}": false,
+	} {
+		tmpl, err := ParseWSTemplate(in)
+		if err != nil {
+			t.Fatalf("ParseWSTemplate(%q): %v", in, err)
+		}
+		if got := tmpl.NamesRelays(); got != want {
+			t.Fatalf("NamesRelays(%q) = %t, want %t", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+// Package seed fills a database with realistic history for dashboards,
+// manual QA and integration tests: users across plan tiers, past sessions
+// with relay heartbeats, outages the usage jobs reconcile, and sessions
+// stuck in provisioning. It writes through the store, so the data meets the
+// same constraints as production writes.
+package seed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+type Options struct {
+	Users           int
+	SessionsPerUser int
+	// Days is how far back session history reaches.
+	Days int
+	// Seed makes the generated users, timings and outages repeatable.
+	Seed uint64
+	// HealthInterval is the spacing of recorded relay heartbeats.
+	HealthInterval time.Duration
+	Regions        []string
+}
+
+// Summary counts what Generate wrote.
+type Summary struct {
+	Users        int
+	Sessions     int
+	Provisioning int
+	Outages      int
+	Heartbeats   int
+}
+
+var tiers = []struct {
+	name     string
+	included int
+}{
+	{"free", 0},
+	{"starter", 54000},
+	{"standard", 144000},
+	{"pro", 360000},
+}
+
+type userPlan struct {
+	ID              string
+	Email           string
+	Tier            string
+	IncludedSeconds int
+	CreatedAt       time.Time
+	CycleStart      *time.Time
+	CycleEnd        *time.Time
+	Sessions        []sessionPlan
+}
+
+type sessionPlan struct {
+	Region    string
+	StartedAt time.Time
+	Length    time.Duration
+	// Stuck sessions never get a relay and stay provisioning.
+	Stuck bool
+	// OutageAt and OutageLength place a gap in the heartbeats that the
+	// live-duration rollup also missed; reconciliation from the relay's
+	// reported uptime recovers it.
+	OutageAt     time.Duration
+	OutageLength time.Duration
+}
+
+// plan lays out the data to write. It depends only on opts and now.
+func plan(opts Options, now time.Time) []userPlan {
+	rng := rand.New(rand.NewPCG(opts.Seed, 0x5eed))
+	history := time.Duration(opts.Days) * 24 * time.Hour
+	slot := history / time.Duration(opts.SessionsPerUser)
+	users := make([]userPlan, 0, opts.Users)
+	for i := range opts.Users {
+		tier := tiers[rng.IntN(len(tiers))]
+		u := userPlan{
+			ID:              fmt.Sprintf("usr_seed_%04d", i+1),
+			Email:           fmt.Sprintf("seed%04d@example.test", i+1),
+			Tier:            tier.name,
+			IncludedSeconds: tier.included,
+			CreatedAt:       now.Add(-history - time.Duration(rng.IntN(60*24))*time.Hour).Truncate(time.Hour),
+		}
+		// Users without a plan start their free cycle on first usage read.
+		if tier.name != "free" {
+			start, end := cycleAt(u.CreatedAt, now)
+			u.CycleStart, u.CycleEnd = &start, &end
+		}
+
+		for j := range opts.SessionsPerUser {
+			slotStart := now.Add(-history + time.Duration(j)*slot)
+			length := max(min(10*time.Minute+time.Duration(rng.Int64N(int64(4*time.Hour))), slot/2), time.Minute).Truncate(time.Second)
+			sp := sessionPlan{
+				Region:    opts.Regions[rng.IntN(len(opts.Regions))],
+				StartedAt: slotStart.Add(time.Duration(rng.Int64N(int64(slot/2) + 1))).Truncate(time.Second),
+				Length:    length,
+			}
+			if length >= 20*time.Minute && rng.IntN(6) == 0 {
+				sp.OutageAt = (length/4 + time.Duration(rng.Int64N(int64(length/4)))).Truncate(time.Second)
+				sp.OutageLength = (length / 4).Truncate(time.Second)
+			}
+			u.Sessions = append(u.Sessions, sp)
+		}
+		// Every fifth user's latest start never got a relay.
+		if i%5 == 4 {
+			last := &u.Sessions[len(u.Sessions)-1]
+			*last = sessionPlan{
+				Region:    last.Region,
+				StartedAt: maxTime(last.StartedAt, now.Add(-time.Duration(15+rng.IntN(75))*time.Minute)).Truncate(time.Second),
+				Stuck:     true,
+			}
+		}
+		users = append(users, u)
+	}
+	return users
+}
+
+// cycleAt returns the month-long billing cycle, counted from anchor, that
+// contains now.
+func cycleAt(anchor, now time.Time) (time.Time, time.Time) {
+	months := 0
+	for !anchor.AddDate(0, months+1, 0).After(now) {
+		months++
+	}
+	return anchor.AddDate(0, months, 0), anchor.AddDate(0, months+1, 0)
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func (o Options) validate() error {
+	switch {
+	case o.Users < 1:
+		return errors.New("users must be at least 1")
+	case o.SessionsPerUser < 1:
+		return errors.New("sessions per user must be at least 1")
+	case o.Days < 1:
+		return errors.New("days must be at least 1")
+	case o.HealthInterval <= 0:
+		return errors.New("health interval must be positive")
+	case len(o.Regions) == 0:
+		return errors.New("at least one region is required")
+	case time.Duration(o.Days)*24*time.Hour/time.Duration(o.SessionsPerUser) < 4*time.Minute:
+		return errors.New("too many sessions per user for the days of history")
+	}
+	return nil
+}
+
+// Generate writes the planned users and sessions, then reconciles outages
+// and rolls up usage as the jobs worker would. Run it with the worker
+// stopped: a worker could claim the seeded relay terminations first.
+func Generate(ctx context.Context, st *store.Store, opts Options) (Summary, error) {
+	var sum Summary
+	if err := opts.validate(); err != nil {
+		return sum, err
+	}
+	for _, u := range plan(opts, time.Now().UTC()) {
+		if err := st.CreateUser(ctx, store.CreateUserInput{
+			ID:              u.ID,
+			Email:           u.Email,
+			PlanTier:        u.Tier,
+			CycleStart:      u.CycleStart,
+			CycleEnd:        u.CycleEnd,
+			IncludedSeconds: u.IncludedSeconds,
+			CreatedAt:       u.CreatedAt,
+		}); err != nil {
+			return sum, fmt.Errorf("create user %s: %w", u.ID, err)
+		}
+		sum.Users++
+		for _, sp := range u.Sessions {
+			beats, err := writeSession(ctx, st, u.ID, sp, opts.HealthInterval)
+			if err != nil {
+				return sum, fmt.Errorf("seed session for %s: %w", u.ID, err)
+			}
+			sum.Sessions++
+			sum.Heartbeats += beats
+			if sp.Stuck {
+				sum.Provisioning++
+			}
+			if sp.OutageLength > 0 {
+				sum.Outages++
+			}
+		}
+	}
+	// Seeded rows are backdated behind any high-water marks the rollups
+	// already keep.
+	if err := st.ResetJobWatermarks(ctx); err != nil {
+		return sum, fmt.Errorf("reset job watermarks: %w", err)
+	}
+	if _, err := st.ReconcileOutageFromHealth(ctx); err != nil {
+		return sum, fmt.Errorf("reconcile outages: %w", err)
+	}
+	if _, err := st.UpsertUsageRollups(ctx); err != nil {
+		return sum, fmt.Errorf("roll up usage: %w", err)
+	}
+	return sum, nil
+}
+
+// writeSession runs one session through start, activation, heartbeats and
+// stop as it happens now, then backdates it into place. It returns the
+// number of heartbeats recorded.
+func writeSession(ctx context.Context, st *store.Store, userID string, sp sessionPlan, interval time.Duration) (int, error) {
+	sess, _, err := st.StartOrGetSession(ctx, store.StartInput{
+		UserID:         userID,
+		Region:         sp.Region,
+		RequestedBy:    "seed",
+		IdempotencyKey: uuid.New(),
+		RequestHash:    "seed",
+	})
+	if err != nil {
+		return 0, err
+	}
+	if sp.Stuck {
+		return 0, st.BackdateSession(ctx, sess.ID, time.Since(sp.StartedAt))
+	}
+
+	ip := fmt.Sprintf("203.0.113.%d", 10+rand.IntN(200))
+	if _, err := st.ActivateProvisionedSession(ctx, store.ActivateProvisionedSessionInput{
+		UserID:        userID,
+		SessionID:     sess.ID,
+		Region:        sp.Region,
+		AWSInstanceID: "i-seed-" + sess.ID,
+		AMIID:         "ami-placeholder-" + sp.Region,
+		InstanceType:  "t4g.small",
+		PublicIP:      ip,
+		SRTPorts:      []int{9000},
+		WSURL:         "wss://" + ip + ":7443/telemetry",
+		PairToken:     pairToken(),
+		NewPairToken:  func() (string, error) { return pairToken(), nil },
+		RelayWSToken:  uuid.NewString(),
+		Provider:      "fake",
+	}); err != nil {
+		return 0, err
+	}
+
+	// The measured duration is what the live rollup saw; an outage is time
+	// it missed.
+	if err := st.BackdateSession(ctx, sess.ID, sp.Length-sp.OutageLength); err != nil {
+		return 0, err
+	}
+	if _, err := st.RollupLiveSessionDurations(ctx); err != nil {
+		return 0, err
+	}
+	if sp.OutageLength > 0 {
+		if err := st.BackdateSession(ctx, sess.ID, sp.OutageLength); err != nil {
+			return 0, err
+		}
+	}
+
+	started := time.Now().Add(-sp.Length)
+	beats := 0
+	for up := interval; up <= sp.Length; up += interval {
+		if sp.OutageLength > 0 && up > sp.OutageAt && up < sp.OutageAt+sp.OutageLength {
+			continue
+		}
+		if err := st.RecordRelayHealth(ctx, store.RelayHealthInput{
+			SessionID:            sess.ID,
+			ObservedAt:           started.Add(up),
+			IngestActive:         true,
+			EgressActive:         true,
+			SessionUptimeSeconds: int(up.Seconds()),
+			RawPayload:           []byte(`{"source":"seed"}`),
+		}); err != nil {
+			return beats, err
+		}
+		beats++
+	}
+
+	if _, err := st.StopSession(ctx, store.StopSessionInput{UserID: userID, SessionID: sess.ID, Reason: model.StopReasonUser}); err != nil {
+		return beats, err
+	}
+	if err := completeTermination(ctx, st, sess.ID); err != nil {
+		return beats, err
+	}
+	return beats, st.BackdateSession(ctx, sess.ID, time.Since(sp.StartedAt.Add(sp.Length)))
+}
+
+// completeTermination confirms the relay termination StopSession queued,
+// as the worker's drain would with the fake provider.
+func completeTermination(ctx context.Context, st *store.Store, sessionID string) error {
+	for {
+		due, err := st.ClaimRelayTerminations(ctx, 10, time.Second)
+		if err != nil {
+			return err
+		}
+		if len(due) == 0 {
+			return fmt.Errorf("termination for session %s was not queued; is a jobs worker running?", sessionID)
+		}
+		for _, t := range due {
+			if t.SessionID == sessionID {
+				return st.CompleteRelayTermination(ctx, t, true, 0)
+			}
+		}
+	}
+}
+
+const pairTokenAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func pairToken() string {
+	b := make([]byte, 8)
+	for i := range b {
+		b[i] = pairTokenAlphabet[rand.IntN(len(pairTokenAlphabet))]
+	}
+	return string(b)
+}
@@ -0,0 +1,67 @@
+package seed
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPlan_DeterministicAndNonOverlapping(t *testing.T) {
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+	opts := Options{Users: 10, SessionsPerUser: 12, Days: 14, Seed: 7, HealthInterval: time.Minute, Regions: []string{"us-east-1", "eu-west-1"}}
+	users := plan(opts, now)
+	if !reflect.DeepEqual(users, plan(opts, now)) {
+		t.Fatal("expected the same plan for the same seed")
+	}
+	opts.Seed = 8
+	if reflect.DeepEqual(users, plan(opts, now)) {
+		t.Fatal("expected a different plan for another seed")
+	}
+
+	var stuck, outages int
+	for _, u := range users {
+		if len(u.Sessions) != 12 {
+			t.Fatalf("%s: expected 12 sessions, got %d", u.ID, len(u.Sessions))
+		}
+		if (u.Tier == "free") != (u.CycleStart == nil) {
+			t.Fatalf("%s: expected a cycle exactly for paid tiers, tier=%s", u.ID, u.Tier)
+		}
+		if u.CycleStart != nil && (u.CycleStart.After(now) || !u.CycleEnd.After(now)) {
+			t.Fatalf("%s: cycle %s - %s does not contain now", u.ID, u.CycleStart, u.CycleEnd)
+		}
+		var prevEnd time.Time
+		for i, sp := range u.Sessions {
+			if sp.StartedAt.Before(prevEnd) || sp.StartedAt.Add(sp.Length).After(now) {
+				t.Fatalf("%s session %d: %s for %s overlaps its neighbours or ends after now", u.ID, i, sp.StartedAt, sp.Length)
+			}
+			if sp.Stuck {
+				stuck++
+				if i != len(u.Sessions)-1 {
+					t.Fatalf("%s: stuck session %d is not the latest", u.ID, i)
+				}
+			}
+			if sp.OutageLength > 0 {
+				outages++
+				if sp.OutageAt+sp.OutageLength >= sp.Length {
+					t.Fatalf("%s session %d: outage runs past the session end", u.ID, i)
+				}
+			}
+			prevEnd = sp.StartedAt.Add(sp.Length)
+		}
+	}
+	if stuck != 2 || outages == 0 {
+		t.Fatalf("expected 2 stuck sessions and some outages, got %d and %d", stuck, outages)
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	valid := Options{Users: 1, SessionsPerUser: 10, Days: 1, HealthInterval: time.Minute, Regions: []string{"us-east-1"}}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("expected valid options, got %v", err)
+	}
+	crowded := valid
+	crowded.SessionsPerUser = 1000
+	if err := crowded.validate(); err == nil {
+		t.Fatal("expected an error when sessions do not fit in the history")
+	}
+}
@@ -0,0 +1,106 @@
+package session
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+)
+
+// attemptLog records the provision attempts of one start in
+// provision_attempts. Recording is best effort: a failed write is logged and
+// never fails the start.
+type attemptLog struct {
+	svc       *Service
+	ctx       context.Context
+	sessionID string
+
+	reported int
+	// lastID is the latest recorded attempt, which a compensation is
+	// recorded on.
+	lastID int64
+}
+
+func (s *Service) newAttemptLog(ctx context.Context, sessionID string) *attemptLog {
+	return &attemptLog{
+		svc: s,
+		// A canceled start still has its attempts recorded.
+		ctx:       context.WithoutCancel(ctx),
+		sessionID: sessionID,
+	}
+}
+
+// report records an attempt a provider reported through
+// relay.ProvisionRequest.OnAttempt.
+func (l *attemptLog) report(a relay.ProvisionAttempt) {
+	l.reported++
+	l.record(l.svc.providerFor(a.Region), a)
+}
+
+// finish records the Provision call as a single attempt when the provider
+// reported none.
+func (l *attemptLog) finish(region string, start time.Time, res relay.ProvisionResult, err error) {
+	if l.reported > 0 {
+		return
+	}
+	a := relay.ProvisionAttempt{Region: region, InstanceType: res.InstanceType, StartedAt: start, FinishedAt: time.Now(), InstanceID: res.AWSInstanceID, Err: err}
+	if res.Region != "" {
+		a.Region = res.Region
+	}
+	provider := res.Provider
+	if provider == "" {
+		provider = l.svc.providerFor(a.Region)
+	}
+	l.record(provider, a)
+}
+
+func (l *attemptLog) record(provider string, a relay.ProvisionAttempt) {
+	outcome, code, errMsg := model.ProvisionAttemptSucceeded, "", ""
+	if a.Err != nil {
+		outcome, code, errMsg = model.ProvisionAttemptFailed, relay.ErrorCode(a.Err), a.Err.Error()
+	}
+	label := code
+	switch {
+	case a.Err == nil:
+		label = "none"
+	case code == "":
+		label = "unknown"
+	}
+	metrics.Default().IncCounter("aegis_relay_provision_attempts_total", map[string]string{
+		"provider":   provider,
+		"region":     a.Region,
+		"outcome":    outcome,
+		"error_code": label,
+	})
+	id, err := l.svc.store.RecordProvisionAttempt(l.ctx, model.ProvisionAttempt{
+		SessionID:    l.sessionID,
+		Provider:     provider,
+		Region:       a.Region,
+		InstanceType: a.InstanceType,
+		StartedAt:    a.StartedAt,
+		FinishedAt:   a.FinishedAt,
+		Outcome:      outcome,
+		AWSErrorCode: code,
+		Error:        errMsg,
+		InstanceID:   a.InstanceID,
+	})
+	if err != nil {
+		log.Printf("event=provision_attempt_record_failed session_id=%s region=%s err=%q", l.sessionID, a.Region, err.Error())
+		return
+	}
+	l.lastID = id
+}
+
+// compensated records how the failed start was cleaned up on its last
+// attempt.
+func (l *attemptLog) compensated(compensation string) {
+	if l.lastID == 0 {
+		return
+	}
+	if err := l.svc.store.SetProvisionAttemptCompensation(l.ctx, l.lastID, compensation); err != nil {
+		log.Printf("event=provision_attempt_record_failed session_id=%s attempt_id=%d err=%q", l.sessionID, l.lastID, err.Error())
+	}
+}
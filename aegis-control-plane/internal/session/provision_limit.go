@@ -0,0 +1,88 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+)
+
+// ErrProvisionQueueFull means a relay start waited its whole queue timeout
+// without getting a provision slot.
+var ErrProvisionQueueFull = errors.New("provision queue full")
+
+// provisionLimiter caps concurrent Provision calls per region, so a burst of
+// starts queues here instead of exhausting the provider's API quota. A nil
+// limiter admits everything.
+type provisionLimiter struct {
+	limit int
+
+	mu      sync.Mutex
+	slots   map[string]chan struct{}
+	waiting map[string]int
+}
+
+func newProvisionLimiter(limit int) *provisionLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &provisionLimiter{
+		limit:   limit,
+		slots:   make(map[string]chan struct{}),
+		waiting: make(map[string]int),
+	}
+}
+
+// acquire takes a provision slot in region, waiting up to timeout for one.
+// The returned release must be called once Provision returns.
+func (l *provisionLimiter) acquire(ctx context.Context, region string, timeout time.Duration) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	slots := l.regionSlots(region)
+	release := func() { <-slots }
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	start := time.Now()
+	l.setWaiting(region, 1)
+	defer l.setWaiting(region, -1)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	status, err := "ok", error(nil)
+	select {
+	case slots <- struct{}{}:
+	case <-timer.C:
+		status, err = "timeout", ErrProvisionQueueFull
+	case <-ctx.Done():
+		status, err = "canceled", ctx.Err()
+	}
+	metrics.Default().ObserveHistogram("aegis_relay_provision_queue_wait_ms", float64(time.Since(start).Milliseconds()), map[string]string{"region": region, "status": status})
+	if err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+func (l *provisionLimiter) regionSlots(region string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slots := l.slots[region]
+	if slots == nil {
+		slots = make(chan struct{}, l.limit)
+		l.slots[region] = slots
+	}
+	return slots
+}
+
+func (l *provisionLimiter) setWaiting(region string, delta int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.waiting[region] += delta
+	metrics.Default().SetGauge("aegis_relay_provision_queue_depth", float64(l.waiting[region]), map[string]string{"region": region})
+}
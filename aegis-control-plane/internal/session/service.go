@@ -0,0 +1,420 @@
+// Package session starts and stops relay sessions. A start records the
+// session, launches its relay, waits for it to boot and activates the
+// session, undoing what it did when a later step fails. Callers translate
+// the results; nothing here depends on HTTP.
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/telemyapp/aegis-control-plane/internal/alert"
+	"github.com/telemyapp/aegis-control-plane/internal/config"
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+// A failed start wraps one of these along with the cause. Store errors
+// (store.ErrIdempotencyMismatch, store.ErrSessionStopping,
+// store.ErrSessionLimit) are returned as they are.
+var (
+	ErrTokens    = errors.New("token generation failed")
+	ErrProvision = errors.New("relay provisioning failed")
+	ErrActivate  = errors.New("failed to activate relay session")
+)
+
+// Store is the persistence a start or stop needs.
+type Store interface {
+	StartOrGetSession(ctx context.Context, in store.StartInput) (*model.Session, bool, error)
+	PreviewStart(ctx context.Context, in store.StartInput) (store.StartPreview, error)
+	ActivateProvisionedSession(ctx context.Context, in store.ActivateProvisionedSessionInput) (*model.Session, error)
+	AdvanceProvisioningPhase(ctx context.Context, userID, sessionID, phase string) error
+	StopSession(ctx context.Context, in store.StopSessionInput) (*model.Session, error)
+	StopProvisionedSession(ctx context.Context, userID, sessionID, region, awsInstanceID string) (*model.Session, error)
+	RecordProvisionAttempt(ctx context.Context, a model.ProvisionAttempt) (int64, error)
+	SetProvisionAttemptCompensation(ctx context.Context, id int64, compensation string) error
+}
+
+type Service struct {
+	store       Store
+	provisioner relay.Provisioner
+	cfg         *config.Live
+	bootProbe   relay.BootProbe
+	provisions  *provisionLimiter
+
+	alerts            *alert.Hook
+	provisionFailures *alert.Window
+}
+
+type Option func(*Service)
+
+// WithBootProbe makes starts wait for new relays to pass p before
+// activating them, when AEGIS_RELAY_BOOT_PROBE is on.
+func WithBootProbe(p relay.BootProbe) Option {
+	return func(s *Service) {
+		s.bootProbe = p
+	}
+}
+
+// WithAlerts alerts operators through h once failed launches in a region
+// reach failures' threshold.
+func WithAlerts(h *alert.Hook, failures *alert.Window) Option {
+	return func(s *Service) {
+		s.alerts = h
+		s.provisionFailures = failures
+	}
+}
+
+func NewService(st Store, prov relay.Provisioner, cfg *config.Live, opts ...Option) *Service {
+	s := &Service{
+		store:       st,
+		provisioner: prov,
+		cfg:         cfg,
+		provisions:  newProvisionLimiter(cfg.Get().ProvisionConcurrency),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type StartCommand struct {
+	UserID         string
+	Region         string
+	RequestedBy    string
+	IdempotencyKey uuid.UUID
+	// RequestHash identifies the request the key was used with; see
+	// store.StartInput for LegacyRequestHash.
+	RequestHash       string
+	LegacyRequestHash string
+	// StaticIP asks for a relay with a stable public IP.
+	StaticIP bool
+	// RegionPreference is the region the caller asked for, "auto" or
+	// empty when it left the choice to the control plane.
+	RegionPreference string
+	// Tags are the session's normalized labels.
+	Tags []string
+	// ClientIP is the streamer's address, for relays locked to it. It and
+	// UserAgent are also recorded on a new session unless
+	// AEGIS_DISABLE_SESSION_CLIENT_INFO is set.
+	ClientIP  string
+	UserAgent string
+	// Canary starts a synthetic session for monitoring; InstanceType, when
+	// set, overrides the provider's primary instance type for its relay.
+	Canary       bool
+	InstanceType string
+	// NonBillable keeps the session out of the user's usage, for canaries
+	// and test sessions started by operators.
+	NonBillable bool
+	// StartedByAdmin is the admin starting the session on the user's
+	// behalf, capped by AEGIS_ADMIN_MAX_LIVE_SESSIONS. RefuseIfLive fails
+	// the start with store.ErrUserSessionsLive while the user has a session.
+	StartedByAdmin string
+	RefuseIfLive   bool
+}
+
+type StopCommand struct {
+	UserID    string
+	SessionID string
+	Reason    string
+	// ClientReason and ClientDetail are what the user's client gave for a
+	// user stop; see store.StopSessionInput.
+	ClientReason string
+	ClientDetail string
+}
+
+// Start returns the user's session for cmd.IdempotencyKey, creating it and
+// bringing its relay up when there is none. created reports whether this
+// call did so; an existing session is returned as it is, possibly still
+// provisioning. A failed start leaves the new session stopped.
+func (s *Service) Start(ctx context.Context, cmd StartCommand) (sess *model.Session, created bool, err error) {
+	sess, created, err = s.store.StartOrGetSession(ctx, s.startInput(cmd))
+	if err != nil || !created {
+		return sess, false, err
+	}
+	sess, err = s.bringUp(ctx, sess, cmd)
+	if err != nil {
+		return nil, false, err
+	}
+	return sess, true, nil
+}
+
+// Preview reports what Start would do with cmd before launching a relay,
+// returning the same store errors, without recording anything.
+func (s *Service) Preview(ctx context.Context, cmd StartCommand) (store.StartPreview, error) {
+	return s.store.PreviewStart(ctx, s.startInput(cmd))
+}
+
+func (s *Service) startInput(cmd StartCommand) store.StartInput {
+	in := store.StartInput{
+		UserID:            cmd.UserID,
+		Region:            cmd.Region,
+		RequestedBy:       cmd.RequestedBy,
+		IdempotencyKey:    cmd.IdempotencyKey,
+		RequestHash:       cmd.RequestHash,
+		LegacyRequestHash: cmd.LegacyRequestHash,
+		Canary:            cmd.Canary,
+		NonBillable:       cmd.NonBillable,
+		Tags:              cmd.Tags,
+		StartedByAdmin:    cmd.StartedByAdmin,
+		RefuseIfLive:      cmd.RefuseIfLive,
+	}
+	if cmd.StartedByAdmin != "" {
+		in.MaxAdminSessions = s.cfg.Get().AdminMaxLiveSessions
+	}
+	if !s.cfg.Get().DisableSessionClientInfo {
+		in.StartedFromIP, in.StartedUserAgent = cmd.ClientIP, clampUserAgent(cmd.UserAgent)
+	}
+	return in
+}
+
+// maxUserAgentLength bounds the user agent kept per session.
+const maxUserAgentLength = 512
+
+// clampUserAgent makes ua valid UTF-8 no longer than maxUserAgentLength.
+func clampUserAgent(ua string) string {
+	ua = strings.ToValidUTF8(ua, "")
+	if len(ua) <= maxUserAgentLength {
+		return ua
+	}
+	ua = ua[:maxUserAgentLength]
+	for !utf8.ValidString(ua) {
+		ua = ua[:len(ua)-1]
+	}
+	return ua
+}
+
+// Stop stops a session on behalf of cmd.Reason. The result is stopping
+// while its relay terminates.
+func (s *Service) Stop(ctx context.Context, cmd StopCommand) (*model.Session, error) {
+	return s.store.StopSession(ctx, store.StopSessionInput{
+		UserID:       cmd.UserID,
+		SessionID:    cmd.SessionID,
+		Reason:       cmd.Reason,
+		ClientReason: cmd.ClientReason,
+		ClientDetail: cmd.ClientDetail,
+	})
+}
+
+func (s *Service) bringUp(ctx context.Context, sess *model.Session, cmd StartCommand) (*model.Session, error) {
+	cfg := s.cfg.Get()
+	attempts := s.newAttemptLog(ctx, sess.ID)
+	compensateStop := func() {
+		if _, stopErr := s.store.StopSession(ctx, store.StopSessionInput{UserID: cmd.UserID, SessionID: sess.ID, Reason: model.StopReasonStartFailed}); stopErr != nil {
+			log.Printf("relay_start_compensation stop_session_failed session_id=%s user_id=%s err=%v", sess.ID, cmd.UserID, stopErr)
+			attempts.compensated(model.CompensationFailed)
+			return
+		}
+		attempts.compensated(model.CompensationSessionStopped)
+	}
+
+	// Tokens are generated before provisioning because the relay receives
+	// its token in boot-time user data.
+	pairToken, err := generatePairToken(cfg.PairTokenLength)
+	if err != nil {
+		compensateStop()
+		return nil, fmt.Errorf("%w: %w", ErrTokens, err)
+	}
+	relayWSToken, err := generateRelayWSToken()
+	if err != nil {
+		compensateStop()
+		return nil, fmt.Errorf("%w: %w", ErrTokens, err)
+	}
+
+	releaseSlot, err := s.provisions.acquire(ctx, sess.Region, cfg.ProvisionQueueTimeout)
+	if err != nil {
+		log.Printf("event=relay_provision_queue_rejected session_id=%s user_id=%s region=%s err=%q", sess.ID, cmd.UserID, sess.Region, err.Error())
+		compensateStop()
+		return nil, fmt.Errorf("%w: %w", ErrProvision, err)
+	}
+	s.advancePhase(ctx, sess.ID, cmd.UserID, model.ProvisioningLaunching)
+	provisionStart := time.Now()
+	prov, err := s.provisioner.Provision(ctx, relay.ProvisionRequest{
+		SessionID:       sess.ID,
+		UserID:          cmd.UserID,
+		Region:          sess.Region,
+		ControlPlaneURL: cfg.RelayControlPlaneURL,
+		RelayAuthToken:  relayWSToken,
+		SRTPortRange:    cfg.RelaySRTPortRange,
+		SRTPortCount:    cfg.RelaySRTPortCount,
+		StaticIP:        cmd.StaticIP,
+		ClientIP:        cmd.ClientIP,
+		InstanceType:    cmd.InstanceType,
+		OnAttempt:       attempts.report,
+	})
+	releaseSlot()
+	attempts.finish(sess.Region, provisionStart, prov, err)
+	durMS := float64(time.Since(provisionStart).Milliseconds())
+	labels := map[string]string{
+		"provider": s.providerFor(sess.Region),
+		"region":   sess.Region,
+	}
+	if err != nil {
+		log.Printf("metric=relay_provision_latency_ms session_id=%s user_id=%s region=%s value=%d status=error", sess.ID, cmd.UserID, sess.Region, time.Since(provisionStart).Milliseconds())
+		labels["status"] = "error"
+		metrics.Default().IncCounter("aegis_relay_provision_total", labels)
+		metrics.Default().ObserveHistogram("aegis_relay_provision_latency_ms", durMS, labels)
+		metrics.Default().IncCounter("aegis_relay_provision_failures_total", map[string]string{
+			"provider": labels["provider"],
+			"region":   sess.Region,
+			"cause":    relay.FailureCause(err),
+		})
+		s.alertProvisionFailure(sess.Region, labels["provider"], err)
+		compensateStop()
+		return nil, fmt.Errorf("%w: %w", ErrProvision, err)
+	}
+	log.Printf("metric=relay_provision_latency_ms session_id=%s user_id=%s region=%s value=%d status=ok", sess.ID, cmd.UserID, sess.Region, time.Since(provisionStart).Milliseconds())
+	labels["status"] = "ok"
+	metrics.Default().IncCounter("aegis_relay_provision_total", labels)
+	metrics.Default().ObserveHistogram("aegis_relay_provision_latency_ms", durMS, labels)
+	s.advancePhase(ctx, sess.ID, cmd.UserID, model.ProvisioningWaitingBoot)
+
+	// A capacity fallback may have launched the relay in another region.
+	if prov.Region == "" {
+		prov.Region = sess.Region
+	}
+	in := store.ActivateProvisionedSessionInput{
+		UserID:        cmd.UserID,
+		SessionID:     sess.ID,
+		Region:        prov.Region,
+		AWSInstanceID: prov.AWSInstanceID,
+		AMIID:         prov.AMIID,
+		InstanceType:  prov.InstanceType,
+		Lifecycle:     prov.Lifecycle,
+		PublicIP:      prov.PublicIP,
+		SRTPorts:      prov.SRTPorts,
+		WSURL:         prov.WSURL,
+		PairToken:     pairToken,
+		NewPairToken:  func() (string, error) { return generatePairToken(cfg.PairTokenLength) },
+		RelayWSToken:  relayWSToken,
+
+		EIPAllocationID:  prov.EIPAllocationID,
+		PublicIPv6:       prov.PublicIPv6,
+		SubnetID:         prov.SubnetID,
+		AvailabilityZone: prov.AvailabilityZone,
+		SecurityGroupID:  prov.SecurityGroupID,
+		AllowedClientIP:  relay.AllowedClientIP(prov, cmd.ClientIP),
+		Provider:         prov.Provider,
+	}
+	// A result the relay's columns cannot hold is a provider bug; the relay
+	// is unusable either way.
+	if err := model.ValidateActivation(in.Relay()); err != nil {
+		log.Printf("event=relay_provision_invalid session_id=%s user_id=%s instance_id=%s provider=%s err=%q", sess.ID, cmd.UserID, prov.AWSInstanceID, prov.Provider, err.Error())
+		s.compensateProvisioned(ctx, sess, cmd.UserID, prov, attempts)
+		return nil, fmt.Errorf("%w: %w", ErrActivate, err)
+	}
+	if err := s.waitRelayReady(ctx, sess, prov); err != nil {
+		log.Printf("event=relay_boot_probe_failed session_id=%s user_id=%s instance_id=%s err=%q", sess.ID, cmd.UserID, prov.AWSInstanceID, err.Error())
+		s.compensateProvisioned(ctx, sess, cmd.UserID, prov, attempts)
+		return nil, fmt.Errorf("%w: boot probe: %w", ErrProvision, err)
+	}
+	activated, err := s.store.ActivateProvisionedSession(ctx, in)
+	if err != nil {
+		s.compensateProvisioned(ctx, sess, cmd.UserID, prov, attempts)
+		return nil, fmt.Errorf("%w: %w", ErrActivate, err)
+	}
+	return activated, nil
+}
+
+// alertProvisionFailure counts a failed launch in region and alerts once
+// the region's failures reach the threshold.
+func (s *Service) alertProvisionFailure(region, provider string, err error) {
+	if s.alerts == nil {
+		return
+	}
+	n, reached := s.provisionFailures.Add(region)
+	if !reached {
+		return
+	}
+	s.alerts.Fire(alert.Alert{
+		Kind:    alert.KindProvisionFailures,
+		Key:     alert.KindProvisionFailures + "/" + region,
+		Summary: fmt.Sprintf("%d relay launches failed in %s within %s", n, region, s.provisionFailures.Period()),
+		Fields: map[string]string{
+			"region":     region,
+			"provider":   provider,
+			"cause":      relay.FailureCause(err),
+			"last_error": err.Error(),
+		},
+	})
+}
+
+// advancePhase records that the session's start reached phase. The phase only
+// informs clients, so a failure to record it does not fail the start;
+// activation sets ready either way.
+func (s *Service) advancePhase(ctx context.Context, sessionID, userID, phase string) {
+	if err := s.store.AdvanceProvisioningPhase(ctx, userID, sessionID, phase); err != nil {
+		log.Printf("event=provisioning_phase_failed session_id=%s user_id=%s phase=%s err=%v", sessionID, userID, phase, err)
+	}
+}
+
+// providerFor names the provider a launch in region goes to, for metric
+// labels.
+func (s *Service) providerFor(region string) string {
+	if router, ok := s.provisioner.(interface {
+		ProviderFor(region string) (string, error)
+	}); ok {
+		if name, err := router.ProviderFor(region); err == nil {
+			return name
+		}
+	}
+	return s.cfg.Get().RelayProvider
+}
+
+// waitRelayReady runs the boot probe, when enabled, within the configured
+// budget and records how long the relay took to become ready.
+func (s *Service) waitRelayReady(ctx context.Context, sess *model.Session, prov relay.ProvisionResult) error {
+	cfg := s.cfg.Get()
+	if s.bootProbe == nil || !cfg.RelayBootProbe {
+		return nil
+	}
+	start := time.Now()
+	probeCtx, cancel := context.WithTimeout(ctx, cfg.RelayBootProbeTimeout)
+	defer cancel()
+	err := s.bootProbe.WaitReady(probeCtx, prov)
+	status := "ok"
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		status = "timeout"
+	case err != nil:
+		status = "error"
+	}
+	provider := prov.Provider
+	if provider == "" {
+		provider = s.providerFor(prov.Region)
+	}
+	log.Printf("metric=relay_boot_ready_ms session_id=%s region=%s value=%d status=%s", sess.ID, prov.Region, time.Since(start).Milliseconds(), status)
+	metrics.Default().ObserveHistogram("aegis_relay_boot_ready_ms", float64(time.Since(start).Milliseconds()), map[string]string{
+		"provider": provider,
+		"region":   prov.Region,
+		"status":   status,
+	})
+	metrics.Default().ObserveHistogram("aegis_relay_provision_phase_ms", float64(time.Since(start).Milliseconds()), map[string]string{
+		"phase":         "boot_probe",
+		"provider":      provider,
+		"region":        prov.Region,
+		"instance_type": prov.InstanceType,
+		"status":        status,
+	})
+	return err
+}
+
+// compensateProvisioned hands a launched-but-unusable relay to the
+// termination queue instead of terminating it inline.
+func (s *Service) compensateProvisioned(ctx context.Context, sess *model.Session, userID string, prov relay.ProvisionResult, attempts *attemptLog) {
+	if _, stopErr := s.store.StopProvisionedSession(ctx, userID, sess.ID, prov.Region, prov.AWSInstanceID); stopErr != nil {
+		log.Printf("relay_start_compensation stop_session_failed session_id=%s user_id=%s instance_id=%s err=%v", sess.ID, userID, prov.AWSInstanceID, stopErr)
+		attempts.compensated(model.CompensationFailed)
+		return
+	}
+	attempts.compensated(model.CompensationTerminationQueued)
+}
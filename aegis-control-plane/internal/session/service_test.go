@@ -0,0 +1,404 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/smithy-go"
+	"github.com/google/uuid"
+
+	"github.com/telemyapp/aegis-control-plane/internal/alert"
+	"github.com/telemyapp/aegis-control-plane/internal/config"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+type fakeStore struct {
+	created     bool
+	activateErr error
+
+	starts      []store.StartInput
+	previews    []store.StartInput
+	activated   []store.ActivateProvisionedSessionInput
+	stopped     []string
+	provStopped []string
+	// phases are the provisioning phases recorded, in order.
+	phases   []string
+	attempts []model.ProvisionAttempt
+}
+
+func (f *fakeStore) StartOrGetSession(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+	f.starts = append(f.starts, in)
+	status := model.SessionProvisioning
+	if !f.created {
+		status = model.SessionActive
+	}
+	return &model.Session{ID: "ses_1", UserID: in.UserID, Status: status, Region: in.Region}, f.created, nil
+}
+
+func (f *fakeStore) PreviewStart(_ context.Context, in store.StartInput) (store.StartPreview, error) {
+	f.previews = append(f.previews, in)
+	return store.StartPreview{}, nil
+}
+
+func (f *fakeStore) ActivateProvisionedSession(_ context.Context, in store.ActivateProvisionedSessionInput) (*model.Session, error) {
+	f.activated = append(f.activated, in)
+	if f.activateErr != nil {
+		return nil, f.activateErr
+	}
+	f.phases = append(f.phases, model.ProvisioningReady)
+	return &model.Session{ID: in.SessionID, UserID: in.UserID, Status: model.SessionActive, Region: in.Region, PairToken: in.PairToken}, nil
+}
+
+func (f *fakeStore) AdvanceProvisioningPhase(_ context.Context, _, _, phase string) error {
+	f.phases = append(f.phases, phase)
+	return nil
+}
+
+func (f *fakeStore) StopSession(_ context.Context, in store.StopSessionInput) (*model.Session, error) {
+	f.stopped = append(f.stopped, in.SessionID+":"+in.Reason)
+	return &model.Session{ID: in.SessionID, Status: model.SessionStopped}, nil
+}
+
+func (f *fakeStore) StopProvisionedSession(_ context.Context, _, sessionID, region, awsInstanceID string) (*model.Session, error) {
+	f.provStopped = append(f.provStopped, sessionID+":"+region+":"+awsInstanceID)
+	return &model.Session{ID: sessionID, Status: model.SessionStopping}, nil
+}
+
+func (f *fakeStore) RecordProvisionAttempt(_ context.Context, a model.ProvisionAttempt) (int64, error) {
+	f.attempts = append(f.attempts, a)
+	return int64(len(f.attempts)), nil
+}
+
+func (f *fakeStore) SetProvisionAttemptCompensation(_ context.Context, id int64, compensation string) error {
+	f.attempts[id-1].Compensation = compensation
+	return nil
+}
+
+type fakeProvisioner struct {
+	relay.Provisioner
+	err   error
+	calls []relay.ProvisionRequest
+	// fallbacks are failed attempts reported before the outcome.
+	fallbacks []relay.ProvisionAttempt
+	// publicIP, when set, replaces the launched relay's address.
+	publicIP string
+}
+
+func (f *fakeProvisioner) Provision(_ context.Context, req relay.ProvisionRequest) (relay.ProvisionResult, error) {
+	f.calls = append(f.calls, req)
+	for _, a := range f.fallbacks {
+		req.OnAttempt(a)
+	}
+	if f.err != nil {
+		return relay.ProvisionResult{}, f.err
+	}
+	res := relay.ProvisionResult{AWSInstanceID: "i-1", PublicIP: "203.0.113.10", SRTPort: 9000, SecurityGroupID: "sg-1"}
+	if f.publicIP != "" {
+		res.PublicIP = f.publicIP
+	}
+	return res, nil
+}
+
+type probeFunc func(context.Context, relay.ProvisionResult) error
+
+func (f probeFunc) WaitReady(ctx context.Context, res relay.ProvisionResult) error {
+	return f(ctx, res)
+}
+
+func testService(st Store, prov relay.Provisioner, opts ...Option) *Service {
+	cfg := config.Config{
+		PairTokenLength:       8,
+		ProvisionConcurrency:  1,
+		ProvisionQueueTimeout: 10 * time.Millisecond,
+		RelayBootProbe:        true,
+		RelayBootProbeTimeout: time.Second,
+	}
+	return NewService(st, prov, config.NewLive(cfg), opts...)
+}
+
+func startCommand() StartCommand {
+	return StartCommand{UserID: "usr_1", Region: "us-east-1", IdempotencyKey: uuid.New(), ClientIP: "198.51.100.23"}
+}
+
+func TestStart_CreatesAndActivates(t *testing.T) {
+	st := &fakeStore{created: true}
+	prov := &fakeProvisioner{}
+	sess, created, err := testService(st, prov).Start(context.Background(), startCommand())
+	if err != nil || !created {
+		t.Fatalf("expected a created session, got created=%v err=%v", created, err)
+	}
+	if sess.Status != model.SessionActive || len(sess.PairToken) != 8 {
+		t.Fatalf("unexpected session: %+v", sess)
+	}
+	if len(prov.calls) != 1 || prov.calls[0].RelayAuthToken != st.activated[0].RelayWSToken {
+		t.Fatalf("expected the relay to boot with the session's relay token")
+	}
+	if got := st.activated[0].AllowedClientIP; got != "198.51.100.23" {
+		t.Fatalf("expected the client IP recorded for a locked relay, got %q", got)
+	}
+	if len(st.stopped)+len(st.provStopped) != 0 {
+		t.Fatalf("expected no compensation, got %v %v", st.stopped, st.provStopped)
+	}
+}
+
+func TestStart_AdvancesProvisioningPhases(t *testing.T) {
+	ok := func(context.Context, relay.ProvisionResult) error { return nil }
+	failed := func(context.Context, relay.ProvisionResult) error { return errors.New("connection refused") }
+	for _, tc := range []struct {
+		name  string
+		prov  *fakeProvisioner
+		probe probeFunc
+		want  []string
+	}{
+		{"success", &fakeProvisioner{}, ok, []string{model.ProvisioningLaunching, model.ProvisioningWaitingBoot, model.ProvisioningReady}},
+		{"provision failure", &fakeProvisioner{err: relay.ErrRegionUnavailable}, ok, []string{model.ProvisioningLaunching}},
+		{"boot probe failure", &fakeProvisioner{}, failed, []string{model.ProvisioningLaunching, model.ProvisioningWaitingBoot}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			st := &fakeStore{created: true}
+			testService(st, tc.prov, WithBootProbe(tc.probe)).Start(context.Background(), startCommand())
+			if !reflect.DeepEqual(st.phases, tc.want) {
+				t.Fatalf("expected phases %v, got %v", tc.want, st.phases)
+			}
+		})
+	}
+}
+
+func TestStart_RecordsClientUnlessDisabled(t *testing.T) {
+	st := &fakeStore{created: true}
+	cmd := startCommand()
+	cmd.UserAgent = "OBS/30.1 " + strings.Repeat("é", maxUserAgentLength)
+	if _, _, err := testService(st, &fakeProvisioner{}).Start(context.Background(), cmd); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	in := st.starts[0]
+	if in.StartedFromIP != "198.51.100.23" || !strings.HasPrefix(in.StartedUserAgent, "OBS/30.1 ") ||
+		len(in.StartedUserAgent) > maxUserAgentLength || !utf8.ValidString(in.StartedUserAgent) {
+		t.Fatalf("expected the client recorded with a clamped user agent, got %q %q", in.StartedFromIP, in.StartedUserAgent)
+	}
+
+	st = &fakeStore{created: true}
+	svc := testService(st, &fakeProvisioner{})
+	cfg := svc.cfg.Get()
+	cfg.DisableSessionClientInfo = true
+	svc.cfg.Reload(cfg)
+	if _, _, err := svc.Start(context.Background(), cmd); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if in := st.starts[0]; in.StartedFromIP != "" || in.StartedUserAgent != "" {
+		t.Fatalf("expected nothing recorded with collection disabled, got %+v", in)
+	}
+	if st.activated[0].AllowedClientIP != "198.51.100.23" {
+		t.Fatal("expected the relay still locked to the client IP")
+	}
+}
+
+func TestStart_CanaryFlagsSessionAndOverridesInstanceType(t *testing.T) {
+	st := &fakeStore{created: true}
+	prov := &fakeProvisioner{}
+	cmd := startCommand()
+	cmd.Canary, cmd.InstanceType = true, "t4g.nano"
+	if _, _, err := testService(st, prov).Start(context.Background(), cmd); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if !st.starts[0].Canary || prov.calls[0].InstanceType != "t4g.nano" {
+		t.Fatalf("expected a canary session on t4g.nano, got %+v and %+v", st.starts[0], prov.calls[0])
+	}
+}
+
+func TestStart_ExistingSessionIsNotProvisioned(t *testing.T) {
+	st := &fakeStore{}
+	prov := &fakeProvisioner{}
+	sess, created, err := testService(st, prov).Start(context.Background(), startCommand())
+	if err != nil || created || sess.ID != "ses_1" {
+		t.Fatalf("expected the existing session, got %+v created=%v err=%v", sess, created, err)
+	}
+	if len(prov.calls) != 0 {
+		t.Fatalf("expected no provisioning, got %d calls", len(prov.calls))
+	}
+}
+
+func TestPreview_ChecksTheStartInputWithoutProvisioning(t *testing.T) {
+	st := &fakeStore{created: true}
+	prov := &fakeProvisioner{}
+	svc := testService(st, prov)
+	cmd := startCommand()
+	if _, err := svc.Preview(context.Background(), cmd); err != nil {
+		t.Fatalf("Preview: %v", err)
+	}
+	if _, _, err := svc.Start(context.Background(), cmd); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if len(st.previews) != 1 || !reflect.DeepEqual(st.previews[0], st.starts[0]) {
+		t.Fatalf("expected the preview to check what the start records, got %+v and %+v", st.previews, st.starts)
+	}
+	if len(prov.calls) != 1 {
+		t.Fatalf("expected only the start to provision, got %d calls", len(prov.calls))
+	}
+}
+
+func TestStart_ProvisionFailureStopsSession(t *testing.T) {
+	st := &fakeStore{created: true}
+	_, _, err := testService(st, &fakeProvisioner{err: relay.ErrRegionUnavailable}).Start(context.Background(), startCommand())
+	if !errors.Is(err, ErrProvision) || !errors.Is(err, relay.ErrRegionUnavailable) {
+		t.Fatalf("expected a provisioning error wrapping the cause, got %v", err)
+	}
+	if len(st.stopped) != 1 || st.stopped[0] != "ses_1:"+model.StopReasonStartFailed {
+		t.Fatalf("expected the session stopped as start_failed, got %v", st.stopped)
+	}
+	if len(st.provStopped) != 0 {
+		t.Fatalf("expected no relay to terminate, got %v", st.provStopped)
+	}
+	if len(st.attempts) != 1 || st.attempts[0].Outcome != model.ProvisionAttemptFailed || st.attempts[0].Compensation != model.CompensationSessionStopped {
+		t.Fatalf("expected one failed attempt with the session stopped, got %+v", st.attempts)
+	}
+}
+
+type alertRecorder struct {
+	mu     sync.Mutex
+	alerts []alert.Alert
+}
+
+func (r *alertRecorder) Name() string { return "recorder" }
+
+func (r *alertRecorder) Notify(_ context.Context, a alert.Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, a)
+	return nil
+}
+
+func TestStart_RepeatedProvisionFailuresAlert(t *testing.T) {
+	rec := &alertRecorder{}
+	hook := alert.New(time.Hour, rec)
+	svc := testService(&fakeStore{created: true}, &fakeProvisioner{err: relay.ErrRegionUnavailable}, WithAlerts(hook, alert.NewWindow(2, time.Minute)))
+	for range 3 {
+		if _, _, err := svc.Start(context.Background(), startCommand()); !errors.Is(err, ErrProvision) {
+			t.Fatalf("expected ErrProvision, got %v", err)
+		}
+	}
+	hook.Wait()
+	// The second failure reaches the threshold; the third repeats its alert.
+	if len(rec.alerts) != 1 {
+		t.Fatalf("expected one alert, got %+v", rec.alerts)
+	}
+	if a := rec.alerts[0]; a.Kind != alert.KindProvisionFailures || a.Key != "provision_failures/us-east-1" || a.Fields["region"] != "us-east-1" {
+		t.Fatalf("unexpected alert %+v", a)
+	}
+}
+
+func TestStart_RecordsReportedFallbackAttempts(t *testing.T) {
+	st := &fakeStore{created: true, activateErr: errors.New("db down")}
+	prov := &fakeProvisioner{fallbacks: []relay.ProvisionAttempt{
+		{Region: "us-east-1", InstanceType: "t4g.small", Err: &smithy.GenericAPIError{Code: "InsufficientInstanceCapacity"}},
+		{Region: "us-west-2", InstanceType: "t4g.small", InstanceID: "i-1"},
+	}}
+	if _, _, err := testService(st, prov).Start(context.Background(), startCommand()); !errors.Is(err, ErrActivate) {
+		t.Fatalf("expected ErrActivate, got %v", err)
+	}
+	if len(st.attempts) != 2 {
+		t.Fatalf("expected the reported attempts only, got %+v", st.attempts)
+	}
+	first, last := st.attempts[0], st.attempts[1]
+	if first.Outcome != model.ProvisionAttemptFailed || first.AWSErrorCode != "InsufficientInstanceCapacity" || first.Compensation != "" {
+		t.Fatalf("unexpected fallback attempt %+v", first)
+	}
+	if last.Outcome != model.ProvisionAttemptSucceeded || last.InstanceID != "i-1" || last.Compensation != model.CompensationTerminationQueued {
+		t.Fatalf("expected the launch recorded with its termination queued, got %+v", last)
+	}
+}
+
+func TestStart_ProvisionQueueFullStopsSession(t *testing.T) {
+	st := &fakeStore{created: true}
+	prov := &fakeProvisioner{}
+	svc := testService(st, prov)
+	release, err := svc.provisions.acquire(context.Background(), "us-east-1", time.Second)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer release()
+
+	_, _, err = svc.Start(context.Background(), startCommand())
+	if !errors.Is(err, ErrProvisionQueueFull) {
+		t.Fatalf("expected ErrProvisionQueueFull, got %v", err)
+	}
+	if len(prov.calls) != 0 || len(st.stopped) != 1 {
+		t.Fatalf("expected no launch and the session stopped, got calls=%d stopped=%v", len(prov.calls), st.stopped)
+	}
+}
+
+func TestStart_BootProbeFailureQueuesTermination(t *testing.T) {
+	st := &fakeStore{created: true}
+	probe := probeFunc(func(context.Context, relay.ProvisionResult) error { return errors.New("connection refused") })
+	_, _, err := testService(st, &fakeProvisioner{}, WithBootProbe(probe)).Start(context.Background(), startCommand())
+	if !errors.Is(err, ErrProvision) {
+		t.Fatalf("expected ErrProvision, got %v", err)
+	}
+	if len(st.activated) != 0 {
+		t.Fatal("expected the session not to be activated")
+	}
+	if len(st.provStopped) != 1 || st.provStopped[0] != "ses_1:us-east-1:i-1" {
+		t.Fatalf("expected the launched relay queued for termination, got %v", st.provStopped)
+	}
+	if len(st.stopped) != 0 {
+		t.Fatalf("expected no plain stop, got %v", st.stopped)
+	}
+}
+
+func TestStart_ActivationFailureQueuesTermination(t *testing.T) {
+	st := &fakeStore{created: true, activateErr: errors.New("db down")}
+	_, _, err := testService(st, &fakeProvisioner{}).Start(context.Background(), startCommand())
+	if !errors.Is(err, ErrActivate) {
+		t.Fatalf("expected ErrActivate, got %v", err)
+	}
+	if len(st.provStopped) != 1 || st.provStopped[0] != "ses_1:us-east-1:i-1" {
+		t.Fatalf("expected the launched relay queued for termination, got %v", st.provStopped)
+	}
+}
+
+func TestStart_InvalidProvisionResultQueuesTermination(t *testing.T) {
+	st := &fakeStore{created: true}
+	probed := false
+	probe := probeFunc(func(context.Context, relay.ProvisionResult) error { probed = true; return nil })
+	_, _, err := testService(st, &fakeProvisioner{publicIP: "203.0.113.300"}, WithBootProbe(probe)).Start(context.Background(), startCommand())
+	var fieldErr *model.FieldError
+	if !errors.Is(err, ErrActivate) || !errors.As(err, &fieldErr) || fieldErr.Field != "public_ip" {
+		t.Fatalf("expected ErrActivate for the public IP, got %v", err)
+	}
+	if probed || len(st.activated) != 0 {
+		t.Fatal("expected neither a boot probe nor an activation")
+	}
+	if len(st.provStopped) != 1 || st.provStopped[0] != "ses_1:us-east-1:i-1" {
+		t.Fatalf("expected the launched relay queued for termination, got %v", st.provStopped)
+	}
+}
+
+func TestStop_PassesReason(t *testing.T) {
+	st := &fakeStore{}
+	sess, err := testService(st, &fakeProvisioner{}).Stop(context.Background(), StopCommand{UserID: "usr_1", SessionID: "ses_1", Reason: model.StopReasonAdmin})
+	if err != nil || sess.Status != model.SessionStopped {
+		t.Fatalf("unexpected stop result: %+v err=%v", sess, err)
+	}
+	if len(st.stopped) != 1 || st.stopped[0] != "ses_1:"+model.StopReasonAdmin {
+		t.Fatalf("expected an admin stop, got %v", st.stopped)
+	}
+}
+
+func TestProvisionLimiter_NilAdmitsEverything(t *testing.T) {
+	l := newProvisionLimiter(0)
+	for range 3 {
+		release, err := l.acquire(context.Background(), "us-east-1", time.Millisecond)
+		if err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		defer release()
+	}
+}
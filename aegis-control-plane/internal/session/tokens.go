@@ -0,0 +1,40 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+const pairTokenAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// generatePairToken draws each character uniformly from pairTokenAlphabet.
+// Bytes at or above the largest multiple of the alphabet size are rejected,
+// since mapping them with a modulo would favour the first characters.
+func generatePairToken(length int) (string, error) {
+	if length <= 0 {
+		return "", errors.New("invalid token length")
+	}
+	const limit = 256 - 256%len(pairTokenAlphabet)
+	out := make([]byte, 0, length)
+	buf := make([]byte, length)
+	for len(out) < length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(out) < length {
+				out = append(out, pairTokenAlphabet[int(b)%len(pairTokenAlphabet)])
+			}
+		}
+	}
+	return string(out), nil
+}
+
+func generateRelayWSToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
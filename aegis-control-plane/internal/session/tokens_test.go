@@ -0,0 +1,39 @@
+package session
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeneratePairToken_UniformOverAlphabet(t *testing.T) {
+	const tokens = 20000
+	counts := make(map[rune]int, len(pairTokenAlphabet))
+	for range tokens {
+		tok, err := generatePairToken(8)
+		if err != nil {
+			t.Fatalf("generatePairToken: %v", err)
+		}
+		if len(tok) != 8 {
+			t.Fatalf("expected 8 characters, got %q", tok)
+		}
+		for _, c := range tok {
+			if !strings.ContainsRune(pairTokenAlphabet, c) {
+				t.Fatalf("character %q outside the alphabet in %q", c, tok)
+			}
+			counts[c]++
+		}
+	}
+
+	// Chi-square over 36 characters has 35 degrees of freedom; 80 is far
+	// beyond its 99.99th percentile (about 71), while the old modulo mapping
+	// scores in the hundreds at this sample size.
+	expected := float64(tokens*8) / float64(len(pairTokenAlphabet))
+	var chi2 float64
+	for _, c := range pairTokenAlphabet {
+		d := float64(counts[c]) - expected
+		chi2 += d * d / expected
+	}
+	if chi2 > 80 {
+		t.Fatalf("character distribution is not uniform: chi2=%.1f counts=%v", chi2, counts)
+	}
+}
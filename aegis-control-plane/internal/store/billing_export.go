@@ -0,0 +1,152 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+// EnqueueBillingExports queues an export for each cycle of a Stripe-billed
+// user that ended between lookback and settle ago. settle gives the final
+// usage rollups time to land. Cycles already queued are left alone, so each
+// cycle is exported once.
+func (s *Store) EnqueueBillingExports(ctx context.Context, settle, lookback time.Duration) (_ int, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Rollup)
+	defer done(&err)
+	const q = `
+insert into billing_exports
+  (user_id, cycle_start_at, cycle_end_at, stripe_subscription_item_id, overage_seconds, next_attempt_at, created_at, updated_at)
+select ur.user_id, ur.cycle_start_at, ur.cycle_end_at, u.stripe_subscription_item_id, sum(ur.overage_seconds), now(), now(), now()
+from usage_records ur
+join users u on u.id = ur.user_id
+where u.stripe_subscription_item_id is not null
+  and ur.cycle_end_at <= now() - make_interval(secs => $1)
+  and ur.cycle_end_at > now() - make_interval(secs => $2)
+group by ur.user_id, ur.cycle_start_at, ur.cycle_end_at, u.stripe_subscription_item_id
+on conflict (user_id, cycle_start_at) do nothing`
+	tag, err := s.db.Exec(ctx, q, settle.Seconds(), lookback.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+const billingExportColumns = `user_id, cycle_start_at, cycle_end_at, stripe_subscription_item_id, overage_seconds, status, attempts,
+  coalesce(last_error, ''), coalesce(stripe_usage_record_id, ''), reported_at, next_attempt_at, updated_at`
+
+func scanBillingExports(rows pgx.Rows) ([]model.BillingExport, error) {
+	defer rows.Close()
+	out := make([]model.BillingExport, 0)
+	for rows.Next() {
+		var e model.BillingExport
+		if err := rows.Scan(&e.UserID, &e.CycleStart, &e.CycleEnd, &e.SubscriptionItemID, &e.OverageSeconds, &e.Status, &e.Attempts,
+			&e.LastError, &e.StripeUsageRecordID, &e.ReportedAt, &e.NextAttemptAt, &e.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// ClaimBillingExports leases up to limit due exports, like
+// ClaimWebhookDeliveries.
+func (s *Store) ClaimBillingExports(ctx context.Context, limit int, lease time.Duration) (_ []model.BillingExport, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	q := `
+with due as (
+  select user_id, cycle_start_at
+  from billing_exports
+  where status = 'pending' and next_attempt_at <= now()
+  order by next_attempt_at asc
+  limit $1
+  for update skip locked
+), claimed as (
+  update billing_exports b
+  set next_attempt_at = now() + make_interval(secs => $2), updated_at = now()
+  from due
+  where b.user_id = due.user_id and b.cycle_start_at = due.cycle_start_at
+  returning b.*
+)
+select ` + billingExportColumns + `
+from claimed`
+	rows, err := s.db.Query(ctx, q, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	return scanBillingExports(rows)
+}
+
+func (s *Store) CompleteBillingExport(ctx context.Context, userID string, cycleStart time.Time, usageRecordID string) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	_, err = s.db.Exec(ctx, `
+update billing_exports
+set status = 'reported', attempts = attempts + 1, stripe_usage_record_id = $3, last_error = null, reported_at = now(), updated_at = now()
+where user_id = $1 and cycle_start_at = $2`, userID, cycleStart, usageRecordID)
+	return err
+}
+
+// RetryBillingExport records a failed report and reschedules the export.
+func (s *Store) RetryBillingExport(ctx context.Context, userID string, cycleStart time.Time, lastErr string, nextAttemptAt time.Time) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	_, err = s.db.Exec(ctx, `
+update billing_exports
+set attempts = attempts + 1, last_error = $3, next_attempt_at = $4, updated_at = now()
+where user_id = $1 and cycle_start_at = $2`, userID, cycleStart, lastErr, nextAttemptAt)
+	return err
+}
+
+// FailBillingExport records a failed final report and parks the export.
+func (s *Store) FailBillingExport(ctx context.Context, userID string, cycleStart time.Time, lastErr string) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	_, err = s.db.Exec(ctx, `
+update billing_exports
+set status = 'failed', attempts = attempts + 1, last_error = $3, updated_at = now()
+where user_id = $1 and cycle_start_at = $2`, userID, cycleStart, lastErr)
+	return err
+}
+
+func (s *Store) CountFailedBillingExports(ctx context.Context) (_ int, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	var n int
+	err = s.db.QueryRow(ctx, `select count(*) from billing_exports where status = 'failed'`).Scan(&n)
+	return n, err
+}
+
+// BillingExportFilter narrows ListBillingExports; zero fields match all.
+type BillingExportFilter struct {
+	UserID     string
+	Status     string
+	CycleStart time.Time
+	Limit      int
+}
+
+// ListBillingExports returns exports newest cycle first.
+func (s *Store) ListBillingExports(ctx context.Context, f BillingExportFilter) (_ []model.BillingExport, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	var cycleStart *time.Time
+	if !f.CycleStart.IsZero() {
+		cycleStart = &f.CycleStart
+	}
+	q := `
+select ` + billingExportColumns + `
+from billing_exports
+where ($1 = '' or user_id = $1)
+  and ($2 = '' or status = $2)
+  and ($3::timestamptz is null or cycle_start_at = $3)
+order by cycle_start_at desc, user_id asc
+limit $4`
+	rows, err := s.db.Query(ctx, q, f.UserID, f.Status, cycleStart, f.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanBillingExports(rows)
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+)
+
+func TestEnqueueBillingExports_QueuesEachCycleOnce(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("on conflict (user_id, cycle_start_at) do nothing")).
+		WithArgs(float64(900), float64(7*24*3600)).
+		WillReturnResult(pgxmock.NewResult("INSERT", 2))
+
+	n, err := New(mock).EnqueueBillingExports(context.Background(), 15*time.Minute, 7*24*time.Hour)
+	if err != nil {
+		t.Fatalf("EnqueueBillingExports: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 queued, got %d", n)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestClaimBillingExports_ReturnsSubscriptionItem(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	cycleStart := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta("for update skip locked")).
+		WithArgs(20, float64(300)).
+		WillReturnRows(pgxmock.NewRows([]string{"user_id", "cycle_start_at", "cycle_end_at", "stripe_subscription_item_id", "overage_seconds", "status",
+			"attempts", "last_error", "stripe_usage_record_id", "reported_at", "next_attempt_at", "updated_at"}).
+			AddRow("usr_1", cycleStart, cycleStart.AddDate(0, 1, 0), "si_1", 600, "pending", 1, "timeout", "", (*time.Time)(nil), time.Now(), time.Now()))
+
+	got, err := New(mock).ClaimBillingExports(context.Background(), 20, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimBillingExports: %v", err)
+	}
+	if len(got) != 1 || got[0].SubscriptionItemID != "si_1" || got[0].OverageSeconds != 600 || got[0].Attempts != 1 || got[0].ReportedAt != nil {
+		t.Fatalf("unexpected exports %+v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
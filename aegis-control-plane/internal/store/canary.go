@@ -0,0 +1,32 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+// RecordCanaryFailure queues a canary_failed delivery to the global webhooks
+// subscribed to it.
+func (s *Store) RecordCanaryFailure(ctx context.Context, f model.CanaryFailure) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+	if err := enqueueWebhookEvent(ctx, tx, model.CanaryUserID, model.WebhookCanaryFailed, map[string]any{
+		"region":     f.Region,
+		"session_id": f.SessionID,
+		"outcome":    f.Outcome,
+		"error":      f.Error,
+		"failed_at":  f.FailedAt.UTC().Format(time.RFC3339),
+	}); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+func TestStartOrGetSession_CanaryIsFlaggedWithoutWebhookEvent(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	key := uuid.New()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("select endpoint, request_hash, response_json")).
+		WithArgs(model.CanaryUserID, key, startEndpoint).
+		WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta("select s.id, s.user_id")).
+		WithArgs(model.CanaryUserID).
+		WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta("insert into sessions")).
+		WithArgs(pgxmock.AnyArg(), model.CanaryUserID, "us-east-1", key, "canary", pgxmock.AnyArg(), true, false, "", "", "", pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"plan_tier"}).AddRow("canary"))
+	mock.ExpectExec(regexp.QuoteMeta("insert into idempotency_records")).
+		WithArgs(anyArgs(6)...).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectCommit()
+
+	_, created, err := New(mock).StartOrGetSession(context.Background(), StartInput{
+		UserID: model.CanaryUserID, Region: "us-east-1", RequestedBy: "canary", IdempotencyKey: key, Canary: true, NonBillable: true,
+	})
+	if err != nil || !created {
+		t.Fatalf("expected a new canary session, got created=%v err=%v", created, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestRecordCanaryFailure_QueuesWebhookEvent(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectBegin()
+	expectWebhookEvent(mock, model.CanaryUserID, model.WebhookCanaryFailed)
+	mock.ExpectCommit()
+
+	err = New(mock).RecordCanaryFailure(context.Background(), model.CanaryFailure{
+		Region: "us-east-1", SessionID: "ses_1", Outcome: "verify_failed", Error: "relay not ready", FailedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("RecordCanaryFailure: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
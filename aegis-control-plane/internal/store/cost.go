@@ -0,0 +1,158 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/obs"
+)
+
+// relayPriceLateral picks the price of relay ri: its region's price for the
+// instance type, else the type's region-wide price, else the default.
+// fallback is true when the default was used.
+const relayPriceLateral = `
+  select rp.hourly_usd, rp.instance_type = '' as fallback
+  from relay_prices rp
+  where (rp.instance_type = ri.instance_type and rp.region in (ri.region, ''))
+     or (rp.instance_type = '' and rp.region = '')
+  order by rp.instance_type desc, rp.region desc
+  limit 1`
+
+// ReplaceRelayPrices makes prices the only relay prices. With none, session
+// costs are no longer estimated; estimates already made are kept.
+func (s *Store) ReplaceRelayPrices(ctx context.Context, prices []model.RelayPrice) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	regions := make([]string, len(prices))
+	instanceTypes := make([]string, len(prices))
+	hourly := make([]float64, len(prices))
+	for i, p := range prices {
+		regions[i], instanceTypes[i], hourly[i] = p.Region, p.InstanceType, p.HourlyUSD
+	}
+	// As with the manifest, the delete sees the rows from before the insert.
+	const q = `
+with input as (
+  select *
+  from unnest($1::text[], $2::text[], $3::float8[]) as t(region, instance_type, hourly_usd)
+), upserted as (
+  insert into relay_prices (region, instance_type, hourly_usd, updated_at)
+  select region, instance_type, hourly_usd, now()
+  from input
+  on conflict (region, instance_type)
+  do update set hourly_usd = excluded.hourly_usd, updated_at = now()
+  where relay_prices.hourly_usd is distinct from excluded.hourly_usd
+)
+delete from relay_prices
+where (region, instance_type) not in (select region, instance_type from input)`
+	_, err = s.db.Exec(ctx, q, regions, instanceTypes, hourly)
+	return err
+}
+
+// priceStoppedSession estimates the cost of a session stopped in tx from
+// its relay's price and how long it ran, and returns the relay's region and
+// instance type when the default price was used. Sessions without a relay
+// or a price are left unestimated.
+func priceStoppedSession(ctx context.Context, tx pgx.Tx, sessionID string) (region, instanceType string, fallback bool, err error) {
+	const q = `
+update sessions s
+set hourly_price_usd = p.hourly_usd,
+    estimated_cost_usd = round(p.hourly_usd * greatest(extract(epoch from (s.stopped_at - s.started_at)), 0) / 3600, 6)
+from relay_instances ri
+cross join lateral (` + relayPriceLateral + `
+) p
+where s.id = $1 and ri.id = s.relay_instance_id
+returning ri.region, ri.instance_type, p.fallback`
+	err = tx.QueryRow(ctx, q, sessionID).Scan(&region, &instanceType, &fallback)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", false, nil
+	}
+	return region, instanceType, fallback, err
+}
+
+// RollupLiveSessionCosts estimates the cost so far of active and grace
+// sessions, as priceStoppedSession does at stop, and returns how many it
+// estimated. It leaves updated_at alone: costs do not feed usage records.
+func (s *Store) RollupLiveSessionCosts(ctx context.Context) (_ int, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Rollup)
+	defer done(&err)
+	const q = `
+update sessions s
+set hourly_price_usd = p.hourly_usd,
+    estimated_cost_usd = round(p.hourly_usd * greatest(extract(epoch from (now() - s.started_at)), 0) / 3600, 6)
+from relay_instances ri
+cross join lateral (` + relayPriceLateral + `
+) p
+where s.status in ('active', 'grace')
+  and ri.id = s.relay_instance_id
+returning ri.region, ri.instance_type, p.fallback`
+	rows, err := s.db.Query(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	type relayType struct{ region, instanceType string }
+	fallbacks := make(map[relayType]int)
+	n := 0
+	for rows.Next() {
+		var rt relayType
+		var fallback bool
+		if err := rows.Scan(&rt.region, &rt.instanceType, &fallback); err != nil {
+			return 0, err
+		}
+		n++
+		if fallback {
+			fallbacks[rt]++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for rt, count := range fallbacks {
+		obs.SessionsPricedAtDefault(rt.region, rt.instanceType, count)
+	}
+	return n, nil
+}
+
+// CostOverview sums the estimated cost of live sessions and lists the
+// topUsers users whose sessions cost the most in their current cycle.
+func (s *Store) CostOverview(ctx context.Context, topUsers int) (_ model.CostOverview, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	var out model.CostOverview
+	const liveQ = `
+select count(*), coalesce(sum(hourly_price_usd), 0)::float8, coalesce(sum(estimated_cost_usd), 0)::float8
+from sessions
+where status in ('active', 'grace') and hourly_price_usd is not null`
+	if err := s.db.QueryRow(ctx, liveQ).Scan(&out.LiveSessions, &out.BurnUSDPerHour, &out.LiveEstimatedCostUSD); err != nil {
+		return model.CostOverview{}, err
+	}
+	const usersQ = `
+select u.id, u.cycle_start_at, u.cycle_end_at,
+       coalesce(sum(greatest(s.duration_seconds, s.reconciled_seconds)) filter (where s.billable), 0)::integer,
+       sum(s.estimated_cost_usd)::float8 as cost
+from users u
+join sessions s on s.user_id = u.id
+where s.started_at >= u.cycle_start_at
+  and s.started_at <= u.cycle_end_at
+  and s.estimated_cost_usd is not null
+group by u.id, u.cycle_start_at, u.cycle_end_at
+order by cost desc, u.id asc
+limit $1`
+	rows, err := s.db.Query(ctx, usersQ, topUsers)
+	if err != nil {
+		return model.CostOverview{}, err
+	}
+	defer rows.Close()
+	out.TopUsers = make([]model.UserCycleCost, 0)
+	for rows.Next() {
+		var c model.UserCycleCost
+		if err := rows.Scan(&c.UserID, &c.CycleStart, &c.CycleEnd, &c.BillableSeconds, &c.EstimatedCostUSD); err != nil {
+			return model.CostOverview{}, err
+		}
+		out.TopUsers = append(out.TopUsers, c)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+func TestReplaceRelayPrices_OneStatement(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("delete from relay_prices")).
+		WithArgs([]string{"", "eu-west-1"}, []string{"", "t4g.small"}, []float64{0.05, 0.0184}).
+		WillReturnResult(pgxmock.NewResult("DELETE", 0))
+
+	err = New(mock).ReplaceRelayPrices(context.Background(), []model.RelayPrice{
+		{HourlyUSD: 0.05},
+		{Region: "eu-west-1", InstanceType: "t4g.small", HourlyUSD: 0.0184},
+	})
+	if err != nil {
+		t.Fatalf("ReplaceRelayPrices: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestRollupLiveSessionCosts_CountsDefaultPrices(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("where s.status in ('active', 'grace')")).
+		WillReturnRows(pgxmock.NewRows([]string{"region", "instance_type", "fallback"}).
+			AddRow("us-east-1", "t4g.small", false).
+			AddRow("us-east-1", "c7g.large", true).
+			AddRow("us-east-1", "c7g.large", true))
+
+	metrics.ResetDefaultForTest()
+	n, err := New(mock).RollupLiveSessionCosts(context.Background())
+	if err != nil {
+		t.Fatalf("RollupLiveSessionCosts: %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 sessions estimated, got %d", n)
+	}
+	if got := metrics.Default().Render(); !strings.Contains(got, `aegis_session_cost_default_price_total{instance_type="c7g.large",region="us-east-1"} 2`) ||
+		strings.Contains(got, `instance_type="t4g.small"`) {
+		t.Fatalf("expected only the unpriced type counted, got:\n%s", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+// ErrUserSessionsLive means the user still has a session that has not been
+// stopped, so their data cannot be erased yet, or an admin start that must
+// not run alongside it is refused.
+var ErrUserSessionsLive = errors.New("user has live sessions")
+
+// erasureSubjectHash is what user_data_erasures keeps of an erased user's id.
+func erasureSubjectHash(userID string) string {
+	sum := sha256.Sum256([]byte(userID))
+	return hex.EncodeToString(sum[:])
+}
+
+// erasureSteps anonymize an erased user's rows, in order, with $1 the user's
+// id and $2, where used, their tombstone's. Each is counted under its name.
+// Rows needed for usage and billing totals move to the tombstone; tokens,
+// client IPs and user agents, raw relay payloads and the user's webhooks go.
+var erasureSteps = []struct {
+	name string
+	sql  string
+}{
+	{"relay_health_events", `
+update relay_health_events h
+set payload_json = '{}'::jsonb
+from sessions s
+where s.id = h.session_id and s.user_id = $1 and h.payload_json <> '{}'::jsonb`},
+	{"relay_instances", `
+update relay_instances ri
+set allowed_client_ip = null
+from sessions s
+where s.id = ri.session_id and s.user_id = $1 and ri.allowed_client_ip is not null`},
+	{"idempotency_records", `delete from idempotency_records where user_id = $1`},
+	{"sessions", `
+update sessions
+set user_id = $2, pair_token = '', relay_ws_token = '', idempotency_key = null,
+    requested_by = 'erased', started_from_ip = null, started_user_agent = null, updated_at = now()
+where user_id = $1`},
+	{"session_events", `
+update session_events
+set user_id = $2, payload_json = payload_json - 'client_ip' - 'user_id'
+where user_id = $1`},
+	{"session_changes", `update session_changes set user_id = $2 where user_id = $1`},
+	{"relay_terminations", `update relay_terminations set user_id = $2 where user_id = $1`},
+	{"usage_records", `update usage_records set user_id = $2 where user_id = $1`},
+	{"usage_alerts", `update usage_alerts set user_id = $2 where user_id = $1`},
+	{"billing_exports", `update billing_exports set user_id = $2, updated_at = now() where user_id = $1`},
+	{"webhook_deliveries", `
+update webhook_deliveries
+set payload_json = (payload_json - 'client_ip') || jsonb_build_object('user_id', $2::text), updated_at = now()
+where payload_json->>'user_id' = $1
+  and webhook_id in (select id from webhooks where user_id is null)`},
+	// The user's own webhooks go with their deliveries.
+	{"webhooks", `delete from webhooks where user_id = $1`},
+}
+
+// EraseUserData anonymizes a user whose sessions have all been stopped: their
+// history moves to a new tombstone user carrying the plan and cycle, personal
+// data is cleared, the user row is deleted and an audit row is written, all
+// in one transaction. Erasing a user again returns the first erasure with
+// erased false. ErrNotFound means the user never existed;
+// ErrUserSessionsLive means a session still has to be stopped first.
+func (s *Store) EraseUserData(ctx context.Context, userID, requestedBy string) (_ *model.UserDataErasure, erased bool, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Rollup)
+	defer done(&err)
+	var out *model.UserDataErasure
+	err = withTxRetry(ctx, "erase_user_data", func() (err error) {
+		out, erased, err = s.eraseUserData(ctx, userID, requestedBy)
+		return err
+	})
+	return out, erased, err
+}
+
+func (s *Store) eraseUserData(ctx context.Context, userID, requestedBy string) (*model.UserDataErasure, bool, error) {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, false, err
+	}
+	defer rollback(tx)
+
+	subject := erasureSubjectHash(userID)
+	// Starts of the user hold this lock until commit, so no session can
+	// start between the check below and the move to the tombstone.
+	if _, err := tx.Exec(ctx, `select pg_advisory_xact_lock(hashtextextended('sessions_live:' || $1, 0))`, userID); err != nil {
+		return nil, false, err
+	}
+	var locked string
+	err = tx.QueryRow(ctx, `select id from users where id = $1 for update`, userID).Scan(&locked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		prev, err := scanUserDataErasure(tx.QueryRow(ctx, `
+select tombstone_user_id, requested_by, rows_json, erased_at
+from user_data_erasures
+where subject_hash = $1`, subject))
+		return prev, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var live int
+	if err := tx.QueryRow(ctx, `
+select count(*)
+from sessions
+where user_id = $1 and status in ('provisioning', 'active', 'grace')`, userID).Scan(&live); err != nil {
+		return nil, false, err
+	}
+	if live > 0 {
+		return nil, false, ErrUserSessionsLive
+	}
+
+	tombstone := "usr_erased_" + uuid.NewString()
+	const tombstoneQ = `
+insert into users (id, email, plan_tier, plan_status, cycle_start_at, cycle_end_at, included_seconds, created_at, updated_at)
+select $2, $2 || '@erased.invalid', plan_tier, 'canceled', cycle_start_at, cycle_end_at, included_seconds, created_at, now()
+from users
+where id = $1`
+	if _, err := tx.Exec(ctx, tombstoneQ, userID, tombstone); err != nil {
+		return nil, false, err
+	}
+	counts := make(map[string]int, len(erasureSteps)+1)
+	for _, step := range erasureSteps {
+		args := []any{userID}
+		if strings.Contains(step.sql, "$2") {
+			args = append(args, tombstone)
+		}
+		tag, err := tx.Exec(ctx, step.sql, args...)
+		if err != nil {
+			return nil, false, err
+		}
+		counts[step.name] = int(tag.RowsAffected())
+	}
+	tag, err := tx.Exec(ctx, `delete from users where id = $1`, userID)
+	if err != nil {
+		return nil, false, err
+	}
+	counts["users"] = int(tag.RowsAffected())
+
+	rowsJSON, err := json.Marshal(counts)
+	if err != nil {
+		return nil, false, err
+	}
+	out, err := scanUserDataErasure(tx.QueryRow(ctx, `
+insert into user_data_erasures (subject_hash, tombstone_user_id, requested_by, rows_json, erased_at)
+values ($1, $2, $3, $4, now())
+returning tombstone_user_id, requested_by, rows_json, erased_at`, subject, tombstone, requestedBy, rowsJSON))
+	if err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
+
+func scanUserDataErasure(row pgx.Row) (*model.UserDataErasure, error) {
+	var (
+		e        model.UserDataErasure
+		rowsJSON []byte
+	)
+	if err := row.Scan(&e.TombstoneUserID, &e.RequestedBy, &rowsJSON, &e.ErasedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(rowsJSON, &e.Rows); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
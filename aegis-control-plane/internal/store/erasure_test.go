@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+)
+
+func TestEraseUserData_ReplaysEarlierErasure(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	erasedAt := time.Now().Add(-time.Hour)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("pg_advisory_xact_lock")).WithArgs("usr_1").WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mock.ExpectQuery(regexp.QuoteMeta("select id from users where id = $1 for update")).WithArgs("usr_1").WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta("from user_data_erasures")).
+		WithArgs(erasureSubjectHash("usr_1")).
+		WillReturnRows(pgxmock.NewRows([]string{"tombstone_user_id", "requested_by", "rows_json", "erased_at"}).
+			AddRow("usr_erased_1", "usr_admin", []byte(`{"sessions":2,"users":1}`), erasedAt))
+	mock.ExpectRollback()
+
+	got, erased, err := New(mock).EraseUserData(context.Background(), "usr_1", "usr_admin")
+	if err != nil {
+		t.Fatalf("EraseUserData: %v", err)
+	}
+	if erased || got.TombstoneUserID != "usr_erased_1" || got.Rows["sessions"] != 2 {
+		t.Fatalf("expected the earlier erasure replayed, got %+v erased=%v", got, erased)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestEraseUserData_RefusesLiveSessions(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("pg_advisory_xact_lock")).WithArgs("usr_1").WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mock.ExpectQuery(regexp.QuoteMeta("select id from users where id = $1 for update")).WithArgs("usr_1").
+		WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow("usr_1"))
+	mock.ExpectQuery(regexp.QuoteMeta("status in ('provisioning', 'active', 'grace')")).WithArgs("usr_1").
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+
+	if _, _, err := New(mock).EraseUserData(context.Background(), "usr_1", "usr_admin"); !errors.Is(err, ErrUserSessionsLive) {
+		t.Fatalf("expected ErrUserSessionsLive, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
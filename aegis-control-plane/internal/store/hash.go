@@ -0,0 +1,78 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// canonicalHashVersion prefixes CanonicalHash results; bump it when the
+// canonical form changes. HashJSON results are unprefixed (version 1).
+const canonicalHashVersion = "v2"
+
+// CanonicalHash hashes the request fields that affect behavior, selected by
+// the caller, so that a request keeps its hash across deploys that add
+// fields to the request struct. Keys are sorted, strings are trimmed and
+// lowercased, and zero values (and maps left empty) are dropped, so a new
+// optional field left unset does not change the hash either. Values may be
+// strings, bools, ints, nested map[string]any and []string, which is hashed
+// as a set: its strings are normalized, sorted and deduplicated.
+func CanonicalHash(fields map[string]any) (string, error) {
+	canon, err := canonicalMap(fields)
+	if err != nil {
+		return "", err
+	}
+	// encoding/json writes map keys in sorted order.
+	b, err := json.Marshal(canon)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return canonicalHashVersion + ":" + hex.EncodeToString(sum[:]), nil
+}
+
+func canonicalMap(fields map[string]any) (map[string]any, error) {
+	out := make(map[string]any, len(fields))
+	for key, v := range fields {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+				out[key] = v
+			}
+		case bool:
+			if v {
+				out[key] = v
+			}
+		case int:
+			if v != 0 {
+				out[key] = v
+			}
+		case []string:
+			set := make([]string, 0, len(v))
+			for _, s := range v {
+				if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+					set = append(set, s)
+				}
+			}
+			slices.Sort(set)
+			if set = slices.Compact(set); len(set) > 0 {
+				out[key] = set
+			}
+		case map[string]any:
+			nested, err := canonicalMap(v)
+			if err != nil {
+				return nil, err
+			}
+			if len(nested) > 0 {
+				out[key] = nested
+			}
+		default:
+			return nil, fmt.Errorf("canonical hash: unsupported %T for %q", v, key)
+		}
+	}
+	return out, nil
+}
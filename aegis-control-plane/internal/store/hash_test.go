@@ -0,0 +1,107 @@
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+// canonicalFields is what a handler would select from either request
+// version below.
+func canonicalFields(region, requestedBy string) map[string]any {
+	return map[string]any{
+		"region_preference": region,
+		"client_context":    map[string]any{"requested_by": requestedBy},
+	}
+}
+
+func TestCanonicalHash_StableAcrossStructFieldAdditions(t *testing.T) {
+	type startV1 struct {
+		RegionPreference string `json:"region_preference"`
+	}
+	type startV2 struct {
+		RegionPreference string `json:"region_preference"`
+		Priority         int    `json:"priority"`
+	}
+	v1, v2 := startV1{RegionPreference: "us-east-1"}, startV2{RegionPreference: "us-east-1"}
+
+	// HashJSON hashes the marshaled struct, so the new field changes it ...
+	h1, _ := HashJSON(v1)
+	h2, _ := HashJSON(v2)
+	if h1 == h2 {
+		t.Fatal("expected HashJSON to differ once the struct has a new field")
+	}
+
+	// ... while the canonical hash only sees the selected fields, and an
+	// unset new field is dropped even once it is selected.
+	c1, err := CanonicalHash(canonicalFields(v1.RegionPreference, "dashboard"))
+	if err != nil {
+		t.Fatalf("CanonicalHash: %v", err)
+	}
+	withNew := canonicalFields(v2.RegionPreference, "dashboard")
+	withNew["priority"] = v2.Priority
+	c2, err := CanonicalHash(withNew)
+	if err != nil {
+		t.Fatalf("CanonicalHash: %v", err)
+	}
+	if c1 != c2 {
+		t.Fatalf("expected equal hashes, got %s and %s", c1, c2)
+	}
+	if !strings.HasPrefix(c1, "v2:") {
+		t.Fatalf("expected a v2: prefix, got %s", c1)
+	}
+}
+
+func TestCanonicalHash_IgnoresOrderCaseAndWhitespace(t *testing.T) {
+	a := map[string]any{}
+	a["static_ip"] = true
+	a["region_preference"] = "us-east-1"
+	a["client_context"] = map[string]any{"requested_by": "dashboard"}
+	b := map[string]any{
+		"client_context":    map[string]any{"requested_by": " Dashboard "},
+		"region_preference": "US-EAST-1",
+		"static_ip":         true,
+	}
+	ha, err := CanonicalHash(a)
+	if err != nil {
+		t.Fatalf("CanonicalHash: %v", err)
+	}
+	hb, err := CanonicalHash(b)
+	if err != nil {
+		t.Fatalf("CanonicalHash: %v", err)
+	}
+	if ha != hb {
+		t.Fatalf("expected equal hashes, got %s and %s", ha, hb)
+	}
+
+	b["region_preference"] = "eu-west-1"
+	if hc, _ := CanonicalHash(b); hc == ha {
+		t.Fatal("expected a different region to change the hash")
+	}
+	if _, err := CanonicalHash(map[string]any{"ratio": 0.5}); err == nil {
+		t.Fatal("expected an unsupported value type to be rejected")
+	}
+}
+
+func TestCanonicalHash_HashesStringSlicesAsSets(t *testing.T) {
+	hash := func(tags []string) string {
+		t.Helper()
+		h, err := CanonicalHash(map[string]any{"region_preference": "us-east-1", "client_context": map[string]any{"tags": tags}})
+		if err != nil {
+			t.Fatalf("CanonicalHash: %v", err)
+		}
+		return h
+	}
+	if hash([]string{"friday-show", "client-x"}) != hash([]string{" Client-X", "friday-show", "client-x"}) {
+		t.Fatal("expected order, case and duplicates not to change the hash")
+	}
+	bare, err := CanonicalHash(map[string]any{"region_preference": "us-east-1"})
+	if err != nil {
+		t.Fatalf("CanonicalHash: %v", err)
+	}
+	if hash(nil) != bare || hash([]string{" "}) != bare {
+		t.Fatal("expected an empty set to be dropped like other zero values")
+	}
+	if hash([]string{"client-x"}) == bare {
+		t.Fatal("expected tags to change the hash")
+	}
+}
@@ -0,0 +1,111 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+func TestEraseUserData_LeavesOnlyUsageTotals(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	userID := createUser(t, march)
+	if _, err := s.CreateWebhook(ctx, model.Webhook{UserID: userID, URL: "https://hooks.test/user", Secret: "s"}); err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	if _, err := s.CreateWebhook(ctx, model.Webhook{URL: "https://hooks.test/global", Secret: "s"}); err != nil {
+		t.Fatalf("CreateWebhook: %v", err)
+	}
+	insertStoppedSession(t, userID, march.AddDate(0, 0, 3), 1800)
+	sess, _, err := s.StartOrGetSession(ctx, store.StartInput{
+		UserID: userID, Region: "us-east-1", RequestedBy: "integration", IdempotencyKey: uuid.New(), RequestHash: "hash",
+		StartedFromIP: "198.51.100.23", StartedUserAgent: "OBS/30.1",
+	})
+	if err != nil {
+		t.Fatalf("StartOrGetSession: %v", err)
+	}
+	if _, err := s.ActivateProvisionedSession(ctx, store.ActivateProvisionedSessionInput{
+		UserID: userID, SessionID: sess.ID, Region: "us-east-1", AWSInstanceID: "i-" + uuid.NewString()[:17], AMIID: "ami-1", InstanceType: "t4g.small",
+		PublicIP: "203.0.113.10", SRTPorts: []int{9000}, WSURL: "wss://relay.test/ws", PairToken: uuid.NewString()[:8], RelayWSToken: "ws-token",
+		AllowedClientIP: "198.51.100.23",
+	}); err != nil {
+		t.Fatalf("ActivateProvisionedSession: %v", err)
+	}
+	if err := s.RecordSessionEvent(ctx, sess.ID, userID, "credentials_fetched", map[string]any{"session_id": sess.ID, "client_ip": "198.51.100.23"}); err != nil {
+		t.Fatalf("RecordSessionEvent: %v", err)
+	}
+	if err := s.RecordRelayHealth(ctx, healthEvent(sess.ID, time.Now().UTC())); err != nil {
+		t.Fatalf("RecordRelayHealth: %v", err)
+	}
+
+	if _, _, err := s.EraseUserData(ctx, userID, "usr_admin"); !errors.Is(err, store.ErrUserSessionsLive) {
+		t.Fatalf("expected a live session to block erasure, got %v", err)
+	}
+	if _, err := s.StopSession(ctx, store.StopSessionInput{UserID: userID, SessionID: sess.ID, Reason: model.StopReasonErasure}); err != nil {
+		t.Fatalf("StopSession: %v", err)
+	}
+	if _, err := s.UpsertUsageRollups(ctx); err != nil {
+		t.Fatalf("UpsertUsageRollups: %v", err)
+	}
+	billable := count(t, `select coalesce(sum(billable_seconds), 0) from usage_records where user_id = $1`, userID)
+	if billable == 0 {
+		t.Fatal("expected usage rolled up before erasure")
+	}
+
+	erasure, erased, err := s.EraseUserData(ctx, userID, "usr_admin")
+	if err != nil || !erased {
+		t.Fatalf("EraseUserData: erased=%v err=%v", erased, err)
+	}
+	if erasure.Rows["sessions"] != 2 || erasure.Rows["users"] != 1 || erasure.Rows["webhooks"] != 1 {
+		t.Fatalf("unexpected row counts %v", erasure.Rows)
+	}
+
+	// No row of any table mentions the user, in any column.
+	rows, err := pool.Query(ctx, `select table_name from information_schema.tables where table_schema = current_schema() and table_type = 'BASE TABLE'`)
+	if err != nil {
+		t.Fatalf("list tables: %v", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatalf("scan table: %v", err)
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+	for _, table := range tables {
+		if n := count(t, `select count(*) from `+table+` t where strpos(t::text, $1) > 0`, userID); n != 0 {
+			t.Fatalf("expected no %s row to reference the erased user, got %d", table, n)
+		}
+	}
+	if n := count(t, `select count(*) from relay_instances ri join sessions s on s.id = ri.session_id where s.id = $1 and ri.allowed_client_ip is null`, sess.ID); n != 1 {
+		t.Fatalf("expected the relay's client IP cleared, got %d", n)
+	}
+	if n := count(t, `select count(*) from sessions where id = $1 and started_from_ip is null and started_user_agent is null`, sess.ID); n != 1 {
+		t.Fatalf("expected the session's starting client cleared, got %d", n)
+	}
+	if n := count(t, `select count(*) from session_events where session_id = $1 and payload_json ? 'client_ip'`, sess.ID); n != 0 {
+		t.Fatalf("expected client IPs dropped from session events, got %d", n)
+	}
+	if n := count(t, `select count(*) from relay_health_events where session_id = $1 and payload_json <> '{}'::jsonb`, sess.ID); n != 0 {
+		t.Fatalf("expected raw health payloads cleared, got %d", n)
+	}
+	if got := count(t, `select coalesce(sum(billable_seconds), 0) from usage_records where user_id = $1`, erasure.TombstoneUserID); got != billable {
+		t.Fatalf("expected %d billable seconds kept under the tombstone, got %d", billable, got)
+	}
+
+	again, erased, err := s.EraseUserData(ctx, userID, "usr_other")
+	if err != nil || erased || again.TombstoneUserID != erasure.TombstoneUserID || again.RequestedBy != "usr_admin" {
+		t.Fatalf("expected the first erasure replayed, got %+v erased=%v err=%v", again, erased, err)
+	}
+	if _, _, err := s.EraseUserData(ctx, "usr_"+uuid.NewString(), "usr_admin"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an unknown user, got %v", err)
+	}
+}
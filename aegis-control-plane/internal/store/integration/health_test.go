@@ -0,0 +1,230 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+// activeSession starts and activates a session for a new user and returns
+// its ID.
+func activeSession(t testing.TB, s *store.Store) string {
+	t.Helper()
+	ctx := context.Background()
+	userID := createUser(t, march)
+	sess, _, err := s.StartOrGetSession(ctx, store.StartInput{UserID: userID, Region: "us-east-1", RequestedBy: "integration", IdempotencyKey: uuid.New(), RequestHash: "hash"})
+	if err != nil {
+		t.Fatalf("StartOrGetSession: %v", err)
+	}
+	if _, err := s.ActivateProvisionedSession(ctx, store.ActivateProvisionedSessionInput{
+		UserID: userID, SessionID: sess.ID, Region: "us-east-1", AWSInstanceID: "i-" + uuid.NewString()[:17], AMIID: "ami-1", InstanceType: "t4g.small",
+		PublicIP: "203.0.113.10", SRTPorts: []int{9000}, WSURL: "wss://relay.test/ws", PairToken: uuid.NewString()[:8], RelayWSToken: "ws-token",
+	}); err != nil {
+		t.Fatalf("ActivateProvisionedSession: %v", err)
+	}
+	return sess.ID
+}
+
+func healthEvent(sessionID string, observedAt time.Time) store.RelayHealthInput {
+	return store.RelayHealthInput{
+		SessionID: sessionID, ObservedAt: observedAt, IngestActive: true, EgressActive: true,
+		SessionUptimeSeconds: 60, RawPayload: json.RawMessage(`{"session_id":"` + sessionID + `"}`),
+	}
+}
+
+func TestRecordRelayHealthBatch_LatestObservationPerRelay(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	a, b := activeSession(t, s), activeSession(t, s)
+	userID := createUser(t, march)
+	unbound, _, err := s.StartOrGetSession(ctx, store.StartInput{UserID: userID, Region: "us-east-1", RequestedBy: "integration", IdempotencyKey: uuid.New(), RequestHash: "hash"})
+	if err != nil {
+		t.Fatalf("StartOrGetSession: %v", err)
+	}
+
+	t0 := time.Now().UTC().Truncate(time.Second)
+	rejected, err := s.RecordRelayHealthBatch(ctx, []store.RelayHealthInput{
+		healthEvent(a, t0.Add(2*time.Second)),
+		healthEvent(a, t0),
+		healthEvent(unbound.ID, t0),
+		healthEvent(b, t0.Add(time.Second)),
+		healthEvent("ses_missing", t0),
+	})
+	if err != nil {
+		t.Fatalf("RecordRelayHealthBatch: %v", err)
+	}
+	for i, want := range []bool{false, false, true, false, true} {
+		if got := errors.Is(rejected[i], store.ErrRelayHealthRejected); got != want {
+			t.Fatalf("event %d: expected rejected=%v, got %v", i, want, rejected[i])
+		}
+	}
+	if n := count(t, `select count(*) from relay_health_events where session_id in ($1, $2)`, a, b); n != 3 {
+		t.Fatalf("expected 3 stored events, got %d", n)
+	}
+	for id, want := range map[string]time.Time{a: t0.Add(2 * time.Second), b: t0.Add(time.Second)} {
+		if n := count(t, `
+select count(*) from relay_instances ri join sessions s on s.relay_instance_id = ri.id
+where s.id = $1 and ri.last_health_at = $2`, id, want); n != 1 {
+			t.Fatalf("expected %s's relay last_health_at at %s", id, want)
+		}
+	}
+}
+
+// BenchmarkRecordRelayHealth compares 100 events sent one by one with the
+// same events in one batch.
+func BenchmarkRecordRelayHealth(b *testing.B) {
+	s := newStore(b)
+	ctx := context.Background()
+	sessionID := activeSession(b, s)
+	events := make([]store.RelayHealthInput, store.MaxRelayHealthBatch)
+	for i := range events {
+		events[i] = healthEvent(sessionID, time.Now().UTC().Add(time.Duration(i)*time.Millisecond))
+	}
+
+	b.Run("single", func(b *testing.B) {
+		for range b.N {
+			for _, e := range events {
+				if err := s.RecordRelayHealth(ctx, e); err != nil {
+					b.Fatalf("RecordRelayHealth: %v", err)
+				}
+			}
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		for range b.N {
+			if _, err := s.RecordRelayHealthBatch(ctx, events); err != nil {
+				b.Fatalf("RecordRelayHealthBatch: %v", err)
+			}
+		}
+	})
+}
+
+func TestReconcileOutageFromHealth_SkipsEventsBeforeHighWater(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	id := activeSession(t, s)
+
+	if err := s.RecordRelayHealth(ctx, healthEvent(id, time.Now())); err != nil {
+		t.Fatalf("RecordRelayHealth: %v", err)
+	}
+	if _, err := s.ReconcileOutageFromHealth(ctx); err != nil {
+		t.Fatalf("ReconcileOutageFromHealth: %v", err)
+	}
+	if n := count(t, `select count(*) from sessions where id = $1 and reconciled_seconds = 60`, id); n != 1 {
+		t.Fatal("expected the session reconciled to the reported uptime")
+	}
+
+	// An event created an hour before the high-water mark is not reread.
+	late := healthEvent(id, time.Now())
+	late.SessionUptimeSeconds = 120
+	if err := s.RecordRelayHealth(ctx, late); err != nil {
+		t.Fatalf("RecordRelayHealth: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `update relay_health_events set created_at = now() - interval '1 hour' where session_id = $1 and session_uptime_seconds = 120`, id); err != nil {
+		t.Fatalf("backdate event: %v", err)
+	}
+	if _, err := s.ReconcileOutageFromHealth(ctx); err != nil {
+		t.Fatalf("ReconcileOutageFromHealth: %v", err)
+	}
+	if n := count(t, `select count(*) from sessions where id = $1 and reconciled_seconds = 60`, id); n != 1 {
+		t.Fatal("expected events before the high-water mark to be skipped")
+	}
+}
+
+func TestReconcileOutageFromHealth_IgnoresOutdatedAgents(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	id := activeSession(t, s)
+
+	e := healthEvent(id, time.Now())
+	e.SessionUptimeSeconds = 600
+	e.AgentVersion, e.AgentOutdated = "v1.1", true
+	if err := s.RecordRelayHealth(ctx, e); err != nil {
+		t.Fatalf("RecordRelayHealth: %v", err)
+	}
+	if n := count(t, `
+select count(*) from relay_instances ri join sessions s on s.relay_instance_id = ri.id
+where s.id = $1 and ri.agent_version = 'v1.1'`, id); n != 1 {
+		t.Fatal("expected the relay's agent version recorded")
+	}
+	if _, err := s.ReconcileOutageFromHealth(ctx); err != nil {
+		t.Fatalf("ReconcileOutageFromHealth: %v", err)
+	}
+	if n := count(t, `select count(*) from sessions where id = $1 and reconciled_seconds = 600`, id); n != 0 {
+		t.Fatal("expected an outdated agent's uptime not to be reconciled")
+	}
+}
+
+func TestDeleteRelayHealthEventsBefore_DeletesOnlyExpired(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	id := activeSession(t, s)
+
+	for range 3 {
+		if err := s.RecordRelayHealth(ctx, healthEvent(id, time.Now())); err != nil {
+			t.Fatalf("RecordRelayHealth: %v", err)
+		}
+	}
+	if _, err := pool.Exec(ctx, `
+update relay_health_events set created_at = now() - interval '40 days'
+where id in (select id from relay_health_events where session_id = $1 order by id limit 2)`, id); err != nil {
+		t.Fatalf("backdate events: %v", err)
+	}
+
+	n, err := s.DeleteRelayHealthEventsBefore(ctx, time.Now().Add(-30*24*time.Hour), 1)
+	if err != nil || n != 1 {
+		t.Fatalf("expected one event deleted per batch, got %d err=%v", n, err)
+	}
+	if _, err := s.DeleteRelayHealthEventsBefore(ctx, time.Now().Add(-30*24*time.Hour), 100); err != nil {
+		t.Fatalf("DeleteRelayHealthEventsBefore: %v", err)
+	}
+	if n := count(t, `select count(*) from relay_health_events where session_id = $1`, id); n != 1 {
+		t.Fatalf("expected the recent event kept, got %d events", n)
+	}
+}
+
+func TestRollupRelayQuality_CountsGapsPerAMIOnce(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	id := activeSession(t, s)
+	amiID := "ami-" + uuid.NewString()[:8]
+	yesterday := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+	if _, err := pool.Exec(ctx, `update relay_instances set ami_id = $2, launched_at = $3 where session_id = $1`, id, amiID, yesterday.Add(-time.Hour)); err != nil {
+		t.Fatalf("backdate relay: %v", err)
+	}
+	for _, at := range []time.Duration{10 * time.Hour, 10*time.Hour + time.Minute, 10*time.Hour + 10*time.Minute} {
+		if err := s.RecordRelayHealth(ctx, healthEvent(id, yesterday.Add(at))); err != nil {
+			t.Fatalf("RecordRelayHealth: %v", err)
+		}
+	}
+	if _, err := pool.Exec(ctx, `delete from job_watermarks where job = 'relay_quality_rollup'`); err != nil {
+		t.Fatalf("reset watermark: %v", err)
+	}
+
+	n, err := s.RollupRelayQuality(ctx, 90*time.Second, 3)
+	if err != nil || n == 0 {
+		t.Fatalf("expected rows written, got %d err=%v", n, err)
+	}
+	days, err := s.ListRelayQuality(ctx, store.RelayQualityFilter{AMIID: amiID, Limit: 10})
+	if err != nil {
+		t.Fatalf("ListRelayQuality: %v", err)
+	}
+	if len(days) != 1 || !days[0].Day.Equal(yesterday) || days[0].Relays != 1 || days[0].RelaysWithGaps != 1 ||
+		days[0].HealthGaps != 1 || days[0].GapSeconds != 540 || days[0].NeverHealthy != 0 {
+		t.Fatalf("unexpected quality %+v", days)
+	}
+	if n, err := s.RollupRelayQuality(ctx, 90*time.Second, 3); err != nil || n != 0 {
+		t.Fatalf("expected a second run to find no ended days, got %d err=%v", n, err)
+	}
+
+	relays, err := s.ListRelayInstances(ctx, store.RelayInstanceFilter{AMIID: amiID, LaunchedTo: yesterday, Limit: 10})
+	if err != nil || len(relays) != 1 || relays[0].SessionID != id || relays[0].LastHealthAt == nil {
+		t.Fatalf("expected the backdated relay listed, got %+v err=%v", relays, err)
+	}
+}
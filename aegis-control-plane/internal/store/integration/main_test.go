@@ -0,0 +1,111 @@
+// Package integration runs the store against a real Postgres. It is skipped
+// unless AEGIS_TEST_DATABASE_URL is set; each run applies the migrations to
+// a fresh schema and drops it afterwards, so any scratch database will do.
+package integration
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+	"github.com/telemyapp/aegis-control-plane/migrations"
+)
+
+// pool is nil when the suite is skipped.
+var pool *pgxpool.Pool
+
+func TestMain(m *testing.M) {
+	url := os.Getenv("AEGIS_TEST_DATABASE_URL")
+	if url == "" {
+		os.Exit(m.Run())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	schema := "aegis_it_" + uuid.NewString()[:8]
+	p, err := setup(ctx, url, schema)
+	cancel()
+	if err != nil {
+		log.Fatalf("integration setup: %v", err)
+	}
+	pool = p
+	code := m.Run()
+
+	if _, err := pool.Exec(context.Background(), `drop schema `+schema+` cascade`); err != nil {
+		log.Printf("integration teardown: drop schema %s: %v", schema, err)
+	}
+	pool.Close()
+	os.Exit(code)
+}
+
+// setup creates schema, points every pooled connection at it and applies
+// the migrations.
+func setup(ctx context.Context, url, schema string) (*pgxpool.Pool, error) {
+	cfg, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		return nil, fmt.Errorf("parse AEGIS_TEST_DATABASE_URL: %w", err)
+	}
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema
+	cfg.MaxConns = 20
+	p, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.Exec(ctx, `create schema `+schema); err != nil {
+		p.Close()
+		return nil, err
+	}
+	names, err := migrations.Files()
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	for _, name := range names {
+		sql, err := migrations.Read(name)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		if _, err := p.Exec(ctx, sql); err != nil {
+			p.Close()
+			return nil, fmt.Errorf("apply %s: %w", name, err)
+		}
+	}
+	return p, nil
+}
+
+// newStore returns a store on the test schema, or skips the test.
+func newStore(t testing.TB) *store.Store {
+	t.Helper()
+	if pool == nil {
+		t.Skip("AEGIS_TEST_DATABASE_URL not set")
+	}
+	return store.New(pool)
+}
+
+// createUser inserts a user whose cycle is [cycleStart, cycleStart+1 month).
+func createUser(t testing.TB, cycleStart time.Time) string {
+	t.Helper()
+	id := "usr_" + uuid.NewString()
+	_, err := pool.Exec(context.Background(), `
+insert into users (id, email, plan_tier, cycle_start_at, cycle_end_at, included_seconds)
+values ($1, $1 || '@example.test', 'starter', $2, $3, 36000)`, id, cycleStart, cycleStart.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return id
+}
+
+func count(t testing.TB, q string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := pool.QueryRow(context.Background(), q, args...).Scan(&n); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	return n
+}
@@ -0,0 +1,63 @@
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/seed"
+)
+
+func TestSeedGenerate_WritesConsistentHistory(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	if err := s.Wipe(ctx); err != nil {
+		t.Fatalf("Wipe: %v", err)
+	}
+	sum, err := seed.Generate(ctx, s, seed.Options{
+		Users: 5, SessionsPerUser: 4, Days: 3, Seed: 1, HealthInterval: time.Minute, Regions: []string{"us-east-1"},
+	})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if sum.Users != 5 || sum.Sessions != 20 || sum.Provisioning != 1 {
+		t.Fatalf("unexpected summary %+v", sum)
+	}
+
+	checks := []struct {
+		name string
+		q    string
+		want int
+	}{
+		{"users", `select count(*) from users where plan_tier <> 'canary'`, 5},
+		{"stuck sessions", `select count(*) from sessions where status = 'provisioning' and relay_instance_id is null and started_at < now() - interval '10 minutes'`, 1},
+		{"stopped sessions", `select count(*) from sessions where status = 'stopped' and stopped_at > started_at and stopped_at <= now() and duration_seconds > 0`, 19},
+		{"terminated relays", `select count(*) from relay_instances where state = 'terminated' and terminated_at <= now()`, 19},
+		{"open terminations", `select count(*) from relay_terminations where completed_at is null`, 0},
+		{"heartbeats", `select count(*) from relay_health_events`, sum.Heartbeats},
+		{"heartbeats outside their session", `select count(*) from relay_health_events h join sessions s on s.id = h.session_id where h.observed_at < s.started_at or h.observed_at > s.stopped_at + interval '1 second'`, 0},
+		// Outages leave a gap in the heartbeats, and reconciliation lifts
+		// the measured duration to the relay's uptime.
+		{"reconciled outages", `
+select count(*) from sessions s
+where s.duration_seconds = s.reconciled_seconds
+  and exists (
+    select 1 from (
+      select observed_at - lag(observed_at) over (order by observed_at) as gap
+      from relay_health_events where session_id = s.id
+    ) g where g.gap > interval '2 minutes'
+  )`, sum.Outages},
+	}
+	for _, c := range checks {
+		if got := count(t, c.q); got != c.want {
+			t.Errorf("%s: expected %d, got %d", c.name, c.want, got)
+		}
+	}
+
+	if err := s.Wipe(ctx); err != nil {
+		t.Fatalf("Wipe: %v", err)
+	}
+	if n := count(t, `select count(*) from sessions`); n != 0 {
+		t.Fatalf("expected Wipe to remove sessions, got %d", n)
+	}
+}
@@ -0,0 +1,154 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+// lastChangeSeq is the position at the end of the changefeed.
+func lastChangeSeq(t *testing.T) int64 {
+	t.Helper()
+	var seq int64
+	err := pool.QueryRow(context.Background(), `select seq from session_changes order by txid desc, seq desc limit 1`).Scan(&seq)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("last seq: %v", err)
+	}
+	return seq
+}
+
+func TestSessionChanges_RecordEveryCommittedStatusChange(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	before := lastChangeSeq(t)
+
+	userID := createUser(t, march)
+	sess, _, err := s.StartOrGetSession(ctx, store.StartInput{UserID: userID, Region: "us-east-1", RequestedBy: "integration", IdempotencyKey: uuid.New(), RequestHash: "hash"})
+	if err != nil {
+		t.Fatalf("StartOrGetSession: %v", err)
+	}
+	if err := s.AdvanceProvisioningPhase(ctx, userID, sess.ID, "launching"); err != nil {
+		t.Fatalf("AdvanceProvisioningPhase: %v", err)
+	}
+	if _, err := s.StopSession(ctx, store.StopSessionInput{UserID: userID, SessionID: sess.ID, Reason: model.StopReasonUser}); err != nil {
+		t.Fatalf("StopSession: %v", err)
+	}
+
+	changes, err := s.ListSessionChanges(ctx, before, 100)
+	if err != nil {
+		t.Fatalf("ListSessionChanges: %v", err)
+	}
+	var mine []model.SessionChange
+	for _, c := range changes {
+		if c.SessionID == sess.ID {
+			mine = append(mine, c)
+		}
+	}
+	// The phase change leaves the status alone and adds no row.
+	if len(mine) != 2 {
+		t.Fatalf("expected the start and the stop, got %+v", mine)
+	}
+	if c := mine[0]; c.Status != model.SessionProvisioning || c.PreviousStatus != "" || c.UserID != userID || c.Region != "us-east-1" {
+		t.Fatalf("unexpected start %+v", c)
+	}
+	if c := mine[1]; c.Status != model.SessionStopped || c.PreviousStatus != model.SessionProvisioning || c.StopReason != "user" || c.Seq <= mine[0].Seq {
+		t.Fatalf("unexpected stop %+v", c)
+	}
+
+	// Rows before the acknowledged one go, whatever their age; that one is
+	// kept for the next read, and an older ack changes nothing.
+	if err := s.AckSessionChanges(ctx, "integration", mine[1].Seq); err != nil {
+		t.Fatalf("AckSessionChanges: %v", err)
+	}
+	if err := s.AckSessionChanges(ctx, "integration", mine[0].Seq); err != nil {
+		t.Fatalf("AckSessionChanges: %v", err)
+	}
+	if _, err := s.DeleteSessionChanges(ctx, time.Now().Add(-time.Hour), 1000); err != nil {
+		t.Fatalf("DeleteSessionChanges: %v", err)
+	}
+	if n := count(t, `select count(*) from session_changes where session_id = $1`, sess.ID); n != 1 {
+		t.Fatalf("expected only the acknowledged change kept, got %d", n)
+	}
+	if _, err := s.ListSessionChanges(ctx, mine[1].Seq, 100); err != nil {
+		t.Fatalf("expected reads to resume after the acknowledged change, got %v", err)
+	}
+	if _, err := s.ListSessionChanges(ctx, mine[0].Seq, 100); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after a deleted change, got %v", err)
+	}
+	if _, err := pool.Exec(ctx, `delete from session_change_consumers where consumer = 'integration'`); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+}
+
+func TestSessionChanges_LateCommitIsNotSkipped(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	before := lastChangeSeq(t)
+
+	begin := func() pgx.Tx {
+		t.Helper()
+		tx, err := pool.Begin(ctx)
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		t.Cleanup(func() { _ = tx.Rollback(ctx) })
+		// Take a transaction ID now, as a transaction's first write would.
+		if _, err := tx.Exec(ctx, `select pg_current_xact_id()`); err != nil {
+			t.Fatalf("xact id: %v", err)
+		}
+		return tx
+	}
+	insert := func(tx pgx.Tx, sessionID string) {
+		t.Helper()
+		const q = `
+insert into session_changes (txid, session_id, user_id, region, status)
+values (pg_current_xact_id()::text::bigint, $1, 'usr_late', 'us-east-1', 'active')`
+		if _, err := tx.Exec(ctx, q, sessionID); err != nil {
+			t.Fatalf("insert change: %v", err)
+		}
+	}
+	read := func(after int64) []model.SessionChange {
+		t.Helper()
+		changes, err := s.ListSessionChanges(ctx, after, 100)
+		if err != nil {
+			t.Fatalf("ListSessionChanges: %v", err)
+		}
+		var out []model.SessionChange
+		for _, c := range changes {
+			if c.UserID == "usr_late" {
+				out = append(out, c)
+			}
+		}
+		return out
+	}
+
+	// early takes its transaction ID first but appends after late, which
+	// commits while early is still open.
+	early := begin()
+	late := begin()
+	insert(late, "ses_late_1")
+	insert(early, "ses_late_2")
+	if err := late.Commit(ctx); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if got := read(before); len(got) != 0 {
+		t.Fatalf("expected nothing while an older transaction is open, got %+v", got)
+	}
+	if err := early.Commit(ctx); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	got := read(before)
+	if len(got) != 2 || got[0].SessionID != "ses_late_2" || got[1].SessionID != "ses_late_1" {
+		t.Fatalf("expected both changes in transaction order, got %+v", got)
+	}
+	if _, err := pool.Exec(ctx, `delete from session_changes where user_id = 'usr_late'`); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+}
@@ -0,0 +1,750 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+var march = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+func TestStartOrGetSession_ConcurrentStartsShareOneSession(t *testing.T) {
+	s := newStore(t)
+	for _, tc := range []struct {
+		name    string
+		sameKey bool
+	}{
+		{"distinct keys", false},
+		{"same key", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			userID := createUser(t, march)
+			key := uuid.New()
+			const starts = 8
+			type result struct {
+				sess    *model.Session
+				created bool
+				err     error
+			}
+			results := make(chan result, starts)
+			var ready sync.WaitGroup
+			ready.Add(1)
+			for range starts {
+				k := key
+				if !tc.sameKey {
+					k = uuid.New()
+				}
+				go func() {
+					ready.Wait()
+					sess, created, err := s.StartOrGetSession(context.Background(), store.StartInput{
+						UserID: userID, Region: "us-east-1", RequestedBy: "integration", IdempotencyKey: k, RequestHash: "hash",
+					})
+					results <- result{sess, created, err}
+				}()
+			}
+			ready.Done()
+
+			var created int
+			ids := make(map[string]bool)
+			for range starts {
+				r := <-results
+				if r.err != nil {
+					t.Fatalf("StartOrGetSession: %v", r.err)
+				}
+				if r.created {
+					created++
+				}
+				ids[r.sess.ID] = true
+			}
+			if created != 1 || len(ids) != 1 {
+				t.Fatalf("expected one created session shared by every start, created=%d ids=%v", created, ids)
+			}
+			if n := count(t, `select count(*) from sessions where user_id = $1`, userID); n != 1 {
+				t.Fatalf("expected 1 session row, got %d", n)
+			}
+		})
+	}
+}
+
+func TestStartOrGetSession_ReplayKeepsOriginalClient(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	userID := createUser(t, march)
+	in := store.StartInput{
+		UserID: userID, Region: "us-east-1", RequestedBy: "integration", IdempotencyKey: uuid.New(), RequestHash: "hash",
+		StartedFromIP: "198.51.100.7", StartedUserAgent: "OBS/30.1",
+	}
+	sess, _, err := s.StartOrGetSession(ctx, in)
+	if err != nil {
+		t.Fatalf("StartOrGetSession: %v", err)
+	}
+	// A replay of the key, and a new key finding the live session, both
+	// come from elsewhere.
+	in.StartedFromIP, in.StartedUserAgent = "203.0.113.9", "curl/8.0"
+	if _, _, err := s.StartOrGetSession(ctx, in); err != nil {
+		t.Fatalf("StartOrGetSession replay: %v", err)
+	}
+	in.IdempotencyKey = uuid.New()
+	if _, _, err := s.StartOrGetSession(ctx, in); err != nil {
+		t.Fatalf("StartOrGetSession existing: %v", err)
+	}
+	sr, err := s.GetSessionRelay(ctx, sess.ID)
+	if err != nil {
+		t.Fatalf("GetSessionRelay: %v", err)
+	}
+	if sr.StartedFromIP != "198.51.100.7" || sr.StartedUserAgent != "OBS/30.1" {
+		t.Fatalf("expected the original client kept, got %q %q", sr.StartedFromIP, sr.StartedUserAgent)
+	}
+}
+
+func TestStartOrGetSession_AdminStartsAreCappedAcrossUsers(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	live := count(t, `select count(*) from sessions where started_by_admin is not null and status in ('provisioning', 'active', 'grace', 'stopping')`)
+	start := func(userID string) (*model.Session, error) {
+		sess, _, err := s.StartOrGetSession(ctx, store.StartInput{
+			UserID: userID, Region: "us-east-1", RequestedBy: "integration", IdempotencyKey: uuid.New(), RequestHash: "hash",
+			NonBillable: true, StartedByAdmin: "usr_admin", MaxAdminSessions: live + 1, RefuseIfLive: true,
+		})
+		return sess, err
+	}
+
+	first := createUser(t, march)
+	sess, err := start(first)
+	if err != nil {
+		t.Fatalf("admin start: %v", err)
+	}
+	if n := count(t, `select count(*) from session_events where session_id = $1 and event_type = 'admin_session_started' and payload_json->>'admin_id' = 'usr_admin'`, sess.ID); n != 1 {
+		t.Fatalf("expected the admin recorded in a session event, got %d", n)
+	}
+	if _, err := start(first); !errors.Is(err, store.ErrUserSessionsLive) {
+		t.Fatalf("expected ErrUserSessionsLive while the user has a session, got %v", err)
+	}
+	if _, err := start(createUser(t, march)); !errors.Is(err, store.ErrAdminSessionLimit) {
+		t.Fatalf("expected ErrAdminSessionLimit, got %v", err)
+	}
+	if _, err := start("usr_missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an unknown user, got %v", err)
+	}
+}
+
+func TestStartOrGetSession_StudioPlanAllowsThreeLiveSessions(t *testing.T) {
+	s := newStore(t)
+	userID := createUser(t, march)
+	if _, err := pool.Exec(context.Background(), `update users set plan_tier = 'studio' where id = $1`, userID); err != nil {
+		t.Fatalf("set plan: %v", err)
+	}
+	const starts = 8
+	errs := make(chan error, starts)
+	var ready sync.WaitGroup
+	ready.Add(1)
+	for range starts {
+		go func() {
+			ready.Wait()
+			_, _, err := s.StartOrGetSession(context.Background(), store.StartInput{
+				UserID: userID, Region: "us-east-1", RequestedBy: "integration", IdempotencyKey: uuid.New(), RequestHash: "hash",
+			})
+			errs <- err
+		}()
+	}
+	ready.Done()
+
+	var limited int
+	for range starts {
+		switch err := <-errs; {
+		case errors.Is(err, store.ErrSessionLimit):
+			limited++
+		case err != nil:
+			t.Fatalf("StartOrGetSession: %v", err)
+		}
+	}
+	if limited != starts-3 {
+		t.Fatalf("expected %d starts over the limit, got %d", starts-3, limited)
+	}
+	if n := count(t, `select count(*) from sessions where user_id = $1`, userID); n != 3 {
+		t.Fatalf("expected 3 session rows, got %d", n)
+	}
+	live, err := s.ListLiveSessions(context.Background(), userID, "")
+	if err != nil || len(live) != 3 {
+		t.Fatalf("expected 3 live sessions, got %d err=%v", len(live), err)
+	}
+}
+
+func TestStopSession_Idempotent(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	userID := createUser(t, march)
+	sess, _, err := s.StartOrGetSession(ctx, store.StartInput{UserID: userID, Region: "us-east-1", RequestedBy: "integration", IdempotencyKey: uuid.New(), RequestHash: "hash"})
+	if err != nil {
+		t.Fatalf("StartOrGetSession: %v", err)
+	}
+	instanceID := "i-" + uuid.NewString()[:17]
+	if _, err := s.ActivateProvisionedSession(ctx, store.ActivateProvisionedSessionInput{
+		UserID: userID, SessionID: sess.ID, Region: "us-east-1", AWSInstanceID: instanceID, AMIID: "ami-1", InstanceType: "t4g.small",
+		PublicIP: "203.0.113.10", SRTPorts: []int{9000}, WSURL: "wss://relay.test/ws", PairToken: uuid.NewString()[:8], RelayWSToken: "ws-token",
+	}); err != nil {
+		t.Fatalf("ActivateProvisionedSession: %v", err)
+	}
+
+	first, err := s.StopSession(ctx, store.StopSessionInput{UserID: userID, SessionID: sess.ID, Reason: model.StopReasonUser})
+	if err != nil {
+		t.Fatalf("StopSession: %v", err)
+	}
+	second, err := s.StopSession(ctx, store.StopSessionInput{UserID: userID, SessionID: sess.ID, Reason: model.StopReasonAdmin})
+	if err != nil {
+		t.Fatalf("repeated StopSession: %v", err)
+	}
+	if first.Status != model.SessionStopping || second.Status != model.SessionStopping {
+		t.Fatalf("expected stopping twice, got %s then %s", first.Status, second.Status)
+	}
+	if first.StoppedAt == nil || second.StoppedAt == nil || !first.StoppedAt.Equal(*second.StoppedAt) {
+		t.Fatalf("expected the first stop time kept, got %v then %v", first.StoppedAt, second.StoppedAt)
+	}
+	if n := count(t, `select count(*) from sessions where id = $1 and stop_reason = 'user'`, sess.ID); n != 1 {
+		t.Fatalf("expected the first stop's reason kept, got %d", n)
+	}
+	if n := count(t, `select count(*) from relay_terminations where session_id = $1 and aws_instance_id = $2`, sess.ID, instanceID); n != 1 {
+		t.Fatalf("expected one queued termination, got %d", n)
+	}
+	if n := count(t, `select count(*) from relay_instances where aws_instance_id = $1 and state = 'terminating'`, instanceID); n != 1 {
+		t.Fatalf("expected the relay marked terminating, got %d", n)
+	}
+
+	if _, _, err := s.StartOrGetSession(ctx, store.StartInput{UserID: userID, Region: "us-east-1", RequestedBy: "integration", IdempotencyKey: uuid.New(), RequestHash: "hash"}); !errors.Is(err, store.ErrSessionStopping) {
+		t.Fatalf("expected a start during stopping to be refused, got %v", err)
+	}
+
+	// A session without a relay stops outright.
+	other := createUser(t, march)
+	bare, _, err := s.StartOrGetSession(ctx, store.StartInput{UserID: other, Region: "us-east-1", RequestedBy: "integration", IdempotencyKey: uuid.New(), RequestHash: "hash"})
+	if err != nil {
+		t.Fatalf("StartOrGetSession: %v", err)
+	}
+	for range 2 {
+		stopped, err := s.StopSession(ctx, store.StopSessionInput{UserID: other, SessionID: bare.ID, Reason: model.StopReasonUser})
+		if err != nil || stopped.Status != model.SessionStopped {
+			t.Fatalf("expected stopped, got %+v %v", stopped, err)
+		}
+	}
+	if n := count(t, `select count(*) from relay_terminations where session_id = $1`, bare.ID); n != 0 {
+		t.Fatalf("expected no termination for a session without a relay, got %d", n)
+	}
+}
+
+func TestStopSession_ConcurrentStopsQueueOneTermination(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	userID := createUser(t, march)
+	sess, _, err := s.StartOrGetSession(ctx, store.StartInput{UserID: userID, Region: "us-east-1", RequestedBy: "integration", IdempotencyKey: uuid.New(), RequestHash: "hash"})
+	if err != nil {
+		t.Fatalf("StartOrGetSession: %v", err)
+	}
+	instanceID := "i-" + uuid.NewString()[:17]
+	if _, err := s.ActivateProvisionedSession(ctx, store.ActivateProvisionedSessionInput{
+		UserID: userID, SessionID: sess.ID, Region: "us-east-1", AWSInstanceID: instanceID, AMIID: "ami-1", InstanceType: "t4g.small",
+		PublicIP: "203.0.113.10", SRTPorts: []int{9000}, WSURL: "wss://relay.test/ws", PairToken: uuid.NewString()[:8], RelayWSToken: "ws-token",
+	}); err != nil {
+		t.Fatalf("ActivateProvisionedSession: %v", err)
+	}
+
+	const stops = 8
+	type result struct {
+		sess *model.Session
+		err  error
+	}
+	results := make(chan result, stops)
+	var ready sync.WaitGroup
+	ready.Add(1)
+	for range stops {
+		go func() {
+			ready.Wait()
+			out, err := s.StopSession(context.Background(), store.StopSessionInput{UserID: userID, SessionID: sess.ID, Reason: model.StopReasonUser})
+			results <- result{out, err}
+		}()
+	}
+	ready.Done()
+
+	for range stops {
+		r := <-results
+		if r.err != nil {
+			t.Fatalf("StopSession: %v", r.err)
+		}
+		if r.sess.Status != model.SessionStopping {
+			t.Fatalf("expected every stop to report stopping, got %s", r.sess.Status)
+		}
+	}
+	if n := count(t, `select count(*) from relay_terminations where session_id = $1`, sess.ID); n != 1 {
+		t.Fatalf("expected one queued termination, got %d", n)
+	}
+}
+
+// queryCounter counts the statements sent to Postgres, including begin and
+// commit.
+type queryCounter struct {
+	n atomic.Int64
+}
+
+func (c *queryCounter) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	c.n.Add(1)
+	return ctx
+}
+
+func (c *queryCounter) TraceQueryEnd(context.Context, *pgx.Conn, pgx.TraceQueryEndData) {}
+
+func TestSessionReads_OneRoundTrip(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	sessionID := activeSession(t, s)
+	var userID string
+	if err := pool.QueryRow(ctx, `select user_id from sessions where id = $1`, sessionID).Scan(&userID); err != nil {
+		t.Fatalf("read user: %v", err)
+	}
+
+	cfg := pool.Config()
+	counter := &queryCounter{}
+	cfg.ConnConfig.Tracer = counter
+	traced, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatalf("traced pool: %v", err)
+	}
+	defer traced.Close()
+	ts := store.New(traced)
+
+	for name, read := range map[string]func() (*model.Session, error){
+		"GetSessionByID":   func() (*model.Session, error) { return ts.GetSessionByID(ctx, userID, sessionID) },
+		"GetActiveSession": func() (*model.Session, error) { return ts.GetActiveSession(ctx, userID) },
+	} {
+		counter.n.Store(0)
+		sess, err := read()
+		if err != nil || sess.ID != sessionID {
+			t.Fatalf("%s: got %+v %v", name, sess, err)
+		}
+		if n := counter.n.Load(); n != 1 {
+			t.Fatalf("%s: expected one statement, got %d", name, n)
+		}
+	}
+}
+
+func insertStoppedSession(t *testing.T, userID string, startedAt time.Time, durationSeconds int) string {
+	t.Helper()
+	id := "ses_" + uuid.NewString()
+	_, err := pool.Exec(context.Background(), `
+insert into sessions (id, user_id, status, region, started_at, stopped_at, duration_seconds)
+values ($1, $2, 'stopped', 'us-east-1', $3, $3 + make_interval(secs => $4), $4)`, id, userID, startedAt, durationSeconds)
+	if err != nil {
+		t.Fatalf("insert session: %v", err)
+	}
+	return id
+}
+
+func TestUpsertUsageRollups_AcrossCycleBoundary(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	userID := createUser(t, march)
+	insertStoppedSession(t, userID, march.AddDate(0, 0, -2), 1000)
+	inMarch := insertStoppedSession(t, userID, march.AddDate(0, 0, 9), 1200)
+
+	if _, err := s.UpsertUsageRollups(ctx); err != nil {
+		t.Fatalf("UpsertUsageRollups: %v", err)
+	}
+	if n := count(t, `select count(*) from usage_records where user_id = $1`, userID); n != 1 {
+		t.Fatalf("expected only the in-cycle session rolled up, got %d records", n)
+	}
+
+	// The cycle rolls over to April.
+	april := march.AddDate(0, 1, 0)
+	if _, err := pool.Exec(ctx, `update users set cycle_start_at = $2, cycle_end_at = $3 where id = $1`, userID, april, april.AddDate(0, 1, 0)); err != nil {
+		t.Fatalf("advance cycle: %v", err)
+	}
+	inApril := insertStoppedSession(t, userID, april.AddDate(0, 0, 1), 300)
+	if _, err := s.UpsertUsageRollups(ctx); err != nil {
+		t.Fatalf("UpsertUsageRollups: %v", err)
+	}
+	for id, want := range map[string]time.Time{inMarch: march, inApril: april} {
+		if n := count(t, `select count(*) from usage_records where session_id = $1 and cycle_start_at = $2`, id, want); n != 1 {
+			t.Fatalf("expected session %s billed to the cycle starting %s", id, want)
+		}
+	}
+
+	usage, err := s.GetUsageCurrent(ctx, userID, 3600)
+	if err != nil {
+		t.Fatalf("GetUsageCurrent: %v", err)
+	}
+	if !usage.CycleStart.Equal(april) || usage.ConsumedSeconds != 300 || usage.IncludedSeconds != 36000 {
+		t.Fatalf("expected only April usage, got %+v", usage)
+	}
+}
+
+func TestIdempotencyRecords_Expire(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	userID := createUser(t, march)
+	key := uuid.New()
+	start := func(k uuid.UUID, hash string) (*model.Session, bool) {
+		t.Helper()
+		sess, created, err := s.StartOrGetSession(ctx, store.StartInput{UserID: userID, Region: "us-east-1", RequestedBy: "integration", IdempotencyKey: k, RequestHash: hash})
+		if err != nil {
+			t.Fatalf("StartOrGetSession: %v", err)
+		}
+		return sess, created
+	}
+	expire := func(k uuid.UUID) {
+		t.Helper()
+		if _, err := pool.Exec(ctx, `update idempotency_records set expires_at = now() - interval '1 second' where user_id = $1 and idempotency_key = $2`, userID, k); err != nil {
+			t.Fatalf("expire record: %v", err)
+		}
+	}
+
+	first, _ := start(key, "hash-a")
+	if _, err := s.StopSession(ctx, store.StopSessionInput{UserID: userID, SessionID: first.ID, Reason: model.StopReasonUser}); err != nil {
+		t.Fatalf("StopSession: %v", err)
+	}
+	if _, _, err := s.StartOrGetSession(ctx, store.StartInput{UserID: userID, Region: "us-east-1", IdempotencyKey: key, RequestHash: "hash-b"}); !errors.Is(err, store.ErrIdempotencyMismatch) {
+		t.Fatalf("expected a live key reused with another payload to mismatch, got %v", err)
+	}
+
+	// Once expired, the key is free for a new request, even before cleanup.
+	expire(key)
+	second, created := start(key, "hash-b")
+	if !created || second.ID == first.ID {
+		t.Fatalf("expected a new session for the expired key, got created=%t %s", created, second.ID)
+	}
+	if replay, created := start(key, "hash-b"); created || replay.ID != second.ID {
+		t.Fatalf("expected the re-recorded key to replay, got created=%t %s", created, replay.ID)
+	}
+
+	other := uuid.New()
+	start(other, "hash-c")
+	expire(key)
+	if err := s.CleanupExpiredIdempotencyRecords(ctx); err != nil {
+		t.Fatalf("CleanupExpiredIdempotencyRecords: %v", err)
+	}
+	if n := count(t, `select count(*) from idempotency_records where user_id = $1 and idempotency_key = $2`, userID, key); n != 0 {
+		t.Fatalf("expected the expired record deleted, got %d", n)
+	}
+	if n := count(t, `select count(*) from idempotency_records where user_id = $1 and idempotency_key = $2`, userID, other); n != 1 {
+		t.Fatalf("expected the live record kept, got %d", n)
+	}
+}
+
+func TestIdempotencyRecords_CappedPerUser(t *testing.T) {
+	newStore(t)
+	const maxPerUser = 5
+	s := store.New(pool, store.WithIdempotencyMaxPerUser(maxPerUser))
+	ctx := context.Background()
+	userID := createUser(t, march)
+
+	// Every start after the first returns the live session and records
+	// its key.
+	keys := make([]uuid.UUID, maxPerUser+5)
+	var first *model.Session
+	for i := range keys {
+		keys[i] = uuid.New()
+		sess, _, err := s.StartOrGetSession(ctx, store.StartInput{UserID: userID, Region: "us-east-1", RequestedBy: "integration", IdempotencyKey: keys[i], RequestHash: "hash"})
+		if err != nil {
+			t.Fatalf("StartOrGetSession %d: %v", i, err)
+		}
+		if first == nil {
+			first = sess
+		}
+	}
+	if n := count(t, `select count(*) from idempotency_records where user_id = $1`, userID); n != maxPerUser {
+		t.Fatalf("expected %d records kept, got %d", maxPerUser, n)
+	}
+	for _, k := range keys[:5] {
+		if n := count(t, `select count(*) from idempotency_records where user_id = $1 and idempotency_key = $2`, userID, k); n != 0 {
+			t.Fatalf("expected the record of old key %s pruned", k)
+		}
+	}
+
+	newest := keys[len(keys)-1]
+	replay, created, err := s.StartOrGetSession(ctx, store.StartInput{UserID: userID, Region: "us-east-1", IdempotencyKey: newest, RequestHash: "hash"})
+	if err != nil || created || replay.ID != first.ID {
+		t.Fatalf("expected the newest key to replay %s, got created=%t sess=%v err=%v", first.ID, created, replay, err)
+	}
+	if _, _, err := s.StartOrGetSession(ctx, store.StartInput{UserID: userID, Region: "us-east-1", IdempotencyKey: newest, RequestHash: "hash-b"}); !errors.Is(err, store.ErrIdempotencyMismatch) {
+		t.Fatalf("expected the newest key reused with another payload to mismatch, got %v", err)
+	}
+	if _, _, err := s.StartOrGetSession(ctx, store.StartInput{UserID: userID, Region: "us-east-1", IdempotencyKey: keys[0], RequestHash: "hash-b"}); err != nil {
+		t.Fatalf("expected the pruned oldest key to be free, got %v", err)
+	}
+}
+
+func TestUpsertRelayManifest_PruneRemovesMissingRegions(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	if _, err := pool.Exec(ctx, `delete from relay_manifests`); err != nil {
+		t.Fatalf("clear manifest: %v", err)
+	}
+	entry := func(region, ami string) model.RelayManifestEntry {
+		return model.RelayManifestEntry{Region: region, AMIID: ami, DefaultInstanceType: "t4g.small", Available: true, Provider: "aws"}
+	}
+	regions := func() map[string]string {
+		t.Helper()
+		entries, err := s.ListRelayManifest(ctx)
+		if err != nil {
+			t.Fatalf("ListRelayManifest: %v", err)
+		}
+		out := make(map[string]string, len(entries))
+		for _, e := range entries {
+			out[e.Region] = e.AMIID
+		}
+		return out
+	}
+
+	if err := s.UpsertRelayManifest(ctx, []model.RelayManifestEntry{entry("us-east-1", "ami-1"), entry("eu-west-1", "ami-2")}, false); err != nil {
+		t.Fatalf("UpsertRelayManifest: %v", err)
+	}
+	// Without prune a region left out is kept and the rest are updated.
+	if err := s.UpsertRelayManifest(ctx, []model.RelayManifestEntry{entry("us-east-1", "ami-3")}, false); err != nil {
+		t.Fatalf("UpsertRelayManifest: %v", err)
+	}
+	if got := regions(); len(got) != 2 || got["us-east-1"] != "ami-3" || got["eu-west-1"] != "ami-2" {
+		t.Fatalf("expected both regions with us-east-1 updated, got %v", got)
+	}
+
+	if err := s.UpsertRelayManifest(ctx, []model.RelayManifestEntry{entry("us-east-1", "ami-4"), entry("ap-south-1", "ami-5")}, true); err != nil {
+		t.Fatalf("UpsertRelayManifest: %v", err)
+	}
+	if got := regions(); len(got) != 2 || got["us-east-1"] != "ami-4" || got["ap-south-1"] != "ami-5" {
+		t.Fatalf("expected eu-west-1 pruned, got %v", got)
+	}
+
+	// An empty input never wipes the manifest.
+	if err := s.UpsertRelayManifest(ctx, nil, true); err != nil {
+		t.Fatalf("UpsertRelayManifest: %v", err)
+	}
+	if got := regions(); len(got) != 2 {
+		t.Fatalf("expected the manifest kept, got %v", got)
+	}
+}
+
+func TestUpsertRelayManifest_KeepsRegionSwitch(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	if _, err := pool.Exec(ctx, `delete from relay_manifests`); err != nil {
+		t.Fatalf("clear manifest: %v", err)
+	}
+	entry := model.RelayManifestEntry{Region: "us-east-1", AMIID: "ami-1", DefaultInstanceType: "t4g.small", Available: true, Provider: "aws"}
+	if err := s.UpsertRelayManifest(ctx, []model.RelayManifestEntry{entry}, true); err != nil {
+		t.Fatalf("UpsertRelayManifest: %v", err)
+	}
+	enabled := false
+	if _, err := s.UpdateRelayManifestEntry(ctx, store.RelayManifestUpdate{Region: "us-east-1", Enabled: &enabled}); err != nil {
+		t.Fatalf("UpdateRelayManifestEntry: %v", err)
+	}
+	// A config reload rewrites the entry but not the operators' switch.
+	entry.AMIID = "ami-2"
+	if err := s.UpsertRelayManifest(ctx, []model.RelayManifestEntry{entry}, true); err != nil {
+		t.Fatalf("UpsertRelayManifest: %v", err)
+	}
+	entries, err := s.ListRelayManifest(ctx)
+	if err != nil {
+		t.Fatalf("ListRelayManifest: %v", err)
+	}
+	if len(entries) != 1 || entries[0].AMIID != "ami-2" || entries[0].Enabled {
+		t.Fatalf("expected the rewritten entry still disabled, got %+v", entries)
+	}
+}
+
+func TestUpsertUsageRollups_WritesOnlyChangedSessions(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	userID := createUser(t, march)
+	id := insertStoppedSession(t, userID, march.AddDate(0, 0, 3), 600)
+
+	if _, err := s.UpsertUsageRollups(ctx); err != nil {
+		t.Fatalf("UpsertUsageRollups: %v", err)
+	}
+	if n, err := s.UpsertUsageRollups(ctx); err != nil || n != 0 {
+		t.Fatalf("expected a rerun to write nothing, got %d err=%v", n, err)
+	}
+
+	if _, err := pool.Exec(ctx, `update sessions set reconciled_seconds = 660, updated_at = now() where id = $1`, id); err != nil {
+		t.Fatalf("reconcile session: %v", err)
+	}
+	if n, err := s.UpsertUsageRollups(ctx); err != nil || n != 1 {
+		t.Fatalf("expected the changed session rewritten, got %d err=%v", n, err)
+	}
+	if n := count(t, `select count(*) from usage_records where session_id = $1 and billable_seconds = 660`, id); n != 1 {
+		t.Fatal("expected the reconciled seconds billed")
+	}
+}
+
+func TestUpsertUsageRollups_SkipsCanarySessions(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	if n := count(t, `select count(*) from users where id = $1 and plan_tier = 'canary'`, model.CanaryUserID); n != 1 {
+		t.Fatal("expected the canary user seeded on the canary plan")
+	}
+	userID := createUser(t, march)
+	id := insertStoppedSession(t, userID, march.AddDate(0, 0, 4), 300)
+	if _, err := pool.Exec(ctx, `update sessions set canary = true, billable = false, updated_at = now() where id = $1`, id); err != nil {
+		t.Fatalf("flag canary: %v", err)
+	}
+
+	if _, err := s.UpsertUsageRollups(ctx); err != nil {
+		t.Fatalf("UpsertUsageRollups: %v", err)
+	}
+	if n := count(t, `select count(*) from usage_records where session_id = $1`, id); n != 0 {
+		t.Fatalf("expected no usage record for a canary session, got %d", n)
+	}
+}
+
+func TestUpsertUsageRollups_NonBillableSessionNeverCreatesUsage(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	userID := createUser(t, time.Now().UTC().AddDate(0, 0, -1))
+	sess, _, err := s.StartOrGetSession(ctx, store.StartInput{
+		UserID: userID, Region: "us-east-1", RequestedBy: "integration", IdempotencyKey: uuid.New(), RequestHash: "hash", NonBillable: true,
+	})
+	if err != nil {
+		t.Fatalf("StartOrGetSession: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `update sessions set status = 'active', started_at = now() - interval '10 minutes' where id = $1`, sess.ID); err != nil {
+		t.Fatalf("activate session: %v", err)
+	}
+	if _, err := s.RollupLiveSessionDurations(ctx); err != nil {
+		t.Fatalf("RollupLiveSessionDurations: %v", err)
+	}
+	if n := count(t, `select count(*) from sessions where id = $1 and duration_seconds >= 600 and not billable`, sess.ID); n != 1 {
+		t.Fatal("expected the non-billable session's duration still tracked")
+	}
+	usage, err := s.GetUsageCurrent(ctx, userID, 3600)
+	if err != nil {
+		t.Fatalf("GetUsageCurrent: %v", err)
+	}
+	if usage.ConsumedSeconds != 0 {
+		t.Fatalf("expected no live usage from a non-billable session, got %d", usage.ConsumedSeconds)
+	}
+
+	if _, err := s.StopSession(ctx, store.StopSessionInput{UserID: userID, SessionID: sess.ID, Reason: model.StopReasonUser}); err != nil {
+		t.Fatalf("StopSession: %v", err)
+	}
+	if _, err := s.UpsertUsageRollups(ctx); err != nil {
+		t.Fatalf("UpsertUsageRollups: %v", err)
+	}
+	if n := count(t, `select count(*) from usage_records where session_id = $1`, sess.ID); n != 0 {
+		t.Fatalf("expected no usage record for a non-billable session, got %d", n)
+	}
+}
+
+func TestSessionCosts_UseRelayPriceThenDefault(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	if err := s.ReplaceRelayPrices(ctx, []model.RelayPrice{
+		{HourlyUSD: 0.5},
+		{InstanceType: "t4g.small", HourlyUSD: 2},
+		{Region: "us-east-1", InstanceType: "t4g.small", HourlyUSD: 3.6},
+	}); err != nil {
+		t.Fatalf("ReplaceRelayPrices: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := s.ReplaceRelayPrices(context.Background(), nil); err != nil {
+			t.Errorf("clear relay prices: %v", err)
+		}
+	})
+
+	priced := activeSession(t, s)
+	unpriced := activeSession(t, s)
+	if _, err := pool.Exec(ctx, `update sessions set started_at = now() - interval '1 hour' where id in ($1, $2)`, priced, unpriced); err != nil {
+		t.Fatalf("backdate sessions: %v", err)
+	}
+	if _, err := pool.Exec(ctx, `update relay_instances set instance_type = 'c7g.large' where session_id = $1`, unpriced); err != nil {
+		t.Fatalf("retype relay: %v", err)
+	}
+
+	if _, err := s.RollupLiveSessionCosts(ctx); err != nil {
+		t.Fatalf("RollupLiveSessionCosts: %v", err)
+	}
+	if n := count(t, `select count(*) from sessions where id = $1 and hourly_price_usd = 3.6 and estimated_cost_usd between 3.6 and 3.7`, priced); n != 1 {
+		t.Fatal("expected the live session costed at its region's price")
+	}
+	if n := count(t, `select count(*) from sessions where id = $1 and hourly_price_usd = 0.5`, unpriced); n != 1 {
+		t.Fatal("expected an unpriced type costed at the default")
+	}
+
+	var userID string
+	if err := pool.QueryRow(ctx, `select user_id from sessions where id = $1`, priced).Scan(&userID); err != nil {
+		t.Fatalf("read user: %v", err)
+	}
+	if _, err := s.StopSession(ctx, store.StopSessionInput{UserID: userID, SessionID: priced, Reason: model.StopReasonUser}); err != nil {
+		t.Fatalf("StopSession: %v", err)
+	}
+	if n := count(t, `select count(*) from sessions where id = $1 and estimated_cost_usd = round(3.6 * extract(epoch from (stopped_at - started_at)) / 3600, 6)`, priced); n != 1 {
+		t.Fatal("expected the stop to cost the session up to stopped_at")
+	}
+	cost, err := s.CostOverview(ctx, 500)
+	if err != nil {
+		t.Fatalf("CostOverview: %v", err)
+	}
+	if cost.LiveSessions < 1 || cost.BurnUSDPerHour < 0.5 {
+		t.Fatalf("expected the live unpriced session in the burn rate, got %+v", cost)
+	}
+}
+
+func TestAdvanceProvisioningPhase_IsMonotonic(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	userID := createUser(t, march)
+	sess, _, err := s.StartOrGetSession(ctx, store.StartInput{UserID: userID, Region: "us-east-1", RequestedBy: "integration", IdempotencyKey: uuid.New(), RequestHash: "hash"})
+	if err != nil {
+		t.Fatalf("StartOrGetSession: %v", err)
+	}
+	if sess.ProvisioningPhase != model.ProvisioningRequested {
+		t.Fatalf("expected a new session requested, got %q", sess.ProvisioningPhase)
+	}
+	// Going back, or repeating a phase, changes nothing.
+	for _, phase := range []string{model.ProvisioningWaitingBoot, model.ProvisioningLaunching, model.ProvisioningWaitingBoot} {
+		if err := s.AdvanceProvisioningPhase(ctx, userID, sess.ID, phase); err != nil {
+			t.Fatalf("AdvanceProvisioningPhase(%s): %v", phase, err)
+		}
+	}
+	if _, err := s.ActivateProvisionedSession(ctx, store.ActivateProvisionedSessionInput{
+		UserID: userID, SessionID: sess.ID, Region: "us-east-1", AWSInstanceID: "i-" + uuid.NewString()[:17], AMIID: "ami-1", InstanceType: "t4g.small",
+		PublicIP: "203.0.113.10", SRTPorts: []int{9000}, WSURL: "wss://relay.test/ws", PairToken: uuid.NewString()[:8], RelayWSToken: "ws-token",
+	}); err != nil {
+		t.Fatalf("ActivateProvisionedSession: %v", err)
+	}
+	// An active session is past provisioning.
+	if err := s.AdvanceProvisioningPhase(ctx, userID, sess.ID, model.ProvisioningLaunching); err != nil {
+		t.Fatalf("AdvanceProvisioningPhase: %v", err)
+	}
+
+	active, err := s.GetActiveSession(ctx, userID)
+	if err != nil || active.ProvisioningPhase != model.ProvisioningReady {
+		t.Fatalf("expected the active session ready, got %+v %v", active, err)
+	}
+	events, err := s.ListSessionEvents(ctx, userID, 0, 10)
+	if err != nil {
+		t.Fatalf("ListSessionEvents: %v", err)
+	}
+	var phases []string
+	for _, e := range events {
+		if e.Type != "provisioning_phase" {
+			continue
+		}
+		var payload struct {
+			Phase string `json:"phase"`
+		}
+		if err := json.Unmarshal(e.Payload, &payload); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		phases = append(phases, payload.Phase)
+	}
+	if want := []string{model.ProvisioningWaitingBoot, model.ProvisioningReady}; !slices.Equal(phases, want) {
+		t.Fatalf("expected phase events %v, got %v", want, phases)
+	}
+}
@@ -0,0 +1,78 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+func TestUserDataExport_StreamsOwnRowsAndLimitsRepeats(t *testing.T) {
+	s := newStore(t)
+	ctx := context.Background()
+	userID := createUser(t, march)
+	other := createUser(t, march)
+	first := insertStoppedSession(t, userID, march.AddDate(0, 0, 1), 600)
+	second := insertStoppedSession(t, userID, march.AddDate(0, 0, 2), 900)
+	insertStoppedSession(t, other, march.AddDate(0, 0, 1), 300)
+	if _, err := s.UpsertUsageRollups(ctx); err != nil {
+		t.Fatalf("UpsertUsageRollups: %v", err)
+	}
+	if err := s.RecordSessionEvent(ctx, second, userID, "credentials_fetched", map[string]any{"session_id": second}); err != nil {
+		t.Fatalf("RecordSessionEvent: %v", err)
+	}
+
+	if _, err := s.RecordUserDataExport(ctx, userID, userID, time.Hour); err != nil {
+		t.Fatalf("RecordUserDataExport: %v", err)
+	}
+	sessions, err := s.ExportUserSessions(ctx, userID)
+	if err != nil {
+		t.Fatalf("ExportUserSessions: %v", err)
+	}
+	var ids []string
+	for sessions.Next() {
+		ids = append(ids, sessions.Row().ID)
+	}
+	sessions.Close()
+	if err := sessions.Err(); err != nil || len(ids) != 2 || ids[0] != first || ids[1] != second {
+		t.Fatalf("expected the user's two sessions oldest first, got %v err=%v", ids, err)
+	}
+	usage, err := s.ExportUserUsage(ctx, userID)
+	if err != nil {
+		t.Fatalf("ExportUserUsage: %v", err)
+	}
+	billable := 0
+	for usage.Next() {
+		billable += usage.Row().BillableSeconds
+	}
+	usage.Close()
+	if billable != 1500 {
+		t.Fatalf("expected 1500 billable seconds, got %d", billable)
+	}
+	events, err := s.ExportUserSessionEvents(ctx, userID)
+	if err != nil {
+		t.Fatalf("ExportUserSessionEvents: %v", err)
+	}
+	n := 0
+	for events.Next() {
+		n++
+	}
+	events.Close()
+	if n != 1 {
+		t.Fatalf("expected one session event, got %d", n)
+	}
+
+	_, err = s.RecordUserDataExport(ctx, userID, userID, time.Hour)
+	var limited *store.ExportLimitedError
+	if !errors.As(err, &limited) || limited.RetryAfter <= 59*time.Minute {
+		t.Fatalf("expected a second export within the hour limited, got %v", err)
+	}
+	if _, err := s.RecordUserDataExport(ctx, userID, "usr_admin", 0); err != nil {
+		t.Fatalf("expected an admin export allowed, got %v", err)
+	}
+	if _, err := s.RecordUserDataExport(ctx, "usr_missing", "usr_admin", 0); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an unknown user, got %v", err)
+	}
+}
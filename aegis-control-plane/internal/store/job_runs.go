@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+const jobRunColumns = `id, job, requested_by, status, coalesce(error, ''), requested_at, started_at, finished_at`
+
+func scanJobRun(row pgx.Row) (*model.JobRun, error) {
+	var r model.JobRun
+	if err := row.Scan(&r.ID, &r.Job, &r.RequestedBy, &r.Status, &r.Error, &r.RequestedAt, &r.StartedAt, &r.FinishedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+// RequestJobRun queues a run of job for the jobs worker.
+func (s *Store) RequestJobRun(ctx context.Context, job, requestedBy string) (_ *model.JobRun, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	q := `
+insert into job_run_requests (job, requested_by, requested_at)
+values ($1, $2, now())
+returning ` + jobRunColumns
+	return scanJobRun(s.db.QueryRow(ctx, q, job, requestedBy))
+}
+
+func (s *Store) GetJobRun(ctx context.Context, id int64) (_ *model.JobRun, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	q := `select ` + jobRunColumns + ` from job_run_requests where id = $1`
+	return scanJobRun(s.db.QueryRow(ctx, q, id))
+}
+
+// ClaimJobRuns marks up to limit pending requests running and returns them,
+// oldest first.
+func (s *Store) ClaimJobRuns(ctx context.Context, limit int) (_ []model.JobRun, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	q := `
+with due as (
+  select id
+  from job_run_requests
+  where status = 'pending'
+  order by requested_at asc
+  limit $1
+  for update skip locked
+)
+update job_run_requests j
+set status = 'running', started_at = now()
+from due
+where j.id = due.id
+returning ` + jobRunColumns
+	rows, err := s.db.Query(ctx, q, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]model.JobRun, 0)
+	for rows.Next() {
+		r, err := scanJobRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *r)
+	}
+	return out, rows.Err()
+}
+
+// FinishJobRun records the outcome of a claimed run; an empty runErr means
+// it succeeded.
+func (s *Store) FinishJobRun(ctx context.Context, id int64, runErr string) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	_, err = s.db.Exec(ctx, `
+update job_run_requests
+set status = case when $2 = '' then 'succeeded' else 'failed' end, error = nullif($2, ''), finished_at = now()
+where id = $1`, id, runErr)
+	return err
+}
@@ -0,0 +1,35 @@
+package store
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+)
+
+func TestClaimJobRuns_MarksRunning(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	startedAt := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta("set status = 'running', started_at = now()")).
+		WithArgs(5).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "job", "requested_by", "status", "error", "requested_at", "started_at", "finished_at"}).
+			AddRow(int64(3), "billing_export", "usr_admin", "running", "", startedAt, &startedAt, (*time.Time)(nil)))
+
+	got, err := New(mock).ClaimJobRuns(context.Background(), 5)
+	if err != nil {
+		t.Fatalf("ClaimJobRuns: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != 3 || got[0].Job != "billing_export" || got[0].StartedAt == nil || got[0].FinishedAt != nil {
+		t.Fatalf("unexpected runs %+v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PoolOptions struct {
+	MaxConns         int
+	MinConns         int
+	MaxConnLifetime  time.Duration
+	StatementTimeout time.Duration
+	// StatementCacheCapacity is how many prepared statements each
+	// connection keeps. pgx prepares a statement on its first use on a
+	// connection and reuses it after, so the hot session reads are planned
+	// once per connection rather than per call.
+	StatementCacheCapacity int
+	// SlowQuery is the duration past which a statement is logged; zero
+	// disables the log. Query metrics are recorded regardless.
+	SlowQuery time.Duration
+}
+
+// Connect opens and pings a pgx pool sized by opts. Both cmd/api and cmd/jobs
+// use it so the pool setup stays in one place.
+func Connect(ctx context.Context, databaseURL string, opts PoolOptions) (*pgxpool.Pool, error) {
+	cfg, err := poolConfig(databaseURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("connect db: %w", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("ping db: %w", err)
+	}
+	return pool, nil
+}
+
+func poolConfig(databaseURL string, opts PoolOptions) (*pgxpool.Config, error) {
+	cfg, err := pgxpool.ParseConfig(databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse database url: %w", err)
+	}
+	if opts.MaxConns > 0 {
+		cfg.MaxConns = int32(opts.MaxConns)
+	}
+	if opts.MinConns > 0 {
+		cfg.MinConns = int32(opts.MinConns)
+	}
+	if opts.MaxConnLifetime > 0 {
+		cfg.MaxConnLifetime = opts.MaxConnLifetime
+	}
+	if opts.StatementTimeout > 0 {
+		cfg.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(opts.StatementTimeout.Milliseconds(), 10)
+	}
+	if opts.StatementCacheCapacity > 0 {
+		cfg.ConnConfig.StatementCacheCapacity = opts.StatementCacheCapacity
+	}
+	cfg.ConnConfig.Tracer = NewQueryTracer(opts.SlowQuery)
+	return cfg, nil
+}
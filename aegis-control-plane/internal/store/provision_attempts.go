@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+// RecordProvisionAttempt stores one launch attempt and returns its ID.
+func (s *Store) RecordProvisionAttempt(ctx context.Context, a model.ProvisionAttempt) (_ int64, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	var id int64
+	err = s.db.QueryRow(ctx, `
+insert into provision_attempts (
+  session_id, provider, region, instance_type, started_at, finished_at,
+  outcome, aws_error_code, error, instance_id
+)
+values ($1, $2, $3, $4, $5, $6, $7, nullif($8, ''), nullif($9, ''), nullif($10, ''))
+returning id`,
+		a.SessionID, a.Provider, a.Region, a.InstanceType, a.StartedAt, a.FinishedAt,
+		a.Outcome, a.AWSErrorCode, a.Error, a.InstanceID,
+	).Scan(&id)
+	return id, err
+}
+
+// SetProvisionAttemptCompensation records how a start that failed after the
+// attempt was cleaned up.
+func (s *Store) SetProvisionAttemptCompensation(ctx context.Context, id int64, compensation string) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	_, err = s.db.Exec(ctx, `update provision_attempts set compensation = $2 where id = $1`, id, compensation)
+	return err
+}
+
+// ListProvisionAttempts returns a session's attempts, oldest first.
+func (s *Store) ListProvisionAttempts(ctx context.Context, sessionID string) (_ []model.ProvisionAttempt, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	rows, err := s.db.Query(ctx, `
+select id, session_id, provider, region, instance_type, started_at, finished_at, outcome,
+       coalesce(aws_error_code, ''), coalesce(error, ''), coalesce(instance_id, ''), coalesce(compensation, '')
+from provision_attempts
+where session_id = $1
+order by started_at asc, id asc`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]model.ProvisionAttempt, 0)
+	for rows.Next() {
+		var a model.ProvisionAttempt
+		if err := rows.Scan(&a.ID, &a.SessionID, &a.Provider, &a.Region, &a.InstanceType, &a.StartedAt, &a.FinishedAt, &a.Outcome,
+			&a.AWSErrorCode, &a.Error, &a.InstanceID, &a.Compensation); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// ProvisionStats counts the launch attempts started since since and how many
+// succeeded, with the 95th percentile of their durations.
+func (s *Store) ProvisionStats(ctx context.Context, since time.Time) (_ model.ProvisionStats, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	var (
+		st    model.ProvisionStats
+		p95MS float64
+	)
+	err = s.db.QueryRow(ctx, `
+select count(*),
+       count(*) filter (where outcome = 'succeeded'),
+       coalesce(percentile_cont(0.95) within group (order by extract(epoch from finished_at - started_at) * 1000), 0)
+from provision_attempts
+where started_at >= $1`, since).Scan(&st.Attempts, &st.Succeeded, &p95MS)
+	st.P95Latency = time.Duration(p95MS * float64(time.Millisecond))
+	return st, err
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+func TestRecordProvisionAttempt_StoresEmptyFieldsAsNull(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	started := time.Now().Add(-time.Second)
+	finished := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta("insert into provision_attempts")).
+		WithArgs("ses_1", "aws", "us-east-1", "t4g.small", started, finished, model.ProvisionAttemptFailed, "InsufficientInstanceCapacity", "no capacity", "").
+		WillReturnRows(pgxmock.NewRows([]string{"id"}).AddRow(int64(7)))
+
+	id, err := New(mock).RecordProvisionAttempt(context.Background(), model.ProvisionAttempt{
+		SessionID:    "ses_1",
+		Provider:     "aws",
+		Region:       "us-east-1",
+		InstanceType: "t4g.small",
+		StartedAt:    started,
+		FinishedAt:   finished,
+		Outcome:      model.ProvisionAttemptFailed,
+		AWSErrorCode: "InsufficientInstanceCapacity",
+		Error:        "no capacity",
+	})
+	if err != nil || id != 7 {
+		t.Fatalf("expected id 7, got %d err=%v", id, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestListProvisionAttempts_OldestFirst(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	t0 := time.Now().Add(-time.Minute)
+	cols := []string{"id", "session_id", "provider", "region", "instance_type", "started_at", "finished_at", "outcome", "aws_error_code", "error", "instance_id", "compensation"}
+	mock.ExpectQuery(regexp.QuoteMeta("order by started_at asc, id asc")).
+		WithArgs("ses_1").
+		WillReturnRows(pgxmock.NewRows(cols).
+			AddRow(int64(1), "ses_1", "aws", "us-east-1", "t4g.small", t0, t0.Add(time.Second), "failed", "InsufficientInstanceCapacity", "no capacity", "", "").
+			AddRow(int64(2), "ses_1", "aws", "us-west-2", "t4g.small", t0.Add(time.Second), t0.Add(30*time.Second), "succeeded", "", "", "i-1", "termination_queued"))
+
+	got, err := New(mock).ListProvisionAttempts(context.Background(), "ses_1")
+	if err != nil {
+		t.Fatalf("ListProvisionAttempts: %v", err)
+	}
+	if len(got) != 2 || got[0].AWSErrorCode != "InsufficientInstanceCapacity" || got[1].InstanceID != "i-1" || got[1].Compensation != model.CompensationTerminationQueued {
+		t.Fatalf("unexpected attempts %+v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestProvisionStats_ConvertsP95ToDuration(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	since := time.Now().Add(-time.Hour)
+	mock.ExpectQuery(regexp.QuoteMeta("percentile_cont(0.95)")).
+		WithArgs(since).
+		WillReturnRows(pgxmock.NewRows([]string{"count", "succeeded", "p95"}).AddRow(20, 19, 1250.5))
+
+	st, err := New(mock).ProvisionStats(context.Background(), since)
+	if err != nil {
+		t.Fatalf("ProvisionStats: %v", err)
+	}
+	if st.Attempts != 20 || st.Succeeded != 19 || st.P95Latency != 1250500*time.Microsecond {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+// ClaimPooledRelay removes and returns the oldest available pool instance
+// matching the region and launch image, or nil when none is available.
+// Instances still referenced by an unfinished relay are skipped.
+func (s *Store) ClaimPooledRelay(ctx context.Context, region, amiID, instanceType string) (_ *model.PooledRelay, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	const q = `
+delete from relay_pool
+where aws_instance_id = (
+  select p.aws_instance_id
+  from relay_pool p
+  where p.region = $1 and p.ami_id = $2 and p.instance_type = $3 and p.state = 'available'
+    and not exists (
+      select 1 from relay_instances ri
+      where ri.aws_instance_id = p.aws_instance_id and ri.state <> 'terminated'
+    )
+  order by p.launched_at asc
+  limit 1
+  for update skip locked
+)
+returning aws_instance_id, region, ami_id, instance_type, state, launched_at`
+
+	var out model.PooledRelay
+	if err := s.db.QueryRow(ctx, q, region, amiID, instanceType).Scan(
+		&out.AWSInstanceID, &out.Region, &out.AMIID, &out.InstanceType, &out.State, &out.LaunchedAt,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &out, nil
+}
+
+// AddPooledRelay records an instance entering the pool.
+func (s *Store) AddPooledRelay(ctx context.Context, in model.PooledRelay) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	const q = `
+insert into relay_pool (aws_instance_id, region, ami_id, instance_type, state, launched_at, created_at, updated_at)
+values ($1, $2, $3, $4, $5, $6, now(), now())
+on conflict (aws_instance_id) do update
+set state = excluded.state, updated_at = now()`
+	_, err = s.db.Exec(ctx, q, in.AWSInstanceID, in.Region, in.AMIID, in.InstanceType, in.State, in.LaunchedAt)
+	return err
+}
+
+func (s *Store) ListPooledRelays(ctx context.Context) (_ []model.PooledRelay, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	const q = `
+select aws_instance_id, region, ami_id, instance_type, state, launched_at
+from relay_pool
+order by region, launched_at`
+	rows, err := s.db.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]model.PooledRelay, 0)
+	for rows.Next() {
+		var p model.PooledRelay
+		if err := rows.Scan(&p.AWSInstanceID, &p.Region, &p.AMIID, &p.InstanceType, &p.State, &p.LaunchedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CountPooledRelays counts a region's pool instances in any state.
+func (s *Store) CountPooledRelays(ctx context.Context, region string) (_ int, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	var n int
+	err = s.db.QueryRow(ctx, `select count(*) from relay_pool where region = $1`, region).Scan(&n)
+	return n, err
+}
+
+func (s *Store) MarkPooledRelayAvailable(ctx context.Context, awsInstanceID string) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	const q = `
+update relay_pool
+set state = 'available', updated_at = now()
+where aws_instance_id = $1 and state = 'warming'`
+	_, err = s.db.Exec(ctx, q, awsInstanceID)
+	return err
+}
+
+// RemovePooledRelay deletes a pool entry and reports whether it was still
+// there; false means a concurrent Provision claimed it first.
+func (s *Store) RemovePooledRelay(ctx context.Context, awsInstanceID string) (_ bool, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	tag, err := s.db.Exec(ctx, `delete from relay_pool where aws_instance_id = $1`, awsInstanceID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,40 @@
+package store
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+)
+
+func TestClaimPooledRelay_ReturnsClaimedInstance(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	launched := time.Now().Add(-time.Hour)
+	mock.ExpectQuery(regexp.QuoteMeta("delete from relay_pool")).
+		WithArgs("us-east-1", "ami-east", "t4g.small").
+		WillReturnRows(pgxmock.NewRows([]string{"aws_instance_id", "region", "ami_id", "instance_type", "state", "launched_at"}).
+			AddRow("i-pooled", "us-east-1", "ami-east", "t4g.small", "available", launched))
+	mock.ExpectQuery(regexp.QuoteMeta("delete from relay_pool")).
+		WithArgs("us-east-1", "ami-east", "t4g.small").
+		WillReturnRows(pgxmock.NewRows([]string{"aws_instance_id", "region", "ami_id", "instance_type", "state", "launched_at"}))
+
+	s := New(mock)
+	got, err := s.ClaimPooledRelay(context.Background(), "us-east-1", "ami-east", "t4g.small")
+	if err != nil || got == nil || got.AWSInstanceID != "i-pooled" {
+		t.Fatalf("expected i-pooled, got %+v err=%v", got, err)
+	}
+	got, err = s.ClaimPooledRelay(context.Background(), "us-east-1", "ami-east", "t4g.small")
+	if err != nil || got != nil {
+		t.Fatalf("expected nil on an empty pool, got %+v err=%v", got, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+// RelayInstanceFilter narrows ListRelayInstances; zero fields match all.
+// Relays are listed newest launch first; a non-zero BeforeLaunchedAt
+// continues a listing after the relay with that launch time and BeforeID.
+type RelayInstanceFilter struct {
+	Region       string
+	State        string
+	AMIID        string
+	LaunchedFrom time.Time
+	LaunchedTo   time.Time
+
+	BeforeLaunchedAt time.Time
+	BeforeID         string
+
+	Limit int
+}
+
+// ListRelayInstances returns relays matching f, newest launch first.
+func (s *Store) ListRelayInstances(ctx context.Context, f RelayInstanceFilter) (_ []model.RelayInstance, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	const q = `
+select id, coalesce(session_id, ''), provider, region, aws_instance_id, ami_id, instance_type, lifecycle, state,
+       coalesce(host(public_ip), ''), coalesce(agent_version, ''), launched_at, terminated_at, last_health_at
+from relay_instances
+where ($1 = '' or region = $1)
+  and ($2 = '' or state = $2)
+  and ($3 = '' or ami_id = $3)
+  and ($4::timestamptz is null or launched_at >= $4)
+  and ($5::timestamptz is null or launched_at < $5)
+  and ($6::timestamptz is null or (launched_at, id) < ($6, $7))
+order by launched_at desc, id desc
+limit $8`
+	rows, err := s.db.Query(ctx, q, f.Region, f.State, f.AMIID, nullTime(f.LaunchedFrom), nullTime(f.LaunchedTo), nullTime(f.BeforeLaunchedAt), f.BeforeID, f.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]model.RelayInstance, 0)
+	for rows.Next() {
+		var ri model.RelayInstance
+		if err := rows.Scan(&ri.ID, &ri.SessionID, &ri.Provider, &ri.Region, &ri.AWSInstanceID, &ri.AMIID, &ri.InstanceType, &ri.Lifecycle, &ri.State,
+			&ri.PublicIP, &ri.AgentVersion, &ri.LaunchedAt, &ri.TerminatedAt, &ri.LastHealthAt); err != nil {
+			return nil, err
+		}
+		out = append(out, ri)
+	}
+	return out, rows.Err()
+}
+
+// nullTime passes a zero t as NULL.
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// RollupRelayQuality writes relay_quality_daily for every UTC day that ended
+// since its previous run, tracked in job_watermarks, and returns how many
+// rows it wrote. Without a mark it starts lookbackDays back. A heartbeat
+// arriving more than gapThreshold after the relay's previous one ends a
+// health gap, counted on the day it ends.
+func (s *Store) RollupRelayQuality(ctx context.Context, gapThreshold time.Duration, lookbackDays int) (_ int, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Rollup)
+	defer done(&err)
+	const q = `
+with mark as (
+  select coalesce(
+    (select (high_water at time zone 'UTC')::date + 1 from job_watermarks where job = 'relay_quality_rollup'),
+    (now() at time zone 'UTC')::date - $2::integer
+  ) as first_day
+), days as (
+  select d::date as day,
+         d at time zone 'UTC' as day_start,
+         (d + interval '1 day') at time zone 'UTC' as day_end
+  from mark, generate_series(mark.first_day::timestamp, ((now() at time zone 'UTC')::date - 1)::timestamp, interval '1 day') d
+), relays as (
+  select d.day, d.day_start, d.day_end, ri.id, ri.region, ri.ami_id, ri.instance_type, ri.launched_at, ri.last_health_at
+  from days d
+  join relay_instances ri
+    on ri.session_id is not null
+   and ri.launched_at < d.day_end
+   and coalesce(ri.terminated_at, ri.terminate_requested_at, 'infinity') >= d.day_start
+), gaps as (
+  select r.day, r.id, count(*) as gaps, sum(extract(epoch from g.gap)) as gap_seconds
+  from relays r
+  join lateral (
+    select e.observed_at, e.observed_at - lag(e.observed_at) over (order by e.observed_at) as gap
+    from relay_health_events e
+    where e.relay_instance_id = r.id
+      and e.observed_at >= r.day_start - make_interval(secs => $1)
+      and e.observed_at < r.day_end
+  ) g on g.observed_at >= r.day_start and g.gap > make_interval(secs => $1)
+  group by r.day, r.id
+), daily as (
+  select r.day, r.region, r.ami_id, r.instance_type,
+         count(*) as relays,
+         count(g.id) as relays_with_gaps,
+         coalesce(sum(g.gaps), 0) as health_gaps,
+         coalesce(sum(g.gap_seconds), 0)::bigint as gap_seconds,
+         count(*) filter (where r.launched_at >= r.day_start and r.last_health_at is null) as never_healthy
+  from relays r
+  left join gaps g on g.day = r.day and g.id = r.id
+  group by r.day, r.region, r.ami_id, r.instance_type
+), upserted as (
+  insert into relay_quality_daily
+    (day, region, ami_id, instance_type, relays, relays_with_gaps, health_gaps, gap_seconds, never_healthy, updated_at)
+  select day, region, ami_id, instance_type, relays, relays_with_gaps, health_gaps, gap_seconds, never_healthy, now()
+  from daily
+  on conflict (day, region, ami_id, instance_type)
+  do update set
+    relays = excluded.relays,
+    relays_with_gaps = excluded.relays_with_gaps,
+    health_gaps = excluded.health_gaps,
+    gap_seconds = excluded.gap_seconds,
+    never_healthy = excluded.never_healthy,
+    updated_at = now()
+  returning 1
+), advanced as (
+  insert into job_watermarks (job, high_water, updated_at)
+  select 'relay_quality_rollup', max(day_start), now()
+  from days
+  having count(*) > 0
+  on conflict (job)
+  do update set high_water = greatest(job_watermarks.high_water, excluded.high_water), updated_at = now()
+)
+select count(*) from upserted`
+	var n int
+	err = s.db.QueryRow(ctx, q, gapThreshold.Seconds(), lookbackDays).Scan(&n)
+	return n, err
+}
+
+// RelayQualityFilter narrows ListRelayQuality to days in [From, To] and
+// optionally one region or AMI; zero fields match all.
+type RelayQualityFilter struct {
+	From   time.Time
+	To     time.Time
+	Region string
+	AMIID  string
+	Limit  int
+}
+
+// ListRelayQuality returns rolled-up days, newest first.
+func (s *Store) ListRelayQuality(ctx context.Context, f RelayQualityFilter) (_ []model.RelayQualityDay, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	const q = `
+select day, region, ami_id, instance_type, relays, relays_with_gaps, health_gaps, gap_seconds, never_healthy, updated_at
+from relay_quality_daily
+where ($1::date is null or day >= $1)
+  and ($2::date is null or day <= $2)
+  and ($3 = '' or region = $3)
+  and ($4 = '' or ami_id = $4)
+order by day desc, region asc, ami_id asc, instance_type asc
+limit $5`
+	rows, err := s.db.Query(ctx, q, nullTime(f.From), nullTime(f.To), f.Region, f.AMIID, f.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]model.RelayQualityDay, 0)
+	for rows.Next() {
+		var d model.RelayQualityDay
+		if err := rows.Scan(&d.Day, &d.Region, &d.AMIID, &d.InstanceType, &d.Relays, &d.RelaysWithGaps, &d.HealthGaps, &d.GapSeconds,
+			&d.NeverHealthy, &d.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
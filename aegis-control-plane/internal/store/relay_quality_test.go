@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+)
+
+func TestListRelayInstances_PassesZeroTimesAsNull(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	launched := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta("order by launched_at desc, id desc")).
+		WithArgs("us-east-1", "", "", &launched, (*time.Time)(nil), &launched, "rly_2", 51).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "session_id", "provider", "region", "aws_instance_id", "ami_id", "instance_type", "lifecycle", "state",
+			"public_ip", "agent_version", "launched_at", "terminated_at", "last_health_at"}).
+			AddRow("rly_1", "", "aws", "us-east-1", "i-1", "ami-0a1b2c3d", "t4g.small", "on_demand", "running", "203.0.113.10", "", launched, (*time.Time)(nil), (*time.Time)(nil)))
+
+	got, err := New(mock).ListRelayInstances(context.Background(), RelayInstanceFilter{
+		Region: "us-east-1", LaunchedFrom: launched, BeforeLaunchedAt: launched, BeforeID: "rly_2", Limit: 51,
+	})
+	if err != nil {
+		t.Fatalf("ListRelayInstances: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != "rly_1" || got[0].SessionID != "" || got[0].TerminatedAt != nil {
+		t.Fatalf("unexpected relays %+v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestRollupRelayQuality_AdvancesWatermark(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("insert into job_watermarks (job, high_water, updated_at)\n  select 'relay_quality_rollup'")).
+		WithArgs(float64(90), 7).
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(6))
+
+	n, err := New(mock).RollupRelayQuality(context.Background(), 90*time.Second, 7)
+	if err != nil {
+		t.Fatalf("RollupRelayQuality: %v", err)
+	}
+	if n != 6 {
+		t.Fatalf("expected 6 rows, got %d", n)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+// CreateUserInput is a user as billing provisions one. A nil cycle leaves
+// the user without a plan until their first usage read.
+type CreateUserInput struct {
+	ID              string
+	Email           string
+	DisplayName     string
+	PlanTier        string
+	CycleStart      *time.Time
+	CycleEnd        *time.Time
+	IncludedSeconds int
+	CreatedAt       time.Time
+}
+
+// CreateUser inserts a user. Only local seeding creates users here; in
+// production they come from the account service.
+func (s *Store) CreateUser(ctx context.Context, in CreateUserInput) error {
+	const q = `
+insert into users (id, email, display_name, plan_tier, cycle_start_at, cycle_end_at, included_seconds, created_at, updated_at)
+values ($1, $2, nullif($3, ''), $4, $5, $6, $7, $8, $8)`
+	_, err := s.db.Exec(ctx, q, in.ID, in.Email, in.DisplayName, in.PlanTier, in.CycleStart, in.CycleEnd, in.IncludedSeconds, in.CreatedAt)
+	return err
+}
+
+// ResetJobWatermarks makes the incremental rollups reread everything on
+// their next run, for history seeded behind their high-water marks.
+func (s *Store) ResetJobWatermarks(ctx context.Context) error {
+	_, err := s.db.Exec(ctx, `delete from job_watermarks`)
+	return err
+}
+
+// BackdateSession moves a session and everything recorded about it (relays,
+// heartbeats, events, terminations, idempotency records) back by age,
+// keeping their spacing. It lets seeding build history through the normal
+// write paths, which stamp now().
+func (s *Store) BackdateSession(ctx context.Context, sessionID string, age time.Duration) error {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	shifts := []string{`
+update sessions
+set started_at = started_at - $2::interval,
+    grace_started_at = grace_started_at - $2::interval,
+    stopped_at = stopped_at - $2::interval,
+    created_at = created_at - $2::interval,
+    updated_at = updated_at - $2::interval
+where id = $1`, `
+update relay_instances
+set launched_at = launched_at - $2::interval,
+    terminated_at = terminated_at - $2::interval,
+    last_health_at = last_health_at - $2::interval,
+    terminate_requested_at = terminate_requested_at - $2::interval,
+    created_at = created_at - $2::interval
+where session_id = $1`, `
+update relay_health_events
+set observed_at = observed_at - $2::interval, created_at = created_at - $2::interval
+where session_id = $1`, `
+update session_events
+set created_at = created_at - $2::interval
+where session_id = $1`, `
+update relay_terminations
+set created_at = created_at - $2::interval,
+    next_attempt_at = next_attempt_at - $2::interval,
+    completed_at = completed_at - $2::interval
+where session_id = $1`, `
+update idempotency_records
+set created_at = created_at - $2::interval, expires_at = expires_at - $2::interval
+where session_id = $1`,
+	}
+	var n int64
+	for i, q := range shifts {
+		tag, err := tx.Exec(ctx, q, sessionID, age)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			n = tag.RowsAffected()
+		}
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit(ctx)
+}
+
+// Wipe deletes every user, session and relay instance and everything
+// recorded about them, global webhooks included, then restores the reserved
+// canary user. The relay manifest, warm pool and job runs are kept.
+func (s *Store) Wipe(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+	const q = `
+truncate users, sessions, relay_instances, idempotency_records, usage_records, relay_health_events,
+  relay_terminations, session_events, session_changes, usage_alerts, webhooks, webhook_deliveries, billing_exports
+cascade`
+	if _, err := tx.Exec(ctx, q); err != nil {
+		return err
+	}
+	const canaryQ = `
+insert into users (id, email, plan_tier, included_seconds, created_at, updated_at)
+values ($1, 'canary@aegis.invalid', 'canary', 0, now(), now())`
+	if _, err := tx.Exec(ctx, canaryQ, model.CanaryUserID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+// ListSessionChanges returns up to limit changefeed rows after the row
+// afterSeq, or from the start when afterSeq is 0. Rows are returned in
+// (txid, seq) order and only once every older transaction has finished, so
+// reading on from the last row returned never skips one that committed late.
+// ErrNotFound means the row afterSeq is gone, deleted by retention. The
+// sessions_record_change trigger writes the rows.
+func (s *Store) ListSessionChanges(ctx context.Context, afterSeq int64, limit int) (_ []model.SessionChange, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	var afterTxid int64
+	if afterSeq > 0 {
+		if err := s.db.QueryRow(ctx, `select txid from session_changes where seq = $1`, afterSeq).Scan(&afterTxid); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, ErrNotFound
+			}
+			return nil, err
+		}
+	}
+	rows, err := s.db.Query(ctx, `
+select seq, session_id, user_id, region, status, coalesce(previous_status, ''), coalesce(stop_reason, ''), changed_at
+from session_changes
+where (txid, seq) > ($1, $2)
+  and txid < pg_snapshot_xmin(pg_current_snapshot())::text::bigint
+order by txid, seq
+limit $3`, afterTxid, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]model.SessionChange, 0)
+	for rows.Next() {
+		var c model.SessionChange
+		if err := rows.Scan(&c.Seq, &c.SessionID, &c.UserID, &c.Region, &c.Status, &c.PreviousStatus, &c.StopReason, &c.ChangedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// AckSessionChanges records that consumer has read the changefeed up to and
+// including the row seq. Acknowledgements only move forward, and one of a
+// row that is gone is ignored.
+func (s *Store) AckSessionChanges(ctx context.Context, consumer string, seq int64) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	_, err = s.db.Exec(ctx, `
+insert into session_change_consumers (consumer, acked_txid, acked_seq, updated_at)
+select $1, txid, seq, now()
+from session_changes
+where seq = $2
+on conflict (consumer) do update
+set acked_txid = excluded.acked_txid, acked_seq = excluded.acked_seq, updated_at = now()
+where (session_change_consumers.acked_txid, session_change_consumers.acked_seq) < (excluded.acked_txid, excluded.acked_seq)`, consumer, seq)
+	return err
+}
+
+// DeleteSessionChanges deletes up to limit of the oldest changefeed rows that
+// either changed before cutoff or come before every consumer's position, and
+// returns how many it deleted; callers repeat it until that is below limit.
+// The rows consumers last acknowledged are kept, since reads resume there.
+func (s *Store) DeleteSessionChanges(ctx context.Context, cutoff time.Time, limit int) (_ int, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Rollup)
+	defer done(&err)
+	const q = `
+delete from session_changes
+where seq in (
+  select seq
+  from session_changes
+  where changed_at < $1
+     or (txid, seq) < (
+       select acked_txid, acked_seq
+       from session_change_consumers
+       order by acked_txid, acked_seq
+       limit 1)
+  order by txid, seq
+  limit $2
+)`
+	tag, err := s.db.Exec(ctx, q, cutoff, limit)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+func TestListSessionChanges_InCommitOrderAfterPosition(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	t0 := time.Now().Add(-time.Minute)
+	cols := []string{"seq", "session_id", "user_id", "region", "status", "previous_status", "stop_reason", "changed_at"}
+	mock.ExpectQuery(regexp.QuoteMeta("select txid from session_changes where seq = $1")).
+		WithArgs(int64(41)).
+		WillReturnRows(pgxmock.NewRows([]string{"txid"}).AddRow(int64(900)))
+	mock.ExpectQuery(regexp.QuoteMeta("where (txid, seq) > ($1, $2)\n  and txid < pg_snapshot_xmin(pg_current_snapshot())::text::bigint\norder by txid, seq")).
+		WithArgs(int64(900), int64(41), 100).
+		WillReturnRows(pgxmock.NewRows(cols).
+			AddRow(int64(43), "ses_1", "usr_1", "us-east-1", "provisioning", "", "", t0).
+			AddRow(int64(42), "ses_2", "usr_2", "us-east-1", "stopped", "provisioning", "start_failed", t0.Add(time.Second)))
+
+	changes, err := New(mock).ListSessionChanges(context.Background(), 41, 100)
+	if err != nil {
+		t.Fatalf("ListSessionChanges: %v", err)
+	}
+	if len(changes) != 2 || changes[0].Seq != 43 || changes[0].PreviousStatus != "" {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	if c := changes[1]; c.Status != model.SessionStopped || c.PreviousStatus != model.SessionProvisioning || c.StopReason != "start_failed" {
+		t.Fatalf("unexpected stop %+v", c)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestListSessionChanges_FromStartAndDeletedPosition(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+	s := New(mock)
+
+	mock.ExpectQuery(regexp.QuoteMeta("where (txid, seq) > ($1, $2)")).
+		WithArgs(int64(0), int64(0), 10).
+		WillReturnRows(pgxmock.NewRows([]string{"seq", "session_id", "user_id", "region", "status", "previous_status", "stop_reason", "changed_at"}))
+	if changes, err := s.ListSessionChanges(context.Background(), 0, 10); err != nil || len(changes) != 0 {
+		t.Fatalf("expected no changes, got %+v err=%v", changes, err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("select txid from session_changes where seq = $1")).
+		WithArgs(int64(7)).
+		WillReturnError(pgx.ErrNoRows)
+	if _, err := s.ListSessionChanges(context.Background(), 7, 10); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a deleted position, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestAckSessionChanges_OnlyMovesForward(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("where (session_change_consumers.acked_txid, session_change_consumers.acked_seq) < (excluded.acked_txid, excluded.acked_seq)")).
+		WithArgs("analytics", int64(43)).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	if err := New(mock).AckSessionChanges(context.Background(), "analytics", 43); err != nil {
+		t.Fatalf("AckSessionChanges: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestDeleteSessionChanges_ExpiredOrBeforeEveryConsumer(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	cutoff := time.Now().Add(-7 * 24 * time.Hour)
+	mock.ExpectExec(regexp.QuoteMeta("or (txid, seq) < (")).
+		WithArgs(cutoff, 5000).
+		WillReturnResult(pgxmock.NewResult("DELETE", 12))
+
+	n, err := New(mock).DeleteSessionChanges(context.Background(), cutoff, 5000)
+	if err != nil || n != 12 {
+		t.Fatalf("expected 12 deleted, got %d err=%v", n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
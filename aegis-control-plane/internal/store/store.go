@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,16 +15,31 @@ import (
 	"github.com/jackc/pgx/v5/pgconn"
 
 	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/obs"
 )
 
 var (
 	ErrNotFound            = errors.New("not found")
 	ErrIdempotencyMismatch = errors.New("idempotency mismatch")
 	ErrRelayHealthRejected = errors.New("relay health rejected")
+	ErrSessionStopping     = errors.New("session stopping")
+	// ErrSessionLimit means the user already has as many live sessions as
+	// their plan allows.
+	ErrSessionLimit = errors.New("live session limit reached")
+	// ErrAdminSessionLimit means AEGIS_ADMIN_MAX_LIVE_SESSIONS sessions
+	// started by admins are already live.
+	ErrAdminSessionLimit = errors.New("admin session limit reached")
 )
 
+// startEndpoint is the idempotency_records endpoint of relay start.
+const startEndpoint = "/api/v1/relay/start"
+
 type Store struct {
-	db DB
+	db       DB
+	timeouts Timeouts
+	// idempotencyMaxPerUser caps each user's idempotency records per
+	// endpoint; zero keeps every record until it expires.
+	idempotencyMaxPerUser int
 }
 
 type DB interface {
@@ -33,12 +49,44 @@ type DB interface {
 	BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
 }
 
+// queryer runs single-row reads; both DB and a pgx.Tx are one, so a reader
+// serves callers inside and outside a transaction.
+type queryer interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
 type StartInput struct {
 	UserID         string
 	Region         string
 	RequestedBy    string
 	IdempotencyKey uuid.UUID
-	RequestHash    string
+	// RequestHash is the CanonicalHash of the request. A stored record also
+	// matches LegacyRequestHash, its HashJSON, so that keys used before
+	// canonical hashing stay valid until their records expire (one hour);
+	// drop it in the release after.
+	RequestHash       string
+	LegacyRequestHash string
+	// Canary flags a synthetic session.
+	Canary bool
+	// Tags are the session's labels, already normalized by
+	// model.NormalizeSessionTags.
+	Tags []string
+	// NonBillable sessions keep their durations but are left out of usage.
+	NonBillable bool
+	// StartedFromIP and StartedUserAgent are recorded on a new session, ""
+	// when unknown or not collected.
+	StartedFromIP    string
+	StartedUserAgent string
+	// StartedByAdmin is the admin starting the session on the user's
+	// behalf. Such a start creates no session once MaxAdminSessions of
+	// them are live, and with RefuseIfLive none while the user has one.
+	StartedByAdmin   string
+	MaxAdminSessions int
+	RefuseIfLive     bool
+}
+
+func (in StartInput) matchesHash(stored string) bool {
+	return stored == in.RequestHash || (in.LegacyRequestHash != "" && stored == in.LegacyRequestHash)
 }
 
 type RelayHealthInput struct {
@@ -48,6 +96,17 @@ type RelayHealthInput struct {
 	EgressActive         bool
 	SessionUptimeSeconds int
 	RawPayload           json.RawMessage
+	// AgentVersion is the relay agent's release, "" when it did not say.
+	// AgentOutdated flags an agent older than the supported minimum; its
+	// events are kept but not used to reconcile outages.
+	AgentVersion  string
+	AgentOutdated bool
+}
+
+// Health returns the fields model.ValidateRelayHealth checks, which callers
+// run before recording the event.
+func (in RelayHealthInput) Health() model.RelayHealth {
+	return model.RelayHealth{SessionID: in.SessionID, ObservedAt: in.ObservedAt, SessionUptimeSeconds: in.SessionUptimeSeconds}
 }
 
 type ActivateProvisionedSessionInput struct {
@@ -57,15 +116,104 @@ type ActivateProvisionedSessionInput struct {
 	AWSInstanceID string
 	AMIID         string
 	InstanceType  string
+	Lifecycle     string
 	PublicIP      string
-	SRTPort       int
+	SRTPorts      []int
 	WSURL         string
 	PairToken     string
 	RelayWSToken  string
+
+	EIPAllocationID  string
+	PublicIPv6       string
+	SubnetID         string
+	AvailabilityZone string
+	// SecurityGroupID and AllowedClientIP are set for relays locked to the
+	// streamer's IP.
+	SecurityGroupID string
+	AllowedClientIP string
+	// Provider is the backend that launched the relay, when routed.
+	Provider string
+
+	// NewPairToken replaces PairToken when it is already held by another
+	// live session. Without it a collision fails the activation.
+	NewPairToken func() (string, error)
+}
+
+// Relay returns the fields model.ValidateActivation checks, which callers
+// run before ActivateProvisionedSession.
+func (in ActivateProvisionedSessionInput) Relay() model.RelayActivation {
+	return model.RelayActivation{
+		Region: in.Region, Provider: in.Provider, AMIID: in.AMIID, PublicIP: in.PublicIP, PublicIPv6: in.PublicIPv6,
+		AllowedClientIP: in.AllowedClientIP, SRTPorts: in.SRTPorts,
+	}
+}
+
+// pairTokenAttempts bounds activation retries after pair token collisions.
+const pairTokenAttempts = 3
+
+type ReplaceSessionRelayInput struct {
+	SessionID          string
+	OldRelayInstanceID string
+	OldRegion          string
+	OldAWSInstanceID   string
+	Reason             string
+
+	Region        string
+	AWSInstanceID string
+	AMIID         string
+	InstanceType  string
+	Lifecycle     string
+	PublicIP      string
+	SRTPorts      []int
+	WSURL         string
+
+	EIPAllocationID  string
+	PublicIPv6       string
+	SubnetID         string
+	AvailabilityZone string
+	// SecurityGroupID and AllowedClientIP are set for relays locked to the
+	// streamer's IP.
+	SecurityGroupID string
+	AllowedClientIP string
+	Provider        string
+}
+
+// Relay returns the fields model.ValidateActivation checks, which callers
+// run before ReplaceSessionRelay.
+func (in ReplaceSessionRelayInput) Relay() model.RelayActivation {
+	return model.RelayActivation{
+		Region: in.Region, Provider: in.Provider, AMIID: in.AMIID, PublicIP: in.PublicIP, PublicIPv6: in.PublicIPv6,
+		AllowedClientIP: in.AllowedClientIP, SRTPorts: in.SRTPorts,
+	}
+}
+
+const insertRelayInstanceQ = `
+insert into relay_instances
+  (id, session_id, aws_instance_id, region, ami_id, instance_type, lifecycle, public_ip, srt_port, ws_url, eip_allocation_id, public_ipv6,
+   subnet_id, availability_zone, security_group_id, allowed_client_ip, provider, srt_ports, state, launched_at, created_at)
+values
+  ($1, $2, $3, $4, $5, $6, $7, nullif($8, '')::inet, $9, $10, nullif($12, ''), nullif($13, '')::inet,
+   nullif($14, ''), nullif($15, ''), nullif($16, ''), nullif($17, '')::inet, $18, $19, 'running', $11, $11)`
+
+// defaultSRTPort is the SRT port of relays that report none.
+const defaultSRTPort = 9000
+
+// srtPortArgs returns a relay's srt_port and srt_ports values: the first
+// port, and the list or NULL when there is none. The ports are assumed to
+// have passed model.ValidateSRTPorts.
+func srtPortArgs(ports []int) (int, []int) {
+	if len(ports) == 0 {
+		return defaultSRTPort, nil
+	}
+	return ports[0], ports
 }
 
-func New(db DB) *Store {
-	return &Store{db: db}
+func New(db DB, opts ...Option) *Store {
+	s := &Store{db: db, timeouts: DefaultTimeouts}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func HashJSON(v any) (string, error) {
@@ -77,73 +225,98 @@ func HashJSON(v any) (string, error) {
 	return hex.EncodeToString(sum[:]), nil
 }
 
-func (s *Store) GetActiveSession(ctx context.Context, userID string) (*model.Session, error) {
+// GetActiveSession returns the user's provisioning, active, grace or
+// stopping session, or ErrNotFound when there is none.
+func (s *Store) GetActiveSession(ctx context.Context, userID string) (_ *model.Session, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	return s.getActiveSession(ctx, s.db, userID)
+}
+
+// GetActiveRelayAccess returns the IP lock of the user's live relay, or
+// ErrNotFound when the user has no active or grace session with a relay.
+func (s *Store) GetActiveRelayAccess(ctx context.Context, userID string) (_ *model.RelayAccess, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
 	const q = `
-select s.id, s.user_id, coalesce(s.relay_instance_id, ''), coalesce(ri.aws_instance_id, ''), s.status, s.region, s.pair_token, s.relay_ws_token,
-       coalesce(ri.public_ip::text, ''), coalesce(ri.srt_port, 9000), coalesce(ri.ws_url, ''),
-       s.started_at, s.stopped_at, s.duration_seconds, s.grace_window_seconds, s.max_session_seconds
+select s.id, ri.id, ri.region, coalesce(ri.security_group_id, ''), coalesce(ri.srt_ports, array[ri.srt_port]), ri.provider
 from sessions s
-left join relay_instances ri on ri.id = s.relay_instance_id
-where user_id = $1 and status in ('provisioning', 'active', 'grace')
+join relay_instances ri on ri.id = s.relay_instance_id
+where s.user_id = $1 and s.status in ('active', 'grace')
 order by s.created_at desc
 limit 1`
 
-	var out model.Session
-	var relayInstanceID string
-	var stoppedAt *time.Time
-	if err := s.db.QueryRow(ctx, q, userID).Scan(
-		&out.ID, &out.UserID, &relayInstanceID, &out.RelayAWSInstanceID, &out.Status, &out.Region, &out.PairToken, &out.RelayWSToken,
-		&out.PublicIP, &out.SRTPort, &out.WSURL,
-		&out.StartedAt, &stoppedAt, &out.DurationSeconds, &out.GraceWindowSeconds, &out.MaxSessionSeconds,
-	); err != nil {
+	var out model.RelayAccess
+	if err := s.db.QueryRow(ctx, q, userID).Scan(&out.SessionID, &out.RelayInstanceID, &out.Region, &out.SecurityGroupID, &out.SRTPorts, &out.Provider); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, nil
+			return nil, ErrNotFound
 		}
 		return nil, err
 	}
-	out.StoppedAt = stoppedAt
-	out.RelayInstanceID = strPtr(relayInstanceID)
 	return &out, nil
 }
 
-func (s *Store) StartOrGetSession(ctx context.Context, in StartInput) (*model.Session, bool, error) {
-	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+// UpdateRelayAllowedClientIP records the streamer IP a relay's security
+// group now admits.
+func (s *Store) UpdateRelayAllowedClientIP(ctx context.Context, relayInstanceID, clientIP string) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	const q = `update relay_instances set allowed_client_ip = $2::inet where id = $1`
+	tag, err := s.db.Exec(ctx, q, relayInstanceID, clientIP)
 	if err != nil {
-		return nil, false, err
+		return err
 	}
-	defer tx.Rollback(ctx)
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
 
-	var storedHash string
-	var storedResp []byte
-	const idemLookup = `
-select request_hash, response_json
-from idempotency_records
-where user_id = $1 and endpoint = '/api/v1/relay/start' and idempotency_key = $2 and expires_at > now()`
-	err = tx.QueryRow(ctx, idemLookup, in.UserID, in.IdempotencyKey).Scan(&storedHash, &storedResp)
-	if err == nil {
-		if storedHash != in.RequestHash {
-			return nil, false, ErrIdempotencyMismatch
-		}
-		var sess model.Session
-		if err := json.Unmarshal(storedResp, &sess); err != nil {
-			return nil, false, err
-		}
-		if err := tx.Commit(ctx); err != nil {
-			return nil, false, err
+// StartOrGetSession creates a provisioning session while the user has fewer
+// live sessions than their plan allows. At a limit of one it returns the
+// live session instead; above one it returns ErrSessionLimit. Either way
+// ErrSessionStopping is returned while one of them is still stopping. A
+// start that loses a race with a concurrent one for the same user retries
+// once and sees the winner's session. A start repeating an earlier key gets
+// that start's session as first recorded, with Replayed set.
+func (s *Store) StartOrGetSession(ctx context.Context, in StartInput) (_ *model.Session, _ bool, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	var sess *model.Session
+	var created bool
+	err = withTxRetry(ctx, "start_or_get_session", func() (err error) {
+		sess, created, err = s.startOrGetSession(ctx, in)
+		if isLiveSessionConflict(err) {
+			sess, created, err = s.startOrGetSession(ctx, in)
 		}
-		return &sess, false, nil
-	}
-	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	})
+	return sess, created, err
+}
+
+// isLiveSessionConflict reports whether err is the live session limit
+// trigger rejecting a start.
+func isLiveSessionConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "sessions_live_limit"
+}
+
+func (s *Store) startOrGetSession(ctx context.Context, in StartInput) (*model.Session, bool, error) {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
 		return nil, false, err
 	}
+	defer rollback(tx)
 
-	existing, err := s.getActiveSessionTx(ctx, tx, in.UserID)
+	existing, replayed, err := s.decideStart(ctx, tx, in)
 	if err != nil {
 		return nil, false, err
 	}
 	if existing != nil {
-		if err := s.persistIdempotencyRecord(ctx, tx, in, existing); err != nil {
-			return nil, false, err
+		if !replayed {
+			if err := s.persistIdempotencyRecord(ctx, tx, in, existing); err != nil {
+				return nil, false, err
+			}
 		}
 		if err := tx.Commit(ctx); err != nil {
 			return nil, false, err
@@ -155,10 +328,29 @@ where user_id = $1 and endpoint = '/api/v1/relay/start' and idempotency_key = $2
 	now := time.Now().UTC()
 	const insertSession = `
 insert into sessions
-  (id, user_id, status, region, idempotency_key, requested_by, pair_token, relay_ws_token, started_at, max_session_seconds, grace_window_seconds, duration_seconds, reconciled_seconds, created_at, updated_at)
+  (id, user_id, status, provisioning_phase, region, idempotency_key, requested_by, pair_token, relay_ws_token, started_at, max_session_seconds, grace_window_seconds, duration_seconds, reconciled_seconds, canary, billable,
+   started_from_ip, started_user_agent, started_by_admin, tags, created_at, updated_at)
 values
-  ($1, $2, 'provisioning', $3, $4, $5, '', '', $6, 57600, 600, 0, 0, $6, $6)`
-	if _, err := tx.Exec(ctx, insertSession, newID, in.UserID, in.Region, in.IdempotencyKey, in.RequestedBy, now); err != nil {
+  ($1, $2, 'provisioning', 'requested', $3, $4, $5, '', '', $6, 57600, 600, 0, 0, $7, $8, nullif($9, '')::inet, nullif($10, ''), nullif($11, ''),
+   coalesce($12::text[], '{}'), $6, $6)
+returning (select plan_tier from users where id = $2)`
+	var planTier string
+	if err := tx.QueryRow(ctx, insertSession, newID, in.UserID, in.Region, in.IdempotencyKey, in.RequestedBy, now, in.Canary, !in.NonBillable, in.StartedFromIP, in.StartedUserAgent, in.StartedByAdmin, in.Tags).Scan(&planTier); err != nil {
+		return nil, false, err
+	}
+	if in.StartedByAdmin != "" {
+		const eventQ = `
+insert into session_events (session_id, user_id, event_type, payload_json, created_at)
+values ($1, $2, 'admin_session_started', jsonb_build_object('session_id', $1::text, 'admin_id', $3::text), $4)`
+		if _, err := tx.Exec(ctx, eventQ, newID, in.UserID, in.StartedByAdmin, now); err != nil {
+			return nil, false, err
+		}
+	}
+	if err := enqueueWebhookEvent(ctx, tx, in.UserID, model.WebhookSessionStarted, map[string]any{
+		"session_id": newID,
+		"region":     in.Region,
+		"started_at": now.Format(time.RFC3339),
+	}); err != nil {
 		return nil, false, err
 	}
 
@@ -166,11 +358,14 @@ values
 		ID:                 newID,
 		UserID:             in.UserID,
 		Status:             model.SessionProvisioning,
+		ProvisioningPhase:  model.ProvisioningRequested,
 		Region:             in.Region,
-		SRTPort:            9000,
+		SRTPort:            defaultSRTPort,
 		StartedAt:          now,
 		GraceWindowSeconds: 600,
 		MaxSessionSeconds:  57600,
+		Billable:           !in.NonBillable,
+		Tags:               in.Tags,
 	}
 
 	if err := s.persistIdempotencyRecord(ctx, tx, in, sess); err != nil {
@@ -179,53 +374,251 @@ values
 	if err := tx.Commit(ctx); err != nil {
 		return nil, false, err
 	}
+	obs.SessionStarted(sess.Region, planTier)
 	return sess, true, nil
 }
 
-func (s *Store) getActiveSessionTx(ctx context.Context, tx pgx.Tx, userID string) (*model.Session, error) {
+// decideStart decides a start in tx without writing: it returns the session
+// the key already started (replayed), the user's live session the start
+// would return instead, or neither when a new session would be created.
+func (s *Store) decideStart(ctx context.Context, tx pgx.Tx, in StartInput) (existing *model.Session, replayed bool, err error) {
+	// Keys are per user, not per endpoint: a key already used on another
+	// endpoint for a different request is a mismatch too.
+	var storedEndpoint, storedHash string
+	var storedResp []byte
+	const idemLookup = `
+select endpoint, request_hash, response_json
+from idempotency_records
+where user_id = $1 and idempotency_key = $2 and expires_at > now()
+order by endpoint = $3 desc
+limit 1`
+	err = tx.QueryRow(ctx, idemLookup, in.UserID, in.IdempotencyKey, startEndpoint).Scan(&storedEndpoint, &storedHash, &storedResp)
+	if err == nil && !in.matchesHash(storedHash) {
+		return nil, false, ErrIdempotencyMismatch
+	}
+	if err == nil && storedEndpoint == startEndpoint {
+		var sess model.Session
+		if err := json.Unmarshal(storedResp, &sess); err != nil {
+			return nil, false, err
+		}
+		sess.Replayed = true
+		return &sess, true, nil
+	}
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, err
+	}
+
+	existing, err = s.getActiveSession(ctx, tx, in.UserID)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		// No live session: create one.
+	case err != nil:
+		return nil, false, err
+	case in.RefuseIfLive:
+		return nil, false, ErrUserSessionsLive
+	default:
+		limit, live, stopping, err := liveSessionCountsTx(ctx, tx, in.UserID)
+		switch {
+		case err != nil:
+			return nil, false, err
+		case live < limit:
+			// Room for another: create one.
+		case stopping > 0:
+			return nil, false, ErrSessionStopping
+		case limit > 1:
+			return nil, false, ErrSessionLimit
+		default:
+			return existing, false, nil
+		}
+	}
+
+	if in.StartedByAdmin != "" {
+		if err := checkAdminSessionLimitTx(ctx, tx, in.UserID, in.MaxAdminSessions); err != nil {
+			return nil, false, err
+		}
+	}
+	return nil, false, nil
+}
+
+// StartPreview is what StartOrGetSession would do with an input. Existing
+// is the session it would return instead of creating one: the one the key
+// already started (Replayed) or the user's live session. Nil means a new
+// session would be created.
+type StartPreview struct {
+	Existing *model.Session
+	Replayed bool
+}
+
+// PreviewStart runs the checks of StartOrGetSession, returning the same
+// errors, in a read-only transaction: it records no session, idempotency
+// record or event, so a later start with the same key is unaffected.
+func (s *Store) PreviewStart(ctx context.Context, in StartInput) (_ StartPreview, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return StartPreview{}, err
+	}
+	defer rollback(tx)
+	existing, replayed, err := s.decideStart(ctx, tx, in)
+	if err != nil {
+		return StartPreview{}, err
+	}
+	return StartPreview{Existing: existing, Replayed: replayed}, nil
+}
+
+// checkAdminSessionLimitTx returns ErrNotFound when the user does not exist
+// and ErrAdminSessionLimit when max admin-started sessions are live. Admin
+// starts are serialized on an advisory lock held until commit, so the count
+// sees every committed one.
+func checkAdminSessionLimitTx(ctx context.Context, tx pgx.Tx, userID string, max int) error {
+	if _, err := tx.Exec(ctx, `select pg_advisory_xact_lock(hashtextextended('sessions_admin', 0))`); err != nil {
+		return err
+	}
+	const q = `
+select exists(select 1 from users where id = $1),
+       (select count(*)
+        from sessions
+        where started_by_admin is not null and status in ('provisioning', 'active', 'grace', 'stopping'))`
+	var userExists bool
+	var live int
+	if err := tx.QueryRow(ctx, q, userID).Scan(&userExists, &live); err != nil {
+		return err
+	}
+	switch {
+	case !userExists:
+		return ErrNotFound
+	case live >= max:
+		return ErrAdminSessionLimit
+	}
+	return nil
+}
+
+// liveSessionCountsTx returns how many live sessions the user's plan allows,
+// how many they have and how many of those are stopping.
+func liveSessionCountsTx(ctx context.Context, tx pgx.Tx, userID string) (limit, live, stopping int, err error) {
 	const q = `
+select coalesce((
+         select pp.max_concurrent_sessions
+         from users u
+         join plan_policies pp on pp.plan_tier = u.plan_tier
+         where u.id = $1), 1),
+       count(*),
+       count(*) filter (where status = 'stopping')
+from sessions
+where user_id = $1 and status in ('provisioning', 'active', 'grace', 'stopping')`
+	err = tx.QueryRow(ctx, q, userID).Scan(&limit, &live, &stopping)
+	return limit, live, stopping, err
+}
+
+// sessionSelect is the projection scanSession reads. Queries returning
+// sessions append their own where clause, so a column added here reaches
+// every reader.
+const sessionSelect = `
 select s.id, s.user_id, coalesce(s.relay_instance_id, ''), coalesce(ri.aws_instance_id, ''), s.status, s.region, s.pair_token, s.relay_ws_token,
-       coalesce(ri.public_ip::text, ''), coalesce(ri.srt_port, 9000), coalesce(ri.ws_url, ''),
-       s.started_at, s.stopped_at, s.duration_seconds, s.grace_window_seconds, s.max_session_seconds
+       coalesce(ri.public_ip::text, ''), coalesce(host(ri.public_ipv6), ''), coalesce(ri.srt_port, 9000),
+       coalesce(ri.srt_ports, array[coalesce(ri.srt_port, 9000)]), coalesce(ri.ws_url, ''),
+       s.started_at, s.stopped_at, s.duration_seconds, s.grace_window_seconds, s.max_session_seconds, s.grace_started_at,
+       ri.last_health_at, coalesce(s.provisioning_phase, ''), s.tags
 from sessions s
-left join relay_instances ri on ri.id = s.relay_instance_id
-where s.user_id = $1 and s.status in ('provisioning', 'active', 'grace')
-order by s.created_at desc
-limit 1`
+left join relay_instances ri on ri.id = s.relay_instance_id`
+
+// scanSession scans a row selected through sessionSelect.
+func scanSession(row pgx.Row) (*model.Session, error) {
 	var out model.Session
 	var relayInstanceID string
-	var stoppedAt *time.Time
-	if err := tx.QueryRow(ctx, q, userID).Scan(
+	if err := row.Scan(
 		&out.ID, &out.UserID, &relayInstanceID, &out.RelayAWSInstanceID, &out.Status, &out.Region, &out.PairToken, &out.RelayWSToken,
-		&out.PublicIP, &out.SRTPort, &out.WSURL,
-		&out.StartedAt, &stoppedAt, &out.DurationSeconds, &out.GraceWindowSeconds, &out.MaxSessionSeconds,
+		&out.PublicIP, &out.PublicIPv6, &out.SRTPort, &out.SRTPorts, &out.WSURL,
+		&out.StartedAt, &out.StoppedAt, &out.DurationSeconds, &out.GraceWindowSeconds, &out.MaxSessionSeconds, &out.GraceStartedAt,
+		&out.LastHealthAt, &out.ProvisioningPhase, &out.Tags,
 	); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, nil
-		}
 		return nil, err
 	}
-	out.StoppedAt = stoppedAt
 	out.RelayInstanceID = strPtr(relayInstanceID)
 	return &out, nil
 }
 
-func (s *Store) ActivateProvisionedSession(ctx context.Context, in ActivateProvisionedSessionInput) (*model.Session, error) {
+// ListLiveSessions returns the user's sessions that are not stopped, newest
+// first; with a tag, only those carrying it.
+func (s *Store) ListLiveSessions(ctx context.Context, userID, tag string) (_ []model.Session, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	const q = sessionSelect + `
+where s.user_id = $1 and s.status in ('provisioning', 'active', 'grace', 'stopping')
+  and ($2 = '' or s.tags @> array[$2::text])
+order by s.created_at desc`
+	rows, err := s.db.Query(ctx, q, userID, tag)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]model.Session, 0)
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *sess)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) getActiveSession(ctx context.Context, db queryer, userID string) (*model.Session, error) {
+	const q = sessionSelect + `
+where s.user_id = $1 and s.status in ('provisioning', 'active', 'grace', 'stopping')
+order by s.created_at desc
+limit 1`
+	sess, err := scanSession(db.QueryRow(ctx, q, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return sess, err
+}
+
+func (s *Store) ActivateProvisionedSession(ctx context.Context, in ActivateProvisionedSessionInput) (_ *model.Session, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	for attempt := 1; ; attempt++ {
+		var sess *model.Session
+		err := withTxRetry(ctx, "activate_provisioned_session", func() (err error) {
+			sess, err = s.activateProvisionedSession(ctx, in)
+			return err
+		})
+		if !isPairTokenConflict(err) || in.NewPairToken == nil || attempt == pairTokenAttempts {
+			return sess, err
+		}
+		if in.PairToken, err = in.NewPairToken(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// isPairTokenConflict reports whether err is a violation of the live pair
+// token index.
+func isPairTokenConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "sessions_live_pair_token"
+}
+
+func (s *Store) activateProvisionedSession(ctx context.Context, in ActivateProvisionedSessionInput) (*model.Session, error) {
+	srtPort, srtPorts := srtPortArgs(in.SRTPorts)
 	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback(ctx)
+	defer rollback(tx)
 
 	relayID := "rly_" + uuid.NewString()
 	now := time.Now().UTC()
-	const insertRelay = `
-insert into relay_instances
-  (id, session_id, aws_instance_id, region, ami_id, instance_type, public_ip, srt_port, ws_url, state, launched_at, created_at)
-values
-  ($1, $2, $3, $4, $5, $6, $7::inet, $8, $9, 'running', $10, $10)`
-	if _, err := tx.Exec(ctx, insertRelay,
-		relayID, in.SessionID, in.AWSInstanceID, in.Region, in.AMIID, in.InstanceType, in.PublicIP, in.SRTPort, in.WSURL, now,
+	lifecycle := in.Lifecycle
+	if lifecycle == "" {
+		lifecycle = model.RelayLifecycleOnDemand
+	}
+	if _, err := tx.Exec(ctx, insertRelayInstanceQ,
+		relayID, in.SessionID, in.AWSInstanceID, in.Region, in.AMIID, in.InstanceType, lifecycle, in.PublicIP, srtPort, in.WSURL, now, in.EIPAllocationID, in.PublicIPv6,
+		in.SubnetID, in.AvailabilityZone, in.SecurityGroupID, in.AllowedClientIP, in.Provider, srtPorts,
 	); err != nil {
 		return nil, err
 	}
@@ -234,19 +627,31 @@ values
 update sessions
 set relay_instance_id = $3,
     status = 'active',
+    provisioning_phase = 'ready',
     pair_token = $4,
     relay_ws_token = $5,
+    region = $6,
     updated_at = now()
 where user_id = $1 and id = $2 and status = 'provisioning'`
-	tag, err := tx.Exec(ctx, updateSession, in.UserID, in.SessionID, relayID, in.PairToken, in.RelayWSToken)
+	tag, err := tx.Exec(ctx, updateSession, in.UserID, in.SessionID, relayID, in.PairToken, in.RelayWSToken, in.Region)
 	if err != nil {
 		return nil, err
 	}
 	if tag.RowsAffected() == 0 {
 		return nil, ErrNotFound
 	}
+	if err := recordProvisioningPhaseTx(ctx, tx, in.UserID, in.SessionID, model.ProvisioningReady); err != nil {
+		return nil, err
+	}
+	if err := enqueueWebhookEvent(ctx, tx, in.UserID, model.WebhookSessionActivated, map[string]any{
+		"session_id":  in.SessionID,
+		"region":      in.Region,
+		"instance_id": in.AWSInstanceID,
+	}); err != nil {
+		return nil, err
+	}
 
-	sess, err := s.getSessionByIDTx(ctx, tx, in.UserID, in.SessionID)
+	sess, err := s.getSessionByID(ctx, tx, in.UserID, in.SessionID)
 	if err != nil {
 		return nil, err
 	}
@@ -256,49 +661,26 @@ where user_id = $1 and id = $2 and status = 'provisioning'`
 	return sess, nil
 }
 
-func (s *Store) getSessionByIDTx(ctx context.Context, tx pgx.Tx, userID, sessionID string) (*model.Session, error) {
-	const q = `
-select s.id, s.user_id, coalesce(s.relay_instance_id, ''), coalesce(ri.aws_instance_id, ''), s.status, s.region, s.pair_token, s.relay_ws_token,
-       coalesce(ri.public_ip::text, ''), coalesce(ri.srt_port, 9000), coalesce(ri.ws_url, ''),
-       s.started_at, s.stopped_at, s.duration_seconds, s.grace_window_seconds, s.max_session_seconds
-from sessions s
-left join relay_instances ri on ri.id = s.relay_instance_id
+func (s *Store) getSessionByID(ctx context.Context, db queryer, userID, sessionID string) (*model.Session, error) {
+	const q = sessionSelect + `
 where s.user_id = $1 and s.id = $2
 limit 1`
-	var out model.Session
-	var relayInstanceID string
-	var stoppedAt *time.Time
-	if err := tx.QueryRow(ctx, q, userID, sessionID).Scan(
-		&out.ID, &out.UserID, &relayInstanceID, &out.RelayAWSInstanceID, &out.Status, &out.Region, &out.PairToken, &out.RelayWSToken,
-		&out.PublicIP, &out.SRTPort, &out.WSURL,
-		&out.StartedAt, &stoppedAt, &out.DurationSeconds, &out.GraceWindowSeconds, &out.MaxSessionSeconds,
-	); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, ErrNotFound
-		}
-		return nil, err
+	sess, err := scanSession(db.QueryRow(ctx, q, userID, sessionID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
 	}
-	out.StoppedAt = stoppedAt
-	out.RelayInstanceID = strPtr(relayInstanceID)
-	return &out, nil
+	return sess, err
 }
 
-func (s *Store) GetSessionByID(ctx context.Context, userID, sessionID string) (*model.Session, error) {
-	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
-	if err != nil {
-		return nil, err
-	}
-	defer tx.Rollback(ctx)
-	sess, err := s.getSessionByIDTx(ctx, tx, userID, sessionID)
-	if err != nil {
-		return nil, err
-	}
-	if err := tx.Commit(ctx); err != nil {
-		return nil, err
-	}
-	return sess, nil
+func (s *Store) GetSessionByID(ctx context.Context, userID, sessionID string) (_ *model.Session, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	return s.getSessionByID(ctx, s.db, userID, sessionID)
 }
 
+// persistIdempotencyRecord records the start's response under its key. A
+// record already holding the key has expired but not been cleaned up yet
+// (live ones are replayed), so it is replaced outright.
 func (s *Store) persistIdempotencyRecord(ctx context.Context, tx pgx.Tx, in StartInput, sess *model.Session) error {
 	resp, err := json.Marshal(sess)
 	if err != nil {
@@ -308,125 +690,220 @@ func (s *Store) persistIdempotencyRecord(ctx context.Context, tx pgx.Tx, in Star
 insert into idempotency_records
   (user_id, endpoint, idempotency_key, request_hash, response_json, session_id, created_at, expires_at)
 values
-  ($1, '/api/v1/relay/start', $2, $3, $4, $5, now(), now() + interval '1 hour')
+  ($1, $2, $3, $4, $5, $6, now(), now() + interval '1 hour')
 on conflict (user_id, endpoint, idempotency_key)
-do update set response_json = excluded.response_json, session_id = excluded.session_id`
-	_, err = tx.Exec(ctx, q, in.UserID, in.IdempotencyKey, in.RequestHash, resp, sess.ID)
-	return err
+do update set request_hash = excluded.request_hash, response_json = excluded.response_json, session_id = excluded.session_id,
+  created_at = excluded.created_at, expires_at = excluded.expires_at`
+	if _, err := tx.Exec(ctx, q, in.UserID, startEndpoint, in.IdempotencyKey, in.RequestHash, resp, sess.ID); err != nil {
+		return err
+	}
+	return s.pruneIdempotencyRecords(ctx, tx, in.UserID, startEndpoint)
+}
+
+// WithIdempotencyMaxPerUser keeps only each user's n most recent
+// idempotency records per endpoint, so a client that sends a fresh key on
+// every retry cannot grow the table without bound within the TTL.
+func WithIdempotencyMaxPerUser(n int) Option {
+	return func(s *Store) {
+		s.idempotencyMaxPerUser = n
+	}
+}
+
+// pruneIdempotencyRecords deletes the user's records on endpoint beyond the
+// most recent idempotencyMaxPerUser. The record just written is the most
+// recent, so its key still replays.
+func (s *Store) pruneIdempotencyRecords(ctx context.Context, tx pgx.Tx, userID, endpoint string) error {
+	if s.idempotencyMaxPerUser <= 0 {
+		return nil
+	}
+	const q = `
+delete from idempotency_records
+where user_id = $1 and endpoint = $2
+  and id not in (
+    select id from idempotency_records
+    where user_id = $1 and endpoint = $2
+    order by created_at desc, id desc
+    limit $3
+  )`
+	tag, err := tx.Exec(ctx, q, userID, endpoint, s.idempotencyMaxPerUser)
+	if err != nil {
+		return err
+	}
+	if n := tag.RowsAffected(); n > 0 {
+		log.Printf("event=idempotency_records_pruned user_id=%s endpoint=%s deleted=%d max_per_user=%d", userID, endpoint, n, s.idempotencyMaxPerUser)
+	}
+	return nil
+}
+
+type StopSessionInput struct {
+	UserID    string
+	SessionID string
+	// Reason is a model.StopReason* value.
+	Reason string
+	// ClientReason (a model.ClientStopReasons value) and ClientDetail are
+	// what the user's client gave for the stop; empty for other stops.
+	ClientReason string
+	ClientDetail string
+}
+
+// StopSession ends a session for in.Reason. Sessions with a bound relay
+// move to stopping and queue a relay_terminations entry in the same
+// transaction; the jobs worker terminates the instance and finalizes the
+// session to stopped. Repeated stops keep the first stop's reasons.
+func (s *Store) StopSession(ctx context.Context, in StopSessionInput) (_ *model.Session, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	var sess *model.Session
+	err = withTxRetry(ctx, "stop_session", func() (err error) {
+		sess, err = s.stopSession(ctx, in, "", "")
+		return err
+	})
+	return sess, err
+}
+
+// StopProvisionedSession is StopSession for a relay that was launched but
+// never bound to the session, i.e. a failed start. region is where the
+// relay runs, which may differ from the session's requested region.
+func (s *Store) StopProvisionedSession(ctx context.Context, userID, sessionID, region, awsInstanceID string) (_ *model.Session, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	var sess *model.Session
+	err = withTxRetry(ctx, "stop_provisioned_session", func() (err error) {
+		sess, err = s.stopSession(ctx, StopSessionInput{UserID: userID, SessionID: sessionID, Reason: model.StopReasonStartFailed}, region, awsInstanceID)
+		return err
+	})
+	return sess, err
 }
 
-func (s *Store) StopSession(ctx context.Context, userID, sessionID string) (*model.Session, error) {
+func (s *Store) stopSession(ctx context.Context, in StopSessionInput, region, awsInstanceID string) (*model.Session, error) {
+	userID, sessionID, reason := in.UserID, in.SessionID, in.Reason
 	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback(ctx)
+	defer rollback(tx)
 
-	curr, err := s.getSessionByIDTx(ctx, tx, userID, sessionID)
+	curr, err := s.getSessionByID(ctx, tx, userID, sessionID)
 	if err != nil {
 		return nil, err
 	}
-	if curr.Status != model.SessionStopped {
+	if awsInstanceID == "" {
+		awsInstanceID = curr.RelayAWSInstanceID
+	}
+	if region == "" {
+		region = curr.Region
+	}
+	stopped := curr.Status != model.SessionStopped && curr.Status != model.SessionStopping
+	var pricedRegion, pricedType string
+	var pricedAtDefault bool
+	next := model.SessionStopped
+	if awsInstanceID != "" {
+		next = model.SessionStopping
+	}
+	if stopped {
 		const stopQ = `
 update sessions
-set status = 'stopped', stopped_at = now(), updated_at = now()
+set status = $3, stop_reason = $4, client_stop_reason = nullif($5, ''), client_stop_detail = nullif($6, ''),
+    stopped_at = now(), updated_at = now()
 where user_id = $1 and id = $2 and status in ('provisioning', 'active', 'grace')`
-		tag, err := tx.Exec(ctx, stopQ, userID, sessionID)
+		tag, err := tx.Exec(ctx, stopQ, userID, sessionID, string(next), reason, in.ClientReason, in.ClientDetail)
 		if err != nil {
 			return nil, err
 		}
-		if tag.RowsAffected() == 0 {
-			return nil, ErrNotFound
+		// Only one of concurrent stops of a session moves it out of a live
+		// status; the others wait on its row lock, match nothing and report
+		// the winner's result without queuing a second termination.
+		stopped = tag.RowsAffected() > 0
+	}
+	if stopped {
+		if err := enqueueWebhookEvent(ctx, tx, userID, model.WebhookSessionStopped, map[string]any{
+			"session_id": sessionID,
+			"status":     string(next),
+		}); err != nil {
+			return nil, err
 		}
 		if curr.RelayInstanceID != nil {
 			const relayQ = `
 update relay_instances
-set state = 'terminated', terminated_at = coalesce(terminated_at, now())
-where id = $1`
-			if _, err := tx.Exec(ctx, relayQ, *curr.RelayInstanceID); err != nil {
+set state = 'terminating', terminating_since = coalesce(terminating_since, now()),
+    terminated_reason = coalesce(terminated_reason, $2)
+where id = $1 and state <> 'terminated'`
+			if _, err := tx.Exec(ctx, relayQ, *curr.RelayInstanceID, model.TerminateReasonForStop(reason)); err != nil {
+				return nil, err
+			}
+			if pricedRegion, pricedType, pricedAtDefault, err = priceStoppedSession(ctx, tx, sessionID); err != nil {
+				return nil, err
+			}
+		}
+		if awsInstanceID != "" {
+			const enqueueQ = `
+insert into relay_terminations (session_id, user_id, region, aws_instance_id, reason, next_attempt_at, created_at)
+values ($1, $2, $3, $4, $5, now(), now())
+on conflict (aws_instance_id) where completed_at is null do nothing`
+			if _, err := tx.Exec(ctx, enqueueQ, sessionID, userID, region, awsInstanceID, model.TerminateReasonForStop(reason)); err != nil {
 				return nil, err
 			}
 		}
 	}
 
-	out, err := s.getSessionByIDTx(ctx, tx, userID, sessionID)
+	out, err := s.getSessionByID(ctx, tx, userID, sessionID)
 	if err != nil {
 		return nil, err
 	}
 	if err := tx.Commit(ctx); err != nil {
 		return nil, err
 	}
-	return out, nil
-}
-
-func (s *Store) GetUsageCurrent(ctx context.Context, userID string) (*model.UsageCurrent, error) {
-	const q = `
-select
-  u.plan_tier,
-  u.cycle_start_at,
-  u.cycle_end_at,
-  u.included_seconds,
-  coalesce(sum(ur.billable_seconds), 0) as consumed_seconds
-from users u
-left join usage_records ur
-  on ur.user_id = u.id
- and ur.cycle_start_at = u.cycle_start_at
- and ur.cycle_end_at = u.cycle_end_at
-where u.id = $1
-group by u.plan_tier, u.cycle_start_at, u.cycle_end_at, u.included_seconds`
-	var out model.UsageCurrent
-	if err := s.db.QueryRow(ctx, q, userID).Scan(
-		&out.PlanTier, &out.CycleStart, &out.CycleEnd, &out.IncludedSeconds, &out.ConsumedSeconds,
-	); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, ErrNotFound
-		}
-		return nil, err
-	}
-	out.RemainingSeconds = max(out.IncludedSeconds-out.ConsumedSeconds, 0)
-	out.OverageSeconds = max(out.ConsumedSeconds-out.IncludedSeconds, 0)
-	return &out, nil
-}
-
-func (s *Store) RecordRelayHealth(ctx context.Context, in RelayHealthInput) error {
-	const q = `
-insert into relay_health_events
-  (session_id, relay_instance_id, observed_at, ingest_active, egress_active, session_uptime_seconds, payload_json, created_at)
-select
-  s.id, s.relay_instance_id, $2, $3, $4, $5, $6, now()
-from sessions s
-where s.id = $1 and s.relay_instance_id is not null`
-	tag, err := s.db.Exec(ctx, q, in.SessionID, in.ObservedAt, in.IngestActive, in.EgressActive, in.SessionUptimeSeconds, in.RawPayload)
-	if err != nil {
-		return err
+	// A stopping session is counted when CompleteRelayTermination
+	// finalizes it.
+	if stopped && out.Status == model.SessionStopped && out.StoppedAt != nil {
+		obs.SessionStopped(out.Region, reason, out.StoppedAt.Sub(out.StartedAt))
 	}
-	if tag.RowsAffected() == 0 {
-		return fmt.Errorf("%w: no relay_instance bound for session", ErrRelayHealthRejected)
+	if pricedAtDefault {
+		obs.SessionsPricedAtDefault(pricedRegion, pricedType, 1)
 	}
-
-	_, err = s.db.Exec(ctx, `update relay_instances ri set last_health_at = $2 where ri.id = (select relay_instance_id from sessions where id = $1)`, in.SessionID, in.ObservedAt)
-	return err
+	return out, nil
 }
 
-func (s *Store) ListRelayManifest(ctx context.Context) ([]model.RelayManifestEntry, error) {
+// ClaimRelayTerminations leases up to limit due outbox entries so concurrent
+// workers do not terminate the same instance twice. A claimed entry becomes
+// due again after lease unless it is completed or rescheduled first.
+func (s *Store) ClaimRelayTerminations(ctx context.Context, limit int, lease time.Duration) (_ []model.RelayTermination, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
 	const q = `
-select region, ami_id, default_instance_type, updated_at
-from relay_manifests
-order by region asc`
+with due as (
+  select id
+  from relay_terminations
+  where completed_at is null and next_attempt_at <= now()
+  order by next_attempt_at asc
+  limit $1
+  for update skip locked
+)
+update relay_terminations rt
+set next_attempt_at = now() + make_interval(secs => $2)
+from due
+where rt.id = due.id
+returning rt.id, rt.session_id, rt.user_id, rt.region, rt.aws_instance_id, rt.attempts, coalesce(rt.reason, ''),
+  coalesce((select ri.eip_allocation_id from relay_instances ri where ri.aws_instance_id = rt.aws_instance_id
+    order by ri.created_at desc limit 1), ''),
+  coalesce((select ri.security_group_id from relay_instances ri where ri.aws_instance_id = rt.aws_instance_id
+    order by ri.created_at desc limit 1), ''),
+  coalesce((select ri.provider from relay_instances ri where ri.aws_instance_id = rt.aws_instance_id
+    order by ri.created_at desc limit 1), '')`
 
-	rows, err := s.db.Query(ctx, q)
+	rows, err := s.db.Query(ctx, q, limit, lease.Seconds())
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	out := make([]model.RelayManifestEntry, 0)
+	out := make([]model.RelayTermination, 0)
 	for rows.Next() {
-		var e model.RelayManifestEntry
-		if err := rows.Scan(&e.Region, &e.AMIID, &e.DefaultInstanceType, &e.UpdatedAt); err != nil {
+		var t model.RelayTermination
+		if err := rows.Scan(&t.ID, &t.SessionID, &t.UserID, &t.Region, &t.AWSInstanceID, &t.Attempts, &t.Reason, &t.EIPAllocationID, &t.SecurityGroupID, &t.Provider); err != nil {
 			return nil, err
 		}
-		out = append(out, e)
+		out = append(out, t)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -434,101 +911,1312 @@ order by region asc`
 	return out, nil
 }
 
-func (s *Store) UpsertRelayManifest(ctx context.Context, entries []model.RelayManifestEntry) error {
-	if len(entries) == 0 {
-		return nil
+// CompleteRelayTermination marks the outbox entry done and finalizes the
+// relay instance, recording how long its deprovision took. An unconfirmed
+// termination leaves the relay 'terminating' for the orphan reaper. A
+// stopping session becomes stopped once none of its relays (e.g. one being
+// replaced) are still pending termination.
+func (s *Store) CompleteRelayTermination(ctx context.Context, t model.RelayTermination, confirmed bool, took time.Duration) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	if _, err := tx.Exec(ctx, `
+update relay_terminations
+set completed_at = now(), attempts = attempts + 1, last_error = null
+where id = $1`, t.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+update relay_instances
+set state = case when $2 then 'terminated' else 'terminating' end,
+    terminated_at = case when $2 then coalesce(terminated_at, now()) end,
+    terminate_requested_at = now(),
+    terminating_since = coalesce(terminating_since, now()),
+    terminated_reason = coalesce(terminated_reason, nullif($3, '')),
+    terminate_duration_ms = $4
+where aws_instance_id = $1 and state <> 'terminated'`, t.AWSInstanceID, confirmed, t.Reason, took.Milliseconds()); err != nil {
+		return err
+	}
+	var region, reason string
+	var startedAt, stoppedAt time.Time
+	err = tx.QueryRow(ctx, `
+update sessions
+set status = 'stopped', updated_at = now()
+where id = $1 and status = 'stopping'
+  and not exists (
+    select 1 from relay_terminations
+    where session_id = $1 and completed_at is null
+  )
+returning region, coalesce(stop_reason, 'unknown'), started_at, coalesce(stopped_at, now())`, t.SessionID).Scan(&region, &reason, &startedAt, &stoppedAt)
+	finalized := err == nil
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	if finalized {
+		obs.SessionStopped(region, reason, stoppedAt.Sub(startedAt))
 	}
+	return nil
+}
+
+func (s *Store) RetryRelayTermination(ctx context.Context, id int64, lastErr string, nextAttemptAt time.Time) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	const q = `
+update relay_terminations
+set attempts = attempts + 1, last_error = $2, next_attempt_at = $3
+where id = $1 and completed_at is null`
+	_, err = s.db.Exec(ctx, q, id, lastErr, nextAttemptAt)
+	return err
+}
 
+// ListUnconfirmedTerminations returns relays still 'terminating', longest
+// waiting first.
+func (s *Store) ListUnconfirmedTerminations(ctx context.Context, limit int) (_ []model.TerminatingRelay, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	const q = `
+select id, coalesce(session_id, ''), region, aws_instance_id, coalesce(terminate_requested_at, now()),
+       coalesce(terminating_since, terminate_requested_at, now()), provider, coalesce(terminated_reason, '')
+from relay_instances
+where state = 'terminating'
+order by terminate_requested_at asc nulls first
+limit $1`
+	rows, err := s.db.Query(ctx, q, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []model.TerminatingRelay
+	for rows.Next() {
+		var t model.TerminatingRelay
+		if err := rows.Scan(&t.RelayInstanceID, &t.SessionID, &t.Region, &t.AWSInstanceID, &t.TerminateRequestedAt, &t.TerminatingSince, &t.Provider, &t.Reason); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// CountPendingTerminations counts relays whose termination is not yet
+// confirmed.
+func (s *Store) CountPendingTerminations(ctx context.Context) (_ int, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	const q = `select count(*) from relay_instances where state = 'terminating'`
+	var n int
+	err = s.db.QueryRow(ctx, q).Scan(&n)
+	return n, err
+}
+
+// InstanceSecondsByTerminateReason sums, per termination reason, how long
+// the relays terminated since since ran, "unknown" for relays terminated
+// before reasons were recorded.
+func (s *Store) InstanceSecondsByTerminateReason(ctx context.Context, since time.Time) (_ map[string]int64, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	const q = `
+select coalesce(terminated_reason, 'unknown'), coalesce(sum(extract(epoch from terminated_at - launched_at)), 0)::bigint
+from relay_instances
+where terminated_at >= $1
+group by 1`
+	rows, err := s.db.Query(ctx, q, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string]int64)
+	for rows.Next() {
+		var (
+			reason  string
+			seconds int64
+		)
+		if err := rows.Scan(&reason, &seconds); err != nil {
+			return nil, err
+		}
+		out[reason] = seconds
+	}
+	return out, rows.Err()
+}
+
+// CountStaleRelays counts the running relays of live sessions that have not
+// sent a heartbeat within heartbeatTimeout, as relay_replacement sees them.
+func (s *Store) CountStaleRelays(ctx context.Context, heartbeatTimeout time.Duration) (_ int, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	const q = `
+select count(*)
+from sessions s
+join relay_instances ri on ri.id = s.relay_instance_id
+where s.status in ('active', 'grace')
+  and ri.state = 'running'
+  and ri.last_health_at < now() - make_interval(secs => $1)`
+	var n int
+	err = s.db.QueryRow(ctx, q, heartbeatTimeout.Seconds()).Scan(&n)
+	return n, err
+}
+
+// CountSessionsByStatusRegion counts sessions not yet stopped, by status and
+// then region. Statuses and regions without sessions are absent.
+func (s *Store) CountSessionsByStatusRegion(ctx context.Context) (_ map[model.SessionStatus]map[string]int, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	const q = `
+select status, region, count(*)
+from sessions
+where status in ('provisioning', 'active', 'grace', 'stopping')
+group by status, region`
+	rows, err := s.db.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[model.SessionStatus]map[string]int)
+	for rows.Next() {
+		var (
+			status, region string
+			n              int
+		)
+		if err := rows.Scan(&status, &region, &n); err != nil {
+			return nil, err
+		}
+		byRegion := out[model.SessionStatus(status)]
+		if byRegion == nil {
+			byRegion = make(map[string]int)
+			out[model.SessionStatus(status)] = byRegion
+		}
+		byRegion[region] = n
+	}
+	return out, rows.Err()
+}
+
+// CountRelaysByAgentVersion counts the running relays of live sessions by
+// the agent version they last reported, "unknown" for relays that never did.
+func (s *Store) CountRelaysByAgentVersion(ctx context.Context) (_ map[string]int, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	const q = `
+select coalesce(ri.agent_version, 'unknown'), count(*)
+from sessions s
+join relay_instances ri on ri.id = s.relay_instance_id
+where s.status in ('active', 'grace')
+  and ri.state = 'running'
+group by 1`
+	rows, err := s.db.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string]int)
+	for rows.Next() {
+		var (
+			agentVersion string
+			n            int
+		)
+		if err := rows.Scan(&agentVersion, &n); err != nil {
+			return nil, err
+		}
+		out[agentVersion] = n
+	}
+	return out, rows.Err()
+}
+
+// MarkRelayTerminated confirms a termination for the orphan reaper. Relays
+// that were left terminating without a reason are attributed to it.
+func (s *Store) MarkRelayTerminated(ctx context.Context, relayInstanceID string) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	const q = `
+update relay_instances
+set state = 'terminated', terminated_at = coalesce(terminated_at, now()),
+    terminated_reason = coalesce(terminated_reason, 'orphan_reaper')
+where id = $1 and state = 'terminating'`
+	_, err = s.db.Exec(ctx, q, relayInstanceID)
+	return err
+}
+
+// RecordRelayTerminateRetry restarts the stuck-termination clock after the
+// reaper re-issues a termination.
+func (s *Store) RecordRelayTerminateRetry(ctx context.Context, relayInstanceID string) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	const q = `
+update relay_instances
+set terminate_requested_at = now()
+where id = $1 and state = 'terminating'`
+	_, err = s.db.Exec(ctx, q, relayInstanceID)
+	return err
+}
+
+// ListSessions returns the most recent sessions for operators. An empty
+// status lists every session that is not stopped; a tag lists only
+// sessions carrying it.
+func (s *Store) ListSessions(ctx context.Context, status, tag string, limit int) (_ []model.Session, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	const q = `
+select s.id, s.user_id, s.status, s.region, coalesce(ri.aws_instance_id, ''), coalesce(ri.lifecycle, ''),
+       coalesce(ri.public_ip::text, ''), coalesce(ri.subnet_id, ''), coalesce(ri.availability_zone, ''),
+       s.started_at, s.stopped_at, s.duration_seconds, s.billable, s.tags
+from sessions s
+left join relay_instances ri on ri.id = s.relay_instance_id
+where (($1 = '' and s.status <> 'stopped') or s.status = $1)
+  and ($3 = '' or s.tags @> array[$3::text])
+order by s.created_at desc
+limit $2`
+
+	rows, err := s.db.Query(ctx, q, status, limit, tag)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]model.Session, 0)
+	for rows.Next() {
+		var sess model.Session
+		if err := rows.Scan(
+			&sess.ID, &sess.UserID, &sess.Status, &sess.Region, &sess.RelayAWSInstanceID, &sess.RelayLifecycle,
+			&sess.PublicIP, &sess.RelaySubnetID, &sess.RelayAvailabilityZone, &sess.StartedAt, &sess.StoppedAt, &sess.DurationSeconds, &sess.Billable, &sess.Tags,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, sess)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetSessionRelay returns any user's session with its current relay, for
+// operators.
+func (s *Store) GetSessionRelay(ctx context.Context, sessionID string) (_ *model.SessionRelay, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	const q = `
+select s.id, s.user_id, s.status, coalesce(ri.id, ''), coalesce(ri.region, s.region),
+       coalesce(ri.aws_instance_id, ''), coalesce(ri.state, ''), coalesce(ri.public_ip::text, ''),
+       ri.launched_at, ri.terminated_at, ri.last_health_at, coalesce(ri.provider, ''),
+       coalesce(host(s.started_from_ip), ''), coalesce(s.started_user_agent, '')
+from sessions s
+left join relay_instances ri on ri.id = s.relay_instance_id
+where s.id = $1`
+
+	var out model.SessionRelay
+	if err := s.db.QueryRow(ctx, q, sessionID).Scan(
+		&out.SessionID, &out.UserID, &out.SessionStatus, &out.RelayInstanceID, &out.Region,
+		&out.AWSInstanceID, &out.State, &out.PublicIP,
+		&out.LaunchedAt, &out.TerminatedAt, &out.LastHealthAt, &out.Provider,
+		&out.StartedFromIP, &out.StartedUserAgent,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetSessionTimers returns any user's session with only its owner, status,
+// region and timers loaded, for heartbeats.
+func (s *Store) GetSessionTimers(ctx context.Context, sessionID string) (_ *model.Session, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	const q = `
+select id, user_id, status, region, started_at, stopped_at, grace_window_seconds, max_session_seconds
+from sessions
+where id = $1`
+
+	var out model.Session
+	if err := s.db.QueryRow(ctx, q, sessionID).Scan(
+		&out.ID, &out.UserID, &out.Status, &out.Region, &out.StartedAt, &out.StoppedAt, &out.GraceWindowSeconds, &out.MaxSessionSeconds,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetRelaySession returns a session, in any status, for the relay serving
+// it. The session's current relay must be awsInstanceID, so a relay that
+// was replaced gets ErrNotFound. Tokens are not loaded.
+func (s *Store) GetRelaySession(ctx context.Context, sessionID, awsInstanceID string) (_ *model.Session, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	const q = `
+select s.id, s.user_id, ri.id, ri.aws_instance_id, s.status, s.region, coalesce(ri.srt_port, 9000),
+       coalesce(ri.srt_ports, array[coalesce(ri.srt_port, 9000)]), s.started_at, s.stopped_at, s.grace_window_seconds, s.max_session_seconds
+from sessions s
+join relay_instances ri on ri.id = s.relay_instance_id
+where s.id = $1 and ri.aws_instance_id = $2`
+
+	var out model.Session
+	var relayInstanceID string
+	if err := s.db.QueryRow(ctx, q, sessionID, awsInstanceID).Scan(
+		&out.ID, &out.UserID, &relayInstanceID, &out.RelayAWSInstanceID, &out.Status, &out.Region, &out.SRTPort,
+		&out.SRTPorts, &out.StartedAt, &out.StoppedAt, &out.GraceWindowSeconds, &out.MaxSessionSeconds,
+	); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	out.RelayInstanceID = &relayInstanceID
+	return &out, nil
+}
+
+// MarkRelayInterrupted moves an active session into grace after its relay
+// reported a spot interruption notice. Repeated notices are accepted.
+func (s *Store) MarkRelayInterrupted(ctx context.Context, sessionID, awsInstanceID string) (_ *model.Session, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer rollback(tx)
+
+	const q = `
+update sessions s
+set status = 'grace', grace_started_at = coalesce(s.grace_started_at, now()), updated_at = now()
+from relay_instances ri
+where s.id = $1
+  and ri.id = s.relay_instance_id
+  and ri.aws_instance_id = $2
+  and s.status in ('active', 'grace')
+returning s.user_id`
+	var userID string
+	if err := tx.QueryRow(ctx, q, sessionID, awsInstanceID).Scan(&userID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	sess, err := s.getSessionByID(ctx, tx, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+// ListRelayReplacementCandidates returns live sessions whose relay may have
+// died: the session is in grace (e.g. after an interruption notice) or the
+// relay stopped sending heartbeats. Relays that never reported health are not
+// considered stale. Sessions with an unexpired replacement claim are skipped.
+func (s *Store) ListRelayReplacementCandidates(ctx context.Context, heartbeatTimeout, claimLease time.Duration, limit int) (_ []model.RelayCheck, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	const q = `
+select s.id, s.user_id, s.region, ri.id, ri.region, ri.aws_instance_id, s.relay_ws_token,
+       coalesce(ri.last_health_at < now() - make_interval(secs => $1), false),
+       ri.eip_allocation_id is not null, coalesce(host(ri.allowed_client_ip), ''), ri.provider
+from sessions s
+join relay_instances ri on ri.id = s.relay_instance_id
+where s.status in ('active', 'grace')
+  and ri.state = 'running'
+  and (s.status = 'grace' or ri.last_health_at < now() - make_interval(secs => $1))
+  and (s.replacement_claimed_at is null or s.replacement_claimed_at < now() - make_interval(secs => $2))
+order by coalesce(ri.last_health_at, s.started_at) asc
+limit $3`
+
+	rows, err := s.db.Query(ctx, q, heartbeatTimeout.Seconds(), claimLease.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]model.RelayCheck, 0)
+	for rows.Next() {
+		var c model.RelayCheck
+		if err := rows.Scan(&c.SessionID, &c.UserID, &c.Region, &c.RelayInstanceID, &c.RelayRegion, &c.AWSInstanceID, &c.RelayWSToken, &c.HeartbeatStale, &c.StaticIP, &c.ClientIP, &c.Provider); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ClaimRelayReplacement reserves a session for replacing relayInstanceID so
+// that concurrent workers do not launch two replacements. It reports false
+// when another worker holds an unexpired claim or the relay is no longer
+// bound to a live session.
+func (s *Store) ClaimRelayReplacement(ctx context.Context, sessionID, relayInstanceID string, lease time.Duration) (_ bool, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	const q = `
+update sessions
+set replacement_claimed_at = now(), updated_at = now()
+where id = $1
+  and relay_instance_id = $2
+  and status in ('active', 'grace')
+  and (replacement_claimed_at is null or replacement_claimed_at < now() - make_interval(secs => $3))`
+	tag, err := s.db.Exec(ctx, q, sessionID, relayInstanceID, lease.Seconds())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (s *Store) ReleaseRelayReplacement(ctx context.Context, sessionID string) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	_, err = s.db.Exec(ctx, `update sessions set replacement_claimed_at = null where id = $1`, sessionID)
+	return err
+}
+
+// ReplaceSessionRelay binds a replacement relay to a live session, keeping its
+// pair and relay tokens. The old relay is queued in relay_terminations and a
+// relay_replaced session event is recorded, all in one transaction. It returns
+// ErrNotFound if the session stopped or was rebound in the meantime.
+func (s *Store) ReplaceSessionRelay(ctx context.Context, in ReplaceSessionRelayInput) (_ *model.Session, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	srtPort, srtPorts := srtPortArgs(in.SRTPorts)
 	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer rollback(tx)
+
+	const retireQ = `
+update relay_instances
+set state = 'terminating', terminating_since = now(), terminated_reason = 'replaced'
+where id = $1 and state = 'running'`
+	if _, err := tx.Exec(ctx, retireQ, in.OldRelayInstanceID); err != nil {
+		return nil, err
+	}
+
+	relayID := "rly_" + uuid.NewString()
+	now := time.Now().UTC()
+	lifecycle := in.Lifecycle
+	if lifecycle == "" {
+		lifecycle = model.RelayLifecycleOnDemand
+	}
+	if _, err := tx.Exec(ctx, insertRelayInstanceQ,
+		relayID, in.SessionID, in.AWSInstanceID, in.Region, in.AMIID, in.InstanceType, lifecycle, in.PublicIP, srtPort, in.WSURL, now, in.EIPAllocationID, in.PublicIPv6,
+		in.SubnetID, in.AvailabilityZone, in.SecurityGroupID, in.AllowedClientIP, in.Provider, srtPorts,
+	); err != nil {
+		return nil, err
+	}
+
+	const rebindQ = `
+update sessions
+set relay_instance_id = $3,
+    status = 'active',
+    grace_started_at = null,
+    replacement_claimed_at = null,
+    updated_at = now()
+where id = $1 and relay_instance_id = $2 and status in ('active', 'grace')
+returning user_id`
+	var userID string
+	if err := tx.QueryRow(ctx, rebindQ, in.SessionID, in.OldRelayInstanceID, relayID).Scan(&userID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	const enqueueQ = `
+insert into relay_terminations (session_id, user_id, region, aws_instance_id, reason, next_attempt_at, created_at)
+values ($1, $2, $3, $4, 'replaced', now(), now())
+on conflict (aws_instance_id) where completed_at is null do nothing`
+	if _, err := tx.Exec(ctx, enqueueQ, in.SessionID, userID, in.OldRegion, in.OldAWSInstanceID); err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(map[string]any{
+		"session_id":      in.SessionID,
+		"reason":          in.Reason,
+		"old_instance_id": in.OldAWSInstanceID,
+		"instance_id":     in.AWSInstanceID,
+		"region":          in.Region,
+		"public_ip":       in.PublicIP,
+		"public_ipv6":     in.PublicIPv6,
+		"srt_port":        srtPort,
+		"srt_ports":       srtPorts,
+		"ws_url":          in.WSURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	const eventQ = `
+insert into session_events (session_id, user_id, event_type, payload_json, created_at)
+values ($1, $2, 'relay_replaced', $3, now())`
+	if _, err := tx.Exec(ctx, eventQ, in.SessionID, userID, payload); err != nil {
+		return nil, err
+	}
+
+	sess, err := s.getSessionByID(ctx, tx, userID, in.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+// AdvanceProvisioningPhase moves a provisioning session on to phase and
+// appends a provisioning_phase event. A phase the session has already
+// reached or passed, or a session no longer provisioning, is left alone.
+func (s *Store) AdvanceProvisioningPhase(ctx context.Context, userID, sessionID, phase string) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	const q = `
+with advanced as (
+  update sessions
+  set provisioning_phase = $3
+  where user_id = $1 and id = $2 and status = 'provisioning'
+    and coalesce(array_position($4::text[], provisioning_phase), 0) < array_position($4::text[], $3)
+  returning id, user_id
+)
+insert into session_events (session_id, user_id, event_type, payload_json, created_at)
+select id, user_id, 'provisioning_phase', jsonb_build_object('session_id', id, 'phase', $3::text), now()
+from advanced`
+	_, err = s.db.Exec(ctx, q, userID, sessionID, phase, model.ProvisioningPhases)
+	return err
+}
+
+// recordProvisioningPhaseTx appends the provisioning_phase event of a
+// session that reached phase in tx.
+func recordProvisioningPhaseTx(ctx context.Context, tx pgx.Tx, userID, sessionID, phase string) error {
+	const q = `
+insert into session_events (session_id, user_id, event_type, payload_json, created_at)
+values ($1, $2, 'provisioning_phase', jsonb_build_object('session_id', $1::text, 'phase', $3::text), now())`
+	_, err := tx.Exec(ctx, q, sessionID, userID, phase)
+	return err
+}
+
+// ListSessionEvents returns the user's session events with IDs above afterID,
+// oldest first.
+func (s *Store) ListSessionEvents(ctx context.Context, userID string, afterID int64, limit int) (_ []model.SessionEvent, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	const q = `
+select id, session_id, user_id, event_type, payload_json, created_at
+from session_events
+where user_id = $1 and id > $2
+order by id asc
+limit $3`
+
+	rows, err := s.db.Query(ctx, q, userID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]model.SessionEvent, 0)
+	for rows.Next() {
+		var e model.SessionEvent
+		if err := rows.Scan(&e.ID, &e.SessionID, &e.UserID, &e.Type, &e.Payload, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LatestSessionEventID returns the user's newest session event ID, or 0.
+func (s *Store) LatestSessionEventID(ctx context.Context, userID string) (_ int64, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	var id int64
+	err = s.db.QueryRow(ctx, `select coalesce(max(id), 0) from session_events where user_id = $1`, userID).Scan(&id)
+	return id, err
+}
+
+// RecordSessionEvent appends an event to the session's stream.
+func (s *Store) RecordSessionEvent(ctx context.Context, sessionID, userID, eventType string, payload any) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	const q = `
+insert into session_events (session_id, user_id, event_type, payload_json, created_at)
+values ($1, $2, $3, $4, now())`
+	_, err = s.db.Exec(ctx, q, sessionID, userID, eventType, b)
+	return err
+}
+
+// GetUsageCurrent reports usage for the user's current cycle. A user whose
+// cycle is not configured yet is put on the free tier with
+// freeIncludedSeconds, in a monthly cycle anchored on the account creation
+// date. ErrNotFound means the user does not exist.
+func (s *Store) GetUsageCurrent(ctx context.Context, userID string, freeIncludedSeconds int) (_ *model.UsageCurrent, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer rollback(tx)
+	out, err := usageCurrent(ctx, tx, userID, freeIncludedSeconds, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PreviewUsageCurrent reports what GetUsageCurrent would, in a read-only
+// transaction: a user whose cycle is not configured yet is reported on the
+// cycle GetUsageCurrent would set up, without recording it.
+func (s *Store) PreviewUsageCurrent(ctx context.Context, userID string, freeIncludedSeconds int) (_ *model.UsageCurrent, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	defer rollback(tx)
+	return usageCurrent(ctx, tx, userID, freeIncludedSeconds, false)
+}
+
+// usageCurrent reads the user's usage in tx, setting up a missing cycle
+// when setUp is true.
+func usageCurrent(ctx context.Context, tx pgx.Tx, userID string, freeIncludedSeconds int, setUp bool) (*model.UsageCurrent, error) {
+	var out model.UsageCurrent
+	var cycleStart, cycleEnd *time.Time
+	var createdAt time.Time
+	// The row is read without a lock: usage is read on every start, poll and
+	// heartbeat, and only a user without a cycle needs writing.
+	const userQ = `
+select plan_tier, cycle_start_at, cycle_end_at, included_seconds, created_at
+from users
+where id = $1`
+	if err := tx.QueryRow(ctx, userQ, userID).Scan(&out.PlanTier, &cycleStart, &cycleEnd, &out.IncludedSeconds, &createdAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	switch {
+	case cycleStart != nil && cycleEnd != nil:
+	case !setUp:
+		start, end := monthlyCycle(createdAt, time.Now())
+		cycleStart, cycleEnd = &start, &end
+		out.PlanTier, out.IncludedSeconds = "free", freeIncludedSeconds
+	default:
+		start, end := monthlyCycle(createdAt, time.Now())
+		// A concurrent read may set the cycle up first; then this matches
+		// nothing and the cycle it set is read back.
+		const initQ = `
+update users
+set plan_tier = $2, cycle_start_at = $3, cycle_end_at = $4, included_seconds = $5, updated_at = now()
+where id = $1 and (cycle_start_at is null or cycle_end_at is null)
+returning plan_tier, cycle_start_at, cycle_end_at, included_seconds`
+		err := tx.QueryRow(ctx, initQ, userID, "free", start, end, freeIncludedSeconds).Scan(&out.PlanTier, &cycleStart, &cycleEnd, &out.IncludedSeconds)
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = tx.QueryRow(ctx, userQ, userID).Scan(&out.PlanTier, &cycleStart, &cycleEnd, &out.IncludedSeconds, &createdAt)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, ErrNotFound
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if cycleStart == nil || cycleEnd == nil {
+			return nil, fmt.Errorf("usage cycle of user %s was not set up", userID)
+		}
+	}
+	out.CycleStart, out.CycleEnd = *cycleStart, *cycleEnd
+
+	const consumedQ = `
+select coalesce(sum(billable_seconds), 0)
+from usage_records
+where user_id = $1 and cycle_start_at = $2 and cycle_end_at = $3`
+	if err := tx.QueryRow(ctx, consumedQ, userID, out.CycleStart, out.CycleEnd).Scan(&out.ConsumedSeconds); err != nil {
+		return nil, err
+	}
+	live, err := liveUsageSeconds(ctx, tx, userID, out.CycleStart, out.CycleEnd)
+	if err != nil {
+		return nil, err
+	}
+	out.ConsumedSeconds += live
+	out.RemainingSeconds = max(out.IncludedSeconds-out.ConsumedSeconds, 0)
+	out.OverageSeconds = max(out.ConsumedSeconds-out.IncludedSeconds, 0)
+	return &out, nil
+}
+
+// liveUsageSeconds is the time live sessions in the cycle have run beyond
+// what the last rollup recorded for them, so usage is current to the second
+// between rollups without counting rolled-up time twice.
+func liveUsageSeconds(ctx context.Context, tx pgx.Tx, userID string, cycleStart, cycleEnd time.Time) (int, error) {
+	const q = `
+select
+  floor(extract(epoch from (now() - s.started_at)))::integer,
+  coalesce(ur.billable_seconds, 0)
+from sessions s
+left join usage_records ur
+  on ur.session_id = s.id
+ and ur.cycle_start_at = $2
+ and ur.cycle_end_at = $3
+where s.user_id = $1
+  and s.status in ('active', 'grace')
+  and s.billable
+  and s.started_at >= $2
+  and s.started_at <= $3`
+	rows, err := tx.Query(ctx, q, userID, cycleStart, cycleEnd)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var total int
+	for rows.Next() {
+		var elapsed, rolledUp int
+		if err := rows.Scan(&elapsed, &rolledUp); err != nil {
+			return 0, err
+		}
+		total += max(elapsed-rolledUp, 0)
+	}
+	return total, rows.Err()
+}
+
+// monthlyCycle returns the month-long cycle, counted from anchor, that
+// contains now.
+func monthlyCycle(anchor, now time.Time) (time.Time, time.Time) {
+	anchor, now = anchor.UTC(), now.UTC()
+	months := (now.Year()-anchor.Year())*12 + int(now.Month()-anchor.Month())
+	if months < 0 {
+		months = 0
+	}
+	if months > 0 && anchor.AddDate(0, months, 0).After(now) {
+		months--
+	}
+	return anchor.AddDate(0, months, 0), anchor.AddDate(0, months+1, 0)
+}
+
+// RecordRelayHealth stores one health event; see RecordRelayHealthBatch.
+// An event for a session without a bound relay returns
+// ErrRelayHealthRejected.
+func (s *Store) RecordRelayHealth(ctx context.Context, in RelayHealthInput) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	rejected, err := s.recordRelayHealth(ctx, []RelayHealthInput{in})
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback(ctx)
+	return rejected[0]
+}
 
+// MaxRelayHealthBatch is the most events RecordRelayHealthBatch takes.
+const MaxRelayHealthBatch = 100
+
+// RecordRelayHealthBatch stores events and moves each relay's
+// last_health_at to the latest observed_at among them, in one statement.
+// rejected[i] wraps ErrRelayHealthRejected when events[i] was not stored
+// because its session is unknown or has no bound relay; the other events
+// are stored regardless. err is set only when the statement failed, in
+// which case nothing was stored.
+func (s *Store) RecordRelayHealthBatch(ctx context.Context, events []RelayHealthInput) (rejected []error, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	if len(events) > MaxRelayHealthBatch {
+		return nil, fmt.Errorf("relay health batch of %d events exceeds %d", len(events), MaxRelayHealthBatch)
+	}
+	return s.recordRelayHealth(ctx, events)
+}
+
+func (s *Store) recordRelayHealth(ctx context.Context, events []RelayHealthInput) ([]error, error) {
+	rejected := make([]error, len(events))
+	if len(events) == 0 {
+		return rejected, nil
+	}
+	sessionIDs := make([]string, len(events))
+	observedAt := make([]time.Time, len(events))
+	ingest := make([]bool, len(events))
+	egress := make([]bool, len(events))
+	uptime := make([]int32, len(events))
+	payloads := make([]string, len(events))
+	agentVersions := make([]string, len(events))
+	outdated := make([]bool, len(events))
+	for i, e := range events {
+		sessionIDs[i], observedAt[i], ingest[i], egress[i] = e.SessionID, e.ObservedAt, e.IngestActive, e.EgressActive
+		uptime[i], payloads[i] = int32(e.SessionUptimeSeconds), string(e.RawPayload)
+		agentVersions[i], outdated[i] = e.AgentVersion, e.AgentOutdated
+	}
+
+	// Rejection depends only on the session, so the sessions of the stored
+	// events tell which events were rejected.
 	const q = `
-insert into relay_manifests (region, ami_id, default_instance_type, updated_at)
-values ($1, $2, $3, now())
-on conflict (region)
-do update set
-  ami_id = excluded.ami_id,
-  default_instance_type = excluded.default_instance_type,
-  updated_at = now()`
-	for _, e := range entries {
-		if _, err := tx.Exec(ctx, q, e.Region, e.AMIID, e.DefaultInstanceType); err != nil {
-			return err
+with input as (
+  select *
+  from unnest($1::text[], $2::timestamptz[], $3::boolean[], $4::boolean[], $5::integer[], $6::text[], $7::text[], $8::boolean[])
+    as t(session_id, observed_at, ingest_active, egress_active, session_uptime_seconds, payload_json, agent_version, agent_outdated)
+), inserted as (
+  insert into relay_health_events
+    (session_id, relay_instance_id, observed_at, ingest_active, egress_active, session_uptime_seconds, payload_json, agent_version, agent_outdated, created_at)
+  select s.id, s.relay_instance_id, i.observed_at, i.ingest_active, i.egress_active, i.session_uptime_seconds, i.payload_json::jsonb,
+         nullif(i.agent_version, ''), i.agent_outdated, now()
+  from input i
+  join sessions s on s.id = i.session_id and s.relay_instance_id is not null
+  returning session_id, relay_instance_id, observed_at, agent_version
+), touched as (
+  update relay_instances ri
+  set last_health_at = latest.observed_at,
+      agent_version = coalesce(latest.agent_version, ri.agent_version)
+  from (
+    select distinct on (relay_instance_id) relay_instance_id, observed_at, agent_version
+    from inserted
+    order by relay_instance_id, observed_at desc
+  ) latest
+  where ri.id = latest.relay_instance_id
+)
+select distinct session_id from inserted`
+	rows, err := s.db.Query(ctx, q, sessionIDs, observedAt, ingest, egress, uptime, payloads, agentVersions, outdated)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stored := make(map[string]bool, len(events))
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		stored[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, e := range events {
+		if !stored[e.SessionID] {
+			rejected[i] = fmt.Errorf("%w: no relay_instance bound for session", ErrRelayHealthRejected)
+		}
+	}
+	return rejected, nil
+}
+
+// ListRelayHealth returns a session's most recent relay heartbeats, newest
+// first.
+func (s *Store) ListRelayHealth(ctx context.Context, sessionID string, limit int) (_ []model.RelayHealthEvent, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	const q = `
+select id, session_id, relay_instance_id, observed_at, ingest_active, egress_active, session_uptime_seconds, created_at
+from relay_health_events
+where session_id = $1
+order by observed_at desc, id desc
+limit $2`
+	rows, err := s.db.Query(ctx, q, sessionID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]model.RelayHealthEvent, 0)
+	for rows.Next() {
+		var e model.RelayHealthEvent
+		if err := rows.Scan(&e.ID, &e.SessionID, &e.RelayInstanceID, &e.ObservedAt, &e.IngestActive, &e.EgressActive, &e.SessionUptimeSeconds, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (s *Store) ListRelayManifest(ctx context.Context) (_ []model.RelayManifestEntry, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	const q = `
+select region, ami_id, default_instance_type, available, updated_at, provider, enabled
+from relay_manifests
+order by region asc`
+
+	rows, err := s.db.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]model.RelayManifestEntry, 0)
+	for rows.Next() {
+		var e model.RelayManifestEntry
+		if err := rows.Scan(&e.Region, &e.AMIID, &e.DefaultInstanceType, &e.Available, &e.UpdatedAt, &e.Provider, &e.Enabled); err != nil {
+			return nil, err
 		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UpsertRelayManifest writes entries in one statement. With prune it also
+// deletes the regions entries leave out, so that a region dropped from
+// config can no longer be started into. An empty entries is a no-op either
+// way rather than a wipe. Entries' Enabled is ignored: new regions start
+// enabled and existing ones keep their switch.
+func (s *Store) UpsertRelayManifest(ctx context.Context, entries []model.RelayManifestEntry, prune bool) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	if len(entries) == 0 {
+		return nil
+	}
+
+	regions := make([]string, len(entries))
+	amis := make([]string, len(entries))
+	instanceTypes := make([]string, len(entries))
+	available := make([]bool, len(entries))
+	providers := make([]string, len(entries))
+	for i, e := range entries {
+		regions[i], amis[i], instanceTypes[i], available[i], providers[i] = e.Region, e.AMIID, e.DefaultInstanceType, e.Available, e.Provider
+	}
+
+	// The delete runs against the snapshot from before the insert, so it
+	// only ever sees rows the input leaves out.
+	const q = `
+with input as (
+  select *
+  from unnest($1::text[], $2::text[], $3::text[], $4::boolean[], $5::text[])
+    as t(region, ami_id, default_instance_type, available, provider)
+), upserted as (
+  insert into relay_manifests (region, ami_id, default_instance_type, available, provider, updated_at)
+  select region, ami_id, default_instance_type, available, provider, now()
+  from input
+  on conflict (region)
+  do update set
+    ami_id = excluded.ami_id,
+    default_instance_type = excluded.default_instance_type,
+    available = excluded.available,
+    provider = excluded.provider,
+    updated_at = now()
+)
+delete from relay_manifests
+where $6::boolean and region not in (select region from input)`
+	_, err = s.db.Exec(ctx, q, regions, amis, instanceTypes, available, providers, prune)
+	return err
+}
+
+// RelayManifestUpdate changes one region's manifest entry; nil and empty
+// fields keep their current value.
+type RelayManifestUpdate struct {
+	Region              string
+	Available           *bool
+	AMIID               string
+	DefaultInstanceType string
+	Enabled             *bool
+}
+
+// UpdateRelayManifestEntry applies an operator's change to a region. The
+// API rewrites the manifest from config at startup and on config reload, so
+// changes other than Enabled last until then. ErrNotFound means the region
+// has no entry.
+func (s *Store) UpdateRelayManifestEntry(ctx context.Context, in RelayManifestUpdate) (_ *model.RelayManifestEntry, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	const q = `
+update relay_manifests
+set available = coalesce($2, available),
+    ami_id = coalesce(nullif($3, ''), ami_id),
+    default_instance_type = coalesce(nullif($4, ''), default_instance_type),
+    enabled = coalesce($5, enabled),
+    updated_at = now()
+where region = $1
+returning region, ami_id, default_instance_type, available, updated_at, provider, enabled`
+	var e model.RelayManifestEntry
+	err = s.db.QueryRow(ctx, q, in.Region, in.Available, in.AMIID, in.DefaultInstanceType, in.Enabled).
+		Scan(&e.Region, &e.AMIID, &e.DefaultInstanceType, &e.Available, &e.UpdatedAt, &e.Provider, &e.Enabled)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
 	}
-	return tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
 }
 
-func (s *Store) CleanupExpiredIdempotencyRecords(ctx context.Context) error {
-	_, err := s.db.Exec(ctx, `delete from idempotency_records where expires_at <= now()`)
+func (s *Store) CleanupExpiredIdempotencyRecords(ctx context.Context) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Rollup)
+	defer done(&err)
+	_, err = s.db.Exec(ctx, `delete from idempotency_records where expires_at <= now()`)
 	return err
 }
 
-func (s *Store) RollupLiveSessionDurations(ctx context.Context) error {
+// RollupLiveSessionDurations raises live sessions' durations to their
+// elapsed time and returns how many it changed. Every live session changes
+// on each run, so it keeps no cursor; stopped sessions are never read.
+// Non-billable sessions are included: only UpsertUsageRollups turns
+// durations into usage.
+func (s *Store) RollupLiveSessionDurations(ctx context.Context) (_ int, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Rollup)
+	defer done(&err)
 	const q = `
 update sessions
-set duration_seconds = greatest(
-      duration_seconds,
-      floor(extract(epoch from (now() - started_at)))::integer
-    ),
+set duration_seconds = floor(extract(epoch from (now() - started_at)))::integer,
     updated_at = now()
 where status in ('active', 'grace')
-  and started_at <= now()`
-	_, err := s.db.Exec(ctx, q)
-	return err
+  and started_at <= now()
+  and duration_seconds < floor(extract(epoch from (now() - started_at)))::integer`
+	tag, err := s.db.Exec(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
 }
 
-func (s *Store) ReconcileOutageFromHealth(ctx context.Context) error {
+// watermarkOverlap is how far before its high-water mark an incremental
+// rollup rereads, for rows whose write committed after a later one was read.
+// Rereading is harmless: the rollups only raise durations and skip rows
+// whose values are unchanged.
+const watermarkOverlap = "5 minutes"
+
+// ReconcileOutageFromHealth raises session durations to the uptime relays
+// last reported and returns how many sessions it changed. It reads only the
+// events created since its previous run, tracked in job_watermarks, rather
+// than the whole table.
+func (s *Store) ReconcileOutageFromHealth(ctx context.Context) (_ int, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Rollup)
+	defer done(&err)
 	const q = `
-with latest as (
+with mark as (
+  select coalesce(
+    (select high_water from job_watermarks where job = 'outage_reconciliation'),
+    '-infinity'::timestamptz
+  ) - interval '` + watermarkOverlap + `' as since
+), recent as (
+  select e.id, e.session_id, e.session_uptime_seconds, e.observed_at, e.created_at, e.agent_outdated
+  from relay_health_events e, mark
+  where e.created_at > mark.since
+), latest as (
+  -- Events from outdated agents are skipped but still advance the mark.
   select distinct on (session_id)
     session_id,
     session_uptime_seconds
-  from relay_health_events
+  from recent
+  where not agent_outdated
   order by session_id, observed_at desc, id desc
+), reconciled as (
+  update sessions s
+  set reconciled_seconds = greatest(s.reconciled_seconds, latest.session_uptime_seconds),
+      duration_seconds = greatest(s.duration_seconds, latest.session_uptime_seconds),
+      updated_at = now()
+  from latest
+  where s.id = latest.session_id
+    and s.status in ('active', 'grace', 'stopping', 'stopped')
+    and (s.reconciled_seconds < latest.session_uptime_seconds or s.duration_seconds < latest.session_uptime_seconds)
+  returning s.id
+), advanced as (
+  insert into job_watermarks (job, high_water, updated_at)
+  select 'outage_reconciliation', max(created_at), now()
+  from recent
+  having count(*) > 0
+  on conflict (job)
+  do update set high_water = greatest(job_watermarks.high_water, excluded.high_water), updated_at = now()
 )
-update sessions s
-set reconciled_seconds = greatest(s.reconciled_seconds, latest.session_uptime_seconds),
-    duration_seconds = greatest(s.duration_seconds, latest.session_uptime_seconds),
+select count(*) from reconciled`
+	var n int
+	err = s.db.QueryRow(ctx, q).Scan(&n)
+	return n, err
+}
+
+// DeleteRelayHealthEventsBefore deletes up to limit of the oldest health
+// events created before cutoff and returns how many it deleted; callers
+// repeat it until that is below limit.
+func (s *Store) DeleteRelayHealthEventsBefore(ctx context.Context, cutoff time.Time, limit int) (_ int, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Rollup)
+	defer done(&err)
+	const q = `
+delete from relay_health_events
+where id in (
+  select id
+  from relay_health_events
+  where created_at < $1
+  order by created_at
+  limit $2
+)`
+	tag, err := s.db.Exec(ctx, q, cutoff, limit)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// UpsertUsageRollups writes each session's usage record for its user's
+// current cycle, non-billable sessions aside, and returns how many records it
+// wrote. It reads only the
+// sessions and users updated since its previous run, tracked in
+// job_watermarks, and leaves records whose seconds are unchanged alone.
+func (s *Store) UpsertUsageRollups(ctx context.Context) (_ int, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Rollup)
+	defer done(&err)
+	const q = `
+with mark as (
+  select coalesce(
+    (select high_water from job_watermarks where job = 'usage_rollup'),
+    '-infinity'::timestamptz
+  ) - interval '` + watermarkOverlap + `' as since
+), changed as (
+  select s.id
+  from sessions s, mark
+  where s.status in ('active', 'grace', 'stopping', 'stopped')
+    and s.updated_at > mark.since
+    and s.billable
+  union
+  select s.id
+  from users u
+  join sessions s on s.user_id = u.id
+  cross join mark
+  where u.updated_at > mark.since
+    and s.status in ('active', 'grace', 'stopping', 'stopped')
+    and s.billable
+), candidates as (
+  select s.id, s.user_id, s.started_at, s.duration_seconds, s.reconciled_seconds,
+         u.cycle_start_at, u.cycle_end_at, greatest(s.updated_at, u.updated_at) as changed_at
+  from changed c
+  join sessions s on s.id = c.id
+  join users u on u.id = s.user_id
+), upserted as (
+  insert into usage_records
+    (id, user_id, session_id, cycle_start_at, cycle_end_at, measured_seconds, reconciled_seconds, billable_seconds, overage_seconds, created_at, updated_at)
+  select
+    'use_' || c.id,
+    c.user_id,
+    c.id,
+    c.cycle_start_at,
+    c.cycle_end_at,
+    c.duration_seconds,
+    c.reconciled_seconds,
+    greatest(c.duration_seconds, c.reconciled_seconds),
+    0,
+    now(),
+    now()
+  from candidates c
+  where c.started_at >= c.cycle_start_at
+    and c.started_at <= c.cycle_end_at
+  on conflict (id)
+  do update set
+    measured_seconds = excluded.measured_seconds,
+    reconciled_seconds = excluded.reconciled_seconds,
+    billable_seconds = excluded.billable_seconds,
     updated_at = now()
-from latest
-where s.id = latest.session_id
-  and s.status in ('active', 'grace', 'stopped')`
-	_, err := s.db.Exec(ctx, q)
-	return err
+  where (usage_records.measured_seconds, usage_records.reconciled_seconds)
+    is distinct from (excluded.measured_seconds, excluded.reconciled_seconds)
+  returning id
+), advanced as (
+  insert into job_watermarks (job, high_water, updated_at)
+  select 'usage_rollup', max(changed_at), now()
+  from candidates
+  having count(*) > 0
+  on conflict (job)
+  do update set high_water = greatest(job_watermarks.high_water, excluded.high_water), updated_at = now()
+)
+select count(*) from upserted`
+	var n int
+	err = s.db.QueryRow(ctx, q).Scan(&n)
+	return n, err
 }
 
-func (s *Store) UpsertUsageRollups(ctx context.Context) error {
+// RecordUsageAlerts records each usage threshold, in percent of included
+// seconds, that users have newly crossed in their current cycle, and
+// notifies the user's live session and webhooks with a
+// usage_threshold_crossed event. Each threshold is returned once per cycle.
+func (s *Store) RecordUsageAlerts(ctx context.Context, thresholds []int) (_ []model.UsageAlert, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Rollup)
+	defer done(&err)
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer rollback(tx)
+
 	const q = `
-insert into usage_records
-  (id, user_id, session_id, cycle_start_at, cycle_end_at, measured_seconds, reconciled_seconds, billable_seconds, overage_seconds, created_at, updated_at)
-select
-  'use_' || s.id,
-  s.user_id,
-  s.id,
-  u.cycle_start_at,
-  u.cycle_end_at,
-  s.duration_seconds,
-  s.reconciled_seconds,
-  greatest(s.duration_seconds, s.reconciled_seconds),
-  0,
-  now(),
-  now()
+with usage as (
+  select u.id as user_id, u.cycle_start_at, u.included_seconds, sum(ur.billable_seconds)::integer as consumed_seconds
+  from users u
+  join usage_records ur
+    on ur.user_id = u.id
+   and ur.cycle_start_at = u.cycle_start_at
+   and ur.cycle_end_at = u.cycle_end_at
+  where u.included_seconds > 0
+  group by u.id, u.cycle_start_at, u.included_seconds
+)
+insert into usage_alerts (user_id, cycle_start_at, threshold_percent, consumed_seconds, included_seconds, created_at)
+select usage.user_id, usage.cycle_start_at, t.threshold, usage.consumed_seconds, usage.included_seconds, now()
+from usage
+cross join unnest($1::integer[]) as t(threshold)
+where usage.consumed_seconds::bigint * 100 >= usage.included_seconds::bigint * t.threshold
+on conflict do nothing
+returning user_id, cycle_start_at, threshold_percent, consumed_seconds, included_seconds`
+	rows, err := tx.Query(ctx, q, thresholds)
+	if err != nil {
+		return nil, err
+	}
+	var alerts []model.UsageAlert
+	for rows.Next() {
+		var a model.UsageAlert
+		if err := rows.Scan(&a.UserID, &a.CycleStart, &a.ThresholdPercent, &a.ConsumedSeconds, &a.IncludedSeconds); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	const eventQ = `
+insert into session_events (session_id, user_id, event_type, payload_json, created_at)
+select s.id, s.user_id, 'usage_threshold_crossed', jsonb_build_object('session_id', s.id) || $2::jsonb, now()
 from sessions s
-join users u on u.id = s.user_id
-where s.status in ('active', 'grace', 'stopped')
-  and s.started_at >= u.cycle_start_at
-  and s.started_at <= u.cycle_end_at
-on conflict (id)
-do update set
-  measured_seconds = excluded.measured_seconds,
-  reconciled_seconds = excluded.reconciled_seconds,
-  billable_seconds = excluded.billable_seconds,
-  updated_at = now()`
-	_, err := s.db.Exec(ctx, q)
-	return err
+where s.user_id = $1 and s.status in ('active', 'grace')
+limit 1
+returning session_id`
+	for i, a := range alerts {
+		payload, err := json.Marshal(map[string]any{
+			"threshold_percent": a.ThresholdPercent,
+			"consumed_seconds":  a.ConsumedSeconds,
+			"included_seconds":  a.IncludedSeconds,
+			"cycle_start":       a.CycleStart.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = tx.QueryRow(ctx, eventQ, a.UserID, payload).Scan(&alerts[i].SessionID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+		if err := enqueueWebhookEvent(ctx, tx, a.UserID, model.WebhookUsageThreshold, map[string]any{
+			"session_id":        alerts[i].SessionID,
+			"threshold_percent": a.ThresholdPercent,
+			"consumed_seconds":  a.ConsumedSeconds,
+			"included_seconds":  a.IncludedSeconds,
+			"cycle_start":       a.CycleStart.UTC().Format(time.RFC3339),
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return alerts, nil
 }
 
 func strPtr(v string) *string {
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+)
+
+func TestRecordRelayHealthBatch_RejectsPerEvent(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	t0 := time.Now().UTC()
+	events := []RelayHealthInput{
+		{SessionID: "ses_1", ObservedAt: t0, IngestActive: true, SessionUptimeSeconds: 10, RawPayload: json.RawMessage(`{"a":1}`)},
+		{SessionID: "ses_unbound", ObservedAt: t0, SessionUptimeSeconds: 3, RawPayload: json.RawMessage(`{}`)},
+		{SessionID: "ses_1", ObservedAt: t0.Add(time.Second), EgressActive: true, SessionUptimeSeconds: 11, RawPayload: json.RawMessage(`{"a":2}`), AgentVersion: "1.2.0", AgentOutdated: true},
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("insert into relay_health_events")).
+		WithArgs(
+			[]string{"ses_1", "ses_unbound", "ses_1"},
+			[]time.Time{t0, t0, t0.Add(time.Second)},
+			[]bool{true, false, false},
+			[]bool{false, false, true},
+			[]int32{10, 3, 11},
+			[]string{`{"a":1}`, `{}`, `{"a":2}`},
+			[]string{"", "", "1.2.0"},
+			[]bool{false, false, true},
+		).
+		WillReturnRows(pgxmock.NewRows([]string{"session_id"}).AddRow("ses_1"))
+
+	rejected, err := New(mock).RecordRelayHealthBatch(context.Background(), events)
+	if err != nil {
+		t.Fatalf("RecordRelayHealthBatch: %v", err)
+	}
+	if rejected[0] != nil || rejected[2] != nil || !errors.Is(rejected[1], ErrRelayHealthRejected) {
+		t.Fatalf("expected only the unbound session's event rejected, got %v", rejected)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+
+	if _, err := New(mock).RecordRelayHealthBatch(context.Background(), make([]RelayHealthInput, MaxRelayHealthBatch+1)); err == nil {
+		t.Fatal("expected an oversized batch to be refused")
+	}
+}
+
+func TestRecordRelayHealth_UnboundSessionRejected(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("insert into relay_health_events")).
+		WithArgs([]string{"ses_1"}, pgxmock.AnyArg(), []bool{true}, []bool{true}, []int32{5}, []string{`{}`}, []string{""}, []bool{false}).
+		WillReturnRows(pgxmock.NewRows([]string{"session_id"}))
+
+	err = New(mock).RecordRelayHealth(context.Background(), RelayHealthInput{
+		SessionID: "ses_1", ObservedAt: time.Now(), IngestActive: true, EgressActive: true, SessionUptimeSeconds: 5, RawPayload: json.RawMessage(`{}`),
+	})
+	if !errors.Is(err, ErrRelayHealthRejected) {
+		t.Fatalf("expected ErrRelayHealthRejected, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestDeleteRelayHealthEventsBefore_ReturnsDeletedCount(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	cutoff := time.Now().Add(-30 * 24 * time.Hour)
+	mock.ExpectExec(regexp.QuoteMeta("delete from relay_health_events")).
+		WithArgs(cutoff, 500).
+		WillReturnResult(pgxmock.NewResult("DELETE", 500))
+
+	n, err := New(mock).DeleteRelayHealthEventsBefore(context.Background(), cutoff, 500)
+	if err != nil || n != 500 {
+		t.Fatalf("expected 500 deleted, got %d err=%v", n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
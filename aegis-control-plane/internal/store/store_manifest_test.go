@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+func TestUpdateRelayManifestEntry_UnknownRegion(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	available := false
+	mock.ExpectQuery(regexp.QuoteMeta("update relay_manifests")).
+		WithArgs("mars-1", &available, "", "", (*bool)(nil)).
+		WillReturnError(pgx.ErrNoRows)
+
+	_, err = New(mock).UpdateRelayManifestEntry(context.Background(), RelayManifestUpdate{Region: "mars-1", Available: &available})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUpdateRelayManifestEntry_SwitchesRegionOff(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	enabled := false
+	mock.ExpectQuery(regexp.QuoteMeta("enabled = coalesce($5, enabled)")).
+		WithArgs("us-east-1", (*bool)(nil), "", "", &enabled).
+		WillReturnRows(pgxmock.NewRows([]string{"region", "ami_id", "default_instance_type", "available", "updated_at", "provider", "enabled"}).
+			AddRow("us-east-1", "ami-1", "t4g.small", true, time.Now(), "aws", false))
+
+	e, err := New(mock).UpdateRelayManifestEntry(context.Background(), RelayManifestUpdate{Region: "us-east-1", Enabled: &enabled})
+	if err != nil {
+		t.Fatalf("UpdateRelayManifestEntry: %v", err)
+	}
+	if e.Enabled || !e.Available || e.AMIID != "ami-1" {
+		t.Fatalf("expected the region disabled with the rest kept, got %+v", e)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestUpsertRelayManifest_OneStatement(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("unnest($1::text[], $2::text[], $3::text[], $4::boolean[], $5::text[])")).
+		WithArgs(
+			[]string{"us-east-1", "eu-central-1"},
+			[]string{"ami-1", "debian-12"},
+			[]string{"t4g.small", "cx22"},
+			[]bool{true, false},
+			[]string{"aws", "hetzner"},
+			true,
+		).
+		WillReturnResult(pgxmock.NewResult("DELETE", 1))
+
+	err = New(mock).UpsertRelayManifest(context.Background(), []model.RelayManifestEntry{
+		{Region: "us-east-1", AMIID: "ami-1", DefaultInstanceType: "t4g.small", Available: true, Provider: "aws"},
+		{Region: "eu-central-1", AMIID: "debian-12", DefaultInstanceType: "cx22", Provider: "hetzner"},
+	}, true)
+	if err != nil {
+		t.Fatalf("UpsertRelayManifest: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
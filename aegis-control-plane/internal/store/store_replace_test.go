@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+func TestReplaceSessionRelay_RebindsAndQueuesOldInstance(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	startedAt := time.Now().UTC().Add(-time.Hour)
+	queryPrefix := "select s.id, s.user_id, coalesce(s.relay_instance_id, ''), coalesce(ri.aws_instance_id, ''), s.status, s.region, s.pair_token, s.relay_ws_token,"
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("update relay_instances")).
+		WithArgs("rly_old").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec(regexp.QuoteMeta("insert into relay_instances")).
+		WithArgs(pgxmock.AnyArg(), "ses_1", "i-new", "us-east-1", "ami-1", "t4g.small", "spot", "198.51.100.9", 9000, "wss://198.51.100.9:7443/telemetry", pgxmock.AnyArg(), "", "", "", "", "", "", "aws", []int{9000}).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectQuery(regexp.QuoteMeta("update sessions")).
+		WithArgs("ses_1", "rly_old", pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"user_id"}).AddRow("usr_1"))
+	mock.ExpectExec(regexp.QuoteMeta("insert into relay_terminations")).
+		WithArgs("ses_1", "usr_1", "us-east-1", "i-old").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectExec(regexp.QuoteMeta("insert into session_events")).
+		WithArgs("ses_1", "usr_1", pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectQuery(regexp.QuoteMeta(queryPrefix)).
+		WithArgs("usr_1", "ses_1").
+		WillReturnRows(sessionRowWithTimes("ses_1", "usr_1", "rly_new", "i-new", string(model.SessionActive), startedAt, nil))
+	mock.ExpectCommit()
+
+	s := New(mock)
+	out, err := s.ReplaceSessionRelay(context.Background(), ReplaceSessionRelayInput{
+		SessionID:          "ses_1",
+		OldRelayInstanceID: "rly_old",
+		OldRegion:          "us-east-1",
+		OldAWSInstanceID:   "i-old",
+		Reason:             "instance_terminated",
+		Region:             "us-east-1",
+		AWSInstanceID:      "i-new",
+		AMIID:              "ami-1",
+		InstanceType:       "t4g.small",
+		Lifecycle:          "spot",
+		PublicIP:           "198.51.100.9",
+		SRTPorts:           []int{9000},
+		WSURL:              "wss://198.51.100.9:7443/telemetry",
+		Provider:           "aws",
+	})
+	if err != nil {
+		t.Fatalf("ReplaceSessionRelay returned err: %v", err)
+	}
+	if out.RelayAWSInstanceID != "i-new" || out.PairToken != "ABCDEFGH" {
+		t.Fatalf("unexpected session: %+v", out)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestReplaceSessionRelay_SessionNoLongerLive(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("update relay_instances")).
+		WithArgs("rly_old").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	mock.ExpectExec(regexp.QuoteMeta("insert into relay_instances")).
+		WithArgs(pgxmock.AnyArg(), "ses_1", "i-new", "us-east-1", "", "", "on-demand", "", 9000, "", pgxmock.AnyArg(), "", "", "", "", "", "", "", []int(nil)).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectQuery(regexp.QuoteMeta("update sessions")).
+		WithArgs("ses_1", "rly_old", pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"user_id"}))
+	mock.ExpectRollback()
+
+	s := New(mock)
+	_, err = s.ReplaceSessionRelay(context.Background(), ReplaceSessionRelayInput{
+		SessionID:          "ses_1",
+		OldRelayInstanceID: "rly_old",
+		Region:             "us-east-1",
+		AWSInstanceID:      "i-new",
+	})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestClaimRelayReplacement_ReportsLostClaim(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("update sessions")).
+		WithArgs("ses_1", "rly_1", float64(600)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+
+	s := New(mock)
+	claimed, err := s.ClaimRelayReplacement(context.Background(), "ses_1", "rly_1", 10*time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimRelayReplacement returned err: %v", err)
+	}
+	if claimed {
+		t.Fatal("expected claim to be lost")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
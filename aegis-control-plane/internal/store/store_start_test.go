@@ -0,0 +1,564 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+func TestStartOrGetSession_KeyReusedOnAnotherEndpointIsMismatch(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	key := uuid.MustParse("0b8c7f0e-5d0a-4f43-9b7e-2f4c9f1f6a11")
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("select endpoint, request_hash, response_json\nfrom idempotency_records")).
+		WithArgs("usr_1", key, startEndpoint).
+		WillReturnRows(pgxmock.NewRows([]string{"endpoint", "request_hash", "response_json"}).
+			AddRow("/api/v1/relay/stop", "stophash", []byte(`{}`)))
+	mock.ExpectRollback()
+
+	s := New(mock)
+	_, _, err = s.StartOrGetSession(context.Background(), StartInput{UserID: "usr_1", Region: "us-east-1", IdempotencyKey: key, RequestHash: "starthash"})
+	if !errors.Is(err, ErrIdempotencyMismatch) {
+		t.Fatalf("expected ErrIdempotencyMismatch, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestStartOrGetSession_ReplaysRecordWithLegacyHash(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	key := uuid.MustParse("0b8c7f0e-5d0a-4f43-9b7e-2f4c9f1f6a11")
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("select endpoint, request_hash, response_json\nfrom idempotency_records")).
+		WithArgs("usr_1", key, startEndpoint).
+		WillReturnRows(pgxmock.NewRows([]string{"endpoint", "request_hash", "response_json"}).
+			AddRow(startEndpoint, "legacyhash", []byte(`{"ID":"ses_1","Status":"active"}`)))
+	mock.ExpectCommit()
+	mock.ExpectRollback()
+
+	sess, created, err := New(mock).StartOrGetSession(context.Background(), StartInput{
+		UserID: "usr_1", Region: "us-east-1", IdempotencyKey: key, RequestHash: "v2:canonical", LegacyRequestHash: "legacyhash",
+	})
+	if err != nil || created || sess.ID != "ses_1" || !sess.Replayed {
+		t.Fatalf("expected the recorded session replayed, got %+v created=%v err=%v", sess, created, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestStartOrGetSession_LosingConcurrentStartReturnsWinner(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	key := uuid.MustParse("0b8c7f0e-5d0a-4f43-9b7e-2f4c9f1f6a11")
+	activePrefix := "select s.id, s.user_id, coalesce(s.relay_instance_id, ''), coalesce(ri.aws_instance_id, ''), s.status, s.region, s.pair_token, s.relay_ws_token,"
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("from idempotency_records")).
+		WithArgs("usr_1", key, startEndpoint).
+		WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta(activePrefix)).
+		WithArgs("usr_1").
+		WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta("insert into sessions")).
+		WithArgs(anyArgs(12)...).
+		WillReturnError(&pgconn.PgError{Code: "23505", ConstraintName: "sessions_live_limit"})
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("from idempotency_records")).
+		WithArgs("usr_1", key, startEndpoint).
+		WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta(activePrefix)).
+		WithArgs("usr_1").
+		WillReturnRows(sessionRowWithTimes("ses_winner", "usr_1", "", "", string(model.SessionProvisioning), time.Now().UTC(), nil))
+	mock.ExpectQuery(regexp.QuoteMeta("join plan_policies pp")).
+		WithArgs("usr_1").
+		WillReturnRows(liveCountRow(1, 1, 0))
+	mock.ExpectExec(regexp.QuoteMeta("insert into idempotency_records")).
+		WithArgs(anyArgs(6)...).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectCommit()
+
+	sess, created, err := New(mock).StartOrGetSession(context.Background(), StartInput{UserID: "usr_1", Region: "us-east-1", IdempotencyKey: key, RequestHash: "h"})
+	if err != nil {
+		t.Fatalf("StartOrGetSession: %v", err)
+	}
+	if created || sess.ID != "ses_winner" {
+		t.Fatalf("expected the concurrent start's session, got created=%t %+v", created, sess)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestActivateProvisionedSession_RetriesPairTokenCollision(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	queryPrefix := "select s.id, s.user_id, coalesce(s.relay_instance_id, ''), coalesce(ri.aws_instance_id, ''), s.status, s.region, s.pair_token, s.relay_ws_token,"
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("insert into relay_instances")).
+		WithArgs(anyArgs(19)...).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectExec(regexp.QuoteMeta("update sessions")).
+		WithArgs("usr_1", "ses_1", pgxmock.AnyArg(), "TAKEN123", "wstoken", "us-east-1").
+		WillReturnError(&pgconn.PgError{Code: "23505", ConstraintName: "sessions_live_pair_token"})
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("insert into relay_instances")).
+		WithArgs(anyArgs(19)...).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectExec(regexp.QuoteMeta("update sessions")).
+		WithArgs("usr_1", "ses_1", pgxmock.AnyArg(), "FRESH456", "wstoken", "us-east-1").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec(regexp.QuoteMeta("'provisioning_phase'")).
+		WithArgs("ses_1", "usr_1", model.ProvisioningReady).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	expectWebhookEvent(mock, "usr_1", model.WebhookSessionActivated)
+	mock.ExpectQuery(regexp.QuoteMeta(queryPrefix)).
+		WithArgs("usr_1", "ses_1").
+		WillReturnRows(sessionRowWithTimes("ses_1", "usr_1", "rly_1", "i-1", string(model.SessionActive), time.Now().UTC(), nil))
+	mock.ExpectCommit()
+
+	s := New(mock)
+	fresh := 0
+	_, err = s.ActivateProvisionedSession(context.Background(), ActivateProvisionedSessionInput{
+		UserID:        "usr_1",
+		SessionID:     "ses_1",
+		Region:        "us-east-1",
+		AWSInstanceID: "i-1",
+		SRTPorts:      []int{9000},
+		PairToken:     "TAKEN123",
+		RelayWSToken:  "wstoken",
+		NewPairToken: func() (string, error) {
+			fresh++
+			return "FRESH456", nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("ActivateProvisionedSession: %v", err)
+	}
+	if fresh != 1 {
+		t.Fatalf("expected one fresh token, got %d", fresh)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func anyArgs(n int) []any {
+	args := make([]any, n)
+	for i := range args {
+		args[i] = pgxmock.AnyArg()
+	}
+	return args
+}
+
+func TestGetActiveSession_NoneIsNotFound(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	queryPrefix := "select s.id, s.user_id, coalesce(s.relay_instance_id, ''), coalesce(ri.aws_instance_id, ''), s.status, s.region, s.pair_token, s.relay_ws_token,"
+	mock.ExpectQuery(regexp.QuoteMeta(queryPrefix)).
+		WithArgs("usr_1").
+		WillReturnError(pgx.ErrNoRows)
+
+	sess, err := New(mock).GetActiveSession(context.Background(), "usr_1")
+	if !errors.Is(err, ErrNotFound) || sess != nil {
+		t.Fatalf("expected ErrNotFound, got sess=%v err=%v", sess, err)
+	}
+}
+
+func TestGetSessionByID_ReadsWithoutTransaction(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	// No Begin or Commit is expected: the read goes straight to the pool.
+	mock.ExpectQuery(regexp.QuoteMeta(sessionQueryPrefix)).
+		WithArgs("usr_1", "ses_1").
+		WillReturnRows(sessionRow("ses_1", "usr_1", "rly_1", "i-abc", string(model.SessionActive), time.Now()))
+
+	sess, err := New(mock).GetSessionByID(context.Background(), "usr_1", "ses_1")
+	if err != nil || sess.ID != "ses_1" || sess.Status != model.SessionActive {
+		t.Fatalf("expected the active session, got sess=%+v err=%v", sess, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestSessionReaders_ScanTheSameRowAlike(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	startedAt := time.Now().UTC().Add(-time.Hour)
+	lastHealthAt := time.Now().UTC().Add(-12 * time.Second)
+	row := func() *pgxmock.Rows {
+		return sessionRowWithHealth("ses_1", "usr_1", "rly_1", "i-abc", string(model.SessionActive), startedAt, nil, &lastHealthAt)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(sessionQueryPrefix)).WithArgs("usr_1").WillReturnRows(row())
+	mock.ExpectQuery(regexp.QuoteMeta(sessionQueryPrefix)).WithArgs("usr_1", "ses_1").WillReturnRows(row())
+	mock.ExpectQuery(regexp.QuoteMeta(sessionQueryPrefix)).WithArgs("usr_1", "").WillReturnRows(row())
+
+	s := New(mock)
+	active, err := s.GetActiveSession(context.Background(), "usr_1")
+	if err != nil {
+		t.Fatalf("GetActiveSession: %v", err)
+	}
+	byID, err := s.GetSessionByID(context.Background(), "usr_1", "ses_1")
+	if err != nil {
+		t.Fatalf("GetSessionByID: %v", err)
+	}
+	live, err := s.ListLiveSessions(context.Background(), "usr_1", "")
+	if err != nil || len(live) != 1 {
+		t.Fatalf("ListLiveSessions: %v %v", live, err)
+	}
+	if !reflect.DeepEqual(active, byID) || !reflect.DeepEqual(*active, live[0]) {
+		t.Fatalf("expected identical sessions, got\n%+v\n%+v\n%+v", active, byID, live[0])
+	}
+	if active.RelayInstanceID == nil || *active.RelayInstanceID != "rly_1" || active.WSURL == "" || active.MaxSessionSeconds != 57600 ||
+		active.LastHealthAt == nil || !active.LastHealthAt.Equal(lastHealthAt) {
+		t.Fatalf("expected every column scanned, got %+v", active)
+	}
+}
+
+func TestStartOrGetSession_CreatesSessionWhenNoneIsLive(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	key := uuid.MustParse("0b8c7f0e-5d0a-4f43-9b7e-2f4c9f1f6a11")
+	queryPrefix := "select s.id, s.user_id, coalesce(s.relay_instance_id, ''), coalesce(ri.aws_instance_id, ''), s.status, s.region, s.pair_token, s.relay_ws_token,"
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("select endpoint, request_hash, response_json")).
+		WithArgs("usr_1", key, startEndpoint).
+		WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta(queryPrefix)).
+		WithArgs("usr_1").
+		WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta("insert into sessions")).
+		WithArgs(append(anyArgs(7), true, "198.51.100.7", "OBS/30.1", "", []string{"client-x", "friday-show"})...).
+		WillReturnRows(pgxmock.NewRows([]string{"plan_tier"}).AddRow("starter"))
+	expectWebhookEvent(mock, "usr_1", model.WebhookSessionStarted)
+	mock.ExpectExec(regexp.QuoteMeta("insert into idempotency_records")).
+		WithArgs(anyArgs(6)...).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectCommit()
+
+	metrics.ResetDefaultForTest()
+	sess, created, err := New(mock).StartOrGetSession(context.Background(), StartInput{
+		UserID: "usr_1", Region: "us-east-1", IdempotencyKey: key, RequestHash: "h", StartedFromIP: "198.51.100.7", StartedUserAgent: "OBS/30.1",
+		Tags: []string{"client-x", "friday-show"},
+	})
+	if err != nil || !created || sess.Status != model.SessionProvisioning || !reflect.DeepEqual(sess.Tags, []string{"client-x", "friday-show"}) {
+		t.Fatalf("expected a new provisioning session, got sess=%+v created=%v err=%v", sess, created, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+	if out := metrics.Default().Render(); !strings.Contains(out, `aegis_sessions_started_total{plan_tier="starter",region="us-east-1"} 1`) {
+		t.Fatalf("expected the start to be counted: %s", out)
+	}
+}
+
+func liveCountRow(limit, live, stopping int) *pgxmock.Rows {
+	return pgxmock.NewRows([]string{"limit", "live", "stopping"}).AddRow(limit, live, stopping)
+}
+
+func TestStartOrGetSession_ConcurrentSessionLimit(t *testing.T) {
+	key := uuid.MustParse("0b8c7f0e-5d0a-4f43-9b7e-2f4c9f1f6a11")
+	activePrefix := "select s.id, s.user_id, coalesce(s.relay_instance_id, ''), coalesce(ri.aws_instance_id, ''), s.status, s.region, s.pair_token, s.relay_ws_token,"
+	for _, tc := range []struct {
+		name                  string
+		limit, live, stopping int
+		wantErr               error
+	}{
+		{"under the limit", 3, 2, 0, nil},
+		{"at the limit", 3, 3, 0, ErrSessionLimit},
+		{"at the limit with one stopping", 3, 3, 1, ErrSessionStopping},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mock, err := pgxmock.NewPool()
+			if err != nil {
+				t.Fatalf("pgxmock pool: %v", err)
+			}
+			defer mock.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta("from idempotency_records")).
+				WithArgs("usr_1", key, startEndpoint).
+				WillReturnError(pgx.ErrNoRows)
+			mock.ExpectQuery(regexp.QuoteMeta(activePrefix)).
+				WithArgs("usr_1").
+				WillReturnRows(sessionRowWithTimes("ses_live", "usr_1", "", "", string(model.SessionActive), time.Now().UTC(), nil))
+			mock.ExpectQuery(regexp.QuoteMeta("join plan_policies pp")).
+				WithArgs("usr_1").
+				WillReturnRows(liveCountRow(tc.limit, tc.live, tc.stopping))
+			if tc.wantErr == nil {
+				mock.ExpectQuery(regexp.QuoteMeta("insert into sessions")).
+					WithArgs(anyArgs(12)...).
+					WillReturnRows(pgxmock.NewRows([]string{"plan_tier"}).AddRow("studio"))
+				expectWebhookEvent(mock, "usr_1", model.WebhookSessionStarted)
+				mock.ExpectExec(regexp.QuoteMeta("insert into idempotency_records")).
+					WithArgs(anyArgs(6)...).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			sess, created, err := New(mock).StartOrGetSession(context.Background(), StartInput{UserID: "usr_1", Region: "us-east-1", IdempotencyKey: key, RequestHash: "h"})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected %v, got %v", tc.wantErr, err)
+			}
+			if tc.wantErr == nil && (!created || sess.ID == "ses_live") {
+				t.Fatalf("expected a second session, got created=%t %+v", created, sess)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("unmet expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestStartOrGetSession_AdminStart(t *testing.T) {
+	key := uuid.MustParse("0b8c7f0e-5d0a-4f43-9b7e-2f4c9f1f6a11")
+	activePrefix := "select s.id, s.user_id, coalesce(s.relay_instance_id, ''), coalesce(ri.aws_instance_id, ''), s.status, s.region, s.pair_token, s.relay_ws_token,"
+	for _, tc := range []struct {
+		name       string
+		userLive   bool
+		userExists bool
+		adminLive  int
+		wantErr    error
+	}{
+		{"under the cap", false, true, 1, nil},
+		{"at the cap", false, true, 2, ErrAdminSessionLimit},
+		{"unknown user", false, false, 0, ErrNotFound},
+		{"user has a live session", true, true, 0, ErrUserSessionsLive},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mock, err := pgxmock.NewPool()
+			if err != nil {
+				t.Fatalf("pgxmock pool: %v", err)
+			}
+			defer mock.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta("from idempotency_records")).
+				WithArgs("usr_1", key, startEndpoint).
+				WillReturnError(pgx.ErrNoRows)
+			active := mock.ExpectQuery(regexp.QuoteMeta(activePrefix)).WithArgs("usr_1")
+			if tc.userLive {
+				active.WillReturnRows(sessionRowWithTimes("ses_live", "usr_1", "", "", string(model.SessionActive), time.Now().UTC(), nil))
+				mock.ExpectRollback()
+			} else {
+				active.WillReturnError(pgx.ErrNoRows)
+				mock.ExpectExec(regexp.QuoteMeta("pg_advisory_xact_lock(hashtextextended('sessions_admin', 0))")).
+					WillReturnResult(pgxmock.NewResult("SELECT", 1))
+				mock.ExpectQuery(regexp.QuoteMeta("where started_by_admin is not null")).
+					WithArgs("usr_1").
+					WillReturnRows(pgxmock.NewRows([]string{"exists", "live"}).AddRow(tc.userExists, tc.adminLive))
+			}
+			if tc.wantErr == nil {
+				mock.ExpectQuery(regexp.QuoteMeta("insert into sessions")).
+					WithArgs(append(anyArgs(7), false, "", "", "usr_admin", pgxmock.AnyArg())...).
+					WillReturnRows(pgxmock.NewRows([]string{"plan_tier"}).AddRow("starter"))
+				mock.ExpectExec(regexp.QuoteMeta("'admin_session_started'")).
+					WithArgs(pgxmock.AnyArg(), "usr_1", "usr_admin", pgxmock.AnyArg()).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+				expectWebhookEvent(mock, "usr_1", model.WebhookSessionStarted)
+				mock.ExpectExec(regexp.QuoteMeta("insert into idempotency_records")).
+					WithArgs(anyArgs(6)...).
+					WillReturnResult(pgxmock.NewResult("INSERT", 1))
+				mock.ExpectCommit()
+			} else if !tc.userLive {
+				mock.ExpectRollback()
+			}
+
+			sess, created, err := New(mock).StartOrGetSession(context.Background(), StartInput{
+				UserID: "usr_1", Region: "us-east-1", IdempotencyKey: key, RequestHash: "h",
+				NonBillable: true, StartedByAdmin: "usr_admin", MaxAdminSessions: 2, RefuseIfLive: true,
+			})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected %v, got %v", tc.wantErr, err)
+			}
+			if tc.wantErr == nil && (!created || sess.Billable) {
+				t.Fatalf("expected a new non-billable session, got created=%t %+v", created, sess)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("unmet expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestStartOrGetSession_PrunesIdempotencyRecordsOverCap(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	key := uuid.MustParse("0b8c7f0e-5d0a-4f43-9b7e-2f4c9f1f6a11")
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("select endpoint, request_hash, response_json")).
+		WithArgs("usr_1", key, startEndpoint).
+		WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta("select s.id, s.user_id")).
+		WithArgs("usr_1").
+		WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta("insert into sessions")).
+		WithArgs(anyArgs(12)...).
+		WillReturnRows(pgxmock.NewRows([]string{"plan_tier"}).AddRow("starter"))
+	expectWebhookEvent(mock, "usr_1", model.WebhookSessionStarted)
+	mock.ExpectExec(regexp.QuoteMeta("insert into idempotency_records")).
+		WithArgs(anyArgs(6)...).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectExec(regexp.QuoteMeta("delete from idempotency_records")).
+		WithArgs("usr_1", startEndpoint, 2).
+		WillReturnResult(pgxmock.NewResult("DELETE", 3))
+	mock.ExpectCommit()
+
+	_, created, err := New(mock, WithIdempotencyMaxPerUser(2)).StartOrGetSession(context.Background(), StartInput{UserID: "usr_1", Region: "us-east-1", IdempotencyKey: key, RequestHash: "h"})
+	if err != nil || !created {
+		t.Fatalf("expected a new session, got created=%v err=%v", created, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestPreviewStart_ReadsOnlyAndWritesNothing(t *testing.T) {
+	key := uuid.MustParse("0b8c7f0e-5d0a-4f43-9b7e-2f4c9f1f6a11")
+	activePrefix := "select s.id, s.user_id, coalesce(s.relay_instance_id, ''), coalesce(ri.aws_instance_id, ''), s.status, s.region, s.pair_token, s.relay_ws_token,"
+	for _, tc := range []struct {
+		name         string
+		limit, live  int
+		wantExisting bool
+		wantErr      error
+	}{
+		{"no live session", 0, 0, false, nil},
+		{"single session plan returns the live one", 1, 1, true, nil},
+		{"at a multi-session limit", 3, 3, false, ErrSessionLimit},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mock, err := pgxmock.NewPool()
+			if err != nil {
+				t.Fatalf("pgxmock pool: %v", err)
+			}
+			defer mock.Close()
+
+			// Any write would fail the test: only these reads are expected.
+			mock.ExpectBeginTx(pgx.TxOptions{AccessMode: pgx.ReadOnly})
+			mock.ExpectQuery(regexp.QuoteMeta("from idempotency_records")).
+				WithArgs("usr_1", key, startEndpoint).
+				WillReturnError(pgx.ErrNoRows)
+			active := mock.ExpectQuery(regexp.QuoteMeta(activePrefix)).WithArgs("usr_1")
+			if tc.live == 0 {
+				active.WillReturnError(pgx.ErrNoRows)
+			} else {
+				active.WillReturnRows(sessionRowWithTimes("ses_live", "usr_1", "", "", string(model.SessionActive), time.Now().UTC(), nil))
+				mock.ExpectQuery(regexp.QuoteMeta("join plan_policies pp")).
+					WithArgs("usr_1").
+					WillReturnRows(liveCountRow(tc.limit, tc.live, 0))
+			}
+			mock.ExpectRollback()
+
+			preview, err := New(mock).PreviewStart(context.Background(), StartInput{UserID: "usr_1", Region: "us-east-1", IdempotencyKey: key, RequestHash: "h"})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected %v, got %v", tc.wantErr, err)
+			}
+			if got := preview.Existing != nil; got != tc.wantExisting || preview.Replayed {
+				t.Fatalf("expected existing=%t, got %+v", tc.wantExisting, preview)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("unmet expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestPreviewStart_ReplaysRecordedSession(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	key := uuid.MustParse("0b8c7f0e-5d0a-4f43-9b7e-2f4c9f1f6a11")
+	mock.ExpectBeginTx(pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	mock.ExpectQuery(regexp.QuoteMeta("from idempotency_records")).
+		WithArgs("usr_1", key, startEndpoint).
+		WillReturnRows(pgxmock.NewRows([]string{"endpoint", "request_hash", "response_json"}).
+			AddRow(startEndpoint, "h", []byte(`{"ID":"ses_1","Status":"active"}`)))
+	mock.ExpectRollback()
+
+	preview, err := New(mock).PreviewStart(context.Background(), StartInput{UserID: "usr_1", Region: "us-east-1", IdempotencyKey: key, RequestHash: "h"})
+	if err != nil || !preview.Replayed || preview.Existing.ID != "ses_1" {
+		t.Fatalf("expected the recorded session replayed, got %+v err=%v", preview, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestAdvanceProvisioningPhase_OnlyMovesForward(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	// The update only matches while the new phase is later than the
+	// recorded one, and the event is only appended for a row it changed.
+	mock.ExpectExec(regexp.QuoteMeta("coalesce(array_position($4::text[], provisioning_phase), 0) < array_position($4::text[], $3)")).
+		WithArgs("usr_1", "ses_1", model.ProvisioningLaunching, model.ProvisioningPhases).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+	if err := New(mock).AdvanceProvisioningPhase(context.Background(), "usr_1", "ses_1", model.ProvisioningLaunching); err != nil {
+		t.Fatalf("AdvanceProvisioningPhase: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
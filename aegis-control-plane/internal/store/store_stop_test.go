@@ -2,12 +2,16 @@ package store
 
 import (
 	"context"
+	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	pgxmock "github.com/pashagolub/pgxmock/v4"
 
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
 	"github.com/telemyapp/aegis-control-plane/internal/model"
 )
 
@@ -31,7 +35,7 @@ func TestStopSession_AlreadyStopped_Idempotent(t *testing.T) {
 	mock.ExpectCommit()
 
 	s := New(mock)
-	out, err := s.StopSession(context.Background(), "usr_1", "ses_1")
+	out, err := s.StopSession(context.Background(), StopSessionInput{UserID: "usr_1", SessionID: "ses_1", Reason: model.StopReasonUser})
 	if err != nil {
 		t.Fatalf("StopSession returned err: %v", err)
 	}
@@ -43,7 +47,7 @@ func TestStopSession_AlreadyStopped_Idempotent(t *testing.T) {
 	}
 }
 
-func TestStopSession_Active_TransitionsAndTerminatesRelay(t *testing.T) {
+func TestStopSession_Active_MarksStoppingAndQueuesTermination(t *testing.T) {
 	mock, err := pgxmock.NewPool()
 	if err != nil {
 		t.Fatalf("pgxmock pool: %v", err)
@@ -53,7 +57,7 @@ func TestStopSession_Active_TransitionsAndTerminatesRelay(t *testing.T) {
 	startedAt := time.Now().UTC().Add(-5 * time.Minute)
 	stoppedAt := time.Now().UTC()
 	activeRow := sessionRowWithTimes("ses_2", "usr_1", "rly_2", "i-xyz", string(model.SessionActive), startedAt, nil)
-	stoppedRow := sessionRowWithTimes("ses_2", "usr_1", "rly_2", "i-xyz", string(model.SessionStopped), startedAt, &stoppedAt)
+	stoppingRow := sessionRowWithTimes("ses_2", "usr_1", "rly_2", "i-xyz", string(model.SessionStopping), startedAt, &stoppedAt)
 	queryPrefix := "select s.id, s.user_id, coalesce(s.relay_instance_id, ''), coalesce(ri.aws_instance_id, ''), s.status, s.region, s.pair_token, s.relay_ws_token,"
 
 	mock.ExpectBegin()
@@ -61,23 +65,215 @@ func TestStopSession_Active_TransitionsAndTerminatesRelay(t *testing.T) {
 		WithArgs("usr_1", "ses_2").
 		WillReturnRows(activeRow)
 	mock.ExpectExec(regexp.QuoteMeta("update sessions")).
-		WithArgs("usr_1", "ses_2").
+		WithArgs("usr_1", "ses_2", string(model.SessionStopping), model.StopReasonUser, model.ClientStopStreamEnded, "obs closed").
 		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	expectWebhookEvent(mock, "usr_1", model.WebhookSessionStopped)
 	mock.ExpectExec(regexp.QuoteMeta("update relay_instances")).
-		WithArgs("rly_2").
+		WithArgs("rly_2", model.StopReasonUser).
 		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	// The relay's instance type has no price of its own.
+	mock.ExpectQuery(regexp.QuoteMeta("set hourly_price_usd = p.hourly_usd")).
+		WithArgs("ses_2").
+		WillReturnRows(pgxmock.NewRows([]string{"region", "instance_type", "fallback"}).AddRow("us-east-1", "t4g.large", true))
+	mock.ExpectExec(regexp.QuoteMeta("insert into relay_terminations")).
+		WithArgs("ses_2", "usr_1", "us-east-1", "i-xyz", model.StopReasonUser).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
 	mock.ExpectQuery(regexp.QuoteMeta(queryPrefix)).
 		WithArgs("usr_1", "ses_2").
-		WillReturnRows(stoppedRow)
+		WillReturnRows(stoppingRow)
 	mock.ExpectCommit()
 
+	metrics.ResetDefaultForTest()
 	s := New(mock)
-	out, err := s.StopSession(context.Background(), "usr_1", "ses_2")
+	out, err := s.StopSession(context.Background(), StopSessionInput{
+		UserID: "usr_1", SessionID: "ses_2", Reason: model.StopReasonUser,
+		ClientReason: model.ClientStopStreamEnded, ClientDetail: "obs closed",
+	})
 	if err != nil {
 		t.Fatalf("StopSession returned err: %v", err)
 	}
-	if out.Status != model.SessionStopped {
-		t.Fatalf("expected stopped status, got %s", out.Status)
+	if out.Status != model.SessionStopping {
+		t.Fatalf("expected stopping status, got %s", out.Status)
+	}
+	if got := metrics.Default().Render(); !strings.Contains(got, `aegis_session_cost_default_price_total{instance_type="t4g.large",region="us-east-1"} 1`) {
+		t.Fatalf("expected the default price counted, got:\n%s", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestStopSession_LosingConcurrentStopReturnsWinner(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	startedAt := time.Now().UTC().Add(-5 * time.Minute)
+	stoppedAt := time.Now().UTC()
+	activeRow := sessionRowWithTimes("ses_2", "usr_1", "rly_2", "i-xyz", string(model.SessionActive), startedAt, nil)
+	stoppingRow := sessionRowWithTimes("ses_2", "usr_1", "rly_2", "i-xyz", string(model.SessionStopping), startedAt, &stoppedAt)
+	queryPrefix := "select s.id, s.user_id, coalesce(s.relay_instance_id, ''), coalesce(ri.aws_instance_id, ''), s.status, s.region, s.pair_token, s.relay_ws_token,"
+
+	// The other stop commits between the read and the update, so the
+	// update matches nothing and no termination is queued.
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(queryPrefix)).
+		WithArgs("usr_1", "ses_2").
+		WillReturnRows(activeRow)
+	mock.ExpectExec(regexp.QuoteMeta("update sessions")).
+		WithArgs("usr_1", "ses_2", string(model.SessionStopping), model.StopReasonUser, "", "").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	mock.ExpectQuery(regexp.QuoteMeta(queryPrefix)).
+		WithArgs("usr_1", "ses_2").
+		WillReturnRows(stoppingRow)
+	mock.ExpectCommit()
+
+	out, err := New(mock).StopSession(context.Background(), StopSessionInput{UserID: "usr_1", SessionID: "ses_2", Reason: model.StopReasonUser})
+	if err != nil {
+		t.Fatalf("StopSession returned err: %v", err)
+	}
+	if out.Status != model.SessionStopping || out.StoppedAt == nil || !out.StoppedAt.Equal(stoppedAt) {
+		t.Fatalf("expected the winning stop's result, got %+v", out)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestStopProvisionedSession_QueuesUnboundInstance(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	startedAt := time.Now().UTC()
+	queryPrefix := "select s.id, s.user_id, coalesce(s.relay_instance_id, ''), coalesce(ri.aws_instance_id, ''), s.status, s.region, s.pair_token, s.relay_ws_token,"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(queryPrefix)).
+		WithArgs("usr_1", "ses_3").
+		WillReturnRows(sessionRowWithTimes("ses_3", "usr_1", "", "", string(model.SessionProvisioning), startedAt, nil))
+	mock.ExpectExec(regexp.QuoteMeta("update sessions")).
+		WithArgs("usr_1", "ses_3", string(model.SessionStopping), model.StopReasonStartFailed, "", "").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	expectWebhookEvent(mock, "usr_1", model.WebhookSessionStopped)
+	mock.ExpectExec(regexp.QuoteMeta("insert into relay_terminations")).
+		WithArgs("ses_3", "usr_1", "us-east-1", "i-unbound", model.TerminateReasonCompensation).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectQuery(regexp.QuoteMeta(queryPrefix)).
+		WithArgs("usr_1", "ses_3").
+		WillReturnRows(sessionRowWithTimes("ses_3", "usr_1", "", "", string(model.SessionStopping), startedAt, &startedAt))
+	mock.ExpectCommit()
+
+	s := New(mock)
+	if _, err := s.StopProvisionedSession(context.Background(), "usr_1", "ses_3", "us-east-1", "i-unbound"); err != nil {
+		t.Fatalf("StopProvisionedSession returned err: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestCompleteRelayTermination_FinalizesSession(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("update relay_terminations")).
+		WithArgs(int64(7)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec(regexp.QuoteMeta("update relay_instances")).
+		WithArgs("i-xyz", false, model.StopReasonAdmin, int64(1500)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	startedAt := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta("update sessions")).
+		WithArgs("ses_2").
+		WillReturnRows(pgxmock.NewRows([]string{"region", "stop_reason", "started_at", "stopped_at"}).
+			AddRow("us-east-1", model.StopReasonAdmin, startedAt, startedAt.Add(90*time.Minute)))
+	mock.ExpectCommit()
+
+	metrics.ResetDefaultForTest()
+	s := New(mock)
+	err = s.CompleteRelayTermination(context.Background(), model.RelayTermination{ID: 7, SessionID: "ses_2", AWSInstanceID: "i-xyz", Reason: model.StopReasonAdmin}, false, 1500*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CompleteRelayTermination returned err: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+	out := metrics.Default().Render()
+	for _, want := range []string{
+		`aegis_sessions_stopped_total{reason="admin",region="us-east-1"} 1`,
+		`aegis_session_duration_seconds_bucket{le="3600",region="us-east-1"} 0`,
+		`aegis_session_duration_seconds_bucket{le="7200",region="us-east-1"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %s in:\n%s", want, out)
+		}
+	}
+}
+
+func TestCompleteRelayTermination_SessionStillStopping(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("update relay_terminations")).
+		WithArgs(int64(8)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec(regexp.QuoteMeta("update relay_instances")).
+		WithArgs("i-old", true, "", int64(0)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectQuery(regexp.QuoteMeta("update sessions")).
+		WithArgs("ses_2").
+		WillReturnError(pgx.ErrNoRows)
+	mock.ExpectCommit()
+
+	metrics.ResetDefaultForTest()
+	if err := New(mock).CompleteRelayTermination(context.Background(), model.RelayTermination{ID: 8, SessionID: "ses_2", AWSInstanceID: "i-old"}, true, 0); err != nil {
+		t.Fatalf("CompleteRelayTermination returned err: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+	if out := metrics.Default().Render(); strings.Contains(out, "aegis_sessions_stopped_total{") {
+		t.Fatalf("expected no stop counted while another termination is pending: %s", out)
+	}
+}
+
+func TestCountSessionsByStatusRegion(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("select status, region, count(*)")).
+		WillReturnRows(pgxmock.NewRows([]string{"status", "region", "count"}).
+			AddRow("active", "us-east-1", 3).
+			AddRow("active", "eu-west-1", 1).
+			AddRow("stopping", "us-east-1", 2))
+
+	s := New(mock)
+	got, err := s.CountSessionsByStatusRegion(context.Background())
+	if err != nil {
+		t.Fatalf("CountSessionsByStatusRegion returned err: %v", err)
+	}
+	want := map[model.SessionStatus]map[string]int{
+		model.SessionActive:   {"us-east-1": 3, "eu-west-1": 1},
+		model.SessionStopping: {"us-east-1": 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected counts: %v", got)
 	}
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Fatalf("unmet expectations: %v", err)
@@ -112,19 +308,19 @@ func TestRollupJobsExecutes(t *testing.T) {
 
 	mock.ExpectExec(regexp.QuoteMeta("update sessions")).
 		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
-	mock.ExpectExec(regexp.QuoteMeta("with latest as")).
-		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
-	mock.ExpectExec(regexp.QuoteMeta("insert into usage_records")).
-		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectQuery(regexp.QuoteMeta("insert into job_watermarks")).
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("insert into usage_records")).
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(1))
 
 	s := New(mock)
-	if err := s.RollupLiveSessionDurations(context.Background()); err != nil {
+	if _, err := s.RollupLiveSessionDurations(context.Background()); err != nil {
 		t.Fatalf("RollupLiveSessionDurations returned err: %v", err)
 	}
-	if err := s.ReconcileOutageFromHealth(context.Background()); err != nil {
+	if _, err := s.ReconcileOutageFromHealth(context.Background()); err != nil {
 		t.Fatalf("ReconcileOutageFromHealth returned err: %v", err)
 	}
-	if err := s.UpsertUsageRollups(context.Background()); err != nil {
+	if _, err := s.UpsertUsageRollups(context.Background()); err != nil {
 		t.Fatalf("UpsertUsageRollups returned err: %v", err)
 	}
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -137,12 +333,80 @@ func sessionRow(sessionID, userID, relayID, awsID, status string, stoppedAt time
 }
 
 func sessionRowWithTimes(sessionID, userID, relayID, awsID, status string, startedAt time.Time, stoppedAt *time.Time) *pgxmock.Rows {
+	return sessionRowWithHealth(sessionID, userID, relayID, awsID, status, startedAt, stoppedAt, nil)
+}
+
+func sessionRowWithHealth(sessionID, userID, relayID, awsID, status string, startedAt time.Time, stoppedAt, lastHealthAt *time.Time) *pgxmock.Rows {
 	cols := []string{
 		"id", "user_id", "relay_instance_id", "aws_instance_id", "status", "region", "pair_token", "relay_ws_token",
-		"public_ip", "srt_port", "ws_url", "started_at", "stopped_at", "duration_seconds", "grace_window_seconds", "max_session_seconds",
+		"public_ip", "public_ipv6", "srt_port", "srt_ports", "ws_url", "started_at", "stopped_at", "duration_seconds", "grace_window_seconds", "max_session_seconds", "grace_started_at",
+		"last_health_at", "provisioning_phase", "tags",
+	}
+	phase := model.ProvisioningReady
+	if status == string(model.SessionProvisioning) {
+		phase = model.ProvisioningRequested
 	}
 	return pgxmock.NewRows(cols).AddRow(
 		sessionID, userID, relayID, awsID, status, "us-east-1", "ABCDEFGH", "relaytoken",
-		"203.0.113.10", 9000, "wss://203.0.113.10:7443/telemetry", startedAt, stoppedAt, 120, 600, 57600,
+		"203.0.113.10", "", 9000, []int{9000}, "wss://203.0.113.10:7443/telemetry", startedAt, stoppedAt, 120, 600, 57600, nil,
+		lastHealthAt, phase, []string{},
 	)
 }
+
+func TestGetRelaySession_RequiresCurrentRelay(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	startedAt := time.Now().Add(-time.Hour)
+	mock.ExpectQuery(regexp.QuoteMeta("where s.id = $1 and ri.aws_instance_id = $2")).
+		WithArgs("ses_1", "i-current").
+		WillReturnRows(pgxmock.NewRows([]string{"id", "user_id", "relay_instance_id", "aws_instance_id", "status", "region", "srt_port", "srt_ports", "started_at", "stopped_at", "grace_window_seconds", "max_session_seconds"}).
+			AddRow("ses_1", "usr_1", "rly_1", "i-current", model.SessionActive, "us-east-1", 9000, []int{9000, 9001}, startedAt, nil, 600, 57600))
+	mock.ExpectQuery(regexp.QuoteMeta("where s.id = $1 and ri.aws_instance_id = $2")).
+		WithArgs("ses_1", "i-replaced").
+		WillReturnError(pgx.ErrNoRows)
+
+	s := New(mock)
+	sess, err := s.GetRelaySession(context.Background(), "ses_1", "i-current")
+	if err != nil {
+		t.Fatalf("GetRelaySession returned err: %v", err)
+	}
+	if sess.SRTPort != 9000 || len(sess.SRTPorts) != 2 || sess.MaxSessionSeconds != 57600 || sess.PairToken != "" {
+		t.Fatalf("unexpected session: %+v", sess)
+	}
+	if _, err := s.GetRelaySession(context.Background(), "ses_1", "i-replaced"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound for a replaced relay, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestInstanceSecondsByTerminateReason(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	since := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta("coalesce(terminated_reason, 'unknown')")).
+		WithArgs(since).
+		WillReturnRows(pgxmock.NewRows([]string{"reason", "seconds"}).
+			AddRow(model.StopReasonUser, int64(7200)).
+			AddRow(model.TerminateReasonCompensation, int64(90)))
+
+	got, err := New(mock).InstanceSecondsByTerminateReason(context.Background(), since)
+	if err != nil {
+		t.Fatalf("InstanceSecondsByTerminateReason: %v", err)
+	}
+	if len(got) != 2 || got["user"] != 7200 || got["compensation"] != 90 {
+		t.Fatalf("unexpected seconds %v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,317 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+const (
+	usageUserQuery = "select plan_tier, cycle_start_at, cycle_end_at, included_seconds, created_at"
+	liveUsageQuery = "floor(extract(epoch from (now() - s.started_at)))::integer"
+)
+
+func TestGetUsageCurrent_InitializesUnconfiguredUser(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	now := time.Now().UTC()
+	createdAt := time.Date(now.Year(), now.Month()-2, 15, 9, 30, 0, 0, time.UTC)
+	cycleStart, cycleEnd := monthlyCycle(createdAt, now)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(usageUserQuery)).
+		WithArgs("usr_new").
+		WillReturnRows(pgxmock.NewRows([]string{"plan_tier", "cycle_start_at", "cycle_end_at", "included_seconds", "created_at"}).
+			AddRow("starter", (*time.Time)(nil), (*time.Time)(nil), 0, createdAt))
+	mock.ExpectQuery(regexp.QuoteMeta("where id = $1 and (cycle_start_at is null or cycle_end_at is null)")).
+		WithArgs("usr_new", "free", pgxmock.AnyArg(), pgxmock.AnyArg(), 3600).
+		WillReturnRows(pgxmock.NewRows([]string{"plan_tier", "cycle_start_at", "cycle_end_at", "included_seconds"}).
+			AddRow("free", &cycleStart, &cycleEnd, 3600))
+	mock.ExpectQuery(regexp.QuoteMeta("select coalesce(sum(billable_seconds), 0)")).
+		WithArgs(anyArgs(3)...).
+		WillReturnRows(pgxmock.NewRows([]string{"coalesce"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta(liveUsageQuery)).
+		WithArgs(anyArgs(3)...).
+		WillReturnRows(pgxmock.NewRows([]string{"elapsed", "billable_seconds"}))
+	mock.ExpectCommit()
+
+	out, err := New(mock).GetUsageCurrent(context.Background(), "usr_new", 3600)
+	if err != nil {
+		t.Fatalf("GetUsageCurrent: %v", err)
+	}
+	if out.PlanTier != "free" || out.IncludedSeconds != 3600 || out.RemainingSeconds != 3600 || out.ConsumedSeconds != 0 || out.OverageSeconds != 0 {
+		t.Fatalf("expected a fresh free-tier usage, got %+v", out)
+	}
+	if out.CycleStart.After(now) || !out.CycleEnd.After(now) || out.CycleStart.Day() != 15 || out.CycleStart.Hour() != 9 {
+		t.Fatalf("expected a cycle anchored on the creation date containing now, got %s - %s", out.CycleStart, out.CycleEnd)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestPreviewUsageCurrent_WritesNothingForUnconfiguredUser(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	now := time.Now().UTC()
+	createdAt := time.Date(now.Year(), now.Month()-2, 15, 9, 30, 0, 0, time.UTC)
+	cycleStart, cycleEnd := monthlyCycle(createdAt, now)
+	// Any write would fail the test: only these reads are expected.
+	mock.ExpectBeginTx(pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	mock.ExpectQuery(regexp.QuoteMeta(usageUserQuery)).
+		WithArgs("usr_new").
+		WillReturnRows(pgxmock.NewRows([]string{"plan_tier", "cycle_start_at", "cycle_end_at", "included_seconds", "created_at"}).
+			AddRow("starter", (*time.Time)(nil), (*time.Time)(nil), 0, createdAt))
+	mock.ExpectQuery(regexp.QuoteMeta("select coalesce(sum(billable_seconds), 0)")).
+		WithArgs("usr_new", cycleStart, cycleEnd).
+		WillReturnRows(pgxmock.NewRows([]string{"coalesce"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta(liveUsageQuery)).
+		WithArgs("usr_new", cycleStart, cycleEnd).
+		WillReturnRows(pgxmock.NewRows([]string{"elapsed", "billable_seconds"}))
+	mock.ExpectRollback()
+
+	out, err := New(mock).PreviewUsageCurrent(context.Background(), "usr_new", 3600)
+	if err != nil {
+		t.Fatalf("PreviewUsageCurrent: %v", err)
+	}
+	if out.PlanTier != "free" || out.RemainingSeconds != 3600 || !out.CycleStart.Equal(cycleStart) {
+		t.Fatalf("expected the free-tier cycle a start would set up, got %+v", out)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestGetUsageCurrent_ReadsBackCycleSetUpConcurrently(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	cycleStart := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	cycleEnd := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	cols := []string{"plan_tier", "cycle_start_at", "cycle_end_at", "included_seconds", "created_at"}
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(usageUserQuery)).
+		WithArgs("usr_new").
+		WillReturnRows(pgxmock.NewRows(cols).AddRow("free", (*time.Time)(nil), (*time.Time)(nil), 0, cycleStart))
+	mock.ExpectQuery(regexp.QuoteMeta("update users")).
+		WithArgs("usr_new", "free", pgxmock.AnyArg(), pgxmock.AnyArg(), 3600).
+		WillReturnError(pgx.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta(usageUserQuery)).
+		WithArgs("usr_new").
+		WillReturnRows(pgxmock.NewRows(cols).AddRow("free", &cycleStart, &cycleEnd, 3600, cycleStart))
+	mock.ExpectQuery(regexp.QuoteMeta("select coalesce(sum(billable_seconds), 0)")).
+		WithArgs("usr_new", cycleStart, cycleEnd).
+		WillReturnRows(pgxmock.NewRows([]string{"coalesce"}).AddRow(120))
+	mock.ExpectQuery(regexp.QuoteMeta(liveUsageQuery)).
+		WithArgs("usr_new", cycleStart, cycleEnd).
+		WillReturnRows(pgxmock.NewRows([]string{"elapsed", "billable_seconds"}))
+	mock.ExpectCommit()
+
+	out, err := New(mock).GetUsageCurrent(context.Background(), "usr_new", 3600)
+	if err != nil {
+		t.Fatalf("GetUsageCurrent: %v", err)
+	}
+	if !out.CycleStart.Equal(cycleStart) || !out.CycleEnd.Equal(cycleEnd) || out.ConsumedSeconds != 120 {
+		t.Fatalf("expected the concurrently set up cycle, got %+v", out)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestGetUsageCurrent_MissingUserIsNotFound(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(usageUserQuery)).
+		WithArgs("usr_missing").
+		WillReturnError(pgx.ErrNoRows)
+	mock.ExpectRollback()
+
+	if _, err := New(mock).GetUsageCurrent(context.Background(), "usr_missing", 3600); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+// expectConfiguredUsage expects a usage read for a user on a configured
+// cycle, with rolledUp seconds in usage_records and one live session.
+func expectConfiguredUsage(mock pgxmock.PgxPoolIface, cycleStart, cycleEnd time.Time, rolledUp, liveElapsed, liveRolledUp int) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(usageUserQuery)).
+		WithArgs("usr_1").
+		WillReturnRows(pgxmock.NewRows([]string{"plan_tier", "cycle_start_at", "cycle_end_at", "included_seconds", "created_at"}).
+			AddRow("starter", &cycleStart, &cycleEnd, 3600, cycleStart))
+	mock.ExpectQuery(regexp.QuoteMeta("select coalesce(sum(billable_seconds), 0)")).
+		WithArgs("usr_1", cycleStart, cycleEnd).
+		WillReturnRows(pgxmock.NewRows([]string{"coalesce"}).AddRow(rolledUp))
+	mock.ExpectQuery(regexp.QuoteMeta(liveUsageQuery)).
+		WithArgs("usr_1", cycleStart, cycleEnd).
+		WillReturnRows(pgxmock.NewRows([]string{"elapsed", "billable_seconds"}).AddRow(liveElapsed, liveRolledUp))
+	mock.ExpectCommit()
+}
+
+func TestGetUsageCurrent_CountsLiveSessionOnce(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	cycleStart := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	cycleEnd := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	s := New(mock)
+
+	// 1000s rolled up, 540 of them from a live session that has run 600s.
+	expectConfiguredUsage(mock, cycleStart, cycleEnd, 1000, 600, 540)
+	before, err := s.GetUsageCurrent(context.Background(), "usr_1", 0)
+	if err != nil {
+		t.Fatalf("GetUsageCurrent: %v", err)
+	}
+	if before.ConsumedSeconds != 1060 || before.RemainingSeconds != 2540 {
+		t.Fatalf("expected the unrolled 60s counted, got %+v", before)
+	}
+
+	// The rollup catches the session up; the total must not move.
+	mock.ExpectQuery(regexp.QuoteMeta("insert into usage_records")).
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(1))
+	if _, err := s.UpsertUsageRollups(context.Background()); err != nil {
+		t.Fatalf("UpsertUsageRollups: %v", err)
+	}
+	expectConfiguredUsage(mock, cycleStart, cycleEnd, 1060, 600, 600)
+	after, err := s.GetUsageCurrent(context.Background(), "usr_1", 0)
+	if err != nil {
+		t.Fatalf("GetUsageCurrent: %v", err)
+	}
+	if after.ConsumedSeconds != before.ConsumedSeconds {
+		t.Fatalf("expected consumed unchanged by the rollup, got %d then %d", before.ConsumedSeconds, after.ConsumedSeconds)
+	}
+
+	// Reconciled uptime may put the rollup ahead of wall-clock time.
+	expectConfiguredUsage(mock, cycleStart, cycleEnd, 1090, 600, 630)
+	ahead, err := s.GetUsageCurrent(context.Background(), "usr_1", 0)
+	if err != nil {
+		t.Fatalf("GetUsageCurrent: %v", err)
+	}
+	if ahead.ConsumedSeconds != 1090 {
+		t.Fatalf("expected no negative live delta, got %d", ahead.ConsumedSeconds)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestRecordUsageAlerts_NotifiesLiveSession(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	cycleStart := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("insert into usage_alerts")).
+		WithArgs([]int{80, 100}).
+		WillReturnRows(pgxmock.NewRows([]string{"user_id", "cycle_start_at", "threshold_percent", "consumed_seconds", "included_seconds"}).
+			AddRow("usr_live", cycleStart, 80, 2900, 3600).
+			AddRow("usr_idle", cycleStart, 100, 3700, 3600))
+	mock.ExpectQuery(regexp.QuoteMeta("'usage_threshold_crossed'")).
+		WithArgs("usr_live", pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"session_id"}).AddRow("ses_1"))
+	expectWebhookEvent(mock, "usr_live", model.WebhookUsageThreshold)
+	mock.ExpectQuery(regexp.QuoteMeta("'usage_threshold_crossed'")).
+		WithArgs("usr_idle", pgxmock.AnyArg()).
+		WillReturnError(pgx.ErrNoRows)
+	expectWebhookEvent(mock, "usr_idle", model.WebhookUsageThreshold)
+	mock.ExpectCommit()
+
+	alerts, err := New(mock).RecordUsageAlerts(context.Background(), []int{80, 100})
+	if err != nil {
+		t.Fatalf("RecordUsageAlerts: %v", err)
+	}
+	if len(alerts) != 2 || alerts[0].SessionID != "ses_1" || alerts[0].ThresholdPercent != 80 || alerts[1].SessionID != "" || alerts[1].ThresholdPercent != 100 {
+		t.Fatalf("expected the live session notified and the idle user recorded only, got %+v", alerts)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestMonthlyCycle(t *testing.T) {
+	anchor := time.Date(2026, 1, 20, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		now, start, end time.Time
+	}{
+		{anchor, anchor, time.Date(2026, 2, 20, 12, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 3, 19, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 20, 12, 0, 0, 0, time.UTC), time.Date(2026, 3, 20, 12, 0, 0, 0, time.UTC)},
+		{time.Date(2027, 1, 25, 0, 0, 0, 0, time.UTC), time.Date(2027, 1, 20, 12, 0, 0, 0, time.UTC), time.Date(2027, 2, 20, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range cases {
+		start, end := monthlyCycle(anchor, tc.now)
+		if !start.Equal(tc.start) || !end.Equal(tc.end) {
+			t.Fatalf("now=%s: expected %s - %s, got %s - %s", tc.now, tc.start, tc.end, start, end)
+		}
+	}
+}
+
+func TestExportUsage_IteratesRows(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 1)
+	started := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	cost := 0.0168
+	mock.ExpectQuery(regexp.QuoteMeta("from usage_records ur")).
+		WithArgs(from, to).
+		WillReturnRows(pgxmock.NewRows([]string{"user_id", "plan_tier", "session_id", "region", "cycle_start_at", "cycle_end_at",
+			"started_at", "stopped_at", "billable_seconds", "overage_seconds", "updated_at", "estimated_cost_usd", "cycle_estimated_cost_usd"}).
+			AddRow("usr_1", "starter", "ses_1", "us-east-1", from, from.AddDate(0, 1, 0), started, &started, 3600, 0, started, &cost, &cost).
+			AddRow("usr_2", "free", "ses_2", "eu-central", from, from.AddDate(0, 1, 0), started, (*time.Time)(nil), 4000, 400, started, (*float64)(nil), (*float64)(nil)))
+
+	export, err := New(mock).ExportUsage(context.Background(), from, to)
+	if err != nil {
+		t.Fatalf("ExportUsage: %v", err)
+	}
+	var got []model.UsageExportRow
+	for export.Next() {
+		got = append(got, export.Row())
+	}
+	export.Close()
+	if err := export.Err(); err != nil {
+		t.Fatalf("iterate: %v", err)
+	}
+	if len(got) != 2 || got[0].StoppedAt == nil || got[1].StoppedAt != nil || got[1].OverageSeconds != 400 || got[1].PlanTier != "free" ||
+		got[0].EstimatedCostUSD == nil || *got[0].EstimatedCostUSD != cost || got[1].CycleEstimatedCostUSD != nil {
+		t.Fatalf("unexpected rows %+v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
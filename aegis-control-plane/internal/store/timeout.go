@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrStoreTimeout means an operation ran past its timeout, or past the
+// caller's deadline, before the database answered.
+var ErrStoreTimeout = errors.New("store timeout")
+
+// Timeouts bound each Store operation, transactions included, so that a
+// stuck query cannot hold a transaction open for the life of the process.
+// Rollup covers the usage and billing jobs.
+type Timeouts struct {
+	Read   time.Duration
+	Write  time.Duration
+	Rollup time.Duration
+}
+
+var DefaultTimeouts = Timeouts{
+	Read:   5 * time.Second,
+	Write:  15 * time.Second,
+	Rollup: 60 * time.Second,
+}
+
+// rollbackTimeout bounds the rollback of a transaction whose operation
+// failed; it runs on a context of its own.
+const rollbackTimeout = 2 * time.Second
+
+type Option func(*Store)
+
+// WithTimeouts replaces DefaultTimeouts. Zero fields keep their default.
+func WithTimeouts(t Timeouts) Option {
+	return func(s *Store) {
+		if t.Read > 0 {
+			s.timeouts.Read = t.Read
+		}
+		if t.Write > 0 {
+			s.timeouts.Write = t.Write
+		}
+		if t.Rollup > 0 {
+			s.timeouts.Rollup = t.Rollup
+		}
+	}
+}
+
+// withTimeout bounds one operation by d. The returned func, deferred with
+// the operation's error, releases the context and reports a run-out
+// deadline as ErrStoreTimeout.
+func (s *Store) withTimeout(ctx context.Context, d time.Duration) (context.Context, func(*error)) {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	return ctx, func(errp *error) {
+		defer cancel()
+		if *errp != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && !errors.Is(*errp, ErrStoreTimeout) {
+			*errp = fmt.Errorf("%w: %w", ErrStoreTimeout, *errp)
+		}
+	}
+}
+
+// rollback is deferred by every transaction. It does not use the
+// operation's context: once that is canceled or past its deadline, pgx
+// could not send the rollback.
+func rollback(tx pgx.Tx) {
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+	if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+		log.Printf("event=db_rollback_failed err=%q", err.Error())
+	}
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+const sessionQueryPrefix = "select s.id, s.user_id, coalesce(s.relay_instance_id, ''), coalesce(ri.aws_instance_id, ''), s.status, s.region, s.pair_token, s.relay_ws_token,"
+
+func TestStopSession_TimeoutMidTransactionRollsBack(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(sessionQueryPrefix)).
+		WithArgs("usr_1", "ses_1").
+		WillReturnRows(sessionRow("ses_1", "usr_1", "rly_1", "i-abc", string(model.SessionActive), time.Now())).
+		WillDelayFor(time.Second)
+	mock.ExpectRollback()
+
+	s := New(mock, WithTimeouts(Timeouts{Write: 20 * time.Millisecond}))
+	_, err = s.StopSession(context.Background(), StopSessionInput{UserID: "usr_1", SessionID: "ses_1", Reason: model.StopReasonUser})
+	if !errors.Is(err, ErrStoreTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected ErrStoreTimeout wrapping the deadline, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestStopSession_CallerCancelStillRollsBack(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+	var logs bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&logs)
+	defer log.SetOutput(prev)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(sessionQueryPrefix)).
+		WithArgs("usr_1", "ses_1").
+		WillReturnRows(sessionRow("ses_1", "usr_1", "rly_1", "i-abc", string(model.SessionActive), time.Now())).
+		WillDelayFor(time.Second)
+	// A rollback sent on the canceled context would fail without waiting.
+	mock.ExpectRollback().WillDelayFor(10 * time.Millisecond)
+
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = New(mock).StopSession(ctx, StopSessionInput{UserID: "usr_1", SessionID: "ses_1", Reason: model.StopReasonUser})
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrStoreTimeout) {
+		t.Fatalf("expected the cancellation, not a timeout, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+	if strings.Contains(logs.String(), "event=db_rollback_failed") {
+		t.Fatalf("expected the rollback to succeed, got logs %q", logs.String())
+	}
+}
+
+func TestWithTimeouts_ZeroKeepsDefault(t *testing.T) {
+	s := New(nil, WithTimeouts(Timeouts{Rollup: 5 * time.Minute}))
+	want := Timeouts{Read: DefaultTimeouts.Read, Write: DefaultTimeouts.Write, Rollup: 5 * time.Minute}
+	if s.timeouts != want {
+		t.Fatalf("expected %+v, got %+v", want, s.timeouts)
+	}
+}
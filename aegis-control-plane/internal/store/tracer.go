@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+)
+
+// otherQuery labels statements not issued by this package, e.g. ad-hoc
+// queries from tests or tools sharing the pool.
+const otherQuery = "other"
+
+var storeFuncPrefix = reflect.TypeOf(Store{}).PkgPath() + "."
+
+// queryTracer records aegis_db_query_duration_ms and
+// aegis_db_query_errors_total for every statement and logs those slower than
+// slow. Statements are labelled by the store function that issues them, so
+// the label set is bounded by the code rather than by the SQL text.
+type queryTracer struct {
+	slow time.Duration
+	// names caches the label of each statement the first time it is seen.
+	names sync.Map
+}
+
+type queryTraceKey struct{}
+
+type queryTrace struct {
+	name  string
+	start time.Time
+}
+
+// NewQueryTracer returns the pgx tracer Connect installs on the pool.
+// slow <= 0 disables the slow-query log.
+func NewQueryTracer(slow time.Duration) pgx.QueryTracer {
+	return &queryTracer{slow: slow}
+}
+
+func (t *queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryTraceKey{}, queryTrace{name: t.statementName(data.SQL), start: time.Now()})
+}
+
+func (t *queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	trace, ok := ctx.Value(queryTraceKey{}).(queryTrace)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(trace.start)
+	labels := map[string]string{"query": trace.name}
+	metrics.Default().ObserveHistogram("aegis_db_query_duration_ms", float64(elapsed.Milliseconds()), labels)
+	// Context cancellation is the caller giving up, not the statement failing.
+	if data.Err != nil && !errors.Is(data.Err, context.Canceled) {
+		metrics.Default().IncCounter("aegis_db_query_errors_total", labels)
+	}
+	if t.slow > 0 && elapsed >= t.slow {
+		errText := ""
+		if data.Err != nil {
+			errText = data.Err.Error()
+		}
+		log.Printf("event=db_slow_query query=%s duration_ms=%d rows=%d err=%q", trace.name, elapsed.Milliseconds(), data.CommandTag.RowsAffected(), errText)
+	}
+}
+
+// statementName must be called directly from TraceQueryStart: it names the
+// statement after the first store function below the tracer on the stack.
+func (t *queryTracer) statementName(sql string) string {
+	if name, ok := t.names.Load(sql); ok {
+		return name.(string)
+	}
+	name := otherQuery
+	pcs := make([]uintptr, 32)
+	// Skip runtime.Callers, statementName and TraceQueryStart.
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if fn, ok := strings.CutPrefix(frame.Function, storeFuncPrefix); ok {
+			name = queryLabel(fn)
+			break
+		}
+		if !more {
+			break
+		}
+	}
+	t.names.Store(sql, name)
+	return name
+}
+
+// queryLabel turns a function name such as "(*Store).StopSession.func1" into
+// the label "stop_session".
+func queryLabel(fn string) string {
+	fn = strings.TrimPrefix(fn, "(*Store).")
+	fn, _, _ = strings.Cut(fn, ".")
+	rs := []rune(fn)
+	var b strings.Builder
+	for i, r := range rs {
+		if unicode.IsUpper(r) {
+			// Break before a word, keeping acronyms like "IP" together.
+			if i > 0 && (!unicode.IsUpper(rs[i-1]) || i+1 < len(rs) && unicode.IsLower(rs[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
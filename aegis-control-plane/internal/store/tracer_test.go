@@ -0,0 +1,82 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pashagolub/pgxmock/v4"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+)
+
+// tracedPool runs the query tracer around Exec the way a pgx connection
+// does, so the statement is attributed to the store method on the stack.
+type tracedPool struct {
+	pgxmock.PgxPoolIface
+	tracer pgx.QueryTracer
+}
+
+func (p tracedPool) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	ctx = p.tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: sql, Args: args})
+	tag, err := p.PgxPoolIface.Exec(ctx, sql, args...)
+	p.tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{CommandTag: tag, Err: err})
+	return tag, err
+}
+
+func TestQueryTracer_LabelsByStoreMethod(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock: %v", err)
+	}
+	defer mock.Close()
+	var logs bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&logs)
+	defer log.SetOutput(prev)
+
+	db := tracedPool{PgxPoolIface: mock, tracer: store.NewQueryTracer(time.Nanosecond)}
+	mock.ExpectExec("update relay_instances set allowed_client_ip").
+		WithArgs("relay-1", "203.0.113.7").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec("update relay_instances set allowed_client_ip").
+		WithArgs("relay-2", "203.0.113.7").
+		WillReturnError(errors.New("connection reset"))
+	mock.ExpectExec("select 1").WillReturnResult(pgxmock.NewResult("SELECT", 1))
+
+	s := store.New(db)
+	if err := s.UpdateRelayAllowedClientIP(context.Background(), "relay-1", "203.0.113.7"); err != nil {
+		t.Fatalf("UpdateRelayAllowedClientIP: %v", err)
+	}
+	if err := s.UpdateRelayAllowedClientIP(context.Background(), "relay-2", "203.0.113.7"); err == nil {
+		t.Fatal("expected the exec error")
+	}
+	if _, err := db.Exec(context.Background(), "select 1"); err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("expectations: %v", err)
+	}
+
+	out := metrics.Default().Render()
+	for _, want := range []string{
+		`aegis_db_query_duration_ms_count{query="update_relay_allowed_client_ip"} 2`,
+		`aegis_db_query_errors_total{query="update_relay_allowed_client_ip"} 1`,
+		`aegis_db_query_duration_ms_count{query="other"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %s in:\n%s", want, out)
+		}
+	}
+	if !strings.Contains(logs.String(), "event=db_slow_query query=update_relay_allowed_client_ip") || !strings.Contains(logs.String(), "rows=1 err=\"\"") {
+		t.Fatalf("expected a slow query log, got %q", logs.String())
+	}
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+)
+
+const (
+	// txRetries bounds the reruns of a transaction that lost to a
+	// concurrent one.
+	txRetries = 3
+	// txRetryBackoff is the delay before the first rerun; it doubles for
+	// each one after and is jittered.
+	txRetryBackoff = 10 * time.Millisecond
+)
+
+// retryableTxCode returns the SQLSTATE of err when it is a serialization
+// failure or a deadlock, which Postgres resolves by aborting one of the
+// transactions involved; running it again usually succeeds.
+func retryableTxCode(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return "", false
+	}
+	switch pgErr.Code {
+	case "40001", "40P01":
+		return pgErr.Code, true
+	}
+	return "", false
+}
+
+// withTxRetry runs fn, which must begin and finish its own transaction,
+// again after a serialization failure or deadlock, up to txRetries times.
+// Reruns are counted in aegis_db_tx_retries_total by op. Other errors, and
+// the last retryable one, are returned as they are.
+func withTxRetry(ctx context.Context, op string, fn func() error) error {
+	backoff := txRetryBackoff
+	for retry := 0; ; retry++ {
+		err := fn()
+		code, ok := retryableTxCode(err)
+		if !ok || retry == txRetries {
+			return err
+		}
+		metrics.Default().IncCounter("aegis_db_tx_retries_total", map[string]string{"op": op, "sqlstate": code})
+		delay := backoff/2 + rand.N(backoff/2+1)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		backoff *= 2
+	}
+}
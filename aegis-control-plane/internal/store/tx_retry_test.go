@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+
+	"github.com/telemyapp/aegis-control-plane/internal/metrics"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+func TestStopSession_RetriesSerializationFailure(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	stoppedAt := time.Now().UTC()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(sessionQueryPrefix)).
+		WithArgs("usr_1", "ses_1").
+		WillReturnError(&pgconn.PgError{Code: "40001", Message: "could not serialize access due to concurrent update"})
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(sessionQueryPrefix)).
+		WithArgs("usr_1", "ses_1").
+		WillReturnRows(sessionRow("ses_1", "usr_1", "rly_1", "i-abc", string(model.SessionStopped), stoppedAt))
+	mock.ExpectQuery(regexp.QuoteMeta(sessionQueryPrefix)).
+		WithArgs("usr_1", "ses_1").
+		WillReturnRows(sessionRow("ses_1", "usr_1", "rly_1", "i-abc", string(model.SessionStopped), stoppedAt))
+	mock.ExpectCommit()
+
+	metrics.ResetDefaultForTest()
+	out, err := New(mock).StopSession(context.Background(), StopSessionInput{UserID: "usr_1", SessionID: "ses_1", Reason: model.StopReasonUser})
+	if err != nil || out.Status != model.SessionStopped {
+		t.Fatalf("expected the rerun to succeed, got %+v err=%v", out, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+	want := `aegis_db_tx_retries_total{op="stop_session",sqlstate="40001"} 1`
+	if got := metrics.Default().Render(); !strings.Contains(got, want) {
+		t.Fatalf("missing %s in:\n%s", want, got)
+	}
+}
+
+func TestStopSession_DoesNotRetryOtherErrors(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	unique := &pgconn.PgError{Code: "23505", ConstraintName: "relay_terminations_pkey"}
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(sessionQueryPrefix)).
+		WithArgs("usr_1", "ses_1").
+		WillReturnError(unique)
+	mock.ExpectRollback()
+
+	metrics.ResetDefaultForTest()
+	_, err = New(mock).StopSession(context.Background(), StopSessionInput{UserID: "usr_1", SessionID: "ses_1", Reason: model.StopReasonUser})
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr != unique {
+		t.Fatalf("expected the unique violation untouched, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+	if got := metrics.Default().Render(); strings.Contains(got, "aegis_db_tx_retries_total{") {
+		t.Fatalf("expected no retries counted, got:\n%s", got)
+	}
+}
+
+func TestWithTxRetry_GivesUpAfterRetries(t *testing.T) {
+	metrics.ResetDefaultForTest()
+	deadlock := &pgconn.PgError{Code: "40P01"}
+	runs := 0
+	err := withTxRetry(context.Background(), "stop_session", func() error {
+		runs++
+		return deadlock
+	})
+	if !errors.Is(err, deadlock) || runs != txRetries+1 {
+		t.Fatalf("expected the deadlock after %d runs, got %v after %d", txRetries+1, err, runs)
+	}
+	want := `aegis_db_tx_retries_total{op="stop_session",sqlstate="40P01"} 3`
+	if got := metrics.Default().Render(); !strings.Contains(got, want) {
+		t.Fatalf("missing %s in:\n%s", want, got)
+	}
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+// RowIterator iterates over exported rows as they are read from the
+// database, so exports of any size are never held in memory. Callers must
+// Close it.
+type RowIterator[T any] interface {
+	Next() bool
+	Row() T
+	Err() error
+	Close()
+}
+
+// UsageExport iterates over exported usage records.
+type UsageExport = RowIterator[model.UsageExportRow]
+
+// ExportUsage streams the usage records of cycles starting in [from, to),
+// ordered by user and session start, with each session's estimated cost and
+// the sum over the user's records in the cycle.
+func (s *Store) ExportUsage(ctx context.Context, from, to time.Time) (UsageExport, error) {
+	const q = `
+select ur.user_id, u.plan_tier, ur.session_id, s.region, ur.cycle_start_at, ur.cycle_end_at,
+       s.started_at, s.stopped_at, ur.billable_seconds, ur.overage_seconds, ur.updated_at,
+       s.estimated_cost_usd::float8,
+       (sum(s.estimated_cost_usd) over (partition by ur.user_id, ur.cycle_start_at))::float8
+from usage_records ur
+join users u on u.id = ur.user_id
+join sessions s on s.id = ur.session_id
+where ur.cycle_start_at >= $1 and ur.cycle_start_at < $2
+order by ur.user_id asc, s.started_at asc, ur.session_id asc`
+	rows, err := s.db.Query(ctx, q, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return &scannedRows[model.UsageExportRow]{rows: rows, scan: scanUsageExportRow}, nil
+}
+
+func scanUsageExportRow(rows pgx.Rows, r *model.UsageExportRow) error {
+	return rows.Scan(&r.UserID, &r.PlanTier, &r.SessionID, &r.Region, &r.CycleStart, &r.CycleEnd,
+		&r.StartedAt, &r.StoppedAt, &r.BillableSeconds, &r.OverageSeconds, &r.UpdatedAt, &r.EstimatedCostUSD, &r.CycleEstimatedCostUSD)
+}
+
+// scannedRows is a RowIterator over rows decoded by scan.
+type scannedRows[T any] struct {
+	rows pgx.Rows
+	scan func(pgx.Rows, *T) error
+	row  T
+	err  error
+}
+
+func (e *scannedRows[T]) Next() bool {
+	if e.err != nil || !e.rows.Next() {
+		return false
+	}
+	var r T
+	if err := e.scan(e.rows, &r); err != nil {
+		e.err = err
+		return false
+	}
+	e.row = r
+	return true
+}
+
+func (e *scannedRows[T]) Row() T { return e.row }
+
+func (e *scannedRows[T]) Err() error {
+	if e.err != nil {
+		return e.err
+	}
+	return e.rows.Err()
+}
+
+func (e *scannedRows[T]) Close() { e.rows.Close() }
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+// ErrExportLimited means the user exported their data too recently.
+var ErrExportLimited = errors.New("data export limit reached")
+
+// ExportLimitedError is returned by RecordUserDataExport when the user's
+// previous export is less than the interval ago. It matches
+// ErrExportLimited.
+type ExportLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ExportLimitedError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", ErrExportLimited, e.RetryAfter)
+}
+
+func (e *ExportLimitedError) Is(target error) bool {
+	return target == ErrExportLimited
+}
+
+// RecordUserDataExport records an export of userID's data by requestedBy and
+// returns when it was generated. Exports a user requests themselves are
+// limited to one per every; zero, as for admins, is unlimited. ErrNotFound
+// means the user does not exist.
+func (s *Store) RecordUserDataExport(ctx context.Context, userID, requestedBy string, every time.Duration) (_ time.Time, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer rollback(tx)
+
+	// Serializes a user's concurrent requests so only one passes the limit.
+	if _, err := tx.Exec(ctx, `select pg_advisory_xact_lock(hashtextextended('user_export:' || $1, 0))`, userID); err != nil {
+		return time.Time{}, err
+	}
+	var (
+		exists bool
+		last   *time.Time
+		now    time.Time
+	)
+	const checkQ = `
+select exists (select 1 from users where id = $1),
+       (select max(created_at) from user_data_exports where user_id = $1 and requested_by = $1),
+       now()`
+	if err := tx.QueryRow(ctx, checkQ, userID).Scan(&exists, &last, &now); err != nil {
+		return time.Time{}, err
+	}
+	if !exists {
+		return time.Time{}, ErrNotFound
+	}
+	if every > 0 && requestedBy == userID && last != nil {
+		if wait := last.Add(every).Sub(now); wait > 0 {
+			return time.Time{}, &ExportLimitedError{RetryAfter: wait}
+		}
+	}
+	var created time.Time
+	const insertQ = `
+insert into user_data_exports (user_id, requested_by, created_at)
+values ($1, $2, now())
+returning created_at`
+	if err := tx.QueryRow(ctx, insertQ, userID, requestedBy).Scan(&created); err != nil {
+		return time.Time{}, err
+	}
+	return created, tx.Commit(ctx)
+}
+
+// ExportUserSessions streams the user's sessions, oldest first.
+func (s *Store) ExportUserSessions(ctx context.Context, userID string) (RowIterator[model.Session], error) {
+	const q = `
+select id, user_id, status, region, started_at, stopped_at, duration_seconds
+from sessions
+where user_id = $1
+order by started_at asc, id asc`
+	rows, err := s.db.Query(ctx, q, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &scannedRows[model.Session]{rows: rows, scan: func(rows pgx.Rows, sess *model.Session) error {
+		return rows.Scan(&sess.ID, &sess.UserID, &sess.Status, &sess.Region, &sess.StartedAt, &sess.StoppedAt, &sess.DurationSeconds)
+	}}, nil
+}
+
+// ExportUserUsage streams the user's usage records, by cycle and session
+// start, in the shape of ExportUsage. Estimated costs are internal and are
+// left out.
+func (s *Store) ExportUserUsage(ctx context.Context, userID string) (UsageExport, error) {
+	const q = `
+select ur.user_id, u.plan_tier, ur.session_id, s.region, ur.cycle_start_at, ur.cycle_end_at,
+       s.started_at, s.stopped_at, ur.billable_seconds, ur.overage_seconds, ur.updated_at,
+       null::float8, null::float8
+from usage_records ur
+join users u on u.id = ur.user_id
+join sessions s on s.id = ur.session_id
+where ur.user_id = $1
+order by ur.cycle_start_at asc, s.started_at asc, ur.session_id asc`
+	rows, err := s.db.Query(ctx, q, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &scannedRows[model.UsageExportRow]{rows: rows, scan: scanUsageExportRow}, nil
+}
+
+// ExportUserSessionEvents streams the user's session events, oldest first.
+func (s *Store) ExportUserSessionEvents(ctx context.Context, userID string) (RowIterator[model.SessionEvent], error) {
+	const q = `
+select id, session_id, user_id, event_type, payload_json, created_at
+from session_events
+where user_id = $1
+order by id asc`
+	rows, err := s.db.Query(ctx, q, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &scannedRows[model.SessionEvent]{rows: rows, scan: func(rows pgx.Rows, e *model.SessionEvent) error {
+		return rows.Scan(&e.ID, &e.SessionID, &e.UserID, &e.Type, &e.Payload, &e.CreatedAt)
+	}}, nil
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+)
+
+func TestRecordUserDataExport_LimitsUserExports(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	now := time.Now()
+	last := now.Add(-20 * time.Minute)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("pg_advisory_xact_lock")).WithArgs("usr_1").WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mock.ExpectQuery(regexp.QuoteMeta("from user_data_exports where user_id = $1 and requested_by = $1")).WithArgs("usr_1").
+		WillReturnRows(pgxmock.NewRows([]string{"exists", "max", "now"}).AddRow(true, &last, now))
+	mock.ExpectRollback()
+
+	_, err = New(mock).RecordUserDataExport(context.Background(), "usr_1", "usr_1", time.Hour)
+	var limited *ExportLimitedError
+	if !errors.As(err, &limited) || !errors.Is(err, ErrExportLimited) || limited.RetryAfter != 40*time.Minute {
+		t.Fatalf("expected the export limited for 40m, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestRecordUserDataExport_AdminExportsAreNotLimited(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	now := time.Now()
+	last := now.Add(-time.Minute)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("pg_advisory_xact_lock")).WithArgs("usr_1").WillReturnResult(pgxmock.NewResult("SELECT", 1))
+	mock.ExpectQuery(regexp.QuoteMeta("from user_data_exports")).WithArgs("usr_1").
+		WillReturnRows(pgxmock.NewRows([]string{"exists", "max", "now"}).AddRow(true, &last, now))
+	mock.ExpectQuery(regexp.QuoteMeta("insert into user_data_exports")).WithArgs("usr_1", "usr_admin").
+		WillReturnRows(pgxmock.NewRows([]string{"created_at"}).AddRow(now))
+	mock.ExpectCommit()
+
+	got, err := New(mock).RecordUserDataExport(context.Background(), "usr_1", "usr_admin", 0)
+	if err != nil || !got.Equal(now) {
+		t.Fatalf("expected the export recorded at %s, got %s err=%v", now, got, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+// Webhooks are scoped by owner: userID "" addresses the global webhooks.
+const webhookColumns = `id, coalesce(user_id, ''), url, secret, events, created_at, updated_at`
+
+func scanWebhook(row pgx.Row) (*model.Webhook, error) {
+	var w model.Webhook
+	if err := row.Scan(&w.ID, &w.UserID, &w.URL, &w.Secret, &w.Events, &w.CreatedAt, &w.UpdatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (s *Store) CreateWebhook(ctx context.Context, in model.Webhook) (_ *model.Webhook, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	if in.Events == nil {
+		in.Events = []string{}
+	}
+	q := `
+insert into webhooks (id, user_id, url, secret, events, created_at, updated_at)
+values ($1, nullif($2, ''), $3, $4, $5, now(), now())
+returning ` + webhookColumns
+	return scanWebhook(s.db.QueryRow(ctx, q, "whk_"+uuid.NewString(), in.UserID, in.URL, in.Secret, in.Events))
+}
+
+func (s *Store) ListWebhooks(ctx context.Context, userID string) (_ []model.Webhook, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	q := `
+select ` + webhookColumns + `
+from webhooks
+where user_id is not distinct from nullif($1, '')
+order by created_at asc`
+	rows, err := s.db.Query(ctx, q, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make([]model.Webhook, 0)
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *w)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) GetWebhook(ctx context.Context, userID, id string) (_ *model.Webhook, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	q := `
+select ` + webhookColumns + `
+from webhooks
+where id = $2 and user_id is not distinct from nullif($1, '')`
+	return scanWebhook(s.db.QueryRow(ctx, q, userID, id))
+}
+
+// UpdateWebhook replaces a webhook's URL and events, and its secret when
+// in.Secret is set.
+func (s *Store) UpdateWebhook(ctx context.Context, in model.Webhook) (_ *model.Webhook, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	if in.Events == nil {
+		in.Events = []string{}
+	}
+	q := `
+update webhooks
+set url = $3, events = $4, secret = coalesce(nullif($5, ''), secret), updated_at = now()
+where id = $2 and user_id is not distinct from nullif($1, '')
+returning ` + webhookColumns
+	return scanWebhook(s.db.QueryRow(ctx, q, in.UserID, in.ID, in.URL, in.Events, in.Secret))
+}
+
+// DeleteWebhook removes a webhook and its undelivered deliveries.
+func (s *Store) DeleteWebhook(ctx context.Context, userID, id string) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	tag, err := s.db.Exec(ctx, `delete from webhooks where id = $2 and user_id is not distinct from nullif($1, '')`, userID, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// enqueueWebhookEvent queues a delivery of the event to the user's webhooks
+// and the global ones that subscribe to it. It runs in the transaction of
+// the change the event reports, so an event is queued if and only if the
+// change commits. The canary user's session events are not delivered; only
+// its failures are.
+func enqueueWebhookEvent(ctx context.Context, tx pgx.Tx, userID, eventType string, data map[string]any) error {
+	if userID == model.CanaryUserID && eventType != model.WebhookCanaryFailed {
+		return nil
+	}
+	payload := map[string]any{"user_id": userID}
+	for k, v := range data {
+		payload[k] = v
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	const q = `
+insert into webhook_deliveries (webhook_id, event_type, payload_json, next_attempt_at, created_at, updated_at)
+select w.id, $2, $3, now(), now(), now()
+from webhooks w
+where (w.user_id = $1 or w.user_id is null)
+  and (cardinality(w.events) = 0 or $2 = any(w.events))`
+	_, err = tx.Exec(ctx, q, userID, eventType, b)
+	return err
+}
+
+const webhookDeliveryColumns = `d.id, d.webhook_id, w.user_id is null, w.url, w.secret, d.event_type, d.payload_json, d.status, d.attempts,
+  coalesce(d.last_status_code, 0), coalesce(d.last_error, ''), d.next_attempt_at, d.created_at`
+
+func scanWebhookDeliveries(rows pgx.Rows) ([]model.WebhookDelivery, error) {
+	defer rows.Close()
+	out := make([]model.WebhookDelivery, 0)
+	for rows.Next() {
+		var d model.WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Global, &d.URL, &d.Secret, &d.EventType, &d.Payload, &d.Status, &d.Attempts,
+			&d.LastStatusCode, &d.LastError, &d.NextAttemptAt, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// ClaimWebhookDeliveries leases up to limit due deliveries, like
+// ClaimRelayTerminations.
+func (s *Store) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) (_ []model.WebhookDelivery, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	q := `
+with due as (
+  select id
+  from webhook_deliveries
+  where status = 'pending' and next_attempt_at <= now()
+  order by next_attempt_at asc
+  limit $1
+  for update skip locked
+), claimed as (
+  update webhook_deliveries d
+  set next_attempt_at = now() + make_interval(secs => $2), updated_at = now()
+  from due
+  where d.id = due.id
+  returning d.*
+)
+select ` + webhookDeliveryColumns + `
+from claimed d
+join webhooks w on w.id = d.webhook_id`
+	rows, err := s.db.Query(ctx, q, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhookDeliveries(rows)
+}
+
+func (s *Store) CompleteWebhookDelivery(ctx context.Context, id int64, statusCode int) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	_, err = s.db.Exec(ctx, `
+update webhook_deliveries
+set status = 'delivered', attempts = attempts + 1, last_status_code = $2, last_error = null, delivered_at = now(), updated_at = now()
+where id = $1`, id, statusCode)
+	return err
+}
+
+// RetryWebhookDelivery records a failed attempt and reschedules the
+// delivery. statusCode is 0 when no response was received.
+func (s *Store) RetryWebhookDelivery(ctx context.Context, id int64, statusCode int, lastErr string, nextAttemptAt time.Time) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	_, err = s.db.Exec(ctx, `
+update webhook_deliveries
+set attempts = attempts + 1, last_status_code = nullif($2, 0), last_error = $3, next_attempt_at = $4, updated_at = now()
+where id = $1`, id, statusCode, lastErr, nextAttemptAt)
+	return err
+}
+
+// DeadLetterWebhookDelivery records a failed final attempt; the delivery is
+// not retried unless an admin replays it.
+func (s *Store) DeadLetterWebhookDelivery(ctx context.Context, id int64, statusCode int, lastErr string) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	_, err = s.db.Exec(ctx, `
+update webhook_deliveries
+set status = 'dead', attempts = attempts + 1, last_status_code = nullif($2, 0), last_error = $3, updated_at = now()
+where id = $1`, id, statusCode, lastErr)
+	return err
+}
+
+// ListWebhookDeliveries returns the newest deliveries in status.
+func (s *Store) ListWebhookDeliveries(ctx context.Context, status string, limit int) (_ []model.WebhookDelivery, err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Read)
+	defer done(&err)
+	q := `
+select ` + webhookDeliveryColumns + `
+from webhook_deliveries d
+join webhooks w on w.id = d.webhook_id
+where d.status = $1
+order by d.created_at desc
+limit $2`
+	rows, err := s.db.Query(ctx, q, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanWebhookDeliveries(rows)
+}
+
+// ReplayWebhookDelivery queues a dead-lettered delivery again with a fresh
+// attempt budget. ErrNotFound means no dead delivery has that ID.
+func (s *Store) ReplayWebhookDelivery(ctx context.Context, id int64) (err error) {
+	ctx, done := s.withTimeout(ctx, s.timeouts.Write)
+	defer done(&err)
+	tag, err := s.db.Exec(ctx, `
+update webhook_deliveries
+set status = 'pending', attempts = 0, next_attempt_at = now(), updated_at = now()
+where id = $1 and status = 'dead'`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	pgxmock "github.com/pashagolub/pgxmock/v4"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+// expectWebhookEvent expects an event to be queued for the user's webhooks.
+func expectWebhookEvent(mock pgxmock.PgxPoolIface, userID, eventType string) {
+	mock.ExpectExec(regexp.QuoteMeta("insert into webhook_deliveries")).
+		WithArgs(userID, eventType, pgxmock.AnyArg()).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+}
+
+func TestClaimWebhookDeliveries_ReturnsEndpointAndSecret(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	now := time.Now().UTC()
+	payload := json.RawMessage(`{"session_id":"ses_1","user_id":"usr_1"}`)
+	mock.ExpectQuery(regexp.QuoteMeta("update webhook_deliveries d")).
+		WithArgs(20, float64(120)).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "webhook_id", "global", "url", "secret", "event_type", "payload_json", "status", "attempts", "last_status_code", "last_error", "next_attempt_at", "created_at"}).
+			AddRow(int64(7), "whk_1", false, "https://hooks.example.com/aegis", "s3cret", model.WebhookSessionStopped, payload, model.WebhookDeliveryPending, 2, 503, "status 503", now, now))
+
+	out, err := New(mock).ClaimWebhookDeliveries(context.Background(), 20, 2*time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimWebhookDeliveries: %v", err)
+	}
+	if len(out) != 1 || out[0].URL != "https://hooks.example.com/aegis" || out[0].Secret != "s3cret" || out[0].Attempts != 2 || string(out[0].Payload) != string(payload) {
+		t.Fatalf("unexpected deliveries: %+v", out)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestReplayWebhookDelivery_OnlyDeadDeliveries(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatalf("pgxmock pool: %v", err)
+	}
+	defer mock.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("where id = $1 and status = 'dead'")).
+		WithArgs(int64(7)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec(regexp.QuoteMeta("where id = $1 and status = 'dead'")).
+		WithArgs(int64(8)).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+
+	s := New(mock)
+	if err := s.ReplayWebhookDelivery(context.Background(), 7); err != nil {
+		t.Fatalf("ReplayWebhookDelivery: %v", err)
+	}
+	if err := s.ReplayWebhookDelivery(context.Background(), 8); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for a delivery that is not dead, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+// Package stripe reports metered usage to Stripe.
+package stripe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+// DefaultEndpoint is the Stripe API base URL.
+const DefaultEndpoint = "https://api.stripe.com"
+
+// Error is a non-2xx response from Stripe.
+type Error struct {
+	StatusCode int
+	Type       string
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("stripe: status %d type=%s code=%s: %s", e.StatusCode, e.Type, e.Code, e.Message)
+}
+
+type Client struct {
+	client   *http.Client
+	endpoint string
+	apiKey   string
+}
+
+// NewClient talks to endpoint, or DefaultEndpoint when it is empty.
+func NewClient(endpoint, apiKey string) *Client {
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	return &Client{client: &http.Client{Timeout: 30 * time.Second}, endpoint: strings.TrimRight(endpoint, "/"), apiKey: apiKey}
+}
+
+// IdempotencyKey identifies the usage report of one user's cycle, so a
+// retried report is applied once.
+func IdempotencyKey(e model.BillingExport) string {
+	return "aegis-usage-" + e.UserID + "-" + strconv.FormatInt(e.CycleStart.Unix(), 10)
+}
+
+// ReportUsage sets the export's overage seconds as the usage of its
+// subscription item at the end of the cycle and returns the Stripe usage
+// record ID. The report uses action=set under IdempotencyKey, so repeating
+// it never adds usage twice, even after Stripe forgets the key.
+func (c *Client) ReportUsage(ctx context.Context, e model.BillingExport) (string, error) {
+	form := url.Values{}
+	form.Set("quantity", strconv.Itoa(e.OverageSeconds))
+	form.Set("timestamp", strconv.FormatInt(e.CycleEnd.Add(-time.Second).Unix(), 10))
+	form.Set("action", "set")
+	path := "/v1/subscription_items/" + url.PathEscape(e.SubscriptionItemID) + "/usage_records"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(c.apiKey, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Idempotency-Key", IdempotencyKey(e))
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= 300 {
+		var env struct {
+			Error struct {
+				Type    string `json:"type"`
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.Unmarshal(body, &env)
+		return "", &Error{StatusCode: resp.StatusCode, Type: env.Error.Type, Code: env.Error.Code, Message: env.Error.Message}
+	}
+	var out struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return "", fmt.Errorf("stripe: decode usage record: %w", err)
+	}
+	return out.ID, nil
+}
@@ -0,0 +1,60 @@
+package stripe
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+func TestReportUsage_SetsCycleUsageIdempotently(t *testing.T) {
+	cycleStart := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	var gotPath, gotKey, gotUser string
+	var gotForm map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotKey = r.Header.Get("Idempotency-Key")
+		gotUser, _, _ = r.BasicAuth()
+		_ = r.ParseForm()
+		gotForm = map[string]string{"quantity": r.PostForm.Get("quantity"), "timestamp": r.PostForm.Get("timestamp"), "action": r.PostForm.Get("action")}
+		_, _ = w.Write([]byte(`{"id":"mbur_123","object":"usage_record"}`))
+	}))
+	defer srv.Close()
+
+	id, err := NewClient(srv.URL, "sk_test_1").ReportUsage(context.Background(), model.BillingExport{
+		UserID: "usr_1", CycleStart: cycleStart, CycleEnd: cycleStart.AddDate(0, 1, 0), SubscriptionItemID: "si_1", OverageSeconds: 420,
+	})
+	if err != nil {
+		t.Fatalf("ReportUsage: %v", err)
+	}
+	if id != "mbur_123" {
+		t.Fatalf("expected usage record id, got %q", id)
+	}
+	if gotPath != "/v1/subscription_items/si_1/usage_records" || gotUser != "sk_test_1" {
+		t.Fatalf("unexpected request path=%q user=%q", gotPath, gotUser)
+	}
+	if gotKey != "aegis-usage-usr_1-1772323200" {
+		t.Fatalf("expected a per user and cycle idempotency key, got %q", gotKey)
+	}
+	if gotForm["quantity"] != "420" || gotForm["action"] != "set" || gotForm["timestamp"] != "1775001599" {
+		t.Fatalf("unexpected form %v", gotForm)
+	}
+}
+
+func TestReportUsage_ReturnsStripeError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":{"type":"invalid_request_error","code":"resource_missing","message":"No such subscription item"}}`))
+	}))
+	defer srv.Close()
+
+	_, err := NewClient(srv.URL, "sk_test_1").ReportUsage(context.Background(), model.BillingExport{UserID: "usr_1", SubscriptionItemID: "si_gone"})
+	var stripeErr *Error
+	if !errors.As(err, &stripeErr) || stripeErr.StatusCode != http.StatusBadRequest || stripeErr.Code != "resource_missing" {
+		t.Fatalf("expected a Stripe error, got %v", err)
+	}
+}
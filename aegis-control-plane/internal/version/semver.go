@@ -0,0 +1,97 @@
+package version
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Semver is a semantic version. Pre is the prerelease, "" for a release.
+type Semver struct {
+	Major, Minor, Patch int
+	Pre                 string
+}
+
+// ParseSemver parses v leniently, as relay agents report it: a leading "v",
+// surrounding space and build metadata are ignored, and missing minor or
+// patch numbers are 0, so "v1.4" is 1.4.0.
+func ParseSemver(v string) (Semver, error) {
+	s := strings.TrimSpace(v)
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "v"), "V")
+	s, _, _ = strings.Cut(s, "+")
+	core, pre, hasPre := strings.Cut(s, "-")
+	if hasPre && pre == "" {
+		return Semver{}, fmt.Errorf("invalid version %q", v)
+	}
+	parts := strings.Split(core, ".")
+	if len(parts) > 3 {
+		return Semver{}, fmt.Errorf("invalid version %q", v)
+	}
+	var nums [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return Semver{}, fmt.Errorf("invalid version %q", v)
+		}
+		nums[i] = n
+	}
+	return Semver{Major: nums[0], Minor: nums[1], Patch: nums[2], Pre: pre}, nil
+}
+
+func (v Semver) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	return s
+}
+
+// Compare returns -1, 0 or +1 as v sorts before, with or after w. A
+// prerelease sorts before its release.
+func (v Semver) Compare(w Semver) int {
+	if c := cmp.Compare(v.Major, w.Major); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Minor, w.Minor); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.Patch, w.Patch); c != 0 {
+		return c
+	}
+	switch {
+	case v.Pre == w.Pre:
+		return 0
+	case v.Pre == "":
+		return 1
+	case w.Pre == "":
+		return -1
+	}
+	return comparePrerelease(v.Pre, w.Pre)
+}
+
+// comparePrerelease orders prereleases by their dot-separated identifiers:
+// numeric ones numerically and before alphanumeric ones, and a shorter list
+// before a longer one it prefixes.
+func comparePrerelease(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		var c int
+		switch {
+		case aErr == nil && bErr == nil:
+			c = cmp.Compare(an, bn)
+		case aErr == nil:
+			c = -1
+		case bErr == nil:
+			c = 1
+		default:
+			c = strings.Compare(as[i], bs[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(as), len(bs))
+}
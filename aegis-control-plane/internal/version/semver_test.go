@@ -0,0 +1,45 @@
+package version
+
+import "testing"
+
+func TestParseSemver_Lenient(t *testing.T) {
+	for in, want := range map[string]string{
+		"1.4.2":           "1.4.2",
+		"v1.4.2":          "1.4.2",
+		" V2 ":            "2.0.0",
+		"v1.4":            "1.4.0",
+		"1.4.2-rc.1":      "1.4.2-rc.1",
+		"1.4.2+build.7":   "1.4.2",
+		"v1.4.2-beta+abc": "1.4.2-beta",
+	} {
+		v, err := ParseSemver(in)
+		if err != nil || v.String() != want {
+			t.Fatalf("ParseSemver(%q) = %s, %v; want %s", in, v, err, want)
+		}
+	}
+	for _, in := range []string{"", "v", "1.x", "1.2.3.4", "1.2.3-", "-1.0.0", "latest"} {
+		if _, err := ParseSemver(in); err == nil {
+			t.Fatalf("expected ParseSemver(%q) to fail", in)
+		}
+	}
+}
+
+func TestSemver_Compare(t *testing.T) {
+	// Each version sorts after the one before it.
+	ordered := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1",
+		"1.0.0", "1.0.1", "1.2.0", "1.10.0", "2.0.0",
+	}
+	for i := 1; i < len(ordered); i++ {
+		a, _ := ParseSemver(ordered[i-1])
+		b, _ := ParseSemver(ordered[i])
+		if a.Compare(b) != -1 || b.Compare(a) != 1 {
+			t.Fatalf("expected %s < %s", a, b)
+		}
+	}
+	a, _ := ParseSemver("v1.4")
+	b, _ := ParseSemver("1.4.0+build")
+	if a.Compare(b) != 0 {
+		t.Fatalf("expected %s == %s", a, b)
+	}
+}
@@ -0,0 +1,18 @@
+// Package version holds the build information stamped into the binaries
+// with -ldflags, e.g.
+//
+//	go build -ldflags "-X github.com/telemyapp/aegis-control-plane/internal/version.Commit=$(git rev-parse HEAD)" ./cmd/api
+//
+// Unstamped builds (go run, go test) report "dev".
+package version
+
+var (
+	Version   = "dev"
+	Commit    = "dev"
+	BuildDate = "dev"
+)
+
+// String formats the build information for startup logs.
+func String() string {
+	return "version=" + Version + " commit=" + Commit + " build_date=" + BuildDate
+}
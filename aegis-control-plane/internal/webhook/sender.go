@@ -0,0 +1,95 @@
+// Package webhook delivers queued webhook events to subscriber endpoints.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+// Headers sent with every delivery.
+const (
+	EventHeader     = "X-Aegis-Event"
+	DeliveryHeader  = "X-Aegis-Delivery"
+	TimestampHeader = "X-Aegis-Timestamp"
+	SignatureHeader = "X-Aegis-Signature"
+)
+
+// Sign returns the signature header value for body sent at ts: the hex
+// HMAC-SHA256, keyed with the webhook secret, of "<ts>.<body>". Receivers
+// recompute it and reject stale timestamps to prevent replays.
+func Sign(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type Sender struct {
+	client  *http.Client
+	baseURL string
+	now     func() time.Time
+}
+
+// NewSender bounds each delivery attempt by timeout. Payloads link to the
+// webhook on baseURL, the control plane's external URL, unless it is empty.
+func NewSender(timeout time.Duration, baseURL string) *Sender {
+	return &Sender{client: &http.Client{Timeout: timeout}, baseURL: baseURL, now: time.Now}
+}
+
+// Send posts the delivery and returns the response status code, or 0 when
+// no response was received. Any status other than 2xx is an error.
+func (s *Sender) Send(ctx context.Context, d model.WebhookDelivery) (int, error) {
+	envelope := map[string]any{
+		"id":         d.ID,
+		"event":      d.EventType,
+		"created_at": d.CreatedAt.UTC().Format(time.RFC3339),
+		"data":       d.Payload,
+	}
+	if s.baseURL != "" {
+		envelope["links"] = map[string]string{"webhook": s.baseURL + webhookPath(d)}
+	}
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	ts := s.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "aegis-webhooks/1")
+	req.Header.Set(EventHeader, d.EventType)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(SignatureHeader, Sign(d.Secret, ts, body))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook endpoint returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookPath is the API path of the webhook d was sent for.
+func webhookPath(d model.WebhookDelivery) string {
+	if d.Global {
+		return "/api/v1/admin/webhooks/" + d.WebhookID
+	}
+	return "/api/v1/webhooks/" + d.WebhookID
+}
@@ -0,0 +1,100 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+)
+
+func TestSend_SignsBody(t *testing.T) {
+	var got struct {
+		header http.Header
+		body   []byte
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.header = r.Header.Clone()
+		got.body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s := NewSender(time.Second, "")
+	s.now = func() time.Time { return time.Unix(1771700000, 0) }
+	code, err := s.Send(context.Background(), model.WebhookDelivery{
+		ID:        42,
+		URL:       srv.URL,
+		Secret:    "s3cret",
+		EventType: model.WebhookSessionStarted,
+		Payload:   json.RawMessage(`{"session_id":"ses_1"}`),
+		CreatedAt: time.Date(2026, 2, 21, 20, 0, 0, 0, time.UTC),
+	})
+	if err != nil || code != http.StatusNoContent {
+		t.Fatalf("expected 204 delivery, got code=%d err=%v", code, err)
+	}
+	if got.header.Get(EventHeader) != model.WebhookSessionStarted || got.header.Get(DeliveryHeader) != "42" || got.header.Get(TimestampHeader) != "1771700000" {
+		t.Fatalf("unexpected headers: %v", got.header)
+	}
+	if want := Sign("s3cret", 1771700000, got.body); got.header.Get(SignatureHeader) != want {
+		t.Fatalf("expected signature %s over the received body, got %s", want, got.header.Get(SignatureHeader))
+	}
+	var body map[string]any
+	if err := json.Unmarshal(got.body, &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body["event"] != model.WebhookSessionStarted || body["data"].(map[string]any)["session_id"] != "ses_1" || body["links"] != nil {
+		t.Fatalf("unexpected body: %s", got.body)
+	}
+}
+
+func TestSend_LinksToWebhookOnBaseURL(t *testing.T) {
+	var body struct {
+		Links map[string]string `json:"links"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer srv.Close()
+
+	s := NewSender(time.Second, "https://aegis.example.com")
+	for _, tc := range []struct {
+		global bool
+		want   string
+	}{
+		{false, "https://aegis.example.com/api/v1/webhooks/whk_1"},
+		{true, "https://aegis.example.com/api/v1/admin/webhooks/whk_1"},
+	} {
+		body.Links = nil
+		if _, err := s.Send(context.Background(), model.WebhookDelivery{ID: 1, WebhookID: "whk_1", Global: tc.global, URL: srv.URL, Payload: json.RawMessage(`{}`)}); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		if body.Links["webhook"] != tc.want {
+			t.Fatalf("global=%t: expected link %s, got %v", tc.global, tc.want, body.Links)
+		}
+	}
+}
+
+func TestSend_Non2xxIsError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	code, err := NewSender(time.Second, "").Send(context.Background(), model.WebhookDelivery{ID: 1, URL: srv.URL, Payload: json.RawMessage(`{}`)})
+	if err == nil || code != http.StatusServiceUnavailable {
+		t.Fatalf("expected a 503 error, got code=%d err=%v", code, err)
+	}
+}
+
+func TestSign_KnownVector(t *testing.T) {
+	// echo -n '1.{}' | openssl dgst -sha256 -hmac key
+	const want = "sha256=1ba6b8171186efc613e8bcc0cbdab2748f24984d7c5a84faa2637afa0e40d224"
+	if got := Sign("key", 1, []byte("{}")); got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
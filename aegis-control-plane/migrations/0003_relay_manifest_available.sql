@@ -0,0 +1,2 @@
+alter table relay_manifests
+  add column if not exists available boolean not null default true;
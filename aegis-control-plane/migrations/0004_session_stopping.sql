@@ -0,0 +1,26 @@
+alter table sessions drop constraint if exists sessions_status_check;
+alter table sessions
+  add constraint sessions_status_check check (status in ('provisioning', 'active', 'grace', 'stopping', 'stopped'));
+
+drop index if exists sessions_one_active_per_user;
+create unique index if not exists sessions_one_active_per_user
+  on sessions(user_id)
+  where status in ('provisioning', 'active', 'grace', 'stopping');
+
+create table if not exists relay_terminations (
+  id bigserial primary key,
+  session_id text not null unique references sessions(id) on delete cascade,
+  user_id text not null references users(id) on delete cascade,
+  region text not null,
+  aws_instance_id text not null,
+  attempts integer not null default 0,
+  last_error text,
+  next_attempt_at timestamptz not null default now(),
+  completed_at timestamptz,
+  created_at timestamptz not null default now(),
+  check (attempts >= 0)
+);
+
+create index if not exists idx_relay_terminations_pending
+  on relay_terminations(next_attempt_at)
+  where completed_at is null;
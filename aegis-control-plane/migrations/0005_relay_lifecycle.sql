@@ -0,0 +1,6 @@
+alter table relay_instances
+  add column if not exists lifecycle text not null default 'on-demand';
+
+alter table relay_instances drop constraint if exists relay_instances_lifecycle_check;
+alter table relay_instances
+  add constraint relay_instances_lifecycle_check check (lifecycle in ('on-demand', 'spot'));
@@ -0,0 +1,25 @@
+-- A session may outlive its relay: replacement binds a new relay_instances row
+-- while the old one is still terminating.
+alter table relay_instances drop constraint if exists relay_instances_session_id_key;
+create unique index if not exists relay_instances_one_live_per_session
+  on relay_instances(session_id)
+  where state in ('provisioning', 'running');
+
+alter table relay_terminations drop constraint if exists relay_terminations_session_id_key;
+alter table relay_terminations drop constraint if exists relay_terminations_aws_instance_id_key;
+alter table relay_terminations
+  add constraint relay_terminations_aws_instance_id_key unique (aws_instance_id);
+
+alter table sessions
+  add column if not exists replacement_claimed_at timestamptz;
+
+create table if not exists session_events (
+  id bigserial primary key,
+  session_id text not null references sessions(id) on delete cascade,
+  user_id text not null references users(id) on delete cascade,
+  event_type text not null,
+  payload_json jsonb not null,
+  created_at timestamptz not null default now()
+);
+
+create index if not exists idx_session_events_user on session_events(user_id, id);
@@ -0,0 +1,8 @@
+-- Relays started with a static IP record the Elastic IP allocation so
+-- deprovision (and orphan cleanup) can disassociate and release it.
+alter table relay_instances
+  add column if not exists eip_allocation_id text;
+
+create index if not exists relay_instances_eip_allocation_id_idx
+  on relay_instances(eip_allocation_id)
+  where eip_allocation_id is not null;
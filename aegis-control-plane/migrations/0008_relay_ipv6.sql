@@ -0,0 +1,4 @@
+-- Dual-stack relays also expose a public IPv6 address; IPv6-only relays have
+-- no IPv4 public_ip.
+alter table relay_instances
+  add column if not exists public_ipv6 inet;
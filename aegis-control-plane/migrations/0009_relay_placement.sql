@@ -0,0 +1,5 @@
+-- Relays are spread across several subnets (AZs) per region; record where
+-- each one landed.
+alter table relay_instances
+  add column if not exists subnet_id text,
+  add column if not exists availability_zone text;
@@ -0,0 +1,9 @@
+-- Relays may run behind a per-session security group locked to the
+-- streamer's IP; record it so teardown and the orphan reaper can delete it.
+alter table relay_instances
+  add column if not exists security_group_id text,
+  add column if not exists allowed_client_ip inet;
+
+create index if not exists idx_relay_instances_security_group
+  on relay_instances (security_group_id)
+  where security_group_id is not null;
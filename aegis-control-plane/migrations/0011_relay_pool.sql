@@ -0,0 +1,31 @@
+-- Warm pool of stopped relay instances. Provision claims an available one
+-- (deleting its row) before launching cold; the jobs worker replenishes it.
+create table if not exists relay_pool (
+  aws_instance_id text primary key,
+  region text not null,
+  ami_id text not null,
+  instance_type text not null,
+  state text not null default 'warming',
+  launched_at timestamptz not null,
+  created_at timestamptz not null default now(),
+  updated_at timestamptz not null default now(),
+  constraint relay_pool_state_check check (state in ('warming', 'available'))
+);
+
+create index if not exists idx_relay_pool_claim
+  on relay_pool(region, ami_id, instance_type, launched_at)
+  where state = 'available';
+
+-- A pooled instance serves several sessions over its lifetime, so an
+-- aws_instance_id may appear in several relay_instances and
+-- relay_terminations rows; only one may be live at a time.
+alter table relay_instances drop constraint if exists relay_instances_aws_instance_id_key;
+create unique index if not exists relay_instances_one_live_per_aws_instance
+  on relay_instances(aws_instance_id)
+  where state in ('provisioning', 'running');
+create index if not exists idx_relay_instances_aws_instance on relay_instances(aws_instance_id);
+
+alter table relay_terminations drop constraint if exists relay_terminations_aws_instance_id_key;
+create unique index if not exists relay_terminations_one_open_per_aws_instance
+  on relay_terminations(aws_instance_id)
+  where completed_at is null;
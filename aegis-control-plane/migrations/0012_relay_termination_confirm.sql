@@ -0,0 +1,9 @@
+-- Terminations are only marked terminated once the provider confirms the
+-- instance is gone; until then the relay stays 'terminating' and the orphan
+-- reaper re-issues the termination if it lingers.
+alter table relay_instances
+  add column if not exists terminate_requested_at timestamptz;
+
+create index if not exists idx_relay_instances_terminating
+  on relay_instances (terminate_requested_at)
+  where state = 'terminating';
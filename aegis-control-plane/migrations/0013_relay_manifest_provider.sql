@@ -0,0 +1,4 @@
+-- Relays can run on more than one provider. ami_id holds the provider's
+-- image identifier (an AMI on AWS, an image ID or name on Hetzner).
+alter table relay_manifests
+  add column if not exists provider text not null default 'aws';
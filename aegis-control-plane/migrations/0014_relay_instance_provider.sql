@@ -0,0 +1,5 @@
+-- Relays remember which provider launched them so termination and status
+-- checks reach the same backend after the region routing map changes. An
+-- empty provider (relays launched before routing) is routed by region.
+alter table relay_instances
+  add column if not exists provider text not null default '';
@@ -0,0 +1,5 @@
+-- Pair tokens identify a live session during pairing, so no two live
+-- sessions may share one. Activation retries with a fresh token on conflict.
+create unique index if not exists sessions_live_pair_token
+  on sessions(pair_token)
+  where status in ('active', 'grace') and pair_token <> '';
@@ -0,0 +1,9 @@
+-- Users may be created before billing configures a plan. Their cycle stays
+-- null until the first usage read starts a free cycle anchored on the
+-- account creation date.
+alter table users alter column cycle_start_at drop not null;
+alter table users alter column cycle_end_at drop not null;
+
+alter table users drop constraint if exists users_plan_tier_check;
+alter table users add constraint users_plan_tier_check
+  check (plan_tier in ('free', 'starter', 'standard', 'pro'));
@@ -0,0 +1,11 @@
+-- One row per usage threshold a user has crossed in a cycle, so the rollup
+-- job alerts once per threshold per cycle.
+create table if not exists usage_alerts (
+  user_id text not null references users(id) on delete cascade,
+  cycle_start_at timestamptz not null,
+  threshold_percent integer not null,
+  consumed_seconds integer not null,
+  included_seconds integer not null,
+  created_at timestamptz not null default now(),
+  primary key (user_id, cycle_start_at, threshold_percent)
+);
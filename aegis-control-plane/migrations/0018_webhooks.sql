@@ -0,0 +1,39 @@
+-- Webhook endpoints. user_id is null for global webhooks managed by admins,
+-- which receive every user's events. An empty events array subscribes to all
+-- events.
+create table if not exists webhooks (
+  id text primary key,
+  user_id text references users(id) on delete cascade,
+  url text not null,
+  secret text not null,
+  events text[] not null default '{}',
+  created_at timestamptz not null default now(),
+  updated_at timestamptz not null default now()
+);
+
+create index if not exists idx_webhooks_user on webhooks(user_id);
+
+-- Outbox of webhook deliveries, written in the transaction of the change the
+-- event reports and drained by the jobs worker.
+create table if not exists webhook_deliveries (
+  id bigserial primary key,
+  webhook_id text not null references webhooks(id) on delete cascade,
+  event_type text not null,
+  payload_json jsonb not null,
+  status text not null default 'pending',
+  attempts integer not null default 0,
+  next_attempt_at timestamptz not null default now(),
+  last_status_code integer,
+  last_error text,
+  delivered_at timestamptz,
+  created_at timestamptz not null default now(),
+  updated_at timestamptz not null default now(),
+  check (status in ('pending', 'delivered', 'dead'))
+);
+
+create index if not exists idx_webhook_deliveries_due
+  on webhook_deliveries(next_attempt_at)
+  where status = 'pending';
+create index if not exists idx_webhook_deliveries_dead
+  on webhook_deliveries(created_at)
+  where status = 'dead';
@@ -0,0 +1,3 @@
+-- Finance exports read every user's records for the cycles starting on a
+-- given day.
+create index if not exists idx_usage_cycle_start on usage_records(cycle_start_at);
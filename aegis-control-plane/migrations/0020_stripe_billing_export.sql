@@ -0,0 +1,33 @@
+-- Users billed for overage through Stripe carry the subscription item their
+-- metered usage is reported against.
+alter table users add column if not exists stripe_subscription_item_id text;
+
+-- One row per user per ended cycle, tracking its report to Stripe. Failed
+-- reports are retried from here with backoff and parked as 'failed' once
+-- they run out of attempts.
+create table if not exists billing_exports (
+  user_id text not null references users(id) on delete cascade,
+  cycle_start_at timestamptz not null,
+  cycle_end_at timestamptz not null,
+  stripe_subscription_item_id text not null,
+  overage_seconds integer not null,
+  status text not null default 'pending',
+  attempts integer not null default 0,
+  next_attempt_at timestamptz not null default now(),
+  last_error text,
+  stripe_usage_record_id text,
+  reported_at timestamptz,
+  created_at timestamptz not null default now(),
+  updated_at timestamptz not null default now(),
+  primary key (user_id, cycle_start_at),
+  check (status in ('pending', 'reported', 'failed')),
+  check (overage_seconds >= 0),
+  check (attempts >= 0)
+);
+
+create index if not exists idx_billing_exports_due
+  on billing_exports(next_attempt_at)
+  where status = 'pending';
+create index if not exists idx_billing_exports_failed
+  on billing_exports(updated_at)
+  where status = 'failed';
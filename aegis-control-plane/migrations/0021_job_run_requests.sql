@@ -0,0 +1,17 @@
+-- Operator requests to run a background job now, picked up by the jobs
+-- worker in addition to the job's schedule.
+create table if not exists job_run_requests (
+  id bigserial primary key,
+  job text not null,
+  requested_by text not null,
+  status text not null default 'pending',
+  error text,
+  requested_at timestamptz not null default now(),
+  started_at timestamptz,
+  finished_at timestamptz,
+  check (status in ('pending', 'running', 'succeeded', 'failed'))
+);
+
+create index if not exists idx_job_run_requests_pending
+  on job_run_requests(requested_at)
+  where status = 'pending';
@@ -0,0 +1,3 @@
+-- Why a session was stopped, kept until the jobs worker finalizes a
+-- stopping session so the stop is counted under the reason it was asked for.
+alter table sessions add column if not exists stop_reason text;
@@ -0,0 +1,54 @@
+-- How many live sessions a user may have at once is a plan policy. Tiers
+-- without a row allow one. The studio tier allows three simultaneous relays.
+create table if not exists plan_policies (
+  plan_tier text primary key,
+  max_concurrent_sessions integer not null default 1,
+  check (max_concurrent_sessions >= 1)
+);
+
+alter table users drop constraint if exists users_plan_tier_check;
+alter table users add constraint users_plan_tier_check
+  check (plan_tier in ('free', 'starter', 'standard', 'pro', 'studio'));
+
+insert into plan_policies (plan_tier, max_concurrent_sessions)
+values ('studio', 3)
+on conflict (plan_tier) do nothing;
+
+-- The limit replaces the one-live-session unique index. Starts of one user
+-- are serialized on an advisory lock held until commit, so the count sees
+-- every committed start. A start over the limit fails like the index did,
+-- with a unique violation, which the store retries once.
+create or replace function sessions_enforce_live_limit() returns trigger as $$
+declare
+  live_limit integer;
+  live_count integer;
+begin
+  perform pg_advisory_xact_lock(hashtextextended('sessions_live:' || new.user_id, 0));
+  select coalesce((
+    select pp.max_concurrent_sessions
+    from users u
+    join plan_policies pp on pp.plan_tier = u.plan_tier
+    where u.id = new.user_id), 1)
+  into live_limit;
+  select count(*) into live_count
+  from sessions
+  where user_id = new.user_id and status in ('provisioning', 'active', 'grace', 'stopping');
+  if live_count >= live_limit then
+    raise exception 'user % already has % live sessions', new.user_id, live_count
+      using errcode = 'unique_violation', constraint = 'sessions_live_limit';
+  end if;
+  return new;
+end;
+$$ language plpgsql;
+
+drop trigger if exists sessions_live_limit on sessions;
+create trigger sessions_live_limit
+  before insert on sessions
+  for each row
+  when (new.status in ('provisioning', 'active', 'grace', 'stopping'))
+  execute function sessions_enforce_live_limit();
+
+drop index if exists sessions_one_active_per_user;
+create index if not exists idx_sessions_live_by_user
+  on sessions(user_id)
+  where status in ('provisioning', 'active', 'grace', 'stopping');
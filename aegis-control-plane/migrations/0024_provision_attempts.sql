@@ -0,0 +1,22 @@
+-- One row per launch target tried while starting a session's relay, with
+-- the provider's error and how a failed start was cleaned up, so a failed
+-- or leaked start can be investigated after the fact.
+create table if not exists provision_attempts (
+  id bigserial primary key,
+  session_id text not null references sessions(id) on delete cascade,
+  provider text not null default '',
+  region text not null,
+  instance_type text not null default '',
+  started_at timestamptz not null,
+  finished_at timestamptz not null,
+  outcome text not null,
+  aws_error_code text,
+  error text,
+  instance_id text,
+  compensation text,
+  check (outcome in ('succeeded', 'failed')),
+  check (compensation in ('session_stopped', 'termination_queued', 'failed'))
+);
+
+create index if not exists idx_provision_attempts_session
+  on provision_attempts(session_id, started_at);
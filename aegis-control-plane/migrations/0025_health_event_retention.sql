@@ -0,0 +1,16 @@
+-- health_event_retention deletes relay_health_events by created_at, and
+-- outage_reconciliation only reads events created since its last run.
+create index if not exists idx_relay_health_created
+  on relay_health_events(created_at);
+
+-- Reconciliation reads events by session, latest first.
+create index if not exists idx_relay_health_session
+  on relay_health_events(session_id, observed_at desc);
+
+-- High-water marks of jobs that process a table incrementally: the newest
+-- created_at the job has read.
+create table if not exists job_watermarks (
+  job text primary key,
+  high_water timestamptz not null,
+  updated_at timestamptz not null default now()
+);
@@ -0,0 +1,3 @@
+-- The usage rollup reads users updated since its job_watermarks mark, so a
+-- cycle change reaches the user's sessions without a full scan.
+create index if not exists idx_users_updated on users(updated_at);
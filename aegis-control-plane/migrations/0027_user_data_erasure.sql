@@ -0,0 +1,12 @@
+-- One row per erased user. The user's retained history (sessions, usage,
+-- billing exports) is moved to a tombstone user; the original id is kept
+-- only as a hash, so a repeated erasure request finds its earlier result
+-- without the id being stored. rows_json counts the rows touched per table.
+create table if not exists user_data_erasures (
+  id bigserial primary key,
+  subject_hash text not null unique,
+  tombstone_user_id text not null references users(id),
+  requested_by text not null,
+  rows_json jsonb not null,
+  erased_at timestamptz not null default now()
+);
@@ -0,0 +1,10 @@
+-- One row per data export of a user, by the user or an admin. Users may
+-- export their own data once an hour; the rows also show support access.
+create table if not exists user_data_exports (
+  id bigserial primary key,
+  user_id text not null references users(id) on delete cascade,
+  requested_by text not null,
+  created_at timestamptz not null default now()
+);
+
+create index if not exists idx_user_data_exports_user on user_data_exports(user_id, created_at desc);
@@ -0,0 +1,17 @@
+-- Canary sessions are synthetic starts the jobs worker runs against each
+-- region to monitor it end to end. They run as a reserved user on the canary
+-- plan, which allows enough live sessions that one region's stopping session
+-- does not hold up the next, and are flagged so usage rollups skip them.
+alter table sessions add column if not exists canary boolean not null default false;
+
+alter table users drop constraint if exists users_plan_tier_check;
+alter table users add constraint users_plan_tier_check
+  check (plan_tier in ('free', 'starter', 'standard', 'pro', 'studio', 'canary'));
+
+insert into plan_policies (plan_tier, max_concurrent_sessions)
+values ('canary', 20)
+on conflict (plan_tier) do nothing;
+
+insert into users (id, email, plan_tier, included_seconds, created_at, updated_at)
+values ('usr_canary', 'canary@aegis.invalid', 'canary', 0, now(), now())
+on conflict (id) do nothing;
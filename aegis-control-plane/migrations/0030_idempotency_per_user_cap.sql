@@ -0,0 +1,4 @@
+-- The per-user cap on idempotency records keeps each user's most recent
+-- records per endpoint; this index serves that ordering.
+create index if not exists idx_idempotency_user_endpoint_created
+  on idempotency_records(user_id, endpoint, created_at desc);
@@ -0,0 +1,7 @@
+-- Relays report their agent release with each heartbeat. Events from agents
+-- older than AEGIS_RELAY_MIN_AGENT_VERSION are stored but flagged, and
+-- outage reconciliation skips them.
+alter table relay_instances add column if not exists agent_version text;
+
+alter table relay_health_events add column if not exists agent_version text;
+alter table relay_health_events add column if not exists agent_outdated boolean not null default false;
@@ -0,0 +1,5 @@
+-- Where each session was started from, for abuse investigations. Only
+-- admins see these; erasure clears them. Null when collection is disabled
+-- (AEGIS_DISABLE_SESSION_CLIENT_INFO) and for sessions that predate it.
+alter table sessions add column if not exists started_from_ip inet;
+alter table sessions add column if not exists started_user_agent text;
@@ -0,0 +1,6 @@
+-- Non-billable sessions (canaries, admin test starts) keep their durations
+-- for operations but never reach usage_records. Canary sessions so far are
+-- non-billable.
+alter table sessions add column if not exists billable boolean not null default true;
+
+update sessions set billable = false where canary and billable;
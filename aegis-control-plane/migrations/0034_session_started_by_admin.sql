@@ -0,0 +1,8 @@
+-- Sessions an admin started on a user's behalf, for support reproduction,
+-- record the admin. They are non-billable, and how many may be live at once
+-- is capped by AEGIS_ADMIN_MAX_LIVE_SESSIONS.
+alter table sessions add column if not exists started_by_admin text;
+
+create index if not exists idx_sessions_live_admin
+  on sessions(started_by_admin)
+  where started_by_admin is not null and status in ('provisioning', 'active', 'grace', 'stopping');
@@ -0,0 +1,4 @@
+-- Relays may bind several SRT listeners, allocated from a configured range.
+-- srt_port stays the first of them for readers that predate srt_ports;
+-- relays launched before this migration have srt_port only.
+alter table relay_instances add column if not exists srt_ports integer[];
@@ -0,0 +1,29 @@
+-- Admins page through relays newest first, optionally narrowed to one AMI.
+create index if not exists idx_relay_instances_launched
+  on relay_instances(launched_at desc, id desc);
+create index if not exists idx_relay_instances_ami
+  on relay_instances(ami_id, launched_at desc);
+
+-- Per UTC day, how the session relays of each region, AMI and instance type
+-- held up: how many were up that day, how many went quiet for longer than
+-- the heartbeat timeout and for how long, and how many launched that day
+-- never sent a heartbeat. Written by the relay_quality_rollup job, so a
+-- regression in a new AMI shows up without scanning health events per
+-- request.
+create table if not exists relay_quality_daily (
+  day date not null,
+  region text not null,
+  ami_id text not null,
+  instance_type text not null,
+  relays integer not null,
+  relays_with_gaps integer not null,
+  health_gaps integer not null,
+  gap_seconds bigint not null,
+  never_healthy integer not null,
+  updated_at timestamptz not null default now(),
+  primary key (day, region, ami_id, instance_type),
+  check (relays >= 0 and relays_with_gaps >= 0 and health_gaps >= 0 and gap_seconds >= 0 and never_healthy >= 0)
+);
+
+create index if not exists idx_relay_quality_daily_ami
+  on relay_quality_daily(ami_id, day desc);
@@ -0,0 +1,18 @@
+-- Why each relay was terminated and how long its deprovision took, so
+-- instance-hours can be attributed to user stops, compensation for failed
+-- starts, replacements and the orphan reaper. The outbox carries the reason
+-- to the worker that terminates the instance.
+alter table relay_instances
+  add column if not exists terminated_reason text,
+  add column if not exists terminate_duration_ms bigint;
+
+alter table relay_instances drop constraint if exists relay_instances_terminate_duration_check;
+alter table relay_instances
+  add constraint relay_instances_terminate_duration_check check (terminate_duration_ms >= 0);
+
+alter table relay_terminations
+  add column if not exists reason text;
+
+create index if not exists idx_relay_instances_terminated
+  on relay_instances (terminated_at)
+  where terminated_at is not null;
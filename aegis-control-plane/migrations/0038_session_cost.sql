@@ -0,0 +1,24 @@
+-- Hourly relay prices, written by the API from AEGIS_RELAY_HOURLY_PRICES and
+-- AEGIS_RELAY_DEFAULT_HOURLY_PRICE at startup and on config reload, so the
+-- jobs worker prices sessions with the same, reloaded, values. An empty
+-- region prices the type in every region; the row with neither is the
+-- default for types without a price.
+create table if not exists relay_prices (
+  region text not null default '',
+  instance_type text not null default '',
+  hourly_usd numeric(12, 6) not null,
+  updated_at timestamptz not null default now(),
+  primary key (region, instance_type),
+  check (hourly_usd >= 0)
+);
+
+-- Each session's estimated relay cost: the hourly price of its relay times
+-- how long it ran. Set when the session stops and kept current for live
+-- sessions by the usage rollup. Null when no price applies.
+alter table sessions
+  add column if not exists hourly_price_usd numeric(12, 6),
+  add column if not exists estimated_cost_usd numeric(14, 6);
+
+alter table sessions drop constraint if exists sessions_estimated_cost_check;
+alter table sessions
+  add constraint sessions_estimated_cost_check check (hourly_price_usd >= 0 and estimated_cost_usd >= 0);
@@ -0,0 +1,20 @@
+-- How far a provisioning session's start has got, for clients to show
+-- progress: requested, launching (the provider call), waiting_boot (the
+-- relay is booting) and ready (activated). Phases only move forward; each
+-- change is also appended to session_events. A failed start keeps the phase
+-- it reached.
+alter table sessions
+  add column if not exists provisioning_phase text;
+
+alter table sessions drop constraint if exists sessions_provisioning_phase_check;
+alter table sessions
+  add constraint sessions_provisioning_phase_check
+  check (provisioning_phase in ('requested', 'launching', 'waiting_boot', 'ready'));
+
+-- Live sessions started before this migration have a relay, so they got
+-- through every phase.
+update sessions
+set provisioning_phase = 'ready'
+where provisioning_phase is null
+  and relay_instance_id is not null
+  and status in ('active', 'grace', 'stopping');
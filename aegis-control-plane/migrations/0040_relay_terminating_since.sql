@@ -0,0 +1,10 @@
+-- When a relay first started terminating. terminate_requested_at moves with
+-- every re-issued termination, so it cannot tell a relay stuck for minutes
+-- from one leaked for days; the orphan reaper alerts on this instead.
+alter table relay_instances
+  add column if not exists terminating_since timestamptz;
+
+update relay_instances
+set terminating_since = coalesce(terminate_requested_at, now())
+where state = 'terminating'
+  and terminating_since is null;
@@ -0,0 +1,17 @@
+-- Why the user's client says it stopped a session, next to stop_reason
+-- (which is 'user' for all of them): user_requested, stream_ended,
+-- switching_region or other, with an optional free-text detail. Null for
+-- stops clients did not ask for and for stops before this migration.
+alter table sessions
+  add column if not exists client_stop_reason text,
+  add column if not exists client_stop_detail text;
+
+alter table sessions drop constraint if exists sessions_client_stop_reason_check;
+alter table sessions
+  add constraint sessions_client_stop_reason_check
+  check (client_stop_reason in ('user_requested', 'stream_ended', 'switching_region', 'other'));
+
+alter table sessions drop constraint if exists sessions_client_stop_detail_check;
+alter table sessions
+  add constraint sessions_client_stop_detail_check
+  check (char_length(client_stop_detail) <= 200);
@@ -0,0 +1,13 @@
+-- Labels users give their sessions at start (client_context.tags), such as
+-- a show or client name: at most 5, each lower-case and at most 32
+-- characters, kept sorted. GET /api/v1/sessions and the admin session list
+-- filter on one with tags @> array[tag], which the GIN index serves.
+alter table sessions
+  add column if not exists tags text[] not null default '{}';
+
+alter table sessions drop constraint if exists sessions_tags_check;
+alter table sessions
+  add constraint sessions_tags_check
+  check (cardinality(tags) <= 5);
+
+create index if not exists sessions_tags_idx on sessions using gin (tags);
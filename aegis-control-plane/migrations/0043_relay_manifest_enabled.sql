@@ -0,0 +1,6 @@
+-- Operator switch for a region, e.g. during a provider incident: no new
+-- session starts in a disabled region while its sessions and relays carry on.
+-- Unlike available, which the API rewrites from config at startup and on
+-- reload, enabled is only changed by PATCH /api/v1/admin/manifest/{region}.
+alter table relay_manifests
+  add column if not exists enabled boolean not null default true;
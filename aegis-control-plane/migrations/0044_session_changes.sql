@@ -0,0 +1,55 @@
+-- Changefeed of session status for internal consumers such as analytics,
+-- read with GET /api/v1/internal/changes. A trigger appends a row in the
+-- transaction that changes the status, so the feed has every committed change
+-- and none that rolled back.
+create table if not exists session_changes (
+  seq bigserial primary key,
+  -- The writing transaction (pg_current_xact_id). Readers only return rows
+  -- of transactions older than every running one, in (txid, seq) order, so
+  -- a row that commits late never lands behind a consumer's position.
+  txid bigint not null,
+  session_id text not null,
+  user_id text not null,
+  region text not null,
+  status text not null,
+  previous_status text,
+  stop_reason text,
+  changed_at timestamptz not null default now()
+);
+
+create index if not exists session_changes_txid_seq_idx
+  on session_changes(txid, seq);
+
+create index if not exists session_changes_changed_at_idx
+  on session_changes(changed_at);
+
+-- How far each named consumer has read, as the position of the last row it
+-- acknowledged; the session_change_retention job deletes the rows before
+-- every consumer's position.
+create table if not exists session_change_consumers (
+  consumer text primary key,
+  acked_txid bigint not null,
+  acked_seq bigint not null,
+  updated_at timestamptz not null default now()
+);
+
+create or replace function sessions_record_change() returns trigger as $$
+begin
+  if tg_op = 'UPDATE' and new.status = old.status then
+    return null;
+  end if;
+  insert into session_changes (txid, session_id, user_id, region, status, previous_status, stop_reason)
+  values (
+    pg_current_xact_id()::text::bigint,
+    new.id, new.user_id, new.region, new.status,
+    case when tg_op = 'UPDATE' then old.status end,
+    new.stop_reason);
+  return null;
+end;
+$$ language plpgsql;
+
+drop trigger if exists sessions_record_change on sessions;
+create trigger sessions_record_change
+  after insert or update of status on sessions
+  for each row
+  execute function sessions_record_change();
@@ -0,0 +1,22 @@
+// Package migrations embeds the SQL migrations so tools and tests can apply
+// them without a checkout. Files apply in filename order.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Files returns the migration file names in the order they apply.
+func Files() ([]string, error) {
+	return fs.Glob(files, "*.sql")
+}
+
+// Read returns the SQL of one migration.
+func Read(name string) (string, error) {
+	b, err := files.ReadFile(name)
+	return string(b), err
+}
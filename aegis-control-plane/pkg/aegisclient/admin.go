@@ -0,0 +1,190 @@
+package aegisclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// The admin calls below need a token with the admin role.
+
+type AdminSession struct {
+	ID               string     `json:"session_id"`
+	UserID           string     `json:"user_id"`
+	Status           string     `json:"status"`
+	Region           string     `json:"region"`
+	InstanceID       string     `json:"instance_id"`
+	RelayLifecycle   string     `json:"relay_lifecycle"`
+	SubnetID         string     `json:"subnet_id"`
+	AvailabilityZone string     `json:"availability_zone"`
+	PublicIP         string     `json:"public_ip"`
+	StartedAt        time.Time  `json:"started_at"`
+	StoppedAt        *time.Time `json:"stopped_at,omitempty"`
+	DurationSeconds  int        `json:"duration_seconds"`
+	Tags             []string   `json:"tags,omitempty"`
+}
+
+// ListSessions lists the newest sessions, optionally only those in status.
+// A zero limit uses the server's default.
+func (c *Client) ListSessions(ctx context.Context, status string, limit int) ([]AdminSession, error) {
+	q := url.Values{}
+	if status != "" {
+		q.Set("status", status)
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var out struct {
+		Sessions []AdminSession `json:"sessions"`
+	}
+	_, err := c.do(ctx, http.MethodGet, withQuery("/api/v1/admin/sessions", q), nil, nil, authUser, &out)
+	return out.Sessions, err
+}
+
+// SessionRelayState is the database's view of a session's relay next to
+// the provider's. Relay is nil for a session without a relay, Provider when
+// the provider could not be asked (see ProviderError).
+type SessionRelayState struct {
+	SessionID     string          `json:"session_id"`
+	UserID        string          `json:"user_id"`
+	SessionStatus string          `json:"session_status"`
+	Relay         *RelayRecord    `json:"relay"`
+	Provider      *ProviderStatus `json:"provider"`
+	ProviderError string          `json:"provider_error,omitempty"`
+}
+
+type RelayRecord struct {
+	RelayInstanceID string     `json:"relay_instance_id"`
+	Region          string     `json:"region"`
+	InstanceID      string     `json:"instance_id"`
+	State           string     `json:"state"`
+	PublicIP        string     `json:"public_ip"`
+	Provider        string     `json:"provider,omitempty"`
+	LaunchedAt      *time.Time `json:"launched_at,omitempty"`
+	TerminatedAt    *time.Time `json:"terminated_at,omitempty"`
+	LastHealthAt    *time.Time `json:"last_health_at,omitempty"`
+}
+
+type ProviderStatus struct {
+	State      string     `json:"state"`
+	PublicIP   string     `json:"public_ip"`
+	LaunchedAt *time.Time `json:"launched_at,omitempty"`
+}
+
+func (c *Client) SessionRelay(ctx context.Context, sessionID string) (SessionRelayState, error) {
+	var out SessionRelayState
+	_, err := c.do(ctx, http.MethodGet, "/api/v1/admin/sessions/"+url.PathEscape(sessionID)+"/relay", nil, nil, authUser, &out)
+	return out, err
+}
+
+// AdminStopSession stops any user's session.
+func (c *Client) AdminStopSession(ctx context.Context, sessionID string) (StopResult, error) {
+	var out StopResult
+	_, err := c.do(ctx, http.MethodPost, "/api/v1/admin/sessions/"+url.PathEscape(sessionID)+"/stop", nil, nil, authUser, &out)
+	return out, err
+}
+
+// UserUsage returns a user's usage in their current cycle.
+func (c *Client) UserUsage(ctx context.Context, userID string) (Usage, error) {
+	var out Usage
+	_, err := c.do(ctx, http.MethodGet, "/api/v1/admin/users/"+url.PathEscape(userID)+"/usage", nil, nil, authUser, &out)
+	return out, err
+}
+
+// ManifestUpdate changes a region's manifest entry; nil and empty fields
+// keep their current value.
+type ManifestUpdate struct {
+	Available           *bool  `json:"available,omitempty"`
+	AMIID               string `json:"ami_id,omitempty"`
+	DefaultInstanceType string `json:"default_instance_type,omitempty"`
+}
+
+// SetManifestRegion updates a region's manifest entry. The API restores the
+// manifest from its config when it restarts or reloads config.
+func (c *Client) SetManifestRegion(ctx context.Context, region string, u ManifestUpdate) (Region, error) {
+	var out Region
+	_, err := c.do(ctx, http.MethodPut, "/api/v1/admin/manifest/"+url.PathEscape(region), u, nil, authUser, &out)
+	return out, err
+}
+
+// SwitchManifestRegion switches new sessions in a region on or off. Unlike
+// SetManifestRegion, the switch outlasts restarts and config reloads.
+func (c *Client) SwitchManifestRegion(ctx context.Context, region string, enabled bool) (Region, error) {
+	var out Region
+	body := map[string]bool{"enabled": enabled}
+	_, err := c.do(ctx, http.MethodPatch, "/api/v1/admin/relay/manifest/"+url.PathEscape(region), body, nil, authUser, &out)
+	return out, err
+}
+
+// Job run statuses.
+const (
+	JobRunPending   = "pending"
+	JobRunRunning   = "running"
+	JobRunSucceeded = "succeeded"
+	JobRunFailed    = "failed"
+)
+
+type JobRun struct {
+	ID          int64      `json:"run_id"`
+	Job         string     `json:"job"`
+	RequestedBy string     `json:"requested_by"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	RequestedAt time.Time  `json:"requested_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// Done reports whether the run has finished.
+func (r JobRun) Done() bool {
+	return r.Status == JobRunSucceeded || r.Status == JobRunFailed
+}
+
+// RunJob asks the jobs worker to run a background job now. The run is
+// queued; poll JobRun for its outcome.
+func (c *Client) RunJob(ctx context.Context, name string) (JobRun, error) {
+	var out struct {
+		Run JobRun `json:"run"`
+	}
+	_, err := c.do(ctx, http.MethodPost, "/api/v1/admin/jobs/"+url.PathEscape(name)+"/runs", nil, nil, authUser, &out)
+	return out.Run, err
+}
+
+func (c *Client) JobRun(ctx context.Context, id int64) (JobRun, error) {
+	var out struct {
+		Run JobRun `json:"run"`
+	}
+	_, err := c.do(ctx, http.MethodGet, "/api/v1/admin/jobs/runs/"+strconv.FormatInt(id, 10), nil, nil, authUser, &out)
+	return out.Run, err
+}
+
+type HealthEvent struct {
+	RelayInstanceID      string    `json:"relay_instance_id"`
+	ObservedAt           time.Time `json:"observed_at"`
+	IngestActive         bool      `json:"ingest_active"`
+	EgressActive         bool      `json:"egress_active"`
+	SessionUptimeSeconds int       `json:"session_uptime_seconds"`
+}
+
+// RelayHealth returns a session's latest relay heartbeats, newest first. A
+// zero limit uses the server's default.
+func (c *Client) RelayHealth(ctx context.Context, sessionID string, limit int) ([]HealthEvent, error) {
+	q := url.Values{}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var out struct {
+		Health []HealthEvent `json:"health"`
+	}
+	_, err := c.do(ctx, http.MethodGet, withQuery("/api/v1/admin/sessions/"+url.PathEscape(sessionID)+"/health", q), nil, nil, authUser, &out)
+	return out.Health, err
+}
+
+func withQuery(path string, q url.Values) string {
+	if len(q) == 0 {
+		return path
+	}
+	return path + "?" + q.Encode()
+}
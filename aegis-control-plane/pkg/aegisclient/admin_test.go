@@ -0,0 +1,107 @@
+package aegisclient_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+	"github.com/telemyapp/aegis-control-plane/pkg/aegisclient"
+)
+
+func (m *memStore) ListSessions(_ context.Context, status, tag string, _ int) ([]model.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]model.Session, 0, len(m.sessions))
+	for _, sess := range m.sessions {
+		if (status == "" || string(sess.Status) == status) && (tag == "" || slices.Contains(sess.Tags, tag)) {
+			out = append(out, *sess)
+		}
+	}
+	return out, nil
+}
+
+func (m *memStore) GetSessionRelay(_ context.Context, sessionID string) (*model.SessionRelay, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, ok := m.sessions[sessionID]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &model.SessionRelay{SessionID: sess.ID, UserID: sess.UserID, SessionStatus: sess.Status}, nil
+}
+
+func (m *memStore) RequestJobRun(_ context.Context, job, requestedBy string) (*model.JobRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	run := model.JobRun{ID: int64(len(m.jobRuns) + 1), Job: job, RequestedBy: requestedBy, Status: model.JobRunPending, RequestedAt: time.Now()}
+	m.jobRuns = append(m.jobRuns, run)
+	return &run, nil
+}
+
+func (m *memStore) GetJobRun(_ context.Context, id int64) (*model.JobRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id < 1 || id > int64(len(m.jobRuns)) {
+		return nil, store.ErrNotFound
+	}
+	run := m.jobRuns[id-1]
+	return &run, nil
+}
+
+func testAdminJWT(t *testing.T, userID string) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"uid": userID, "role": "admin", "exp": time.Now().Add(time.Hour).Unix()})
+	signed, err := tok.SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatalf("sign jwt: %v", err)
+	}
+	return signed
+}
+
+func TestContract_AdminSessionsAndJobs(t *testing.T) {
+	st := newMemStore()
+	srv := newServer(t, st, nil)
+	ctx := context.Background()
+
+	sess, err := aegisclient.New(srv.URL, aegisclient.WithToken(testJWT(t, "usr_1"))).StartRelay(ctx, aegisclient.StartOptions{})
+	if err != nil {
+		t.Fatalf("StartRelay: %v", err)
+	}
+
+	admin := aegisclient.New(srv.URL, aegisclient.WithToken(testAdminJWT(t, "usr_admin")))
+	sessions, err := admin.ListSessions(ctx, aegisclient.StatusActive, 10)
+	if err != nil {
+		t.Fatalf("ListSessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != sess.ID || sessions[0].UserID != "usr_1" || sessions[0].StartedAt.IsZero() {
+		t.Fatalf("unexpected sessions %+v", sessions)
+	}
+	stopped, err := admin.AdminStopSession(ctx, sess.ID)
+	if err != nil {
+		t.Fatalf("AdminStopSession: %v", err)
+	}
+	if stopped.Status != aegisclient.StatusStopped {
+		t.Fatalf("unexpected stop result %+v", stopped)
+	}
+	usage, err := admin.UserUsage(ctx, "usr_1")
+	if err != nil || usage.IncludedSeconds != 3600 {
+		t.Fatalf("UserUsage: %+v %v", usage, err)
+	}
+
+	run, err := admin.RunJob(ctx, "session_usage_rollup")
+	if err != nil {
+		t.Fatalf("RunJob: %v", err)
+	}
+	if run.ID != 1 || run.Status != aegisclient.JobRunPending || run.Done() {
+		t.Fatalf("unexpected run %+v", run)
+	}
+	st.jobRuns[0].Status = model.JobRunSucceeded
+	if run, err = admin.JobRun(ctx, run.ID); err != nil || !run.Done() {
+		t.Fatalf("JobRun: %+v %v", run, err)
+	}
+}
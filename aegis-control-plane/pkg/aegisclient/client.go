@@ -0,0 +1,329 @@
+// Package aegisclient is a Go client for the Aegis control-plane API v1. It
+// lives in the server's module and is versioned with it, so a client and a
+// server built from the same tree agree on the API.
+package aegisclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIVersion is the API version the client speaks.
+const APIVersion = "v1"
+
+const userAgent = "aegisclient/" + APIVersion
+
+// ErrNoActiveSession is returned by ActiveSession when the user has no
+// session.
+var ErrNoActiveSession = errors.New("aegisclient: no active session")
+
+// APIError is an error response from the API.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	RequestID  string
+	// RetryAfter is the server's Retry-After hint on 503 and
+	// provisioning_in_progress responses.
+	RetryAfter time.Duration
+	// SessionID is the still provisioning session a
+	// provisioning_in_progress start found.
+	SessionID string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("aegis api: %d %s: %s", e.StatusCode, e.Code, e.Message)
+	if e.RequestID != "" {
+		msg += " (request_id=" + e.RequestID + ")"
+	}
+	return msg
+}
+
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	token      func(context.Context) (string, error)
+	relayKey   string
+
+	maxRetries    int
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+}
+
+type Option func(*Client)
+
+// WithToken authenticates user calls with a fixed control-plane JWT.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = func(context.Context) (string, error) { return token, nil }
+	}
+}
+
+// WithTokenSource fetches the JWT before every request, for callers that
+// refresh it.
+func WithTokenSource(fn func(context.Context) (string, error)) Option {
+	return func(c *Client) {
+		c.token = fn
+	}
+}
+
+// WithRelayKey sets the shared key relays send with ReportHealth.
+func WithRelayKey(key string) Option {
+	return func(c *Client) {
+		c.relayKey = key
+	}
+}
+
+// WithHTTPClient replaces the default http.Client, e.g. to bound attempts
+// with a timeout.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithRetries sets how many times a failed request is retried and the
+// initial delay, which doubles per retry. Zero retries disables retrying.
+func WithRetries(maxRetries int, delay time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.retryDelay = delay
+	}
+}
+
+// New returns a client for the API at baseURL (e.g. https://api.telemy.app).
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		httpClient:    &http.Client{},
+		maxRetries:    3,
+		retryDelay:    500 * time.Millisecond,
+		maxRetryDelay: 30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// StartRelay starts a relay session, or returns the user's current one. A
+// request without an IdempotencyKey gets a fresh one, reused by its
+// retries so they cannot start a second session.
+func (c *Client) StartRelay(ctx context.Context, opts StartOptions) (Session, error) {
+	key := opts.IdempotencyKey
+	if key == "" {
+		key = uuid.NewString()
+	}
+	body := startRequest{RegionPreference: opts.RegionPreference, ClientContext: opts.ClientContext, StaticIP: opts.StaticIP}
+	var out struct {
+		Session Session `json:"session"`
+	}
+	_, err := c.do(ctx, http.MethodPost, "/api/v1/relay/start", body, http.Header{"Idempotency-Key": {key}}, authUser, &out)
+	return out.Session, err
+}
+
+// StopRelay stops the session. reason is user_requested (also when empty),
+// stream_ended, switching_region or other. Stopping an already stopped
+// session is not an error.
+func (c *Client) StopRelay(ctx context.Context, sessionID, reason string) (StopResult, error) {
+	var out StopResult
+	_, err := c.do(ctx, http.MethodPost, "/api/v1/relay/stop", stopRequest{SessionID: sessionID, Reason: reason}, nil, authUser, &out)
+	return out, err
+}
+
+// ActiveSession returns the user's current session, or ErrNoActiveSession.
+func (c *Client) ActiveSession(ctx context.Context) (Session, error) {
+	var out struct {
+		Session Session `json:"session"`
+	}
+	status, err := c.do(ctx, http.MethodGet, "/api/v1/relay/active", nil, nil, authUser, &out)
+	if err == nil && status == http.StatusNoContent {
+		return Session{}, ErrNoActiveSession
+	}
+	return out.Session, err
+}
+
+// Usage returns the user's usage in the current cycle.
+func (c *Client) Usage(ctx context.Context) (Usage, error) {
+	var out Usage
+	_, err := c.do(ctx, http.MethodGet, "/api/v1/usage/current", nil, nil, authUser, &out)
+	return out, err
+}
+
+// Manifest lists the regions relays can run in.
+func (c *Client) Manifest(ctx context.Context) ([]Region, error) {
+	var out struct {
+		Regions []Region `json:"regions"`
+	}
+	_, err := c.do(ctx, http.MethodGet, "/api/v1/relay/manifest", nil, nil, authUser, &out)
+	return out.Regions, err
+}
+
+// ReportHealth sends a relay heartbeat, authenticated with WithRelayKey.
+// A zero ObservedAt lets the server use its receive time.
+func (c *Client) ReportHealth(ctx context.Context, h Health) (HealthReply, error) {
+	body := healthRequest{
+		SessionID:            h.SessionID,
+		InstanceID:           h.InstanceID,
+		IngestActive:         h.IngestActive,
+		EgressActive:         h.EgressActive,
+		SessionUptimeSeconds: h.SessionUptimeSeconds,
+	}
+	if !h.ObservedAt.IsZero() {
+		body.ObservedAt = h.ObservedAt.UTC().Format(time.RFC3339)
+	}
+	var out HealthReply
+	_, err := c.do(ctx, http.MethodPost, "/api/v1/relay/health", body, nil, authRelay, &out)
+	return out, err
+}
+
+type authKind int
+
+const (
+	authUser authKind = iota
+	authRelay
+)
+
+// do sends the request, retrying network errors and 5xx responses with
+// backoff, and decodes a 2xx body into out. Every API call is safe to
+// repeat: reads are idempotent, starts carry an Idempotency-Key, stops and
+// heartbeats converge on the same state, and a job run queued twice only
+// runs the job an extra time.
+func (c *Client) do(ctx context.Context, method, path string, in any, header http.Header, auth authKind, out any) (int, error) {
+	var payload []byte
+	if in != nil {
+		var err error
+		if payload, err = json.Marshal(in); err != nil {
+			return 0, err
+		}
+	}
+	delay := c.retryDelay
+	for attempt := 0; ; attempt++ {
+		status, wait, err := c.attempt(ctx, method, path, payload, header, auth, out)
+		if err == nil || attempt >= c.maxRetries || !retryable(err) {
+			return status, err
+		}
+		wait = max(wait, delay)
+		delay = min(delay*2, c.maxRetryDelay)
+		select {
+		case <-ctx.Done():
+			return status, err
+		case <-time.After(min(wait, c.maxRetryDelay)):
+		}
+	}
+}
+
+// attempt sends one request. wait is the server's Retry-After, if any.
+func (c *Client) attempt(ctx context.Context, method, path string, payload []byte, header http.Header, auth authKind, out any) (status int, wait time.Duration, err error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return 0, 0, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	switch auth {
+	case authUser:
+		if c.token != nil {
+			tok, err := c.token(ctx)
+			if err != nil {
+				return 0, 0, fmt.Errorf("aegisclient: token: %w", err)
+			}
+			req.Header.Set("Authorization", "Bearer "+tok)
+		}
+	case authRelay:
+		req.Header.Set("X-Relay-Auth", c.relayKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, 0, &transportError{err: err}
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return resp.StatusCode, 0, &transportError{err: err}
+	}
+	if resp.StatusCode >= 300 {
+		apiErr := decodeAPIError(resp, raw)
+		return resp.StatusCode, apiErr.RetryAfter, apiErr
+	}
+	if out != nil && resp.StatusCode != http.StatusNoContent && len(raw) > 0 {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return resp.StatusCode, 0, fmt.Errorf("aegisclient: decode %s %s: %w", method, path, err)
+		}
+	}
+	return resp.StatusCode, 0, nil
+}
+
+func decodeAPIError(resp *http.Response, raw []byte) *APIError {
+	var env struct {
+		Error struct {
+			Code              string `json:"code"`
+			Message           string `json:"message"`
+			RequestID         string `json:"request_id"`
+			RetryAfterSeconds int    `json:"retry_after_seconds"`
+			SessionID         string `json:"session_id"`
+		} `json:"error"`
+	}
+	_ = json.Unmarshal(raw, &env)
+	e := &APIError{
+		StatusCode: resp.StatusCode,
+		Code:       env.Error.Code,
+		Message:    env.Error.Message,
+		RequestID:  env.Error.RequestID,
+		SessionID:  env.Error.SessionID,
+	}
+	if e.Code == "" {
+		e.Code = strings.ReplaceAll(strings.ToLower(http.StatusText(resp.StatusCode)), " ", "_")
+	}
+	if e.Message == "" {
+		e.Message = strings.TrimSpace(string(raw))
+	}
+	if e.RequestID == "" {
+		e.RequestID = resp.Header.Get("X-Request-Id")
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	} else if env.Error.RetryAfterSeconds > 0 {
+		e.RetryAfter = time.Duration(env.Error.RetryAfterSeconds) * time.Second
+	}
+	return e
+}
+
+// transportError is a request that got no complete response, such as a
+// refused connection or a timeout.
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string { return "aegisclient: " + e.err.Error() }
+func (e *transportError) Unwrap() error { return e.err }
+
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500 && apiErr.StatusCode != http.StatusNotImplemented
+	}
+	var te *transportError
+	return errors.As(err, &te) && !errors.Is(err, context.Canceled)
+}
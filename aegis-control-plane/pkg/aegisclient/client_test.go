@@ -0,0 +1,387 @@
+package aegisclient_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/telemyapp/aegis-control-plane/internal/api"
+	"github.com/telemyapp/aegis-control-plane/internal/config"
+	"github.com/telemyapp/aegis-control-plane/internal/model"
+	"github.com/telemyapp/aegis-control-plane/internal/relay"
+	"github.com/telemyapp/aegis-control-plane/internal/store"
+	"github.com/telemyapp/aegis-control-plane/pkg/aegisclient"
+)
+
+// memStore keeps one user's sessions in memory, enough of api.Store for the
+// endpoints the client calls; the rest panic through the nil embedded
+// interface.
+type memStore struct {
+	api.Store
+
+	mu       sync.Mutex
+	sessions map[string]*model.Session
+	byKey    map[string]string
+	health   []store.RelayHealthInput
+	jobRuns  []model.JobRun
+}
+
+func newMemStore() *memStore {
+	return &memStore{sessions: make(map[string]*model.Session), byKey: make(map[string]string)}
+}
+
+func (m *memStore) StartOrGetSession(_ context.Context, in store.StartInput) (*model.Session, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id, ok := m.byKey[in.IdempotencyKey.String()]; ok {
+		sess := *m.sessions[id]
+		return &sess, false, nil
+	}
+	for _, sess := range m.sessions {
+		if sess.UserID == in.UserID && sess.Status != model.SessionStopped {
+			out := *sess
+			return &out, false, nil
+		}
+	}
+	sess := &model.Session{
+		ID: "ses_" + in.IdempotencyKey.String()[:8], UserID: in.UserID, Status: model.SessionProvisioning, Region: in.Region,
+		ProvisioningPhase: model.ProvisioningRequested, StartedAt: time.Now(), GraceWindowSeconds: 600, MaxSessionSeconds: 57600, Tags: in.Tags,
+	}
+	m.sessions[sess.ID] = sess
+	m.byKey[in.IdempotencyKey.String()] = sess.ID
+	out := *sess
+	return &out, true, nil
+}
+
+func (m *memStore) AdvanceProvisioningPhase(_ context.Context, _, sessionID, phase string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[sessionID].ProvisioningPhase = phase
+	return nil
+}
+
+func (m *memStore) ActivateProvisionedSession(_ context.Context, in store.ActivateProvisionedSessionInput) (*model.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess := m.sessions[in.SessionID]
+	sess.Status, sess.ProvisioningPhase = model.SessionActive, model.ProvisioningReady
+	sess.PublicIP, sess.SRTPort, sess.SRTPorts, sess.WSURL = in.PublicIP, in.SRTPorts[0], in.SRTPorts, in.WSURL
+	sess.PairToken, sess.RelayWSToken = in.PairToken, in.RelayWSToken
+	out := *sess
+	return &out, nil
+}
+
+func (m *memStore) GetActiveSession(_ context.Context, userID string) (*model.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, sess := range m.sessions {
+		if sess.UserID == userID && sess.Status != model.SessionStopped {
+			out := *sess
+			return &out, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (m *memStore) StopSession(_ context.Context, in store.StopSessionInput) (*model.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, ok := m.sessions[in.SessionID]
+	if !ok || sess.UserID != in.UserID {
+		return nil, store.ErrNotFound
+	}
+	if sess.StoppedAt == nil {
+		now := time.Now()
+		sess.Status, sess.StoppedAt = model.SessionStopped, &now
+	}
+	out := *sess
+	return &out, nil
+}
+
+func (m *memStore) GetUsageCurrent(_ context.Context, _ string, freeIncludedSeconds int) (*model.UsageCurrent, error) {
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	return &model.UsageCurrent{
+		PlanTier: "free", CycleStart: start, CycleEnd: start.AddDate(0, 1, 0),
+		IncludedSeconds: freeIncludedSeconds, ConsumedSeconds: 3000, RemainingSeconds: freeIncludedSeconds - 3000,
+	}, nil
+}
+
+func (m *memStore) PreviewUsageCurrent(ctx context.Context, userID string, freeIncludedSeconds int) (*model.UsageCurrent, error) {
+	return m.GetUsageCurrent(ctx, userID, freeIncludedSeconds)
+}
+
+func (m *memStore) ListRelayManifest(context.Context) ([]model.RelayManifestEntry, error) {
+	return []model.RelayManifestEntry{{Region: "us-east-1", AMIID: "ami-123", DefaultInstanceType: "t4g.small", Available: true, Enabled: true, UpdatedAt: time.Now()}}, nil
+}
+
+func (m *memStore) GetSessionTimers(_ context.Context, sessionID string) (*model.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, ok := m.sessions[sessionID]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	out := *sess
+	return &out, nil
+}
+
+func (m *memStore) RecordProvisionAttempt(context.Context, model.ProvisionAttempt) (int64, error) {
+	return 1, nil
+}
+
+func (m *memStore) SetProvisionAttemptCompensation(context.Context, int64, string) error {
+	return nil
+}
+
+func (m *memStore) RecordRelayHealth(_ context.Context, in store.RelayHealthInput) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[in.SessionID]; !ok {
+		return store.ErrRelayHealthRejected
+	}
+	m.health = append(m.health, in)
+	return nil
+}
+
+func testConfig() config.Config {
+	return config.Config{
+		JWTSecret:       "test-secret",
+		RelaySharedKey:  "relay-key",
+		DefaultRegion:   "us-east-1",
+		SupportedRegion: []string{"us-east-1", "eu-west-1"},
+
+		HTTPRequestTimeout: 5 * time.Second,
+		HTTPStartTimeout:   30 * time.Second,
+
+		UnavailableRetryAfter: time.Second,
+		PairTokenLength:       8,
+		FreeIncludedSeconds:   3600,
+		UsageAlertThresholds:  []int{80, 100},
+	}
+}
+
+func testJWT(t *testing.T, userID string) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"uid": userID, "exp": time.Now().Add(time.Hour).Unix()})
+	signed, err := tok.SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatalf("sign jwt: %v", err)
+	}
+	return signed
+}
+
+// newServer serves NewRouter; wrap, when set, sits in front of it.
+func newServer(t *testing.T, st *memStore, wrap func(http.Handler) http.Handler) *httptest.Server {
+	t.Helper()
+	var h http.Handler = api.NewRouter(testConfig(), st, relay.NewFakeProvisioner())
+	if wrap != nil {
+		h = wrap(h)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestContract_SessionLifecycle(t *testing.T) {
+	srv := newServer(t, newMemStore(), nil)
+	c := aegisclient.New(srv.URL, aegisclient.WithToken(testJWT(t, "usr_1")))
+	ctx := context.Background()
+
+	sess, err := c.StartRelay(ctx, aegisclient.StartOptions{RegionPreference: "eu-west-1", ClientContext: aegisclient.ClientContext{RequestedBy: "sdk-test", Tags: []string{"Friday-Show"}}})
+	if err != nil {
+		t.Fatalf("StartRelay: %v", err)
+	}
+	if sess.ID == "" || sess.Status != aegisclient.StatusActive || sess.Region != "eu-west-1" || sess.ProvisioningPhase != "ready" || len(sess.Tags) != 1 || sess.Tags[0] != "friday-show" || sess.Relay.PublicIP == "" || sess.Credentials.PairToken == "" {
+		t.Fatalf("unexpected started session %+v", sess)
+	}
+	if sess.StartedAt == nil || sess.ExpiresAt == nil || sess.Timers.MaxSessionSeconds != 57600 {
+		t.Fatalf("expected timers and timestamps, got %+v", sess)
+	}
+
+	active, err := c.ActiveSession(ctx)
+	if err != nil {
+		t.Fatalf("ActiveSession: %v", err)
+	}
+	if active.ID != sess.ID || active.UsageWarning == nil || active.UsageWarning.ThresholdPercent != 80 {
+		t.Fatalf("unexpected active session %+v", active)
+	}
+
+	stopped, err := c.StopRelay(ctx, sess.ID, "stream_ended")
+	if err != nil {
+		t.Fatalf("StopRelay: %v", err)
+	}
+	if stopped.SessionID != sess.ID || stopped.Status != aegisclient.StatusStopped || stopped.StoppedAt.IsZero() || stopped.Reason != "stream_ended" {
+		t.Fatalf("unexpected stop result %+v", stopped)
+	}
+	if _, err := c.ActiveSession(ctx); !errors.Is(err, aegisclient.ErrNoActiveSession) {
+		t.Fatalf("expected ErrNoActiveSession after stop, got %v", err)
+	}
+}
+
+func TestContract_StartRetryReusesIdempotencyKey(t *testing.T) {
+	st := newMemStore()
+	var mu sync.Mutex
+	var keys []string
+	// The first start reaches the API but its response is lost.
+	srv := newServer(t, st, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			keys = append(keys, r.Header.Get("Idempotency-Key"))
+			first := len(keys) == 1
+			mu.Unlock()
+			if first {
+				next.ServeHTTP(httptest.NewRecorder(), r)
+				http.Error(w, "upstream reset", http.StatusBadGateway)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	c := aegisclient.New(srv.URL, aegisclient.WithToken(testJWT(t, "usr_1")), aegisclient.WithRetries(2, time.Millisecond))
+
+	sess, err := c.StartRelay(context.Background(), aegisclient.StartOptions{})
+	if err != nil {
+		t.Fatalf("StartRelay: %v", err)
+	}
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Fatalf("expected one retry with the same Idempotency-Key, got %q", keys)
+	}
+	if len(st.sessions) != 1 || st.sessions[sess.ID] == nil {
+		t.Fatalf("expected the retry to return the one session, got %d sessions", len(st.sessions))
+	}
+}
+
+func TestContract_DecodesErrorEnvelope(t *testing.T) {
+	requests := 0
+	srv := newServer(t, newMemStore(), func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			next.ServeHTTP(w, r)
+		})
+	})
+	c := aegisclient.New(srv.URL, aegisclient.WithToken(testJWT(t, "usr_1")), aegisclient.WithRetries(3, time.Millisecond))
+
+	_, err := c.StopRelay(context.Background(), "ses_unknown", "")
+	var apiErr *aegisclient.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Code != "not_found" || apiErr.Message != "session not found" {
+		t.Fatalf("expected a not_found APIError, got %v", err)
+	}
+	if requests != 1 {
+		t.Fatalf("expected client errors not to be retried, got %d requests", requests)
+	}
+
+	_, err = aegisclient.New(srv.URL, aegisclient.WithToken("not-a-jwt")).Usage(context.Background())
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 APIError, got %v", err)
+	}
+}
+
+func TestContract_StartWhileProvisioningNamesTheSession(t *testing.T) {
+	st := newMemStore()
+	st.sessions["ses_first"] = &model.Session{ID: "ses_first", UserID: "usr_1", Status: model.SessionProvisioning, Region: "us-east-1"}
+	c := aegisclient.New(newServer(t, st, nil).URL, aegisclient.WithToken(testJWT(t, "usr_1")))
+
+	_, err := c.StartRelay(context.Background(), aegisclient.StartOptions{})
+	var apiErr *aegisclient.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict || apiErr.Code != "provisioning_in_progress" {
+		t.Fatalf("expected a provisioning_in_progress APIError, got %v", err)
+	}
+	if apiErr.SessionID != "ses_first" || apiErr.RetryAfter != 5*time.Second {
+		t.Fatalf("expected the provisioning session and a retry hint, got %+v", apiErr)
+	}
+}
+
+func TestContract_UsageManifestAndHealth(t *testing.T) {
+	st := newMemStore()
+	srv := newServer(t, st, nil)
+	c := aegisclient.New(srv.URL, aegisclient.WithToken(testJWT(t, "usr_1")), aegisclient.WithRelayKey("relay-key"))
+	ctx := context.Background()
+
+	usage, err := c.Usage(ctx)
+	if err != nil {
+		t.Fatalf("Usage: %v", err)
+	}
+	if usage.PlanTier != "free" || usage.IncludedSeconds != 3600 || usage.RemainingSeconds != 600 || len(usage.Alerts) != 1 || usage.Alerts[0].Level != "warning" {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+	if !usage.CycleStart.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected cycle start %s", usage.CycleStart)
+	}
+
+	regions, err := c.Manifest(ctx)
+	if err != nil {
+		t.Fatalf("Manifest: %v", err)
+	}
+	if len(regions) != 1 || regions[0].Region != "us-east-1" || !regions[0].Available {
+		t.Fatalf("unexpected manifest %+v", regions)
+	}
+
+	sess, err := c.StartRelay(ctx, aegisclient.StartOptions{})
+	if err != nil {
+		t.Fatalf("StartRelay: %v", err)
+	}
+	observedAt := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	reply, err := c.ReportHealth(ctx, aegisclient.Health{SessionID: sess.ID, IngestActive: true, SessionUptimeSeconds: 42, ObservedAt: observedAt})
+	if err != nil {
+		t.Fatalf("ReportHealth: %v", err)
+	}
+	// The free plan's 600 remaining seconds are below the session's cap.
+	if reply.RemainingAllowedSeconds == nil || *reply.RemainingAllowedSeconds != 600 {
+		t.Fatalf("unexpected health reply %+v", reply)
+	}
+	if len(st.health) != 1 || !st.health[0].ObservedAt.Equal(observedAt) || st.health[0].SessionUptimeSeconds != 42 {
+		t.Fatalf("unexpected recorded health %+v", st.health)
+	}
+
+	_, err = aegisclient.New(srv.URL, aegisclient.WithRelayKey("wrong")).ReportHealth(ctx, aegisclient.Health{SessionID: sess.ID})
+	var apiErr *aegisclient.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a bad relay key, got %v", err)
+	}
+}
+
+func TestRetries_StopAfterBudget(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		w.Header().Set("X-Request-Id", "req-42")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"error":{"code":"provider_unavailable","message":"relay provider is temporarily unavailable"}}`))
+	}))
+	defer srv.Close()
+
+	_, err := aegisclient.New(srv.URL, aegisclient.WithRetries(2, time.Millisecond)).Manifest(context.Background())
+	var apiErr *aegisclient.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "provider_unavailable" || apiErr.RequestID != "req-42" {
+		t.Fatalf("expected the last 503 as an APIError, got %v", err)
+	}
+	if requests != 3 {
+		t.Fatalf("expected 1 attempt and 2 retries, got %d", requests)
+	}
+}
+
+func TestRetries_RetriesTransportErrors(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	start := time.Now()
+	_, err := aegisclient.New(url, aegisclient.WithRetries(2, 10*time.Millisecond)).Manifest(context.Background())
+	if err == nil {
+		t.Fatalf("expected a connection error")
+	}
+	var apiErr *aegisclient.APIError
+	if errors.As(err, &apiErr) {
+		t.Fatalf("expected a transport error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatalf("expected two backed-off retries (10ms, 20ms), returned after %s", elapsed)
+	}
+}
@@ -0,0 +1,158 @@
+package aegisclient
+
+import "time"
+
+// Session statuses.
+const (
+	StatusProvisioning = "provisioning"
+	StatusActive       = "active"
+	StatusGrace        = "grace"
+	StatusStopping     = "stopping"
+	StatusStopped      = "stopped"
+)
+
+// ClientContext describes the caller of StartRelay, for the session record.
+type ClientContext struct {
+	OBSConnected bool   `json:"obs_connected"`
+	Mode         string `json:"mode"`
+	RequestedBy  string `json:"requested_by"`
+	// Tags label the session: at most 5, each up to 32 characters of a-z,
+	// 0-9, '.', '_' and '-'. The server lower-cases and sorts them.
+	Tags []string `json:"tags,omitempty"`
+}
+
+type StartOptions struct {
+	RegionPreference string
+	ClientContext    ClientContext
+	// StaticIP requests a relay with a stable public IP.
+	StaticIP bool
+	// IdempotencyKey is a version 4 UUID identifying the start. Callers
+	// retrying a start themselves (e.g. after a restart) pass the same key;
+	// empty generates one per call.
+	IdempotencyKey string
+}
+
+type startRequest struct {
+	RegionPreference string        `json:"region_preference"`
+	ClientContext    ClientContext `json:"client_context"`
+	StaticIP         bool          `json:"static_ip,omitempty"`
+}
+
+type Session struct {
+	ID              string             `json:"session_id"`
+	Status          string             `json:"status"`
+	Region          string             `json:"region"`
+	Relay           SessionRelay       `json:"relay"`
+	Credentials     SessionCredentials `json:"credentials"`
+	Timers          SessionTimers      `json:"timers"`
+	DurationSeconds int                `json:"duration_seconds"`
+	StartedAt       *time.Time         `json:"started_at,omitempty"`
+	ExpiresAt       *time.Time         `json:"expires_at,omitempty"`
+	StoppedAt       *time.Time         `json:"stopped_at,omitempty"`
+	GraceDeadline   *time.Time         `json:"grace_deadline,omitempty"`
+	// UsageWarning is set on ActiveSession once the user crossed a usage
+	// threshold.
+	UsageWarning *UsageWarning `json:"usage_warning,omitempty"`
+	// ProvisioningPhase is how far the relay's start has got: requested,
+	// launching, waiting_boot or ready. Empty for older sessions.
+	ProvisioningPhase string `json:"provisioning_phase,omitempty"`
+	// Tags are the labels the session was started with.
+	Tags []string `json:"tags,omitempty"`
+}
+
+type SessionRelay struct {
+	PublicIP   string `json:"public_ip"`
+	PublicIPv6 string `json:"public_ipv6"`
+	SRTPort    int    `json:"srt_port"`
+	// SRTPorts are every SRT listener of the relay, SRTPort first.
+	SRTPorts []int  `json:"srt_ports"`
+	WSURL    string `json:"ws_url"`
+}
+
+// SessionCredentials are masked (Masked set, last two characters only) when
+// the server keeps them out of ActiveSession.
+type SessionCredentials struct {
+	PairToken    string `json:"pair_token"`
+	RelayWSToken string `json:"relay_ws_token"`
+	Masked       bool   `json:"masked,omitempty"`
+}
+
+type SessionTimers struct {
+	GraceWindowSeconds int `json:"grace_window_seconds"`
+	MaxSessionSeconds  int `json:"max_session_seconds"`
+}
+
+type UsageWarning struct {
+	ThresholdPercent int    `json:"threshold_percent"`
+	Level            string `json:"level"`
+	RemainingSeconds int    `json:"remaining_seconds"`
+}
+
+type stopRequest struct {
+	SessionID string `json:"session_id"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type StopResult struct {
+	SessionID string    `json:"session_id"`
+	Status    string    `json:"status"`
+	StoppedAt time.Time `json:"stopped_at"`
+	// Reason is the normalized stop reason; empty for admin stops.
+	Reason string `json:"reason,omitempty"`
+}
+
+type Usage struct {
+	PlanTier         string       `json:"plan_tier"`
+	CycleStart       time.Time    `json:"cycle_start"`
+	CycleEnd         time.Time    `json:"cycle_end"`
+	IncludedSeconds  int          `json:"included_seconds"`
+	ConsumedSeconds  int          `json:"consumed_seconds"`
+	RemainingSeconds int          `json:"remaining_seconds"`
+	OverageSeconds   int          `json:"overage_seconds"`
+	Alerts           []UsageAlert `json:"usage_alerts"`
+}
+
+type UsageAlert struct {
+	ThresholdPercent int    `json:"threshold_percent"`
+	Level            string `json:"level"`
+}
+
+type Region struct {
+	Region              string    `json:"region"`
+	AMIID               string    `json:"ami_id"`
+	DefaultInstanceType string    `json:"default_instance_type"`
+	Available           bool      `json:"available"`
+	Enabled             bool      `json:"enabled"`
+	UpdatedAt           time.Time `json:"updated_at"`
+	AMIParameter        string    `json:"ami_parameter,omitempty"`
+	Provider            string    `json:"provider,omitempty"`
+}
+
+// Health is a relay heartbeat.
+type Health struct {
+	SessionID            string
+	InstanceID           string
+	IngestActive         bool
+	EgressActive         bool
+	SessionUptimeSeconds int
+	ObservedAt           time.Time
+}
+
+// HealthReply is the API's answer to a heartbeat.
+type HealthReply struct {
+	// RemainingAllowedSeconds is how much longer the relay may forward,
+	// counting from the reply: the lower of the user's plan allowance and
+	// the session's maximum duration, or -1 when neither applies. The relay
+	// stops ingest at zero. Nil when the API could not work it out; keep
+	// counting down from the last value.
+	RemainingAllowedSeconds *int `json:"remaining_allowed_seconds"`
+}
+
+type healthRequest struct {
+	SessionID            string `json:"session_id"`
+	InstanceID           string `json:"instance_id"`
+	IngestActive         bool   `json:"ingest_active"`
+	EgressActive         bool   `json:"egress_active"`
+	SessionUptimeSeconds int    `json:"session_uptime_seconds"`
+	ObservedAt           string `json:"observed_at,omitempty"`
+}